@@ -2,9 +2,11 @@ package aof
 
 import (
 	"context"
+	"errors"
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,6 +17,8 @@ import (
 	"github.com/zhangming/go-redis/config"
 	"github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/interfaces/redis/parser"
+	"github.com/zhangming/go-redis/lib/sync/atomic"
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
 	"github.com/zhangming/go-redis/lib/utils"
 	"github.com/zhangming/go-redis/redis/connection"
 	"github.com/zhangming/go-redis/redis/protocol"
@@ -41,6 +45,8 @@ type payload struct {
 	// 记录数据库序号
 	dbIndex int
 	wg      *sync.WaitGroup
+	// 进入 aof 的顺序编号
+	seq int64
 }
 
 // Listener will be called-back after receiving a aof payload
@@ -56,6 +62,8 @@ type Persister struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	db     database.DBEngine
+	// 所属实例的配置
+	cfg *config.ServerProperties
 	// 创建临时数据库实例的函数。
 	tmpDBMaker func() database.DBEngine
 	// aofChan is the channel to receive aof payload(listenCmd will send payload to this channel)
@@ -71,6 +79,8 @@ type Persister struct {
 	// aof goroutine will send msg to main goroutine through this channel when aof tasks finished and ready to shut down
 	//用于通知主线程 AOF 模块已经完成清理并退出。
 	aofFinished chan struct{}
+	// closing 为 true 后不再接收新的命令
+	closing atomic.Boolean
 	// pause aof for start/finish aof rewrite progress
 	//互斥锁，用于在 AOF 重写（rewrite）期间暂停正常的 AOF 写入。
 	pausingAof sync.Mutex
@@ -79,56 +89,139 @@ type Persister struct {
 	listeners map[Listener]struct{}
 	// reuse cmdLine buffer
 	buffer []CmdLine
+	// 加载 aof 的进度
+	progress LoadProgress
+	// 后台加载结束后关闭，Close 需要等待加载结束才能关闭 aofChan
+	loadDone chan struct{}
+	// 写入失败的次数和错误
+	writeStatus writeStatus
+	// 合并命令的窗口，0 表示不合并
+	coalesceWindow time.Duration
+	coalesceStats  coalesceStats
+	// 写入和落盘的命令编号
+	watermark syncWatermark
+	// 多文件 aof 的目录和清单，此时 aofFilename 是正在追加的 incr 文件；单个 aof 文件时 manifest 为 nil
+	aofDir   string
+	manifest *Manifest
+	// 同一时刻只进行一次重写
+	rewriting sync.Mutex
 }
 
 func NewPersister(db database.DBEngine, filename string, load bool, fsync string, tmpDBMaker func() database.DBEngine) (*Persister, error) {
+	return NewPersisterWithConfig(config.Properties, db, filename, load, fsync, tmpDBMaker)
+}
+
+// NewPersisterWithConfig creates a persister bound to the given instance config
+func NewPersisterWithConfig(cfg *config.ServerProperties, db database.DBEngine, filename string, load bool, fsync string, tmpDBMaker func() database.DBEngine) (*Persister, error) {
 	persister := &Persister{}
+	if err := persister.init(cfg, db, filename, load, fsync, tmpDBMaker); err != nil {
+		return nil, err
+	}
+	return persister, nil
+}
+
+// NewMultiPartPersister creates a persister keeping aof in cfg.AppendDirPath() as a base file and incr files,
+// an existing single aof file is moved into the dir as the base file
+func NewMultiPartPersister(cfg *config.ServerProperties, db database.DBEngine, load bool, tmpDBMaker func() database.DBEngine) (*Persister, error) {
+	dir := cfg.AppendDirPath()
+	manifest, err := openManifest(dir, cfg.AppendFilename, cfg.AppendFilePath())
+	if err != nil {
+		return nil, err
+	}
+	incr, _ := manifest.lastIncr()
+	persister := &Persister{aofDir: dir, manifest: manifest}
+	if err := persister.init(cfg, db, filepath.Join(dir, incr.Name), load, cfg.AppendFsync, tmpDBMaker); err != nil {
+		return nil, err
+	}
+	return persister, nil
+}
+
+func (persister *Persister) init(cfg *config.ServerProperties, db database.DBEngine, filename string, load bool, fsync string, tmpDBMaker func() database.DBEngine) error {
+	persister.cfg = cfg
 	persister.db = db
 	persister.tmpDBMaker = tmpDBMaker
 	persister.aofFilename = filename
 	persister.aofFsync = strings.ToLower(fsync)
 	persister.currentDB = 0
+	if cfg.AofCoalesceWindow > 0 && persister.aofFsync != FsyncAlways {
+		persister.coalesceWindow = time.Duration(cfg.AofCoalesceWindow) * time.Millisecond
+	}
 	if load {
 		// 这一行调用 LoadAof(0) 的作用是 从 AOF 文件中加载持久化的命令数据到内存数据库中，通常在 Redis 启动时执行
 		// 这是为了恢复上次关闭服务前保存的数据状态，确保重启后数据不会丢失（前提是开启了 AOF 持久化）
-		persister.LoadAof(0)
+		persister.LoadWithProgress()
 	}
-	// os.O_APPEND	写入时始终追加到文件末尾
-	// os.O_CREATE	如果文件不存在，则创建它
-	// os.O_RDWR	以读写模式打开文件
-	// 0600	文件权限：所有者可读写，其他用户无权限
-	aofFile, err := os.OpenFile(persister.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	aofFile, err := openAofFile(persister.aofFilename)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	persister.aofFile = aofFile
 	persister.aofChan = make(chan *payload, aofQueueSize)
 	persister.aofFinished = make(chan struct{})
 	persister.listeners = make(map[Listener]struct{})
-	go func() {
-		persister.listenCmd()
-	}()
+	persister.watermark.init()
+	// 后台加载时 LoadAof 会暂时把 aofChan 置为 nil，所以这里直接传入 channel
+	go func(aofChan chan *payload) {
+		persister.listenCmd(aofChan)
+	}(persister.aofChan)
 	ctx, cancel := context.WithCancel(context.Background())
 	persister.cancel = cancel
 	persister.ctx = ctx
 	if persister.aofFsync == FsyncEverySec {
 		persister.fsyncEverySecond()
 	}
-	return persister, nil
+	persister.syncOnRequest()
+	return nil
+}
+
+// openAofFile 打开用于追加的 aof 文件，新文件以版本标记开头
+func openAofFile(filename string) (*os.File, error) {
+	// os.O_APPEND	写入时始终追加到文件末尾
+	// os.O_CREATE	如果文件不存在，则创建它
+	// os.O_RDWR	以读写模式打开文件
+	// 0600	文件权限：所有者可读写，其他用户无权限
+	aofFile, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := aofFile.Stat(); err == nil && info.Size() == 0 {
+		if err := writeFormatCmd(aofFile); err != nil {
+			_ = aofFile.Close()
+			return nil, err
+		}
+	}
+	return aofFile, nil
+}
+
+var pausingAofClass = lockorder.NewClass("aof.pausing")
+
+func (persister *Persister) lockAof() {
+	pausingAofClass.Acquire(0)
+	persister.pausingAof.Lock()
+}
 
+func (persister *Persister) unlockAof() {
+	persister.pausingAof.Unlock()
+	pausingAofClass.Release(0)
 }
 
 func (persister *Persister) RemoveListener(Listener Listener) {
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
+	persister.lockAof()
+	defer persister.unlockAof()
 	delete(persister.listeners, Listener)
 }
 
 // listenCmd listen aof channel and write into file
-func (persister *Persister) listenCmd() {
-	for p := range persister.aofChan {
+func (persister *Persister) listenCmd(aofChan chan *payload) {
+	if persister.coalesceWindow > 0 {
+		persister.listenCoalesced(aofChan, persister.coalesceWindow)
+		persister.aofFinished <- struct{}{}
+		return
+	}
+	for p := range aofChan {
 		// 这里写入了
 		persister.writeAof(p)
+		persister.watermark.markWritten(p.seq)
 	}
 	persister.aofFinished <- struct{}{}
 }
@@ -137,18 +230,28 @@ func (persister *Persister) SaveCmdLine(db int, cmdLine CmdLine) {
 	if persister.aofChan == nil {
 		return
 	}
+	if persister.closing.Get() {
+		slog.Warn("aof is closing, drop command", "cmd", string(cmdLine[0]))
+		return
+	}
+	w := &persister.watermark
+	w.enqueueMu.Lock()
+	defer w.enqueueMu.Unlock()
 	// FsyncAlways 需要立即写入磁盘，不能等待后台异步处理。
 	if persister.aofFsync == FsyncAlways {
 		p := &payload{
 			dbIndex: db,
 			cmdLine: cmdLine,
+			seq:     w.queued.Add(1),
 		}
 		persister.writeAof(p)
+		w.markWritten(p.seq)
 		return
 	}
 	persister.aofChan <- &payload{
 		cmdLine: cmdLine,
 		dbIndex: db,
+		seq:     w.queued.Add(1),
 	}
 }
 
@@ -157,16 +260,14 @@ func (persister *Persister) writeAof(p *payload) {
 
 	// 减少频繁创建和释放内存带来的性能损耗。
 	persister.buffer = persister.buffer[:0] // reuse underlying array
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
+	persister.lockAof()
+	defer persister.unlockAof()
 	if persister.currentDB != p.dbIndex {
 		//查找数据库
 		selectCmd := utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))
 		persister.buffer = append(persister.buffer, selectCmd)
 		data := protocol.MakeMultiBulkReply(selectCmd).ToBytes()
-		_, err := persister.aofFile.Write(data)
-		if err != nil {
-			slog.Error("err ", err)
+		if !persister.writeData(data, p.seq) {
 			return // skip this command
 		}
 		persister.currentDB = p.dbIndex
@@ -174,61 +275,148 @@ func (persister *Persister) writeAof(p *payload) {
 	//执行写入
 	data := protocol.MakeMultiBulkReply(p.cmdLine).ToBytes()
 	persister.buffer = append(persister.buffer, p.cmdLine)
-	_, err := persister.aofFile.Write(data)
-	if err != nil {
-		slog.Error("err", err)
+	if !persister.writeData(data, p.seq) {
+		// 没有写入 aof 的命令也不转发给从节点
+		return
 	}
 	for listener := range persister.listeners {
 		listener.Callback(persister.buffer)
 	}
 	if persister.aofFsync == FsyncAlways {
 		// /调用该方法会将文件缓冲区中的数据 强制刷新到磁盘，确保数据不会因为程序崩溃而丢失。
-		_ = persister.aofFile.Sync()
+		if persister.syncData(p.seq) {
+			persister.watermark.markSynced(p.seq)
+		}
+		persister.watermark.notify()
 	}
 }
 
+// LoadAof replays the first maxBytes bytes of aof into db, maxBytes <= 0 means the whole aof
 func (persister *Persister) LoadAof(maxBytes int) {
+	snapshot, err := persister.openSnapshot(int64(maxBytes))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Error("load aof error", "error", err)
+		}
+		return
+	}
+	persister.loadSnapshot(snapshot)
+}
+
+// aofSnapshot 某一时刻的 aof 内容: 依次打开的文件以及每个文件读取的字节数
+// 文件在确定快照时打开，之后的重写删除或者替换文件不影响读取
+type aofSnapshot struct {
+	files []*os.File
+	sizes []int64
+}
+
+func (s *aofSnapshot) totalSize() int64 {
+	var total int64
+	for _, size := range s.sizes {
+		total += size
+	}
+	return total
+}
+
+func (s *aofSnapshot) close() {
+	for _, file := range s.files {
+		_ = file.Close()
+	}
+}
+
+// aofPaths 按加载顺序返回 aof 文件
+func (persister *Persister) aofPaths() []string {
+	if persister.manifest != nil {
+		return persister.manifest.Paths(persister.aofDir)
+	}
+	return []string{persister.aofFilename}
+}
+
+// openSnapshot 打开当前的 aof 文件，总共读取 maxBytes 字节，maxBytes <= 0 表示读取全部
+// 调用方需要保证期间文件不会被重写替换，例如持有 pausingAof
+func (persister *Persister) openSnapshot(maxBytes int64) (*aofSnapshot, error) {
+	snapshot := &aofSnapshot{}
+	for _, filename := range persister.aofPaths() {
+		if maxBytes > 0 && snapshot.totalSize() >= maxBytes {
+			break
+		}
+		file, err := os.Open(filename)
+		if err != nil {
+			snapshot.close()
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			_ = file.Close()
+			snapshot.close()
+			return nil, err
+		}
+		size := info.Size()
+		if maxBytes > 0 {
+			size = min(size, maxBytes-snapshot.totalSize())
+		}
+		snapshot.files = append(snapshot.files, file)
+		snapshot.sizes = append(snapshot.sizes, size)
+	}
+	return snapshot, nil
+}
+
+// loadSnapshot 依次重放快照中的文件，结束后关闭文件
+func (persister *Persister) loadSnapshot(snapshot *aofSnapshot) {
+	defer snapshot.close()
 	aofChan := persister.aofChan
 	persister.aofChan = nil
 	defer func(aofChan chan *payload) {
 		persister.aofChan = aofChan
 	}(aofChan)
 
-	file, err := os.Open(persister.aofFilename)
-	if err != nil {
-		if _, ok := err.(*os.PathError); ok {
+	fakeConn := connection.NewFakeConn() // only used for save dbIndex
+	persister.progress.start(fakeConn, snapshot.totalSize())
+	defer persister.progress.finish()
+	for i, file := range snapshot.files {
+		if !persister.loadFile(fakeConn, file, snapshot.sizes[i]) {
 			return
 		}
-		slog.Error("load aof error", err)
-		return
 	}
-	defer file.Close()
+}
+
+// loadFile 重放文件的前 size 字节，返回 false 表示需要停止加载
+func (persister *Persister) loadFile(fakeConn *connection.FakeConn, file *os.File, size int64) bool {
+	// 更新的引擎写入的文件可能包含不认识的数据，拒绝加载
+	version, err := formatVersion(io.NewSectionReader(file, 0, size))
+	if err == nil {
+		err = checkVersion(file.Name(), version)
+	}
+	if errors.Is(err, ErrNewerFormat) {
+		slog.Error("refuse to load aof: " + err.Error())
+		return false
+	}
+	reader := io.NewSectionReader(file, 0, size)
 	// load rdb preamble if needed
-	decoder := rdb.NewDecoder(file)
+	decoder := rdb.NewDecoder(reader)
 	err = persister.db.LoadRDB(decoder)
 	if err != nil {
 		// no rdb preamble
-		file.Seek(0, io.SeekStart)
+		_, _ = reader.Seek(0, io.SeekStart)
 	} else {
 		// has rdb preamble
-		_, _ = file.Seek(int64(decoder.GetReadCount())+1, io.SeekStart)
-		maxBytes = maxBytes - decoder.GetReadCount()
+		_, _ = reader.Seek(int64(decoder.GetReadCount()), io.SeekStart)
+		persister.progress.loadedBytes.Add(int64(decoder.GetReadCount()))
 	}
-	var reader io.Reader
-	if maxBytes > 0 {
-		reader = io.LimitReader(file, int64(maxBytes))
-	} else {
-		reader = file
-	}
-	ch := parser.ParseStream(reader)
-	fakeConn := connection.NewFakeConn() // only used for save dbIndex
+	ch := parser.ParseStream(&countingReader{reader: reader, progress: &persister.progress})
 	for p := range ch {
+		if persister.closing.Get() {
+			// 后台加载时实例正在关闭
+			return false
+		}
 		if p.Err != nil {
 			if p.Err == io.EOF {
 				break
 			}
-			slog.Error("parse error: " + p.Err.Error())
-			continue
+			// 启动时 PrepareLoad 已经检查过文件，这里出错说明文件在检查之后被修改，
+			// 跳过出错的数据继续执行会把后面的命令应用在错误的状态上，停止加载
+			slog.Error("parse error, stop loading aof: " + p.Err.Error())
+			return false
 		}
 		if p.Data == nil {
 			slog.Error("empty payload")
@@ -239,43 +427,66 @@ func (persister *Persister) LoadAof(maxBytes int) {
 			slog.Error("require multi bulk protocol")
 			continue
 		}
+		if isFormatCmd(r.Args) {
+			continue
+		}
 		ret := persister.db.Exec(fakeConn, r.Args)
 		if protocol.IsErrorReply(ret) {
 			slog.Error("exec err", string(ret.ToBytes()))
 		}
+		persister.progress.commands.Add(1)
 		if strings.ToLower(string(r.Args[0])) == "select" {
 			// execSelect success, here must be no error
 			dbIndex, err := strconv.Atoi(string(r.Args[1]))
 			if err == nil {
 				persister.currentDB = dbIndex
+				persister.progress.currentDB.Store(int64(dbIndex))
 			}
 		}
 	}
+	return true
 }
 
-// 手动刷盘
+// 手动刷盘，成功后推进落盘的命令编号
 func (persister *Persister) Fsync() {
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
-	if err := persister.aofFile.Sync(); err != nil {
-		slog.Error("aof sync error", "error", err)
+	persister.lockAof()
+	written := persister.watermark.written.Load()
+	ok := persister.syncData(written)
+	persister.unlockAof()
+	if ok {
+		persister.watermark.markSynced(written)
 	}
+	persister.watermark.notify()
 }
 
+// Close 按顺序关闭：停止接收命令 -> 等待 aofChan 中的命令全部写入 -> fsync -> 关闭文件
+// 调用方需要保证此时已经不会再有新的写命令
 func (persister *Persister) Close() {
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
 	// aofFile 是指向 AOF 日志文件的指针，如果它为 nil，说明 AOF 持久化功能未被启用或尚未初始化。
 	// 因此，通过判断 aofFile != nil 可以避免对未初始化的对象执行操作（如关闭通道、关闭文件等），防止空指针 panic。
-	if persister.aofFile != nil {
-		close(persister.aofChan)
-		<-persister.aofFinished
-		err := persister.aofFile.Close()
-		if err != nil {
-			slog.Error("aof close error", "error", err)
-		}
+	if persister.aofFile == nil {
+		return
 	}
+	persister.closing.Set(true)
+	if persister.loadDone != nil {
+		<-persister.loadDone
+	}
+	// listenCmd 写入时需要 pausingAof，所以必须在加锁之前等待它把缓冲的命令写完
+	close(persister.aofChan)
+	<-persister.aofFinished
 	persister.cancel()
+
+	persister.lockAof()
+	defer persister.unlockAof()
+	if err := persister.aofFile.Sync(); err != nil {
+		slog.Error("aof sync error", "error", err)
+	} else {
+		persister.watermark.markSynced(persister.watermark.written.Load())
+	}
+	err := persister.aofFile.Close()
+	if err != nil {
+		slog.Error("aof close error", "error", err)
+	}
 }
 
 // fsyncEverySecond fsync aof file every second
@@ -296,8 +507,11 @@ func (persister *Persister) fsyncEverySecond() {
 func (persister *Persister) generateAof(ctx *RewriteCtx) error {
 	tmpFile := ctx.tmpFile
 	tmpAof := persister.newRewriteHandler()
-	tmpAof.LoadAof(int(ctx.fileSize))
-	for i := 0; i < config.Properties.Databases; i++ {
+	tmpAof.loadSnapshot(ctx.snapshot)
+	if err := writeFormatCmd(tmpFile); err != nil {
+		return err
+	}
+	for i := 0; i < persister.cfg.Databases; i++ {
 		// 选择数据库
 		data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(i))).ToBytes()
 		_, err := tmpFile.Write(data)
@@ -306,9 +520,9 @@ func (persister *Persister) generateAof(ctx *RewriteCtx) error {
 		}
 		// 循环写入每个键值对是为了完整重建数据库状态
 		// 重写 AOF 时需要将当前数据库中的每一个 key-value 对转换为等价的 Redis 命令（如 SET, HSET, SADD 等），并逐条写入到临时 AOF 文件中。
-		persister.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
-			cmd := EntityToCmd(key, entity)
-			if cmd != nil {
+		// 遍历重放出来的临时数据库，而不是线上数据库，否则重写开始之后的命令会在追加 aof 尾部时重复执行
+		tmpAof.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			for _, cmd := range EntityToCmds(key, entity) {
 				_, _ = tmpFile.Write(cmd.ToBytes())
 			}
 			if expiration != nil {
@@ -0,0 +1,155 @@
+package aof
+
+import (
+	"encoding/binary"
+	"hash"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/zhangming/go-redis/interfaces/database"
+)
+
+// 确定性的 rdb 输出
+// 开启 rdb-deterministic 后按 key 的字典序写入，集合成员排序，并且不写 ctime，
+// 相同的数据生成的 rdb 字节完全相同，可以直接比较文件来去重备份或者做 golden file 测试。
+// rdb 库编码 hash 时遍历 map，字段的顺序不确定，所以 hash 由 canonicalWriter 按字段排序后直接编码。
+// rdb 库也没有消息流的编码器，消息流同样由 canonicalWriter 直接编码(见 stream.go)，
+// 因此无论是否开启确定性输出，文件末尾的 crc64 都由 canonicalWriter 计算
+
+const (
+	rdbOpCodeResizeDB     = 251
+	rdbOpCodeExpireTimeMs = 252
+	rdbOpCodeSelectDB     = 254
+	rdbOpCodeEOF          = 255
+	rdbTypeHash           = 4
+)
+
+// canonicalWriter 位于编码器和输出之间，统计写入的所有字节的校验和
+type canonicalWriter struct {
+	w   io.Writer
+	crc hash.Hash64
+	// 编码器写了 db header 之后还没有写过对象。编码器不允许连续写两个 db header，
+	// 这时下一个 db header 由 canonicalWriter 直接写入
+	headerOnly bool
+}
+
+func newCanonicalWriter(w io.Writer) *canonicalWriter {
+	return &canonicalWriter{
+		w:   w,
+		crc: crc64jones.New(),
+	}
+}
+
+func (cw *canonicalWriter) Write(p []byte) (int, error) {
+	_, _ = cw.crc.Write(p)
+	return cw.w.Write(p)
+}
+
+func (cw *canonicalWriter) writeLength(value uint64) error {
+	var buf []byte
+	if value < 1<<6 {
+		buf = []byte{byte(value)}
+	} else if value < 1<<14 {
+		buf = []byte{byte(value>>8) | 0x40, byte(value)}
+	} else if value <= math.MaxUint32 {
+		buf = make([]byte, 5)
+		buf[0] = 0x80
+		binary.BigEndian.PutUint32(buf[1:], uint32(value))
+	} else {
+		buf = make([]byte, 9)
+		buf[0] = 0x81
+		binary.BigEndian.PutUint64(buf[1:], value)
+	}
+	_, err := cw.Write(buf)
+	return err
+}
+
+func (cw *canonicalWriter) writeString(s []byte) error {
+	if err := cw.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	_, err := cw.Write(s)
+	return err
+}
+
+func (cw *canonicalWriter) writeDBHeader(dbIndex int, keyCount, ttlCount int) error {
+	if _, err := cw.Write([]byte{rdbOpCodeSelectDB}); err != nil {
+		return err
+	}
+	if err := cw.writeLength(uint64(dbIndex)); err != nil {
+		return err
+	}
+	if _, err := cw.Write([]byte{rdbOpCodeResizeDB}); err != nil {
+		return err
+	}
+	if err := cw.writeLength(uint64(keyCount)); err != nil {
+		return err
+	}
+	return cw.writeLength(uint64(ttlCount))
+}
+
+// writeHash 按字段排序，使用普通的 hash 编码
+func (cw *canonicalWriter) writeHash(key string, hash map[string][]byte, expiration *time.Time) error {
+	if expiration != nil {
+		buf := make([]byte, 9)
+		buf[0] = rdbOpCodeExpireTimeMs
+		binary.LittleEndian.PutUint64(buf[1:], uint64(expiration.UnixNano()/1e6))
+		if _, err := cw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if _, err := cw.Write([]byte{rdbTypeHash}); err != nil {
+		return err
+	}
+	if err := cw.writeString([]byte(key)); err != nil {
+		return err
+	}
+	if err := cw.writeLength(uint64(len(hash))); err != nil {
+		return err
+	}
+	fields := make([]string, 0, len(hash))
+	for field := range hash {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if err := cw.writeString([]byte(field)); err != nil {
+			return err
+		}
+		if err := cw.writeString(hash[field]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEnd 写入 EOF 和整个文件的校验和
+func (cw *canonicalWriter) writeEnd() error {
+	if _, err := cw.Write([]byte{rdbOpCodeEOF}); err != nil {
+		return err
+	}
+	_, err := cw.w.Write(cw.crc.Sum(nil))
+	return err
+}
+
+type rdbEntry struct {
+	key        string
+	entity     *database.DataEntity
+	expiration *time.Time
+}
+
+// sortedEntries 返回按 key 排序的数据
+func sortedEntries(db RDBSource, dbIndex int) []rdbEntry {
+	var entries []rdbEntry
+	db.ForEach(dbIndex, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+		entries = append(entries, rdbEntry{key: key, entity: entity, expiration: expiration})
+		return true
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].key < entries[j].key
+	})
+	return entries
+}
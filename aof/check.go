@@ -0,0 +1,184 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+
+	rdb "github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+)
+
+// aof 完整性检查
+// 进程崩溃或者磁盘写满时 aof 的最后一条命令可能只写了一半，按照 aof-load-truncated:
+//   - yes(默认): 截断到最后一条完整的命令，打印警告后继续加载
+//   - no: 拒绝启动
+//
+// 文件中间的数据损坏无论哪种模式都拒绝启动，需要用 go-redis check-aof -fix 离线修复，
+// 修复同样截断到损坏之前最后一条完整的命令，之后的数据全部丢弃
+
+// ErrAofTruncated means the aof ends in the middle of a command
+var ErrAofTruncated = errors.New("aof is truncated")
+
+// ErrAofCorrupted means the aof contains malformed data before its end
+var ErrAofCorrupted = errors.New("aof is corrupted")
+
+// CheckResult describes the integrity of an aof file
+type CheckResult struct {
+	// Size 文件的字节数
+	Size int64
+	// ValidSize 从文件开头到最后一条完整命令结尾的字节数
+	ValidSize int64
+	// Commands 完整的命令数，不包括 rdb 前缀
+	Commands int
+	// Err 为 nil 表示文件完整，否则包装 ErrAofTruncated 或 ErrAofCorrupted
+	Err error
+}
+
+// CheckAof scans the whole aof file and reports where the last complete command ends
+func CheckAof(filename string) (*CheckResult, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	result := &CheckResult{Size: info.Size()}
+	reader := bufio.NewReader(file)
+	if head, _ := reader.Peek(5); bytes.Equal(head, []byte("REDIS")) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		dec := rdb.NewDecoder(file)
+		if err := dec.Parse(func(o model.RedisObject) bool { return true }); err != nil {
+			result.Err = fmt.Errorf("%w: bad rdb preamble: %v", ErrAofCorrupted, err)
+			return result, nil
+		}
+		result.ValidSize = int64(dec.GetReadCount())
+		if _, err := file.Seek(result.ValidSize, io.SeekStart); err != nil {
+			return nil, err
+		}
+		reader.Reset(file)
+	}
+	for {
+		n, err := skipCommand(reader)
+		if err == io.EOF && n == 0 {
+			return result, nil
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				result.Err = fmt.Errorf("%w: incomplete command at offset %d", ErrAofTruncated, result.ValidSize)
+			} else {
+				result.Err = fmt.Errorf("%w at offset %d: %v", ErrAofCorrupted, result.ValidSize, err)
+			}
+			return result, nil
+		}
+		result.ValidSize += n
+		result.Commands++
+	}
+}
+
+// skipCommand 读取一条 *<n>\r\n($<len>\r\n<data>\r\n)*n 格式的命令，返回读取的字节数
+func skipCommand(reader *bufio.Reader) (int64, error) {
+	var read int64
+	readLine := func(prefix byte) (int64, error) {
+		line, err := reader.ReadSlice('\n')
+		read += int64(len(line))
+		if err != nil {
+			if err == io.EOF && read > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if len(line) < 3 || line[0] != prefix || line[len(line)-2] != '\r' {
+			return 0, fmt.Errorf("expected '%c' line, got %q", prefix, line)
+		}
+		n, err := strconv.ParseInt(string(line[1:len(line)-2]), 10, 64)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("bad length %q", line)
+		}
+		return n, nil
+	}
+	argc, err := readLine('*')
+	if err != nil {
+		return read, err
+	}
+	if argc == 0 {
+		return read, errors.New("empty command")
+	}
+	for i := int64(0); i < argc; i++ {
+		size, err := readLine('$')
+		if err != nil {
+			return read, unexpectedEOF(err)
+		}
+		discarded, err := reader.Discard(int(size))
+		read += int64(discarded)
+		if err != nil {
+			return read, unexpectedEOF(err)
+		}
+		var crlf [2]byte
+		got, err := io.ReadFull(reader, crlf[:])
+		read += int64(got)
+		if err != nil {
+			return read, unexpectedEOF(err)
+		}
+		if crlf != [2]byte{'\r', '\n'} {
+			return read, errors.New("bulk string does not end with CRLF")
+		}
+	}
+	return read, nil
+}
+
+// unexpectedEOF 命令开始之后遇到文件结尾
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// FixAof truncates the aof file after the last complete command,
+// returns the result of the check before truncating
+func FixAof(filename string) (*CheckResult, error) {
+	result, err := CheckAof(filename)
+	if err != nil || result.Err == nil {
+		return result, err
+	}
+	if err := os.Truncate(filename, result.ValidSize); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// PrepareLoad checks the aof file before loading it at startup.
+// A truncated tail is cut off when allowTruncated is true, other problems are returned as errors
+func PrepareLoad(filename string, allowTruncated bool) error {
+	result, err := CheckAof(filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if result.Err == nil {
+		return nil
+	}
+	if !allowTruncated || !errors.Is(result.Err, ErrAofTruncated) {
+		return fmt.Errorf("%s: %w; run 'go-redis check-aof -fix %s' to truncate it after the last complete command",
+			filename, result.Err, filename)
+	}
+	if err := os.Truncate(filename, result.ValidSize); err != nil {
+		return err
+	}
+	slog.Warn("aof is truncated, discarded the incomplete command at the end",
+		"file", filename, "size", result.Size, "truncated_to", result.ValidSize)
+	return nil
+}
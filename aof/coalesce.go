@@ -0,0 +1,214 @@
+package aof
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/lib/utils"
+)
+
+// aof 命令合并
+// 开启 aof-coalesce-window 后，后台协程把一个时间窗口内的命令暂存起来，同一个 key 上的连续写入合并成最终状态再追加：
+// 多次 INCR/INCRBY/DECR/DECRBY 合并成一条 INCRBY，之后的 SET 覆盖之前的 SET 和 INCR，SET 之后的 INCR 直接算出新的值。
+// 只合并这几种只影响自己的 key 的命令，其他命令是屏障，屏障之后的命令不会合并到屏障之前，
+// 所以合并只改变同一个 key 的写入粒度，不会改变不同 key 之间的先后关系。
+// 代价是重放 aof 时只能恢复到合并之后的状态，崩溃时最多丢失一个窗口内的命令，从节点也会晚一个窗口收到这些写入。
+// appendfsync always 时每条命令都要立即落盘，不合并
+
+// aofCoalesceMaxPending 暂存的命令数达到上限时立即写入，限制占用的内存
+const aofCoalesceMaxPending = 1 << 16
+
+type coalesceKey struct {
+	dbIndex int
+	key     string
+}
+
+// coalesceStats 记录合并掉的命令数
+type coalesceStats struct {
+	merged atomic.Int64
+}
+
+// coalescer 暂存一个窗口内的命令
+type coalescer struct {
+	pending []*payload
+	// 最近一个屏障之后，每个 key 上可以继续合并的命令在 pending 中的下标
+	mergeable map[coalesceKey]int
+	stats     *coalesceStats
+}
+
+func newCoalescer(stats *coalesceStats) *coalescer {
+	return &coalescer{
+		mergeable: make(map[coalesceKey]int),
+		stats:     stats,
+	}
+}
+
+// coalesceOp 是可以合并的命令
+type coalesceOp struct {
+	set   bool
+	value []byte // SET 的值
+	ttl   CmdLine
+	delta int64 // INCRBY 的增量
+}
+
+// parseCoalesceOp 识别可以合并的命令，返回 false 表示屏障
+func parseCoalesceOp(cmdLine CmdLine) (string, *coalesceOp, bool) {
+	if len(cmdLine) < 2 {
+		return "", nil, false
+	}
+	key := string(cmdLine[1])
+	switch strings.ToLower(string(cmdLine[0])) {
+	case "set":
+		// 数据库写入 aof 的 SET 只有这三种形式
+		switch {
+		case len(cmdLine) == 3:
+			return key, &coalesceOp{set: true, value: cmdLine[2]}, true
+		case len(cmdLine) == 4 && strings.EqualFold(string(cmdLine[3]), "keepttl"),
+			len(cmdLine) == 5 && strings.EqualFold(string(cmdLine[3]), "pxat"):
+			return key, &coalesceOp{set: true, value: cmdLine[2], ttl: cmdLine[3:]}, true
+		}
+	case "incr", "decr":
+		if len(cmdLine) == 2 {
+			delta := int64(1)
+			if strings.EqualFold(string(cmdLine[0]), "decr") {
+				delta = -1
+			}
+			return key, &coalesceOp{delta: delta}, true
+		}
+	case "incrby", "decrby":
+		if len(cmdLine) == 3 {
+			delta, err := strconv.ParseInt(string(cmdLine[2]), 10, 64)
+			if err != nil || delta == math.MinInt64 {
+				return "", nil, false
+			}
+			if strings.EqualFold(string(cmdLine[0]), "decrby") {
+				delta = -delta
+			}
+			return key, &coalesceOp{delta: delta}, true
+		}
+	}
+	return "", nil, false
+}
+
+// addInt64 检查溢出
+func addInt64(a, b int64) (int64, bool) {
+	if (b > 0 && a > math.MaxInt64-b) || (b < 0 && a < math.MinInt64-b) {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// merge 把 next 合并进 prev，无法合并时返回 nil
+func merge(prev, next *coalesceOp) *coalesceOp {
+	if next.set {
+		if len(next.ttl) == 1 && prev.set {
+			// KEEPTTL 保留前一条 SET 设置的过期时间
+			return &coalesceOp{set: true, value: next.value, ttl: prev.ttl}
+		}
+		return next
+	}
+	if prev.set {
+		// 与 INCR 相同，只有规范的整数字符串才能计算
+		val, err := strconv.ParseInt(string(prev.value), 10, 64)
+		if err != nil || strconv.FormatInt(val, 10) != string(prev.value) {
+			return nil
+		}
+		sum, ok := addInt64(val, next.delta)
+		if !ok {
+			return nil
+		}
+		return &coalesceOp{set: true, value: []byte(strconv.FormatInt(sum, 10)), ttl: prev.ttl}
+	}
+	sum, ok := addInt64(prev.delta, next.delta)
+	if !ok {
+		return nil
+	}
+	return &coalesceOp{delta: sum}
+}
+
+func (op *coalesceOp) toCmdLine(key string) CmdLine {
+	if op.set {
+		cmdLine := utils.ToCmdLine("set", key)
+		cmdLine = append(cmdLine, op.value)
+		return append(cmdLine, op.ttl...)
+	}
+	return utils.ToCmdLine("incrby", key, strconv.FormatInt(op.delta, 10))
+}
+
+// add 暂存一条命令，能合并时合并到之前的命令中
+func (c *coalescer) add(p *payload) {
+	key, op, ok := parseCoalesceOp(p.cmdLine)
+	if !ok {
+		// 屏障
+		c.pending = append(c.pending, p)
+		clear(c.mergeable)
+		return
+	}
+	ck := coalesceKey{dbIndex: p.dbIndex, key: key}
+	if i, exists := c.mergeable[ck]; exists {
+		_, prev, _ := parseCoalesceOp(c.pending[i].cmdLine)
+		if merged := merge(prev, op); merged != nil {
+			c.pending[i] = &payload{dbIndex: p.dbIndex, cmdLine: merged.toCmdLine(key), seq: p.seq}
+			c.stats.merged.Add(1)
+			return
+		}
+	}
+	c.mergeable[ck] = len(c.pending)
+	c.pending = append(c.pending, p)
+}
+
+// take 取出暂存的命令
+func (c *coalescer) take() []*payload {
+	pending := c.pending
+	c.pending = nil
+	clear(c.mergeable)
+	return pending
+}
+
+// listenCoalesced 按窗口合并命令后写入，aofChan 关闭时写入剩余的命令
+func (persister *Persister) listenCoalesced(aofChan chan *payload, window time.Duration) {
+	c := newCoalescer(&persister.coalesceStats)
+	// 合并之后的命令编号不再递增，整个窗口写完之后再推进 written
+	flush := func() {
+		var seq int64
+		for _, p := range c.take() {
+			persister.writeAof(p)
+			seq = max(seq, p.seq)
+		}
+		if seq > 0 {
+			persister.watermark.markWritten(seq)
+		}
+	}
+	timer := time.NewTimer(window)
+	timer.Stop()
+	var deadline <-chan time.Time
+	for {
+		select {
+		case p, ok := <-aofChan:
+			if !ok {
+				flush()
+				return
+			}
+			c.add(p)
+			if len(c.pending) >= aofCoalesceMaxPending {
+				timer.Stop()
+				deadline = nil
+				flush()
+			} else if deadline == nil {
+				timer.Reset(window)
+				deadline = timer.C
+			}
+		case <-deadline:
+			deadline = nil
+			flush()
+		}
+	}
+}
+
+// CoalescedCommands returns number of commands merged away by the coalescing window
+func (persister *Persister) CoalescedCommands() int64 {
+	return persister.coalesceStats.merged.Load()
+}
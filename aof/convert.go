@@ -0,0 +1,212 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	rdb "github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/model"
+	"github.com/zhangming/go-redis/interfaces/redis/parser"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 把更新版本的持久化文件改写成旧版本，用于降级
+// rdb 重新编码，aof 逐条复制命令，旧版本不支持的数据类型和命令被丢弃并计入 Dropped。
+// 版本不高于目标版本的文件原样复制
+
+// streamWriteCmds 版本 2 增加的消息流写命令
+var streamWriteCmds = map[string]struct{}{
+	"xadd":   {},
+	"xtrim":  {},
+	"xsetid": {},
+	"xdel":   {},
+}
+
+// ConvertResult describes a finished conversion
+type ConvertResult struct {
+	From int
+	To   int
+	// Dropped is the number of keys (rdb) and commands (aof) not supported by target version
+	Dropped int
+}
+
+// ConvertFile rewrites src in format version target and saves it to dst
+func ConvertFile(src, dst string, target int) (*ConvertResult, error) {
+	if target < MinFormatVersion || target > FormatVersion {
+		return nil, fmt.Errorf("target version %d: out of range [%d, %d]", target, MinFormatVersion, FormatVersion)
+	}
+	from, err := FileFormatVersion(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkVersion(src, from); err != nil {
+		return nil, err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+	result := &ConvertResult{From: from, To: target}
+	if from <= target {
+		result.To = from
+		_, err = io.Copy(out, in)
+	} else {
+		err = convert(in, out, target, result)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return result, out.Sync()
+}
+
+func convert(in io.ReadSeeker, out io.Writer, target int, result *ConvertResult) error {
+	head := make([]byte, 5)
+	n, _ := io.ReadFull(in, head)
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	if bytes.Equal(head[:n], []byte("REDIS")) {
+		dec := rdb.NewDecoder(in)
+		if err := convertRDB(dec, w, target, result); err != nil {
+			return err
+		}
+		// rdb 之后是 aof 的命令部分
+		if _, err := in.Seek(int64(dec.GetReadCount()), io.SeekStart); err != nil {
+			return err
+		}
+	} else if target > 1 {
+		if _, err := w.Write(protocol.MakeMultiBulkReply(formatCmdLine(target)).ToBytes()); err != nil {
+			return err
+		}
+	}
+	if err := convertAofCmds(in, w, target, result); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// convertAofCmds 复制 aof 命令，去掉版本标记和目标版本不支持的命令
+func convertAofCmds(in io.Reader, w io.Writer, target int, result *ConvertResult) error {
+	for p := range parser.ParseStream(in) {
+		if p.Err != nil {
+			if p.Err == io.EOF {
+				break
+			}
+			return p.Err
+		}
+		r, ok := p.Data.(*protocol.MultiBulkReply)
+		if !ok || len(r.Args) == 0 || isFormatCmd(r.Args) {
+			continue
+		}
+		if _, ok := streamWriteCmds[strings.ToLower(string(r.Args[0]))]; ok && target < 2 {
+			result.Dropped++
+			continue
+		}
+		if _, err := w.Write(r.ToBytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// convertRDB 读取整个 rdb 后重新编码，数据按数据库分组写入
+func convertRDB(dec *rdb.Decoder, w io.Writer, target int, result *ConvertResult) error {
+	var auxKeys []string
+	aux := make(map[string]string)
+	dbs := make(map[int][]model.RedisObject)
+	err := dec.WithSpecialOpCode().Parse(func(o model.RedisObject) bool {
+		switch obj := o.(type) {
+		case *model.AuxObject:
+			if _, ok := aux[obj.Key]; !ok {
+				auxKeys = append(auxKeys, obj.Key)
+			}
+			aux[obj.Key] = obj.Value
+		case *model.StreamObject:
+			if target < 2 {
+				result.Dropped++
+				return true
+			}
+			dbs[o.GetDBIndex()] = append(dbs[o.GetDBIndex()], o)
+		case *model.StringObject, *model.ListObject, *model.HashObject, *model.SetObject, *model.ZSetObject:
+			dbs[o.GetDBIndex()] = append(dbs[o.GetDBIndex()], o)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if target < 2 {
+		delete(aux, formatAuxKey)
+	} else {
+		aux[formatAuxKey] = strconv.Itoa(target)
+	}
+
+	enc := encoder.NewEncoder(w).EnableCompress()
+	if err := enc.WriteHeader(); err != nil {
+		return err
+	}
+	for _, key := range auxKeys {
+		if value, ok := aux[key]; ok {
+			if err := enc.WriteAux(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	indexes := make([]int, 0, len(dbs))
+	for index := range dbs {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	for _, index := range indexes {
+		objects := dbs[index]
+		ttlCount := 0
+		for _, o := range objects {
+			if o.GetExpiration() != nil {
+				ttlCount++
+			}
+		}
+		if err := enc.WriteDBHeader(uint(index), uint64(len(objects)), uint64(ttlCount)); err != nil {
+			return err
+		}
+		for _, o := range objects {
+			if err := writeRDBObject(enc, o); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.WriteEnd()
+}
+
+func writeRDBObject(enc *encoder.Encoder, o model.RedisObject) error {
+	var opts []interface{}
+	if expiration := o.GetExpiration(); expiration != nil {
+		opts = append(opts, encoder.WithTTL(uint64(expiration.UnixNano()/1e6)))
+	}
+	switch obj := o.(type) {
+	case *model.StringObject:
+		return enc.WriteStringObject(obj.Key, obj.Value, opts...)
+	case *model.ListObject:
+		return enc.WriteListObject(obj.Key, obj.Values, opts...)
+	case *model.HashObject:
+		return enc.WriteHashMapObject(obj.Key, obj.Hash, opts...)
+	case *model.SetObject:
+		return enc.WriteSetObject(obj.Key, obj.Members, opts...)
+	case *model.ZSetObject:
+		return enc.WriteZSetObject(obj.Key, obj.Entries, opts...)
+	}
+	return fmt.Errorf("cannot convert %s key %s", o.GetType(), o.GetKey())
+}
@@ -0,0 +1,88 @@
+package aof
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/crc64jones"
+	rdb "github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/model"
+	"github.com/zhangming/go-redis/interfaces/database"
+)
+
+// DUMP/RESTORE 的载荷
+// 与 redis 相同: 对象类型(1 字节) + rdb 中的对象编码 + rdb 版本号(2 字节小端) + crc64(8 字节小端，覆盖之前的所有字节)。
+// 编码时用 rdb 编码器以空 key 写入对象再去掉 key，解析时补上 rdb 文件头和空 key 交给 rdb 解析器，
+// 所以 redis 生成的载荷只要 rdb 解析器认识对象的编码就可以恢复。
+// 集合成员和 hash 字段排序后编码，相同的数据得到相同的载荷
+
+// dumpRDBVersion 与 rdb 编码器写入的文件版本相同
+const dumpRDBVersion = 11
+
+var errUnknownObject = errors.New("unknown object type")
+
+// ErrBadDumpPayload means the payload of RESTORE is truncated or corrupted
+var ErrBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// DumpEntity serializes entity into the payload of DUMP
+func DumpEntity(entity *database.DataEntity) ([]byte, error) {
+	var buf bytes.Buffer
+	cw := newCanonicalWriter(&buf)
+	encoder := rdb.NewEncoder(cw).EnableCompress()
+	if err := encoder.WriteHeader(); err != nil {
+		return nil, err
+	}
+	if err := encoder.WriteDBHeader(0, 1, 0); err != nil {
+		return nil, err
+	}
+	start := buf.Len()
+	if _, err := writeObject(encoder, cw, true, "", entity, nil); err != nil {
+		return nil, err
+	}
+	obj := buf.Bytes()[start:]
+	// 空 key 编码为长度 0，紧跟在类型之后
+	if len(obj) < 2 || obj[1] != 0 {
+		return nil, errors.New("unexpected key encoding")
+	}
+	payload := make([]byte, 0, len(obj)+9)
+	payload = append(payload, obj[0])
+	payload = append(payload, obj[2:]...)
+	payload = binary.LittleEndian.AppendUint16(payload, dumpRDBVersion)
+	crc := crc64jones.New()
+	_, _ = crc.Write(payload)
+	return binary.LittleEndian.AppendUint64(payload, crc.Sum64()), nil
+}
+
+// ParseDump verifies the footer of payload and decodes the object in it
+func ParseDump(payload []byte) (model.RedisObject, error) {
+	if len(payload) < 11 {
+		return nil, ErrBadDumpPayload
+	}
+	body := payload[:len(payload)-8]
+	crc := crc64jones.New()
+	_, _ = crc.Write(body)
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version > dumpRDBVersion || crc.Sum64() != binary.LittleEndian.Uint64(payload[len(body):]) {
+		return nil, ErrBadDumpPayload
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("REDIS%04d", dumpRDBVersion))
+	buf.Write([]byte{rdbOpCodeSelectDB, 0, body[0], 0})
+	buf.Write(body[1 : len(body)-2])
+	buf.WriteByte(rdbOpCodeEOF)
+	var obj model.RedisObject
+	err := core.NewDecoder(&buf).Parse(func(o model.RedisObject) bool {
+		obj = o
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if obj == nil {
+		return nil, ErrBadDumpPayload
+	}
+	return obj, nil
+}
@@ -0,0 +1,144 @@
+package aof
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// 同步确认
+// 进入 aof 的命令按进入的顺序编号，写入文件后推进 written，fsync 成功后把 synced 推进到 fsync 开始时的 written。
+// WAITSYNC 和 CLIENT DURABILITY SYNC 的连接在命令执行之后取 QueuedOffset，等到 synced 不小于它时才回复，
+// 这时这条命令以及之前进入 aof 的命令都已经落盘。
+// 等待的连接登记需要的编号，写入协程写到这个编号时请求后台 fsync，同一时间等待的连接共用一次 fsync
+
+// errClosedBeforeSync 等待期间 persister 被关闭
+var errClosedBeforeSync = errors.New("aof closed before fsync")
+
+// syncWatermark 记录命令编号的写入和落盘进度
+type syncWatermark struct {
+	// 分配编号和放入 aofChan 必须是原子的，否则写入协程看到的编号不是递增的
+	enqueueMu sync.Mutex
+	queued    atomic.Int64
+	written   atomic.Int64
+	synced    atomic.Int64
+	// 等待的连接需要的最大编号
+	wanted atomic.Int64
+	// 请求后台 fsync，容量为 1，多个请求合并为一次
+	requests chan struct{}
+	// 每次 fsync 之后关闭并替换，唤醒等待的连接
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+func (w *syncWatermark) init() {
+	w.requests = make(chan struct{}, 1)
+	w.changed = make(chan struct{})
+}
+
+func (w *syncWatermark) request() {
+	select {
+	case w.requests <- struct{}{}:
+	default:
+	}
+}
+
+func (w *syncWatermark) waitChan() <-chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.changed
+}
+
+func (w *syncWatermark) notify() {
+	w.mu.Lock()
+	close(w.changed)
+	w.changed = make(chan struct{})
+	w.mu.Unlock()
+}
+
+// markWritten 编号不大于 seq 的命令都已经写入文件，有连接在等待时请求 fsync
+func (w *syncWatermark) markWritten(seq int64) {
+	w.written.Store(seq)
+	if wanted := w.wanted.Load(); wanted > w.synced.Load() && seq >= wanted {
+		w.request()
+	}
+}
+
+// markSynced 编号不大于 seq 的命令都已经落盘
+func (w *syncWatermark) markSynced(seq int64) {
+	for {
+		cur := w.synced.Load()
+		if cur >= seq || w.synced.CompareAndSwap(cur, seq) {
+			return
+		}
+	}
+}
+
+// syncOnRequest 在后台执行等待的连接请求的 fsync
+func (persister *Persister) syncOnRequest() {
+	go func() {
+		for {
+			select {
+			case <-persister.watermark.requests:
+				persister.Fsync()
+			case <-persister.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// QueuedOffset returns the number of commands accepted by the persister so far
+func (persister *Persister) QueuedOffset() int64 {
+	return persister.watermark.queued.Load()
+}
+
+// PendingCommands returns the number of commands accepted but not written to the file yet
+func (persister *Persister) PendingCommands() int64 {
+	w := &persister.watermark
+	return w.queued.Load() - w.written.Load()
+}
+
+// FsyncedOffset returns the number of commands known to be on disk
+func (persister *Persister) FsyncedOffset() int64 {
+	return persister.watermark.synced.Load()
+}
+
+// WaitFsynced blocks until commands up to offset are fsynced,
+// returns error if a write or fsync fails or the persister is closed meanwhile
+func (persister *Persister) WaitFsynced(offset int64) error {
+	w := &persister.watermark
+	failures := persister.WriteFailures()
+	for {
+		changed := w.waitChan()
+		if w.synced.Load() >= offset {
+			return nil
+		}
+		if persister.WriteFailures() != failures {
+			return errors.New(persister.LastWriteError())
+		}
+		for {
+			cur := w.wanted.Load()
+			if cur >= offset || w.wanted.CompareAndSwap(cur, offset) {
+				break
+			}
+		}
+		// 登记之后再检查 written，写入协程在登记之前已经写到 offset 时由这里请求
+		if w.written.Load() >= offset {
+			w.request()
+		}
+		select {
+		case <-changed:
+		case <-persister.ctx.Done():
+			if w.synced.Load() >= offset {
+				return nil
+			}
+			return errClosedBeforeSync
+		}
+	}
+}
+
+// Persisters returns the persister of every partition
+func (pp *PartitionedPersister) Persisters() []*Persister {
+	return pp.partitions
+}
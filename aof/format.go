@@ -0,0 +1,165 @@
+package aof
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	rdb "github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/model"
+	"github.com/zhangming/go-redis/interfaces/redis/parser"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 持久化文件的格式版本
+// rdb 在辅助字段 goredis-format 中记录版本，aof 的第一条命令是 GOREDIS-FORMAT <version>，
+// 加载时遇到比当前引擎更新的版本直接拒绝，避免降级后把不认识的数据当作错误命令丢掉。
+// 没有版本标记的文件是版本 1。
+//
+// 版本历史:
+//  1. 字符串、列表、哈希、集合、有序集合
+//  2. 增加消息流，文件开头带有版本标记
+
+// FormatVersion is the persistence format version written by this engine
+const FormatVersion = 2
+
+// MinFormatVersion is the oldest version cmd/convert can produce
+const MinFormatVersion = 1
+
+const (
+	formatAuxKey = "goredis-format"
+	formatCmd    = "goredis-format"
+)
+
+// ErrNewerFormat is wrapped by errors of files written by a newer engine
+var ErrNewerFormat = errors.New("persistence file format is newer than supported")
+
+func formatCmdLine(version int) CmdLine {
+	return utils.ToCmdLine(formatCmd, strconv.Itoa(version))
+}
+
+// isFormatCmd 判断 aof 中的命令是否是版本标记
+func isFormatCmd(cmdLine CmdLine) bool {
+	return len(cmdLine) == 2 && strings.EqualFold(string(cmdLine[0]), formatCmd)
+}
+
+func parseFormatVersion(value string) (int, error) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid format version %q", value)
+	}
+	return version, nil
+}
+
+// checkVersion 版本不高于当前引擎时返回 nil
+func checkVersion(name string, version int) error {
+	if version > FormatVersion {
+		return fmt.Errorf("%w: %s has format version %d, this server supports up to %d; "+
+			"upgrade the server or downgrade the file with cmd/convert", ErrNewerFormat, name, version, FormatVersion)
+	}
+	return nil
+}
+
+// RDBFormatVersion returns the format version recorded in rdb aux fields
+func RDBFormatVersion(r io.Reader) (int, error) {
+	version := 1
+	var versionErr error
+	dec := rdb.NewDecoder(r).WithSpecialOpCode()
+	err := dec.Parse(func(o model.RedisObject) bool {
+		aux, ok := o.(*model.AuxObject)
+		if !ok {
+			// 辅助字段都在数据之前
+			return false
+		}
+		if aux.Key == formatAuxKey {
+			version, versionErr = parseFormatVersion(aux.Value)
+			return false
+		}
+		return true
+	})
+	if versionErr != nil {
+		return 0, versionErr
+	}
+	return version, err
+}
+
+// aofFormatVersion 读取 aof 第一条命令中的版本，没有标记时返回 1
+func aofFormatVersion(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	head, err := reader.Peek(1)
+	if err == io.EOF {
+		return FormatVersion, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if head[0] != '*' {
+		return 1, nil
+	}
+	cmdLine, err := parser.ParseV2(reader)
+	if err != nil {
+		// 不完整的第一条命令由加载过程处理
+		return 1, nil
+	}
+	if isFormatCmd(cmdLine) {
+		return parseFormatVersion(string(cmdLine[1]))
+	}
+	return 1, nil
+}
+
+// FileFormatVersion returns the format version of an aof or rdb file,
+// empty files are considered to be the current version
+func FileFormatVersion(filename string) (int, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	return formatVersion(file)
+}
+
+func formatVersion(r io.Reader) (int, error) {
+	reader := bufio.NewReader(r)
+	head, err := reader.Peek(5)
+	if len(head) == 0 && err == io.EOF {
+		return FormatVersion, nil
+	}
+	if bytes.Equal(head, []byte("REDIS")) {
+		// rdb 文件或者带 rdb 前缀的 aof
+		return RDBFormatVersion(reader)
+	}
+	return aofFormatVersion(reader)
+}
+
+// CheckAuxFormat checks the format version aux field of rdb, other aux fields are ignored
+func CheckAuxFormat(aux *model.AuxObject) error {
+	if aux.Key != formatAuxKey {
+		return nil
+	}
+	version, err := parseFormatVersion(aux.Value)
+	if err != nil {
+		return err
+	}
+	return checkVersion("rdb", version)
+}
+
+// CheckFileFormat returns an error wrapping ErrNewerFormat if the file was written by a newer engine,
+// missing or unreadable files are left to the loader
+func CheckFileFormat(filename string) error {
+	version, err := FileFormatVersion(filename)
+	if err != nil {
+		return nil
+	}
+	return checkVersion(filename, version)
+}
+
+// writeFormatCmd 在新的 aof 文件开头写入版本标记
+func writeFormatCmd(w io.Writer) error {
+	_, err := w.Write(protocol.MakeMultiBulkReply(formatCmdLine(FormatVersion)).ToBytes())
+	return err
+}
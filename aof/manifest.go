@@ -0,0 +1,290 @@
+package aof
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// 多文件 aof
+// 设置 appenddirname 后 aof 保存在一个目录中，由清单文件 <appendfilename>.manifest 记录，每行一个文件:
+//
+//	file appendonly.aof.2.base.rdb seq 2 type b
+//	file appendonly.aof.3.incr.aof seq 3 type i
+//
+//   - base(b): 重写生成的快照，aof-use-rdb-preamble 时是 rdb 格式，否则是 aof 格式，最多一个
+//   - incr(i): 快照之后的增量命令，按 seq 递增的顺序加载，写入总是追加到最后一个
+//
+// 重写开始时切换到新的 incr 文件，结束时新快照成为 base，之前的 base 和 incr 从清单中删除，
+// 不需要在暂停写入期间复制旧文件的尾部。清单总是写临时文件后改名，崩溃时要么是旧清单要么是新清单，
+// 不在清单中的文件(例如写了一半的快照)在下次启动时删除
+
+const (
+	manifestTypeBase = "b"
+	manifestTypeIncr = "i"
+)
+
+// ManifestFile is a file recorded in the manifest of a multi part aof
+type ManifestFile struct {
+	Name string
+	Seq  int
+	Type string
+}
+
+// Manifest lists files of a multi part aof in loading order: the base file if any, then incr files
+type Manifest struct {
+	Files []ManifestFile
+}
+
+// ManifestPath returns path of the manifest in dir
+func ManifestPath(dir, name string) string {
+	return filepath.Join(dir, name+".manifest")
+}
+
+func baseFilename(name string, seq int, rdb bool) string {
+	if rdb {
+		return name + "." + strconv.Itoa(seq) + ".base.rdb"
+	}
+	return name + "." + strconv.Itoa(seq) + ".base.aof"
+}
+
+func incrFilename(name string, seq int) string {
+	return name + "." + strconv.Itoa(seq) + ".incr.aof"
+}
+
+// ReadManifest reads the manifest in dir
+func ReadManifest(dir, name string) (*Manifest, error) {
+	file, err := os.Open(ManifestPath(dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	m := &Manifest{}
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f, err := parseManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", ManifestPath(dir, name), lineNo, err)
+		}
+		m.Files = append(m.Files, f)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", ManifestPath(dir, name), err)
+	}
+	return m, nil
+}
+
+// parseManifestLine 解析 file <name> seq <seq> type <b|i>，键值对的顺序不限
+func parseManifestLine(line string) (ManifestFile, error) {
+	var f ManifestFile
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return f, fmt.Errorf("malformed line %q", line)
+	}
+	for i := 0; i < len(fields); i += 2 {
+		value := fields[i+1]
+		switch fields[i] {
+		case "file":
+			f.Name = value
+		case "seq":
+			seq, err := strconv.Atoi(value)
+			if err != nil || seq <= 0 {
+				return f, fmt.Errorf("invalid seq %q", value)
+			}
+			f.Seq = seq
+		case "type":
+			f.Type = value
+		}
+	}
+	if f.Name == "" || f.Seq == 0 || (f.Type != manifestTypeBase && f.Type != manifestTypeIncr) {
+		return f, fmt.Errorf("malformed line %q", line)
+	}
+	// 清单只能引用目录中的文件
+	if f.Name != filepath.Base(f.Name) {
+		return f, fmt.Errorf("invalid file name %q", f.Name)
+	}
+	return f, nil
+}
+
+func (m *Manifest) validate() error {
+	for i, f := range m.Files {
+		if f.Type == manifestTypeBase && i != 0 {
+			return errors.New("base file must be the first one")
+		}
+		if i > 0 && f.Type == manifestTypeIncr && m.Files[i-1].Type == manifestTypeIncr && f.Seq <= m.Files[i-1].Seq {
+			return errors.New("seq of incr files must be increasing")
+		}
+	}
+	return nil
+}
+
+func (m *Manifest) String() string {
+	var sb strings.Builder
+	for _, f := range m.Files {
+		sb.WriteString("file " + f.Name + " seq " + strconv.Itoa(f.Seq) + " type " + f.Type + "\n")
+	}
+	return sb.String()
+}
+
+// Paths returns paths of files in loading order
+func (m *Manifest) Paths(dir string) []string {
+	paths := make([]string, 0, len(m.Files))
+	for _, f := range m.Files {
+		paths = append(paths, filepath.Join(dir, f.Name))
+	}
+	return paths
+}
+
+// nextSeq 新文件的 seq 大于清单中所有同类型的文件
+func (m *Manifest) nextSeq(typ string) int {
+	seq := 0
+	for _, f := range m.Files {
+		if f.Type == typ {
+			seq = max(seq, f.Seq)
+		}
+	}
+	return seq + 1
+}
+
+func (m *Manifest) lastIncr() (ManifestFile, bool) {
+	if len(m.Files) == 0 || m.Files[len(m.Files)-1].Type != manifestTypeIncr {
+		return ManifestFile{}, false
+	}
+	return m.Files[len(m.Files)-1], true
+}
+
+func (m *Manifest) clone() *Manifest {
+	return &Manifest{Files: slices.Clone(m.Files)}
+}
+
+// writeManifest 写入临时文件后改名，然后同步目录保证改名落盘
+func writeManifest(dir, name string, m *Manifest) error {
+	tmp, err := os.CreateTemp(dir, name+".manifest-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(m.String()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), ManifestPath(dir, name)); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// ManifestFiles returns paths of files listed in the manifest in dir,
+// returns nil if the manifest does not exist
+func ManifestFiles(dir, name string) ([]string, error) {
+	m, err := ReadManifest(dir, name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m.Paths(dir), nil
+}
+
+// openManifest 读取清单，保证最后一个文件是可以追加的 incr 文件
+// 清单不存在时创建新的清单，legacyFile 存在时把它移入目录作为 base，用于从单个 aof 文件升级
+func openManifest(dir, name, legacyFile string) (*Manifest, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	m, err := ReadManifest(dir, name)
+	if os.IsNotExist(err) {
+		m = &Manifest{}
+		if info, statErr := os.Stat(legacyFile); statErr == nil && info.Mode().IsRegular() {
+			base := ManifestFile{Name: baseFilename(name, 1, false), Seq: 1, Type: manifestTypeBase}
+			if err := os.Rename(legacyFile, filepath.Join(dir, base.Name)); err != nil {
+				return nil, err
+			}
+			slog.Info("moved aof into the aof dir as base file", "from", legacyFile, "to", filepath.Join(dir, base.Name))
+			m.Files = append(m.Files, base)
+		} else if _, statErr := os.Stat(filepath.Join(dir, baseFilename(name, 1, false))); statErr == nil {
+			// 上次升级时移入了文件，但是在写清单之前崩溃
+			m.Files = append(m.Files, ManifestFile{Name: baseFilename(name, 1, false), Seq: 1, Type: manifestTypeBase})
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	for _, f := range m.Files {
+		if _, err := os.Stat(filepath.Join(dir, f.Name)); err != nil {
+			return nil, fmt.Errorf("file listed in %s: %w", ManifestPath(dir, name), err)
+		}
+	}
+	if _, ok := m.lastIncr(); !ok {
+		seq := m.nextSeq(manifestTypeIncr)
+		m.Files = append(m.Files, ManifestFile{Name: incrFilename(name, seq), Seq: seq, Type: manifestTypeIncr})
+		// 先创建文件再写入清单，清单中的文件总是存在
+		file, err := openAofFile(filepath.Join(dir, incrFilename(name, seq)))
+		if err != nil {
+			return nil, err
+		}
+		_ = file.Close()
+		if err := writeManifest(dir, name, m); err != nil {
+			return nil, err
+		}
+	}
+	removeUnlisted(dir, name, m)
+	return m, nil
+}
+
+// removeUnlisted 删除目录中不在清单里的 aof 文件，它们是重写中途崩溃或者删除旧文件失败留下的
+func removeUnlisted(dir, name string, m *Manifest) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	listed := make(map[string]struct{}, len(m.Files))
+	for _, f := range m.Files {
+		listed[f.Name] = struct{}{}
+	}
+	for _, entry := range entries {
+		n := entry.Name()
+		if _, ok := listed[n]; ok || entry.IsDir() || !strings.HasPrefix(n, name+".") {
+			continue
+		}
+		if n == name+".manifest" {
+			continue
+		}
+		if !strings.HasSuffix(n, ".base.rdb") && !strings.HasSuffix(n, ".base.aof") &&
+			!strings.HasSuffix(n, ".incr.aof") && !strings.HasSuffix(n, ".tmp") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, n)); err == nil {
+			slog.Info("removed aof file not in manifest", "file", n)
+		}
+	}
+}
@@ -9,6 +9,8 @@ import (
 	List "github.com/zhangming/go-redis/datastruct/list"
 	"github.com/zhangming/go-redis/datastruct/set"
 	"github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/datastruct/sparse"
+	"github.com/zhangming/go-redis/datastruct/stream"
 	"github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/redis/protocol"
 )
@@ -25,6 +27,9 @@ func EntityToCmd(key string, entity *database.DataEntity) *protocol.MultiBulkRep
 	switch val := entity.Data.(type) {
 	case []byte:
 		cmd = stringToCmd(key, val)
+	case *sparse.String:
+		// 重写时按完整的字符串写入
+		cmd = stringToCmd(key, val.Bytes())
 	case list.List:
 		cmd = listToCmd(key, val)
 	case *set.Set:
@@ -37,6 +42,20 @@ func EntityToCmd(key string, entity *database.DataEntity) *protocol.MultiBulkRep
 	return cmd
 }
 
+// EntityToCmds 与 EntityToCmd 相同，消息流需要多条命令才能重建
+func EntityToCmds(key string, entity *database.DataEntity) []*protocol.MultiBulkReply {
+	if entity == nil {
+		return nil
+	}
+	if s, ok := entity.Data.(*stream.Stream); ok {
+		return streamToCmds(key, s)
+	}
+	if cmd := EntityToCmd(key, entity); cmd != nil {
+		return []*protocol.MultiBulkReply{cmd}
+	}
+	return nil
+}
+
 var pExpireAtBytes = []byte("PEXPIREAT")
 
 // MakeExpireCmd 生成命令行以设置给定键的过期时间
@@ -96,8 +115,8 @@ func hashToCmd(key string, hash dict.Dict) *protocol.MultiBulkReply {
 	i := 0
 	hash.ForEach(func(key string, val interface{}) bool {
 		bytes, _ := val.([]byte)
-		args[2*i] = []byte(key)
-		args[2*i+1] = bytes
+		args[2+2*i] = []byte(key)
+		args[3+2*i] = bytes
 		i++
 		return true
 
@@ -121,3 +140,30 @@ func zSetToCmd(key string, zSet *sortedset.SortedSet) *protocol.MultiBulkReply {
 	})
 	return protocol.MakeMultiBulkReply(args)
 }
+
+var (
+	xAddCmd   = []byte("XADD")
+	xSetIDCmd = []byte("XSETID")
+)
+
+// streamToCmds 每条消息一个 XADD，最后用 XSETID 恢复最大 ID。
+// 所有消息都被裁剪掉的消息流用 XADD MAXLEN 0 创建
+func streamToCmds(key string, s *stream.Stream) []*protocol.MultiBulkReply {
+	cmds := make([]*protocol.MultiBulkReply, 0, s.Len()+2)
+	if s.Len() == 0 {
+		cmds = append(cmds, protocol.MakeMultiBulkReply([][]byte{
+			xAddCmd, []byte(key), []byte("MAXLEN"), []byte("0"), []byte("0-1"), []byte(""), []byte(""),
+		}))
+	}
+	s.ForEach(func(entry *stream.Entry) bool {
+		args := make([][]byte, 0, 3+len(entry.Fields))
+		args = append(args, xAddCmd, []byte(key), []byte(entry.ID.String()))
+		args = append(args, entry.Fields...)
+		cmds = append(cmds, protocol.MakeMultiBulkReply(args))
+		return true
+	})
+	cmds = append(cmds, protocol.MakeMultiBulkReply([][]byte{
+		xSetIDCmd, []byte(key), []byte(s.LastID().String()),
+	}))
+	return cmds
+}
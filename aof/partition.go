@@ -0,0 +1,183 @@
+package aof
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+// 按槽位分区的 aof
+// 每个分区负责一段连续的槽位，拥有独立的 Persister 和 aof 文件，
+// 迁移槽位时只需要发送该分区 aof 的增量部分。
+// 集群模式下一条命令的 key 都在同一个槽位，所以每条命令只会写入一个分区，
+// 没有 key 的命令(FLUSHDB/FLUSHALL 等)写入所有分区。
+
+// SlotCount is the number of slots of redis cluster
+const SlotCount = 16384
+
+// SlotRange is a closed interval of slots
+type SlotRange struct {
+	Begin uint32
+	End   uint32
+}
+
+func (r SlotRange) String() string {
+	return strconv.Itoa(int(r.Begin)) + "-" + strconv.Itoa(int(r.End))
+}
+
+// ParseSlotRanges parses "0-8191,8192-16383", ranges must cover all slots without overlapping
+func ParseSlotRanges(specs []string) ([]SlotRange, error) {
+	var ranges []SlotRange
+	covered := make([]bool, SlotCount)
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		begin, end, found := strings.Cut(spec, "-")
+		if !found {
+			end = begin
+		}
+		b, err1 := strconv.Atoi(begin)
+		e, err2 := strconv.Atoi(end)
+		if err1 != nil || err2 != nil || b < 0 || e >= SlotCount || b > e {
+			return nil, fmt.Errorf("invalid slot range %q", spec)
+		}
+		for slot := b; slot <= e; slot++ {
+			if covered[slot] {
+				return nil, fmt.Errorf("slot %d is assigned to more than one partition", slot)
+			}
+			covered[slot] = true
+		}
+		ranges = append(ranges, SlotRange{Begin: uint32(b), End: uint32(e)})
+	}
+	if len(ranges) == 0 {
+		return nil, errors.New("no slot range given")
+	}
+	for slot, ok := range covered {
+		if !ok {
+			return nil, fmt.Errorf("slot %d is not assigned to any partition", slot)
+		}
+	}
+	return ranges, nil
+}
+
+// PartitionedPersister manages one Persister per slot range
+type PartitionedPersister struct {
+	ranges     []SlotRange
+	partitions []*Persister
+	// slot -> 分区序号
+	index []int
+}
+
+// PartitionFilename returns the aof filename of the given slot range
+func PartitionFilename(filename string, r SlotRange) string {
+	return filename + "." + r.String()
+}
+
+// NewPartitionedPersister 创建各分区的 persister
+// load 为 true 时先把每个分区单独重放到临时数据库，再合并到 db 中，
+// 这样某个分区中的 FLUSHALL 不会清掉其他分区已经加载的数据
+func NewPartitionedPersister(cfg *config.ServerProperties, db database.DBEngine, ranges []SlotRange,
+	load bool, tmpDBMaker func() database.DBEngine) (*PartitionedPersister, error) {
+	pp := &PartitionedPersister{
+		ranges: ranges,
+		index:  make([]int, SlotCount),
+	}
+	filename := cfg.AppendFilePath()
+	for i, r := range ranges {
+		for slot := r.Begin; slot <= r.End; slot++ {
+			pp.index[slot] = i
+		}
+		persister, err := NewPersisterWithConfig(cfg, db, PartitionFilename(filename, r), false, cfg.AppendFsync, tmpDBMaker)
+		if err != nil {
+			pp.Close()
+			return nil, err
+		}
+		pp.partitions = append(pp.partitions, persister)
+	}
+	if load {
+		for _, persister := range pp.partitions {
+			persister.loadIntoDB()
+		}
+	}
+	return pp, nil
+}
+
+// loadIntoDB 把分区 aof 重放到临时数据库，然后以重写 aof 的格式写入 persister.db
+func (persister *Persister) loadIntoDB() {
+	tmpHandler := persister.newRewriteHandler()
+	tmpHandler.LoadAof(0)
+	// 后续追加的命令需要和文件末尾选中的数据库保持一致
+	persister.currentDB = tmpHandler.currentDB
+	conn := connection.NewFakeConn()
+	for i := 0; i < persister.cfg.Databases; i++ {
+		if keys, _ := tmpHandler.db.GetDBSize(i); keys == 0 {
+			continue
+		}
+		persister.db.Exec(conn, utils.ToCmdLine("SELECT", strconv.Itoa(i)))
+		tmpHandler.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			for _, cmd := range EntityToCmds(key, entity) {
+				persister.db.Exec(conn, cmd.Args)
+			}
+			if expiration != nil {
+				persister.db.Exec(conn, MakeExpireCmd(key, *expiration).Args)
+			}
+			return true
+		})
+	}
+}
+
+// Ranges returns slot ranges of partitions
+func (pp *PartitionedPersister) Ranges() []SlotRange {
+	return pp.ranges
+}
+
+// Partition returns the persister which the slot belongs to
+func (pp *PartitionedPersister) Partition(slot uint32) *Persister {
+	return pp.partitions[pp.index[slot]]
+}
+
+// SaveCmdLine 写入槽位所在的分区，slot 小于 0 表示命令不涉及 key，写入所有分区
+func (pp *PartitionedPersister) SaveCmdLine(slot int, dbIndex int, cmdLine CmdLine) {
+	if slot < 0 {
+		for _, persister := range pp.partitions {
+			persister.SaveCmdLine(dbIndex, cmdLine)
+		}
+		return
+	}
+	pp.Partition(uint32(slot)).SaveCmdLine(dbIndex, cmdLine)
+}
+
+// Rewrite rewrites aof of every partition
+func (pp *PartitionedPersister) Rewrite() error {
+	for _, persister := range pp.partitions {
+		if err := persister.Rewrite(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PendingCommands returns the sum of PendingCommands of every partition
+func (pp *PartitionedPersister) PendingCommands() int64 {
+	var pending int64
+	for _, persister := range pp.partitions {
+		pending += persister.PendingCommands()
+	}
+	return pending
+}
+
+// Close closes every partition
+func (pp *PartitionedPersister) Close() {
+	for _, persister := range pp.partitions {
+		persister.Close()
+	}
+}
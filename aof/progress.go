@@ -0,0 +1,167 @@
+package aof
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+)
+
+// aof 加载进度
+// 按照已经读取的字节数计算百分比和剩余时间，加载过程中可以被 INFO 等并发读取
+
+const defaultLoadProgressInterval = 5 * time.Second
+
+// LoadProgress records progress of loading aof
+type LoadProgress struct {
+	loading     atomic.Bool
+	startTime   atomic.Int64 // unix 秒
+	totalBytes  atomic.Int64
+	loadedBytes atomic.Int64
+	commands    atomic.Int64
+	currentDB   atomic.Int64
+	// 执行重放命令的连接，加载期间只有它可以执行写命令
+	conn atomic.Value
+}
+
+// Loading returns true while aof is being loaded
+func (p *LoadProgress) Loading() bool {
+	return p.loading.Load()
+}
+
+// IsLoader returns true if c is the connection replaying aof
+func (p *LoadProgress) IsLoader(c redis.Connection) bool {
+	conn, _ := p.conn.Load().(redis.Connection)
+	return conn != nil && conn == c
+}
+
+// StartTime returns unix time in seconds when loading started
+func (p *LoadProgress) StartTime() int64 {
+	return p.startTime.Load()
+}
+
+// TotalBytes returns size of aof file
+func (p *LoadProgress) TotalBytes() int64 {
+	return p.totalBytes.Load()
+}
+
+// LoadedBytes returns bytes have been read
+func (p *LoadProgress) LoadedBytes() int64 {
+	return p.loadedBytes.Load()
+}
+
+// Commands returns number of replayed commands
+func (p *LoadProgress) Commands() int64 {
+	return p.commands.Load()
+}
+
+// CurrentDB returns the db which loader is writing into
+// 重写后的 aof 按照数据库序号依次写入，序号更小的数据库可以认为已经加载完成
+func (p *LoadProgress) CurrentDB() int {
+	return int(p.currentDB.Load())
+}
+
+// Percent returns loaded percentage
+func (p *LoadProgress) Percent() float64 {
+	total := p.TotalBytes()
+	if total <= 0 {
+		return 0
+	}
+	return float64(p.LoadedBytes()) * 100 / float64(total)
+}
+
+// ETA estimates remaining seconds by average loading speed
+func (p *LoadProgress) ETA() int64 {
+	loaded := p.LoadedBytes()
+	if loaded <= 0 {
+		return 0
+	}
+	elapsed := time.Now().Unix() - p.StartTime()
+	remaining := p.TotalBytes() - loaded
+	if remaining <= 0 {
+		return 0
+	}
+	return elapsed * remaining / loaded
+}
+
+func (p *LoadProgress) start(conn redis.Connection, totalBytes int64) {
+	p.conn.Store(conn)
+	p.startTime.Store(time.Now().Unix())
+	p.totalBytes.Store(totalBytes)
+	p.loadedBytes.Store(0)
+	p.commands.Store(0)
+	p.currentDB.Store(0)
+	p.loading.Store(true)
+}
+
+func (p *LoadProgress) finish() {
+	p.loadedBytes.Store(p.totalBytes.Load())
+	p.loading.Store(false)
+}
+
+func (p *LoadProgress) String() string {
+	return fmt.Sprintf("%.2f%% (%d/%d bytes), %d commands, eta %ds",
+		p.Percent(), p.LoadedBytes(), p.TotalBytes(), p.Commands(), p.ETA())
+}
+
+// report 每隔 interval 打印一次进度，直到 done 被关闭
+func (p *LoadProgress) report(filename string, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			slog.Info("loading aof " + filename + ": " + p.String())
+		}
+	}
+}
+
+// countingReader 统计已经读取的字节数
+type countingReader struct {
+	reader   io.Reader
+	progress *LoadProgress
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.progress.loadedBytes.Add(int64(n))
+	return n, err
+}
+
+// Progress returns loading progress of aof
+func (persister *Persister) Progress() *LoadProgress {
+	return &persister.progress
+}
+
+// LoadInBackground 在新的 goroutine 中加载 aof，返回前已经处于加载状态
+func (persister *Persister) LoadInBackground() {
+	persister.progress.loading.Store(true)
+	persister.loadDone = make(chan struct{})
+	go func() {
+		defer close(persister.loadDone)
+		defer persister.progress.loading.Store(false)
+		persister.LoadWithProgress()
+	}()
+}
+
+// LoadWithProgress 加载整个 aof 并按照 aof-load-progress-interval 打印进度，用于启动时的加载
+func (persister *Persister) LoadWithProgress() {
+	interval := defaultLoadProgressInterval
+	if persister.cfg != nil && persister.cfg.AofLoadProgressInterval != 0 {
+		interval = time.Duration(persister.cfg.AofLoadProgressInterval) * time.Second
+	}
+	done := make(chan struct{})
+	if interval > 0 {
+		go persister.progress.report(persister.aofFilename, interval, done)
+	}
+	start := time.Now()
+	persister.LoadAof(0)
+	close(done)
+	slog.Info(fmt.Sprintf("aof %s loaded: %d commands in %s", persister.aofFilename,
+		persister.progress.Commands(), time.Since(start).Round(time.Millisecond)))
+}
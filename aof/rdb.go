@@ -1,7 +1,10 @@
 package aof
 
 import (
+	"errors"
+	"io"
 	"os"
+	"sort"
 	"strconv"
 	"time"
 
@@ -12,17 +15,61 @@ import (
 	"github.com/zhangming/go-redis/datastruct/list"
 	"github.com/zhangming/go-redis/datastruct/set"
 	"github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/datastruct/sparse"
+	"github.com/zhangming/go-redis/datastruct/stream"
 	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/lib/utils"
 )
 
 // 它既可以用于 AOF Rewrite 时写入 RDB 前缀，也可以用于生成完整的 RDB 快照文件。
 
 func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
+	return persister.writeRDB(ctx.tmpFile, ctx.snapshot)
+}
+
+// writeRDB 把 aof 快照重放到临时数据库，再以 rdb 格式写入 w
+// w 可以是临时文件，也可以直接是从节点的 socket（无盘复制）
+func (persister *Persister) writeRDB(w io.Writer, snapshot *aofSnapshot) error {
 	// 命令写入aof
 	tmpHandler := persister.newRewriteHandler()
-	tmpHandler.LoadAof(int(ctx.fileSize))
+	tmpHandler.loadSnapshot(snapshot)
+	return WriteRDB(w, persister.cfg, tmpHandler.db)
+}
 
-	encoder := rdb.NewEncoder(ctx.tmpFile).EnableCompress()
+// RDBSource provides the databases encoded by WriteRDB, implemented by DBEngine
+type RDBSource interface {
+	GetDBSize(dbIndex int) (int, int)
+	ForEach(dbIndex int, cb func(key string, data *database.DataEntity, expiration *time.Time) bool)
+}
+
+// SaveRDBFile writes all databases of db into a temp file in rdb format and renames it to filename,
+// the caller stops writes in the meantime if all keys must come from the same moment
+func SaveRDBFile(cfg *config.ServerProperties, db RDBSource, filename string) error {
+	file, err := os.CreateTemp(cfg.TmpDir(), "*.rdb")
+	if err != nil {
+		return err
+	}
+	err = WriteRDB(file, cfg, db)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	// 改名是原子的，生成失败时旧的 rdb 文件保持不变
+	return os.Rename(file.Name(), filename)
+}
+
+// WriteRDB encodes all databases of db into w in rdb format
+func WriteRDB(w io.Writer, cfg *config.ServerProperties, db RDBSource) error {
+	// 消息流不经过编码器直接写入，所以文件末尾的校验和总是由 canonicalWriter 计算
+	cw := newCanonicalWriter(w)
+	deterministic := cfg.RdbDeterministic
+	encoder := rdb.NewEncoder(cw).EnableCompress()
 	err := encoder.WriteHeader()
 	if err != nil {
 		return err
@@ -31,157 +78,207 @@ func (persister *Persister) generateRDB(ctx *RewriteCtx) error {
 	// 5. 准备辅助字段（AUX fields）
 	//    这些是存储在 RDB 文件中的元数据。
 	auxMap := map[string]string{
-		"redis-ver":    "6.0.0",                                  // Redis 版本
-		"redis-bits":   "64",                                     // 操作系统位数
-		"aof-preamble": "0",                                      // AOF 序言标志，默认为 0
-		"ctime":        strconv.FormatInt(time.Now().Unix(), 10), // 创建时间戳
+		"redis-ver":    "6.0.0", // Redis 版本
+		"redis-bits":   "64",    // 操作系统位数
+		"aof-preamble": "0",     // AOF 序言标志，默认为 0
+		formatAuxKey:   strconv.Itoa(FormatVersion),
+	}
+	if !deterministic {
+		auxMap["ctime"] = strconv.FormatInt(time.Now().Unix(), 10) // 创建时间戳
 	}
 
 	// 6. 根据配置决定是否开启 AOF 序言
 	//    如果配置了 AofUseRdbPreamble，这个 RDB 文件可以作为混合持久化 AOF 文件的头部。
-	if config.Properties.AofUseRdbPreamble {
+	if cfg.AofUseRdbPreamble {
 		auxMap["aof-preamble"] = "1"
 	}
 
-	// 写入rdb配置信息
-	for key, value := range auxMap {
-		err := encoder.WriteAux(key, value)
+	// 写入rdb配置信息，按 key 排序保证顺序固定
+	auxKeys := make([]string, 0, len(auxMap))
+	for key := range auxMap {
+		auxKeys = append(auxKeys, key)
+	}
+	sort.Strings(auxKeys)
+	for _, key := range auxKeys {
+		err := encoder.WriteAux(key, auxMap[key])
 		if err != nil {
 			return err
 		}
 	}
 
-	for i := 0; i < config.Properties.Databases; i++ {
-		keyCount, ttlCount := tmpHandler.db.GetDBSize(i)
+	for i := 0; i < cfg.Databases; i++ {
+		keyCount, ttlCount := db.GetDBSize(i)
 		if keyCount == 0 {
 			continue
 		}
-		err = encoder.WriteDBHeader(uint(i), uint64(keyCount), uint64(ttlCount))
+		if cw.headerOnly {
+			err = cw.writeDBHeader(i, keyCount, ttlCount)
+		} else {
+			err = encoder.WriteDBHeader(uint(i), uint64(keyCount), uint64(ttlCount))
+			cw.headerOnly = true
+		}
 		if err != nil {
 			return err
 		}
 		// dump db
 		var err2 error
-		tmpHandler.db.ForEach(i, func(key string, entity *database.DataEntity, expiration *time.Time) bool {
-			var opts []interface{}
-			if expiration != nil {
-				opts = append(opts, rdb.WithTTL(uint64(expiration.UnixNano()/1e6)))
-			}
-			switch obj := entity.Data.(type) {
-			case []byte:
-				err = encoder.WriteStringObject(key, obj, opts...)
-			case list.List:
-				vals := make([][]byte, 0, obj.Len())
-				obj.ForEach(func(i int, v interface{}) bool {
-					bytes, _ := v.([]byte)
-					vals = append(vals, bytes)
-					return true
-				})
-				err = encoder.WriteListObject(key, vals, opts...)
-			case *set.Set:
-				vals := make([][]byte, 0, obj.Len())
-				obj.ForEach(func(m string) bool {
-					vals = append(vals, []byte(m))
-					return true
-				})
-				err = encoder.WriteSetObject(key, vals, opts...)
-			case dict.Dict:
-				hash := make(map[string][]byte)
-				obj.ForEach(func(key string, val interface{}) bool {
-					bytes, _ := val.([]byte)
-					hash[key] = bytes
-					return true
-				})
-				err = encoder.WriteHashMapObject(key, hash, opts...)
-			case *sortedset.SortedSet:
-				var entries []*model.ZSetEntry
-				obj.ForEachByRank(int64(0), obj.Len(), true, func(element *sortedset.Element) bool {
-					entries = append(entries, &model.ZSetEntry{
-						Member: element.Member,
-						Score:  element.Score,
-					})
-					return true
-				})
-				err = encoder.WriteZSetObject(key, entries, opts...)
+		writeEntity := func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			byEncoder, err := writeObject(encoder, cw, deterministic, key, entity, expiration)
+			if errors.Is(err, errUnknownObject) {
+				return true
 			}
 			if err != nil {
 				err2 = err
 				return false
 			}
+			if byEncoder {
+				cw.headerOnly = false
+			}
 			return true
-		})
+		}
+		if deterministic {
+			for _, entry := range sortedEntries(db, i) {
+				if !writeEntity(entry.key, entry.entity, entry.expiration) {
+					break
+				}
+			}
+		} else {
+			db.ForEach(i, writeEntity)
+		}
 		if err2 != nil {
 			return err2
 		}
 	}
-	err = encoder.WriteEnd()
-	if err != nil {
-		return err
+	return cw.writeEnd()
+}
+
+// writeObject 以 rdb 格式写入一个 key，返回是否由编码器写入。
+// 确定性输出时的 hash 和消息流由 cw 直接编码，编码器的状态不变
+func writeObject(encoder *rdb.Encoder, cw *canonicalWriter, deterministic bool, key string,
+	entity *database.DataEntity, expiration *time.Time) (bool, error) {
+	var opts []interface{}
+	if expiration != nil {
+		opts = append(opts, rdb.WithTTL(uint64(expiration.UnixNano()/1e6)))
 	}
-	return nil
+	switch obj := entity.Data.(type) {
+	case []byte:
+		return true, encoder.WriteStringObject(key, obj, opts...)
+	case *sparse.String:
+		return true, encoder.WriteStringObject(key, obj.Bytes(), opts...)
+	case list.List:
+		vals := make([][]byte, 0, obj.Len())
+		obj.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			vals = append(vals, bytes)
+			return true
+		})
+		return true, encoder.WriteListObject(key, vals, opts...)
+	case *set.Set:
+		members := obj.ToSlice()
+		if deterministic {
+			sort.Strings(members)
+		}
+		vals := make([][]byte, 0, len(members))
+		for _, m := range members {
+			vals = append(vals, []byte(m))
+		}
+		return true, encoder.WriteSetObject(key, vals, opts...)
+	case dict.Dict:
+		hash := make(map[string][]byte)
+		obj.ForEach(func(key string, val interface{}) bool {
+			bytes, _ := val.([]byte)
+			hash[key] = bytes
+			return true
+		})
+		if deterministic {
+			// 不经过编码器，不影响编码器的状态
+			return false, cw.writeHash(key, hash, expiration)
+		}
+		return true, encoder.WriteHashMapObject(key, hash, opts...)
+	case *sortedset.SortedSet:
+		var entries []*model.ZSetEntry
+		obj.ForEachByRank(int64(0), obj.Len(), true, func(element *sortedset.Element) bool {
+			entries = append(entries, &model.ZSetEntry{
+				Member: element.Member,
+				Score:  element.Score,
+			})
+			return true
+		})
+		return true, encoder.WriteZSetObject(key, entries, opts...)
+	case *stream.Stream:
+		// rdb 库没有消息流的编码器
+		return false, cw.writeStream(key, obj, expiration)
+	}
+	return false, errUnknownObject
 }
 
-func (persister *Persister) startGenerateRDB(newListener Listener, hook func()) (*RewriteCtx, error) {
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
+// startSnapshot 在暂停 aof 写入期间确定快照的截止位置
+// newListeners 会从这一刻开始收到之后写入 aof 的命令，用于向从节点补发快照之后的增量数据
+func (persister *Persister) startSnapshot(newListeners []Listener, hook func()) (*aofSnapshot, error) {
+	persister.lockAof()
+	defer persister.unlockAof()
 
 	err := persister.aofFile.Sync()
 	if err != nil {
 		return nil, err
 	}
-	fileInfo, _ := os.Stat(persister.aofFilename)
-	filesize := fileInfo.Size()
-	//在 GenerateRDB 这个函数执行期间，往临时文件里写入的内容格式是 RDB。
-	// 这里相当于直接按照混合形式来写的
-	file, err := os.CreateTemp(config.GetTmpDir(), "*.aof")
+	snapshot, err := persister.openSnapshot(0)
 	if err != nil {
 		return nil, err
 	}
-	if newListener != nil {
-
+	for _, newListener := range newListeners {
+		// 快照之后的命令都在 currentDB 的上下文中，先让监听者对齐数据库
+		newListener.Callback([]CmdLine{utils.ToCmdLine("SELECT", strconv.Itoa(persister.currentDB))})
+		persister.listeners[newListener] = struct{}{}
 	}
 	if hook != nil {
 		hook()
 	}
+	return snapshot, nil
+}
+
+func (persister *Persister) startGenerateRDB(newListeners []Listener, hook func()) (*RewriteCtx, error) {
+	snapshot, err := persister.startSnapshot(newListeners, hook)
+	if err != nil {
+		return nil, err
+	}
+	// 生成期间往临时文件里写入的内容格式是 RDB。
+	// 这里相当于直接按照混合形式来写的
+	file, err := os.CreateTemp(persister.cfg.TmpDir(), "*.aof")
+	if err != nil {
+		snapshot.close()
+		return nil, err
+	}
 	return &RewriteCtx{
-		fileSize: filesize,
+		snapshot: snapshot,
 		tmpFile:  file,
 	}, nil
 }
 
-func (persister *Persister) GenerateRDB(rdbFilename string) error {
-	// 1. 调用 startGenerateRDB 进行准备工作
-	//    传入 nil, nil 表示不需要为复制设置监听器（listener）或钩子函数（hook）。
-	ctx, err := persister.startGenerateRDB(nil, nil)
+// GenerateRDBForReplication 为全量同步生成 rdb 文件
+// listener 会收到快照之后的所有命令，调用方负责在传输完成后转发给从节点
+func (persister *Persister) GenerateRDBForReplication(rdbFilename string, listener Listener, hook func()) error {
+	ctx, err := persister.startGenerateRDB([]Listener{listener}, hook)
 	if err != nil {
-		// 如果准备阶段出错，直接返回错误。
 		return err
 	}
-
-	// 2. 调用 generateRDB 执行核心的 RDB 文件生成逻辑
-	//    它会把数据写入 ctx.tmpFile（一个临时文件）中。
 	err = persister.generateRDB(ctx)
 	if err != nil {
-		// 如果生成过程中出错，直接返回错误。
 		return err
 	}
-
-	// 3. 关闭临时文件
-	//    确保所有写入的数据都已刷到磁盘。
 	err = ctx.tmpFile.Close()
 	if err != nil {
 		return err
 	}
+	return os.Rename(ctx.tmpFile.Name(), rdbFilename)
+}
 
-	// 4. 将临时文件重命名为最终的目标文件名
-	//    os.Rename 是一个原子操作（在大多数文件系统上）。
-	//    这样做的好处是：如果在生成过程中失败，旧的 RDB 文件（如果存在）不会被破坏。
-	//    只有当新的 RDB 文件完全成功生成后，才会瞬间替换掉旧文件。
-	err = os.Rename(ctx.tmpFile.Name(), rdbFilename)
+// WriteRDBForReplication 无盘复制：不落临时文件，直接把 rdb 快照编码写入 w（通常是从节点的连接）
+// 多个从节点共用一个快照时 w 同时写入它们的连接，listeners 是每个从节点各自的增量命令接收者
+func (persister *Persister) WriteRDBForReplication(w io.Writer, listeners []Listener, hook func()) error {
+	snapshot, err := persister.startSnapshot(listeners, hook)
 	if err != nil {
 		return err
 	}
-
-	// 5. RDB 文件生成成功，返回 nil 表示没有错误。
-	return nil
+	return persister.writeRDB(w, snapshot)
 }
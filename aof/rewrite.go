@@ -4,9 +4,9 @@ import (
 	"io"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"strconv"
 
-	"github.com/zhangming/go-redis/config"
 	"github.com/zhangming/go-redis/lib/utils"
 	"github.com/zhangming/go-redis/redis/protocol"
 )
@@ -17,17 +17,28 @@ type RewriteCtx struct {
 	// 在 AOF 重写过程中，可以基于 fileSize 判断是否超过限制（如 auto-aof-rewrite-size）。
 	fileSize int64
 	dbIdx    int // selected db index when startRewrite
+	// 重写开始时的 aof 内容，重放到临时数据库后生成新的 aof
+	snapshot *aofSnapshot
+	// 多文件 aof 重写开始时切换到的 incr 文件，它和之后的 incr 文件保留在新的清单中
+	incrSeq int
 }
 
 func (persister *Persister) newRewriteHandler() *Persister {
 	h := &Persister{}
 	h.aofFilename = persister.aofFilename
+	h.aofDir = persister.aofDir
+	if persister.manifest != nil {
+		h.manifest = persister.manifest.clone()
+	}
+	h.cfg = persister.cfg
 	h.db = persister.tmpDBMaker()
 	return h
 }
 
 // Rewrite carries out AOF rewrite
 func (persister *Persister) Rewrite() error {
+	persister.rewriting.Lock()
+	defer persister.rewriting.Unlock()
 	ctx, err := persister.StartRewrite()
 	if err != nil {
 		return err
@@ -36,6 +47,9 @@ func (persister *Persister) Rewrite() error {
 	if err != nil {
 		return err
 	}
+	if persister.manifest != nil {
+		return persister.finishMultiPartRewrite(ctx)
+	}
 
 	persister.FinishRewrite(ctx)
 	return nil
@@ -43,19 +57,43 @@ func (persister *Persister) Rewrite() error {
 
 func (persister *Persister) DoRewrite(ctx *RewriteCtx) (err error) {
 	// start rewrite
-	if !config.Properties.AofUseRdbPreamble {
+	if !persister.cfg.AofUseRdbPreamble {
 		slog.Info("generate aof preamble")
 		err = persister.generateAof(ctx)
 	} else {
 		slog.Info("generate rdb preamble")
 		err = persister.generateRDB(ctx)
 	}
+	if err != nil {
+		return err
+	}
+	return persister.writeRetained(ctx.tmpFile)
+}
+
+// RetainedSource is implemented by engines keeping pub/sub retained messages
+type RetainedSource interface {
+	ForEachRetained(cb func(channel string, message []byte) bool)
+}
+
+// writeRetained 把保留消息写成 PUBLISH channel message RETAIN。
+// 直接读取线上实例: 重写开始之后的变化还会在 aof 尾部重放一次，结果相同
+func (persister *Persister) writeRetained(w io.Writer) error {
+	source, ok := persister.db.(RetainedSource)
+	if !ok {
+		return nil
+	}
+	var err error
+	source.ForEachRetained(func(channel string, message []byte) bool {
+		cmdLine := CmdLine{[]byte("PUBLISH"), []byte(channel), message, []byte("RETAIN")}
+		_, err = w.Write(protocol.MakeMultiBulkReply(cmdLine).ToBytes())
+		return err == nil
+	})
 	return err
 }
 
 func (persister *Persister) StartRewrite() (*RewriteCtx, error) {
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
+	persister.lockAof()
+	defer persister.unlockAof()
     err := persister.aofFile.Sync()
 	if err != nil {
 		slog.Error("sync aof file error", "error", err)
@@ -63,22 +101,117 @@ func (persister *Persister) StartRewrite() (*RewriteCtx, error) {
 	}
 	fileInfo, _ := os.Stat(persister.aofFilename)
 	filesize := fileInfo.Size()
+	snapshot, err := persister.openSnapshot(0)
+	if err != nil {
+		return nil, err
+	}
+	ctx := &RewriteCtx{
+		dbIdx:    persister.currentDB,
+		fileSize: filesize,
+		snapshot: snapshot,
+	}
+	tmpDir, pattern := persister.cfg.TmpDir(), "*.aof"
+	if persister.manifest != nil {
+		// 快照写在 aof 目录中，结束时改名为新的 base
+		tmpDir, pattern = persister.aofDir, persister.cfg.AppendFilename+".rewrite-*.tmp"
+		ctx.incrSeq, err = persister.switchIncr()
+		if err != nil {
+			snapshot.close()
+			return nil, err
+		}
+	}
 
-    file, err := os.CreateTemp(config.GetTmpDir(), "*.aof")
+    file, err := os.CreateTemp(tmpDir, pattern)
 	if err != nil {
 		slog.Error("create temp file error", "error", err)
+		snapshot.close()
 		return nil, err
 	}
-	return &RewriteCtx{
-		dbIdx: persister.currentDB,
-		fileSize: filesize,
-		tmpFile: file,
-	}, nil
+	ctx.tmpFile = file
+	return ctx, nil
+}
+
+// switchIncr 之后的命令写入新的 incr 文件，返回它的 seq，调用方持有 pausingAof
+func (persister *Persister) switchIncr() (int, error) {
+	seq := persister.manifest.nextSeq(manifestTypeIncr)
+	filename := filepath.Join(persister.aofDir, incrFilename(persister.cfg.AppendFilename, seq))
+	file, err := openAofFile(filename)
+	if err != nil {
+		return 0, err
+	}
+	// 新文件自己选中数据库，加载时不依赖之前的文件
+	data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(persister.currentDB))).ToBytes()
+	if _, err := file.Write(data); err != nil {
+		_ = file.Close()
+		_ = os.Remove(filename)
+		return 0, err
+	}
+	manifest := persister.manifest.clone()
+	manifest.Files = append(manifest.Files, ManifestFile{Name: filepath.Base(filename), Seq: seq, Type: manifestTypeIncr})
+	if err := writeManifest(persister.aofDir, persister.cfg.AppendFilename, manifest); err != nil {
+		_ = file.Close()
+		_ = os.Remove(filename)
+		return 0, err
+	}
+	_ = persister.aofFile.Close()
+	persister.aofFile = file
+	persister.aofFilename = filename
+	persister.manifest = manifest
+	return seq, nil
+}
+
+// finishMultiPartRewrite 新快照成为 base，清单中只保留重写开始之后的 incr 文件，然后删除旧文件
+func (persister *Persister) finishMultiPartRewrite(ctx *RewriteCtx) error {
+	tmpFile := ctx.tmpFile
+	err := tmpFile.Sync()
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	persister.lockAof()
+	defer persister.unlockAof()
+	name := persister.cfg.AppendFilename
+	seq := persister.manifest.nextSeq(manifestTypeBase)
+	base := ManifestFile{Name: baseFilename(name, seq, persister.cfg.AofUseRdbPreamble), Seq: seq, Type: manifestTypeBase}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(persister.aofDir, base.Name)); err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return err
+	}
+	manifest := &Manifest{Files: []ManifestFile{base}}
+	var obsolete []string
+	for _, f := range persister.manifest.Files {
+		if f.Type == manifestTypeIncr && f.Seq >= ctx.incrSeq {
+			manifest.Files = append(manifest.Files, f)
+		} else {
+			obsolete = append(obsolete, f.Name)
+		}
+	}
+	if err := writeManifest(persister.aofDir, name, manifest); err != nil {
+		_ = os.Remove(filepath.Join(persister.aofDir, base.Name))
+		return err
+	}
+	persister.manifest = manifest
+	// 正在生成快照的协程已经打开了旧文件，删除不影响它们读取
+	for _, n := range obsolete {
+		if err := os.Remove(filepath.Join(persister.aofDir, n)); err != nil {
+			slog.Warn("remove old aof file error", "file", n, "error", err)
+		}
+	}
+	return nil
 }
 
 func (persister *Persister) FinishRewrite (ctx *RewriteCtx) { 
-	persister.pausingAof.Lock()
-	defer persister.pausingAof.Unlock()
+	if persister.manifest != nil {
+		if err := persister.finishMultiPartRewrite(ctx); err != nil {
+			slog.Error("finish aof rewrite error", "error", err)
+		}
+		return
+	}
+	persister.lockAof()
+	defer persister.unlockAof()
 	tmpFile := ctx.tmpFile
 
 	// 定位最后写到的位置
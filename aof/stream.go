@@ -0,0 +1,187 @@
+package aof
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/zhangming/go-redis/datastruct/stream"
+)
+
+// 消息流的 rdb 编码，格式与 redis 的 RDB_TYPE_STREAM_LISTPACKS 相同：
+// 消息按顺序分成若干节点，每个节点是一个 listpack，以节点第一条消息的 ID 作为 key。
+// 字段名相同的相邻消息放在同一个节点并标记 SAME_FIELDS，这样加载时可以按照节点的字段名恢复字段的顺序
+
+const (
+	rdbTypeStreamListPacks = 15
+	// 每个节点最多的消息数，与 redis 的 stream-node-max-entries 默认值相同
+	streamNodeMaxEntries = 100
+
+	streamItemFlagSameFields = 1 << 1
+)
+
+// listPack 按 redis listpack 的格式编码元素
+type listPack struct {
+	buf   []byte
+	count int
+}
+
+func newListPack() *listPack {
+	// 4 字节总长度和 2 字节元素个数在 bytes() 中填写
+	return &listPack{buf: make([]byte, 6, 256)}
+}
+
+// appendBackLen 写入元素的长度，用于从后向前遍历
+func (lp *listPack) appendBackLen(l int) {
+	switch {
+	case l <= 127:
+		lp.buf = append(lp.buf, byte(l))
+	case l < 16383:
+		lp.buf = append(lp.buf, byte(l>>7), byte(l&127)|128)
+	case l < 2097151:
+		lp.buf = append(lp.buf, byte(l>>14), byte((l>>7)&127)|128, byte(l&127)|128)
+	case l < 268435455:
+		lp.buf = append(lp.buf, byte(l>>21), byte((l>>14)&127)|128, byte((l>>7)&127)|128, byte(l&127)|128)
+	default:
+		lp.buf = append(lp.buf, byte(l>>28), byte((l>>21)&127)|128, byte((l>>14)&127)|128,
+			byte((l>>7)&127)|128, byte(l&127)|128)
+	}
+}
+
+func (lp *listPack) appendInt(v int64) {
+	beg := len(lp.buf)
+	if v >= 0 && v <= 127 {
+		lp.buf = append(lp.buf, byte(v))
+	} else {
+		lp.buf = append(lp.buf, 0xf4)
+		lp.buf = binary.LittleEndian.AppendUint64(lp.buf, uint64(v))
+	}
+	lp.appendBackLen(len(lp.buf) - beg)
+	lp.count++
+}
+
+func (lp *listPack) appendString(s []byte) {
+	beg := len(lp.buf)
+	switch {
+	case len(s) < 64:
+		lp.buf = append(lp.buf, 0x80|byte(len(s)))
+	case len(s) < 4096:
+		lp.buf = append(lp.buf, 0xe0|byte(len(s)>>8), byte(len(s)))
+	default:
+		lp.buf = append(lp.buf, 0xf0)
+		lp.buf = binary.LittleEndian.AppendUint32(lp.buf, uint32(len(s)))
+	}
+	lp.buf = append(lp.buf, s...)
+	lp.appendBackLen(len(lp.buf) - beg)
+	lp.count++
+}
+
+func (lp *listPack) bytes() []byte {
+	lp.buf = append(lp.buf, 0xff)
+	binary.LittleEndian.PutUint32(lp.buf, uint32(len(lp.buf)))
+	count := lp.count
+	if count > math.MaxUint16 {
+		// 超过 65535 个元素时 redis 同样写入 65535，表示需要遍历才能得到元素个数
+		count = math.MaxUint16
+	}
+	binary.LittleEndian.PutUint16(lp.buf[4:], uint16(count))
+	return lp.buf
+}
+
+// fieldNames 返回消息的字段名
+func fieldNames(entry *stream.Entry) [][]byte {
+	names := make([][]byte, 0, len(entry.Fields)/2)
+	for i := 0; i < len(entry.Fields); i += 2 {
+		names = append(names, entry.Fields[i])
+	}
+	return names
+}
+
+func sameNames(a, b [][]byte) bool {
+	return slices.EqualFunc(a, b, func(x, y []byte) bool {
+		return string(x) == string(y)
+	})
+}
+
+// encodeStreamNode 编码一个节点，entries 中的消息字段名都与 names 相同
+func encodeStreamNode(names [][]byte, entries []*stream.Entry) []byte {
+	master := entries[0].ID
+	lp := newListPack()
+	lp.appendInt(int64(len(entries))) // count
+	lp.appendInt(0)                   // deleted
+	lp.appendInt(int64(len(names)))
+	for _, name := range names {
+		lp.appendString(name)
+	}
+	lp.appendInt(0) // master entry 结束
+	for _, entry := range entries {
+		lp.appendInt(streamItemFlagSameFields)
+		lp.appendInt(int64(entry.ID.Ms - master.Ms))
+		lp.appendInt(int64(entry.ID.Seq - master.Seq))
+		for i := 1; i < len(entry.Fields); i += 2 {
+			lp.appendString(entry.Fields[i])
+		}
+		// lp-count: flag、ms、seq 和所有的值
+		lp.appendInt(int64(3 + len(names)))
+	}
+	return lp.bytes()
+}
+
+// writeStream 写入一个消息流，不包含消费组
+func (cw *canonicalWriter) writeStream(key string, s *stream.Stream, expiration *time.Time) error {
+	if expiration != nil {
+		buf := make([]byte, 9)
+		buf[0] = rdbOpCodeExpireTimeMs
+		binary.LittleEndian.PutUint64(buf[1:], uint64(expiration.UnixNano()/1e6))
+		if _, err := cw.Write(buf); err != nil {
+			return err
+		}
+	}
+	if _, err := cw.Write([]byte{rdbTypeStreamListPacks}); err != nil {
+		return err
+	}
+	if err := cw.writeString([]byte(key)); err != nil {
+		return err
+	}
+
+	type node struct {
+		names   [][]byte
+		entries []*stream.Entry
+	}
+	var nodes []*node
+	s.ForEach(func(entry *stream.Entry) bool {
+		names := fieldNames(entry)
+		if len(nodes) > 0 {
+			last := nodes[len(nodes)-1]
+			if len(last.entries) < streamNodeMaxEntries && sameNames(last.names, names) {
+				last.entries = append(last.entries, entry)
+				return true
+			}
+		}
+		nodes = append(nodes, &node{names: names, entries: []*stream.Entry{entry}})
+		return true
+	})
+	if err := cw.writeLength(uint64(len(nodes))); err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		master := make([]byte, 16)
+		binary.BigEndian.PutUint64(master, n.entries[0].ID.Ms)
+		binary.BigEndian.PutUint64(master[8:], n.entries[0].ID.Seq)
+		if err := cw.writeString(master); err != nil {
+			return err
+		}
+		if err := cw.writeString(encodeStreamNode(n.names, n.entries)); err != nil {
+			return err
+		}
+	}
+	lastID := s.LastID()
+	for _, v := range []uint64{uint64(s.Len()), lastID.Ms, lastID.Seq, 0} {
+		// 长度、最大 ID 和消费组个数
+		if err := cw.writeLength(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
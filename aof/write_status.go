@@ -0,0 +1,132 @@
+package aof
+
+import (
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// aof 写入失败
+// 磁盘写满等情况下 Write 可能只写入了一部分，留在文件中的半条命令会破坏之后追加的数据，
+// 所以写入失败时把文件截断回写入之前的长度。
+// 与 redis 相同，最近一次写入或者刷盘失败之后 WriteOK 返回 false，调用方在执行之前拒绝写命令，内存中的数据不会继续偏离 aof。
+// 之后的写入、刷盘分别成功，或者每秒一次的重试(写入当前数据库的 SELECT 并刷盘)成功时恢复。
+// appendfsync always 时调用方再通过 FailedSince 判断执行期间进入 aof 的命令是否已经落盘，没有落盘的写命令不能确认成功
+
+// writeStatus 记录写入失败的次数和最近一次的错误，状态的变化都在持有 pausingAof 时发生
+type writeStatus struct {
+	failures atomic.Int64
+	lastErr  atomic.Value // string
+	// 最近一次写入失败，之后写入成功时清除
+	writeFailed atomic.Bool
+	// 最近一次刷盘失败，之后刷盘成功时清除
+	syncFailed atomic.Bool
+	// 失败涉及的最大命令编号，刷盘失败时是已经写入的编号
+	failedSeq atomic.Int64
+	// 上一次失败或者重试的时间(unix 毫秒)
+	lastProbe atomic.Int64
+}
+
+const writeProbeInterval = time.Second
+
+// WriteFailures returns number of failed aof writes and fsyncs since start
+func (persister *Persister) WriteFailures() int64 {
+	return persister.writeStatus.failures.Load()
+}
+
+// LastWriteError returns message of the latest failed aof write
+func (persister *Persister) LastWriteError() string {
+	msg, _ := persister.writeStatus.lastErr.Load().(string)
+	return msg
+}
+
+// FsyncAlways 每条命令都在回复前写入并刷盘
+func (persister *Persister) FsyncAlways() bool {
+	return persister.aofFsync == FsyncAlways
+}
+
+// WriteOK reports whether the latest aof write and fsync succeeded, write commands should be refused otherwise.
+// 失败之后每秒最多重试一次，重试成功时返回 true
+func (persister *Persister) WriteOK() bool {
+	status := &persister.writeStatus
+	if !status.writeFailed.Load() && !status.syncFailed.Load() {
+		return true
+	}
+	now := time.Now().UnixMilli()
+	last := status.lastProbe.Load()
+	if now-last < writeProbeInterval.Milliseconds() || !status.lastProbe.CompareAndSwap(last, now) {
+		return false
+	}
+	persister.lockAof()
+	defer persister.unlockAof()
+	seq := persister.watermark.written.Load()
+	data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(persister.currentDB))).ToBytes()
+	if !persister.writeData(data, seq) {
+		return false
+	}
+	return persister.syncData(seq)
+}
+
+// FailedSince returns whether a command accepted after offset failed to be written or fsynced,
+// offset is the QueuedOffset taken before executing the command.
+// 同一时间其他连接的命令失败也会被计入，调用方宁可返回错误也不能确认没有落盘的命令
+func (persister *Persister) FailedSince(offset int64) bool {
+	return persister.writeStatus.failedSeq.Load() > offset
+}
+
+// recordError seq 是没有写入或者没有落盘的最大命令编号
+func (persister *Persister) recordError(failed *atomic.Bool, err error, seq int64) {
+	slog.Error("aof write failed", "error", err)
+	status := &persister.writeStatus
+	status.lastErr.Store(err.Error())
+	for {
+		cur := status.failedSeq.Load()
+		if cur >= seq || status.failedSeq.CompareAndSwap(cur, seq) {
+			break
+		}
+	}
+	// 失败之后一秒再开始重试
+	status.lastProbe.Store(time.Now().UnixMilli())
+	failed.Store(true)
+	status.failures.Add(1)
+}
+
+func (persister *Persister) recover(failed *atomic.Bool) {
+	if failed.Swap(false) {
+		slog.Info("aof write error looks solved, accepting writes again")
+	}
+}
+
+// writeData 写入 aof 文件，失败时去掉已经写入的部分，seq 是数据所属的命令编号，调用方需要持有 pausingAof
+func (persister *Persister) writeData(data []byte, seq int64) bool {
+	status := &persister.writeStatus
+	n, err := persister.aofFile.Write(data)
+	if err == nil {
+		persister.recover(&status.writeFailed)
+		return true
+	}
+	persister.recordError(&status.writeFailed, err, seq)
+	if n > 0 {
+		if info, statErr := persister.aofFile.Stat(); statErr == nil {
+			if truncErr := persister.aofFile.Truncate(info.Size() - int64(n)); truncErr != nil {
+				slog.Error("truncate partial aof write failed", "error", truncErr)
+			}
+		}
+	}
+	return false
+}
+
+// syncData 刷盘，seq 是已经写入的命令编号，调用方需要持有 pausingAof
+func (persister *Persister) syncData(seq int64) bool {
+	status := &persister.writeStatus
+	if err := persister.aofFile.Sync(); err != nil {
+		persister.recordError(&status.syncFailed, err, seq)
+		return false
+	}
+	persister.recover(&status.syncFailed)
+	return true
+}
@@ -0,0 +1,51 @@
+package cluster
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 集群管理命令
+// FLUSHALL [ASYNC|SYNC] CLUSTER 和 CONFIG SET parameter value [parameter value ...] CLUSTER
+// 由收到命令的节点去掉 CLUSTER 参数后转发到所有节点(包括自己)，收集每个节点的确认并汇总成一个回复:
+// 所有节点成功时返回 OK，否则返回失败的节点数以及每个失败节点的错误，已经成功的节点不会回滚
+
+// hasClusterOption 命令的最后一个参数是否为 CLUSTER
+func hasClusterOption(cmdLine CmdLine) bool {
+	return len(cmdLine) > 1 && strings.EqualFold(string(cmdLine[len(cmdLine)-1]), "cluster")
+}
+
+// execConfig 带 CLUSTER 参数的 CONFIG SET 在所有节点上执行，其它 CONFIG 子命令只作用于当前节点
+func execConfig(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) > 1 && strings.EqualFold(string(cmdLine[1]), "set") && hasClusterOption(cmdLine) {
+		return execClusterAdmin(cluster, c, cmdLine[:len(cmdLine)-1])
+	}
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// execClusterAdmin 广播 cmdLine 并汇总所有节点的回复
+func execClusterAdmin(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	replies := cluster.broadcast(c, cmdLine)
+	var failures []string
+	for _, node := range cluster.nodes {
+		if reply := replies[node]; reply == nil || protocol.IsErrorReply(reply) {
+			failures = append(failures, node+": "+replyMessage(reply))
+		}
+	}
+	if len(failures) == 0 {
+		return protocol.MakeOkReply()
+	}
+	return protocol.MakeErrReply("ERR " + strings.ToUpper(string(cmdLine[0])) + " failed on " +
+		strconv.Itoa(len(failures)) + " of " + strconv.Itoa(len(cluster.nodes)) + " nodes: " + strings.Join(failures, "; "))
+}
+
+// replyMessage 返回错误回复去掉 '-' 和换行之后的内容
+func replyMessage(reply redis.Reply) string {
+	if reply == nil {
+		return "no reply"
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(reply.ToBytes()), "-"))
+}
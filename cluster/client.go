@@ -0,0 +1,125 @@
+package cluster
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/client"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 到其他节点的连接
+// 每个节点只保持一个 pipeline 客户端，客户端记录着连接当前选择的数据库(重连后恢复)，
+// 只有转发的客户端的数据库与它不同时才先发送 SELECT，SELECT 和命令必须连续发送，
+// 所以同一个节点上的转发是串行的
+
+type peerClient struct {
+	mu     sync.Mutex
+	client *client.Client
+}
+
+type peerClients struct {
+	mu      sync.Mutex
+	cfg     *config.ServerProperties
+	clients map[string]*peerClient
+}
+
+func makePeerClients(cfg *config.ServerProperties) *peerClients {
+	return &peerClients{
+		cfg:     cfg,
+		clients: make(map[string]*peerClient),
+	}
+}
+
+// get 返回到 peer 的连接，连接不存在或者已经放弃重连时重新建立连接
+func (peers *peerClients) get(peer string) (*peerClient, error) {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+	if pc, ok := peers.clients[peer]; ok && !pc.client.Closed() {
+		return pc, nil
+	}
+	c, err := client.MakeClient(peer)
+	if err != nil {
+		return nil, err
+	}
+	c.Start()
+	if peers.cfg.RequirePass != "" {
+		// 集群中的节点使用相同的密码
+		ret := c.Send(utils.ToCmdLine("AUTH", peers.cfg.RequirePass))
+		if protocol.IsErrorReply(ret) {
+			c.Close()
+			return nil, errors.New("auth failed")
+		}
+	}
+	pc := &peerClient{client: c}
+	peers.clients[peer] = pc
+	return pc, nil
+}
+
+func (peers *peerClients) close() {
+	peers.mu.Lock()
+	defer peers.mu.Unlock()
+	for _, pc := range peers.clients {
+		if !pc.client.Closed() {
+			pc.client.Close()
+		}
+	}
+	peers.clients = make(map[string]*peerClient)
+}
+
+// send 在 peer 上以 dbIndex 为当前数据库执行命令
+func (pc *peerClient) send(dbIndex int, cmdLine CmdLine) redis.Reply {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.client.DB() != dbIndex {
+		// SELECT 失败时不能在原来的数据库上执行命令，所以不和命令放在同一个 pipeline 中
+		ret := pc.client.Send(utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex)))
+		if protocol.IsErrorReply(ret) {
+			return ret
+		}
+	}
+	return pc.client.Send(cmdLine)
+}
+
+// relay 把命令转发到 peer 执行，peer 为当前节点时直接在本地执行
+func (cluster *Cluster) relay(peer string, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if peer == cluster.self {
+		return cluster.db.Exec(c, cmdLine)
+	}
+	pc, err := cluster.peers.get(peer)
+	if err != nil {
+		return protocol.MakeErrReply("ERR connect to peer " + peer + " failed: " + err.Error())
+	}
+	return pc.send(c.GetDBIndex(), cmdLine)
+}
+
+// broadcast 在所有节点上并发执行命令，等待所有节点回复后返回每个节点的回复。
+// 发往其他节点的命令加上 "_" 前缀，对端只在本地执行，不会再次广播
+func (cluster *Cluster) broadcast(c redis.Connection, cmdLine CmdLine) map[string]redis.Reply {
+	relayed := make(CmdLine, len(cmdLine))
+	copy(relayed, cmdLine)
+	relayed[0] = append([]byte(internalPrefix), cmdLine[0]...)
+	replies := make([]redis.Reply, len(cluster.nodes))
+	var wg sync.WaitGroup
+	for i, node := range cluster.nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if node == cluster.self {
+				replies[i] = cluster.db.Exec(c, cmdLine)
+			} else {
+				replies[i] = cluster.relay(node, c, relayed)
+			}
+		}()
+	}
+	wg.Wait()
+	result := make(map[string]redis.Reply, len(cluster.nodes))
+	for i, node := range cluster.nodes {
+		result[node] = replies[i]
+	}
+	return result
+}
@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"runtime/debug"
+	"strings"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/database"
+	idatabase "github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/consistenthash"
+	"github.com/zhangming/go-redis/lib/metrics"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 一致性哈希集群
+// 每个节点都是完整的 database.Server，Cluster 位于它前面，按照 key 的一致性哈希把命令转发到负责的节点。
+// 所有节点必须配置相同的节点列表(self + peers)，这样转发到对端的命令在对端会被路由到它自己执行。
+// 与 redis cluster 不同，这里没有槽位和 MOVED 重定向，客户端可以连接任意节点。
+
+// 每个节点在哈希环上的虚拟节点个数
+const replicas = 4
+
+// CmdLine is alias for [][]byte, represents a command line
+type CmdLine = [][]byte
+
+// Cluster represents a node of godis cluster
+type Cluster struct {
+	self       string
+	nodes      []string
+	peerPicker *consistenthash.Map
+	peers      *peerClients
+	db         idatabase.DBEngine
+	cfg        *config.ServerProperties
+}
+
+// MakeCluster creates and starts a node of cluster
+func MakeCluster() *Cluster {
+	return MakeClusterWithConfig(config.Properties)
+}
+
+// MakeClusterWithConfig creates a cluster node whose local server uses the given config
+func MakeClusterWithConfig(cfg *config.ServerProperties) *Cluster {
+	return makeCluster(cfg, database.NewStandaloneServerWithConfig(cfg))
+}
+
+func makeCluster(cfg *config.ServerProperties, db idatabase.DBEngine) *Cluster {
+	self := cfg.Self
+	if self == "" {
+		self = cfg.AnnounceAddress()
+	}
+	nodes := make([]string, 0, len(cfg.Peers)+1)
+	nodes = append(nodes, self)
+	for _, peer := range cfg.Peers {
+		if peer != self {
+			nodes = append(nodes, peer)
+		}
+	}
+	peerPicker := consistenthash.New(replicas, nil)
+	peerPicker.AddNode(nodes...)
+	return &Cluster{
+		self:       self,
+		nodes:      nodes,
+		peerPicker: peerPicker,
+		peers:      makePeerClients(cfg),
+		db:         db,
+		cfg:        cfg,
+	}
+}
+
+// Exec executes command on cluster
+func (cluster *Cluster) Exec(c redis.Connection, cmdLine [][]byte) (result redis.Reply) {
+	defer func() {
+		if err := recover(); err != nil {
+			slog.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
+			result = &protocol.UnknownErrReply{}
+		}
+	}()
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdFunc, ok := router[cmdName]; ok {
+		return cmdFunc(cluster, c, cmdLine)
+	}
+	return defaultFunc(cluster, c, cmdLine)
+}
+
+// AfterClientClose does some clean after client close connection
+func (cluster *Cluster) AfterClientClose(c redis.Connection) {
+	cluster.db.AfterClientClose(c)
+}
+
+// AfterClientConnect 连接登记在本节点上
+func (cluster *Cluster) AfterClientConnect(c redis.Connection) {
+	if tracker, ok := cluster.db.(idatabase.ClientTracker); ok {
+		tracker.AfterClientConnect(c)
+	}
+}
+
+// CancelBlocking 结束连接在本节点上的阻塞命令
+func (cluster *Cluster) CancelBlocking(c redis.Connection) {
+	if canceler, ok := cluster.db.(idatabase.BlockingCanceler); ok {
+		canceler.CancelBlocking(c)
+	}
+}
+
+// AcceptConnection 按本节点的配置检查新连接
+func (cluster *Cluster) AcceptConnection(addr net.Addr) redis.Reply {
+	if filter, ok := cluster.db.(idatabase.ConnectionFilter); ok {
+		return filter.AcceptConnection(addr)
+	}
+	return nil
+}
+
+// RecordNetInput 流量统计在本节点上
+func (cluster *Cluster) RecordNetInput(n int) {
+	if recorder, ok := cluster.db.(idatabase.StatsRecorder); ok {
+		recorder.RecordNetInput(n)
+	}
+}
+
+// RecordNetOutput 流量统计在本节点上
+func (cluster *Cluster) RecordNetOutput(n int) {
+	if recorder, ok := cluster.db.(idatabase.StatsRecorder); ok {
+		recorder.RecordNetOutput(n)
+	}
+}
+
+// StatsSnapshot 返回本节点的统计信息
+func (cluster *Cluster) StatsSnapshot() map[string]float64 {
+	if recorder, ok := cluster.db.(idatabase.StatsRecorder); ok {
+		return recorder.StatsSnapshot()
+	}
+	return nil
+}
+
+// HotKeys 返回本节点的热点 key
+func (cluster *Cluster) HotKeys(count int) []idatabase.HotKey {
+	if reporter, ok := cluster.db.(idatabase.HotKeyReporter); ok {
+		return reporter.HotKeys(count)
+	}
+	return nil
+}
+
+// CollectMetrics 返回本节点的指标
+func (cluster *Cluster) CollectMetrics() []metrics.Family {
+	if collector, ok := cluster.db.(idatabase.MetricsCollector); ok {
+		return collector.CollectMetrics()
+	}
+	return nil
+}
+
+// ShutdownRequested 本节点执行 SHUTDOWN 之后 close
+func (cluster *Cluster) ShutdownRequested() <-chan struct{} {
+	if notifier, ok := cluster.db.(idatabase.ShutdownNotifier); ok {
+		return notifier.ShutdownRequested()
+	}
+	return nil
+}
+
+// Close stops current node of cluster
+func (cluster *Cluster) Close() {
+	cluster.peers.close()
+	cluster.db.Close()
+}
+
+// pickNode 返回负责 key 的节点
+func (cluster *Cluster) pickNode(key string) string {
+	return cluster.peerPicker.PickNode(key)
+}
+
+// groupByNode 按照负责的节点对 key 分组
+func (cluster *Cluster) groupByNode(keys []string) map[string][]string {
+	result := make(map[string][]string)
+	for _, key := range keys {
+		node := cluster.pickNode(key)
+		result[node] = append(result[node], key)
+	}
+	return result
+}
@@ -0,0 +1,232 @@
+package cluster
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+	"github.com/zhangming/go-redis/redis/server/std"
+	"github.com/zhangming/go-redis/tcp"
+)
+
+// startNodes 启动 n 个互为 peers 的节点，返回每个节点的 Cluster
+func startNodes(t *testing.T, n int) []*Cluster {
+	listeners := make([]net.Listener, n)
+	addrs := make([]string, n)
+	for i := range listeners {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = listener
+		addrs[i] = listener.Addr().String()
+	}
+	nodes := make([]*Cluster, n)
+	for i, listener := range listeners {
+		cfg := &config.ServerProperties{
+			Databases:     16,
+			ClusterEnable: true,
+			Self:          addrs[i],
+			Peers:         addrs,
+		}
+		nodes[i] = MakeClusterWithConfig(cfg)
+		handler := std.MakeHandlerWithDB(nodes[i])
+		closeChan := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			tcp.ListenAndServe(listener, handler, closeChan)
+		}()
+		t.Cleanup(func() {
+			close(closeChan)
+			<-done
+		})
+	}
+	return nodes
+}
+
+func TestCluster(t *testing.T) {
+	nodes := startNodes(t, 3)
+	conn := connection.NewFakeConn()
+	node := nodes[0]
+
+	var keys []string
+	args := []string{"MSET"}
+	for i := 0; i < 20; i++ {
+		key := "key" + strconv.Itoa(i)
+		keys = append(keys, key)
+		args = append(args, key, strconv.Itoa(i))
+	}
+	if ret := node.Exec(conn, utils.ToCmdLine(args...)); !protocol.IsOKReply(ret) {
+		t.Fatalf("mset: %q", ret.ToBytes())
+	}
+	// key 分布在不同的节点上
+	owners := node.groupByNode(keys)
+	if len(owners) < 2 {
+		t.Fatalf("expected keys on several nodes, got %v", owners)
+	}
+	for owner, group := range owners {
+		for _, n := range nodes {
+			if n.self != owner {
+				continue
+			}
+			ret := n.db.Exec(connection.NewFakeConn(), utils.ToCmdLine2("EXISTS", group...))
+			if intReply, ok := ret.(*protocol.IntReply); !ok || intReply.Code != int64(len(group)) {
+				t.Fatalf("keys %v are not stored on %s: %q", group, owner, ret.ToBytes())
+			}
+		}
+	}
+
+	// 每个节点都可以读到所有 key
+	for _, n := range nodes {
+		ret := n.Exec(conn, utils.ToCmdLine("GET", "key7"))
+		if bulk, ok := ret.(*protocol.BulkReply); !ok || string(bulk.Arg) != "7" {
+			t.Fatalf("get from %s: %q", n.self, ret.ToBytes())
+		}
+	}
+	ret := node.Exec(conn, utils.ToCmdLine("MGET", "key3", "missing", "key11"))
+	multiBulk, ok := ret.(*protocol.MultiBulkReply)
+	if !ok || string(multiBulk.Args[0]) != "3" || multiBulk.Args[1] != nil || string(multiBulk.Args[2]) != "11" {
+		t.Fatalf("mget: %q", ret.ToBytes())
+	}
+	ret = node.Exec(conn, utils.ToCmdLine2("DEL", keys[:10]...))
+	if intReply, ok := ret.(*protocol.IntReply); !ok || intReply.Code != 10 {
+		t.Fatalf("del: %q", ret.ToBytes())
+	}
+	ret = node.Exec(conn, utils.ToCmdLine2("EXISTS", keys...))
+	if intReply, ok := ret.(*protocol.IntReply); !ok || intReply.Code != 10 {
+		t.Fatalf("exists: %q", ret.ToBytes())
+	}
+
+	// 转发时保留客户端选择的数据库
+	node.Exec(conn, utils.ToCmdLine("SELECT", "3"))
+	node.Exec(conn, utils.ToCmdLine("SET", "key15", "db3"))
+	node.Exec(conn, utils.ToCmdLine("SELECT", "0"))
+	ret = node.Exec(conn, utils.ToCmdLine("GET", "key15"))
+	if bulk, ok := ret.(*protocol.BulkReply); !ok || string(bulk.Arg) != "15" {
+		t.Fatalf("get db0: %q", ret.ToBytes())
+	}
+
+	// 多个 key 必须在同一个节点，hash tag 可以把 key 放在一起
+	var a, b string
+	for i := 0; a == "" || b == ""; i++ {
+		key := "k" + strconv.Itoa(i)
+		if node.pickNode(key) == node.pickNode("k0") {
+			a = key
+		} else {
+			b = key
+		}
+	}
+	ret = node.Exec(conn, utils.ToCmdLine("RENAME", a, b))
+	if !protocol.IsErrorReply(ret) {
+		t.Fatalf("expected cross node error, got %q", ret.ToBytes())
+	}
+	node.Exec(conn, utils.ToCmdLine("SET", "{user}:1", "v"))
+	if ret := node.Exec(conn, utils.ToCmdLine("RENAME", "{user}:1", "{user}:2")); !protocol.IsOKReply(ret) {
+		t.Fatalf("rename with hash tag: %q", ret.ToBytes())
+	}
+
+	if ret := node.Exec(conn, utils.ToCmdLine("MULTI")); !protocol.IsErrorReply(ret) {
+		t.Fatalf("expected multi to be rejected, got %q", ret.ToBytes())
+	}
+
+	// FLUSHALL 清空所有节点
+	if ret := nodes[1].Exec(conn, utils.ToCmdLine("FLUSHALL")); !protocol.IsOKReply(ret) {
+		t.Fatalf("flushall: %q", ret.ToBytes())
+	}
+	for _, n := range nodes {
+		if ret := n.db.Exec(connection.NewFakeConn(), utils.ToCmdLine("DBSIZE")); string(ret.ToBytes()) != ":0\r\n" {
+			t.Fatalf("%s is not empty after flushall: %q", n.self, ret.ToBytes())
+		}
+	}
+}
+
+// 转发只在数据库改变时发送 SELECT
+func TestRelaySelect(t *testing.T) {
+	nodes := startNodes(t, 2)
+	node := nodes[0]
+	var key string
+	for i := 0; key == ""; i++ {
+		if candidate := "key" + strconv.Itoa(i); node.peerPicker.PickNode(candidate) == nodes[1].self {
+			key = candidate
+		}
+	}
+	conn := connection.NewFakeConn()
+	for i := 0; i < 3; i++ {
+		node.Exec(conn, utils.ToCmdLine("SET", key, "0"))
+	}
+	node.Exec(conn, utils.ToCmdLine("SELECT", "2"))
+	for i := 0; i < 3; i++ {
+		node.Exec(conn, utils.ToCmdLine("SET", key, "2"))
+	}
+	node.Exec(connection.NewFakeConn(), utils.ToCmdLine("SET", key, "0"))
+
+	peer := nodes[1].db
+	info := string(peer.Exec(connection.NewFakeConn(), utils.ToCmdLine("INFO", "commandstats")).ToBytes())
+	if !strings.Contains(info, "cmdstat_select:calls=2,") || !strings.Contains(info, "cmdstat_set:calls=7,") {
+		t.Fatalf("unexpected commandstats %q", info)
+	}
+	peerConn := connection.NewFakeConn()
+	for _, db := range []string{"0", "2"} {
+		peer.Exec(peerConn, utils.ToCmdLine("SELECT", db))
+		if ret := string(peer.Exec(peerConn, utils.ToCmdLine("GET", key)).ToBytes()); ret != "$1\r\n"+db+"\r\n" {
+			t.Errorf("unexpected value in db %s: %q", db, ret)
+		}
+	}
+}
+
+func TestClusterPublish(t *testing.T) {
+	nodes := startNodes(t, 2)
+	subscriber := connection.NewFakeConn()
+	nodes[1].Exec(subscriber, utils.ToCmdLine("SUBSCRIBE", "ch"))
+	ret := nodes[0].Exec(connection.NewFakeConn(), utils.ToCmdLine("PUBLISH", "ch", "hello"))
+	if intReply, ok := ret.(*protocol.IntReply); !ok || intReply.Code != 1 {
+		t.Fatalf("publish: %q", ret.ToBytes())
+	}
+}
+
+func TestClusterAdmin(t *testing.T) {
+	nodes := startNodes(t, 3)
+	conn := connection.NewFakeConn()
+	ret := nodes[0].Exec(conn, utils.ToCmdLine("CONFIG", "SET", "slowlog-max-len", "5", "slowlog-log-slower-than", "100", "CLUSTER"))
+	if !protocol.IsOKReply(ret) {
+		t.Fatalf("config set cluster: %q", ret.ToBytes())
+	}
+	for _, n := range nodes {
+		ret := n.db.Exec(connection.NewFakeConn(), utils.ToCmdLine("CONFIG", "GET", "slowlog-*"))
+		if string(ret.ToBytes()) != "*4\r\n$23\r\nslowlog-log-slower-than\r\n$3\r\n100\r\n$15\r\nslowlog-max-len\r\n$1\r\n5\r\n" {
+			t.Fatalf("config on %s is not changed: %q", n.self, ret.ToBytes())
+		}
+	}
+	// 不带 CLUSTER 时只修改当前节点
+	nodes[0].Exec(conn, utils.ToCmdLine("CONFIG", "SET", "slowlog-max-len", "7"))
+	ret = nodes[1].db.Exec(connection.NewFakeConn(), utils.ToCmdLine("CONFIG", "GET", "slowlog-max-len"))
+	if string(ret.ToBytes()) != "*2\r\n$15\r\nslowlog-max-len\r\n$1\r\n5\r\n" {
+		t.Fatalf("config set without CLUSTER should be local: %q", ret.ToBytes())
+	}
+	ret = nodes[0].Exec(conn, utils.ToCmdLine("CONFIG", "SET", "nosuchparam", "1", "CLUSTER"))
+	if !protocol.IsErrorReply(ret) || !strings.Contains(string(ret.ToBytes()), "CONFIG failed on 3 of 3 nodes") {
+		t.Fatalf("expected summarized error, got %q", ret.ToBytes())
+	}
+
+	// 只有一个节点拒绝时回复中只有这个节点
+	nodes[0].Exec(conn, utils.ToCmdLine("SET", "k", "v"))
+	nodes[2].cfg.FlushRequireAsync = true
+	ret = nodes[0].Exec(conn, utils.ToCmdLine("FLUSHALL", "CLUSTER"))
+	if !protocol.IsErrorReply(ret) || !strings.Contains(string(ret.ToBytes()), "FLUSHALL failed on 1 of 3 nodes: "+nodes[2].self+": ERR FLUSHALL without ASYNC") {
+		t.Fatalf("expected flushall to fail on %s, got %q", nodes[2].self, ret.ToBytes())
+	}
+	if ret := nodes[1].Exec(conn, utils.ToCmdLine("FLUSHALL", "ASYNC", "CLUSTER")); !protocol.IsOKReply(ret) {
+		t.Fatalf("flushall async cluster: %q", ret.ToBytes())
+	}
+	for _, n := range nodes {
+		if ret := n.db.Exec(connection.NewFakeConn(), utils.ToCmdLine("DBSIZE")); string(ret.ToBytes()) != ":0\r\n" {
+			t.Fatalf("%s is not empty after flushall: %q", n.self, ret.ToBytes())
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"strings"
+
+	"github.com/zhangming/go-redis/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// CmdFunc represents the handler of a redis command in cluster
+type CmdFunc func(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply
+
+// 广播到其他节点的命令使用的前缀，见 broadcast
+const internalPrefix = "_"
+
+var router = makeRouter()
+
+func makeRouter() map[string]CmdFunc {
+	routerMap := make(map[string]CmdFunc)
+	// 与 key 无关或者只作用于当前节点的命令
+	for _, name := range []string{"ping", "auth", "hello", "info", "select", "command", "dbsize", "subscribe", "unsubscribe",
+		"psubscribe", "punsubscribe", "bgrewriteaof", "rewriteaof", "save", "bgsave", "lastsave", "debug", "keys", "scan", "randomkey",
+		"cluster", "asking", "hotkeys", "client", "psync", "sync", "replconf", "slaveof", "replicaof", "sentinel",
+		"expirepattern", "persistpattern", "monitor", "acl", "shutdown"} {
+		routerMap[name] = execLocal
+	}
+	// 事务中的 key 可能属于不同的节点
+	for _, name := range []string{"multi", "exec", "discard", "watch", "unwatch"} {
+		routerMap[name] = execUnsupported
+	}
+	for _, name := range []string{"flushdb", "flushall"} {
+		routerMap[name] = execFlush
+		routerMap[internalPrefix+name] = execInternal
+	}
+	routerMap["config"] = execConfig
+	routerMap[internalPrefix+"config"] = execInternal
+	routerMap["publish"] = execPublish
+	routerMap[internalPrefix+"publish"] = execInternal
+
+	for _, name := range []string{"blpop", "brpop", "blmove", "brpoplpush"} {
+		routerMap[name] = execBlocking
+	}
+	routerMap["xread"] = execXRead
+
+	routerMap["del"] = execSumKeys
+	routerMap["unlink"] = execSumKeys
+	routerMap["exists"] = execSumKeys
+	routerMap["mget"] = execMGet
+	routerMap["mset"] = execMSet
+	return routerMap
+}
+
+// defaultFunc 把命令转发到负责它的 key 的节点，所有 key 必须属于同一个节点
+func defaultFunc(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	write, read := database.GetRelatedKeys(cmdLine)
+	keys := append(append([]string{}, write...), read...)
+	if len(keys) == 0 {
+		// 未知命令或者参数错误，由本地返回错误
+		return cluster.db.Exec(c, cmdLine)
+	}
+	node := cluster.pickNode(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.pickNode(key) != node {
+			return protocol.MakeErrReply("ERR keys in request don't hash to the same node, use {hashtag} to group them")
+		}
+	}
+	return cluster.relay(node, c, cmdLine)
+}
+
+// execBlocking 阻塞命令只能在 key 所在的节点上等待，转发给其他节点时会超过转发的超时时间
+func execBlocking(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	write, read := database.GetRelatedKeys(cmdLine)
+	for _, key := range append(write, read...) {
+		if node := cluster.pickNode(key); node != cluster.self {
+			return protocol.MakeErrReply("ERR blocking command on key '" + key + "' must be sent to node " + node)
+		}
+	}
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// execXRead 带 BLOCK 的 XREAD 按阻塞命令处理，否则正常转发
+func execXRead(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	for _, arg := range cmdLine[1:] {
+		switch strings.ToUpper(string(arg)) {
+		case "BLOCK":
+			return execBlocking(cluster, c, cmdLine)
+		case "STREAMS":
+			return defaultFunc(cluster, c, cmdLine)
+		}
+	}
+	return defaultFunc(cluster, c, cmdLine)
+}
+
+func execLocal(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	return cluster.db.Exec(c, cmdLine)
+}
+
+func execUnsupported(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	return protocol.MakeErrReply("ERR command '" + strings.ToLower(string(cmdLine[0])) + "' is not supported in cluster mode")
+}
+
+// execInternal 执行其他节点广播过来的命令
+func execInternal(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	local := make(CmdLine, len(cmdLine))
+	copy(local, cmdLine)
+	local[0] = cmdLine[0][len(internalPrefix):]
+	return cluster.db.Exec(c, local)
+}
+
+// execFlush 在所有节点上执行 FLUSHDB/FLUSHALL，带 CLUSTER 参数时汇总所有失败的节点
+func execFlush(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if hasClusterOption(cmdLine) {
+		return execClusterAdmin(cluster, c, cmdLine[:len(cmdLine)-1])
+	}
+	replies := cluster.broadcast(c, cmdLine)
+	for _, node := range cluster.nodes {
+		if reply := replies[node]; protocol.IsErrorReply(reply) {
+			return protocol.MakeErrReply("ERR flush failed on " + node + ": " + strings.TrimPrefix(replyMessage(reply), "ERR "))
+		}
+	}
+	return protocol.MakeOkReply()
+}
+
+// execPublish 订阅者可能连接在任意节点，所以消息需要发布到所有节点，返回收到消息的订阅者总数
+func execPublish(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) != 3 {
+		return protocol.MakeArgNumErrReply("publish")
+	}
+	var count int64
+	for _, reply := range cluster.broadcast(c, cmdLine) {
+		if intReply, ok := reply.(*protocol.IntReply); ok {
+			count += intReply.Code
+		}
+	}
+	return protocol.MakeIntReply(count)
+}
+
+// execSumKeys 按节点拆分 DEL/UNLINK/EXISTS，返回各节点结果之和
+func execSumKeys(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+	keys := make([]string, len(cmdLine)-1)
+	for i, arg := range cmdLine[1:] {
+		keys[i] = string(arg)
+	}
+	var count int64
+	for node, group := range cluster.groupByNode(keys) {
+		reply := cluster.relay(node, c, makeCmdLine(cmdLine[0], group))
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		if intReply, ok := reply.(*protocol.IntReply); ok {
+			count += intReply.Code
+		}
+	}
+	return protocol.MakeIntReply(count)
+}
+
+// execMGet 按节点拆分 MGET，按照请求中 key 的顺序合并结果
+func execMGet(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("mget")
+	}
+	keys := make([]string, len(cmdLine)-1)
+	for i, arg := range cmdLine[1:] {
+		keys[i] = string(arg)
+	}
+	values := make(map[string][]byte)
+	for node, group := range cluster.groupByNode(keys) {
+		reply := cluster.relay(node, c, makeCmdLine(cmdLine[0], group))
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		multiBulk, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(group) {
+			return protocol.MakeErrReply("ERR unexpected reply from " + node)
+		}
+		for i, key := range group {
+			values[key] = multiBulk.Args[i]
+		}
+	}
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = values[key]
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// execMSet 按节点拆分 MSET。不同节点上的写入不是原子的，
+// 某个节点失败时其他节点上已经写入的 key 不会回滚，需要原子写入时使用 {hashtag} 把 key 放在同一个节点
+func execMSet(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	args := cmdLine[1:]
+	if len(args) == 0 || len(args)%2 != 0 {
+		return protocol.MakeArgNumErrReply("mset")
+	}
+	groups := make(map[string]CmdLine)
+	for i := 0; i < len(args); i += 2 {
+		node := cluster.pickNode(string(args[i]))
+		if groups[node] == nil {
+			groups[node] = CmdLine{cmdLine[0]}
+		}
+		groups[node] = append(groups[node], args[i], args[i+1])
+	}
+	for node, group := range groups {
+		reply := cluster.relay(node, c, group)
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+	}
+	return protocol.MakeOkReply()
+}
+
+func makeCmdLine(name []byte, keys []string) CmdLine {
+	result := make(CmdLine, 0, len(keys)+1)
+	result = append(result, name)
+	for _, key := range keys {
+		result = append(result, []byte(key))
+	}
+	return result
+}
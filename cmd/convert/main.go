@@ -0,0 +1,40 @@
+// convert 把更新版本写入的 aof/rdb 文件改写成旧版本的格式，用于滚动降级
+//
+//	convert -target 1 -in appendonly.aof -out appendonly.v1.aof
+//
+// 目标版本不支持的数据(例如版本 1 中的消息流)会被丢弃，丢弃的数量打印在结果中
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zhangming/go-redis/aof"
+)
+
+func main() {
+	in := flag.String("in", "", "aof or rdb file to convert")
+	out := flag.String("out", "", "output file")
+	target := flag.Int("target", aof.MinFormatVersion, "target format version")
+	flag.Parse()
+	if *in == "" || *out == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *in == *out {
+		fmt.Fprintln(os.Stderr, "convert: -in and -out must be different files")
+		os.Exit(2)
+	}
+	result, err := aof.ConvertFile(*in, *out, *target)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "convert:", err)
+		os.Exit(1)
+	}
+	if result.From == result.To {
+		fmt.Printf("%s is already format version %d, copied to %s\n", *in, result.From, *out)
+		return
+	}
+	fmt.Printf("converted %s from format version %d to %d: %s, %d unsupported keys/commands dropped\n",
+		*in, result.From, result.To, *out, result.Dropped)
+}
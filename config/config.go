@@ -2,8 +2,9 @@ package config
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"io"
-	"log/slog"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -31,24 +32,138 @@ type ServerProperties struct {
 	AppendFilename    string `cfg:"appendfilename"`
 	AppendFsync       string `cfg:"appendfsync"`
 	AofUseRdbPreamble bool   `cfg:"aof-use-rdb-preamble"`
-	MaxClients        int    `cfg:"maxclients"`
-	RequirePass       string `cfg:"requirepass"`
-	Databases         int    `cfg:"databases"`
-	RDBFilename       string `cfg:"dbfilename"`
-	MasterAuth        string `cfg:"masterauth"`
+	// 生成 rdb 时按 key 排序并且不写 ctime，相同的数据生成的 rdb 完全相同
+	RdbDeterministic bool `cfg:"rdb-deterministic"`
+	// 启动加载 aof 时打印进度的间隔(秒)，0 表示使用默认值 5，负数表示不打印
+	AofLoadProgressInterval int `cfg:"aof-load-progress-interval"`
+	// 启动时在后台加载 aof，加载期间已经加载完的数据库可以执行读命令
+	AofLoadLazy bool `cfg:"aof-load-lazy"`
+	// aof 最后一条命令不完整时，yes(默认)截断到最后一条完整的命令后加载，no 拒绝启动
+	AofLoadTruncated string `cfg:"aof-load-truncated"`
+	// 设置后 aof 保存在这个目录中，由清单记录 base 文件和增量文件，重写时不需要复制旧文件的尾部；
+	// 为空时使用单个 aof 文件
+	AppendDirname string `cfg:"appenddirname"`
+	// 按槽位范围拆分 aof，例如 0-8191,8192-16383，每个范围一个 aof 文件。
+	// 命令按 key 的槽位写入分区，所以需要 cluster-enabled 并且不能配置 peers，跨槽位的命令返回 CROSSSLOT
+	AofSlotPartitions []string `cfg:"aof-slot-partitions"`
+	// 合并 aof 命令的窗口(毫秒)，窗口内同一个 key 上连续的 SET/INCR 只写入最终状态，
+	// 以重放粒度和最多一个窗口的数据换取更小的 aof 文件，0 表示不合并。appendfsync always 时不生效
+	AofCoalesceWindow int `cfg:"aof-coalesce-window"`
+	// 自动生成 rdb 快照的规则 "<seconds> <changes> ..."，距离上次保存至少 seconds 秒并且至少有 changes 次修改时
+	// 在后台保存，为空表示不自动保存
+	Save string `cfg:"save"`
+	// 关闭时等待执行中的命令结束以及等待后台保存完成的最长时间(秒)，0 表示使用默认值 10
+	ShutdownTimeout int `cfg:"shutdown-timeout"`
+	MaxClients      int `cfg:"maxclients"`
+	// 每个数据库的字典分片数，0 表示根据 GOMAXPROCS 自动推导，见 Tuning
+	ShardCount int `cfg:"shard-count"`
+	// 平均每个分片的 key 数超过这个值时在后台把数据库字典的分片数翻倍(最多 65536)，0 表示不自动扩容
+	ShardMaxLoad int `cfg:"shard-max-load"`
+	// 同时执行数据命令的连接数上限，0 表示根据 GOMAXPROCS 和 maxclients 自动推导
+	WorkerPoolSize int `cfg:"worker-pool-size"`
+	// 每个连接解析请求的缓冲区字节数，0 表示根据 maxclients 自动推导
+	ReadBufferSize int `cfg:"read-buffer-size"`
+	// 连接的输出缓冲限制 "<class> <hard> <soft> <soft seconds> ..."，class 为 normal、replica、pubsub，
+	// 超过限制的连接被断开，没有配置的类型使用 redis 的默认值，见 ParseOutputBufferLimits
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit"`
+	// 连接的处理方式: goroutine(默认)每个连接一个协程; epoll 用事件循环监听所有连接，可读时才启动协程，
+	// 大量空闲连接时占用的内存更少，只支持 linux
+	ServerMode string `cfg:"server-mode"`
+	// HGETALL/SMEMBERS/LRANGE 等命令一次最多返回的元素个数，0 表示不限制
+	MaxReplyElements int `cfg:"max-reply-elements"`
+	// 用 HELLO ... COMPRESS lz4 开启压缩的连接，不短于该值(字节)的 bulk string 压缩后发送，0 表示使用默认值 1024
+	ReplyCompressThreshold int    `cfg:"reply-compress-threshold"`
+	RequirePass            string `cfg:"requirepass"`
+	// 字符串的最大长度，0 表示使用默认值 512MB
+	ProtoMaxBulkLen int `cfg:"proto-max-bulk-len"`
+	// keyspace 通知的类型，与 redis 的 notify-keyspace-events 相同，为空表示关闭
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+	// 所有连接共用的 ACL 规则，例如 "+@all -@dangerous"，为空表示不限制
+	AclDefaultRules string `cfg:"acl-default-rules"`
+	// 命名用户，逗号分隔，每个用户为 "<name> <password> [maxclients N] [maxinflight N]"，
+	// 用 AUTH username password 登录，maxclients/maxinflight 限制同时登录的连接数和执行中的命令数
+	AclUsers []string `cfg:"acl-users"`
+	// 配置预设，例如 production，预设中的配置项在配置文件没有显式配置时生效
+	Profile string `cfg:"profile"`
+	// 没有设置 requirepass 时只接受来自本机的连接
+	ProtectedMode bool `cfg:"protected-mode"`
+	// 禁止执行的命令，例如 keys,debug
+	DenyCommands []string `cfg:"deny-commands"`
+	// FLUSHALL/FLUSHDB 必须带 ASYNC 参数
+	FlushRequireAsync bool `cfg:"flush-require-async"`
+	// 执行时间超过该值(微秒)的命令记入慢日志，0 表示不记录
+	SlowlogLogSlowerThan int `cfg:"slowlog-log-slower-than"`
+	// 慢日志保留的条数，0 表示使用默认值 128
+	SlowlogMaxLen int `cfg:"slowlog-max-len"`
+	// 热点 key 统计按 1/N 的概率采样 key 访问，0 表示不统计
+	HotkeysSampleRatio int `cfg:"hotkeys-sample-ratio"`
+	// 热点 key 统计跟踪的 key 数量，0 表示使用默认值 128
+	HotkeysCapacity int `cfg:"hotkeys-capacity"`
+	// 热点 key 统计的滑动窗口(秒)，0 表示使用默认值 60
+	HotkeysWindow int `cfg:"hotkeys-window"`
+	// 在 pprof 服务上提供 Prometheus 格式的 /metrics
+	MetricsEnable bool `cfg:"metrics-enable"`
+	// 审计日志文件，相对路径在 dir 下，空表示不记录
+	AuditLogFile string `cfg:"audit-log-file"`
+	// 记录的 ACL 分类，空格或逗号分隔，默认 write
+	AuditLogCategories string `cfg:"audit-log-categories"`
+	// 审计日志超过这个大小(MB)时轮转，0 表示使用默认值 64
+	AuditLogMaxSize int `cfg:"audit-log-max-size"`
+	// 轮转后保留的旧文件数，0 表示使用默认值 5
+	AuditLogMaxFiles int `cfg:"audit-log-max-files"`
+	// 哈希的字段数和字段名、值的长度不超过这两个值时使用 listpack 编码，0 表示使用默认值 128 和 64
+	HashMaxListpackEntries int `cfg:"hash-max-listpack-entries"`
+	HashMaxListpackValue   int `cfg:"hash-max-listpack-value"`
+	// 只包含整数的集合成员数不超过该值时使用 intset 编码，0 表示使用默认值 512
+	SetMaxIntsetEntries int `cfg:"set-max-intset-entries"`
+	// 有序集合的成员数和成员长度不超过这两个值时使用 listpack 编码，0 表示使用默认值 128 和 64
+	ZsetMaxListpackEntries int `cfg:"zset-max-listpack-entries"`
+	ZsetMaxListpackValue   int `cfg:"zset-max-listpack-value"`
+	// PUBLISH ... RETAIN 保留消息的频道数上限，超过时淘汰最久没有更新的频道，0 表示使用默认值 1024
+	PubsubRetainMax int    `cfg:"pubsub-retain-max"`
+	Databases       int    `cfg:"databases"`
+	RDBFilename     string `cfg:"dbfilename"`
+	MasterAuth      string `cfg:"masterauth"`
+	// MasterUser 主节点开启了 ACL 时用 AUTH masteruser masterauth 认证
+	MasterUser        string `cfg:"masteruser"`
 	SlaveAnnouncePort int    `cfg:"slave-announce-port"`
 	SlaveAnnounceIP   string `cfg:"slave-announce-ip"`
 	ReplTimeout       int    `cfg:"repl-timeout"`
-	UseGnet           bool   `cfg:"use-gnet"`
+	// ReplDisklessSync 全量同步时直接把 rdb 写入从节点的连接，不生成临时文件
+	ReplDisklessSync bool `cfg:"repl-diskless-sync"`
+	// ReplDisklessSyncDelay 无盘同步开始传输前等待的秒数，等待期间到达的从节点共用一个快照
+	ReplDisklessSyncDelay int `cfg:"repl-diskless-sync-delay"`
+	// ReplicaOf 启动时作为从节点连接的主节点，格式为 "host port"
+	ReplicaOf string `cfg:"replicaof"`
+	// 以 sentinel 模式运行，提供 SENTINEL 命令
+	Sentinel bool `cfg:"sentinel"`
+	// sentinel 监控的主节点，格式为 "<name> <host> <port> <quorum>"，多个主节点用逗号分隔
+	SentinelMonitor []string `cfg:"sentinel-monitor"`
+	// 其他 sentinel 的地址 host:port，SENTINEL sentinels 返回这些节点
+	SentinelPeers []string `cfg:"sentinel-peers"`
+	UseGnet       bool     `cfg:"use-gnet"`
+	// 淘汰策略: noeviction, allkeys-lru, volatile-lru, allkeys-lfu, volatile-lfu,
+	// allkeys-random, volatile-random, volatile-ttl
+	MaxMemoryPolicy string `cfg:"maxmemory-policy"`
+	// LFU 计数器的对数因子，0 表示使用默认值 10
+	LfuLogFactor int `cfg:"lfu-log-factor"`
+	// LFU 计数器每隔多少分钟衰减 1，0 表示使用默认值 1
+	LfuDecayTime int `cfg:"lfu-decay-time"`
+	// 二进制协议(sidecar)的端口，0 表示不开启
+	SidecarPort int `cfg:"sidecar-port"`
 
-	ClusterEnable     bool   `cfg:"cluster-enable"`
-	ClusterAsSeed     bool   `cfg:"cluster-as-seed"`
-	ClusterSeed       string `cfg:"cluster-seed"`
-	RaftListenAddr    string `cfg:"raft-listen-address"`
-	RaftAdvertiseAddr string `cfg:"raft-advertise-address"`
+	ClusterEnable bool `cfg:"cluster-enable"`
+	// 一致性哈希集群中当前节点的地址，需要与其他节点 peers 中的写法一致
+	Self string `cfg:"self"`
+	// 一致性哈希集群中其他节点的地址，开启 cluster-enable 并配置 peers 后按 key 转发命令
+	Peers             []string `cfg:"peers"`
+	ClusterAsSeed     bool     `cfg:"cluster-as-seed"`
+	ClusterSeed       string   `cfg:"cluster-seed"`
+	RaftListenAddr    string   `cfg:"raft-listen-address"`
+	RaftAdvertiseAddr string   `cfg:"raft-advertise-address"`
 	// If the node join the cluster as a replica of another node,
 	// set MasterInCluster as the RedisAdvertiseAddr of it's master node
-	MasterInCluster   string `cfg:"master-in-cluster"`
+	MasterInCluster string `cfg:"master-in-cluster"`
 }
 
 var configFilePath string
@@ -94,13 +209,18 @@ func init() {
 	}
 }
 
-func parse(src io.Reader) *ServerProperties {
+// parse 解析配置文件，无法解析的值全部收集起来一起返回，错误中带有行号和配置项
+func parse(src io.Reader) (*ServerProperties, error) {
 	config := &ServerProperties{}
 
 	// read config file
 	rawMap := make(map[string]string)
+	// 配置项所在的行号，预设中的配置项没有行号
+	lineNums := make(map[string]int)
+	lineNum := 0
 	scanner := bufio.NewScanner(src)
 	for scanner.Scan() {
+		lineNum++
 		line := scanner.Text()
 		if len(line) > 0 && strings.TrimLeft(line, " ")[0] == '#' {
 			continue
@@ -110,10 +230,22 @@ func parse(src io.Reader) *ServerProperties {
 			key := line[0:pivot]
 			value := strings.Trim(line[pivot+1:], " ")
 			rawMap[strings.ToLower(key)] = value
+			lineNums[strings.ToLower(key)] = lineNum
 		}
 	}
 	if err := scanner.Err(); err != nil {
-		slog.Error("error",err)
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+	var errs []error
+	invalid := func(key, value, reason string) {
+		if n, ok := lineNums[key]; ok {
+			errs = append(errs, fmt.Errorf("line %d: %s %q: %s", n, key, value, reason))
+		} else {
+			errs = append(errs, fmt.Errorf("%s %q: %s", key, value, reason))
+		}
+	}
+	if !applyProfile(rawMap) {
+		invalid("profile", rawMap["profile"], "unknown profile")
 	}
 
 	// parse format
@@ -135,12 +267,20 @@ func parse(src io.Reader) *ServerProperties {
 				fieldVal.SetString(value)
 			case reflect.Int:
 				intValue, err := strconv.ParseInt(value, 10, 64)
-				if err == nil {
-					fieldVal.SetInt(intValue)
+				if err != nil {
+					invalid(key, value, "not an integer")
+					continue
 				}
+				fieldVal.SetInt(intValue)
 			case reflect.Bool:
-				boolValue := "yes" == value
-				fieldVal.SetBool(boolValue)
+				switch strings.ToLower(value) {
+				case "yes":
+					fieldVal.SetBool(true)
+				case "no":
+					fieldVal.SetBool(false)
+				default:
+					invalid(key, value, "must be yes or no")
+				}
 			case reflect.Slice:
 				if field.Type.Elem().Kind() == reflect.String {
 					slice := strings.Split(value, ",")
@@ -149,27 +289,111 @@ func parse(src io.Reader) *ServerProperties {
 			}
 		}
 	}
-	return config
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return config, nil
 }
 
-// SetupConfig read config file and store properties into Properties
-func SetupConfig(configFilename string) {
+// SetupConfig read config file and store properties into Properties,
+// Properties is not changed if the file cannot be read or contains invalid values
+func SetupConfig(configFilename string) error {
 	file, err := os.Open(configFilename)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("open config file: %w", err)
 	}
 	defer file.Close()
-	Properties = parse(file)
-	Properties.RunID = utils.RandString(40)
-	configFilePath, err = filepath.Abs(configFilename)
+	p, err := parse(file)
 	if err != nil {
-		return
+		return fmt.Errorf("config file %s:\n%w", configFilename, err)
+	}
+	p.RunID = utils.RandString(40)
+	if p.Dir == "" {
+		p.Dir = "."
 	}
-	if Properties.Dir == "" {
-		Properties.Dir = "."
+	Properties = p
+	configFilePath, err = filepath.Abs(configFilename)
+	if err != nil {
+		configFilePath = configFilename
 	}
+	return nil
 }
 
+// GetTmpDir returns tmp dir of the global config
 func GetTmpDir() string {
-	return Properties.Dir + "/tmp"
+	return Properties.TmpDir()
+}
+
+// TmpDir 存放 aof 重写、rdb 生成时的临时文件，每个实例在自己的 Dir 下
+func (p *ServerProperties) TmpDir() string {
+	return p.Dir + "/tmp"
+}
+
+// AppendFilePath returns path of aof file, relative filename is resolved under Dir
+func (p *ServerProperties) AppendFilePath() string {
+	return p.resolvePath(p.AppendFilename)
+}
+
+// AppendDirPath returns path of the multi part aof dir, relative dirname is resolved under Dir
+func (p *ServerProperties) AppendDirPath() string {
+	return p.resolvePath(p.AppendDirname)
+}
+
+// AuditLogFilePath returns path of the audit log, relative filename is resolved under Dir
+func (p *ServerProperties) AuditLogFilePath() string {
+	return p.resolvePath(p.AuditLogFile)
+}
+
+// AofLoadTruncatedAllowed reports whether a truncated aof tail is cut off at startup, defaults to true
+func (p *ServerProperties) AofLoadTruncatedAllowed() bool {
+	return !strings.EqualFold(p.AofLoadTruncated, "no")
+}
+
+// RDBFilePath returns path of rdb file, relative filename is resolved under Dir
+// dump.rdb is used if dbfilename is not set
+func (p *ServerProperties) RDBFilePath() string {
+	if p.RDBFilename == "" {
+		return p.resolvePath("dump.rdb")
+	}
+	return p.resolvePath(p.RDBFilename)
+}
+
+func (p *ServerProperties) resolvePath(filename string) string {
+	if filename == "" || p.Dir == "" || filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(p.Dir, filename)
+}
+
+// Entries returns name and value of every config item in declaration order,
+// values are formatted as they are written in the config file
+func (p *ServerProperties) Entries() [][2]string {
+	t := reflect.TypeOf(p).Elem()
+	v := reflect.ValueOf(p).Elem()
+	entries := make([][2]string, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key, ok := field.Tag.Lookup("cfg")
+		if !ok || strings.TrimSpace(key) == "" {
+			continue
+		}
+		var value string
+		switch fieldVal := v.Field(i); field.Type.Kind() {
+		case reflect.String:
+			value = fieldVal.String()
+		case reflect.Int:
+			value = strconv.FormatInt(fieldVal.Int(), 10)
+		case reflect.Bool:
+			value = "no"
+			if fieldVal.Bool() {
+				value = "yes"
+			}
+		case reflect.Slice:
+			if items, ok := fieldVal.Interface().([]string); ok {
+				value = strings.Join(items, ",")
+			}
+		}
+		entries = append(entries, [2]string{strings.ToLower(key), value})
+	}
+	return entries
 }
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
 )
@@ -10,8 +14,8 @@ func TestParse(t *testing.T) {
 		"port 6399\n" +
 		"appendonly yes\n" +
 		"peers a,b"
-	p := parse(strings.NewReader(src))
-	if p == nil {
+	p, err := parse(strings.NewReader(src))
+	if err != nil || p == nil {
 		t.Error("cannot get result")
 		return
 	}
@@ -25,3 +29,152 @@ func TestParse(t *testing.T) {
 		t.Error("bool parse failed")
 	}
 }
+
+func TestProfile(t *testing.T) {
+	p, err := parse(strings.NewReader("profile production\nslowlog-log-slower-than 500\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.ProtectedMode || !p.FlushRequireAsync || p.SlowlogMaxLen != 128 {
+		t.Errorf("profile defaults not applied: %+v", p)
+	}
+	if len(p.DenyCommands) != 2 || p.DenyCommands[0] != "keys" || p.DenyCommands[1] != "debug" {
+		t.Errorf("unexpected deny-commands %v", p.DenyCommands)
+	}
+	// 显式配置优先于预设
+	if p.SlowlogLogSlowerThan != 500 {
+		t.Errorf("expected explicit value to win, got %d", p.SlowlogLogSlowerThan)
+	}
+	p, err = parse(strings.NewReader("profile production\nprotected-mode no\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.ProtectedMode {
+		t.Error("expected protected-mode to be disabled explicitly")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	_, err := parse(strings.NewReader("port abc\n# comment\nappendonly true\nprofile nosuch\n"))
+	if err == nil {
+		t.Fatal("expected parse errors")
+	}
+	for _, msg := range []string{
+		`line 1: port "abc": not an integer`,
+		`line 3: appendonly "true": must be yes or no`,
+		`line 4: profile "nosuch": unknown profile`,
+	} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q in %q", msg, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	dir := t.TempDir()
+	p := &ServerProperties{Bind: "127.0.0.1", Port: freePort(t), Dir: dir, AppendOnly: true, AppendFilename: "appendonly.aof"}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 所有问题一起报告
+	p = &ServerProperties{Port: 70000, Databases: -1, AppendFsync: "sometimes", MaxMemoryPolicy: "lru",
+		Dir: filepath.Join(dir, "missing"), ServerMode: "select"}
+	err := p.Validate()
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, msg := range []string{"port 70000: out of range", "databases -1", `appendfsync "sometimes"`,
+		`maxmemory-policy "lru"`, "dir " + filepath.Join(dir, "missing"), `server-mode "select"`} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("expected %q in %q", msg, err)
+		}
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "dump.rdb"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p = &ServerProperties{Bind: "127.0.0.1", Port: freePort(t), Dir: dir}
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "dbfilename") {
+		t.Errorf("expected dbfilename error, got %v", err)
+	}
+
+	// 分区 aof 依赖槽位重定向拒绝跨槽位命令
+	p = &ServerProperties{Bind: "127.0.0.1", Port: freePort(t), Dir: t.TempDir(), AofSlotPartitions: []string{"0-16383"}}
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "aof-slot-partitions") {
+		t.Errorf("expected aof-slot-partitions error, got %v", err)
+	}
+	p.ClusterEnable = true
+	if err := p.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// 端口被占用
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	p = &ServerProperties{Bind: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port, Dir: t.TempDir()}
+	if err := p.Validate(); err == nil || !strings.Contains(err.Error(), "cannot listen") {
+		t.Errorf("expected port in use error, got %v", err)
+	}
+}
+
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestTuning(t *testing.T) {
+	procs := runtime.GOMAXPROCS(0)
+	tuning := (&ServerProperties{MaxClients: 10000}).Tuning()
+	if tuning.GOMAXPROCS != procs {
+		t.Errorf("gomaxprocs %d, expected %d", tuning.GOMAXPROCS, procs)
+	}
+	if n := tuning.ShardCount; n < minShardCount || n > maxShardCount || n&(n-1) != 0 {
+		t.Errorf("unexpected shard count %d", n)
+	}
+	if tuning.WorkerPoolSize != min(procs*workersPerProc, 10000) {
+		t.Errorf("unexpected worker pool size %d", tuning.WorkerPoolSize)
+	}
+	// 64MB/10000 向下取 2 的幂
+	if tuning.ReadBufferSize != 4096 {
+		t.Errorf("unexpected read buffer size %d", tuning.ReadBufferSize)
+	}
+	if size := (&ServerProperties{MaxClients: 1000}).Tuning().ReadBufferSize; size != 65536 {
+		t.Errorf("unexpected read buffer size %d for 1000 clients", size)
+	}
+	if size := (&ServerProperties{MaxClients: 1}).Tuning().WorkerPoolSize; size != 1 {
+		t.Errorf("worker pool size %d exceeds maxclients", size)
+	}
+
+	// 配置值优先，分片数向上取 2 的幂
+	tuning = (&ServerProperties{ShardCount: 1000, WorkerPoolSize: 7, ReadBufferSize: 12345}).Tuning()
+	if tuning.ShardCount != 1024 || tuning.WorkerPoolSize != 7 || tuning.ReadBufferSize != 12345 {
+		t.Errorf("config values are not used: %+v", tuning)
+	}
+}
+
+func TestParseOutputBufferLimits(t *testing.T) {
+	limits, err := ParseOutputBufferLimits("normal 0 0 0 pubsub 1mb 256kb 10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limits.PubSub.Hard != 1<<20 || limits.PubSub.Soft != 256<<10 || limits.PubSub.SoftDuration.Seconds() != 10 {
+		t.Errorf("unexpected pubsub limit %+v", limits.PubSub)
+	}
+	// 没有配置的类型使用默认值
+	if limits.Replica.Hard != 256<<20 || limits.Normal.Hard != 0 {
+		t.Errorf("unexpected defaults %+v", limits)
+	}
+	for _, value := range []string{"pubsub 1mb 1mb", "master 0 0 0", "pubsub 1xb 0 0", "normal 0 0 -1"} {
+		if _, err := ParseOutputBufferLimits(value); err == nil {
+			t.Errorf("expected error for %q", value)
+		}
+	}
+}
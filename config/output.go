@@ -0,0 +1,86 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OutputBufferLimit disconnects a client when its pending output exceeds Hard bytes,
+// or stays above Soft bytes for SoftDuration, 0 means no limit
+type OutputBufferLimit struct {
+	Hard         int64
+	Soft         int64
+	SoftDuration time.Duration
+}
+
+// OutputBufferLimits are the limits of normal clients, replicas and pub/sub subscribers
+type OutputBufferLimits struct {
+	Normal  OutputBufferLimit
+	Replica OutputBufferLimit
+	PubSub  OutputBufferLimit
+}
+
+// 与 redis 的默认值相同
+var defaultOutputBufferLimits = OutputBufferLimits{
+	Replica: OutputBufferLimit{Hard: 256 << 20, Soft: 64 << 20, SoftDuration: 60 * time.Second},
+	PubSub:  OutputBufferLimit{Hard: 32 << 20, Soft: 8 << 20, SoftDuration: 60 * time.Second},
+}
+
+// ParseOutputBufferLimits parses "<class> <hard> <soft> <soft seconds> ...", class is normal, replica (slave) or pubsub,
+// sizes may end with k/kb/m/mb/g/gb. Classes not listed keep the defaults of redis
+func ParseOutputBufferLimits(value string) (OutputBufferLimits, error) {
+	limits := defaultOutputBufferLimits
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return limits, fmt.Errorf("expected groups of <class> <hard limit> <soft limit> <soft seconds>")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		var limit *OutputBufferLimit
+		switch strings.ToLower(fields[i]) {
+		case "normal":
+			limit = &limits.Normal
+		case "replica", "slave":
+			limit = &limits.Replica
+		case "pubsub":
+			limit = &limits.PubSub
+		default:
+			return limits, fmt.Errorf("invalid client class %q", fields[i])
+		}
+		hard, err1 := parseMemory(fields[i+1])
+		soft, err2 := parseMemory(fields[i+2])
+		seconds, err3 := strconv.Atoi(fields[i+3])
+		if err1 != nil || err2 != nil || err3 != nil || seconds < 0 {
+			return limits, fmt.Errorf("invalid limit %q", strings.Join(fields[i:i+4], " "))
+		}
+		*limit = OutputBufferLimit{Hard: hard, Soft: soft, SoftDuration: time.Duration(seconds) * time.Second}
+	}
+	return limits, nil
+}
+
+// parseMemory 与 redis 的 memtoll 相同，k/m/g 是 1000 的倍数，kb/mb/gb 是 1024 的倍数
+func parseMemory(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{
+		{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+		{"b", 1},
+	}
+	lower := strings.ToLower(value)
+	unit := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			unit = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", value)
+	}
+	return n * unit, nil
+}
@@ -0,0 +1,38 @@
+package config
+
+import "strings"
+
+// 配置预设
+// profile 指令一次设置一组配置项的默认值，配置文件中显式写出的配置项优先于预设。
+// production 预设面向直接部署到生产环境的场景：
+//   - protected-mode yes: 没有设置 requirepass 时只接受本机连接
+//   - deny-commands keys,debug: 禁止会阻塞整个实例或者修改内部状态的命令
+//   - flush-require-async yes: FLUSHALL/FLUSHDB 必须带 ASYNC，避免误操作
+//   - slowlog-log-slower-than 10000, slowlog-max-len 128: 记录执行超过 10ms 的命令
+var profiles = map[string]map[string]string{
+	"production": {
+		"protected-mode":          "yes",
+		"deny-commands":           "keys,debug",
+		"flush-require-async":     "yes",
+		"slowlog-log-slower-than": "10000",
+		"slowlog-max-len":         "128",
+	},
+}
+
+// applyProfile 把预设中没有显式配置的项加入 rawMap，未知的预设返回 false
+func applyProfile(rawMap map[string]string) bool {
+	name, ok := rawMap["profile"]
+	if !ok || name == "" {
+		return true
+	}
+	profile, ok := profiles[strings.ToLower(name)]
+	if !ok {
+		return false
+	}
+	for key, value := range profile {
+		if _, exists := rawMap[key]; !exists {
+			rawMap[key] = value
+		}
+	}
+	return true
+}
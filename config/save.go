@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SaveRule triggers a background rdb snapshot when there have been at least Changes writes
+// and at least Seconds seconds since the last successful save
+type SaveRule struct {
+	Seconds int
+	Changes int
+}
+
+// ParseSaveRules parses "<seconds> <changes> [<seconds> <changes> ...]", an empty value disables snapshotting
+func ParseSaveRules(value string) ([]SaveRule, error) {
+	fields := strings.Fields(value)
+	if len(fields) == 1 && fields[0] == `""` {
+		return nil, nil
+	}
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("expected pairs of <seconds> <changes>")
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err1 := strconv.Atoi(fields[i])
+		changes, err2 := strconv.Atoi(fields[i+1])
+		if err1 != nil || err2 != nil || seconds <= 0 || changes <= 0 {
+			return nil, fmt.Errorf("invalid rule %q", fields[i]+" "+fields[i+1])
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
@@ -0,0 +1,84 @@
+package config
+
+import "runtime"
+
+// 启动参数自动调整
+// 分片数、执行命令的工作池大小和解析请求的缓冲区大小根据 GOMAXPROCS 和 maxclients 推导，
+// 对应的配置项不为 0 时使用配置值:
+//   - shard-count: 每个数据库的 data/version 字典分片数，GOMAXPROCS*256 取 2 的幂，范围 [1024, 65536]。
+//     并发执行的命令数受 GOMAXPROCS 限制，分片过多只会增加内存占用和 SCAN 的遍历次数
+//   - worker-pool-size: 同时执行数据命令的连接数上限，GOMAXPROCS*32，不超过 maxclients。
+//     阻塞命令等待数据期间不占用名额
+//   - read-buffer-size: 每个连接解析请求的缓冲区大小，64MB 平均分给 maxclients 个连接后向下取 2 的幂，
+//     范围 [4KB, 64KB]，maxclients 为 0 时使用 4KB
+// NUMA 机器上可以用 taskset/numactl 把进程绑定到一个节点并设置 GOMAXPROCS，推导的参数随之缩小
+
+const (
+	minShardCount     = 1 << 10
+	maxShardCount     = 1 << 16
+	shardsPerProc     = 256
+	workersPerProc    = 32
+	minReadBufferSize = 4 << 10
+	maxReadBufferSize = 64 << 10
+	readBufferBudget  = 64 << 20
+)
+
+// Tuning holds runtime parameters derived from GOMAXPROCS and maxclients at startup
+type Tuning struct {
+	GOMAXPROCS     int
+	NumCPU         int
+	ShardCount     int
+	WorkerPoolSize int
+	ReadBufferSize int
+}
+
+// Tuning derives runtime parameters from GOMAXPROCS and maxclients, non-zero config values take precedence
+func (p *ServerProperties) Tuning() Tuning {
+	procs := runtime.GOMAXPROCS(0)
+	t := Tuning{
+		GOMAXPROCS:     procs,
+		NumCPU:         runtime.NumCPU(),
+		ShardCount:     p.ShardCount,
+		WorkerPoolSize: p.WorkerPoolSize,
+		ReadBufferSize: p.ReadBufferSize,
+	}
+	if t.ShardCount <= 0 {
+		t.ShardCount = clamp(ceilPowerOfTwo(procs*shardsPerProc), minShardCount, maxShardCount)
+	} else {
+		// 与 dict.MakeConcurrent 相同，分片数向上取 2 的幂，至少 16
+		t.ShardCount = clamp(ceilPowerOfTwo(t.ShardCount), 16, maxShardCount)
+	}
+	if t.WorkerPoolSize <= 0 {
+		t.WorkerPoolSize = procs * workersPerProc
+		if p.MaxClients > 0 && t.WorkerPoolSize > p.MaxClients {
+			t.WorkerPoolSize = p.MaxClients
+		}
+	}
+	if t.ReadBufferSize <= 0 {
+		t.ReadBufferSize = minReadBufferSize
+		if p.MaxClients > 0 {
+			t.ReadBufferSize = clamp(floorPowerOfTwo(readBufferBudget/p.MaxClients), minReadBufferSize, maxReadBufferSize)
+		}
+	}
+	return t
+}
+
+func clamp(n, lo, hi int) int {
+	return max(lo, min(n, hi))
+}
+
+func ceilPowerOfTwo(n int) int {
+	p := 1
+	for p < n {
+		p <<= 1
+	}
+	return p
+}
+
+func floorPowerOfTwo(n int) int {
+	p := 1
+	for p<<1 <= n {
+		p <<= 1
+	}
+	return p
+}
@@ -0,0 +1,235 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// 启动前检查配置
+// 配置值的范围、数据目录和持久化文件的读写权限、监听端口是否可用，
+// 所有问题汇总成一个错误返回，避免启动之后才在深处 panic 或者只打印日志
+
+var validFsync = []string{"always", "everysec", "no"}
+
+var validMaxMemoryPolicies = []string{"noeviction", "allkeys-lru", "volatile-lru", "allkeys-lfu",
+	"volatile-lfu", "allkeys-random", "volatile-random", "volatile-ttl"}
+
+func oneOf(value string, options []string) bool {
+	for _, option := range options {
+		if strings.EqualFold(value, option) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks config values, files and listening ports before starting the server,
+// all problems are joined in the returned error
+func (p *ServerProperties) Validate() error {
+	var errs []error
+	fail := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if p.Port <= 0 || p.Port > 65535 {
+		fail("port %d: out of range [1, 65535]", p.Port)
+	}
+	if p.SidecarPort < 0 || p.SidecarPort > 65535 {
+		fail("sidecar-port %d: out of range [0, 65535]", p.SidecarPort)
+	} else if p.SidecarPort != 0 && p.SidecarPort == p.Port {
+		fail("sidecar-port %d: same as port", p.SidecarPort)
+	}
+	for _, item := range []struct {
+		key   string
+		value int
+	}{
+		{"databases", p.Databases},
+		{"maxclients", p.MaxClients},
+		{"worker-pool-size", p.WorkerPoolSize},
+		{"read-buffer-size", p.ReadBufferSize},
+		{"max-reply-elements", p.MaxReplyElements},
+		{"reply-compress-threshold", p.ReplyCompressThreshold},
+		{"proto-max-bulk-len", p.ProtoMaxBulkLen},
+		{"aof-coalesce-window", p.AofCoalesceWindow},
+		{"slowlog-max-len", p.SlowlogMaxLen},
+		{"hotkeys-sample-ratio", p.HotkeysSampleRatio},
+		{"hotkeys-capacity", p.HotkeysCapacity},
+		{"hotkeys-window", p.HotkeysWindow},
+		{"shard-max-load", p.ShardMaxLoad},
+		{"audit-log-max-size", p.AuditLogMaxSize},
+		{"audit-log-max-files", p.AuditLogMaxFiles},
+		{"hash-max-listpack-entries", p.HashMaxListpackEntries},
+		{"hash-max-listpack-value", p.HashMaxListpackValue},
+		{"set-max-intset-entries", p.SetMaxIntsetEntries},
+		{"zset-max-listpack-entries", p.ZsetMaxListpackEntries},
+		{"zset-max-listpack-value", p.ZsetMaxListpackValue},
+		{"pubsub-retain-max", p.PubsubRetainMax},
+		{"repl-timeout", p.ReplTimeout},
+		{"shutdown-timeout", p.ShutdownTimeout},
+		{"repl-diskless-sync-delay", p.ReplDisklessSyncDelay},
+		{"lfu-log-factor", p.LfuLogFactor},
+		{"lfu-decay-time", p.LfuDecayTime},
+	} {
+		if item.value < 0 {
+			fail("%s %d: must not be negative", item.key, item.value)
+		}
+	}
+	if p.ShardCount < 0 || p.ShardCount > maxShardCount {
+		fail("shard-count %d: out of range [0, %d]", p.ShardCount, maxShardCount)
+	}
+	if p.AppendFsync != "" && !oneOf(p.AppendFsync, validFsync) {
+		fail("appendfsync %q: must be one of %s", p.AppendFsync, strings.Join(validFsync, ", "))
+	}
+	if p.ServerMode != "" && !oneOf(p.ServerMode, []string{"goroutine", "epoll"}) {
+		fail("server-mode %q: must be goroutine or epoll", p.ServerMode)
+	} else if strings.EqualFold(p.ServerMode, "epoll") && runtime.GOOS != "linux" {
+		fail("server-mode %q: only supported on linux", p.ServerMode)
+	}
+	if p.AofLoadTruncated != "" && !oneOf(p.AofLoadTruncated, []string{"yes", "no"}) {
+		fail("aof-load-truncated %q: must be yes or no", p.AofLoadTruncated)
+	}
+	if _, err := ParseSaveRules(p.Save); err != nil {
+		fail("save %q: %v", p.Save, err)
+	}
+	if _, err := ParseOutputBufferLimits(p.ClientOutputBufferLimit); err != nil {
+		fail("client-output-buffer-limit %q: %v", p.ClientOutputBufferLimit, err)
+	}
+	if len(p.AofSlotPartitions) > 0 && (!p.ClusterEnable || len(p.Peers) > 0) {
+		// 跨槽位的命令只写入一个分区，重启后会丢失其他分区的修改，需要由槽位重定向拒绝
+		fail("aof-slot-partitions: requires cluster-enabled yes without peers")
+	}
+	if p.AppendDirname != "" {
+		if len(p.AofSlotPartitions) > 0 {
+			fail("appenddirname: not supported with aof-slot-partitions")
+		}
+		if p.AppendFilename != filepath.Base(p.AppendFilename) {
+			fail("appendfilename %q: must be a plain file name when appenddirname is set", p.AppendFilename)
+		}
+	}
+	if p.MaxMemoryPolicy != "" && !oneOf(p.MaxMemoryPolicy, validMaxMemoryPolicies) {
+		fail("maxmemory-policy %q: must be one of %s", p.MaxMemoryPolicy, strings.Join(validMaxMemoryPolicies, ", "))
+	}
+	if p.ReplicaOf != "" {
+		if fields := strings.Fields(p.ReplicaOf); len(fields) != 2 {
+			fail("replicaof %q: expected \"host port\"", p.ReplicaOf)
+		} else if port, err := strconv.Atoi(fields[1]); err != nil || port <= 0 || port > 65535 {
+			fail("replicaof %q: invalid port", p.ReplicaOf)
+		}
+	}
+
+	errs = append(errs, p.validateFiles()...)
+	// 端口检查放在最后，前面有错误时不需要再占用端口
+	if len(errs) == 0 {
+		errs = append(errs, p.validatePorts()...)
+	}
+	return errors.Join(errs...)
+}
+
+// checkWritableDir 确认 dir 是可以创建文件的目录
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("not a directory")
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("not writable: %w", err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+	return nil
+}
+
+// checkFile 已经存在的文件需要是普通文件并且可以按 flag 打开，不存在时检查所在目录可写
+func checkFile(path string, flag int) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		if err := checkWritableDir(filepath.Dir(path)); err != nil {
+			return fmt.Errorf("directory %s: %w", filepath.Dir(path), err)
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errors.New("not a regular file")
+	}
+	f, err := os.OpenFile(path, flag, 0)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (p *ServerProperties) validateFiles() []error {
+	var errs []error
+	dir := p.Dir
+	if dir == "" {
+		dir = "."
+	}
+	if err := checkWritableDir(dir); err != nil {
+		// 数据目录不可用时其它文件的检查没有意义
+		return []error{fmt.Errorf("dir %s: %w", dir, err)}
+	}
+	// 临时目录由启动时创建，已经存在时同样需要可写
+	if _, err := os.Stat(p.TmpDir()); err == nil {
+		if err := checkWritableDir(p.TmpDir()); err != nil {
+			errs = append(errs, fmt.Errorf("tmp dir %s: %w", p.TmpDir(), err))
+		}
+	}
+	if p.AppendOnly {
+		if p.AppendFilename == "" {
+			errs = append(errs, errors.New("appendfilename: required when appendonly is yes"))
+		} else if err := checkFile(p.AppendFilePath(), os.O_RDWR|os.O_APPEND); err != nil {
+			errs = append(errs, fmt.Errorf("appendfilename %s: %w", p.AppendFilePath(), err))
+		}
+		// aof 目录由启动时创建，已经存在时同样需要可写
+		if p.AppendDirname != "" {
+			if _, err := os.Stat(p.AppendDirPath()); err == nil {
+				if err := checkWritableDir(p.AppendDirPath()); err != nil {
+					errs = append(errs, fmt.Errorf("appenddirname %s: %w", p.AppendDirPath(), err))
+				}
+			}
+		}
+	}
+	if err := checkFile(p.RDBFilePath(), os.O_RDONLY); err != nil {
+		errs = append(errs, fmt.Errorf("dbfilename %s: %w", p.RDBFilePath(), err))
+	}
+	if p.AuditLogFile != "" {
+		if err := checkFile(p.AuditLogFilePath(), os.O_WRONLY|os.O_APPEND); err != nil {
+			errs = append(errs, fmt.Errorf("audit-log-file %s: %w", p.AuditLogFilePath(), err))
+		}
+	}
+	return errs
+}
+
+// validatePorts 试着监听配置的端口，确认没有被其它进程占用
+func (p *ServerProperties) validatePorts() []error {
+	var errs []error
+	ports := []struct {
+		key  string
+		port int
+	}{{"port", p.Port}, {"sidecar-port", p.SidecarPort}}
+	for _, item := range ports {
+		if item.port == 0 {
+			continue
+		}
+		addr := net.JoinHostPort(p.Bind, strconv.Itoa(item.port))
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %d: cannot listen on %s: %w", item.key, item.port, addr, err))
+			continue
+		}
+		_ = listener.Close()
+	}
+	return errs
+}
@@ -0,0 +1,372 @@
+package database
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// ACL 分类
+// 每个命令的分类在注册时由 flags 和 signs 推导: 只读命令属于 @read，其余属于 @write，
+// 服务器层的命令(PING/SELECT...)没有读写之分，只根据 signs 中的 readonly/write 推导，
+// admin 属于 @admin 和 @dangerous，fast 属于 @fast，否则属于 @slow；
+// 数据类型(@string/@hash...)和 @keyspace 无法从 flags 得到，由 commandTypeCategories 给出，
+// 新增命令时需要加入这张表，TestEveryCommandCategorized 检查每个命令都有所属的分组
+
+type aclCategory uint32
+
+const (
+	aclKeyspace aclCategory = 1 << iota
+	aclRead
+	aclWrite
+	aclString
+	aclBitmap
+	aclHash
+	aclList
+	aclSet
+	aclSortedSet
+	aclStream
+	aclGeo
+	aclPubSub
+	aclAdmin
+	aclFast
+	aclSlow
+	aclDangerous
+	aclConnection
+	aclTransaction
+	aclBlocking
+
+	aclAll aclCategory = 1<<iota - 1
+)
+
+// 按照 redis ACL CAT 的顺序
+var aclCategoryNames = []struct {
+	category aclCategory
+	name     string
+}{
+	{aclKeyspace, "keyspace"},
+	{aclRead, "read"},
+	{aclWrite, "write"},
+	{aclSet, "set"},
+	{aclSortedSet, "sortedset"},
+	{aclList, "list"},
+	{aclHash, "hash"},
+	{aclString, "string"},
+	{aclBitmap, "bitmap"},
+	{aclGeo, "geo"},
+	{aclStream, "stream"},
+	{aclPubSub, "pubsub"},
+	{aclAdmin, "admin"},
+	{aclFast, "fast"},
+	{aclSlow, "slow"},
+	{aclBlocking, "blocking"},
+	{aclDangerous, "dangerous"},
+	{aclConnection, "connection"},
+	{aclTransaction, "transaction"},
+}
+
+// parseAclCategory 解析不带 @ 前缀的分类名
+func parseAclCategory(name string) (aclCategory, bool) {
+	name = strings.ToLower(name)
+	if name == "all" {
+		return aclAll, true
+	}
+	for _, c := range aclCategoryNames {
+		if c.name == name {
+			return c.category, true
+		}
+	}
+	return 0, false
+}
+
+// names 返回带 @ 前缀的分类名
+func (categories aclCategory) names() []string {
+	var result []string
+	for _, c := range aclCategoryNames {
+		if categories&c.category != 0 {
+			result = append(result, "@"+c.name)
+		}
+	}
+	return result
+}
+
+// 无法从 flags 推导的分类
+var commandTypeCategories = buildCommandCategories(map[aclCategory][]string{
+	aclKeyspace: {"del", "unlink", "expire", "pexpire", "expireat", "pexpireat", "expiretime", "pexpiretime", "ttl", "pttl", "persist",
+		"exists", "type", "rename", "renamenx", "copy", "keys", "scan", "randomkey", "dbsize", "flushdb", "flushall",
+		"expirepattern", "persistpattern", "dump", "restore", "swapdb", "object"},
+	aclString: {"set", "setnx", "setex", "psetex", "mset", "mget", "msetnx", "get", "getex", "getset", "getdel",
+		"incr", "incrby", "incrbyfloat", "decr", "decrby", "strlen", "append", "setrange", "getrange",
+		"substr"},
+	aclBitmap: {"setbit", "getbit", "bitcount", "bitpos", "bitop"},
+	aclHash: {"hset", "hsetnx", "hget", "hexists", "hdel", "hlen", "hstrlen", "hmset", "hmget", "hkeys", "hvals",
+		"hgetall", "hincrby", "hrandfield", "hscan"},
+	aclList: {"lpush", "lpushx", "rpush", "rpushx", "lpop", "rpop", "rpoplpush", "lrem", "llen", "lindex", "lset",
+		"lrange", "ltrim", "linsert", "lmove", "lpos", "lmpop", "blpop", "brpop", "blmove", "brpoplpush"},
+	aclBlocking: {"blpop", "brpop", "blmove", "brpoplpush", "bzpopmin", "bzpopmax", "xread"},
+	aclSet: {"sadd", "sismember", "smismember", "srem", "spop", "srandmember", "scard", "smembers", "sinter",
+		"sintercard", "sinterstore", "sunion", "sunionstore", "sdiff", "sdiffstore", "sscan"},
+	aclSortedSet: {"zadd", "zscore", "zmscore", "zrandmember", "zincrby", "zrank", "zcount", "zrevrank", "zcard", "zrange", "zrangebyscore",
+		"zrevrange", "zrevrangebyscore", "zpopmin", "zpopmax", "bzpopmin", "bzpopmax", "zrem", "zremrangebyscore", "zremrangebyrank", "zlexcount",
+		"zrangebylex", "zremrangebylex", "zrevrangebylex", "zscan", "zpeekmin"},
+	aclGeo:    {"geoadd", "geopos", "geodist", "geosearch", "geosearchstore"},
+	aclStream: {"xadd", "xlen", "xrange", "xrevrange", "xread", "xtrim", "xsetid"},
+	aclDangerous: {"keys", "flushdb", "flushall", "swapdb", "info", "sync", "psync", "replconf", "slaveof",
+		"replicaof", "sentinel", "debug", "save", "bgsave", "lastsave", "bgrewriteaof", "rewriteaof", "cluster", "config",
+		"monitor", "shutdown"},
+	aclConnection:  {"ping", "auth", "hello", "select", "asking", "command", "client", "waitsync"},
+	aclTransaction: {"multi", "exec", "discard", "watch", "unwatch"},
+})
+
+func buildCommandCategories(table map[aclCategory][]string) map[string]aclCategory {
+	result := make(map[string]aclCategory)
+	for category, names := range table {
+		for _, name := range names {
+			result[name] |= category
+		}
+	}
+	return result
+}
+
+// flagCategories 由 flags 推导分类，attachCommandExtra 之前命令视为 @slow
+func flagCategories(name string, flags int) aclCategory {
+	categories := commandTypeCategories[name] | aclSlow
+	if flags&flagReadOnly != 0 {
+		categories |= aclRead
+	} else {
+		categories |= aclWrite
+	}
+	return categories
+}
+
+// signCategories 由 signs 推导分类
+func signCategories(categories aclCategory, signs []string) aclCategory {
+	for _, sign := range signs {
+		switch sign {
+		case redisFlagReadonly:
+			categories |= aclRead
+		case redisFlagWrite:
+			categories |= aclWrite
+		case redisFlagAdmin:
+			categories |= aclAdmin | aclDangerous
+		case redisFlagPubSub:
+			// 发布订阅命令不读写数据
+			categories = categories&^(aclRead|aclWrite) | aclPubSub
+		case redisFlagFast:
+			categories = categories&^aclSlow | aclFast
+		}
+	}
+	return categories
+}
+
+// serverCmdTable 保存在 Server.Exec / DB.exec 中直接处理的命令(PUBLISH 在事务中需要排队，注册在 cmdTable 中)，
+// 只用于 COMMAND INFO 和 ACL 检查，不会进入事务队列或 aof
+var serverCmdTable = make(map[string]*command)
+
+func registerServerCommand(name string, arity int, flags int) *command {
+	name = strings.ToLower(name)
+	cmd := &command{
+		name:       name,
+		arity:      arity,
+		flags:      flags,
+		categories: commandTypeCategories[name] | aclSlow,
+	}
+	serverCmdTable[name] = cmd
+	return cmd
+}
+
+// lookupCommand 查找普通命令以及服务器层的命令
+func lookupCommand(name string) (*command, bool) {
+	if cmd, ok := cmdTable[name]; ok {
+		return cmd, true
+	}
+	cmd, ok := serverCmdTable[name]
+	return cmd, ok
+}
+
+// aclRules 是一个用户的命令权限，例如 acl-default-rules 配置的 "+@all -@dangerous +info"
+// 规则按顺序生效，后面的规则覆盖前面的规则。+@all/-@all 覆盖之前所有的规则，
+// rules 只保留最后一个 +@all/-@all 及之后的规则，用于 ACL GETUSER/LIST 显示
+type aclRules struct {
+	allowed map[string]bool
+	rules   []string
+}
+
+func newAclRules() *aclRules {
+	return &aclRules{
+		allowed: make(map[string]bool),
+	}
+}
+
+func parseAclRules(rules string) (*aclRules, error) {
+	acl := newAclRules()
+	for _, rule := range strings.Fields(rules) {
+		if err := acl.apply(rule); err != nil {
+			return nil, err
+		}
+	}
+	return acl, nil
+}
+
+// apply 应用一条 +command/-command/+@category/-@category/allcommands/nocommands 规则
+func (acl *aclRules) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "allcommands":
+		rule = "+@all"
+	case "nocommands":
+		rule = "-@all"
+	}
+	if len(rule) < 2 || (rule[0] != '+' && rule[0] != '-') {
+		return errors.New("ERR Error in ACL rule '" + rule + "'")
+	}
+	allow := rule[0] == '+'
+	if rule[1] == '@' {
+		category, ok := parseAclCategory(rule[2:])
+		if !ok {
+			return errors.New("ERR Unknown command category '" + rule[2:] + "'")
+		}
+		acl.setCategory(category, allow)
+		if category == aclAll {
+			acl.rules = acl.rules[:0]
+		}
+		acl.rules = append(acl.rules, rule[:1]+"@"+strings.ToLower(rule[2:]))
+		return nil
+	}
+	name := strings.ToLower(rule[1:])
+	if _, ok := lookupCommand(name); !ok {
+		return errors.New("ERR Unknown command '" + name + "'")
+	}
+	acl.allowed[name] = allow
+	acl.rules = append(acl.rules, rule[:1]+name)
+	return nil
+}
+
+func (acl *aclRules) clone() *aclRules {
+	return &aclRules{
+		allowed: maps.Clone(acl.allowed),
+		rules:   slices.Clone(acl.rules),
+	}
+}
+
+// String 返回生效的规则，没有任何规则时不允许执行命令
+func (acl *aclRules) String() string {
+	if len(acl.rules) == 0 {
+		return "-@all"
+	}
+	return strings.Join(acl.rules, " ")
+}
+
+func (acl *aclRules) setCategory(category aclCategory, allow bool) {
+	for _, table := range []map[string]*command{cmdTable, serverCmdTable} {
+		for name, cmd := range table {
+			if cmd.categories&category != 0 {
+				acl.allowed[name] = allow
+			}
+		}
+	}
+}
+
+// check 返回 nil 表示允许执行，未知命令交给后续流程返回 unknown command
+func (acl *aclRules) check(cmdName string) redis.Reply {
+	if acl == nil {
+		return nil
+	}
+	if _, ok := lookupCommand(cmdName); !ok {
+		return nil
+	}
+	if acl.allowed[cmdName] {
+		return nil
+	}
+	return protocol.MakeErrReply("NOPERM this user has no permissions to run the '" + cmdName + "' command")
+}
+
+func init() {
+	registerServerCommand("Auth", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagLoading, redisFlagStale, redisFlagSkipMonitor, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("Hello", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagLoading, redisFlagStale, redisFlagSkipMonitor, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("Acl", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Client", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagRandom, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Ping", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagFast, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Info", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("DbSize", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("Select", 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagLoading, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("Command", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagRandom, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Subscribe", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading}, 0, 0, 0)
+	registerServerCommand("Unsubscribe", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading}, 0, 0, 0)
+	registerServerCommand("PSubscribe", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading}, 0, 0, 0)
+	registerServerCommand("PUnsubscribe", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagNoScript, redisFlagLoading}, 0, 0, 0)
+	registerServerCommand("FlushDB", -1, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite}, 0, 0, 0)
+	registerServerCommand("FlushAll", -1, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite}, 0, 0, 0)
+	registerServerCommand("SwapDB", 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("ExpirePattern", -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
+	registerServerCommand("PersistPattern", -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
+	registerServerCommand("Config", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Monitor", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Slowlog", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagRandom, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Hotkeys", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagRandom, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("BGRewriteAOF", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin}, 0, 0, 0)
+	registerServerCommand("RewriteAOF", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin}, 0, 0, 0)
+	registerServerCommand("Save", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
+	registerServerCommand("BGSave", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
+	registerServerCommand("Shutdown", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("LastSave", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagRandom, redisFlagLoading, redisFlagStale, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("PSync", -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
+	registerServerCommand("Sync", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript}, 0, 0, 0)
+	registerServerCommand("ReplConf", -1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("SlaveOf", 3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("ReplicaOf", 3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Sentinel", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Asking", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagFast}, 0, 0, 0)
+	registerServerCommand("Cluster", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin}, 0, 0, 0)
+	registerServerCommand("Debug", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagAdmin, redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("Multi", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagLoading, redisFlagStale, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("Exec", 1, flagWrite).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagLoading, redisFlagStale}, 0, 0, 0)
+	registerServerCommand("WaitSync", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript}, 0, 0, 0)
+	registerServerCommand("Discard", 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagLoading, redisFlagStale, redisFlagFast}, 0, 0, 0)
+	registerServerCommand("Watch", -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagLoading, redisFlagStale, redisFlagFast}, 1, -1, 1)
+}
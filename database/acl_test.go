@@ -0,0 +1,223 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestCommandCategories(t *testing.T) {
+	tests := []struct {
+		name       string
+		categories string
+	}{
+		{"get", "@read @string @fast"},
+		{"set", "@write @string @slow"},
+		{"keys", "@keyspace @read @slow @dangerous"},
+		{"flushall", "@keyspace @write @slow @dangerous"},
+		{"ping", "@fast @connection"},
+		{"bgsave", "@admin @slow @dangerous"},
+		{"publish", "@pubsub @fast"},
+		{"multi", "@fast @transaction"},
+		{"object", "@keyspace @read @slow"},
+		{"zpeekmin", "@read @sortedset @fast"},
+	}
+	for _, tt := range tests {
+		cmd, ok := lookupCommand(tt.name)
+		if !ok {
+			t.Fatalf("command %s not found", tt.name)
+		}
+		if actual := strings.Join(cmd.categories.names(), " "); actual != tt.categories {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.categories, actual)
+		}
+	}
+}
+
+// 每个命令至少属于一个类型或者功能分组，只有 @read/@write/@fast/@slow 说明 commandTypeCategories 中漏掉了它
+func TestEveryCommandCategorized(t *testing.T) {
+	groups := aclKeyspace | aclString | aclBitmap | aclHash | aclList | aclSet | aclSortedSet | aclStream | aclGeo |
+		aclPubSub | aclAdmin | aclDangerous | aclConnection | aclTransaction
+	for _, table := range []map[string]*command{cmdTable, serverCmdTable} {
+		for name, cmd := range table {
+			if cmd.categories&groups == 0 {
+				t.Errorf("%s has no category group: %v", name, cmd.categories.names())
+			}
+		}
+	}
+}
+
+func TestCommandInfo(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "INFO", "get", "nosuchcmd")),
+		"*2\r\n*7\r\n$3\r\nget\r\n:2\r\n*2\r\n$8\r\nreadonly\r\n$4\r\nfast\r\n:1\r\n:1\r\n:1\r\n"+
+			"*3\r\n$5\r\n@read\r\n$7\r\n@string\r\n$5\r\n@fast\r\n$-1\r\n")
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2")),
+		"*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "GETKEYS", "sinterstore", "dest", "k1", "k2")),
+		"*3\r\n$4\r\ndest\r\n$2\r\nk1\r\n$2\r\nk2\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "GETKEYS", "RPOPLPUSH", "src", "dst")),
+		"*2\r\n$3\r\nsrc\r\n$3\r\ndst\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "GETKEYS", "PING")), "-ERR The command has no key arguments\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "GETKEYS", "GET")), "-ERR Invalid number of arguments specified for command\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "GETKEYS", "nosuchcmd", "a")), "-ERR Invalid command specified\r\n")
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("COMMAND", "DOCS", "get", "nosuchcmd", "lpush")),
+		"*4\r\n$3\r\nget\r\n*2\r\n$5\r\ngroup\r\n$6\r\nstring\r\n$5\r\nlpush\r\n*2\r\n$5\r\ngroup\r\n$4\r\nlist\r\n")
+	count := server.Exec(conn, utils.ToCmdLine("COMMAND", "COUNT")).(*protocol.IntReply).Code
+	if all := server.Exec(conn, utils.ToCmdLine("COMMAND", "INFO")).(*protocol.MultiRawReply); int64(len(all.Replies)) != count {
+		t.Errorf("COMMAND INFO should return %d commands, got %d", count, len(all.Replies))
+	}
+}
+
+func TestAclDefaultRules(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:       16,
+		AclDefaultRules: "+@all -@dangerous +info",
+	})
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "k", "v")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("KEYS", "*")),
+		"-NOPERM this user has no permissions to run the 'keys' command\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHALL")),
+		"-NOPERM this user has no permissions to run the 'flushall' command\r\n")
+	if reply := server.Exec(conn, utils.ToCmdLine("INFO", "server")); strings.HasPrefix(string(reply.ToBytes()), "-") {
+		t.Errorf("info should be allowed, got %q", reply.ToBytes())
+	}
+
+	server = NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:       16,
+		AclDefaultRules: "-@all +@read",
+	})
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$-1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "k", "v")),
+		"-NOPERM this user has no permissions to run the 'set' command\r\n")
+
+	if _, err := parseAclRules("+@nosuchcategory"); err == nil {
+		t.Error("expected error for unknown category")
+	}
+	if _, err := parseAclRules("+nosuchcmd"); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
+
+func TestAclUserQuotas(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases: 16,
+		AclUsers:  []string{"app1 pw1 maxclients 2 maxinflight 1", "app2 pw2"},
+	})
+	defer server.Close()
+	conns := make([]*connection.FakeConn, 4)
+	for i := range conns {
+		conns[i] = connection.NewFakeConn()
+		server.AfterClientConnect(conns[i])
+	}
+	assertReply(t, server.Exec(conns[0], utils.ToCmdLine("AUTH", "app1", "wrong")),
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	assertReply(t, server.Exec(conns[0], utils.ToCmdLine("AUTH", "app1", "pw1")), "+OK\r\n")
+	// 重复登录同一个用户不占用新的名额
+	assertReply(t, server.Exec(conns[0], utils.ToCmdLine("AUTH", "app1", "pw1")), "+OK\r\n")
+	assertReply(t, server.Exec(conns[1], utils.ToCmdLine("AUTH", "app1", "pw1")), "+OK\r\n")
+	assertReply(t, server.Exec(conns[2], utils.ToCmdLine("AUTH", "app1", "pw1")),
+		"-QUOTA max number of clients reached for user 'app1'\r\n")
+	if !conns[2].IsKilled() {
+		t.Error("connection over quota should be closed")
+	}
+	if list := string(server.Exec(conns[0], utils.ToCmdLine("CLIENT", "LIST")).ToBytes()); !strings.Contains(list, " user=app1 ") ||
+		!strings.Contains(list, " user=default ") {
+		t.Errorf("unexpected client list %s", list)
+	}
+
+	// 断开连接或者切换到其它用户后归还名额
+	server.AfterClientClose(conns[1])
+	if reply := server.Exec(conns[3], utils.ToCmdLine("HELLO", "2", "AUTH", "app1", "pw1")); strings.HasPrefix(string(reply.ToBytes()), "-") {
+		t.Fatalf("hello auth failed: %q", reply.ToBytes())
+	}
+	assertReply(t, server.Exec(conns[3], utils.ToCmdLine("AUTH", "app2", "pw2")), "+OK\r\n")
+	assertReply(t, server.Exec(conns[1], utils.ToCmdLine("AUTH", "app1", "pw1")), "+OK\r\n")
+
+	// 阻塞命令在等待期间占用执行名额
+	done := make(chan redis.Reply)
+	go func() {
+		done <- server.Exec(conns[0], utils.ToCmdLine("BLPOP", "queue", "0"))
+	}()
+	for server.users["app1"].inflight.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	assertReply(t, server.Exec(conns[1], utils.ToCmdLine("GET", "k")),
+		"-QUOTA max number of in-flight commands reached for user 'app1'\r\n")
+	assertReply(t, server.Exec(conns[3], utils.ToCmdLine("RPUSH", "queue", "a")), ":1\r\n")
+	assertReply(t, <-done, "*2\r\n$5\r\nqueue\r\n$1\r\na\r\n")
+	assertReply(t, server.Exec(conns[1], utils.ToCmdLine("GET", "k")), "$-1\r\n")
+
+	if _, err := parseAclUsers([]string{"default pw"}, newAclRules()); err == nil {
+		t.Error("expected error for default user")
+	}
+	if _, err := parseAclUsers([]string{"app pw maxclients -1"}, newAclRules()); err == nil {
+		t.Error("expected error for negative quota")
+	}
+}
+
+func TestAclSetUser(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16, RequirePass: "root"})
+	defer server.Close()
+	admin := connection.NewFakeConn()
+	server.AfterClientConnect(admin)
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("GET", "k")), "-NOAUTH Authentication required.\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("AUTH", "wrong")),
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("AUTH", "root")), "+OK\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "WHOAMI")), "$7\r\ndefault\r\n")
+
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "SETUSER", "reader", "on", ">secret", "~cache:*", "+@read", "+nosuchcmd")),
+		"-ERR Error in ACL SETUSER modifier '+nosuchcmd': Unknown command 'nosuchcmd'\r\n")
+	// 出错时不创建用户
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "GETUSER", "reader")), "$-1\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "SETUSER", "reader", "on", ">secret", "~cache:*", "+@read")), "+OK\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "USERS")), "*2\r\n$7\r\ndefault\r\n$6\r\nreader\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "GETUSER", "reader")),
+		"*8\r\n$5\r\nflags\r\n*1\r\n$2\r\non\r\n$9\r\npasswords\r\n*1\r\n$64\r\n"+hashPassword("secret")+"\r\n"+
+			"$8\r\ncommands\r\n$6\r\n+@read\r\n$4\r\nkeys\r\n$8\r\n~cache:*\r\n")
+	server.Exec(admin, utils.ToCmdLine("SET", "cache:1", "v"))
+	server.Exec(admin, utils.ToCmdLine("SET", "other", "v"))
+
+	conn := connection.NewFakeConn()
+	server.AfterClientConnect(conn)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("AUTH", "reader", "secret")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("ACL", "WHOAMI")),
+		"-NOPERM this user has no permissions to run the 'acl' command\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "cache:1")), "$1\r\nv\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MGET", "cache:1", "other")),
+		"-NOPERM this user has no permissions to access one of the keys used as arguments\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "cache:1", "w")),
+		"-NOPERM this user has no permissions to run the 'set' command\r\n")
+
+	// 修改之后对已经登录的连接立即生效
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "SETUSER", "reader", "+set", "allkeys")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "other", "w")), "+OK\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "LIST")),
+		"*2\r\n$"+strconv.Itoa(len("user default on #"+hashPassword("root")+" ~* +@all"))+"\r\nuser default on #"+hashPassword("root")+" ~* +@all\r\n"+
+			"$"+strconv.Itoa(len("user reader on #"+hashPassword("secret")+" ~* +@read +set"))+"\r\nuser reader on #"+hashPassword("secret")+" ~* +@read +set\r\n")
+
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "DELUSER", "default")), "-ERR The 'default' user cannot be removed\r\n")
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "DELUSER", "reader", "nosuchuser")), ":1\r\n")
+	if !conn.IsKilled() {
+		t.Error("connections of deleted user should be closed")
+	}
+	assertReply(t, server.Exec(admin, utils.ToCmdLine("ACL", "SETUSER", "default", "nopass")), "+OK\r\n")
+	fresh := connection.NewFakeConn()
+	server.AfterClientConnect(fresh)
+	assertReply(t, server.Exec(fresh, utils.ToCmdLine("GET", "other")), "$1\r\nw\r\n")
+}
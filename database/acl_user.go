@@ -0,0 +1,425 @@
+package database
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/wildcard"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 用户和权限
+// 每个用户有自己的密码、命令权限和可以访问的 key:
+//   - default 用户的密码是 requirepass(没有设置时为 nopass)，命令权限是 acl-default-rules，可以访问所有 key
+//   - acl-users 配置的用户用 AUTH username password 或 HELLO ... AUTH username password 登录，初始权限与 default 用户相同
+//   - ACL SETUSER 创建或修改用户，新建的用户是 off、没有密码、不能执行任何命令也不能访问任何 key
+//
+// 权限在分发命令之前检查，修改之后对已经登录的连接立即生效。default 用户需要密码或者被禁用时，
+// 新连接必须先用 AUTH/HELLO 登录，否则返回 NOAUTH。
+// maxclients 限制同时登录为该用户的连接数，超过时 AUTH 返回 QUOTA 错误并断开连接；
+// maxinflight 限制该用户同时执行中的命令数(包括等待中的阻塞命令)，超过时命令返回 QUOTA 错误。
+// 0 表示不限制，default 用户的连接数由 maxclients 限制
+
+const defaultUser = "default"
+
+var wrongPassReply = protocol.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+
+var noAuthReply = protocol.MakeErrReply("NOAUTH Authentication required.")
+
+type aclUser struct {
+	name        string
+	maxClients  int64
+	maxInflight int64
+
+	clients  atomic.Int64
+	inflight atomic.Int64
+
+	// 密码和权限，ACL SETUSER 修改时整体替换
+	perm atomic.Pointer[aclPermissions]
+}
+
+// aclPermissions 创建之后只读
+type aclPermissions struct {
+	enabled bool
+	nopass  bool
+	// 密码的 sha256，小写十六进制
+	passwords []string
+	commands  *aclRules
+	allKeys   bool
+	keys      []aclKeyPattern
+}
+
+type aclKeyPattern struct {
+	source  string
+	pattern *wildcard.Pattern
+}
+
+func newAclUser(name string, perm *aclPermissions) *aclUser {
+	user := &aclUser{name: name}
+	user.perm.Store(perm)
+	return user
+}
+
+// newDefaultUser requirepass 为空时 default 用户不需要密码
+func newDefaultUser(requirePass string, commands *aclRules) *aclUser {
+	perm := &aclPermissions{
+		enabled:  true,
+		nopass:   requirePass == "",
+		commands: commands.clone(),
+		allKeys:  true,
+	}
+	if requirePass != "" {
+		perm.passwords = []string{hashPassword(requirePass)}
+	}
+	return newAclUser(defaultUser, perm)
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *aclPermissions) checkPassword(password string) bool {
+	if p.nopass {
+		return true
+	}
+	hash := []byte(hashPassword(password))
+	for _, stored := range p.passwords {
+		if subtle.ConstantTimeCompare(hash, []byte(stored)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresAuth default 用户需要密码或者被禁用时，新连接需要登录
+func (p *aclPermissions) requiresAuth() bool {
+	return !p.enabled || !p.nopass
+}
+
+func (p *aclPermissions) keyAllowed(key string) bool {
+	if p.allKeys {
+		return true
+	}
+	for _, k := range p.keys {
+		if k.pattern.IsMatch(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *aclPermissions) clone() *aclPermissions {
+	next := *p
+	next.passwords = slices.Clone(p.passwords)
+	next.commands = p.commands.clone()
+	next.keys = slices.Clone(p.keys)
+	return &next
+}
+
+// apply 应用 ACL SETUSER 的一条规则
+func (p *aclPermissions) apply(rule string) error {
+	if rule == "" {
+		return errors.New("Syntax error")
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		p.enabled = true
+	case "off":
+		p.enabled = false
+	case "nopass":
+		p.nopass = true
+		p.passwords = nil
+	case "resetpass":
+		p.nopass = false
+		p.passwords = nil
+	case "allkeys", "~*":
+		p.allKeys = true
+		p.keys = nil
+	case "resetkeys":
+		p.allKeys = false
+		p.keys = nil
+	case "reset":
+		*p = aclPermissions{commands: newAclRules()}
+	case "allcommands", "nocommands":
+		return p.commands.apply(rule)
+	default:
+		switch rule[0] {
+		case '>':
+			p.addPassword(hashPassword(rule[1:]))
+		case '#':
+			hash := rule[1:]
+			if !validPasswordHash(hash) {
+				return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+			}
+			p.addPassword(hash)
+		case '<', '!':
+			hash := rule[1:]
+			if rule[0] == '<' {
+				hash = hashPassword(hash)
+			}
+			i := slices.Index(p.passwords, hash)
+			if i < 0 {
+				return errors.New("The password you are trying to remove from the user does not exist")
+			}
+			p.passwords = slices.Delete(p.passwords, i, i+1)
+		case '~':
+			if p.allKeys {
+				return errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. " +
+					"Try 'resetkeys' to start with an empty list of patterns")
+			}
+			pattern, err := wildcard.CompilePattern(rule[1:])
+			if err != nil {
+				return errors.New("Invalid key pattern")
+			}
+			p.keys = append(p.keys, aclKeyPattern{source: rule[1:], pattern: pattern})
+		case '+', '-':
+			if err := p.commands.apply(rule); err != nil {
+				return errors.New(strings.TrimPrefix(err.Error(), "ERR "))
+			}
+		default:
+			return errors.New("Syntax error")
+		}
+	}
+	return nil
+}
+
+func (p *aclPermissions) addPassword(hash string) {
+	p.nopass = false
+	if !slices.Contains(p.passwords, hash) {
+		p.passwords = append(p.passwords, hash)
+	}
+}
+
+func validPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for i := 0; i < len(hash); i++ {
+		if (hash[i] < '0' || hash[i] > '9') && (hash[i] < 'a' || hash[i] > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// flags 返回 ACL GETUSER 中的 flags
+func (p *aclPermissions) flags() []string {
+	flags := []string{"off"}
+	if p.enabled {
+		flags[0] = "on"
+	}
+	if p.nopass {
+		flags = append(flags, "nopass")
+	}
+	if p.allKeys {
+		flags = append(flags, "allkeys")
+	}
+	return flags
+}
+
+// keysString 返回 ~pattern 形式的 key 权限，allkeys 为 ~*
+func (p *aclPermissions) keysString() string {
+	if p.allKeys {
+		return "~*"
+	}
+	patterns := make([]string, len(p.keys))
+	for i, k := range p.keys {
+		patterns[i] = "~" + k.source
+	}
+	return strings.Join(patterns, " ")
+}
+
+// describe 返回 ACL LIST 中的一行，例如 user default on nopass ~* +@all
+func (p *aclPermissions) describe(name string) string {
+	parts := []string{"user", name}
+	if p.enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if p.nopass {
+		parts = append(parts, "nopass")
+	}
+	for _, hash := range p.passwords {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := p.keysString(); keys != "" {
+		parts = append(parts, keys)
+	}
+	parts = append(parts, p.commands.String())
+	return strings.Join(parts, " ")
+}
+
+// parseAclUser 解析 "<name> <password> [maxclients N] [maxinflight N]"，命令权限为 commands
+func parseAclUser(spec string, commands *aclRules) (*aclUser, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 || len(fields)%2 != 0 {
+		return nil, errors.New("acl-users " + strconv.Quote(spec) + ": expected \"<name> <password> [maxclients N] [maxinflight N]\"")
+	}
+	if strings.EqualFold(fields[0], defaultUser) {
+		return nil, errors.New("acl-users " + strconv.Quote(spec) + ": password of default user is requirepass")
+	}
+	user := newAclUser(fields[0], &aclPermissions{
+		enabled:   true,
+		passwords: []string{hashPassword(fields[1])},
+		commands:  commands.clone(),
+		allKeys:   true,
+	})
+	for i := 2; i < len(fields); i += 2 {
+		value, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || value < 0 {
+			return nil, errors.New("acl-users " + strconv.Quote(spec) + ": invalid " + fields[i] + " " + fields[i+1])
+		}
+		switch strings.ToLower(fields[i]) {
+		case "maxclients":
+			user.maxClients = value
+		case "maxinflight":
+			user.maxInflight = value
+		default:
+			return nil, errors.New("acl-users " + strconv.Quote(spec) + ": unknown option " + fields[i])
+		}
+	}
+	return user, nil
+}
+
+func parseAclUsers(specs []string, commands *aclRules) (map[string]*aclUser, error) {
+	users := make(map[string]*aclUser)
+	for _, spec := range specs {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		user, err := parseAclUser(spec, commands)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := users[user.name]; ok {
+			return nil, errors.New("acl-users: duplicate user " + user.name)
+		}
+		users[user.name] = user
+	}
+	return users, nil
+}
+
+// lookupUser 按名字查找用户，不存在时返回 nil
+func (server *Server) lookupUser(name string) *aclUser {
+	server.usersMu.RLock()
+	defer server.usersMu.RUnlock()
+	return server.users[name]
+}
+
+// connUser 返回连接登录的用户，没有登录时是 default 用户
+func (server *Server) connUser(c redis.Connection) *aclUser {
+	name := c.GetUser()
+	if name == "" {
+		name = defaultUser
+	}
+	return server.lookupUser(name)
+}
+
+// AfterClientConnect 中调用，default 用户需要登录时新连接先标记为未登录
+func (server *Server) initClientAuth(c redis.Connection) {
+	if user := server.lookupUser(defaultUser); user != nil && user.perm.Load().requiresAuth() {
+		c.SetAuthenticated(false)
+	}
+}
+
+// checkPermission 检查连接所属的用户能否执行命令以及访问命令中的 key，
+// 主节点的连接以及重放 aof 的辅助实例(没有用户)不检查
+func (server *Server) checkPermission(c redis.Connection, cmdName string, cmdLine [][]byte) redis.Reply {
+	if c == nil || c.IsMaster() || server.users == nil {
+		return nil
+	}
+	user := server.connUser(c)
+	if user == nil {
+		// 登录之后用户被删除
+		return protocol.MakeErrReply("NOPERM this user has no permissions to run the '" + cmdName + "' command")
+	}
+	perm := user.perm.Load()
+	if errReply := perm.commands.check(cmdName); errReply != nil {
+		return errReply
+	}
+	if perm.allKeys {
+		return nil
+	}
+	cmd, ok := lookupCommand(cmdName)
+	if !ok {
+		return nil
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		// 交给后续流程返回参数个数错误
+		return nil
+	}
+	for _, key := range commandKeyArgs(cmd, cmdLine) {
+		if !perm.keyAllowed(string(key)) {
+			return protocol.MakeErrReply("NOPERM this user has no permissions to access one of the keys used as arguments")
+		}
+	}
+	return nil
+}
+
+// authenticate 以 username 登录，成功后占用该用户的一个连接名额并释放之前用户的名额
+func (server *Server) authenticate(c redis.Connection, username, password string) redis.Reply {
+	user := server.lookupUser(username)
+	if user == nil {
+		return wrongPassReply
+	}
+	if perm := user.perm.Load(); !perm.enabled || !perm.checkPassword(password) {
+		return wrongPassReply
+	}
+	if username == defaultUser {
+		server.releaseUser(c)
+		c.SetUser("")
+		c.SetPassword(password)
+		c.SetAuthenticated(true)
+		return nil
+	}
+	if c.GetUser() == username {
+		c.SetPassword(password)
+		c.SetAuthenticated(true)
+		return nil
+	}
+	if n := user.clients.Add(1); user.maxClients > 0 && n > user.maxClients {
+		user.clients.Add(-1)
+		// 与 redis 的 maxclients 相同，拒绝之后断开连接
+		c.Kill()
+		return protocol.MakeErrReply("QUOTA max number of clients reached for user '" + username + "'")
+	}
+	server.releaseUser(c)
+	c.SetUser(username)
+	c.SetPassword(password)
+	c.SetAuthenticated(true)
+	return nil
+}
+
+// releaseUser 归还连接占用的用户名额
+func (server *Server) releaseUser(c redis.Connection) {
+	if name := c.GetUser(); name != "" {
+		if user := server.lookupUser(name); user != nil {
+			user.clients.Add(-1)
+		}
+	}
+}
+
+// acquireInflight 占用连接所属用户的一个执行名额，返回的函数归还名额
+func (server *Server) acquireInflight(c redis.Connection) (func(), redis.Reply) {
+	if c == nil || c.GetUser() == "" {
+		return nil, nil
+	}
+	user := server.lookupUser(c.GetUser())
+	if user == nil || user.maxInflight == 0 {
+		return nil, nil
+	}
+	if user.inflight.Add(1) > user.maxInflight {
+		user.inflight.Add(-1)
+		return nil, protocol.MakeErrReply("QUOTA max number of in-flight commands reached for user '" + user.name + "'")
+	}
+	return func() { user.inflight.Add(-1) }, nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// execAcl ACL SETUSER | GETUSER | DELUSER | LIST | USERS | WHOAMI | CAT
+func (server *Server) execAcl(c redis.Connection, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "setuser":
+		if len(args) < 2 {
+			return protocol.MakeArgNumErrReply("acl|setuser")
+		}
+		return server.aclSetUser(string(args[1]), args[2:])
+	case "getuser":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("acl|getuser")
+		}
+		return server.aclGetUser(string(args[1]))
+	case "deluser":
+		if len(args) < 2 {
+			return protocol.MakeArgNumErrReply("acl|deluser")
+		}
+		return server.aclDelUser(args[1:])
+	case "list", "users":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("acl|" + subCmd)
+		}
+		server.usersMu.RLock()
+		names := slices.Sorted(maps.Keys(server.users))
+		lines := make([][]byte, len(names))
+		for i, name := range names {
+			if subCmd == "list" {
+				lines[i] = []byte(server.users[name].perm.Load().describe(name))
+			} else {
+				lines[i] = []byte(name)
+			}
+		}
+		server.usersMu.RUnlock()
+		return protocol.MakeMultiBulkReply(lines)
+	case "whoami":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("acl|whoami")
+		}
+		name := defaultUser
+		if c != nil && c.GetUser() != "" {
+			name = c.GetUser()
+		}
+		return protocol.MakeBulkReply([]byte(name))
+	case "cat":
+		if len(args) > 2 {
+			return protocol.MakeArgNumErrReply("acl|cat")
+		}
+		return execAclCat(args[1:])
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try ACL HELP.")
+}
+
+// aclSetUser 所有规则都合法时才修改用户，已经登录的连接立即使用新的权限
+func (server *Server) aclSetUser(name string, rules [][]byte) redis.Reply {
+	server.usersMu.Lock()
+	defer server.usersMu.Unlock()
+	user := server.users[name]
+	var perm *aclPermissions
+	if user != nil {
+		perm = user.perm.Load().clone()
+	} else {
+		perm = &aclPermissions{commands: newAclRules()}
+	}
+	for _, rule := range rules {
+		if err := perm.apply(string(rule)); err != nil {
+			return protocol.MakeErrReply("ERR Error in ACL SETUSER modifier '" + string(rule) + "': " + err.Error())
+		}
+	}
+	if user == nil {
+		server.users[name] = newAclUser(name, perm)
+	} else {
+		user.perm.Store(perm)
+	}
+	return protocol.MakeOkReply()
+}
+
+func (server *Server) aclGetUser(name string) redis.Reply {
+	user := server.lookupUser(name)
+	if user == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	perm := user.perm.Load()
+	flags := make([][]byte, 0, 3)
+	for _, flag := range perm.flags() {
+		flags = append(flags, []byte(flag))
+	}
+	passwords := make([][]byte, len(perm.passwords))
+	for i, hash := range perm.passwords {
+		passwords[i] = []byte(hash)
+	}
+	return protocol.MakeMapReply([]redis.Reply{
+		protocol.MakeBulkReply([]byte("flags")), protocol.MakeMultiBulkReply(flags),
+		protocol.MakeBulkReply([]byte("passwords")), protocol.MakeMultiBulkReply(passwords),
+		protocol.MakeBulkReply([]byte("commands")), protocol.MakeBulkReply([]byte(perm.commands.String())),
+		protocol.MakeBulkReply([]byte("keys")), protocol.MakeBulkReply([]byte(perm.keysString())),
+	})
+}
+
+// aclDelUser 删除用户并断开以这些用户登录的连接，返回删除的个数
+func (server *Server) aclDelUser(names [][]byte) redis.Reply {
+	deleted := make(map[string]struct{})
+	server.usersMu.Lock()
+	for _, arg := range names {
+		name := string(arg)
+		if name == defaultUser {
+			server.usersMu.Unlock()
+			return protocol.MakeErrReply("ERR The 'default' user cannot be removed")
+		}
+		if _, ok := server.users[name]; ok {
+			deleted[name] = struct{}{}
+		}
+	}
+	for name := range deleted {
+		delete(server.users, name)
+	}
+	server.usersMu.Unlock()
+	if len(deleted) > 0 {
+		for _, client := range server.listClients() {
+			if _, ok := deleted[client.GetUser()]; ok {
+				client.Kill()
+			}
+		}
+	}
+	return protocol.MakeIntReply(int64(len(deleted)))
+}
+
+// execAclCat 没有参数时返回所有分类，否则返回分类中的命令
+func execAclCat(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		names := make([][]byte, len(aclCategoryNames))
+		for i, c := range aclCategoryNames {
+			names[i] = []byte(c.name)
+		}
+		return protocol.MakeMultiBulkReply(names)
+	}
+	category, ok := parseAclCategory(string(args[0]))
+	if !ok || category == aclAll {
+		return protocol.MakeErrReply("ERR Unknown category '" + string(args[0]) + "'")
+	}
+	var names []string
+	for _, table := range []map[string]*command{cmdTable, serverCmdTable} {
+		for name, cmd := range table {
+			if cmd.categories&category != 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	result := make([][]byte, len(names))
+	for i, name := range names {
+		result[i] = []byte(name)
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
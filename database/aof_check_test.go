@@ -0,0 +1,95 @@
+package database
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+// writeCheckAof 写入两个 key 之后关闭，返回 aof 的路径
+func writeCheckAof(t *testing.T, cfg *config.ServerProperties) string {
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "2"))
+	server.Close()
+	return cfg.AppendFilePath()
+}
+
+func appendToFile(t *testing.T, filename, data string) {
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// startPanics 返回启动时的 panic，正常启动时返回 nil
+func startPanics(cfg *config.ServerProperties) (recovered any) {
+	defer func() {
+		recovered = recover()
+	}()
+	NewStandaloneServerWithConfig(cfg).Close()
+	return nil
+}
+
+func TestAofLoadTruncated(t *testing.T) {
+	cfg := makeCrashTestConfig(t.TempDir())
+	filename := writeCheckAof(t, cfg)
+	info, _ := os.Stat(filename)
+	validSize := info.Size()
+	appendToFile(t, filename, "*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$2\r\n3")
+
+	result, err := aof.CheckAof(filename)
+	if err != nil || !errors.Is(result.Err, aof.ErrAofTruncated) || result.ValidSize != validSize {
+		t.Fatalf("unexpected check result %+v, %v", result, err)
+	}
+	// 严格模式拒绝启动，文件保持不变
+	cfg.AofLoadTruncated = "no"
+	if r := startPanics(cfg); r == nil || !strings.Contains(r.(error).Error(), "truncated") {
+		t.Fatalf("expected refusing to start, got %v", r)
+	}
+	if info, _ := os.Stat(filename); info.Size() == validSize {
+		t.Fatal("strict mode should not modify the file")
+	}
+	// 默认截断不完整的命令后加载
+	cfg.AofLoadTruncated = ""
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MGET", "a", "b", "c")), "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$-1\r\n")
+	// 之后追加的命令接在完整的命令后面
+	server.Exec(conn, utils.ToCmdLine("SET", "c", "3"))
+	server.Close()
+	if result, err := aof.CheckAof(filename); err != nil || result.Err != nil {
+		t.Fatalf("expected valid aof after truncating, got %+v, %v", result, err)
+	}
+}
+
+func TestAofCorrupted(t *testing.T) {
+	cfg := makeCrashTestConfig(t.TempDir())
+	filename := writeCheckAof(t, cfg)
+	info, _ := os.Stat(filename)
+	validSize := info.Size()
+	appendToFile(t, filename, "*2\r\n$3\r\nGET\r\nxx\r\n*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$1\r\n3\r\n")
+
+	// 中间损坏时总是拒绝启动
+	if r := startPanics(cfg); r == nil || !strings.Contains(r.(error).Error(), "check-aof -fix") {
+		t.Fatalf("expected refusing to start, got %v", r)
+	}
+	result, err := aof.FixAof(filename)
+	if err != nil || !errors.Is(result.Err, aof.ErrAofCorrupted) || result.ValidSize != validSize {
+		t.Fatalf("unexpected fix result %+v, %v", result, err)
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	assertReply(t, server.Exec(connection.NewFakeConn(), utils.ToCmdLine("MGET", "a", "b", "c")), "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$-1\r\n")
+}
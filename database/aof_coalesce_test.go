@@ -0,0 +1,74 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestAofCoalesce(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &config.ServerProperties{
+		Dir:               dir,
+		AppendOnly:        true,
+		AppendFilename:    "appendonly.aof",
+		AppendFsync:       "everysec",
+		AofCoalesceWindow: 1000,
+		Databases:         16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(server.Exec(conn, utils.ToCmdLine(args...)).ToBytes())
+	}
+	for i := 0; i < 1000; i++ {
+		exec("INCR", "counter")
+		exec("DECRBY", "down", "2")
+	}
+	exec("SET", "str", "10", "EX", "1000")
+	exec("INCRBY", "str", "5")
+	exec("SET", "str", "20", "KEEPTTL")
+	exec("INCR", "str")
+	exec("SET", "text", "abc")
+	exec("SET", "text", "abcd")
+	// 屏障之后的命令不能合并到屏障之前
+	exec("SET", "moved", "1")
+	exec("RENAME", "moved", "target")
+	exec("SET", "moved", "2")
+	exec("INCR", "moved")
+	server.Close()
+	if n := server.persister.CoalescedCommands(); n < 1990 {
+		t.Fatalf("expected most commands to be coalesced, got %d", n)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "appendonly.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "*3\r\n") + strings.Count(string(data), "*5\r\n"); n > 10 {
+		t.Fatalf("too many records in aof: %d\n%s", n, data)
+	}
+
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	for _, tt := range [][]string{
+		{"GET", "counter", "$4\r\n1000\r\n"},
+		{"GET", "down", "$5\r\n-2000\r\n"},
+		{"GET", "str", "$2\r\n21\r\n"},
+		{"GET", "text", "$4\r\nabcd\r\n"},
+		{"GET", "target", "$1\r\n1\r\n"},
+		{"GET", "moved", "$1\r\n3\r\n"},
+	} {
+		if actual := exec(tt[:2]...); actual != tt[2] {
+			t.Fatalf("%v: expected %q, got %q", tt[:2], tt[2], actual)
+		}
+	}
+	if actual := exec("TTL", "str"); actual == ":-1\r\n" || actual == ":-2\r\n" {
+		t.Fatalf("expected ttl to be kept, got %q", actual)
+	}
+}
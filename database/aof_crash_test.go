@@ -0,0 +1,130 @@
+package database
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+const crashDirEnv = "GO_REDIS_CRASH_DIR"
+
+func makeCrashTestConfig(dir string) *config.ServerProperties {
+	return &config.ServerProperties{
+		Dir:            dir,
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+}
+
+// runCrashChild keeps writing until it is killed, printing each acknowledged counter value
+func runCrashChild(dir string) {
+	server := NewStandaloneServerWithConfig(makeCrashTestConfig(dir))
+	conn := connection.NewFakeConn()
+	out := bufio.NewWriter(os.Stdout)
+	for i := 0; ; i++ {
+		ret := server.Exec(conn, utils.ToCmdLine("INCR", "counter"))
+		intReply, ok := ret.(*protocol.IntReply)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unexpected reply %q\n", ret.ToBytes())
+			os.Exit(1)
+		}
+		server.Exec(conn, utils.ToCmdLine("SET", "key"+strconv.Itoa(i%100), strconv.FormatInt(intReply.Code, 10)))
+		fmt.Fprintln(out, intReply.Code)
+		_ = out.Flush()
+	}
+}
+
+func loadCounter(t *testing.T, dir string) int64 {
+	server := NewStandaloneServerWithConfig(makeCrashTestConfig(dir))
+	defer server.Close()
+	ret := server.Exec(connection.NewFakeConn(), utils.ToCmdLine("GET", "counter"))
+	bulk, ok := ret.(*protocol.BulkReply)
+	if !ok {
+		return 0
+	}
+	val, err := strconv.ParseInt(string(bulk.Arg), 10, 64)
+	if err != nil {
+		t.Fatalf("counter is not an integer: %q", bulk.Arg)
+	}
+	return val
+}
+
+// TestAofCrashRecovery kills a writing process at random points and checks that
+// every acknowledged write survives and the aof replays to the same state every time
+func TestAofCrashRecovery(t *testing.T) {
+	if dir := os.Getenv(crashDirEnv); dir != "" {
+		runCrashChild(dir)
+		return
+	}
+	if testing.Short() {
+		t.Skip("skip crash recovery in short mode")
+	}
+	dir := t.TempDir()
+	var lastLoaded int64
+	for round := 0; round < 3; round++ {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestAofCrashRecovery$")
+		cmd.Env = append(os.Environ(), crashDirEnv+"="+dir)
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		acked := make(chan int64, 1024)
+		go func() {
+			defer close(acked)
+			scanner := bufio.NewScanner(stdout)
+			for scanner.Scan() {
+				val, err := strconv.ParseInt(scanner.Text(), 10, 64)
+				if err == nil {
+					acked <- val
+				}
+			}
+		}()
+
+		var lastAcked int64
+		deadline := time.After(time.Duration(50+rand.Intn(200)) * time.Millisecond)
+	wait:
+		for {
+			select {
+			case val, ok := <-acked:
+				if !ok {
+					break wait
+				}
+				lastAcked = val
+			case <-deadline:
+				break wait
+			}
+		}
+		_ = cmd.Process.Kill()
+		for val := range acked {
+			lastAcked = val
+		}
+		_ = cmd.Wait()
+
+		loaded := loadCounter(t, dir)
+		if loaded < lastAcked {
+			t.Fatalf("round %d: acknowledged %d but recovered %d", round, lastAcked, loaded)
+		}
+		if loaded < lastLoaded {
+			t.Fatalf("round %d: counter went backwards from %d to %d", round, lastLoaded, loaded)
+		}
+		if again := loadCounter(t, dir); again != loaded {
+			t.Fatalf("round %d: replay is not deterministic, %d != %d", round, loaded, again)
+		}
+		lastLoaded = loaded
+	}
+}
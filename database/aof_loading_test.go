@@ -0,0 +1,70 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func infoField(t *testing.T, server *Server, field string) string {
+	ret := server.Exec(connection.NewFakeConn(), utils.ToCmdLine("INFO", "persistence"))
+	bulk, ok := ret.(*protocol.BulkReply)
+	if !ok {
+		t.Fatalf("unexpected info reply %q", ret.ToBytes())
+	}
+	for _, line := range strings.Split(string(bulk.Arg), "\r\n") {
+		if strings.HasPrefix(line, field+":") {
+			return strings.TrimPrefix(line, field+":")
+		}
+	}
+	return ""
+}
+
+func TestAofLoadProgress(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "2"))
+	server.Close()
+
+	server = NewStandaloneServerWithConfig(cfg)
+	if v := infoField(t, server, "loading"); v != "0" {
+		t.Fatalf("expected loading:0, got %q", v)
+	}
+	// SET a, SELECT 1, SET b
+	if v := infoField(t, server, "loading_loaded_commands"); v != "3" {
+		t.Fatalf("expected 3 loaded commands, got %q", v)
+	}
+	if v := infoField(t, server, "loading_loaded_perc"); v != "100.00" {
+		t.Fatalf("expected 100.00%%, got %q", v)
+	}
+	server.Close()
+
+	cfg.AofLoadLazy = true
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for infoField(t, server, "loading") != "0" {
+		if time.Now().After(deadline) {
+			t.Fatal("lazy loading did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn = connection.NewFakeConn()
+	conn.SelectDB(1)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "b")), "$1\r\n2\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "c", "3")), "+OK\r\n")
+}
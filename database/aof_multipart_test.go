@@ -0,0 +1,128 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func makeMultiPartTestConfig(dir string) *config.ServerProperties {
+	cfg := makeCrashTestConfig(dir)
+	cfg.AppendDirname = "appendonlydir"
+	return cfg
+}
+
+func readTestManifest(t *testing.T, cfg *config.ServerProperties) *aof.Manifest {
+	m, err := aof.ReadManifest(cfg.AppendDirPath(), cfg.AppendFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestMultiPartAofRewrite(t *testing.T) {
+	for _, preamble := range []bool{false, true} {
+		cfg := makeMultiPartTestConfig(t.TempDir())
+		cfg.AofUseRdbPreamble = preamble
+		server := NewStandaloneServerWithConfig(cfg)
+		conn := connection.NewFakeConn()
+		for i := 0; i < 10; i++ {
+			server.Exec(conn, utils.ToCmdLine("SET", "k"+strconv.Itoa(i), strconv.Itoa(i)))
+		}
+		server.Exec(conn, utils.ToCmdLine("SELECT", "2"))
+		server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "a", "b"))
+		before := readTestManifest(t, cfg)
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("REWRITEAOF")), "+OK\r\n")
+		// 重写之后的命令写入新的 incr 文件，仍然在 db 2 中
+		server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "c"))
+		server.Exec(conn, utils.ToCmdLine("SELECT", "0"))
+		server.Exec(conn, utils.ToCmdLine("DEL", "k0"))
+
+		after := readTestManifest(t, cfg)
+		if len(after.Files) != 2 || after.Files[0].Type != "b" || after.Files[1].Type != "i" {
+			t.Fatalf("unexpected manifest after rewrite:\n%s", after)
+		}
+		for _, f := range before.Files {
+			if _, err := os.Stat(filepath.Join(cfg.AppendDirPath(), f.Name)); !os.IsNotExist(err) {
+				t.Errorf("old file %s should be removed", f.Name)
+			}
+		}
+		server.Close()
+
+		server = NewStandaloneServerWithConfig(cfg)
+		conn = connection.NewFakeConn()
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k0")), ":0\r\n")
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k9")), "$1\r\n9\r\n")
+		server.Exec(conn, utils.ToCmdLine("SELECT", "2"))
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("LRANGE", "list", "0", "-1")), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+		// 再次重写替换 base
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("REWRITEAOF")), "+OK\r\n")
+		if m := readTestManifest(t, cfg); m.Files[0].Seq != 2 || len(m.Files) != 2 {
+			t.Fatalf("unexpected manifest after second rewrite:\n%s", m)
+		}
+		server.Close()
+		server = NewStandaloneServerWithConfig(cfg)
+		conn = connection.NewFakeConn()
+		server.Exec(conn, utils.ToCmdLine("SELECT", "2"))
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("LLEN", "list")), ":3\r\n")
+		server.Close()
+	}
+}
+
+func TestMultiPartAofUpgrade(t *testing.T) {
+	cfg := makeCrashTestConfig(t.TempDir())
+	legacy := writeCheckAof(t, cfg)
+
+	cfg.AppendDirname = "appendonlydir"
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "b")), "$1\r\n2\r\n")
+	server.Exec(conn, utils.ToCmdLine("SET", "c", "3"))
+	server.Close()
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Fatalf("legacy aof should be moved into the aof dir")
+	}
+	if m := readTestManifest(t, cfg); len(m.Files) != 2 || m.Files[0].Type != "b" {
+		t.Fatalf("unexpected manifest:\n%s", m)
+	}
+
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MGET", "a", "b", "c")), "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n")
+}
+
+func TestMultiPartAofRecovery(t *testing.T) {
+	cfg := makeMultiPartTestConfig(t.TempDir())
+	writeCheckAof(t, cfg)
+	m := readTestManifest(t, cfg)
+	incr := filepath.Join(cfg.AppendDirPath(), m.Files[len(m.Files)-1].Name)
+	// 崩溃时最后一条命令只写了一半，重写中途留下的快照不在清单中
+	appendToFile(t, incr, "*3\r\n$3\r\nSET\r\n$1\r\nc\r\n$2\r\n3")
+	orphan := filepath.Join(cfg.AppendDirPath(), cfg.AppendFilename+".rewrite-1.tmp")
+	if err := os.WriteFile(orphan, []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MGET", "a", "b", "c")), "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$-1\r\n")
+	server.Close()
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("file not in manifest should be removed")
+	}
+
+	// 清单中的文件缺失时拒绝启动
+	if err := os.Remove(incr); err != nil {
+		t.Fatal(err)
+	}
+	if r := startPanics(cfg); r == nil {
+		t.Fatalf("expected refusing to start without a listed file")
+	}
+}
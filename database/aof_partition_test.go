@@ -0,0 +1,102 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func makePartitionTestConfig(dir string) *config.ServerProperties {
+	return &config.ServerProperties{
+		Dir:               dir,
+		AppendOnly:        true,
+		AppendFilename:    "appendonly.aof",
+		AppendFsync:       "always",
+		AofSlotPartitions: []string{"0-8191", "8192-16383"},
+		Databases:         16,
+		ClusterEnable:     true,
+	}
+}
+
+func TestPartitionedAof(t *testing.T) {
+	dir := t.TempDir()
+	// getSlot("a") = 15495, getSlot("b") = 3300, getSlot("c") = 7365
+	low := "b"
+	high := "a"
+	server := NewStandaloneServerWithConfig(makePartitionTestConfig(dir))
+	conn := connection.NewFakeConn()
+	exec := func(cmd ...string) string {
+		return string(server.Exec(conn, utils.ToCmdLine(cmd...)).ToBytes())
+	}
+	exec("SET", low, "1")
+	exec("SET", high, "2")
+	exec("FLUSHALL")
+	exec("SET", "c", "3")
+	exec("SELECT", "1")
+	exec("RPUSH", high, "x", "y")
+	server.Close()
+
+	lowFile, err := os.ReadFile(filepath.Join(dir, "appendonly.aof.0-8191"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	highFile, err := os.ReadFile(filepath.Join(dir, "appendonly.aof.8192-16383"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(lowFile), "RPUSH") || !strings.Contains(string(lowFile), "FlushAll") {
+		t.Errorf("unexpected low partition %q", lowFile)
+	}
+	if strings.Contains(string(highFile), "$1\r\nc\r\n") || !strings.Contains(string(highFile), "FlushAll") {
+		t.Errorf("unexpected high partition %q", highFile)
+	}
+
+	// FLUSHALL in one partition must not remove keys loaded from another one
+	server = NewStandaloneServerWithConfig(makePartitionTestConfig(dir))
+	conn = connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", low)), "$-1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "c")), "$1\r\n3\r\n")
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LRANGE", high, "0", "-1")), "*2\r\n$1\r\nx\r\n$1\r\ny\r\n")
+
+	// 跨槽位的命令只会记入一个分区，必须拒绝
+	server.Exec(conn, utils.ToCmdLine("SELECT", "0"))
+	crossSlot := "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MSET", high, "1", low, "2")), crossSlot)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("RENAME", "c", high)), crossSlot)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "{t}x", "v")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("RENAME", "{t}x", "{t}y")), "+OK\r\n")
+	server.Close()
+
+	server = NewStandaloneServerWithConfig(makePartitionTestConfig(dir))
+	defer server.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{t}x")), "$-1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{t}y")), "$1\r\nv\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", high)), "$-1\r\n")
+}
+
+func TestParseSlotRanges(t *testing.T) {
+	bad := [][]string{
+		{"0-100"},
+		{"0-8191", "8191-16383"},
+		{"0-16384"},
+		{"x-1"},
+		{},
+	}
+	for _, specs := range bad {
+		if _, err := aof.ParseSlotRanges(specs); err == nil {
+			t.Errorf("expected error for %v", specs)
+		}
+	}
+	ranges, err := aof.ParseSlotRanges([]string{"0-100", "101-16383"})
+	if err != nil || len(ranges) != 2 || ranges[1].Begin != 101 {
+		t.Errorf("unexpected ranges %v, %v", ranges, err)
+	}
+}
@@ -0,0 +1,106 @@
+//go:build linux
+
+package database
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+const aofFullEnv = "GO_REDIS_AOF_FULL"
+
+// runAofFullChild 用 RLIMIT_FSIZE 限制文件大小模拟磁盘写满(Go 程序忽略 SIGXFSZ，写入返回 EFBIG)
+func runAofFullChild(t *testing.T, mode string) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+	switch mode {
+	case "everysec":
+		cfg.AppendFsync = "everysec"
+	case "partitions":
+		cfg.AofSlotPartitions = []string{"0-8191", "8192-16383"}
+		cfg.ClusterEnable = true
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	full := limit
+	full.Cur = 64 << 10
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &full); err != nil {
+		t.Fatal(err)
+	}
+	conn := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(server.Exec(conn, utils.ToCmdLine(args...)).ToBytes())
+	}
+	value := strings.Repeat("x", 1000)
+	// everysec 时后台写入，写入失败之后的命令才会被拒绝
+	misconf := false
+	deadline := time.Now().Add(5 * time.Second)
+	for i := 0; !misconf && time.Now().Before(deadline); i++ {
+		misconf = strings.HasPrefix(exec("SET", "key:"+strconv.Itoa(i), value), "-MISCONF")
+		time.Sleep(time.Millisecond)
+	}
+	if !misconf {
+		t.Fatal("expected MISCONF after the aof is full")
+	}
+
+	// 写入失败之后写命令在执行之前被拒绝，不修改内存中的数据，读命令不受影响
+	if reply := exec("SET", "refused", "v"); !strings.HasPrefix(reply, "-MISCONF") {
+		t.Fatalf("expected write to be refused, got %q", reply)
+	}
+	if reply := exec("EXISTS", "refused"); reply != ":0\r\n" {
+		t.Fatalf("refused write changed the data, EXISTS returned %q", reply)
+	}
+	if reply := exec("STRLEN", "key:0"); reply != ":1000\r\n" {
+		t.Fatalf("unexpected read reply %q", reply)
+	}
+
+	// 空间恢复之后重试成功，重新接受写命令
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &limit); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if reply := exec("SET", "after", "v"); reply != "+OK\r\n" {
+		t.Fatalf("expected write to be accepted after recovery, got %q", reply)
+	}
+}
+
+// TestAofWriteError 在子进程中运行，文件大小的限制不影响其他测试
+func TestAofWriteError(t *testing.T) {
+	if mode := os.Getenv(aofFullEnv); mode != "" {
+		runAofFullChild(t, mode)
+		return
+	}
+	for _, mode := range []string{"always", "everysec", "partitions"} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestAofWriteError$")
+		cmd.Env = append(os.Environ(), aofFullEnv+"="+mode)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Errorf("%s: %v\n%s", mode, err, lastLines(string(out), 10))
+		}
+	}
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimSpace(s), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
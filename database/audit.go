@@ -0,0 +1,246 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 审计日志
+// 配置 audit-log-file 后，客户端执行的属于 audit-log-categories 中任一 ACL 分类的命令(默认 @write)逐条写入 JSON lines 文件，
+// 包括被拒绝的命令，error 字段是错误回复。事务中的命令在入队时记录，之后记录 EXEC。aof 加载的命令不来自客户端，不记录。
+// 文件超过 audit-log-max-size MB 时重命名为 <file>.1，已有的 <file>.N 依次后移，最多保留 audit-log-max-files 个
+
+const (
+	defaultAuditMaxSize  = 64 // MB
+	defaultAuditMaxFiles = 5
+	// 缓冲中的记录最多等待这么久写入文件
+	auditFlushInterval = time.Second
+)
+
+// 参数中包含密码的命令只记录命令名
+var auditRedactedCommands = map[string]bool{"auth": true, "hello": true, "acl": true, "config": true}
+
+type auditRecord struct {
+	Time  string   `json:"time"`
+	Addr  string   `json:"addr"`
+	User  string   `json:"user"`
+	DB    int      `json:"db"`
+	Cmd   string   `json:"cmd"`
+	Args  []string `json:"args,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+type auditLog struct {
+	categories aclCategory
+	path       string
+	maxSize    int64
+	maxFiles   int
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	size   int64
+	closed bool
+	stop   chan struct{}
+}
+
+// parseAuditCategories 解析空格或逗号分隔、可以带 @ 前缀的分类名，空字符串表示 @write
+func parseAuditCategories(s string) (aclCategory, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+	if len(fields) == 0 {
+		return aclWrite, nil
+	}
+	var categories aclCategory
+	for _, name := range fields {
+		category, ok := parseAclCategory(strings.TrimPrefix(name, "@"))
+		if !ok {
+			return 0, fmt.Errorf("unknown command category '%s'", name)
+		}
+		categories |= category
+	}
+	return categories, nil
+}
+
+// openAuditLog 没有配置 audit-log-file 时返回 nil
+func openAuditLog(cfg *config.ServerProperties) (*auditLog, error) {
+	path := cfg.AuditLogFilePath()
+	if path == "" {
+		return nil, nil
+	}
+	categories, err := parseAuditCategories(cfg.AuditLogCategories)
+	if err != nil {
+		return nil, fmt.Errorf("audit-log-categories: %w", err)
+	}
+	audit := &auditLog{
+		categories: categories,
+		path:       path,
+		maxSize:    int64(cfg.AuditLogMaxSize) << 20,
+		maxFiles:   cfg.AuditLogMaxFiles,
+		stop:       make(chan struct{}),
+	}
+	if audit.maxSize <= 0 {
+		audit.maxSize = defaultAuditMaxSize << 20
+	}
+	if audit.maxFiles <= 0 {
+		audit.maxFiles = defaultAuditMaxFiles
+	}
+	if err := audit.openFile(); err != nil {
+		return nil, err
+	}
+	go audit.flushLoop()
+	return audit, nil
+}
+
+func (audit *auditLog) openFile() error {
+	file, err := os.OpenFile(audit.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	audit.file = file
+	audit.writer = bufio.NewWriter(file)
+	audit.size = info.Size()
+	return nil
+}
+
+// rotate 关闭当前文件，<file>.N 后移一位，超出 maxFiles 的删除
+func (audit *auditLog) rotate() error {
+	if err := audit.writer.Flush(); err != nil {
+		return err
+	}
+	_ = audit.file.Close()
+	audit.file = nil
+	_ = os.Remove(fmt.Sprintf("%s.%d", audit.path, audit.maxFiles))
+	for i := audit.maxFiles - 1; i >= 1; i-- {
+		_ = os.Rename(fmt.Sprintf("%s.%d", audit.path, i), fmt.Sprintf("%s.%d", audit.path, i+1))
+	}
+	// 重命名失败时继续追加到原来的文件
+	renameErr := os.Rename(audit.path, audit.path+".1")
+	if err := audit.openFile(); err != nil {
+		return err
+	}
+	return renameErr
+}
+
+func (audit *auditLog) write(line []byte) {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if audit.closed {
+		return
+	}
+	if audit.file == nil {
+		// 上次轮转之后没能打开新文件
+		if err := audit.openFile(); err != nil {
+			slog.Error("open audit log failed", "path", audit.path, "error", err)
+			return
+		}
+	}
+	if audit.size > 0 && audit.size+int64(len(line)) > audit.maxSize {
+		if err := audit.rotate(); err != nil {
+			slog.Error("rotate audit log failed", "path", audit.path, "error", err)
+			if audit.file == nil {
+				return
+			}
+		}
+	}
+	n, err := audit.writer.Write(line)
+	audit.size += int64(n)
+	if err != nil {
+		slog.Error("write audit log failed", "path", audit.path, "error", err)
+	}
+}
+
+func (audit *auditLog) flushLoop() {
+	ticker := time.NewTicker(auditFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			audit.flush()
+		case <-audit.stop:
+			return
+		}
+	}
+}
+
+func (audit *auditLog) flush() {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if audit.closed || audit.file == nil {
+		return
+	}
+	if err := audit.writer.Flush(); err != nil {
+		slog.Error("flush audit log failed", "path", audit.path, "error", err)
+	}
+}
+
+// close 写入缓冲中的记录并关闭文件，之后的记录被丢弃
+func (audit *auditLog) close() {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if audit.closed {
+		return
+	}
+	audit.closed = true
+	close(audit.stop)
+	if audit.file == nil {
+		return
+	}
+	if err := audit.writer.Flush(); err != nil {
+		slog.Error("flush audit log failed", "path", audit.path, "error", err)
+	}
+	_ = audit.file.Sync()
+	_ = audit.file.Close()
+}
+
+// recordAudit 客户端执行的命令属于审计的分类时写入审计日志，dbIndex 是执行之前连接选择的库
+func (server *Server) recordAudit(c redis.Connection, dbIndex int, cmdLine [][]byte, result redis.Reply, start time.Time) {
+	if server.audit == nil || c == nil || c.Name() == "" || len(cmdLine) == 0 {
+		return
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := lookupCommand(cmdName)
+	if !ok || cmd.categories&server.audit.categories == 0 {
+		return
+	}
+	record := auditRecord{
+		Time: start.Format(time.RFC3339Nano),
+		Addr: c.Name(),
+		User: c.GetUser(),
+		DB:   dbIndex,
+		Cmd:  cmdName,
+	}
+	if record.User == "" {
+		record.User = defaultUser
+	}
+	if !auditRedactedCommands[cmdName] {
+		// 与慢日志相同截断过长的参数
+		for _, arg := range slowlogArgs(cmdLine)[1:] {
+			record.Args = append(record.Args, string(arg))
+		}
+	}
+	if errReply, ok := result.(protocol.ErrorReply); ok {
+		record.Error = errReply.Error()
+	}
+	line, err := json.Marshal(&record)
+	if err != nil {
+		return
+	}
+	server.audit.write(append(line, '\n'))
+}
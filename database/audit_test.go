@@ -0,0 +1,96 @@
+package database
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func readAuditRecords(t *testing.T, path string) []auditRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []auditRecord
+	for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		var record auditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("invalid line %q: %v", line, err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: dir, Databases: 16, AuditLogFile: "audit.log"})
+	client, peer := net.Pipe()
+	defer client.Close()
+	defer peer.Close()
+	// 审计日志只记录来自网络的连接
+	conn := connection.NewConn(client)
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	server.Exec(conn, utils.ToCmdLine("SELECT", "2"))
+	server.Exec(conn, utils.ToCmdLine("INCR", "a"))
+	server.Exec(conn, utils.ToCmdLine("LPUSH", "a", "x"))
+	server.Exec(connection.NewFakeConn(), utils.ToCmdLine("SET", "b", "1"))
+	server.Close()
+
+	records := readAuditRecords(t, filepath.Join(dir, "audit.log"))
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", records)
+	}
+	if r := records[0]; r.Cmd != "set" || strings.Join(r.Args, " ") != "a 1" || r.DB != 0 || r.User != "default" || r.Addr != "pipe" || r.Time == "" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r := records[1]; r.Cmd != "incr" || r.DB != 2 || r.Error != "" {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r := records[2]; r.Cmd != "lpush" || !strings.HasPrefix(r.Error, "WRONGTYPE") {
+		t.Errorf("expected the failed command to be recorded with its error, got %+v", r)
+	}
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	categories, err := parseAuditCategories("@write, admin")
+	if err != nil || categories != aclWrite|aclAdmin {
+		t.Fatalf("unexpected categories %v, %v", categories, err)
+	}
+	if _, err := parseAuditCategories("write nosuch"); err == nil {
+		t.Error("expected error for unknown category")
+	}
+
+	audit, err := openAuditLog(&config.ServerProperties{AuditLogFile: path, AuditLogMaxFiles: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit.maxSize = 10
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		audit.write([]byte(line))
+	}
+	audit.close()
+	for name, expected := range map[string]string{"audit.log": "fourth\n", "audit.log.1": "third\n", "audit.log.2": "second\n"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || string(data) != expected {
+			t.Errorf("%s: expected %q, got %q (%v)", name, expected, data, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("expected old files beyond audit-log-max-files to be removed")
+	}
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"errors"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/datastruct/dict"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// rdb 快照的自动保存
+// 每条写命令(记录 aof 的地方)累计一次修改，按照 save 规则 "<seconds> <changes> ..." 每秒检查一次，
+// 距离上次成功保存至少 seconds 秒并且至少有 changes 次修改时在后台保存。
+// 保存失败后至少等待 saveRetryDelay 再按规则重试，避免磁盘出错时不停地生成快照。
+// 同一时刻只有一个 SAVE/BGSAVE，直接遍历数据库，不依赖 aof:
+//   - SAVE 独占 writeGate，所有 key 来自同一时刻
+//   - BGSAVE 只在开始时记下每个编号上的 DB，之后逐个分片加锁编码，写命令只在所在分片正在编码时等待。
+//     每个 key 是完整的，但是保存期间修改的 key 可能是修改前或者修改后的状态，之后的 SWAPDB/FLUSHALL 不影响这次保存。
+//     rdb-deterministic 需要排序之后在锁外编码: SAVE 独占 writeGate 编码；BGSAVE 只在开始时独占 writeGate
+//     用各个类型的 Clone 复制所有的值，之后在后台编码复制出的数据，写命令只在复制期间暂停
+
+const (
+	saveCheckInterval = time.Second
+	saveRetryDelay    = 5 * time.Second
+)
+
+var errSaveInProgress = errors.New("ERR Background save already in progress")
+
+type rdbSaveState struct {
+	// 上次成功保存之后的修改次数
+	dirty atomic.Int64
+	// 正在执行 SAVE/BGSAVE
+	saving atomic.Bool
+	// 上次成功保存的时间(unix 秒)
+	lastSave atomic.Int64
+	// 上次尝试保存的时间(unix 秒)和结果
+	lastTry    atomic.Int64
+	lastFailed atomic.Bool
+	// 解析后的 save 规则 []config.SaveRule，可以用 CONFIG SET 修改
+	rules atomic.Value
+}
+
+// initAutoSave 启动时调用，加载数据产生的修改不计入 dirty
+func (server *Server) initAutoSave() {
+	rules, err := config.ParseSaveRules(server.cfg.Save)
+	if err != nil {
+		panic("invalid save: " + err.Error())
+	}
+	server.rdb.rules.Store(rules)
+	server.rdb.dirty.Store(0)
+	server.rdb.lastSave.Store(time.Now().Unix())
+	go server.runAutoSave(server.shutdown)
+}
+
+func (server *Server) runAutoSave(shutdown <-chan struct{}) {
+	ticker := time.NewTicker(saveCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case now := <-ticker.C:
+			if server.shouldAutoSave(now) {
+				_ = server.bgSave()
+			}
+		}
+	}
+}
+
+// shouldAutoSave 是否有规则满足条件
+func (server *Server) shouldAutoSave(now time.Time) bool {
+	if server.rdb.saving.Load() {
+		return false
+	}
+	if server.rdb.lastFailed.Load() && now.Unix()-server.rdb.lastTry.Load() < int64(saveRetryDelay/time.Second) {
+		return false
+	}
+	rules, _ := server.rdb.rules.Load().([]config.SaveRule)
+	dirty := server.rdb.dirty.Load()
+	elapsed := now.Unix() - server.rdb.lastSave.Load()
+	for _, rule := range rules {
+		if dirty >= int64(rule.Changes) && elapsed >= int64(rule.Seconds) {
+			slog.Info("starting background save", "changes", dirty, "seconds", elapsed)
+			return true
+		}
+	}
+	return false
+}
+
+// dbSnapshot 保存开始时每个编号上的 DB
+type dbSnapshot []*DB
+
+func (s dbSnapshot) GetDBSize(dbIndex int) (int, int) {
+	return s[dbIndex].data.Len(), s[dbIndex].ttlMap.Len()
+}
+
+func (s dbSnapshot) ForEach(dbIndex int, cb func(key string, data *database.DataEntity, expiration *time.Time) bool) {
+	s[dbIndex].ForEach(cb)
+}
+
+// saveRDB 暂停写命令生成 rdb 文件，调用方已经把 saving 设为 true
+func (server *Server) saveRDB() error {
+	// 没有写命令在执行，遍历得到的是同一时刻的数据
+	unlock := server.lockWriteGate(true)
+	defer unlock()
+	return server.writeRDBFile(server, server.rdb.dirty.Load())
+}
+
+// snapshotDBs 记下每个编号上当前的 DB 和修改次数
+func (server *Server) snapshotDBs() (dbSnapshot, int64) {
+	// SWAPDB 独占 writeGate，共享时记下的 DB 和编号是对应的
+	unlock := server.lockWriteGate(false)
+	defer unlock()
+	snapshot := make(dbSnapshot, len(server.dbSet))
+	for i := range server.dbSet {
+		snapshot[i] = server.mustSelectDB(i)
+	}
+	return snapshot, server.rdb.dirty.Load()
+}
+
+// cloneDBs 独占 writeGate 复制每个 DB 的数据，之后的写命令不影响复制出的数据
+func (server *Server) cloneDBs() (dbSnapshot, int64) {
+	unlock := server.lockWriteGate(true)
+	defer unlock()
+	snapshot := make(dbSnapshot, len(server.dbSet))
+	for i := range server.dbSet {
+		snapshot[i] = server.mustSelectDB(i).cloneForSave()
+	}
+	return snapshot, server.rdb.dirty.Load()
+}
+
+// cloneForSave 返回只包含数据和过期时间的 DB，值用 cloneData 深拷贝，只用于编码 rdb
+func (db *DB) cloneForSave() *DB {
+	data := dict.MakeConcurrent(db.data.ShardCount())
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		data.Put(key, &database.DataEntity{Data: cloneData(raw.(*database.DataEntity).Data)})
+		return true
+	})
+	return &DB{dbShared: &dbShared{
+		index:  int32(db.getIndex()),
+		data:   data,
+		ttlMap: db.ttlMap.Clone().(*dict.ConcurrentDict),
+	}}
+}
+
+// writeRDBFile 把 source 写入 rdb 文件，dirty 是开始保存时的修改次数
+func (server *Server) writeRDBFile(source aof.RDBSource, dirty int64) error {
+	server.rdb.lastTry.Store(time.Now().Unix())
+	err := aof.SaveRDBFile(server.cfg, source, server.cfg.RDBFilePath())
+	server.rdb.lastFailed.Store(err != nil)
+	if err != nil {
+		return err
+	}
+	// 保存期间的修改留到下一次
+	server.rdb.dirty.Add(-dirty)
+	server.rdb.lastSave.Store(time.Now().Unix())
+	return nil
+}
+
+// bgSave 在后台保存，已经有保存在进行时返回 errSaveInProgress
+func (server *Server) bgSave() error {
+	if !server.rdb.saving.CompareAndSwap(false, true) {
+		return errSaveInProgress
+	}
+	// 在返回之前记下 DB，之后的 FLUSHALL/SWAPDB 不影响这次保存
+	var snapshot dbSnapshot
+	var dirty int64
+	if server.cfg.RdbDeterministic {
+		snapshot, dirty = server.cloneDBs()
+	} else {
+		snapshot, dirty = server.snapshotDBs()
+	}
+	save := func() error {
+		return server.writeRDBFile(snapshot, dirty)
+	}
+	go func() {
+		defer server.rdb.saving.Store(false)
+		defer func() {
+			if err := recover(); err != nil {
+				slog.Error("background save panic", "error", err)
+				server.rdb.lastFailed.Store(true)
+			}
+		}()
+		if err := save(); err != nil {
+			slog.Error("background save error", "error", err)
+		}
+	}()
+	return nil
+}
+
+// execLastSave LASTSAVE 返回上次成功保存的 unix 时间
+func (server *Server) execLastSave() redis.Reply {
+	return protocol.MakeIntReply(server.rdb.lastSave.Load())
+}
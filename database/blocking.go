@@ -0,0 +1,486 @@
+package database
+
+import (
+	"container/list"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	List "github.com/zhangming/go-redis/datastruct/list"
+	SortedSet "github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 阻塞命令 BLPOP/BRPOP/BLMOVE/BRPOPLPUSH/BZPOPMIN/BZPOPMAX/XREAD BLOCK
+// 命令表中的执行函数只尝试一次，没有数据时返回 nil。服务器在 execBlocking 中循环：
+// 先在 key 上登记等待，再尝试执行，仍然没有数据时挂起连接直到被唤醒、超时或者连接断开。
+// 新的列表或有序集合写入(PutEntity)时唤醒这个 key 上最早登记的连接，它取走数据后如果还有剩余，再唤醒下一个。
+// XREAD 不会取走消息，所以 XADD 唤醒 key 上所有的连接
+
+// blockingKeys 记录一个数据库中阻塞在各个 key 上的连接，FLUSHDB 替换 DB 时保留
+type blockingKeys struct {
+	mu      sync.Mutex
+	waiters map[string]*list.List // key -> *blockedClient，先登记的先唤醒
+	count   atomic.Int32          // 登记中的连接数，为 0 时 signal 直接返回
+}
+
+// blockedClient 一次等待，可以同时等待多个 key
+type blockedClient struct {
+	keys     []string
+	elements []*list.Element
+	// 被唤醒时写入，容量为 1
+	wake chan struct{}
+}
+
+var blockingLockClass = lockorder.NewClass("database.blocking")
+
+func (b *blockingKeys) lock() {
+	blockingLockClass.Acquire(0)
+	b.mu.Lock()
+}
+
+func (b *blockingKeys) unlock() {
+	b.mu.Unlock()
+	blockingLockClass.Release(0)
+}
+
+func makeBlockingKeys() *blockingKeys {
+	return &blockingKeys{
+		waiters: make(map[string]*list.List),
+	}
+}
+
+// wait 在 keys 上登记等待
+func (b *blockingKeys) wait(keys []string) *blockedClient {
+	client := &blockedClient{
+		keys: keys,
+		wake: make(chan struct{}, 1),
+	}
+	b.lock()
+	defer b.unlock()
+	for _, key := range keys {
+		queue, ok := b.waiters[key]
+		if !ok {
+			queue = list.New()
+			b.waiters[key] = queue
+		}
+		client.elements = append(client.elements, queue.PushBack(client))
+	}
+	b.count.Add(1)
+	return client
+}
+
+// removeLocked 从所有 key 的等待队列中移除 client，返回 client 是否还在队列中
+func (b *blockingKeys) removeLocked(client *blockedClient) bool {
+	if client.elements == nil {
+		return false
+	}
+	for i, key := range client.keys {
+		queue := b.waiters[key]
+		queue.Remove(client.elements[i])
+		if queue.Len() == 0 {
+			delete(b.waiters, key)
+		}
+	}
+	client.elements = nil
+	b.count.Add(-1)
+	return true
+}
+
+// cancel 结束等待。已经被唤醒但是没有取走数据的连接把唤醒转交给下一个等待者
+func (b *blockingKeys) cancel(client *blockedClient) {
+	b.lock()
+	removed := b.removeLocked(client)
+	b.unlock()
+	if removed {
+		return
+	}
+	select {
+	case <-client.wake:
+		for _, key := range client.keys {
+			b.signal(key)
+		}
+	default:
+	}
+}
+
+// signal 唤醒 key 上最早登记的连接
+func (b *blockingKeys) signal(key string) {
+	if b == nil || b.count.Load() == 0 {
+		return
+	}
+	b.lock()
+	defer b.unlock()
+	queue, ok := b.waiters[key]
+	if !ok {
+		return
+	}
+	client := queue.Front().Value.(*blockedClient)
+	b.removeLocked(client)
+	client.wake <- struct{}{}
+}
+
+// wakeAll 唤醒所有登记的连接，SWAPDB 之后它们需要到新的 DB 上重新等待。
+// 重写 aof 时在临时数据库中重放 SWAPDB，b 为 nil
+func (b *blockingKeys) wakeAll() {
+	if b == nil {
+		return
+	}
+	b.lock()
+	defer b.unlock()
+	for _, queue := range b.waiters {
+		for queue.Len() > 0 {
+			client := queue.Front().Value.(*blockedClient)
+			b.removeLocked(client)
+			client.wake <- struct{}{}
+		}
+	}
+}
+
+// broadcast 唤醒 key 上所有登记的连接
+func (b *blockingKeys) broadcast(key string) {
+	if b == nil || b.count.Load() == 0 {
+		return
+	}
+	b.lock()
+	defer b.unlock()
+	queue, ok := b.waiters[key]
+	if !ok {
+		return
+	}
+	for queue.Len() > 0 {
+		client := queue.Front().Value.(*blockedClient)
+		b.removeLocked(client)
+		client.wake <- struct{}{}
+	}
+}
+
+func isBlockingCommand(cmdName string) bool {
+	switch cmdName {
+	case "blpop", "brpop", "blmove", "brpoplpush", "bzpopmin", "bzpopmax", "xread":
+		return true
+	}
+	return false
+}
+
+// isBlockingPopTarget 判断写入的数据是否可能被阻塞的弹出命令取走
+func isBlockingPopTarget(entity *database.DataEntity) bool {
+	switch entity.Data.(type) {
+	case List.List, *SortedSet.SortedSet:
+		return true
+	}
+	return false
+}
+
+// parseBlockingTimeout 解析秒为单位的超时时间，可以是小数，0 表示一直等待
+func parseBlockingTimeout(raw []byte) (time.Duration, protocol.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(raw), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, protocol.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, protocol.MakeErrReply("ERR timeout is negative")
+	}
+	if seconds > float64(math.MaxInt64/int64(time.Second)) {
+		return 0, protocol.MakeErrReply("ERR timeout is out of range")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// blockingKeysOf 返回阻塞命令等待的 key
+func blockingKeysOf(cmdName string, args [][]byte) []string {
+	switch cmdName {
+	case "blmove", "brpoplpush":
+		return []string{string(args[0])}
+	}
+	keys := make([]string, 0, len(args)-1)
+	for _, arg := range args[:len(args)-1] {
+		keys = append(keys, string(arg))
+	}
+	return keys
+}
+
+// parseBlockingArgs 返回超时时间和等待的 key。没有 BLOCK 参数的 XREAD 和参数错误的 XREAD 不阻塞，由 execOnce 执行
+func parseBlockingArgs(cmdName string, args [][]byte) (timeout time.Duration, keys []string, xread *xreadArgs,
+	errReply protocol.ErrorReply) {
+	if cmdName == "xread" {
+		xread, errReply = parseXRead(args)
+		if errReply != nil || !xread.block {
+			return 0, nil, nil, nil
+		}
+		return xread.timeout, xread.keys, xread, nil
+	}
+	timeout, errReply = parseBlockingTimeout(args[len(args)-1])
+	if errReply != nil {
+		return 0, nil, nil, errReply
+	}
+	return timeout, blockingKeysOf(cmdName, args), nil, nil
+}
+
+// execBlocking 执行阻塞命令，等待期间不持有任何锁
+func (server *Server) execBlocking(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return server.execOnce(c, cmdLine)
+	}
+	timeout, keys, xread, errReply := parseBlockingArgs(cmdName, cmdLine[1:])
+	if errReply != nil {
+		return errReply
+	}
+	if len(keys) == 0 {
+		return server.execOnce(c, cmdLine)
+	}
+	dbIndex := c.GetDBIndex()
+	db, selectErr := server.selectDB(dbIndex)
+	if selectErr != nil {
+		return selectErr
+	}
+	if xread != nil {
+		cmdLine = db.resolveLastIDs(cmdLine, xread)
+	}
+
+	cancel := make(chan struct{})
+	server.blockedConns.Store(c, cancel)
+	defer server.blockedConns.Delete(c)
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		// SWAPDB 之后序号对应的 DB 会变化，每次都登记到当前的 DB
+		registry := server.mustSelectDB(dbIndex).blocking
+		// 先登记再尝试，尝试之后写入的数据一定会唤醒这次等待
+		waiter := registry.wait(keys)
+		reply := server.execOnce(c, cmdLine)
+		if !isNullReply(reply) {
+			registry.cancel(waiter)
+			return reply
+		}
+		// 流水线中前面命令的回复可能还在输出缓冲中，等待之前先发送
+		_ = c.Flush()
+		select {
+		case <-waiter.wake:
+			continue
+		case <-deadline:
+		case <-cancel:
+		case <-server.shutdown:
+		}
+		registry.cancel(waiter)
+		return protocol.MakeNullMultiBulkReply()
+	}
+}
+
+func isNullReply(reply redis.Reply) bool {
+	switch reply.(type) {
+	case *protocol.NullBulkReply, *protocol.NullMultiBulkReply:
+		return true
+	}
+	return false
+}
+
+// CancelBlocking 连接断开时结束它的阻塞命令，避免唤醒后取走的数据发给已经断开的连接
+func (server *Server) CancelBlocking(c redis.Connection) {
+	if raw, ok := server.blockedConns.LoadAndDelete(c); ok {
+		close(raw.(chan struct{}))
+	}
+}
+
+// execBLPop BLPOP key [key ...] timeout，在命令表中只尝试一次
+func execBLPop(db *DB, args [][]byte) redis.Reply {
+	return execBlockingPop(db, args, true)
+}
+
+// execBRPop BRPOP key [key ...] timeout
+func execBRPop(db *DB, args [][]byte) redis.Reply {
+	return execBlockingPop(db, args, false)
+}
+
+func execBlockingPop(db *DB, args [][]byte, left bool) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+		var val []byte
+		if left {
+			val, _ = list.Remove(0).([]byte)
+			db.addAof(CmdLine{[]byte("LPOP"), arg})
+		} else {
+			val, _ = list.RemoveLast().([]byte)
+			db.addAof(CmdLine{[]byte("RPOP"), arg})
+		}
+		db.deleteIfEmpty(key, list)
+		if list.Len() > 0 {
+			db.signalBlocked(key)
+		}
+		return protocol.MakeMultiBulkReply([][]byte{arg, val})
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
+// execBZPopMin BZPOPMIN key [key ...] timeout，返回 key、成员和分数
+func execBZPopMin(db *DB, args [][]byte) redis.Reply {
+	return execBlockingZPop(db, args, false)
+}
+
+// execBZPopMax BZPOPMAX key [key ...] timeout
+func execBZPopMax(db *DB, args [][]byte) redis.Reply {
+	return execBlockingZPop(db, args, true)
+}
+
+func execBlockingZPop(db *DB, args [][]byte, desc bool) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[len(args)-1]); errReply != nil {
+		return errReply
+	}
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		sortedSet, errReply := db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if sortedSet == nil {
+			continue
+		}
+		element := popSortedSet(sortedSet, 1, desc)[0]
+		if desc {
+			db.addAof(CmdLine{[]byte("ZPOPMAX"), arg})
+		} else {
+			db.addAof(CmdLine{[]byte("ZPOPMIN"), arg})
+		}
+		db.deleteIfEmpty(key, sortedSet)
+		if sortedSet.Len() > 0 {
+			db.signalBlocked(key)
+		}
+		return protocol.MakeMultiBulkReply([][]byte{arg, []byte(element.Member), []byte(protocol.FormatDouble(element.Score))})
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
+func prepareBlockingPop(args [][]byte) ([]string, []string) {
+	return writeAllKeys(args[:len(args)-1])
+}
+
+func undoBlockingPop(db *DB, args [][]byte) []CmdLine {
+	keys, _ := prepareBlockingPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+func prepareMove(args [][]byte) ([]string, []string) {
+	return []string{string(args[0]), string(args[1])}, nil
+}
+
+func undoMove(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
+
+// parseListSide 解析 LEFT/RIGHT
+func parseListSide(raw []byte) (left bool, ok bool) {
+	switch strings.ToUpper(string(raw)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+// execLMove LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db *DB, args [][]byte) redis.Reply {
+	from, ok1 := parseListSide(args[2])
+	to, ok2 := parseListSide(args[3])
+	if !ok1 || !ok2 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	return listMove(db, args[0], args[1], from, to)
+}
+
+// execBLMove BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func execBLMove(db *DB, args [][]byte) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[4]); errReply != nil {
+		return errReply
+	}
+	return execLMove(db, args[:4])
+}
+
+// execBRPopLPush BRPOPLPUSH source destination timeout
+func execBRPopLPush(db *DB, args [][]byte) redis.Reply {
+	if _, errReply := parseBlockingTimeout(args[2]); errReply != nil {
+		return errReply
+	}
+	return listMove(db, args[0], args[1], false, true)
+}
+
+// listMove 从 source 的一端取出元素放到 destination 的一端，source 不存在时返回 nil
+func listMove(db *DB, source, destination []byte, fromLeft, toLeft bool) redis.Reply {
+	sourceKey := string(source)
+	destKey := string(destination)
+	sourceList, errReply := db.getAsList(sourceKey)
+	if errReply != nil {
+		return errReply
+	}
+	if sourceList == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	// 目标存在但不是列表时不能取出元素
+	if _, errReply = db.getAsList(destKey); errReply != nil {
+		return errReply
+	}
+	var val []byte
+	if fromLeft {
+		val, _ = sourceList.Remove(0).([]byte)
+	} else {
+		val, _ = sourceList.RemoveLast().([]byte)
+	}
+	db.deleteIfEmpty(sourceKey, sourceList)
+	destList, _, _ := db.getOrInitList(destKey)
+	if toLeft {
+		destList.Insert(0, val)
+	} else {
+		destList.Add(val)
+	}
+	fromSide, toSide := "RIGHT", "RIGHT"
+	if fromLeft {
+		fromSide = "LEFT"
+	}
+	if toLeft {
+		toSide = "LEFT"
+	}
+	db.addAof(CmdLine{[]byte("LMOVE"), source, destination, []byte(fromSide), []byte(toSide)})
+	if sourceKey != destKey && sourceList.Len() > 0 {
+		db.signalBlocked(sourceKey)
+	}
+	return protocol.MakeBulkReply(val)
+}
+
+func init() {
+	registerCommand("BLPop", execBLPop, prepareBlockingPop, undoBlockingPop, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagNoScript}, 1, -2, 1)
+	registerCommand("BRPop", execBRPop, prepareBlockingPop, undoBlockingPop, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagNoScript}, 1, -2, 1)
+	registerCommand("BZPopMin", execBZPopMin, prepareBlockingPop, undoBlockingPop, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagNoScript, redisFlagFast}, 1, -2, 1)
+	registerCommand("BZPopMax", execBZPopMax, prepareBlockingPop, undoBlockingPop, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagNoScript, redisFlagFast}, 1, -2, 1)
+	registerCommand("LMove", execLMove, prepareMove, undoMove, 5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+	registerCommand("BLMove", execBLMove, prepareMove, undoMove, 6, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagNoScript}, 1, 2, 1)
+	registerCommand("BRPopLPush", execBRPopLPush, prepareMove, undoMove, 4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagNoScript}, 1, 2, 1)
+}
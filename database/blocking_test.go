@@ -0,0 +1,137 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+// execAsync 在后台执行命令，返回接收回复的 channel
+func execAsync(server *Server, conn redis.Connection, args ...string) chan redis.Reply {
+	result := make(chan redis.Reply, 1)
+	go func() {
+		result <- server.Exec(conn, utils.ToCmdLine(args...))
+	}()
+	return result
+}
+
+func waitBlocked(t *testing.T, server *Server, count int) {
+	waitFor(t, "client was not blocked", func() bool {
+		n := 0
+		server.blockedConns.Range(func(key, value any) bool {
+			n++
+			return true
+		})
+		return n == count
+	})
+}
+
+func TestBlockingPop(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "b", "1", "2"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BLPOP", "a", "b", "0")), "*2\r\n$1\r\nb\r\n$1\r\n1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BRPOP", "a", "b", "0")), "*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	begin := time.Now()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BLPOP", "a", "b", "0.1")), "*-1\r\n")
+	if elapsed := time.Since(begin); elapsed < 100*time.Millisecond {
+		t.Fatalf("BLPOP returned after %v, expected to wait for timeout", elapsed)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BLPOP", "a", "-1")), "-ERR timeout is negative\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BLPOP", "a", "abc")),
+		"-ERR timeout is not a float or out of range\r\n")
+	server.Exec(conn, utils.ToCmdLine("SET", "str", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BLPOP", "str", "0")),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// 先阻塞的连接先得到数据，一次写入多个元素时依次唤醒
+	c1, c2 := connection.NewFakeConn(), connection.NewFakeConn()
+	r1 := execAsync(server, c1, "BLPOP", "queue", "0")
+	waitBlocked(t, server, 1)
+	r2 := execAsync(server, c2, "BRPOP", "other", "queue", "0")
+	waitBlocked(t, server, 2)
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "queue", "x", "y"))
+	assertReply(t, <-r1, "*2\r\n$5\r\nqueue\r\n$1\r\nx\r\n")
+	assertReply(t, <-r2, "*2\r\n$5\r\nqueue\r\n$1\r\ny\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "queue")), ":0\r\n")
+
+	// 事务中的阻塞命令不等待
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	server.Exec(conn, utils.ToCmdLine("BLPOP", "queue", "0"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*1\r\n*-1\r\n")
+}
+
+func TestBlockingZPop(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+
+	server.Exec(conn, utils.ToCmdLine("ZADD", "z", "1", "a", "2", "b", "3", "c"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BZPOPMIN", "missing", "z", "0")), "*3\r\n$1\r\nz\r\n$1\r\na\r\n$1\r\n1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BZPOPMAX", "z", "0")), "*3\r\n$1\r\nz\r\n$1\r\nc\r\n$1\r\n3\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BZPOPMAX", "missing", "0.05")), "*-1\r\n")
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "list", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BZPOPMIN", "list", "0")),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	// ZADD 创建有序集合时依次唤醒等待的连接
+	c1, c2 := connection.NewFakeConn(), connection.NewFakeConn()
+	r1 := execAsync(server, c1, "BZPOPMIN", "scores", "0")
+	waitBlocked(t, server, 1)
+	r2 := execAsync(server, c2, "BZPOPMAX", "other", "scores", "0")
+	waitBlocked(t, server, 2)
+	server.Exec(conn, utils.ToCmdLine("ZADD", "scores", "10", "x", "20", "y", "30", "z"))
+	assertReply(t, <-r1, "*3\r\n$6\r\nscores\r\n$1\r\nx\r\n$2\r\n10\r\n")
+	assertReply(t, <-r2, "*3\r\n$6\r\nscores\r\n$1\r\nz\r\n$2\r\n30\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("ZCARD", "scores")), ":1\r\n")
+}
+
+func TestBlockingCancel(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	blocked := connection.NewFakeConn()
+	reply := execAsync(server, blocked, "BLPOP", "queue", "0")
+	waitBlocked(t, server, 1)
+	server.AfterClientClose(blocked)
+	assertReply(t, <-reply, "*-1\r\n")
+	// 断开的连接不会再取走数据
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "queue", "x"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LLEN", "queue")), ":1\r\n")
+
+	reply = execAsync(server, blocked, "BLPOP", "missing", "0")
+	waitBlocked(t, server, 1)
+	server.Close()
+	assertReply(t, <-reply, "*-1\r\n")
+}
+
+func TestBlockingMove(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "src", "1", "2", "3"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LMOVE", "src", "dst", "LEFT", "RIGHT")), "$1\r\n1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LMOVE", "src", "dst", "RIGHT", "LEFT")), "$1\r\n3\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LRANGE", "dst", "0", "-1")), "*2\r\n$1\r\n3\r\n$1\r\n1\r\n")
+	// 同一个列表时轮转
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LMOVE", "dst", "dst", "LEFT", "RIGHT")), "$1\r\n3\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LRANGE", "dst", "0", "-1")), "*2\r\n$1\r\n1\r\n$1\r\n3\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LMOVE", "src", "dst", "UP", "LEFT")), "-ERR syntax error\r\n")
+	server.Exec(conn, utils.ToCmdLine("SET", "str", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LMOVE", "src", "str", "LEFT", "LEFT")),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LLEN", "src")), ":1\r\n")
+
+	reply := execAsync(server, connection.NewFakeConn(), "BLMOVE", "jobs", "processing", "RIGHT", "LEFT", "0")
+	waitBlocked(t, server, 1)
+	server.Exec(conn, utils.ToCmdLine("LPUSH", "jobs", "job1"))
+	assertReply(t, <-reply, "$4\r\njob1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LRANGE", "processing", "0", "-1")), "*1\r\n$4\r\njob1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BRPOPLPUSH", "jobs", "processing", "0.05")), "*-1\r\n")
+}
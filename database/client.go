@@ -0,0 +1,264 @@
+package database
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// CLIENT ID | GETNAME | SETNAME name | LIST [TYPE type] [ID id ...] | KILL addr | KILL [ID id] [ADDR addr] [TYPE type] [SKIPME yes|no]
+// handler 接受连接时调用 AfterClientConnect 登记，AfterClientClose 时删除
+
+// AfterClientConnect registers an accepted connection
+func (server *Server) AfterClientConnect(c redis.Connection) {
+	server.clients.Store(c.GetID(), c)
+	server.initClientAuth(c)
+}
+
+// listClients 返回按编号排序的连接
+func (server *Server) listClients() []redis.Connection {
+	var clients []redis.Connection
+	server.clients.Range(func(key, value any) bool {
+		clients = append(clients, value.(redis.Connection))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].GetID() < clients[j].GetID()
+	})
+	return clients
+}
+
+// clientType 与 redis 相同，订阅了频道的连接为 pubsub
+func clientType(c redis.Connection) string {
+	if c.IsSlave() {
+		return "replica"
+	} else if c.IsMaster() {
+		return "master"
+	} else if c.SubsCount() > 0 {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+func parseClientType(name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "normal", "master", "pubsub":
+		return strings.ToLower(name), true
+	case "replica", "slave":
+		return "replica", true
+	}
+	return "", false
+}
+
+// clientUser 没有登录为命名用户的连接属于 default 用户
+func clientUser(c redis.Connection) string {
+	if user := c.GetUser(); user != "" {
+		return user
+	}
+	return defaultUser
+}
+
+func clientFlags(c redis.Connection) string {
+	var flags []byte
+	if c.IsSlave() {
+		flags = append(flags, 'S')
+	}
+	if c.IsMaster() {
+		flags = append(flags, 'M')
+	}
+	if c.SubsCount() > 0 {
+		flags = append(flags, 'P')
+	}
+	if c.InMultiState() {
+		flags = append(flags, 'x')
+	}
+	if len(flags) == 0 {
+		return "N"
+	}
+	return string(flags)
+}
+
+// clientInfo 一个连接在 CLIENT LIST 中的一行
+func clientInfo(c redis.Connection, now time.Time) string {
+	cmd, lastActive := c.GetLastCmd()
+	if cmd == "" {
+		cmd = "NULL"
+	}
+	multi := -1
+	if c.InMultiState() {
+		multi = len(c.GetQueuedCmdLine())
+	}
+	var sb strings.Builder
+	sb.WriteString("id=" + strconv.FormatUint(c.GetID(), 10))
+	sb.WriteString(" addr=" + c.RemoteAddr())
+	sb.WriteString(" name=" + c.GetClientName())
+	sb.WriteString(" age=" + strconv.FormatInt(int64(now.Sub(c.GetCreateTime())/time.Second), 10))
+	sb.WriteString(" idle=" + strconv.FormatInt(int64(now.Sub(lastActive)/time.Second), 10))
+	sb.WriteString(" flags=" + clientFlags(c))
+	sb.WriteString(" db=" + strconv.Itoa(c.GetDBIndex()))
+	sb.WriteString(" sub=" + strconv.Itoa(len(c.GetChannels())))
+	sb.WriteString(" psub=" + strconv.Itoa(len(c.GetPatterns())))
+	sb.WriteString(" multi=" + strconv.Itoa(multi))
+	sb.WriteString(" omem=" + strconv.Itoa(c.GetOutputBufferSize()))
+	sb.WriteString(" cmd=" + cmd)
+	sb.WriteString(" user=" + clientUser(c))
+	sb.WriteString(" resp=" + strconv.Itoa(c.GetProtocol()))
+	sb.WriteString("\n")
+	return sb.String()
+}
+
+func parseClientID(arg []byte) (uint64, bool) {
+	id, err := strconv.ParseUint(string(arg), 10, 64)
+	return id, err == nil && id > 0
+}
+
+func (server *Server) execClient(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("client")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "id":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("client|id")
+		}
+		return protocol.MakeIntReply(int64(c.GetID()))
+	case "getname":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("client|getname")
+		}
+		name := c.GetClientName()
+		if name == "" {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(name))
+	case "setname":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("client|setname")
+		}
+		name := string(args[1])
+		if !validClientName(name) {
+			return protocol.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		// 空字符串清除名字
+		c.SetClientName(name)
+		return protocol.MakeOkReply()
+	case "list":
+		return server.execClientList(args[1:])
+	case "kill":
+		return server.execClientKill(c, args[1:])
+	case "durability":
+		return server.execClientDurability(c, args[1:])
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CLIENT HELP.")
+}
+
+// execClientList CLIENT LIST [TYPE type] [ID id ...]
+func (server *Server) execClientList(args [][]byte) redis.Reply {
+	var typ string
+	var ids map[uint64]struct{}
+	if len(args) >= 2 && strings.EqualFold(string(args[0]), "type") {
+		if len(args) != 2 {
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+		var ok bool
+		if typ, ok = parseClientType(string(args[1])); !ok {
+			return protocol.MakeErrReply("ERR Unknown client type '" + string(args[1]) + "'")
+		}
+	} else if len(args) >= 2 && strings.EqualFold(string(args[0]), "id") {
+		ids = make(map[uint64]struct{}, len(args)-1)
+		for _, arg := range args[1:] {
+			id, ok := parseClientID(arg)
+			if !ok {
+				return protocol.MakeErrReply("ERR Invalid client ID")
+			}
+			ids[id] = struct{}{}
+		}
+	} else if len(args) != 0 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	now := time.Now()
+	var sb strings.Builder
+	for _, client := range server.listClients() {
+		if typ != "" && clientType(client) != typ {
+			continue
+		}
+		if ids != nil {
+			if _, ok := ids[client.GetID()]; !ok {
+				continue
+			}
+		}
+		sb.WriteString(clientInfo(client, now))
+	}
+	return protocol.MakeBulkReply([]byte(sb.String()))
+}
+
+// execClientKill 旧格式 CLIENT KILL addr 回复 OK，新格式回复关闭的连接数，默认不关闭自己
+func (server *Server) execClientKill(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 1 {
+		addr := string(args[0])
+		for _, client := range server.listClients() {
+			if client.RemoteAddr() == addr {
+				client.Kill()
+				return protocol.MakeOkReply()
+			}
+		}
+		return protocol.MakeErrReply("ERR No such client")
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	var id uint64
+	var addr, typ string
+	skipMe := true
+	for i := 0; i < len(args); i += 2 {
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "id":
+			var ok bool
+			if id, ok = parseClientID(args[i+1]); !ok {
+				return protocol.MakeErrReply("ERR client-id should be greater than 0")
+			}
+		case "addr":
+			addr = value
+		case "type":
+			var ok bool
+			if typ, ok = parseClientType(value); !ok {
+				return protocol.MakeErrReply("ERR Unknown client type '" + value + "'")
+			}
+		case "skipme":
+			switch strings.ToLower(value) {
+			case "yes":
+				skipMe = true
+			case "no":
+				skipMe = false
+			default:
+				return protocol.MakeErrReply("ERR syntax error")
+			}
+		default:
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+	killed := 0
+	for _, client := range server.listClients() {
+		if id != 0 && client.GetID() != id {
+			continue
+		}
+		if addr != "" && client.RemoteAddr() != addr {
+			continue
+		}
+		if typ != "" && clientType(client) != typ {
+			continue
+		}
+		if skipMe && client.GetID() == c.GetID() {
+			continue
+		}
+		client.Kill()
+		killed++
+	}
+	return protocol.MakeIntReply(int64(killed))
+}
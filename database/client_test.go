@@ -0,0 +1,83 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestClientCommands(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir()})
+	defer server.Close()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	server.AfterClientConnect(c1)
+	server.AfterClientConnect(c2)
+	id1 := strconv.FormatUint(c1.GetID(), 10)
+	id2 := strconv.FormatUint(c2.GetID(), 10)
+	if c2.GetID() <= c1.GetID() {
+		t.Fatalf("connection ids should increase, got %s and %s", id1, id2)
+	}
+
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "ID")), ":"+id1+"\r\n")
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "GETNAME")), "$-1\r\n")
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "SETNAME", "bad name")),
+		"-ERR Client names cannot contain spaces, newlines or special characters.\r\n")
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "SETNAME", "worker")), "+OK\r\n")
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "GETNAME")), "$6\r\nworker\r\n")
+
+	server.Exec(c2, utils.ToCmdLine("SELECT", "2"))
+	server.Exec(c2, utils.ToCmdLine("SUBSCRIBE", "news"))
+	reply, ok := server.Exec(c1, utils.ToCmdLine("CLIENT", "LIST")).(*protocol.BulkReply)
+	if !ok {
+		t.Fatal("CLIENT LIST should return a bulk string")
+	}
+	lines := strings.Split(strings.TrimSuffix(string(reply.Arg), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 clients, got %q", reply.Arg)
+	}
+	for _, field := range []string{"id=" + id1 + " ", " name=worker ", " flags=N ", " db=0 ", " cmd=client ", " resp=2"} {
+		if !strings.Contains(lines[0], field) {
+			t.Errorf("expected %q in %q", field, lines[0])
+		}
+	}
+	for _, field := range []string{"id=" + id2 + " ", " flags=P ", " db=2 ", " sub=1 ", " psub=0 ", " cmd=subscribe "} {
+		if !strings.Contains(lines[1], field) {
+			t.Errorf("expected %q in %q", field, lines[1])
+		}
+	}
+	reply = server.Exec(c1, utils.ToCmdLine("CLIENT", "LIST", "TYPE", "pubsub")).(*protocol.BulkReply)
+	if !strings.HasPrefix(string(reply.Arg), "id="+id2+" ") || strings.Count(string(reply.Arg), "\n") != 1 {
+		t.Errorf("unexpected pubsub clients %q", reply.Arg)
+	}
+	reply = server.Exec(c1, utils.ToCmdLine("CLIENT", "LIST", "ID", id1)).(*protocol.BulkReply)
+	if !strings.HasPrefix(string(reply.Arg), "id="+id1+" ") || strings.Count(string(reply.Arg), "\n") != 1 {
+		t.Errorf("unexpected clients %q", reply.Arg)
+	}
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "LIST", "TYPE", "foo")), "-ERR Unknown client type 'foo'\r\n")
+
+	// 新格式默认跳过自己
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "KILL", "ID", id1)), ":0\r\n")
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "KILL", "ID", "0")), "-ERR client-id should be greater than 0\r\n")
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "KILL", "1.2.3.4:5")), "-ERR No such client\r\n")
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "KILL", "ID", id2)), ":1\r\n")
+	if !c2.IsKilled() || c1.IsKilled() {
+		t.Fatal("only the second client should be killed")
+	}
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "KILL", "ID", id1, "SKIPME", "no")), ":1\r\n")
+	if !c1.IsKilled() {
+		t.Fatal("SKIPME no should kill the caller")
+	}
+
+	server.AfterClientClose(c2)
+	reply = server.Exec(c1, utils.ToCmdLine("CLIENT", "LIST")).(*protocol.BulkReply)
+	if strings.Contains(string(reply.Arg), "id="+id2+" ") {
+		t.Errorf("closed client should be removed, got %q", reply.Arg)
+	}
+	assertReply(t, server.Exec(c1, utils.ToCmdLine("CLIENT", "FOO")), "-ERR unknown subcommand 'FOO'. Try CLIENT HELP.\r\n")
+}
@@ -0,0 +1,146 @@
+package database
+
+import (
+	"github.com/zhangming/go-redis/interfaces/redis"
+)
+
+// 写命令的提交顺序
+// 写命令持有 key 的锁执行，执行期间产生的 aof 和唤醒先记录在 commitLog 中，执行完成后按固定的顺序提交:
+//  1. 修改数据: 命令的执行函数
+//  2. 递增写入 key 的版本号: EXEC 看到新版本时数据已经修改完成
+//  3. 追加 aof: appendfsync always 时通知发出之前命令已经落盘
+//  4. 释放锁之后发布 keyspace 通知: 数据已经提交，订阅者收到通知后执行的 GET 一定能看到修改；
+//     发布时不持有锁，进程内订阅者的缓存已满时发布者等待也不会阻塞其它访问这些 key 的命令，
+//     订阅者在回调中访问同一个 key 不会死锁。并发修改同一个 key 的通知之间不保证顺序
+//  5. 唤醒阻塞的连接: 被唤醒的连接可以直接拿到锁取走数据
+// 事务中所有命令的副作用合并为一次提交，放弃的事务不递增版本号也不发布通知，
+// 已经执行的命令和回滚命令仍然写入 aof，保证重放的结果与内存一致
+
+// commitLog 记录一次提交的副作用
+type commitLog struct {
+	// 执行命令的原始 DB
+	db        *DB
+	writeKeys []string
+	aof       []CmdLine
+	events    []keyEvent
+	// 命令执行期间直接发出的通知(例如集合为空后删除 key)，排在命令自身的通知之后
+	inner []keyEvent
+	// 需要唤醒最早登记的连接的 key
+	signals []string
+	// 需要唤醒所有登记的连接的 key
+	broadcasts []string
+	// 事务中的只读命令读到的已经过期的 key，释放锁之后删除
+	readExpired []string
+}
+
+// postCommitHooks 释放锁之前按顺序执行
+var postCommitHooks = []func(db *DB, log *commitLog){
+	(*DB).commitVersions,
+	(*DB).commitAof,
+}
+
+// beginCommit 返回执行命令使用的 DB，它的 aof 和唤醒记录在返回的 commitLog 中，不能在命令执行之后继续使用。
+// 直接复制 db 只在调用方持有 writeGate 时是安全的: SWAPDB 独占 writeGate 修改 db，写命令执行期间 db 不会被修改。
+// 不持有 writeGate 的读命令使用 beginRead
+func (db *DB) beginCommit(writeKeys []string) (*DB, *commitLog) {
+	log := &commitLog{
+		db:        db,
+		writeKeys: writeKeys,
+	}
+	view := *db
+	view.addAof = func(line CmdLine) {
+		log.aof = append(log.aof, line)
+	}
+	view.pendingCommit = log
+	return &view, log
+}
+
+// base 返回 beginCommit 之前的 DB，在命令执行之后才会使用的闭包(例如过期任务)需要持有它
+func (db *DB) base() *DB {
+	if db.pendingCommit != nil {
+		return db.pendingCommit.db
+	}
+	return db
+}
+
+// addEvents 记录命令修改数据之后需要发布的通知
+func (log *commitLog) addEvents(pending *pendingEvents, reply redis.Reply) {
+	log.events = append(log.events, pending.changedEvents(reply)...)
+	log.events = append(log.events, log.inner...)
+	log.inner = nil
+}
+
+// abort 放弃的事务没有修改数据，不递增版本号也不发布通知
+func (log *commitLog) abort() {
+	log.writeKeys = nil
+	log.events = nil
+	log.inner = nil
+}
+
+// commit 依次执行 postCommitHooks，调用方持有 key 的锁
+func (db *DB) commit(log *commitLog) {
+	for _, hook := range postCommitHooks {
+		hook(db, log)
+	}
+}
+
+func (db *DB) commitVersions(log *commitLog) {
+	db.addVersion(log.writeKeys...)
+}
+
+func (db *DB) commitAof(log *commitLog) {
+	for _, line := range log.aof {
+		db.addAof(line)
+	}
+}
+
+// commitEvents 发布提交中记录的通知，调用方已经释放了 key 的锁
+func (db *DB) commitEvents(log *commitLog) {
+	for _, e := range log.events {
+		db.notify(e.class, e.event, e.key)
+	}
+	for _, e := range log.inner {
+		db.notify(e.class, e.event, e.key)
+	}
+}
+
+// wakeBlocked 唤醒提交中记录的阻塞连接，调用方已经释放了 key 的锁
+func (db *DB) wakeBlocked(log *commitLog) {
+	for _, key := range log.signals {
+		db.blocking.signal(key)
+	}
+	for _, key := range log.broadcasts {
+		db.blocking.broadcast(key)
+	}
+}
+
+// signalBlocked 唤醒 key 上最早登记的阻塞连接，命令执行期间推迟到释放锁之后
+func (db *DB) signalBlocked(key string) {
+	if db.pendingCommit != nil {
+		db.pendingCommit.signals = append(db.pendingCommit.signals, key)
+		return
+	}
+	db.blocking.signal(key)
+}
+
+// broadcastBlocked 唤醒 key 上所有登记的阻塞连接，命令执行期间推迟到释放锁之后
+func (db *DB) broadcastBlocked(key string) {
+	if db.pendingCommit != nil {
+		db.pendingCommit.broadcasts = append(db.pendingCommit.broadcasts, key)
+		return
+	}
+	db.blocking.broadcast(key)
+}
+
+// execCommitted 持有 key 的锁执行 fn，fn 负责调用 commit；释放锁之后发布通知、删除只读命令读到的过期 key 并唤醒阻塞的连接
+func (db *DB) execCommitted(writeKeys, readKeys []string, log *commitLog, fn func() redis.Reply) redis.Reply {
+	db.RWLocks(writeKeys, readKeys)
+	reply := func() redis.Reply {
+		defer db.RWUnLocks(writeKeys, readKeys)
+		return fn()
+	}()
+	db.commitEvents(log)
+	db.expireReads(log.readExpired)
+	db.wakeBlocked(log)
+	return reply
+}
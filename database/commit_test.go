@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/pubhub"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestCommitOrder(t *testing.T) {
+	db := makeTestDB()
+	db.blocking = makeBlockingKeys()
+	var steps []string
+	db.addAof = func(line CmdLine) {
+		steps = append(steps, "aof "+string(line[0]))
+	}
+	waiter := db.blocking.wait([]string{"q"})
+	before := db.GetVersion("q")
+	db.notifier = func(dbIndex int, class int, event string, key string) {
+		steps = append(steps, "notify "+event)
+		// 通知发出时数据和版本号已经更新，key 的锁已经释放，阻塞的连接还没有被唤醒
+		if !keyUnlocked(db, key) {
+			t.Errorf("%s: lock of %s is held while notifying", event, key)
+		}
+		if _, ok := db.data.GetLocked(key); !ok {
+			t.Errorf("%s: data of %s is not visible", event, key)
+		}
+		if db.GetVersion(key) == before {
+			t.Errorf("%s: version of %s is not bumped", event, key)
+		}
+		if len(waiter.wake) > 0 {
+			t.Errorf("%s: blocked client is woken before notification", event)
+		}
+	}
+	execTestCmd(db, "RPUSH", "q", "a")
+	if len(steps) != 2 || steps[0] != "aof rpush" || steps[1] != "notify rpush" {
+		t.Errorf("unexpected commit steps %v", steps)
+	}
+	if len(waiter.wake) == 0 {
+		t.Error("blocked client should be woken after commit")
+	}
+
+	// 事务合并为一次提交，所有 aof 在通知之前
+	steps = nil
+	waiter = db.blocking.wait([]string{"q"})
+	before = db.GetVersion("q")
+	conn := connection.NewFakeConn()
+	db.Exec(conn, utils.ToCmdLine("MULTI"))
+	db.Exec(conn, utils.ToCmdLine("RPUSH", "q", "b"))
+	db.Exec(conn, utils.ToCmdLine("LPOP", "q"))
+	db.Exec(conn, utils.ToCmdLine("EXEC"))
+	if len(steps) != 4 || steps[0] != "aof rpush" || steps[1] != "aof lpop" || steps[2] != "notify rpush" || steps[3] != "notify lpop" {
+		t.Errorf("unexpected transaction commit steps %v", steps)
+	}
+}
+
+// keyUnlocked 检查其它协程能否拿到 key 的读锁
+func keyUnlocked(db *DB, key string) bool {
+	keys := []string{key}
+	locked := make(chan struct{})
+	go func() {
+		db.RWLocks(nil, keys)
+		db.RWUnLocks(nil, keys)
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+// TestNotificationVisibility 订阅者收到通知之后立即执行的读命令一定能看到这次修改
+func TestNotificationVisibility(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:            16,
+		NotifyKeyspaceEvents: "EA",
+	})
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	messages, err := server.Subscribe(ctx, "__keyevent@0__:set", "__keyevent@0__:expire", "__keyevent@0__:del")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个阶段每个 key 只修改一次，订阅者检查时看到的只能是这次修改或者更早的状态
+	const writers, keysPerWriter = 4, 100
+	phases := []struct {
+		channel string
+		cmd     []string
+		check   func(conn *connection.FakeConn, key string)
+	}{
+		{"__keyevent@0__:set", []string{"SET", "", "v"}, func(conn *connection.FakeConn, key string) {
+			assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", key)), "$1\r\nv\r\n")
+		}},
+		{"__keyevent@0__:expire", []string{"GETEX", "", "EX", "100"}, func(conn *connection.FakeConn, key string) {
+			assertReply(t, server.Exec(conn, utils.ToCmdLine("TTL", key)), ":100\r\n")
+		}},
+		{"__keyevent@0__:del", []string{"GETDEL", ""}, func(conn *connection.FakeConn, key string) {
+			assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", key)), ":0\r\n")
+		}},
+	}
+	for _, phase := range phases {
+		var wg sync.WaitGroup
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				conn := connection.NewFakeConn()
+				cmd := append([]string(nil), phase.cmd...)
+				for i := 0; i < keysPerWriter; i++ {
+					cmd[1] = "key:" + strconv.Itoa(w) + ":" + strconv.Itoa(i)
+					server.Exec(conn, utils.ToCmdLine(cmd...))
+				}
+			}(w)
+		}
+		conn := connection.NewFakeConn()
+		for n := 0; n < writers*keysPerWriter; n++ {
+			msg := <-messages
+			if msg.Channel != phase.channel {
+				t.Fatalf("unexpected notification %s %s", msg.Channel, msg.Payload)
+			}
+			phase.check(conn, string(msg.Payload))
+		}
+		wg.Wait()
+	}
+}
+
+// TestLazyExpireNotification 只读命令删除过期 key 的通知在释放锁之后发布，
+// 进程内订阅者的缓存已满时读命令等待投递，同一个 key 上的写命令不受影响
+func TestLazyExpireNotification(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:            16,
+		NotifyKeyspaceEvents: "Ex",
+	})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("DEBUG", "SET-ACTIVE-EXPIRE", "0"))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	const channel = "__keyevent@0__:expired"
+	messages, err := server.Subscribe(ctx, channel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 不消费消息，写满订阅者的缓存
+	for i := 0; i < pubhub.SubscriptionBuffer; i++ {
+		server.Exec(conn, utils.ToCmdLine("PUBLISH", channel, "filler"))
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v", "PX", "1"))
+	time.Sleep(10 * time.Millisecond)
+
+	read := make(chan time.Duration)
+	go func() {
+		start := time.Now()
+		assertReply(t, server.Exec(connection.NewFakeConn(), utils.ToCmdLine("GET", "k")), "$-1\r\n")
+		read <- time.Since(start)
+	}()
+	time.Sleep(pubhub.SubscriptionTimeout / 5)
+	start := time.Now()
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v2"))
+	if elapsed := time.Since(start); elapsed > pubhub.SubscriptionTimeout/2 {
+		t.Errorf("write waited %v for the blocked notification", elapsed)
+	}
+	if elapsed := <-read; elapsed < pubhub.SubscriptionTimeout/2 {
+		t.Errorf("expected the read to wait for the subscriber, returned after %v", elapsed)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$2\r\nv2\r\n")
+	if len(messages) != pubhub.SubscriptionBuffer {
+		t.Errorf("expected the expired notification to be dropped, buffered %d", len(messages))
+	}
+}
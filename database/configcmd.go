@@ -0,0 +1,130 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/wildcard"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// CONFIG GET/SET
+// CONFIG GET 返回配置文件中的所有配置项，CONFIG SET 只支持运行期间可以安全修改的配置项，
+// 修改只作用于内存中的配置，不写回配置文件。集群模式下加上 CLUSTER 参数由 cluster 包广播到所有节点
+
+// errClusterOptionReply 没有配置 peers 时 CLUSTER 参数由本节点返回错误
+var errClusterOptionReply = protocol.MakeErrReply("ERR CLUSTER option is only supported in cluster mode with peers")
+
+// configParam 可以用 CONFIG SET 修改的配置项
+type configParam struct {
+	// parse 检查配置值，返回 apply 使用的解析结果
+	parse func(value string) (int, bool)
+	// apply 应用新的配置值，调用方持有 configMu
+	apply func(server *Server, value string, parsed int)
+}
+
+var configParams = map[string]configParam{
+	"notify-keyspace-events": {parseNotifyFlags, func(server *Server, value string, flags int) {
+		server.notifyFlags.Store(int64(flags))
+		server.cfg.NotifyKeyspaceEvents = value
+	}},
+	"slowlog-log-slower-than": {parseConfigInt, func(server *Server, value string, n int) {
+		server.slowlogThreshold.Store(int64(n))
+		server.cfg.SlowlogLogSlowerThan = n
+	}},
+	"slowlog-max-len": {parseConfigNonNegative, func(server *Server, value string, n int) {
+		server.slowlog.setMaxLen(n)
+		server.cfg.SlowlogMaxLen = n
+	}},
+	"save": {parseConfigSave, func(server *Server, value string, _ int) {
+		rules, _ := config.ParseSaveRules(value)
+		server.rdb.rules.Store(rules)
+		server.cfg.Save = value
+	}},
+}
+
+func parseConfigSave(value string) (int, bool) {
+	_, err := config.ParseSaveRules(value)
+	return 0, err == nil
+}
+
+func parseConfigInt(value string) (int, bool) {
+	n, err := strconv.Atoi(value)
+	return n, err == nil
+}
+
+func parseConfigNonNegative(value string) (int, bool) {
+	n, ok := parseConfigInt(value)
+	return n, ok && n >= 0
+}
+
+// execConfig CONFIG GET pattern [pattern ...] | SET parameter value [parameter value ...]
+func (server *Server) execConfig(args [][]byte) redis.Reply {
+	if strings.EqualFold(string(args[len(args)-1]), "cluster") {
+		return errClusterOptionReply
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "get":
+		if len(args) < 2 {
+			return protocol.MakeArgNumErrReply("config|get")
+		}
+		return server.configGet(args[1:])
+	case "set":
+		if len(args) < 3 || len(args)%2 == 0 {
+			return protocol.MakeArgNumErrReply("config|set")
+		}
+		return server.configSet(args[1:])
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try CONFIG GET, CONFIG SET.")
+}
+
+func (server *Server) configGet(patterns [][]byte) redis.Reply {
+	matchers := make([]*wildcard.Pattern, 0, len(patterns))
+	for _, raw := range patterns {
+		pattern, err := wildcard.CompilePattern(strings.ToLower(string(raw)))
+		if err != nil {
+			return protocol.MakeErrReply("ERR invalid pattern " + string(raw))
+		}
+		matchers = append(matchers, pattern)
+	}
+	server.configMu.Lock()
+	entries := server.cfg.Entries()
+	server.configMu.Unlock()
+	var result [][]byte
+	for _, entry := range entries {
+		for _, matcher := range matchers {
+			if matcher.IsMatch(entry[0]) {
+				result = append(result, []byte(entry[0]), []byte(entry[1]))
+				break
+			}
+		}
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// configSet 先检查所有参数，全部合法时才修改，不会只有一部分参数生效
+func (server *Server) configSet(args [][]byte) redis.Reply {
+	params := make([]configParam, 0, len(args)/2)
+	parsed := make([]int, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		name, value := strings.ToLower(string(args[i])), string(args[i+1])
+		param, ok := configParams[name]
+		if !ok {
+			return protocol.MakeErrReply("ERR Unsupported CONFIG parameter: " + name)
+		}
+		n, ok := param.parse(value)
+		if !ok {
+			return protocol.MakeErrReply("ERR Invalid argument '" + value + "' for CONFIG SET '" + name + "'")
+		}
+		params = append(params, param)
+		parsed = append(parsed, n)
+	}
+	server.configMu.Lock()
+	defer server.configMu.Unlock()
+	for i, param := range params {
+		param.apply(server, string(args[2*i+1]), parsed[i])
+	}
+	return protocol.MakeOkReply()
+}
@@ -2,9 +2,12 @@ package database
 
 import (
 	"log/slog"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/zhangming/go-redis/config"
 	"github.com/zhangming/go-redis/datastruct/dict"
 	"github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/interfaces/redis"
@@ -18,35 +21,99 @@ const (
 )
 
 // DB stores data and execute user's commands
+// 数据和所属实例的状态在 dbShared 中，beginCommit/beginRead 返回的 DB 与原来的 DB 共用同一个 dbShared，
+// 只有每次执行各自的 addAof、pendingCommit 和 readExpired 不同
 type DB struct {
-	index int
+	*dbShared
+	// addaof is used to add command to aof
+	addAof func(CmdLine)
+	// 执行写命令时由 beginCommit 设置，记录推迟到提交时的副作用
+	pendingCommit *commitLog
+	// 只持有 key 的读锁时由 beginRead 设置，记录读到的已经过期的 key，释放锁之后由 expireReads 删除
+	readExpired *[]string
+}
+
+// dbShared 是同一个数据库的各个 DB 共用的部分。读命令不持有 writeGate 并发执行，
+// 放入 dbSet 之后只有 index 会被 SWAPDB 修改，它通过 getIndex/setIndex 原子地读写
+type dbShared struct {
+	// 在 dbSet 中的序号，SWAPDB 会修改，通过 getIndex 读取
+	index int32
+	// 创建顺序，跨 DB 加锁时按它排序，见 lockDBs
+	lockRank uint64
+	// 所属实例的配置，临时数据库为 nil
+	cfg *config.ServerProperties
 	// 数据存储的键值对
 	data *dict.ConcurrentDict
-	// key -> expireTime (time.Time) 记录键的过期时间
+	// key -> expireTime (time.Time) 记录键的过期时间。
+	// data 使用 *Locked 方法，由调用方通过 RWLocks 持有 key 所在分片的锁；ttlMap 的分片数与 data 不同，
+	// RWLocks 锁不住它的分片，所以不能使用 *Locked 方法，只通过自己加分片锁的 Get/Put/Remove 访问(见 ExpireAt/Expire/Persist)。
+	// 同一个 key 的值和过期时间仍然由调用方持有的 key 锁保证一起修改
 	ttlMap *dict.ConcurrentDict
 	// key -> version(uint32) 记录键的版本信息
 	versionMap *dict.ConcurrentDict
-	// addaof is used to add command to aof
-	addAof func(CmdLine)
 	// callbacks
 	// 回调函数
 	insertCallback database.KeyEventCallback
 	deleteCallback database.KeyEventCallback
+	// 发布 keyspace 通知，临时数据库为 nil
+	notifier func(dbIndex int, class int, event string, key string)
+	// 执行事务中的 PUBLISH，临时数据库为 nil
+	publisher func(args [][]byte) redis.Reply
+	// 阻塞在这个数据库的 key 上的连接，临时数据库为 nil
+	blocking *blockingKeys
+	// 所属实例的统计信息，临时数据库为 nil
+	stats *serverStats
+	// 热点 key 统计，没有开启或者临时数据库为 nil
+	hotkeys *hotKeyProfiler
+	// DEBUG SET-ACTIVE-EXPIRE 0 之后为 true，过期任务不再删除 key，临时数据库为 nil
+	activeExpireOff *atomic.Bool
+	// 过期任务所在的调度器，属于所在的实例，临时数据库使用进程共享的调度器
+	expires *timewheel.Scheduler
+	// UNLINK 后台释放大对象，属于所在的实例，临时数据库为 nil，直接释放
+	lazyfree *lazyFreer
+	// 记录写命令: 累计 rdb 的修改次数，开启 aof 时写入 aof，临时数据库为 nil。
+	// 由 saveToAof 按调用时的序号记录，SWAPDB 交换 DB 时只需要修改序号
+	aofSaver func(dbIndex int, cmdLine CmdLine)
 }
 
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
 
 func makeBasicDB() *DB {
+	return makeBasicDBWithShards(dataDictSize)
+}
+
+// makeBasicDBWithShards data 和 versionMap 使用 shardCount 个分片
+func makeBasicDBWithShards(shardCount int) *DB {
 	db := &DB{
-		data:       dict.MakeConcurrent(dataDictSize),
-		ttlMap:     dict.MakeConcurrent(ttlDictSize),
-		versionMap: dict.MakeConcurrent(dataDictSize),
-		addAof:     func(line CmdLine) {},
+		dbShared: &dbShared{
+			lockRank:   nextDBLockRank(),
+			data:       dict.MakeConcurrent(shardCount),
+			ttlMap:     dict.MakeConcurrent(ttlDictSize),
+			versionMap: dict.MakeConcurrent(shardCount),
+			expires:    timewheel.Default(),
+		},
 	}
+	db.addAof = db.saveToAof
 	return db
 }
 
+// saveToAof 是 DB 自身的 addAof，按调用时 DB 的序号记录写命令
+func (db *DB) saveToAof(line CmdLine) {
+	if db.aofSaver != nil {
+		db.aofSaver(db.getIndex(), line)
+	}
+}
+
+// checkReplySize 开启 max-reply-elements 后，回复超过限制时返回错误并提示使用 scan 类命令
+func (db *DB) checkReplySize(size int, alternative string) redis.Reply {
+	if db.cfg == nil || db.cfg.MaxReplyElements <= 0 || size <= db.cfg.MaxReplyElements {
+		return nil
+	}
+	return protocol.MakeErrReply("ERR reply has " + strconv.Itoa(size) + " elements which exceeds max-reply-elements " +
+		strconv.Itoa(db.cfg.MaxReplyElements) + ", use " + alternative + " instead")
+}
+
 /* ---- Transaction Functions ---- */
 
 // 参数验证
@@ -60,7 +127,7 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 
 // execNormalCommand 是完整的命令执行流程，包含加锁、版本控制等
 func (db *DB) execNormalCommand(cmdLine [][]byte) redis.Reply {
-	slog.Info("exec normal command")
+	slog.Debug("exec normal command")
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	cmd, ok := cmdTable[cmdName]
 	if !ok {
@@ -72,21 +139,24 @@ func (db *DB) execNormalCommand(cmdLine [][]byte) redis.Reply {
 
 	prepare := cmd.prepare
 	write, read := prepare(cmdLine[1:])
-	db.addVersion(write...)
-
-	// defer fmt.Println("锁放执行完毕")
-	slog.Info("即将执行命令")
-	db.RWLocks(write, read)
-	defer db.RWUnLocks(write, read)
-	// defer func() {
-	// 	if err := recover(); err != nil {
-	// 		slog.Error("panic in command execution", "err", err)
-	// 	}
-	// 	db.RWUnLocks(write, read) // 确保锁释放
-	// 	fmt.Println("锁释放执行完毕")
-	// }()
-	executer := cmd.executor
-	return executer(db, cmdLine[1:])
+	db.hotkeys.record(db.getIndex(), write, read)
+
+	if cmd.flags&flagReadOnly != 0 {
+		var reply redis.Reply
+		db.execRead(write, read, func(view *DB) {
+			view.recordKeyspaceLookups(read)
+			reply = cmd.executor(view, cmdLine[1:])
+		})
+		return reply
+	}
+	view, log := db.beginCommit(write)
+	return db.execCommitted(write, read, log, func() redis.Reply {
+		events := db.prepareEvents(cmdName, cmdLine[1:])
+		reply := cmd.executor(view, cmdLine[1:])
+		log.addEvents(events, reply)
+		db.commit(log)
+		return reply
+	})
 }
 
 func execMulti(db *DB, conn redis.Connection) redis.Reply {
@@ -103,7 +173,16 @@ func execMulti(db *DB, conn redis.Connection) redis.Reply {
 	return db.ExecMulti(conn, conn.GetWatching(), cmdLines)
 }
 
+// Exec 执行命令，命令层返回的 RESP3 类型在 RESP2 连接上会被转换
 func (db *DB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	reply := db.exec(c, cmdLine)
+	if c == nil || c.GetProtocol() < protocol.RESP3 {
+		return protocol.ToRESP2(reply)
+	}
+	return reply
+}
+
+func (db *DB) exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
 	// transaction control commands and other commands which cannot execute within transaction
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	if cmdName == "multi" {
@@ -127,6 +206,11 @@ func (db *DB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
 			return protocol.MakeArgNumErrReply(cmdName)
 		}
 		return Watch(db, c, cmdLine[1:])
+	} else if cmdName == "unwatch" && c != nil && !c.InMultiState() {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return UnWatch(c)
 	}
 	if c != nil && c.InMultiState() {
 		return EnqueueCmd(c, cmdLine)
@@ -140,114 +224,271 @@ func (db *DB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
 //  2. 防止内存无限增长
 // 限流与计数器
 // 如限制用户每分钟最多请求 100 次，可以为每个用户 key 设置 TTL=60。
-func genExpireTask(key string) string {
-	return "expire:" + key
+// 任务名包含 DB 的创建序号 lockRank，不同 DB 中的同名 key 各自有过期任务，COPY ... DB 不会覆盖源 DB 的任务。
+// 不使用 SELECT 的序号，因为 SWAPDB 之后序号会变，而已经注册的任务仍然属于原来的 DB
+func (db *DB) expireTask(key string) string {
+	return "expire:" + strconv.FormatUint(db.lockRank, 10) + ":" + key
 }
 
 // 设定ttl的键的过期时间
 func (db *DB) Expire(key string, expireTime time.Time) {
+	// 过期任务在命令执行之后触发，不能持有 beginCommit 返回的 DB
+	db = db.base()
 	db.ttlMap.Put(key, expireTime)
-	taskKey := genExpireTask(key)
-	timewheel.At(expireTime, taskKey, func() {
-		keys := []string{key}
-		db.RWLocks(keys, nil)
-		defer db.RWUnLocks(keys, nil)
-		// check-lock-check, ttl may be updated during waiting lock
-		slog.Info("expire " + key)
-		rawExpireTime, ok := db.ttlMap.Get(key)
-		if !ok {
-			return
-		}
-		expireTime, _ := rawExpireTime.(time.Time)
-		expired := time.Now().After(expireTime)
-		if expired {
-			db.Remove(key)
+	taskKey := db.expireTask(key)
+	db.expires.AddJob(expireTime, taskKey, func() {
+		// 与写命令相同，释放锁之后再发布通知
+		if db.removeIfExpired(key) {
+			db.notify(notifyExpired, "expired", key)
 		}
 	})
 }
 
+// removeIfExpired 过期任务加锁检查 key 是否到期并删除，返回是否删除了 key
+func (db *DB) removeIfExpired(key string) bool {
+	if db.activeExpireOff != nil && db.activeExpireOff.Load() {
+		return false
+	}
+	slog.Info("expire " + key)
+	return db.removeExpired(key)
+}
+
+// removeExpired 加写锁检查 key 是否到期并删除，返回是否删除了 key，调用方不能持有 key 的锁
+func (db *DB) removeExpired(key string) bool {
+	keys := []string{key}
+	db.RWLocks(keys, nil)
+	defer db.RWUnLocks(keys, nil)
+	// check-lock-check, ttl may be updated during waiting lock
+	expireTime, ok := db.ExpireAt(key)
+	if !ok {
+		return false
+	}
+	// 定时器在到期时间或之后触发
+	if time.Now().Before(expireTime) {
+		return false
+	}
+	db.Remove(key)
+	db.stats.incr(statsMetricExpired, 1)
+	return true
+}
+
 // 持久化取消TTL键
 func (db *DB) Persist(key string) {
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
-	timewheel.Cancel(taskKey)
+	db.expires.RemoveJob(db.expireTask(key))
 }
 
-// 检查密钥是否过期
+// 检查密钥是否过期，过期的 key 在持有写锁时直接删除，
+// 在 beginRead 返回的 DB 上只记录下来，释放读锁之后由 expireReads 删除
 func (db *DB) IsExpired(key string) bool {
-	rawExpireTime, ok := db.ttlMap.Get(key)
+	expireTime, ok := db.ExpireAt(key)
 	if !ok {
 		return false
 	}
-	expireTime, _ := rawExpireTime.(time.Time)
 	expired := time.Now().After(expireTime)
-	if expired {
-		db.Remove(key)
+	if !expired {
+		return false
+	}
+	if db.readExpired != nil {
+		*db.readExpired = append(*db.readExpired, key)
+		return true
+	}
+	db.Remove(key)
+	db.stats.incr(statsMetricExpired, 1)
+	db.notify(notifyExpired, "expired", key)
+	return true
+}
+
+// beginRead 返回只持有 key 的读锁时使用的 DB，读到的已经过期的 key 视为不存在但不删除，
+// 记录在返回的切片中，调用方释放锁之后交给 expireReads。
+// 读命令不持有 writeGate，与 SWAPDB 并发执行，所以不能像 beginCommit 那样复制 db，只共用 dbShared
+func (db *DB) beginRead() (*DB, *[]string) {
+	expired := make([]string, 0)
+	view := &DB{
+		dbShared:    db.dbShared,
+		addAof:      db.addAof,
+		readExpired: &expired,
+	}
+	return view, &expired
+}
+
+// execRead 持有 key 的锁在 beginRead 返回的 DB 上执行 fn，释放锁之后删除读到的过期 key
+func (db *DB) execRead(writeKeys, readKeys []string, fn func(view *DB)) {
+	view, expired := db.beginRead()
+	func() {
+		db.RWLocks(writeKeys, readKeys)
+		defer db.RWUnLocks(writeKeys, readKeys)
+		fn(view)
+	}()
+	db.expireReads(*expired)
+}
+
+// expireReads 加写锁删除只读访问时发现的过期 key，释放锁之后发布通知，调用方已经释放了 key 的锁
+func (db *DB) expireReads(keys []string) {
+	for _, key := range keys {
+		if db.removeExpired(key) {
+			db.notify(notifyExpired, "expired", key)
+		}
+	}
+}
+
+/* ---- Key Space ----- */
+// 值和过期时间分别保存在 data 和 ttlMap 中。读写过期时间使用 ExpireAt/TTL，
+// 整体读取或替换一个 key 的命令(RENAME、COPY、RESTORE、MSET 等)使用 GetWithTTL/SetWithTTL，
+// 不直接访问 ttlMap，避免写入新值之后残留旧的过期时间或者丢掉原来的过期时间。调用方持有 key 的锁
+
+// ExpireAt returns the absolute expiration time of the key, false if the key has no ttl
+func (db *DB) ExpireAt(key string) (time.Time, bool) {
+	raw, ok := db.ttlMap.Get(key)
+	if !ok {
+		return time.Time{}, false
+	}
+	expireTime, _ := raw.(time.Time)
+	return expireTime, true
+}
+
+// TTL returns the remaining time to live of the key, false if the key has no ttl.
+// 已经到期但还没有被删除的 key 返回 0
+func (db *DB) TTL(key string) (time.Duration, bool) {
+	expireTime, ok := db.ExpireAt(key)
+	if !ok {
+		return 0, false
+	}
+	return max(time.Until(expireTime), 0), true
+}
+
+// GetWithTTL returns the entity and its expiration time together, expireAt is nil if the key has no ttl
+func (db *DB) GetWithTTL(key string) (entity *database.DataEntity, expireAt *time.Time, exists bool) {
+	entity, exists = db.GetEntity(key)
+	if !exists {
+		return nil, nil, false
+	}
+	if expireTime, ok := db.ExpireAt(key); ok {
+		expireAt = &expireTime
+	}
+	return entity, expireAt, true
+}
+
+// SetWithTTL puts the entity and replaces the ttl of the key with expireAt, nil means no ttl.
+// 覆盖已有的 key 时原来的过期时间一并丢弃，返回新增的 key 数
+func (db *DB) SetWithTTL(key string, entity *database.DataEntity, expireAt *time.Time) int {
+	ret := db.PutEntity(key, entity)
+	if expireAt != nil {
+		db.Expire(key, *expireAt)
+	} else if _, ok := db.ttlMap.Get(key); ok {
+		db.Persist(key)
+	}
+	return ret
+}
+
+// recordKeyspaceLookups 只读命令读取的每个 key 记一次 keyspace_hits 或 keyspace_misses，调用方持有 key 的锁
+func (db *DB) recordKeyspaceLookups(keys []string) {
+	if db.stats == nil {
+		return
+	}
+	for _, key := range keys {
+		if _, ok := db.peekEntity(key); ok {
+			db.stats.incr(statsMetricKeyspaceHits, 1)
+		} else {
+			db.stats.incr(statsMetricKeyspaceMisses, 1)
+		}
 	}
-	return expired
 }
 
 /* ---- Data Access ----- */
+// GetEntity/PutEntity/Remove 等方法使用 data 的 *Locked 方法，不自己加锁，调用方必须已经通过 RWLocks 持有 key 的锁:
+// 命令的 executor 由 execNormalCommand/ExecMulti 加锁，其他入口(过期任务、Server.GetEntity、槽位重定向、加载 rdb)自己加锁。
+// 修改数据的方法需要写锁。GetEntity 读到已经过期的 key 时需要删除它，只持有读锁的调用方(只读命令、事务中的只读命令、
+// DEBUG OBJECT 等)必须使用 beginRead 返回的 DB，过期的 key 视为不存在，释放锁之后由 expireReads 加写锁删除
+
 // 返回给定键的数据实体绑定
 func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
-	// 这里选用不上锁的吧
-	raw, ok := db.data.Get(key)
+	raw, ok := db.data.GetLocked(key)
 	if !ok {
 		return nil, false
 	}
 	if db.IsExpired(key) {
-		//惰性检查，键过期了，就直接删除
-		db.Remove(key)
+		//惰性检查，键过期了，持有写锁时由 IsExpired 删除
 		return nil, false
 	}
 	entity, _ := raw.(*database.DataEntity)
+	db.touch(entity, time.Now())
 	return entity, true
 }
 
+// peekEntity 与 GetEntity 相同但不记录访问，用于 OBJECT 等查看 key 元数据的命令
+func (db *DB) peekEntity(key string) (*database.DataEntity, bool) {
+	raw, ok := db.data.GetLocked(key)
+	if !ok || db.IsExpired(key) {
+		return nil, false
+	}
+	entity, _ := raw.(*database.DataEntity)
+	return entity, true
+}
+
+// hasKey 只判断 key 是否存在，已经到期的 key 视为不存在但不删除，调用方持有 key 的读锁即可
+func (db *DB) hasKey(key string) bool {
+	if _, ok := db.data.GetLocked(key); !ok {
+		return false
+	}
+	expireTime, ok := db.ExpireAt(key)
+	return !ok || time.Now().Before(expireTime)
+}
+
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	ret := db.data.Put(key, entity)
+	initAccess(entity, time.Now())
+	ret := db.data.PutLocked(key, entity)
 	if cb := db.insertCallback; ret > 0 && cb != nil {
-		cb(db.index, key, entity)
+		cb(db.getIndex(), key, entity)
+	}
+	if ret > 0 && isBlockingPopTarget(entity) {
+		// 新建的列表和有序集合可以唤醒阻塞在这个 key 上的 BLPOP/BZPOPMIN 等命令
+		db.signalBlocked(key)
 	}
 	return ret
 }
 
 // 编辑现有的数据实体
+// 调用方已经持有 key 的锁，只能使用 data 的 *Locked 方法；已经过期的 key 视为不存在
 func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
-	return db.data.PutIfExistsWithLock(key, entity)
+	db.IsExpired(key)
+	initAccess(entity, time.Now())
+	return db.data.PutIfExistsLocked(key, entity)
 }
 
 // 只有当键不存在时才插入数据实体
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	ret := db.data.PutIfAbsentWithLock(key, entity)
+	db.IsExpired(key)
+	initAccess(entity, time.Now())
+	ret := db.data.PutIfAbsentLocked(key, entity)
 	// db.insertCallback may be set as nil, during `if` and actually callback
 	// so introduce a local variable `cb`
 	if cb := db.insertCallback; ret > 0 && cb != nil {
-		cb(db.index, key, entity)
+		cb(db.getIndex(), key, entity)
 	}
 	return ret
 }
 
 // 从数据库中删除给定的键
 func (db *DB) Remove(key string) {
-	raw, deleted := db.data.Remove(key)
+	raw, deleted := db.data.RemoveLocked(key)
 	db.ttlMap.Remove(key)
-	taskKey := genExpireTask(key)
-	timewheel.Cancel(taskKey)
+	db.expires.RemoveJob(db.expireTask(key))
 	if cb := db.deleteCallback; cb != nil {
 		var entity *database.DataEntity
 		if deleted > 0 {
 			entity = raw.(*database.DataEntity)
 		}
-		cb(db.index, key, entity)
+		cb(db.getIndex(), key, entity)
 	}
 }
 
 // Removes the given keys from db
+// 调用方已经持有这些 key 的锁，这里不能再加分片锁，否则会死锁
 func (db *DB) Removes(keys ...string) (deleted int) {
 	deleted = 0
 	for _, key := range keys {
-		_, exists := db.data.GetWithLock(key)
+		_, exists := db.data.GetLocked(key)
 		if exists {
 			db.Remove(key)
 			deleted++
@@ -265,6 +506,15 @@ func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
 	db.data.RWUnLocks(writeKeys, readKeys)
 }
 
+// getIndex 返回 DB 当前的序号
+func (db *DB) getIndex() int {
+	return int(atomic.LoadInt32(&db.index))
+}
+
+func (db *DB) setIndex(index int) {
+	atomic.StoreInt32(&db.index, int32(index))
+}
+
 /* --- add version --- */
 
 // 返回给定键的版本代码
@@ -292,9 +542,7 @@ func (db *DB) ForEach(cb func(key string, entity *database.DataEntity, expiratio
 	db.data.ForEach(func(key string, raw interface{}) bool {
 		entity := raw.(*database.DataEntity)
 		var expiration *time.Time
-		rawExpireTime, ok := db.ttlMap.Get(key)
-		if ok {
-			expireTime, _ := rawExpireTime.(time.Time)
+		if expireTime, ok := db.ExpireAt(key); ok {
 			expiration = &expireTime
 		}
 
@@ -0,0 +1,58 @@
+package database
+
+import (
+	"cmp"
+	"slices"
+	"sync/atomic"
+)
+
+// 跨 DB 加锁
+// 同时读写多个 DB 的命令(COPY ... DB、MOVE 等)通过 lockDBs 一次获取所有 DB 中 key 的锁。
+// 各个 DB 按照创建顺序 lockRank 加锁、逆序释放，所有跨 DB 的命令使用同一个顺序，不会互相等待形成环。
+// 不使用 dbSet 中的序号排序: SWAPDB 会交换序号，交换前后按序号加锁的两个命令顺序相反。
+// SWAPDB 自己独占 writeGate，执行期间没有其它写命令，不需要 key 的锁
+
+var dbLockRank atomic.Uint64
+
+func nextDBLockRank() uint64 {
+	return dbLockRank.Add(1)
+}
+
+// dbKeys 一个 DB 中需要加锁的 key
+type dbKeys struct {
+	db        *DB
+	writeKeys []string
+	readKeys  []string
+}
+
+// lockDBs 获取多个 DB 中 key 的锁，返回释放锁的函数。
+// 同一个 DB 可以出现多次(包括 beginCommit 返回的 DB)，合并后只加锁一次
+func lockDBs(locks ...dbKeys) func() {
+	merged := make([]dbKeys, 0, len(locks))
+	for _, l := range locks {
+		i := slices.IndexFunc(merged, func(m dbKeys) bool {
+			return m.db.lockRank == l.db.lockRank
+		})
+		if i < 0 {
+			merged = append(merged, dbKeys{
+				db:        l.db,
+				writeKeys: slices.Clone(l.writeKeys),
+				readKeys:  slices.Clone(l.readKeys),
+			})
+			continue
+		}
+		merged[i].writeKeys = append(merged[i].writeKeys, l.writeKeys...)
+		merged[i].readKeys = append(merged[i].readKeys, l.readKeys...)
+	}
+	slices.SortFunc(merged, func(a, b dbKeys) int {
+		return cmp.Compare(a.db.lockRank, b.db.lockRank)
+	})
+	for _, l := range merged {
+		l.db.RWLocks(l.writeKeys, l.readKeys)
+	}
+	return func() {
+		for i := len(merged) - 1; i >= 0; i-- {
+			merged[i].db.RWUnLocks(merged[i].writeKeys, merged[i].readKeys)
+		}
+	}
+}
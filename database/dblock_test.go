@@ -0,0 +1,87 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestLockDBs(t *testing.T) {
+	a, b := makeBasicDB(), makeBasicDB()
+	// 同一个 DB 出现多次时合并，beginCommit 返回的 DB 与原来的 DB 是同一个
+	view, _ := a.beginCommit([]string{"k"})
+	unlock := lockDBs(
+		dbKeys{db: b, writeKeys: []string{"x"}},
+		dbKeys{db: view, writeKeys: []string{"k"}},
+		dbKeys{db: a, readKeys: []string{"k", "y"}},
+	)
+	unlock()
+	// 释放之后可以再次获取
+	unlock = lockDBs(dbKeys{db: a, writeKeys: []string{"k", "y"}}, dbKeys{db: b, writeKeys: []string{"x"}})
+	unlock()
+}
+
+func TestCrossDBCopyNoDeadlock(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	// 两个方向的跨 DB COPY 和 SWAPDB 并发执行
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			conn := connection.NewFakeConn()
+			from, to := strconv.Itoa(w%2), strconv.Itoa(1-w%2)
+			server.Exec(conn, utils.ToCmdLine("SELECT", from))
+			for i := 0; i < 500; i++ {
+				key := "k" + strconv.Itoa(i%5)
+				server.Exec(conn, utils.ToCmdLine("SET", key, from))
+				server.Exec(conn, utils.ToCmdLine("COPY", key, "k"+strconv.Itoa((i+1)%5), "DB", to, "REPLACE"))
+				if w == 0 && i%50 == 0 {
+					server.Exec(conn, utils.ToCmdLine("SWAPDB", "0", "1"))
+				}
+			}
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("cross db copies deadlocked")
+	}
+}
+
+// Server.GetEntity 自己持有 key 的锁，与写命令并发时 -race 不报告数据竞争，过期的 key 也不会返回
+func TestServerGetEntityLocks(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		conn := connection.NewFakeConn()
+		for i := 0; i < 1000; i++ {
+			server.Exec(conn, utils.ToCmdLine("SET", "k"+strconv.Itoa(i%8), strconv.Itoa(i)))
+			server.Exec(conn, utils.ToCmdLine("DEL", "k"+strconv.Itoa((i+4)%8)))
+		}
+	}()
+	for i := 0; i < 1000; i++ {
+		server.GetEntity(0, "k"+strconv.Itoa(i%8))
+	}
+	<-done
+
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "e", "v", "PX", "1"))
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := server.GetEntity(0, "e"); ok {
+		t.Error("expected expired key not to be returned")
+	}
+}
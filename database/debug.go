@@ -0,0 +1,266 @@
+package database
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// execDebug 调试用的管理命令
+func (server *Server) execDebug(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("debug")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "ttlmap":
+		return server.execDebugTTLMap(args[1:])
+	case "zset-levels":
+		return server.execDebugZSetLevels(c, args[1:])
+	case "evict-candidates":
+		return server.execDebugEvictCandidates(args[1:])
+	case "seed-freq", "seed-idle":
+		return server.execDebugSeedAccess(c, subCmd, args[1:])
+	case "sleep":
+		return server.execDebugSleep(args[1:])
+	case "object":
+		return server.execDebugObject(c, args[1:])
+	case "set-active-expire":
+		return server.execDebugSetActiveExpire(args[1:])
+	case "reload":
+		return server.execDebugReload(args[1:])
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'")
+}
+
+// DEBUG TTLMAP <db> [RESCHEDULE]
+// 列出 ttlMap 中的每个 key: [key, 过期时间戳(毫秒), 剩余毫秒数, 时间轮任务是否存在(1/0)]
+// RESCHEDULE 会为丢失了时间轮任务的 key 重新注册过期任务
+func (server *Server) execDebugTTLMap(args [][]byte) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return protocol.MakeArgNumErrReply("debug|ttlmap")
+	}
+	dbIndex, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid DB index")
+	}
+	db, errReply := server.selectDB(dbIndex)
+	if errReply != nil {
+		return errReply
+	}
+	reschedule := false
+	if len(args) == 2 {
+		if strings.ToLower(string(args[1])) != "reschedule" {
+			return protocol.MakeSyntaxErrReply()
+		}
+		reschedule = true
+	}
+
+	now := time.Now()
+	result := make([]redis.Reply, 0, db.ttlMap.Len())
+	var lost []string
+	db.ttlMap.ForEach(func(key string, val interface{}) bool {
+		expireTime, _ := val.(time.Time)
+		scheduled := int64(0)
+		if db.expires.HasJob(db.expireTask(key)) {
+			scheduled = 1
+		} else {
+			lost = append(lost, key)
+		}
+		result = append(result, protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			protocol.MakeIntReply(expireTime.UnixMilli()),
+			protocol.MakeIntReply(expireTime.Sub(now).Milliseconds()),
+			protocol.MakeIntReply(scheduled),
+		}))
+		return true
+	})
+	if reschedule {
+		db.rescheduleExpires(lost)
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// rescheduleExpires 为丢失了时间轮任务的 key 重新注册过期任务。
+// 逐个持有 key 的写锁重新读取过期时间，列出之后被 PERSIST、DEL 或覆盖的 key 不会恢复旧的过期时间
+func (db *DB) rescheduleExpires(keys []string) {
+	for _, key := range keys {
+		db.rescheduleExpire(key)
+	}
+}
+
+func (db *DB) rescheduleExpire(key string) {
+	keys := []string{key}
+	db.RWLocks(keys, nil)
+	defer db.RWUnLocks(keys, nil)
+	expireTime, ok := db.ExpireAt(key)
+	if !ok {
+		return
+	}
+	db.Expire(key, expireTime)
+}
+
+// DEBUG SLEEP <seconds>
+// 只暂停执行这条命令的连接，用于测试超时和阻塞，关闭服务器时提前返回
+func (server *Server) execDebugSleep(args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("debug|sleep")
+	}
+	seconds, err := strconv.ParseFloat(string(args[0]), 64)
+	if err != nil || seconds < 0 {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-server.shutdown:
+	}
+	return protocol.MakeOkReply()
+}
+
+// DEBUG OBJECT <key>
+// 与 redis 相同的格式，serializedlength 是值在 rdb 中的长度，lru 是以秒为单位的 24 位时钟
+func (server *Server) execDebugObject(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("debug|object")
+	}
+	db, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	var reply redis.Reply
+	db.execRead(nil, []string{key}, func(view *DB) {
+		reply = debugObject(view, key)
+	})
+	return reply
+}
+
+func debugObject(db *DB, key string) redis.Reply {
+	entity, ok := db.peekEntity(key)
+	if !ok {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	payload, err := aof.DumpEntity(entity)
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	lastAccess := atomic.LoadInt64(&entity.LRU)
+	return protocol.MakeStatusReply(fmt.Sprintf("Value at:%p refcount:1 encoding:%s serializedlength:%d lru:%d lru_seconds_idle:%d",
+		entity, objectEncoding(entity), len(payload)-dumpOverheadLen, (lastAccess/1000)&lruClockMax,
+		(time.Now().UnixMilli()-lastAccess)/1000))
+}
+
+const (
+	// DUMP 载荷开头 1 字节的类型以及末尾的 2 字节 rdb 版本和 8 字节校验和
+	dumpOverheadLen = 11
+	lruClockMax     = 1<<24 - 1
+)
+
+// DEBUG SET-ACTIVE-EXPIRE <0|1>
+// 关闭后到期的 key 只在访问时删除；重新开启时为期间已经触发的 key 重新注册过期任务
+func (server *Server) execDebugSetActiveExpire(args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("debug|set-active-expire")
+	}
+	switch string(args[0]) {
+	case "0":
+		server.activeExpireOff.Store(true)
+	case "1":
+		if server.activeExpireOff.Swap(false) {
+			for i := range server.dbSet {
+				db := server.mustSelectDB(i)
+				var lost []string
+				db.ttlMap.ForEach(func(key string, val interface{}) bool {
+					if !db.expires.HasJob(db.expireTask(key)) {
+						lost = append(lost, key)
+					}
+					return true
+				})
+				db.rescheduleExpires(lost)
+			}
+		}
+	default:
+		return protocol.MakeErrReply("ERR value must be 0 or 1")
+	}
+	return protocol.MakeOkReply()
+}
+
+// DEBUG RELOAD
+// 暂停写命令，保存 rdb 之后从文件重新加载全部数据。加载到辅助实例中再替换各个数据库，不写入 aof
+func (server *Server) execDebugReload(args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.MakeArgNumErrReply("debug|reload")
+	}
+	if !server.rdb.saving.CompareAndSwap(false, true) {
+		return protocol.MakeErrReply(errSaveInProgress.Error())
+	}
+	defer server.rdb.saving.Store(false)
+	unlock := server.lockWriteGate(true)
+	defer unlock()
+	if err := server.writeRDBFile(server, server.rdb.dirty.Load()); err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	aux := makeAuxiliaryServer(server.cfg, server.expires)
+	if err := aux.loadRdbFile(); err != nil {
+		return protocol.MakeErrReply("ERR Error trying to load the RDB dump: " + err.Error())
+	}
+	for i := range server.dbSet {
+		server.loadDB(i, aux.mustSelectDB(i))
+	}
+	return protocol.MakeOkReply()
+}
+
+// DEBUG ZSET-LEVELS <key>
+// 返回 [length, n, max-level, l, levels, [[层号, 节点数, 期望节点数, 平均跨度], ...]]
+// 每层以 1/2 的概率晋升，第 i 层(从 1 开始)期望有 n/2^(i-1) 个节点，平均跨度约为 2^(i-1)
+func (server *Server) execDebugZSetLevels(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("debug|zset-levels")
+	}
+	db, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	var reply redis.Reply
+	db.execRead(nil, []string{key}, func(view *DB) {
+		reply = debugZSetLevels(view, key)
+	})
+	return reply
+}
+
+func debugZSetLevels(db *DB, key string) redis.Reply {
+	sortedSet, err := db.getAsSortedSet(key)
+	if err != nil {
+		return err
+	}
+	if sortedSet == nil {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	stats := sortedSet.LevelStats()
+	levels := make([]redis.Reply, len(stats))
+	for i, stat := range stats {
+		levels[i] = protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeIntReply(int64(i + 1)),
+			protocol.MakeIntReply(stat.Nodes),
+			protocol.MakeIntReply(sortedSet.Len() >> i),
+			protocol.MakeBulkReply([]byte(strconv.FormatFloat(stat.AvgSpan, 'f', 2, 64))),
+		})
+	}
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeBulkReply([]byte("length")),
+		protocol.MakeIntReply(sortedSet.Len()),
+		protocol.MakeBulkReply([]byte("max-level")),
+		protocol.MakeIntReply(int64(len(stats))),
+		protocol.MakeBulkReply([]byte("levels")),
+		protocol.MakeMultiRawReply(levels),
+	})
+}
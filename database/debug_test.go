@@ -0,0 +1,162 @@
+package database
+
+import (
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestDebugZSetLevels(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("ZADD", "z", "1", "a"))
+	server.Exec(conn, utils.ToCmdLine("SET", "s", "v"))
+
+	reply := server.Exec(conn, utils.ToCmdLine("DEBUG", "ZSET-LEVELS", "z"))
+	prefix := "*6\r\n$6\r\nlength\r\n:1\r\n$9\r\nmax-level\r\n"
+	if actual := string(reply.ToBytes()); len(actual) < len(prefix) || actual[:len(prefix)] != prefix {
+		t.Errorf("unexpected reply %q", actual)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "ZSET-LEVELS", "missing")), "-ERR no such key\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "ZSET-LEVELS", "s")),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestDebugObjectAndSleep(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "s", "hello"))
+	reply := string(server.Exec(conn, utils.ToCmdLine("DEBUG", "OBJECT", "s")).ToBytes())
+	if !regexp.MustCompile(`^\+Value at:0x[0-9a-f]+ refcount:1 encoding:embstr serializedlength:6 lru:\d+ lru_seconds_idle:0\r\n$`).MatchString(reply) {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "OBJECT", "missing")), "-ERR no such key\r\n")
+
+	start := time.Now()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "SLEEP", "0.05")), "+OK\r\n")
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected to sleep 50ms, returned after %v", elapsed)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "SLEEP", "abc")), "-ERR value is not a valid float\r\n")
+}
+
+func TestDebugSetActiveExpire(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "SET-ACTIVE-EXPIRE", "0")), "+OK\r\n")
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v", "PX", "10"))
+	time.Sleep(1500 * time.Millisecond)
+	// 到期的 key 留在数据库中，访问时才删除
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DBSIZE")), ":1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k")), ":0\r\n")
+
+	server.Exec(conn, utils.ToCmdLine("SET", "k2", "v", "PX", "10"))
+	time.Sleep(1500 * time.Millisecond)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "SET-ACTIVE-EXPIRE", "1")), "+OK\r\n")
+	time.Sleep(1500 * time.Millisecond)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DBSIZE")), ":0\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "SET-ACTIVE-EXPIRE", "2")), "-ERR value must be 0 or 1\r\n")
+}
+
+func TestDebugReload(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "s", "v", "EX", "100"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "l", "a", "b"))
+	server.Exec(conn, utils.ToCmdLine("SELECT", "3"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "h", "f", "v"))
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "RELOAD")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("HGET", "h", "f")), "$1\r\nv\r\n")
+	server.Exec(conn, utils.ToCmdLine("SELECT", "0"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("LRANGE", "l", "0", "-1")), "*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	ttl := string(server.Exec(conn, utils.ToCmdLine("TTL", "s")).ToBytes())
+	if ttl != ":100\r\n" && ttl != ":99\r\n" {
+		t.Errorf("expected ttl to survive reload, got %q", ttl)
+	}
+	// 重新加载之后的数据库仍然可以写入
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "n", "1")), "+OK\r\n")
+}
+
+func TestDebugTTLMap(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v", "EX", "100"))
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v", "EX", "100"))
+	entry := func(scheduled string) *regexp.Regexp {
+		return regexp.MustCompile(`^\*1\r\n\*4\r\n\$1\r\nk\r\n:\d+\r\n:\d+\r\n:` + scheduled + `\r\n$`)
+	}
+	ttlMap := func(args ...string) string {
+		return string(server.Exec(conn, utils.ToCmdLine(append([]string{"DEBUG", "TTLMAP"}, args...)...)).ToBytes())
+	}
+
+	// 两个 DB 中的同名 key 各自有任务，去掉 db0 的任务不影响 db1
+	db0 := server.mustSelectDB(0)
+	db0.expires.RemoveJob(db0.expireTask("k"))
+	if reply := ttlMap("0"); !entry("0").MatchString(reply) {
+		t.Errorf("expected k in db0 not scheduled, actually %q", reply)
+	}
+	if reply := ttlMap("1"); !entry("1").MatchString(reply) {
+		t.Errorf("expected k in db1 scheduled, actually %q", reply)
+	}
+
+	// RESCHEDULE 返回的是重新注册之前的状态
+	if reply := ttlMap("0", "RESCHEDULE"); !entry("0").MatchString(reply) {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	if reply := ttlMap("0"); !entry("1").MatchString(reply) {
+		t.Errorf("expected k in db0 rescheduled, actually %q", reply)
+	}
+	if expireAt, _ := db0.ExpireAt("k"); time.Until(expireAt) < 90*time.Second {
+		t.Errorf("expected reschedule to keep the expire time, actually %v", expireAt)
+	}
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "TTLMAP")), "-ERR wrong number of arguments for 'debug|ttlmap' command\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "TTLMAP", "x")), "-ERR invalid DB index\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "TTLMAP", "0", "x")), "-ERR syntax error\r\n")
+}
+
+func TestDebugTTLMapRescheduleWithPersist(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	db := server.mustSelectDB(0)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+	}
+	for round := 0; round < 20; round++ {
+		for _, key := range keys {
+			server.Exec(conn, utils.ToCmdLine("SET", key, "v", "EX", "100"))
+			db.expires.RemoveJob(db.expireTask(key))
+		}
+		// PERSIST 与 RESCHEDULE 并发执行，无论先后，PERSIST 之后的 key 都不能恢复过期时间
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			persistConn := connection.NewFakeConn()
+			// 倒序执行，与 RESCHEDULE 在中间相遇
+			for i := len(keys) - 1; i >= 0; i-- {
+				server.Exec(persistConn, utils.ToCmdLine("PERSIST", keys[i]))
+			}
+		}()
+		server.Exec(conn, utils.ToCmdLine("DEBUG", "TTLMAP", "0", "RESCHEDULE"))
+		wg.Wait()
+
+		for _, key := range keys {
+			if _, ok := db.ExpireAt(key); ok || db.expires.HasJob(db.expireTask(key)) {
+				t.Fatalf("round %d: expected persisted key %s to have no ttl and no expire task", round, key)
+			}
+		}
+	}
+}
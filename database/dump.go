@@ -0,0 +1,100 @@
+package database
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// DUMP/RESTORE
+// 载荷的格式与 redis 相同(见 aof/dump.go)，可以在实例之间迁移 key。
+// RESTORE 写入 aof 的是重建 key 的普通命令和 PEXPIREAT，不是载荷本身
+
+// execDump DUMP key
+func execDump(db *DB, args [][]byte) redis.Reply {
+	entity, ok := db.GetEntity(string(args[0]))
+	if !ok {
+		return protocol.MakeNullBulkReply()
+	}
+	payload, err := aof.DumpEntity(entity)
+	if err != nil {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeBulkReply(payload)
+}
+
+// execRestore RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+// ttl 为 0 表示不过期，ABSTTL 时 ttl 是过期的 unix 毫秒时间戳
+func execRestore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if ttl < 0 {
+		return protocol.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	var replace, absTTL bool
+	for _, arg := range args[3:] {
+		switch strings.ToLower(string(arg)) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	_, exists := db.GetEntity(key)
+	if exists && !replace {
+		return protocol.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	obj, err := aof.ParseDump(args[2])
+	if errors.Is(err, aof.ErrBadDumpPayload) {
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	if err != nil {
+		return protocol.MakeErrReply("ERR Bad data format")
+	}
+	entity := db.entityFromRDB(obj)
+	if entity == nil {
+		return protocol.MakeErrReply("ERR Bad data format")
+	}
+	var expireAt *time.Time
+	if ttl > 0 {
+		t := time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		if absTTL {
+			t = time.UnixMilli(ttl)
+		}
+		expireAt = &t
+	}
+	if exists {
+		db.Remove(key)
+		db.addAof(utils.ToCmdLine("del", key))
+	}
+	if expireAt != nil && !expireAt.After(time.Now()) {
+		// 与 redis 相同，已经过期的 key 不会创建
+		return protocol.MakeOkReply()
+	}
+	db.SetWithTTL(key, entity, expireAt)
+	for _, cmd := range aof.EntityToCmds(key, entity) {
+		db.addAof(cmd.Args)
+	}
+	if expireAt != nil {
+		db.addAof(aof.MakeExpireCmd(key, *expireAt).Args)
+	}
+	return protocol.MakeOkReply()
+}
+
+func init() {
+	registerCommand("Dump", execDump, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
+	registerCommand("Restore", execRestore, writeFirstKey, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+}
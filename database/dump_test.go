@@ -0,0 +1,71 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func dumpTestKey(t *testing.T, db *DB, key string) []byte {
+	t.Helper()
+	reply, ok := execTestCmd(db, "dump", key).(*protocol.BulkReply)
+	if !ok || reply.Arg == nil {
+		t.Fatalf("dump %s: unexpected reply", key)
+	}
+	return reply.Arg
+}
+
+func TestDumpRestore(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "set", "str", "value")
+	execTestCmd(db, "rpush", "list", "a", "b", "c")
+	execTestCmd(db, "hset", "hash", "f1", "v1")
+	execTestCmd(db, "hset", "hash", "f2", "v2")
+	execTestCmd(db, "sadd", "set", "m1", "m2")
+	execTestCmd(db, "zadd", "zset", "1", "a", "2.5", "b")
+	checks := map[string][]string{
+		"str":  {"get", "str"},
+		"list": {"lrange", "list", "0", "-1"},
+		"hash": {"hget", "hash", "f2"},
+		"set":  {"sismember", "set", "m2"},
+		"zset": {"zscore", "zset", "b"},
+	}
+	for key, check := range checks {
+		payload := dumpTestKey(t, db, key)
+		expected := string(execTestCmd(db, check...).ToBytes())
+		restored := key + ":restored"
+		assertReply(t, execTestCmd(db, "restore", restored, "0", string(payload)), "+OK\r\n")
+		check = append([]string{check[0], restored}, check[2:]...)
+		assertReply(t, execTestCmd(db, check...), expected)
+		// 相同的值得到相同的载荷
+		if string(dumpTestKey(t, db, restored)) != string(payload) {
+			t.Errorf("%s: payload changed after restore", key)
+		}
+	}
+	assertReply(t, execTestCmd(db, "dump", "nokey"), "$-1\r\n")
+
+	payload := string(dumpTestKey(t, db, "str"))
+	assertReply(t, execTestCmd(db, "restore", "str", "0", payload), "-BUSYKEY Target key name already exists.\r\n")
+	execTestCmd(db, "rpush", "other", "x")
+	assertReply(t, execTestCmd(db, "restore", "other", "0", payload, "REPLACE"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "get", "other"), "$5\r\nvalue\r\n")
+
+	assertReply(t, execTestCmd(db, "restore", "ttl", "100000", payload), "+OK\r\n")
+	if pttl := execTestCmd(db, "pttl", "ttl").(*protocol.IntReply).Code; pttl < 90000 || pttl > 100000 {
+		t.Errorf("unexpected pttl %d", pttl)
+	}
+	at := time.Now().Add(time.Hour).Unix()
+	assertReply(t, execTestCmd(db, "restore", "abs", strconv.FormatInt(at*1000, 10), payload, "ABSTTL"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "expiretime", "abs"), ":"+strconv.FormatInt(at, 10)+"\r\n")
+	past := strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10)
+	assertReply(t, execTestCmd(db, "restore", "gone", past, payload, "ABSTTL"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "exists", "gone"), ":0\r\n")
+
+	corrupted := []byte(payload)
+	corrupted[1] ^= 0xff
+	assertReply(t, execTestCmd(db, "restore", "bad", "0", string(corrupted)), "-ERR DUMP payload version or checksum are wrong\r\n")
+	assertReply(t, execTestCmd(db, "restore", "bad", "-1", payload), "-ERR Invalid TTL value, must be >= 0\r\n")
+	assertReply(t, execTestCmd(db, "restore", "bad", "0", payload, "KEEP"), "-ERR syntax error\r\n")
+}
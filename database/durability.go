@@ -0,0 +1,134 @@
+package database
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 同步持久化
+// WAITSYNC command [arg ...] 执行一条写命令，命令写入 aof 并 fsync 之后才回复。
+// CLIENT DURABILITY SYNC 之后连接上的所有写命令(包括 EXEC)都这样确认，CLIENT DURABILITY ASYNC 恢复默认。
+// 等待期间连接不占用工作池的名额，同时等待的连接共用一次 fsync。
+// 没有开启 aof 时无法确认落盘，返回错误
+
+var errDurabilityNoAofReply = protocol.MakeErrReply("ERR synchronous durability requires appendonly yes")
+
+// aofPersisters 返回写入 aof 的所有 persister，分区模式下每个分区一个
+func (server *Server) aofPersisters() []*aof.Persister {
+	if !server.cfg.AppendOnly {
+		return nil
+	}
+	if server.partitions != nil {
+		return server.partitions.Persisters()
+	}
+	if server.persister != nil {
+		return []*aof.Persister{server.persister}
+	}
+	return nil
+}
+
+// checkAofWrite 最近一次 aof 写入或者刷盘失败时拒绝写命令，分区模式下检查每个分区
+func (server *Server) checkAofWrite() redis.Reply {
+	for _, persister := range server.aofPersisters() {
+		if !persister.WriteOK() {
+			return protocol.MakeErrReply("MISCONF Errors writing to the AOF file: " + persister.LastWriteError())
+		}
+	}
+	return nil
+}
+
+// execFsyncAlways appendfsync always 时没有写入 aof 的命令不能确认成功，
+// 执行期间进入 aof 的命令写入或者刷盘失败时返回 MISCONF 错误
+func (server *Server) execFsyncAlways(c redis.Connection, db *DB, cmdLine [][]byte) redis.Reply {
+	persisters := server.aofPersisters()
+	if len(persisters) == 0 || !persisters[0].FsyncAlways() {
+		return db.Exec(c, cmdLine)
+	}
+	offsets := make([]int64, len(persisters))
+	for i, persister := range persisters {
+		offsets[i] = persister.QueuedOffset()
+	}
+	reply := db.Exec(c, cmdLine)
+	for i, persister := range persisters {
+		if persister.FailedSince(offsets[i]) {
+			return protocol.MakeErrReply("MISCONF Errors writing to the AOF file: " + persister.LastWriteError())
+		}
+	}
+	return reply
+}
+
+// needsSyncAck 连接开启了 CLIENT DURABILITY SYNC 时写命令需要等待落盘，事务中排队的命令在 EXEC 时等待
+func needsSyncAck(c redis.Connection, cmdName string) bool {
+	if c == nil || !c.IsSyncDurability() || !isWriteCommand(cmdName) {
+		return false
+	}
+	return cmdName == "exec" || !c.InMultiState()
+}
+
+// execWaitSync WAITSYNC command [arg ...]
+func (server *Server) execWaitSync(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	if errReply := server.checkPermission(c, "waitsync", cmdLine); errReply != nil {
+		return errReply
+	}
+	if len(cmdLine) < 2 {
+		return protocol.MakeArgNumErrReply("waitsync")
+	}
+	if c != nil && c.InMultiState() {
+		return protocol.MakeErrReply("ERR WAITSYNC is not allowed in transaction")
+	}
+	inner := strings.ToLower(string(cmdLine[1]))
+	if inner == "waitsync" {
+		return protocol.MakeErrReply("ERR WAITSYNC can not be nested")
+	}
+	return server.execSyncAck(c, cmdLine[1:])
+}
+
+// execSyncAck 执行命令并等待命令写入的 aof 落盘，落盘失败时返回 MISCONF 错误
+func (server *Server) execSyncAck(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	persisters := server.aofPersisters()
+	if len(persisters) == 0 {
+		return errDurabilityNoAofReply
+	}
+	failures := make([]int64, len(persisters))
+	for i, persister := range persisters {
+		failures[i] = persister.WriteFailures()
+	}
+	reply := server.execUnacked(c, cmdLine)
+	if reply == nil || protocol.IsErrorReply(reply) {
+		return reply
+	}
+	// 命令的 aof 在执行期间已经进入 persister，之后取到的编号一定包含它们
+	for i, persister := range persisters {
+		err := persister.WaitFsynced(persister.QueuedOffset())
+		if err == nil && persister.WriteFailures() != failures[i] {
+			err = errors.New(persister.LastWriteError())
+		}
+		if err != nil {
+			return protocol.MakeErrReply("MISCONF Errors writing to the AOF file: " + err.Error())
+		}
+	}
+	return reply
+}
+
+// execClientDurability CLIENT DURABILITY SYNC|ASYNC
+func (server *Server) execClientDurability(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("client|durability")
+	}
+	switch strings.ToLower(string(args[0])) {
+	case "sync":
+		if len(server.aofPersisters()) == 0 {
+			return errDurabilityNoAofReply
+		}
+		c.SetSyncDurability(true)
+	case "async":
+		c.SetSyncDurability(false)
+	default:
+		return protocol.MakeSyntaxErrReply()
+	}
+	return protocol.MakeOkReply()
+}
@@ -0,0 +1,71 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestSyncDurability(t *testing.T) {
+	for _, window := range []int{0, 50} {
+		cfg := &config.ServerProperties{
+			Dir:               t.TempDir(),
+			AppendOnly:        true,
+			AppendFilename:    "appendonly.aof",
+			AppendFsync:       "no",
+			AofCoalesceWindow: window,
+			Databases:         16,
+		}
+		server := NewStandaloneServerWithConfig(cfg)
+		conn := connection.NewFakeConn()
+		exec := func(args ...string) string {
+			return string(server.Exec(conn, utils.ToCmdLine(args...)).ToBytes())
+		}
+		// 回复时这条命令已经落盘
+		assertSynced := func(reply, expected string) {
+			t.Helper()
+			if reply != expected {
+				t.Fatalf("window %d: expected %q, got %q", window, expected, reply)
+			}
+			if synced, queued := server.persister.FsyncedOffset(), server.persister.QueuedOffset(); synced < queued {
+				t.Fatalf("window %d: replied before fsync, synced %d queued %d", window, synced, queued)
+			}
+		}
+		assertSynced(exec("WAITSYNC", "SET", "k", "v"), "+OK\r\n")
+		assertSynced(exec("WAITSYNC", "INCR", "n"), ":1\r\n")
+
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("CLIENT", "DURABILITY", "SYNC")), "+OK\r\n")
+		assertSynced(exec("INCR", "n"), ":2\r\n")
+		exec("MULTI")
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("INCR", "n")), "+QUEUED\r\n")
+		assertSynced(exec("EXEC"), "*1\r\n:3\r\n")
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("CLIENT", "DURABILITY", "ASYNC")), "+OK\r\n")
+
+		// 同时等待的连接共用 fsync
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				c := connection.NewFakeConn()
+				ret := server.Exec(c, utils.ToCmdLine("WAITSYNC", "SET", "key:"+strconv.Itoa(i), "v"))
+				if string(ret.ToBytes()) != "+OK\r\n" {
+					t.Errorf("waitsync: %q", ret.ToBytes())
+				}
+			}(i)
+		}
+		wg.Wait()
+		server.Close()
+	}
+
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("WAITSYNC", "SET", "k", "v")), "-ERR synchronous durability requires appendonly yes\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CLIENT", "DURABILITY", "SYNC")), "-ERR synchronous durability requires appendonly yes\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("WAITSYNC", "WAITSYNC", "SET", "k", "v")), "-ERR WAITSYNC can not be nested\r\n")
+}
@@ -0,0 +1,285 @@
+package database
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 访问记录与淘汰模拟
+// 每次命令通过 GetEntity 访问 key 时更新 LRU 时间和 LFU 计数器，算法与 redis 相同:
+// 计数器按对数概率增长，每隔 lfu-decay-time 分钟没有访问衰减 1。
+// 目前没有 maxmemory，DEBUG EVICT-CANDIDATES 只是按照策略给出接下来会被淘汰的 key，不会删除数据
+
+const (
+	lfuInitVal          = 5
+	lfuMaxVal           = 255
+	defaultLfuLogFactor = 10
+	defaultLfuDecayTime = 1
+)
+
+const (
+	policyNoEviction     = "noeviction"
+	policyAllKeysLRU     = "allkeys-lru"
+	policyVolatileLRU    = "volatile-lru"
+	policyAllKeysLFU     = "allkeys-lfu"
+	policyVolatileLFU    = "volatile-lfu"
+	policyAllKeysRandom  = "allkeys-random"
+	policyVolatileRandom = "volatile-random"
+	policyVolatileTTL    = "volatile-ttl"
+)
+
+func validPolicy(policy string) bool {
+	switch policy {
+	case policyNoEviction, policyAllKeysLRU, policyVolatileLRU, policyAllKeysLFU, policyVolatileLFU,
+		policyAllKeysRandom, policyVolatileRandom, policyVolatileTTL:
+		return true
+	}
+	return false
+}
+
+func (db *DB) lfuLogFactor() int {
+	if db.cfg == nil || db.cfg.LfuLogFactor <= 0 {
+		return defaultLfuLogFactor
+	}
+	return db.cfg.LfuLogFactor
+}
+
+func (db *DB) lfuDecayTime() int {
+	if db.cfg == nil || db.cfg.LfuDecayTime <= 0 {
+		return defaultLfuDecayTime
+	}
+	return db.cfg.LfuDecayTime
+}
+
+// initAccess 新写入的 key 从 lfuInitVal 开始计数，避免刚写入就被淘汰
+func initAccess(entity *database.DataEntity, now time.Time) {
+	if atomic.LoadInt64(&entity.LRU) == 0 {
+		atomic.StoreInt64(&entity.LRU, now.UnixMilli())
+		atomic.StoreUint32(&entity.Freq, lfuInitVal)
+	}
+}
+
+// lfuDecay 返回按照距上次访问的时间衰减后的计数器
+func lfuDecay(counter uint32, lastAccess int64, now time.Time, decayTime int) uint32 {
+	periods := (now.UnixMilli() - lastAccess) / (int64(decayTime) * time.Minute.Milliseconds())
+	if periods <= 0 {
+		return counter
+	}
+	if periods >= int64(counter) {
+		return 0
+	}
+	return counter - uint32(periods)
+}
+
+// lfuLogIncr 计数器越大增长的概率越小
+func lfuLogIncr(counter uint32, logFactor int) uint32 {
+	if counter >= lfuMaxVal {
+		return lfuMaxVal
+	}
+	baseval := float64(counter) - lfuInitVal
+	if baseval < 0 {
+		baseval = 0
+	}
+	if rand.Float64() < 1.0/(baseval*float64(logFactor)+1) {
+		counter++
+	}
+	return counter
+}
+
+// touch 记录一次访问，并发的读命令可能丢失少量计数，不影响淘汰的近似效果
+func (db *DB) touch(entity *database.DataEntity, now time.Time) {
+	lastAccess := atomic.LoadInt64(&entity.LRU)
+	counter := lfuDecay(atomic.LoadUint32(&entity.Freq), lastAccess, now, db.lfuDecayTime())
+	atomic.StoreUint32(&entity.Freq, lfuLogIncr(counter, db.lfuLogFactor()))
+	atomic.StoreInt64(&entity.LRU, now.UnixMilli())
+}
+
+type evictCandidate struct {
+	dbIndex int
+	key     string
+	idle    int64 // 秒
+	freq    uint32
+	ttl     int64 // 毫秒，-1 表示没有过期时间
+}
+
+func (c *evictCandidate) reason(policy string) string {
+	switch policy {
+	case policyAllKeysLRU, policyVolatileLRU:
+		return "idle " + strconv.FormatInt(c.idle, 10) + "s"
+	case policyAllKeysLFU, policyVolatileLFU:
+		return "freq " + strconv.FormatUint(uint64(c.freq), 10)
+	case policyVolatileTTL:
+		return "ttl " + strconv.FormatInt(c.ttl, 10) + "ms"
+	}
+	return "random"
+}
+
+// collectEvictCandidates 按照策略对 key 排序，越靠前越先被淘汰
+// redis 淘汰时只采样 maxmemory-samples 个 key，这里对全部 key 精确排序，给出的是近似淘汰的理想顺序
+func (server *Server) collectEvictCandidates(policy string, dbIndexes []int) []*evictCandidate {
+	now := time.Now()
+	volatile := strings.HasPrefix(policy, "volatile-")
+	var candidates []*evictCandidate
+	for _, dbIndex := range dbIndexes {
+		db := server.mustSelectDB(dbIndex)
+		decayTime := db.lfuDecayTime()
+		db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			if volatile && expiration == nil {
+				return true
+			}
+			lastAccess := atomic.LoadInt64(&entity.LRU)
+			candidate := &evictCandidate{
+				dbIndex: dbIndex,
+				key:     key,
+				idle:    (now.UnixMilli() - lastAccess) / 1000,
+				freq:    lfuDecay(atomic.LoadUint32(&entity.Freq), lastAccess, now, decayTime),
+				ttl:     -1,
+			}
+			if expiration != nil {
+				candidate.ttl = expiration.Sub(now).Milliseconds()
+			}
+			candidates = append(candidates, candidate)
+			return true
+		})
+	}
+	switch policy {
+	case policyAllKeysLRU, policyVolatileLRU:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].idle > candidates[j].idle
+		})
+	case policyAllKeysLFU, policyVolatileLFU:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			if candidates[i].freq != candidates[j].freq {
+				return candidates[i].freq < candidates[j].freq
+			}
+			return candidates[i].idle > candidates[j].idle
+		})
+	case policyVolatileTTL:
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].ttl < candidates[j].ttl
+		})
+	default:
+		rand.Shuffle(len(candidates), func(i, j int) {
+			candidates[i], candidates[j] = candidates[j], candidates[i]
+		})
+	}
+	return candidates
+}
+
+// DEBUG EVICT-CANDIDATES [COUNT n] [POLICY policy] [DB index]
+// 默认使用 maxmemory-policy，返回前 n 个(默认 10)候选 key，
+// 每个候选为 {key, db, idle(秒), freq, ttl(毫秒), reason}
+func (server *Server) execDebugEvictCandidates(args [][]byte) redis.Reply {
+	count := 10
+	policy := strings.ToLower(server.cfg.MaxMemoryPolicy)
+	dbIndex := -1
+	for i := 0; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.MakeSyntaxErrReply()
+		}
+		value := string(args[i+1])
+		switch strings.ToLower(string(args[i])) {
+		case "count":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return protocol.MakeErrReply("ERR count should be a positive integer")
+			}
+			count = n
+		case "policy":
+			policy = strings.ToLower(value)
+		case "db":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 || n >= len(server.dbSet) {
+				return protocol.MakeErrReply("ERR DB index is out of range")
+			}
+			dbIndex = n
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	if policy == "" {
+		policy = policyNoEviction
+	}
+	if !validPolicy(policy) {
+		return protocol.MakeErrReply("ERR unknown maxmemory-policy '" + policy + "'")
+	}
+	if policy == policyNoEviction {
+		return protocol.MakeErrReply("ERR maxmemory-policy is noeviction, no key would be evicted")
+	}
+
+	var dbIndexes []int
+	if dbIndex >= 0 {
+		dbIndexes = []int{dbIndex}
+	} else {
+		for i := range server.dbSet {
+			dbIndexes = append(dbIndexes, i)
+		}
+	}
+	candidates := server.collectEvictCandidates(policy, dbIndexes)
+	if len(candidates) > count {
+		candidates = candidates[:count]
+	}
+	result := make([]redis.Reply, len(candidates))
+	for i, c := range candidates {
+		result[i] = protocol.MakeMapReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("key")),
+			protocol.MakeBulkReply([]byte(c.key)),
+			protocol.MakeBulkReply([]byte("db")),
+			protocol.MakeIntReply(int64(c.dbIndex)),
+			protocol.MakeBulkReply([]byte("idle")),
+			protocol.MakeIntReply(c.idle),
+			protocol.MakeBulkReply([]byte("freq")),
+			protocol.MakeIntReply(int64(c.freq)),
+			protocol.MakeBulkReply([]byte("ttl")),
+			protocol.MakeIntReply(c.ttl),
+			protocol.MakeBulkReply([]byte("reason")),
+			protocol.MakeBulkReply([]byte(c.reason(policy))),
+		})
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// DEBUG SEED-FREQ key counter / DEBUG SEED-IDLE key seconds
+// 直接设置 key 的 LFU 计数器或空闲时间，用于调试淘汰策略
+// 计数器按照距上次访问的时间衰减，所以设置空闲时间同样会让计数器衰减
+func (server *Server) execDebugSeedAccess(c redis.Connection, subCmd string, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("debug|" + subCmd)
+	}
+	value, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || value < 0 {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	db, errReply := server.selectDB(c.GetDBIndex())
+	if errReply != nil {
+		return errReply
+	}
+	key := string(args[0])
+	db.RWLocks([]string{key}, nil)
+	defer db.RWUnLocks([]string{key}, nil)
+	raw, ok := db.data.GetLocked(key)
+	if !ok {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	entity := raw.(*database.DataEntity)
+	now := time.Now()
+	if subCmd == "seed-freq" {
+		if value > lfuMaxVal {
+			value = lfuMaxVal
+		}
+		// 同时把访问时间设为当前时间，避免设置的计数器立即衰减
+		atomic.StoreInt64(&entity.LRU, now.UnixMilli())
+		atomic.StoreUint32(&entity.Freq, uint32(value))
+	} else {
+		atomic.StoreInt64(&entity.LRU, now.Add(-time.Duration(value)*time.Second).UnixMilli())
+	}
+	return protocol.MakeOkReply()
+}
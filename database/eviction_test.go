@@ -0,0 +1,80 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestLfuCounter(t *testing.T) {
+	now := time.Now()
+	if c := lfuDecay(10, now.Add(-3*time.Minute).UnixMilli(), now, 1); c != 7 {
+		t.Errorf("expected 7, got %d", c)
+	}
+	if c := lfuDecay(2, now.Add(-time.Hour).UnixMilli(), now, 1); c != 0 {
+		t.Errorf("expected 0, got %d", c)
+	}
+	// 计数器小于 lfuInitVal 时每次访问都会增长
+	if c := lfuLogIncr(3, 10); c != 4 {
+		t.Errorf("expected 4, got %d", c)
+	}
+	if c := lfuLogIncr(lfuMaxVal, 10); c != lfuMaxVal {
+		t.Errorf("counter should not exceed %d", lfuMaxVal)
+	}
+}
+
+func candidateKeys(t *testing.T, reply interface{ ToBytes() []byte }) []string {
+	t.Helper()
+	multi, ok := reply.(*protocol.MultiRawReply)
+	if !ok {
+		t.Fatalf("unexpected reply %q", reply.ToBytes())
+	}
+	keys := make([]string, len(multi.Replies))
+	for i, r := range multi.Replies {
+		fields := r.(*protocol.MultiRawReply).Replies
+		keys[i] = string(fields[1].(*protocol.BulkReply).Arg)
+	}
+	return keys
+}
+
+func TestDebugEvictCandidates(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:       16,
+		MaxMemoryPolicy: "allkeys-lru",
+	})
+	conn := connection.NewFakeConn()
+	for _, key := range []string{"a", "b", "c"} {
+		server.Exec(conn, utils.ToCmdLine("SET", key, "v"))
+	}
+	server.Exec(conn, utils.ToCmdLine("EXPIRE", "c", "100"))
+	server.Exec(conn, utils.ToCmdLine("DEBUG", "SEED-IDLE", "a", "60"))
+	server.Exec(conn, utils.ToCmdLine("DEBUG", "SEED-IDLE", "b", "120"))
+	server.Exec(conn, utils.ToCmdLine("DEBUG", "SEED-FREQ", "c", "0"))
+
+	reply := server.Exec(conn, utils.ToCmdLine("DEBUG", "EVICT-CANDIDATES", "COUNT", "2"))
+	if keys := strings.Join(candidateKeys(t, reply), ","); keys != "b,a" {
+		t.Errorf("lru: expected b,a, got %s", keys)
+	}
+	if !strings.Contains(string(reply.ToBytes()), "idle 120s") {
+		t.Errorf("reason missing in %q", reply.ToBytes())
+	}
+	reply = server.Exec(conn, utils.ToCmdLine("DEBUG", "EVICT-CANDIDATES", "POLICY", "allkeys-lfu"))
+	if keys := candidateKeys(t, reply); len(keys) != 3 || keys[0] != "c" {
+		t.Errorf("lfu: expected c first, got %v", keys)
+	}
+	reply = server.Exec(conn, utils.ToCmdLine("DEBUG", "EVICT-CANDIDATES", "POLICY", "volatile-ttl"))
+	if keys := strings.Join(candidateKeys(t, reply), ","); keys != "c" {
+		t.Errorf("volatile-ttl: expected c, got %s", keys)
+	}
+	// 模拟不会删除数据
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DBSIZE")), ":3\r\n")
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "EVICT-CANDIDATES", "POLICY", "noeviction")),
+		"-ERR maxmemory-policy is noeviction, no key would be evicted\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "SEED-FREQ", "missing", "1")), "-ERR no such key\r\n")
+}
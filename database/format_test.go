@@ -0,0 +1,162 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/encoder"
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func assertFormatVersion(t *testing.T, filename string, expected int) {
+	t.Helper()
+	version, err := aof.FileFormatVersion(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != expected {
+		t.Fatalf("%s: expected format version %d, got %d", filepath.Base(filename), expected, version)
+	}
+}
+
+func TestFormatVersion(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		RDBFilename:    "dump.rdb",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v"))
+	server.Exec(conn, utils.ToCmdLine("XADD", "s", "1-0", "a", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "+OK\r\n")
+	server.Close()
+	assertFormatVersion(t, cfg.AppendFilePath(), aof.FormatVersion)
+	assertFormatVersion(t, cfg.RDBFilePath(), aof.FormatVersion)
+
+	// 版本标记不会被当作命令执行
+	loaded := NewStandaloneServerWithConfig(cfg)
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "k")), "$1\r\nv\r\n")
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("XLEN", "s")), ":1\r\n")
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("REWRITEAOF")), "+OK\r\n")
+	loaded.Close()
+	assertFormatVersion(t, cfg.AppendFilePath(), aof.FormatVersion)
+
+	// 没有标记的旧文件是版本 1
+	legacy := filepath.Join(cfg.Dir, "legacy.aof")
+	if err := os.WriteFile(legacy, protocol.MakeMultiBulkReply(utils.ToCmdLine("SET", "k", "v")).ToBytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	assertFormatVersion(t, legacy, 1)
+}
+
+func TestRefuseNewerFormat(t *testing.T) {
+	dir := t.TempDir()
+	newer := protocol.MakeMultiBulkReply(utils.ToCmdLine("GOREDIS-FORMAT", "99")).ToBytes()
+	newer = append(newer, protocol.MakeMultiBulkReply(utils.ToCmdLine("SET", "k", "v")).ToBytes()...)
+	if err := os.WriteFile(filepath.Join(dir, "appendonly.aof"), newer, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := aof.CheckFileFormat(filepath.Join(dir, "appendonly.aof"))
+	if !errors.Is(err, aof.ErrNewerFormat) {
+		t.Fatalf("expected ErrNewerFormat, got %v", err)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("server should refuse to load newer aof")
+			}
+		}()
+		NewStandaloneServerWithConfig(&config.ServerProperties{
+			Dir:            dir,
+			AppendOnly:     true,
+			AppendFilename: "appendonly.aof",
+			Databases:      16,
+		})
+	}()
+
+	var buf bytes.Buffer
+	enc := encoder.NewEncoder(&buf)
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	_ = enc.WriteAux("goredis-format", "99")
+	_ = enc.WriteDBHeader(0, 1, 0)
+	_ = enc.WriteStringObject("k", []byte("v"))
+	_ = enc.WriteEnd()
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: dir})
+	defer server.Close()
+	err = server.LoadRDB(core.NewDecoder(bytes.NewReader(buf.Bytes())))
+	if !errors.Is(err, aof.ErrNewerFormat) {
+		t.Fatalf("expected ErrNewerFormat, got %v", err)
+	}
+}
+
+func TestConvertFormat(t *testing.T) {
+	for _, preamble := range []bool{false, true} {
+		cfg := &config.ServerProperties{
+			Dir:               t.TempDir(),
+			AppendOnly:        true,
+			AppendFilename:    "appendonly.aof",
+			AppendFsync:       "always",
+			RDBFilename:       "dump.rdb",
+			Databases:         16,
+			AofUseRdbPreamble: preamble,
+		}
+		server := NewStandaloneServerWithConfig(cfg)
+		conn := connection.NewFakeConn()
+		server.Exec(conn, utils.ToCmdLine("SET", "k", "v"))
+		server.Exec(conn, utils.ToCmdLine("XADD", "s", "1-0", "a", "1"))
+		server.Exec(conn, utils.ToCmdLine("SELECT", "2"))
+		server.Exec(conn, utils.ToCmdLine("HSET", "h", "f", "v"))
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("REWRITEAOF")), "+OK\r\n")
+		// 重写之后的命令在 aof 尾部
+		server.Exec(conn, utils.ToCmdLine("XADD", "s2", "1-0", "a", "1"))
+		server.Exec(conn, utils.ToCmdLine("RPUSH", "l", "a"))
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "+OK\r\n")
+		server.Close()
+
+		dir := t.TempDir()
+		for _, name := range []string{"appendonly.aof", "dump.rdb"} {
+			result, err := aof.ConvertFile(filepath.Join(cfg.Dir, name), filepath.Join(dir, name), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.From != aof.FormatVersion || result.To != 1 || result.Dropped == 0 {
+				t.Errorf("%s: unexpected result %+v", name, *result)
+			}
+			assertFormatVersion(t, filepath.Join(dir, name), 1)
+		}
+		fromAof := NewStandaloneServerWithConfig(&config.ServerProperties{
+			Dir:            dir,
+			AppendOnly:     true,
+			AppendFilename: "appendonly.aof",
+			Databases:      16,
+		})
+		fromRdb := NewStandaloneServerWithConfig(&config.ServerProperties{
+			Dir:         dir,
+			RDBFilename: "dump.rdb",
+			Databases:   16,
+		})
+		for _, loaded := range []*Server{fromAof, fromRdb} {
+			conn := connection.NewFakeConn()
+			assertReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "k")), "$1\r\nv\r\n")
+			assertReply(t, loaded.Exec(conn, utils.ToCmdLine("EXISTS", "s", "s2")), ":0\r\n")
+			conn.SelectDB(2)
+			assertReply(t, loaded.Exec(conn, utils.ToCmdLine("HGET", "h", "f")), "$1\r\nv\r\n")
+			assertReply(t, loaded.Exec(conn, utils.ToCmdLine("LLEN", "l")), ":1\r\n")
+			loaded.Close()
+		}
+	}
+}
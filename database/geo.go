@@ -0,0 +1,502 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/geohash"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// GEO 命令
+// 与 redis 相同，位置保存在有序集合中，分数是经纬度编码成的 52 位 geohash，
+// 搜索时把范围换算成 geohash 格子对应的分数区间在跳表中查找，再按照实际的距离过滤。
+// GEO 的 key 就是普通的 zset，也可以使用 ZRANGE、ZREM 等命令
+
+// geoUnits 距离单位换算成米
+var geoUnits = map[string]float64{
+	"m":  1,
+	"km": 1000,
+	"ft": 0.3048,
+	"mi": 1609.34,
+}
+
+func parseGeoUnit(arg []byte) (float64, protocol.ErrorReply) {
+	unit, ok := geoUnits[strings.ToLower(string(arg))]
+	if !ok {
+		return 0, protocol.MakeErrReply("ERR unsupported unit provided. please use M, KM, FT, MI")
+	}
+	return unit, nil
+}
+
+// parseLngLat 解析经纬度，超出 geohash 的范围时返回错误
+func parseLngLat(lngArg, latArg []byte) (float64, float64, protocol.ErrorReply) {
+	lng, err1 := strconv.ParseFloat(string(lngArg), 64)
+	lat, err2 := strconv.ParseFloat(string(latArg), 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, protocol.MakeErrReply("ERR value is not a valid float")
+	}
+	if !geohash.Valid(lng, lat) {
+		return 0, 0, protocol.MakeErrReply("ERR invalid longitude,latitude pair " +
+			strconv.FormatFloat(lng, 'f', 6, 64) + "," + strconv.FormatFloat(lat, 'f', 6, 64))
+	}
+	return lng, lat, nil
+}
+
+// formatGeoDistance 与 redis 相同，距离保留 4 位小数
+func formatGeoDistance(dist float64) redis.Reply {
+	return protocol.MakeBulkReply([]byte(strconv.FormatFloat(dist, 'f', 4, 64)))
+}
+
+// parseGeoAddFlags 解析 GEOADD 的选项，返回第一个经度参数的下标
+func parseGeoAddFlags(args [][]byte) (nx, xx, ch bool, i int) {
+	for i = 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "nx":
+			nx = true
+		case "xx":
+			xx = true
+		case "ch":
+			ch = true
+		default:
+			return
+		}
+	}
+	return
+}
+
+// GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
+func execGeoAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	nx, xx, ch, i := parseGeoAddFlags(args)
+	if nx && xx {
+		return protocol.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	rest := args[i:]
+	if len(rest) == 0 || len(rest)%3 != 0 {
+		return protocol.MakeErrReply("ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... ")
+	}
+	elements := make([]*sortedset.Element, 0, len(rest)/3)
+	for j := 0; j < len(rest); j += 3 {
+		lng, lat, errReply := parseLngLat(rest[j], rest[j+1])
+		if errReply != nil {
+			return errReply
+		}
+		elements = append(elements, &sortedset.Element{
+			Member: string(rest[j+2]),
+			Score:  float64(geohash.Encode(lng, lat)),
+		})
+	}
+
+	var zset *sortedset.SortedSet
+	var errReply protocol.ErrorReply
+	if xx {
+		// XX 只更新已有的成员，key 不存在时不创建
+		zset, errReply = db.getAsSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if zset == nil {
+			return protocol.MakeIntReply(0)
+		}
+	} else {
+		zset, _, errReply = db.getOrInitSortedSet(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
+	count := 0
+	for _, e := range elements {
+		old, exists := zset.Get(e.Member)
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+		if exists && old.Score == e.Score {
+			continue
+		}
+		zset.Add(e.Member, e.Score)
+		if !exists || ch {
+			count++
+		}
+	}
+	db.addAof(utils.ToCmdLine3("geoadd", args...))
+	return protocol.MakeIntReply(int64(count))
+}
+
+func undoGeoAdd(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	_, _, _, i := parseGeoAddFlags(args)
+	var members []string
+	for j := i + 2; j < len(args); j += 3 {
+		members = append(members, string(args[j]))
+	}
+	return rollbackZSetFields(db, key, members...)
+}
+
+// GEOPOS key [member ...]
+func execGeoPos(db *DB, args [][]byte) redis.Reply {
+	zset, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		var element *sortedset.Element
+		exists := false
+		if zset != nil {
+			element, exists = zset.Get(string(member))
+		}
+		if !exists {
+			result[i] = protocol.MakeNullMultiBulkReply()
+			continue
+		}
+		lng, lat := geohash.Decode(uint64(element.Score))
+		result[i] = protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeDoubleReply(lng),
+			protocol.MakeDoubleReply(lat),
+		})
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// GEODIST key member1 member2 [M|KM|FT|MI]
+func execGeoDist(db *DB, args [][]byte) redis.Reply {
+	unit := 1.0
+	if len(args) == 4 {
+		var errReply protocol.ErrorReply
+		unit, errReply = parseGeoUnit(args[3])
+		if errReply != nil {
+			return errReply
+		}
+	} else if len(args) > 4 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	zset, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	e1, ok1 := zset.Get(string(args[1]))
+	e2, ok2 := zset.Get(string(args[2]))
+	if !ok1 || !ok2 {
+		return protocol.MakeNullBulkReply()
+	}
+	lng1, lat1 := geohash.Decode(uint64(e1.Score))
+	lng2, lat2 := geohash.Decode(uint64(e2.Score))
+	return formatGeoDistance(geohash.Distance(lng1, lat1, lng2, lat2) / unit)
+}
+
+// geoSearchArgs 是 GEOSEARCH/GEOSEARCHSTORE 的参数
+type geoSearchArgs struct {
+	fromMember []byte
+	fromLngLat bool
+	lng, lat   float64
+
+	byRadius bool
+	byBox    bool
+	radius   float64 // 米
+	width    float64
+	height   float64
+	unit     float64
+
+	sort  int // 0 不排序，1 升序，-1 降序
+	count int // 0 不限制
+	any   bool
+
+	withCoord bool
+	withDist  bool
+	withHash  bool
+	storeDist bool
+}
+
+func parseGeoSearchArgs(cmdName string, args [][]byte, store bool) (*geoSearchArgs, protocol.ErrorReply) {
+	opts := &geoSearchArgs{}
+	parseFloat := func(arg []byte, msg string) (float64, protocol.ErrorReply) {
+		v, err := strconv.ParseFloat(string(arg), 64)
+		if err != nil || math.IsNaN(v) {
+			return 0, protocol.MakeErrReply(msg)
+		}
+		return v, nil
+	}
+	for i := 0; i < len(args); i++ {
+		remain := len(args) - i - 1
+		var errReply protocol.ErrorReply
+		switch arg := strings.ToLower(string(args[i])); {
+		case arg == "frommember" && remain >= 1:
+			if opts.fromMember != nil || opts.fromLngLat {
+				return nil, protocol.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+			}
+			opts.fromMember = args[i+1]
+			i++
+		case arg == "fromlonlat" && remain >= 2:
+			if opts.fromMember != nil || opts.fromLngLat {
+				return nil, protocol.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+			}
+			opts.lng, opts.lat, errReply = parseLngLat(args[i+1], args[i+2])
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.fromLngLat = true
+			i += 2
+		case arg == "byradius" && remain >= 2:
+			if opts.byRadius || opts.byBox {
+				return nil, protocol.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+			}
+			if opts.radius, errReply = parseFloat(args[i+1], "ERR need numeric radius"); errReply != nil {
+				return nil, errReply
+			}
+			if opts.radius < 0 {
+				return nil, protocol.MakeErrReply("ERR radius cannot be negative")
+			}
+			if opts.unit, errReply = parseGeoUnit(args[i+2]); errReply != nil {
+				return nil, errReply
+			}
+			opts.radius *= opts.unit
+			opts.byRadius = true
+			i += 2
+		case arg == "bybox" && remain >= 3:
+			if opts.byRadius || opts.byBox {
+				return nil, protocol.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+			}
+			if opts.width, errReply = parseFloat(args[i+1], "ERR value is not a valid float"); errReply != nil {
+				return nil, errReply
+			}
+			if opts.height, errReply = parseFloat(args[i+2], "ERR value is not a valid float"); errReply != nil {
+				return nil, errReply
+			}
+			if opts.width < 0 || opts.height < 0 {
+				return nil, protocol.MakeErrReply("ERR height or width cannot be negative")
+			}
+			if opts.unit, errReply = parseGeoUnit(args[i+3]); errReply != nil {
+				return nil, errReply
+			}
+			opts.width *= opts.unit
+			opts.height *= opts.unit
+			opts.byBox = true
+			i += 3
+		case arg == "asc":
+			opts.sort = 1
+		case arg == "desc":
+			opts.sort = -1
+		case arg == "count" && remain >= 1:
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count <= 0 {
+				return nil, protocol.MakeErrReply("ERR COUNT must be > 0")
+			}
+			opts.count = count
+			i++
+		case arg == "any":
+			opts.any = true
+		case arg == "withcoord" && !store:
+			opts.withCoord = true
+		case arg == "withdist" && !store:
+			opts.withDist = true
+		case arg == "withhash" && !store:
+			opts.withHash = true
+		case arg == "storedist" && store:
+			opts.storeDist = true
+		default:
+			return nil, protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+	if opts.fromMember == nil && !opts.fromLngLat {
+		return nil, protocol.MakeErrReply("ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for " + cmdName)
+	}
+	if !opts.byRadius && !opts.byBox {
+		return nil, protocol.MakeErrReply("ERR exactly one of BYRADIUS and BYBOX can be specified for " + cmdName)
+	}
+	if opts.any && opts.count == 0 {
+		return nil, protocol.MakeErrReply("ERR the ANY argument requires COUNT argument")
+	}
+	// 与 redis 相同，只有 COUNT 时返回最近的 count 个
+	if opts.count > 0 && !opts.any && opts.sort == 0 {
+		opts.sort = 1
+	}
+	return opts, nil
+}
+
+// geoPoint 是搜索到的位置
+type geoPoint struct {
+	member string
+	hash   uint64
+	dist   float64 // 米
+	lng    float64
+	lat    float64
+}
+
+// geoSearch 在 zset 中查找范围内的位置，FROMMEMBER 的成员不存在时返回错误
+func geoSearch(zset *sortedset.SortedSet, opts *geoSearchArgs) ([]*geoPoint, protocol.ErrorReply) {
+	lng, lat := opts.lng, opts.lat
+	if opts.fromMember != nil {
+		element, ok := zset.Get(string(opts.fromMember))
+		if !ok {
+			return nil, protocol.MakeErrReply("ERR could not decode requested zset member")
+		}
+		lng, lat = geohash.Decode(uint64(element.Score))
+	}
+	var ranges []geohash.Range
+	if opts.byRadius {
+		ranges = geohash.Cover(lng, lat, opts.radius, opts.radius)
+	} else {
+		ranges = geohash.Cover(lng, lat, opts.width/2, opts.height/2)
+	}
+
+	points := make([]*geoPoint, 0)
+	for _, r := range ranges {
+		min := sortedset.NewScoreBorder(float64(r.Min), false)
+		max := sortedset.NewScoreBorder(float64(r.Max), true)
+		zset.ForEach(min, max, 0, -1, false, func(element *sortedset.Element) bool {
+			hash := uint64(element.Score)
+			pointLng, pointLat := geohash.Decode(hash)
+			var dist float64
+			if opts.byRadius {
+				dist = geohash.Distance(lng, lat, pointLng, pointLat)
+				if dist > opts.radius {
+					return true
+				}
+			} else {
+				var inBox bool
+				dist, inBox = geohash.InBox(lng, lat, opts.width, opts.height, pointLng, pointLat)
+				if !inBox {
+					return true
+				}
+			}
+			points = append(points, &geoPoint{
+				member: element.Member,
+				hash:   hash,
+				dist:   dist,
+				lng:    pointLng,
+				lat:    pointLat,
+			})
+			// ANY 找到足够的位置后立即停止
+			return !opts.any || len(points) < opts.count
+		})
+		if opts.any && len(points) >= opts.count {
+			break
+		}
+	}
+	if opts.sort != 0 {
+		sort.SliceStable(points, func(i, j int) bool {
+			if opts.sort > 0 {
+				return points[i].dist < points[j].dist
+			}
+			return points[i].dist > points[j].dist
+		})
+	}
+	if opts.count > 0 && len(points) > opts.count {
+		points = points[:opts.count]
+	}
+	return points, nil
+}
+
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT longitude latitude BYRADIUS radius unit|BYBOX width height unit
+// [ASC|DESC] [COUNT count [ANY]] [WITHCOORD] [WITHDIST] [WITHHASH]
+func execGeoSearch(db *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseGeoSearchArgs("geosearch", args[1:], false)
+	if errReply != nil {
+		return errReply
+	}
+	zset, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if zset == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	points, errReply := geoSearch(zset, opts)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(points))
+	for i, p := range points {
+		if !opts.withDist && !opts.withHash && !opts.withCoord {
+			result[i] = protocol.MakeBulkReply([]byte(p.member))
+			continue
+		}
+		item := []redis.Reply{protocol.MakeBulkReply([]byte(p.member))}
+		if opts.withDist {
+			item = append(item, formatGeoDistance(p.dist/opts.unit))
+		}
+		if opts.withHash {
+			item = append(item, protocol.MakeIntReply(int64(p.hash)))
+		}
+		if opts.withCoord {
+			item = append(item, protocol.MakeMultiRawReply([]redis.Reply{
+				protocol.MakeDoubleReply(p.lng),
+				protocol.MakeDoubleReply(p.lat),
+			}))
+		}
+		result[i] = protocol.MakeMultiRawReply(item)
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+// GEOSEARCHSTORE destination source FROMMEMBER ...|FROMLONLAT ... BYRADIUS ...|BYBOX ...
+// [ASC|DESC] [COUNT count [ANY]] [STOREDIST]
+// 结果保存为 zset，分数是 geohash，使用 STOREDIST 时是以搜索单位表示的距离
+func execGeoSearchStore(db *DB, args [][]byte) redis.Reply {
+	dest := string(args[0])
+	opts, errReply := parseGeoSearchArgs("geosearchstore", args[2:], true)
+	if errReply != nil {
+		return errReply
+	}
+	zset, errReply := db.getAsSortedSet(string(args[1]))
+	if errReply != nil {
+		return errReply
+	}
+	var points []*geoPoint
+	if zset != nil {
+		points, errReply = geoSearch(zset, opts)
+		if errReply != nil {
+			return errReply
+		}
+	}
+	if len(points) == 0 {
+		if db.Removes(dest) > 0 {
+			db.notify(notifyGeneric, "del", dest)
+		}
+	} else {
+		result := db.makeSortedSet()
+		for _, p := range points {
+			if opts.storeDist {
+				result.Add(p.member, p.dist/opts.unit)
+			} else {
+				result.Add(p.member, float64(p.hash))
+			}
+		}
+		db.Remove(dest) // clean ttl
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
+	}
+	db.addAof(utils.ToCmdLine3("geosearchstore", args...))
+	return protocol.MakeIntReply(int64(len(points)))
+}
+
+func prepareGeoSearchStore(args [][]byte) ([]string, []string) {
+	return []string{string(args[0])}, []string{string(args[1])}
+}
+
+func init() {
+	registerCommand("GeoAdd", execGeoAdd, writeFirstKey, undoGeoAdd, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("GeoPos", execGeoPos, readFirstKey, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("GeoDist", execGeoDist, readFirstKey, nil, -4, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("GeoSearch", execGeoSearch, readFirstKey, nil, -7, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("GeoSearchStore", execGeoSearchStore, prepareGeoSearchStore, rollbackFirstKey, -8, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 2, 1)
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 期望结果来自 redis 文档中的例子
+func TestGeo(t *testing.T) {
+	db := makeTestDB()
+	assertReply(t, execTestCmd(db, "GEOADD", "Sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "GEODIST", "Sicily", "Palermo", "Catania"), "$11\r\n166274.1516\r\n")
+	assertReply(t, execTestCmd(db, "GEODIST", "Sicily", "Palermo", "Catania", "km"), "$8\r\n166.2742\r\n")
+	assertReply(t, execTestCmd(db, "GEODIST", "Sicily", "Palermo", "Catania", "MI"), "$8\r\n103.3182\r\n")
+	assertReply(t, execTestCmd(db, "GEODIST", "Sicily", "Foo", "Bar"), "$-1\r\n")
+	assertReply(t, protocol.ToRESP2(execTestCmd(db, "GEOPOS", "Sicily", "Palermo", "NonExisting")),
+		"*2\r\n*2\r\n$18\r\n13.361389338970184\r\n$16\r\n38.1155563954963\r\n*-1\r\n")
+	// 位置保存在普通的 zset 中
+	assertReply(t, execTestCmd(db, "ZSCORE", "Sicily", "Palermo"), "$16\r\n3479099956230698\r\n")
+
+	assertReply(t, execTestCmd(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "ASC"),
+		bulks("Catania", "Palermo"))
+	assertReply(t, execTestCmd(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km"),
+		bulks("Catania"))
+	assertReply(t, execTestCmd(db, "GEOSEARCH", "Sicily", "FROMMEMBER", "Palermo", "BYRADIUS", "200", "km", "DESC", "WITHDIST", "WITHHASH"),
+		"*2\r\n*3\r\n$7\r\nCatania\r\n$8\r\n166.2742\r\n:3479447370796909\r\n*3\r\n$7\r\nPalermo\r\n$6\r\n0.0000\r\n:3479099956230698\r\n")
+
+	execTestCmd(db, "GEOADD", "Sicily", "12.758489", "38.788135", "edge1", "17.241510", "38.788135", "edge2")
+	assertReply(t, execTestCmd(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "WITHDIST"),
+		"*4\r\n*2\r\n$7\r\nCatania\r\n$7\r\n56.4413\r\n*2\r\n$7\r\nPalermo\r\n$8\r\n190.4424\r\n"+
+			"*2\r\n$5\r\nedge2\r\n$8\r\n279.7403\r\n*2\r\n$5\r\nedge1\r\n$8\r\n279.7405\r\n")
+	assertReply(t, execTestCmd(db, "GEOSEARCH", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "200", "km", "COUNT", "1"),
+		bulks("Catania"))
+	assertReply(t, execTestCmd(db, "GEOSEARCH", "nokey", "FROMMEMBER", "x", "BYRADIUS", "1", "m"), "*0\r\n")
+
+	// NX/XX/CH
+	assertReply(t, execTestCmd(db, "GEOADD", "Sicily", "NX", "13", "38", "Palermo", "13", "38", "new"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "GEOADD", "Sicily", "XX", "CH", "13", "38", "Palermo", "13", "38", "other"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "GEOADD", "missing", "XX", "13", "38", "Palermo"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "missing"), ":0\r\n")
+
+	assertReply(t, execTestCmd(db, "GEOSEARCHSTORE", "dst", "Sicily", "FROMLONLAT", "15", "37", "BYBOX", "400", "400", "km", "ASC", "COUNT", "3"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "ZCARD", "dst"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "ZSCORE", "dst", "Catania"), "$16\r\n3479447370796909\r\n")
+	assertReply(t, execTestCmd(db, "GEOSEARCHSTORE", "dst", "Sicily", "FROMLONLAT", "15", "37", "BYRADIUS", "100", "km", "STOREDIST"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "ZSCORE", "dst", "Catania"), "$16\r\n56.4412578701582\r\n")
+	assertReply(t, execTestCmd(db, "GEOSEARCHSTORE", "dst", "Sicily", "FROMLONLAT", "0", "0", "BYRADIUS", "1", "km"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "dst"), ":0\r\n")
+
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"GEOADD", "k", "200", "100", "m"}, "-ERR invalid longitude,latitude pair 200.000000,100.000000\r\n"},
+		{[]string{"GEOADD", "k", "NX", "XX", "1", "1", "m"}, "-ERR XX and NX options at the same time are not compatible\r\n"},
+		{[]string{"GEOADD", "k", "1", "1", "m", "1"}, "-ERR syntax error. Try GEOADD key [x1] [y1] [name1] [x2] [y2] [name2] ... \r\n"},
+		{[]string{"GEODIST", "Sicily", "Palermo", "Catania", "yd"}, "-ERR unsupported unit provided. please use M, KM, FT, MI\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "BYRADIUS", "1", "m", "ASC", "WITHDIST"}, "-ERR exactly one of FROMMEMBER or FROMLONLAT can be specified for geosearch\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "1", "1", "ASC", "WITHDIST"}, "-ERR exactly one of BYRADIUS and BYBOX can be specified for geosearch\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMMEMBER", "Foo", "BYRADIUS", "1", "m"}, "-ERR could not decode requested zset member\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "1", "1", "BYRADIUS", "-1", "m"}, "-ERR radius cannot be negative\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "1", "1", "BYRADIUS", "1", "m", "ANY"}, "-ERR the ANY argument requires COUNT argument\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "1", "1", "BYRADIUS", "1", "m", "COUNT", "0"}, "-ERR COUNT must be > 0\r\n"},
+		{[]string{"GEOSEARCH", "Sicily", "FROMLONLAT", "1", "1", "BYRADIUS", "1", "m", "STOREDIST"}, "-ERR syntax error\r\n"},
+		{[]string{"GEOSEARCHSTORE", "d", "Sicily", "FROMLONLAT", "1", "1", "BYRADIUS", "1", "m", "WITHDIST"}, "-ERR syntax error\r\n"},
+	} {
+		assertReply(t, execTestCmd(db, tt.args...), tt.expected)
+	}
+}
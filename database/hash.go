@@ -27,6 +27,21 @@ func (db *DB) getAsDict(key string) (dict.Dict, protocol.ErrorReply) {
 	return d, nil
 }
 
+// emptyHash 只读的空哈希，不存在的 key 按空哈希处理，读命令不需要再单独判断 nil
+var emptyHash dict.Dict = dict.MakeSimple()
+
+// getAsReadableDict 与 getAsDict 相同，但 key 不存在时返回 emptyHash，只能用于读操作
+func (db *DB) getAsReadableDict(key string) (dict.Dict, protocol.ErrorReply) {
+	d, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return nil, errReply
+	}
+	if d == nil {
+		return emptyHash, nil
+	}
+	return d, nil
+}
+
 func (db *DB) getOrInitDict(key string) (dict.Dict, bool, protocol.ErrorReply) {
 	d, errReply := db.getAsDict(key)
 	if errReply != nil {
@@ -35,7 +50,7 @@ func (db *DB) getOrInitDict(key string) (dict.Dict, bool, protocol.ErrorReply) {
 	inited := false
 	if d == nil {
 		// 这里不用并发map，是为了降低锁的消耗，在进行db相关操作的时候，db层已经加过锁了
-		d = dict.MakeSimple()
+		d = db.makeHash()
 		db.PutEntity(key, &database.DataEntity{
 			Data: d,
 		})
@@ -73,7 +88,9 @@ func execHSetNX(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	result := d.PutIfAbsent(field, value)
-	db.addAof(utils.ToCmdLine3("hset", args...))
+	if result > 0 {
+		db.addAof(utils.ToCmdLine3("hsetnx", args...))
+	}
 	return protocol.MakeIntReply(int64(result))
 }
 
@@ -82,11 +99,9 @@ func execHGet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])   // Hash 键
 	field := string(args[1]) // Hash 字段名
 
-	d, errReply := db.getAsDict(key)
-
+	d, errReply := db.getAsReadableDict(key)
 	if errReply != nil {
 		return errReply
-
 	}
 	result, ok := d.Get(field)
 	if !ok {
@@ -127,17 +142,18 @@ func execHDel(db *DB, args [][]byte) redis.Reply {
 	if errReply != nil {
 		return errReply
 	}
+	if d == nil {
+		return protocol.MakeIntReply(0)
+	}
 	deleted := 0
 	for _, v := range fields {
 		_, res := d.Remove(v)
 		deleted += res
 	}
-	if d.Len() == 0 {
-		db.Remove(key)
-	}
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine3("hdel", args...))
 	}
+	db.deleteIfEmpty(key, d)
 	return protocol.MakeIntReply(int64(deleted))
 }
 
@@ -167,7 +183,7 @@ func execHLen(db *DB, args [][]byte) redis.Reply {
 func execHStrlen(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	filed := string(args[1])
-	d, errReply := db.getAsDict(key)
+	d, errReply := db.getAsReadableDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -249,11 +265,14 @@ func execHMGet(db *DB, args [][]byte) redis.Reply {
 
 func execHKeys(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	d, errReply := db.getAsDict(key)
+	d, errReply := db.getAsReadableDict(key)
 	if errReply != nil {
 		return errReply
 	}
-	fileds := make([][]byte, d.Len()-1)
+	if errReply := db.checkReplySize(d.Len(), "HSCAN"); errReply != nil {
+		return errReply
+	}
+	fileds := make([][]byte, d.Len())
 	i := 0
 	d.ForEach(func(key string, value interface{}) bool {
 		fileds[i] = []byte(key)
@@ -265,11 +284,14 @@ func execHKeys(db *DB, args [][]byte) redis.Reply {
 
 func execHVals(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	d, errReply := db.getAsDict(key)
+	d, errReply := db.getAsReadableDict(key)
 	if errReply != nil {
 		return errReply
 	}
-	values := make([][]byte, d.Len()-1)
+	if errReply := db.checkReplySize(d.Len(), "HSCAN"); errReply != nil {
+		return errReply
+	}
+	values := make([][]byte, d.Len())
 	i := 0
 	d.ForEach(func(key string, value interface{}) bool {
 		values[i] = value.([]byte)
@@ -295,23 +317,23 @@ func execHIncrBy(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	d, errReply := db.getAsDict(key)
+	d, _, errReply := db.getOrInitDict(key)
 	if errReply != nil {
 		return errReply
 	}
-	value, exists := d.Get(field)
-	if !exists {
-		return protocol.MakeErrReply("ERR no such key")
-	}
-	val, err := strconv.ParseInt(string(value.([]byte)), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	// 不存在的字段按 0 处理
+	var val int64
+	if value, exists := d.Get(field); exists {
+		val, err = strconv.ParseInt(string(value.([]byte)), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR hash value is not an integer")
+		}
 	}
 	val += delta
 	bytes := []byte(strconv.FormatInt(val, 10))
 	d.Put(field, bytes)
 	db.addAof(utils.ToCmdLine3("hincrby", args...))
-	return protocol.MakeBulkReply(bytes)
+	return protocol.MakeIntReply(val)
 }
 
 // 从存储在key的哈希值中返回一个随机字段（或字段值）
@@ -339,10 +361,18 @@ func execHRandField(db *DB, args [][]byte) redis.Reply {
 		}
 		count = int(count64)
 	}
-	d, errReply := db.getAsDict(key)
+	d, errReply := db.getAsReadableDict(key)
 	if errReply != nil {
 		return errReply
 	}
+	if len(args) == 1 {
+		// 不带 count 时返回单个字段，哈希为空时返回 nil
+		fields := d.RandomDistinctKeys(1)
+		if len(fields) == 0 {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(fields[0]))
+	}
 
 	if count > 0 {
 		fields := d.RandomDistinctKeys(count)
@@ -354,16 +384,8 @@ func execHRandField(db *DB, args [][]byte) redis.Reply {
 			}
 			return protocol.MakeMultiBulkReply(results)
 		} else {
-			results := make([][]byte, size*2)
-			for i, field := range fields {
-				value, ok := d.Get(field)
-				if !ok {
-					return protocol.MakeErrReply("ERR no such key")
-				}
-				results[i*2] = []byte(field)
-				results[i*2+1] = value.([]byte)
-			}
-			return protocol.MakeMultiBulkReply(results)
+			// 字段不重复，RESP3 下返回 map
+			return protocol.MakeMapReply(makeFieldValueReplies(d, fields))
 		}
 	} else if count < 0 {
 		fields := d.RandomKeys(-count)
@@ -375,33 +397,36 @@ func execHRandField(db *DB, args [][]byte) redis.Reply {
 			}
 			return protocol.MakeMultiBulkReply(results)
 		} else {
-			results := make([][]byte, size*2)
-			for i, field := range fields {
-				value, ok := d.Get(field)
-				if !ok {
-					return protocol.MakeErrReply("ERR no such key")
-				}
-				results[i*2] = []byte(field)
-				results[i*2+1] = value.([]byte)
-			}
-			return protocol.MakeMultiBulkReply(results)
+			// 字段可能重复，RESP3 下返回 [field, value] 数组
+			return protocol.MakePairsReply(makeFieldValueReplies(d, fields))
 		}
 
 	}
 	// count == 0直接走这里，什么都不返回
-	return protocol.MakeMultiBulkReply(nil)
+	return protocol.MakeEmptyMultiBulkReply()
+}
+
+// makeFieldValueReplies 返回 field1, value1, field2, value2 ...
+func makeFieldValueReplies(d dict.Dict, fields []string) []redis.Reply {
+	results := make([]redis.Reply, 0, len(fields)*2)
+	for _, field := range fields {
+		value, _ := d.Get(field)
+		bytes, _ := value.([]byte)
+		results = append(results, protocol.MakeBulkReply([]byte(field)), protocol.MakeBulkReply(bytes))
+	}
+	return results
 }
 
 func execHGetAll(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	d, errReply := db.getAsDict(key)
+	d, errReply := db.getAsReadableDict(key)
 	if errReply != nil {
 		return errReply
 	}
-	if d == nil {
-		return protocol.MakeMultiBulkReply(nil)
-	}
 	size := d.Len()
+	if errReply := db.checkReplySize(size*2, "HSCAN"); errReply != nil {
+		return errReply
+	}
 	results := make([][]byte, size*2)
 	i := 0
 	d.ForEach(func(key string, value interface{}) bool {
@@ -425,6 +450,9 @@ func execHScan(db *DB, args [][]byte) redis.Reply {
 	if len(args) >= 2 {
 		for i := 2; i < len(args); i++ {
 			arg := strings.ToLower(string(args[i]))
+			if i+1 >= len(args) {
+				return &protocol.SyntaxErrReply{}
+			}
 			if arg == "count" {
 				count0, err := strconv.Atoi(string(args[i+1]))
 				if err != nil {
@@ -441,7 +469,7 @@ func execHScan(db *DB, args [][]byte) redis.Reply {
 		}
 	}
 	key := string(args[0])
-	d, errReply := db.getAsDict(key)
+	d, errReply := db.getAsReadableDict(key)
 	if errReply != nil {
 		return errReply
 	}
@@ -452,7 +480,7 @@ func execHScan(db *DB, args [][]byte) redis.Reply {
 	//用于下一次请求时传入，以继续扫描。
 	keysReply, nextCursor := d.DictScan(cursor, count, pattern)
 	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+		return protocol.MakeErrReply("ERR invalid cursor")
 	}
 
 	result := make([]redis.Reply, 2)
@@ -481,8 +509,6 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 	registerCommand("HMGet", execHMGet, readFirstKey, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("HGet", execHGet, readFirstKey, nil, -3, flagReadOnly).
-		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("HKeys", execHKeys, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
 	registerCommand("HVals", execHVals, readFirstKey, nil, 2, flagReadOnly).
@@ -0,0 +1,121 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestHashMissingKey(t *testing.T) {
+	db := makeTestDB()
+	cases := []struct {
+		cmd      []string
+		expected string
+	}{
+		{[]string{"HGET", "missing", "f"}, "$-1\r\n"},
+		{[]string{"HEXISTS", "missing", "f"}, ":0\r\n"},
+		{[]string{"HDEL", "missing", "f"}, ":0\r\n"},
+		{[]string{"HLEN", "missing"}, ":0\r\n"},
+		{[]string{"HSTRLEN", "missing", "f"}, ":0\r\n"},
+		{[]string{"HMGET", "missing", "a", "b"}, "*2\r\n$-1\r\n$-1\r\n"},
+		{[]string{"HKEYS", "missing"}, "*0\r\n"},
+		{[]string{"HVALS", "missing"}, "*0\r\n"},
+		{[]string{"HGETALL", "missing"}, "*0\r\n"},
+		{[]string{"HRANDFIELD", "missing"}, "$-1\r\n"},
+		{[]string{"HRANDFIELD", "missing", "3"}, "*0\r\n"},
+		{[]string{"HRANDFIELD", "missing", "-3"}, "*0\r\n"},
+		{[]string{"HRANDFIELD", "missing", "3", "WITHVALUES"}, "*0\r\n"},
+		{[]string{"HSCAN", "missing", "0"}, "*2\r\n$1\r\n0\r\n*0\r\n"},
+	}
+	for _, c := range cases {
+		assertReply(t, execTestCmd(db, c.cmd...), c.expected)
+	}
+	// read commands must not create the key
+	assertReply(t, execTestCmd(db, "EXISTS", "missing"), ":0\r\n")
+
+	// write commands create the hash
+	assertReply(t, execTestCmd(db, "HINCRBY", "counter", "f", "5"), ":5\r\n")
+	assertReply(t, execTestCmd(db, "HINCRBY", "counter", "f", "-2"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "HSETNX", "nx", "f", "v"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "HSETNX", "nx", "f", "v2"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "HGET", "nx", "f"), "$1\r\nv\r\n")
+}
+
+func TestHashExistingKey(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "HMSET", "h", "a", "1", "b", "22")
+	assertReply(t, execTestCmd(db, "HSTRLEN", "h", "b"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "HLEN", "h"), ":2\r\n")
+	if r := execTestCmd(db, "HKEYS", "h"); len(r.ToBytes()) == 0 || r.ToBytes()[1] != '2' {
+		t.Errorf("expected 2 keys, actually %q", r.ToBytes())
+	}
+	if r := execTestCmd(db, "HVALS", "h"); len(r.ToBytes()) == 0 || r.ToBytes()[1] != '2' {
+		t.Errorf("expected 2 values, actually %q", r.ToBytes())
+	}
+	assertReply(t, execTestCmd(db, "HINCRBY", "h", "a", "1"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "HSET", "str", "a", "x"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "HINCRBY", "str", "a", "1"), "-ERR hash value is not an integer\r\n")
+	assertReply(t, execTestCmd(db, "HDEL", "h", "a", "b"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "h"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "HSCAN", "h", "0", "COUNT"), "-ERR syntax error\r\n")
+}
+
+func TestMaxReplyElements(t *testing.T) {
+	db := makeTestDB()
+	db.cfg = &config.ServerProperties{MaxReplyElements: 4}
+	execTestCmd(db, "HMSET", "h", "a", "1", "b", "2", "c", "3")
+	execTestCmd(db, "SADD", "s", "a", "b", "c", "d", "e")
+	execTestCmd(db, "RPUSH", "l", "a", "b", "c", "d", "e")
+
+	assertReply(t, execTestCmd(db, "HGETALL", "h"),
+		"-ERR reply has 6 elements which exceeds max-reply-elements 4, use HSCAN instead\r\n")
+	if reply := execTestCmd(db, "HKEYS", "h"); string(reply.ToBytes())[:4] != "*3\r\n" {
+		t.Errorf("expected 3 fields, actually %q", reply.ToBytes())
+	}
+	assertReply(t, execTestCmd(db, "SMEMBERS", "s"),
+		"-ERR reply has 5 elements which exceeds max-reply-elements 4, use SSCAN instead\r\n")
+	assertReply(t, execTestCmd(db, "LRANGE", "l", "0", "-1"),
+		"-ERR reply has 5 elements which exceeds max-reply-elements 4, use LRANGE with a smaller range instead\r\n")
+	assertReply(t, execTestCmd(db, "LRANGE", "l", "1", "-1"), "*4\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n$1\r\ne\r\n")
+
+	db.cfg.MaxReplyElements = 0
+	assertReply(t, execTestCmd(db, "SCARD", "s"), ":5\r\n")
+	if reply := execTestCmd(db, "SMEMBERS", "s"); string(reply.ToBytes())[:4] != "*5\r\n" {
+		t.Errorf("expected 5 members, actually %q", reply.ToBytes())
+	}
+}
+
+func TestHScanPages(t *testing.T) {
+	db := makeTestDB()
+	for i := 0; i < 500; i++ {
+		execTestCmd(db, "HSET", "h", "f"+strconv.Itoa(i), "v")
+	}
+	// 大哈希按游标分页返回，遍历期间删除一部分字段，没有删除的字段都至少返回一次
+	seen := make(map[string]bool)
+	cursor, pages := "0", 0
+	for {
+		reply := execTestCmd(db, "HSCAN", "h", cursor, "COUNT", "20").(*protocol.MultiRawReply)
+		fields := reply.Replies[1].(*protocol.MultiBulkReply).Args
+		for i := 0; i < len(fields); i += 2 {
+			seen[string(fields[i])] = true
+		}
+		if pages < 20 {
+			execTestCmd(db, "HDEL", "h", "f"+strconv.Itoa(400+pages*5), "f"+strconv.Itoa(401+pages*5),
+				"f"+strconv.Itoa(402+pages*5), "f"+strconv.Itoa(403+pages*5), "f"+strconv.Itoa(404+pages*5))
+		}
+		pages++
+		if cursor = string(reply.Replies[0].(*protocol.BulkReply).Arg); cursor == "0" {
+			break
+		}
+	}
+	if pages < 10 {
+		t.Errorf("expected more pages, actually %d", pages)
+	}
+	for i := 0; i < 400; i++ {
+		if !seen["f"+strconv.Itoa(i)] {
+			t.Fatalf("missed f%d", i)
+		}
+	}
+}
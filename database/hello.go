@@ -0,0 +1,121 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// HELLO [protover [AUTH username password] [SETNAME clientname] [COMPRESS lz4|none]]
+// 协商连接使用的 RESP 版本，之后的回复按照新的版本编码。
+// COMPRESS lz4 是扩展选项，之后不短于 reply-compress-threshold 的 bulk string 压缩后发送(见 protocol.CompressedReply)，
+// 开启时回复中多一个 compress 字段，不认识这个选项的服务器返回语法错误，客户端据此判断服务器是否支持
+
+const defaultReplyCompressThreshold = 1024
+
+func (server *Server) replyCompressThreshold() int {
+	if server.cfg.ReplyCompressThreshold > 0 {
+		return server.cfg.ReplyCompressThreshold
+	}
+	return defaultReplyCompressThreshold
+}
+
+// validClientName 与 redis 相同，名字不能包含空格、换行等特殊字符
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func (server *Server) execHello(c redis.Connection, args [][]byte) redis.Reply {
+	version := c.GetProtocol()
+	if len(args) > 0 {
+		ver, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if ver != protocol.RESP2 && ver != protocol.RESP3 {
+			return protocol.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		version = int(ver)
+	}
+	var username, password, clientName string
+	auth, setName := false, false
+	compress := c.GetReplyCompression() > 0
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "auth" && i+2 < len(args):
+			auth = true
+			username = string(args[i+1])
+			password = string(args[i+2])
+			i += 2
+		case option == "setname" && i+1 < len(args):
+			setName = true
+			clientName = string(args[i+1])
+			if !validClientName(clientName) {
+				return protocol.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+			}
+			i++
+		case option == "compress" && i+1 < len(args):
+			switch strings.ToLower(string(args[i+1])) {
+			case "lz4":
+				compress = true
+			case "none":
+				compress = false
+			default:
+				return protocol.MakeErrReply("ERR unsupported compression '" + string(args[i+1]) + "'")
+			}
+			i++
+		default:
+			return protocol.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+		}
+	}
+	if !auth && !c.IsAuthenticated() {
+		return protocol.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and " +
+			"select the RESP protocol version at the same time")
+	}
+	// 认证失败时不修改连接的任何状态
+	if auth {
+		if errReply := server.authenticate(c, username, password); errReply != nil {
+			return errReply
+		}
+	}
+	if setName {
+		c.SetClientName(clientName)
+	}
+	c.SetProtocol(version)
+	if compress {
+		c.SetReplyCompression(server.replyCompressThreshold())
+	} else {
+		c.SetReplyCompression(0)
+	}
+
+	mode := "standalone"
+	if server.cfg.ClusterEnable {
+		mode = "cluster"
+	}
+	role := "master"
+	if server.isReplica() {
+		role = "replica"
+	}
+	fields := []redis.Reply{
+		protocol.MakeBulkReply([]byte("server")), protocol.MakeBulkReply([]byte("redis")),
+		protocol.MakeBulkReply([]byte("version")), protocol.MakeBulkReply([]byte(godisVersion)),
+		protocol.MakeBulkReply([]byte("proto")), protocol.MakeIntReply(int64(version)),
+		protocol.MakeBulkReply([]byte("id")), protocol.MakeIntReply(int64(c.GetID())),
+		protocol.MakeBulkReply([]byte("mode")), protocol.MakeBulkReply([]byte(mode)),
+		protocol.MakeBulkReply([]byte("role")), protocol.MakeBulkReply([]byte(role)),
+		protocol.MakeBulkReply([]byte("modules")), protocol.MakeEmptyMultiBulkReply(),
+	}
+	if compress {
+		fields = append(fields, protocol.MakeBulkReply([]byte("compress")), protocol.MakeBulkReply([]byte("lz4")))
+	}
+	return protocol.MakeMapReply(fields)
+}
@@ -0,0 +1,192 @@
+package database
+
+import (
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/datastruct/topk"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 热点 key 统计
+// 每次 key 访问以 1/hotkeys-sample-ratio 的概率采样(随机采样，避免固定间隔与访问模式重合)，
+// 用 space-saving 算法统计采样到的 key。
+// 滑动窗口分成 hotkeysBuckets 个时间片，每个时间片有独立的统计，查询时合并窗口内的时间片，
+// 过期的时间片在下次写入时重置，计数乘以采样比例作为估计值
+
+const (
+	hotkeysBuckets         = 10
+	defaultHotkeysCapacity = 128
+	defaultHotkeysWindow   = 60
+	defaultHotkeysCount    = 10
+)
+
+type hotKeyBucket struct {
+	epoch   int64 // 时间片编号，now / span
+	summary *topk.SpaceSaving
+}
+
+// hotKeyProfiler nil 表示不统计
+type hotKeyProfiler struct {
+	ratio    int64
+	capacity int
+	span     time.Duration
+	now      func() time.Time
+
+	mu      sync.Mutex
+	buckets [hotkeysBuckets]hotKeyBucket
+}
+
+func makeHotKeyProfiler(cfg *config.ServerProperties) *hotKeyProfiler {
+	if cfg.HotkeysSampleRatio <= 0 {
+		return nil
+	}
+	capacity := cfg.HotkeysCapacity
+	if capacity <= 0 {
+		capacity = defaultHotkeysCapacity
+	}
+	window := cfg.HotkeysWindow
+	if window <= 0 {
+		window = defaultHotkeysWindow
+	}
+	return &hotKeyProfiler{
+		ratio:    int64(cfg.HotkeysSampleRatio),
+		capacity: capacity,
+		span:     time.Duration(window) * time.Second / hotkeysBuckets,
+		now:      time.Now,
+	}
+}
+
+// hotKeyID 统计时把数据库编号和 key 拼在一起，编号中没有 ':'
+func hotKeyID(dbIndex int, key string) string {
+	return strconv.Itoa(dbIndex) + ":" + key
+}
+
+// record 记录一次命令访问的 key
+func (p *hotKeyProfiler) record(dbIndex int, keys ...[]string) {
+	if p == nil {
+		return
+	}
+	var sampled []string
+	for _, group := range keys {
+		for _, key := range group {
+			if p.ratio == 1 || rand.Int64N(p.ratio) == 0 {
+				sampled = append(sampled, key)
+			}
+		}
+	}
+	if len(sampled) == 0 {
+		return
+	}
+	epoch := p.now().UnixNano() / int64(p.span)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	bucket := &p.buckets[epoch%hotkeysBuckets]
+	if bucket.summary == nil || bucket.epoch != epoch {
+		bucket.epoch = epoch
+		bucket.summary = topk.New(p.capacity)
+	}
+	for _, key := range sampled {
+		bucket.summary.Add(hotKeyID(dbIndex, key), 1)
+	}
+}
+
+// top 合并窗口内的时间片，返回估计访问次数最多的 count 个 key
+func (p *hotKeyProfiler) top(count int) []database.HotKey {
+	if p == nil {
+		return nil
+	}
+	epoch := p.now().UnixNano() / int64(p.span)
+	merged := make(map[string]*topk.Item)
+	p.mu.Lock()
+	for i := range p.buckets {
+		bucket := &p.buckets[i]
+		if bucket.summary == nil || epoch-bucket.epoch >= hotkeysBuckets {
+			continue
+		}
+		for _, item := range bucket.summary.Items() {
+			m, ok := merged[item.Key]
+			if !ok {
+				m = &topk.Item{Key: item.Key}
+				merged[item.Key] = m
+			}
+			m.Count += item.Count
+			m.Error += item.Error
+		}
+	}
+	p.mu.Unlock()
+
+	result := make([]database.HotKey, 0, len(merged))
+	for id, item := range merged {
+		sep := strings.IndexByte(id, ':')
+		dbIndex, _ := strconv.Atoi(id[:sep])
+		result = append(result, database.HotKey{
+			DB:    dbIndex,
+			Key:   id[sep+1:],
+			Count: item.Count * p.ratio,
+			Error: item.Error * p.ratio,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		if result[i].DB != result[j].DB {
+			return result[i].DB < result[j].DB
+		}
+		return result[i].Key < result[j].Key
+	})
+	if count >= 0 && len(result) > count {
+		result = result[:count]
+	}
+	return result
+}
+
+func (p *hotKeyProfiler) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buckets = [hotkeysBuckets]hotKeyBucket{}
+}
+
+// HotKeys 返回窗口内访问最多的 count 个 key，用于 metrics 接口，没有开启统计时返回 nil
+func (server *Server) HotKeys(count int) []database.HotKey {
+	return server.hotkeys.top(count)
+}
+
+// execHotkeys HOTKEYS [COUNT count] | RESET
+func (server *Server) execHotkeys(args [][]byte) redis.Reply {
+	if server.hotkeys == nil {
+		return protocol.MakeErrReply("ERR hotkeys profiler is disabled, set hotkeys-sample-ratio to enable it")
+	}
+	count := defaultHotkeysCount
+	if len(args) == 1 && strings.EqualFold(string(args[0]), "reset") {
+		server.hotkeys.reset()
+		return protocol.MakeOkReply()
+	} else if len(args) == 2 && strings.EqualFold(string(args[0]), "count") {
+		n, err := strconv.Atoi(string(args[1]))
+		if err != nil || n <= 0 {
+			return protocol.MakeErrReply("ERR count should be greater than 0")
+		}
+		count = n
+	} else if len(args) != 0 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	hotKeys := server.hotkeys.top(count)
+	replies := make([]redis.Reply, len(hotKeys))
+	for i, hk := range hotKeys {
+		replies[i] = protocol.MakeMapReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte("key")), protocol.MakeBulkReply([]byte(hk.Key)),
+			protocol.MakeBulkReply([]byte("db")), protocol.MakeIntReply(int64(hk.DB)),
+			protocol.MakeBulkReply([]byte("count")), protocol.MakeIntReply(hk.Count),
+			protocol.MakeBulkReply([]byte("error")), protocol.MakeIntReply(hk.Error),
+		})
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
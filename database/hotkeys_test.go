@@ -0,0 +1,68 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestHotkeys(t *testing.T) {
+	cfg := &config.ServerProperties{Dir: t.TempDir(), HotkeysSampleRatio: 1, HotkeysCapacity: 128, HotkeysWindow: 10}
+	server := NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	now := time.Now()
+	server.hotkeys.now = func() time.Time { return now }
+	conn := connection.NewFakeConn()
+
+	for i := 0; i < 100; i++ {
+		server.Exec(conn, utils.ToCmdLine("GET", "hot"))
+		server.Exec(conn, utils.ToCmdLine("SET", "cold"+strconv.Itoa(i), "v"))
+	}
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	for i := 0; i < 40; i++ {
+		server.Exec(conn, utils.ToCmdLine("INCR", "counter"))
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("HOTKEYS", "COUNT", "2")),
+		"*2\r\n*8\r\n$3\r\nkey\r\n$3\r\nhot\r\n$2\r\ndb\r\n:0\r\n$5\r\ncount\r\n:100\r\n$5\r\nerror\r\n:0\r\n"+
+			"*8\r\n$3\r\nkey\r\n$7\r\ncounter\r\n$2\r\ndb\r\n:1\r\n$5\r\ncount\r\n:40\r\n$5\r\nerror\r\n:0\r\n")
+	if hotKeys := server.HotKeys(-1); len(hotKeys) != 102 {
+		t.Errorf("expected 102 tracked keys, got %d", len(hotKeys))
+	}
+
+	// 窗口内的新访问与旧的时间片合并，整个窗口过去之后清空
+	now = now.Add(5 * time.Second)
+	for i := 0; i < 20; i++ {
+		server.Exec(conn, utils.ToCmdLine("INCR", "counter"))
+	}
+	if hotKeys := server.HotKeys(1); len(hotKeys) != 1 || hotKeys[0].Key != "hot" || server.HotKeys(2)[1].Count != 60 {
+		t.Errorf("unexpected hot keys %+v", server.HotKeys(2))
+	}
+	now = now.Add(6 * time.Second)
+	if hotKeys := server.HotKeys(-1); len(hotKeys) != 1 || hotKeys[0].Key != "counter" || hotKeys[0].Count != 20 {
+		t.Errorf("unexpected hot keys %+v", hotKeys)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("HOTKEYS", "RESET")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("HOTKEYS")), "*0\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("HOTKEYS", "COUNT", "0")), "-ERR count should be greater than 0\r\n")
+
+	disabled := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir()})
+	defer disabled.Close()
+	assertReply(t, disabled.Exec(conn, utils.ToCmdLine("HOTKEYS")),
+		"-ERR hotkeys profiler is disabled, set hotkeys-sample-ratio to enable it\r\n")
+}
+
+func TestHotkeysSampling(t *testing.T) {
+	cfg := &config.ServerProperties{HotkeysSampleRatio: 4}
+	p := makeHotKeyProfiler(cfg)
+	for i := 0; i < 10000; i++ {
+		p.record(0, []string{"hot"}, []string{"cold" + strconv.Itoa(i)})
+	}
+	// 采样的计数乘以采样比例作为估计值
+	if hotKeys := p.top(1); len(hotKeys) != 1 || hotKeys[0].Key != "hot" || hotKeys[0].Count < 8000 || hotKeys[0].Count > 12000 {
+		t.Errorf("unexpected estimate %+v", hotKeys)
+	}
+}
@@ -0,0 +1,197 @@
+package database
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/metrics"
+	"strings"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/database"
+)
+
+// INFO memory/cpu/keyspace
+// used_memory 是 go 堆上存活对象的字节数，used_memory_rss 是向操作系统申请的内存，
+// used_memory_peak 在统计采样时通过 runtime/metrics 更新(不会暂停程序)。
+// used_memory_dataset 按数据结构估算 key 和 value 的字节数：每个数据库抽取 datasetSampleKeys 个 key，
+// 集合类型只遍历前 datasetSampleElements 个元素，再按 key 数和元素数放大，不需要遍历整个数据库。
+// keyspace 的 avg_ttl 同样由抽取的带过期时间的 key 估算
+
+const (
+	datasetSampleKeys     = 64
+	datasetSampleElements = 128
+	// 每个 key 在 dict 和 DataEntity 上的额外开销
+	keyOverhead = 64
+)
+
+const heapObjectsMetric = "/memory/classes/heap/objects:bytes"
+
+// trackMemoryPeak 读取当前堆上存活对象的字节数，更新 used_memory_peak
+func (s *serverStats) trackMemoryPeak() {
+	sample := []metrics.Sample{{Name: heapObjectsMetric}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() == metrics.KindUint64 {
+		s.updateMemoryPeak(sample[0].Value.Uint64())
+	}
+}
+
+func (s *serverStats) peakMemory() uint64 {
+	if s == nil {
+		return 0
+	}
+	return s.memoryPeak.Load()
+}
+
+func (s *serverStats) updateMemoryPeak(used uint64) {
+	if s == nil {
+		return
+	}
+	for {
+		peak := s.memoryPeak.Load()
+		if used <= peak || s.memoryPeak.CompareAndSwap(peak, used) {
+			return
+		}
+	}
+}
+
+// bytesToHuman 与 redis 相同，例如 1.50K、2.00G
+func bytesToHuman(n uint64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	value := float64(n) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.2f%c", value, units[unit])
+}
+
+// memoryInfo server 为 nil 时只输出进程的内存
+func (server *Server) memoryInfo() string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	peak := mem.HeapAlloc
+	var dataset uint64
+	var lazyfreePending, lazyfreed, lazyfreeBytes int64
+	if server != nil {
+		if server.lazyfree != nil {
+			lazyfreePending = server.lazyfree.pending.Load()
+			lazyfreed = server.lazyfree.freedObjects.Load()
+			lazyfreeBytes = server.lazyfree.freedBytes.Load()
+		}
+		server.stats.updateMemoryPeak(mem.HeapAlloc)
+		peak = max(peak, server.stats.peakMemory())
+		dataset = uint64(server.estimateDataset())
+	}
+	datasetPerc := 0.0
+	if mem.HeapAlloc > 0 {
+		datasetPerc = float64(dataset) * 100 / float64(mem.HeapAlloc)
+	}
+	return fmt.Sprintf("# Memory\r\n"+
+		"used_memory:%d\r\n"+
+		"used_memory_human:%s\r\n"+
+		"used_memory_rss:%d\r\n"+
+		"used_memory_rss_human:%s\r\n"+
+		"used_memory_peak:%d\r\n"+
+		"used_memory_peak_human:%s\r\n"+
+		"used_memory_dataset:%d\r\n"+
+		"used_memory_dataset_perc:%.2f%%\r\n"+
+		"mem_allocator:go-%s\r\n"+
+		"mem_heap_objects:%d\r\n"+
+		"mem_gc_count:%d\r\n"+
+		"mem_gc_pause_total_ms:%d\r\n"+
+		"lazyfree_pending_objects:%d\r\n"+
+		"lazyfreed_objects:%d\r\n"+
+		"lazyfree_freed_bytes:%d\r\n",
+		mem.HeapAlloc,
+		bytesToHuman(mem.HeapAlloc),
+		mem.Sys,
+		bytesToHuman(mem.Sys),
+		peak,
+		bytesToHuman(peak),
+		dataset,
+		datasetPerc,
+		runtime.Version(),
+		mem.HeapObjects,
+		mem.NumGC,
+		time.Duration(mem.PauseTotalNs).Milliseconds(),
+		lazyfreePending,
+		lazyfreed,
+		lazyfreeBytes)
+}
+
+// estimateDataset 估算所有数据库中 key 和 value 占用的字节数
+func (server *Server) estimateDataset() int64 {
+	var total int64
+	for i := range server.dbSet {
+		total += server.mustSelectDB(i).estimateDataset()
+	}
+	return total
+}
+
+// estimateDataset 估算前 datasetSampleKeys 个 key 的大小再按 key 数放大，
+// key 按哈希分布在分片中，遍历到的前几个 key 相当于随机抽样。遍历时持有分片的读锁，也就是这些 key 的读锁
+func (db *DB) estimateDataset() int64 {
+	keys := db.data.Len()
+	if keys == 0 {
+		return 0
+	}
+	var size, sampled int64
+	db.data.ForEach(func(key string, raw interface{}) bool {
+		if entity, ok := raw.(*database.DataEntity); ok {
+			size += int64(len(key)) + keyOverhead + objectSize(entity.Data, datasetSampleElements)
+			sampled++
+		}
+		return sampled < datasetSampleKeys
+	})
+	if sampled == 0 {
+		return 0
+	}
+	return size * int64(keys) / sampled
+}
+
+func (server *Server) cpuInfo() string {
+	sys, user := cpuUsage()
+	return fmt.Sprintf("# CPU\r\n"+
+		"used_cpu_sys:%.6f\r\n"+
+		"used_cpu_user:%.6f\r\n"+
+		"used_cpu_sys_children:0.000000\r\n"+
+		"used_cpu_user_children:0.000000\r\n",
+		sys, user)
+}
+
+// keyspaceInfo 每个非空的数据库一行 db<n>:keys=<n>,expires=<n>,avg_ttl=<ms>
+func (server *Server) keyspaceInfo() string {
+	var sb strings.Builder
+	sb.WriteString("# Keyspace\r\n")
+	for i := range server.dbSet {
+		db := server.mustSelectDB(i)
+		keys, expires := db.data.Len(), db.ttlMap.Len()
+		if keys == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "db%d:keys=%d,expires=%d,avg_ttl=%d\r\n", i, keys, expires, db.estimateAvgTTL())
+	}
+	return sb.String()
+}
+
+// estimateAvgTTL 抽取前 datasetSampleKeys 个带过期时间的 key，返回剩余时间的平均毫秒数
+func (db *DB) estimateAvgTTL() int64 {
+	now := time.Now()
+	var total, sampled int64
+	db.ttlMap.ForEach(func(key string, raw interface{}) bool {
+		expireTime, _ := raw.(time.Time)
+		if ttl := expireTime.Sub(now).Milliseconds(); ttl > 0 {
+			total += ttl
+			sampled++
+		}
+		return sampled < datasetSampleKeys
+	})
+	if sampled == 0 {
+		return 0
+	}
+	return total / sampled
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package database
+
+// cpuUsage 没有 getrusage 的平台上返回 0
+func cpuUsage() (sys float64, user float64) {
+	return 0, 0
+}
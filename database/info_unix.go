@@ -0,0 +1,18 @@
+//go:build unix
+
+package database
+
+import "syscall"
+
+// cpuUsage 返回进程使用的系统态和用户态 CPU 时间(秒)
+func cpuUsage() (sys float64, user float64) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0
+	}
+	return timevalSeconds(usage.Stime), timevalSeconds(usage.Utime)
+}
+
+func timevalSeconds(tv syscall.Timeval) float64 {
+	return float64(tv.Sec) + float64(tv.Usec)/1e6
+}
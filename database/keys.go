@@ -12,6 +12,9 @@ import (
 	"github.com/zhangming/go-redis/datastruct/list"
 	"github.com/zhangming/go-redis/datastruct/set"
 	"github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/datastruct/sparse"
+	"github.com/zhangming/go-redis/datastruct/stream"
+	"github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/interfaces/redis"
 	"github.com/zhangming/go-redis/lib/utils"
 	"github.com/zhangming/go-redis/lib/wildcard"
@@ -39,12 +42,11 @@ const (
 //管理键的生命周期、存在性、扫描、过期等
 
 func toTTLCmd(db *DB, key string) *protocol.MultiBulkReply {
-	raw, exists := db.ttlMap.Get(key)
+	expireTime, exists := db.ExpireAt(key)
 	if !exists {
 		// has no TTL
 		return protocol.MakeMultiBulkReply(utils.ToCmdLine("PERSIST", key))
 	}
-	expireTime, _ := raw.(time.Time)
 	timestamp := strconv.FormatInt(expireTime.UnixNano()/1000/1000, 10)
 	return protocol.MakeMultiBulkReply(utils.ToCmdLine("PEXPIREAT", key, timestamp))
 }
@@ -94,8 +96,13 @@ func getType(db *DB, key string) string {
 	if !exists {
 		return "none"
 	}
+	return entityType(entity)
+}
+
+// entityType 返回 TYPE 命令使用的类型名，未知的类型返回空字符串
+func entityType(entity *database.DataEntity) string {
 	switch entity.Data.(type) {
-	case []byte:
+	case []byte, *sparse.String:
 		return "string"
 	case list.List:
 		return "list"
@@ -105,6 +112,8 @@ func getType(db *DB, key string) string {
 		return "set"
 	case *sortedset.SortedSet:
 		return "zset"
+	case *stream.Stream:
+		return "stream"
 	}
 	return ""
 }
@@ -134,8 +143,8 @@ func undoRename(db *DB, args [][]byte) []CmdLine {
 
 // 执行重命名
 // 如果 dest 存在，直接覆盖。
-// 清除 src 和 dest 的 TTL。
-// 设置新的 TTL（如果原 src 有 TTL）。
+// dest 原来的 TTL 被丢弃，src 的 TTL 跟随值转移到 dest。
+// src 和 dest 相同时什么也不做
 func execRename(db *DB, args [][]byte) redis.Reply {
 	if len(args) != 2 {
 		return protocol.MakeErrReply("ERR wrong number of arguments for 'rename' command")
@@ -143,27 +152,22 @@ func execRename(db *DB, args [][]byte) redis.Reply {
 	src := string(args[0])
 	dest := string(args[1])
 
-	entity, ok := db.GetEntity(src)
+	entity, expireAt, ok := db.GetWithTTL(src)
 	if !ok {
-		return protocol.MakeErrReply("no such key")
+		return protocol.MakeErrReply("ERR no such key")
 	}
-	rawTTL, hasTTL := db.ttlMap.Get(src)
-	db.PutEntity(dest, entity)
-	db.Remove(src)
-	if hasTTL {
-		db.Persist(src) // clean src and dest with their ttl
-		db.Persist(dest)
-		expireTime, _ := rawTTL.(time.Time)
-		db.Expire(dest, expireTime)
+	if src == dest {
+		return &protocol.OkReply{}
 	}
+	db.Remove(src)
+	db.SetWithTTL(dest, entity, expireAt)
 	db.addAof(utils.ToCmdLine3("rename", args...))
 	return &protocol.OkReply{}
 }
 
 // 执行重命名
 // 如果 dest 存在，直接返回 0。
-// 清除 src 和 dest 的 TTL。
-// 设置新的 TTL（如果原 src 有 TTL）。
+// src 的 TTL 跟随值转移到 dest。
 func execRenameNx(db *DB, args [][]byte) redis.Reply {
 	if len(args) != 2 {
 		return protocol.MakeErrReply("ERR wrong number of arguments for 'renamenx' command")
@@ -171,7 +175,7 @@ func execRenameNx(db *DB, args [][]byte) redis.Reply {
 	src := string(args[0])
 	dest := string(args[1])
 
-	entity, ok := db.GetEntity(src)
+	entity, expireAt, ok := db.GetWithTTL(src)
 	if !ok {
 		return protocol.MakeIntReply(0)
 	}
@@ -179,29 +183,242 @@ func execRenameNx(db *DB, args [][]byte) redis.Reply {
 	if ok {
 		return protocol.MakeIntReply(0)
 	}
-	rawTTL, hasTTL := db.ttlMap.Get(src)
-	db.PutEntity(dest, entity)
 	db.Remove(src)
-	if hasTTL {
-		db.Persist(src)
-		db.Persist(dest)
-		expireTime, _ := rawTTL.(time.Time)
-		db.Expire(dest, expireTime)
-	}
+	db.SetWithTTL(dest, entity, expireAt)
 	db.addAof(utils.ToCmdLine3("renamenx", args...))
 	return protocol.MakeIntReply(1)
 }
 
-// 设置key的时间以秒为单位
-func execExpire(db *DB, args [][]byte) redis.Reply {
+// cloneData 复制 key 的值，集合类型使用各自的 Clone
+// 字符串会被 APPEND/SETRANGE/SETBIT 原地修改，必须拷贝字节
+func cloneData(data interface{}) interface{} {
+	switch val := data.(type) {
+	case []byte:
+		bytes := make([]byte, len(val))
+		copy(bytes, val)
+		return bytes
+	case *sparse.String:
+		return val.Clone()
+	case list.List:
+		return val.Clone()
+	case dict.Dict:
+		return val.Clone()
+	case *set.Set:
+		return val.Clone()
+	case *sortedset.SortedSet:
+		return val.Clone()
+	case *stream.Stream:
+		return val.Clone()
+	}
+	return data
+}
+
+func prepareCopy(args [][]byte) ([]string, []string) {
+	src := string(args[0])
+	dest := string(args[1])
+	return []string{dest}, []string{src}
+}
+
+func undoCopy(db *DB, args [][]byte) []CmdLine {
+	dest := string(args[1])
+	return rollbackGivenKeys(db, dest)
+}
+
+// parseCopyArgs 解析 COPY 的 [DB destination-db] [REPLACE]，没有 DB 参数时 dbIndex 为 -1
+func parseCopyArgs(args [][]byte) (dbIndex int, replace bool, errReply redis.Reply) {
+	dbIndex = -1
+	for i := 2; i < len(args); i++ {
+		arg := strings.ToLower(string(args[i]))
+		switch {
+		case arg == "replace":
+			replace = true
+		case arg == "db" && i+1 < len(args):
+			index, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return 0, false, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			dbIndex = index
+			i++
+		default:
+			return 0, false, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return dbIndex, replace, nil
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+// 复制到其它 db 由 Server.execCopyToDB 处理，这里只处理当前 db 内的复制，ttl 跟随 source
+func execCopy(db *DB, args [][]byte) redis.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	dbIndex, replace, errReply := parseCopyArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	if dbIndex >= 0 && dbIndex != db.getIndex() {
+		// 只有事务中的 COPY 会走到这里，事务在一个 db 的锁内执行
+		return protocol.MakeErrReply("ERR COPY to another db is not supported")
+	}
+	if src == dest {
+		return protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+	if !copyEntity(db, src, db, dest, replace) {
+		return protocol.MakeIntReply(0)
+	}
+	db.addAof(utils.ToCmdLine3("copy", args...))
+	return protocol.MakeIntReply(1)
+}
+
+// copyEntity 把 srcDB 中的 src 深拷贝为 destDB 中的 dest，ttl 跟随 src，没有复制时返回 false
+func copyEntity(srcDB *DB, src string, destDB *DB, dest string, replace bool) bool {
+	entity, expireAt, ok := srcDB.GetWithTTL(src)
+	if !ok {
+		return false
+	}
+	if _, exists := destDB.GetEntity(dest); exists {
+		if !replace {
+			return false
+		}
+		destDB.Remove(dest)
+	}
+	destDB.SetWithTTL(dest, &database.DataEntity{
+		Data: cloneData(entity.Data),
+	}, expireAt)
+	return true
+}
+
+// execCopyToDB 执行复制到其它 db 的 COPY，两个 db 的锁由 lockDBs 获取。
+// 返回 nil 表示没有 DB 参数或者目标就是当前 db，由 execCopy 处理
+func (server *Server) execCopyToDB(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	args := cmdLine[1:]
+	if len(args) < 2 {
+		return nil
+	}
+	dbIndex, replace, errReply := parseCopyArgs(args)
+	if errReply != nil || dbIndex < 0 || dbIndex == c.GetDBIndex() {
+		return errReply
+	}
+	srcDB, selectErr := server.selectDB(c.GetDBIndex())
+	if selectErr != nil {
+		return selectErr
+	}
+	destDB, selectErr := server.selectDB(dbIndex)
+	if selectErr != nil {
+		return selectErr
+	}
+	src, dest := string(args[0]), string(args[1])
+	view, log := destDB.beginCommit([]string{dest})
+	// 源 key 只持有读锁
+	reader, expired := srcDB.beginRead()
+	reply := func() redis.Reply {
+		defer lockDBs(
+			dbKeys{db: srcDB, readKeys: []string{src}},
+			dbKeys{db: destDB, writeKeys: []string{dest}},
+		)()
+		if !copyEntity(reader, src, view, dest, replace) {
+			return protocol.MakeIntReply(0)
+		}
+		log.events = append(log.events, keyEvent{notifyGeneric, "copy_to", dest})
+		// 与其它写命令的提交顺序相同，aof 写在源 db 中，重放时同样复制到 destination-db
+		destDB.commitVersions(log)
+		srcDB.addAof(utils.ToCmdLine3("copy", args...))
+		return protocol.MakeIntReply(1)
+	}()
+	destDB.commitEvents(log)
+	srcDB.expireReads(*expired)
+	destDB.wakeBlocked(log)
+	return reply
+}
+
+// EXPIRE 系列命令的选项
+const (
+	// 只在没有过期时间时设置
+	expireNX = 1 << iota
+	// 只在已有过期时间时设置
+	expireXX
+	// 只在新的过期时间更晚时设置，没有过期时间的 key 看作永不过期
+	expireGT
+	// 只在新的过期时间更早时设置
+	expireLT
+)
+
+func parseExpireFlags(args [][]byte) (int, protocol.ErrorReply) {
+	flags := 0
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			flags |= expireNX
+		case "XX":
+			flags |= expireXX
+		case "GT":
+			flags |= expireGT
+		case "LT":
+			flags |= expireLT
+		default:
+			return 0, protocol.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if flags&expireNX != 0 && flags&(expireXX|expireGT|expireLT) != 0 {
+		return 0, protocol.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if flags&expireGT != 0 && flags&expireLT != 0 {
+		return 0, protocol.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return flags, nil
+}
+
+// toExpireTime 把 n 个 unit 毫秒转换为过期时间，relative 时相对于当前时间，超出范围时返回 false
+func toExpireTime(n int64, unit int64, relative bool) (time.Time, bool) {
+	if n > math.MaxInt64/unit || n < math.MinInt64/unit {
+		return time.Time{}, false
+	}
+	ms := n * unit
+	if !relative {
+		return time.UnixMilli(ms), true
+	}
+	if ms > math.MaxInt64/int64(time.Millisecond) || ms < math.MinInt64/int64(time.Millisecond) {
+		return time.Time{}, false
+	}
+	return time.Now().Add(time.Duration(ms) * time.Millisecond), true
+}
+
+// expireAllowed 按照 NX/XX/GT/LT 检查是否可以把 key 的过期时间设置为 expireTime
+func expireAllowed(db *DB, key string, expireTime time.Time, flags int) bool {
+	current, volatile := db.ExpireAt(key)
+	if flags&expireNX != 0 {
+		return !volatile
+	}
+	if flags&expireXX != 0 && !volatile {
+		return false
+	}
+	if flags&expireGT != 0 {
+		return volatile && expireTime.After(current)
+	}
+	if flags&expireLT != 0 {
+		return !volatile || expireTime.Before(current)
+	}
+	return true
+}
+
+// expireGeneric KEY n [NX|XX|GT|LT]，过期时间在 aof 中统一记录为 PEXPIREAT
+func expireGeneric(db *DB, args [][]byte, cmdName string, unit int64, relative bool) redis.Reply {
 	key := string(args[0])
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
+	n, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	expireTime := time.Now().Add(time.Duration(ttlArg) * time.Second)
-	_, exists := db.GetEntity(key)
-	if !exists {
+	flags, errReply := parseExpireFlags(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	expireTime, ok := toExpireTime(n, unit, relative)
+	if !ok {
+		return protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	if _, exists := db.GetEntity(key); !exists {
+		return protocol.MakeIntReply(0)
+	}
+	if !expireAllowed(db, key, expireTime, flags) {
 		return protocol.MakeIntReply(0)
 	}
 	db.Expire(key, expireTime)
@@ -209,42 +426,49 @@ func execExpire(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(1)
 }
 
+// 设置key的时间以秒为单位
+func execExpire(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, args, "expire", 1000, true)
+}
+
+// 毫秒级的相对过期时间
+func execPExpire(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, args, "pexpire", 1, true)
+}
+
 // 在Unix时间戳中设置密钥的过期时间
 // 绝对时间，在哪个时间点过期（秒级 Unix 时间戳）
 func execExpireAt(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-
-	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	expireAt := time.Unix(raw, 0)
-
-	_, exists := db.GetEntity(key)
-	if !exists {
-		return protocol.MakeIntReply(0)
-	}
+	return expireGeneric(db, args, "expireat", 1000, false)
+}
 
-	db.Expire(key, expireAt)
-	db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
-	return protocol.MakeIntReply(1)
+// 毫秒级的绝对过期时间，aof 和事务回滚中的过期时间都记录为 PEXPIREAT
+func execPExpireAt(db *DB, args [][]byte) redis.Reply {
+	return expireGeneric(db, args, "pexpireat", 1, false)
 }
 
 // 查询一个键的 绝对过期时间戳（秒）
 func execExpireTime(db *DB, args [][]byte) redis.Reply {
+	return expireTimeGeneric(db, args, time.Time.Unix)
+}
+
+// 查询一个键的 绝对过期时间戳（毫秒）
+func execPExpireTime(db *DB, args [][]byte) redis.Reply {
+	return expireTimeGeneric(db, args, time.Time.UnixMilli)
+}
+
+func expireTimeGeneric(db *DB, args [][]byte, timestamp func(time.Time) int64) redis.Reply {
 	key := string(args[0])
 	_, exists := db.GetEntity(key)
 	if !exists {
 		return protocol.MakeIntReply(-2)
 	}
 
-	raw, exists := db.ttlMap.Get(key)
+	expireTime, exists := db.ExpireAt(key)
 	if !exists {
 		return protocol.MakeIntReply(-1)
 	}
-	rawExpireTime, _ := raw.(time.Time)
-	expireTime := rawExpireTime.Unix()
-	return protocol.MakeIntReply(expireTime)
+	return protocol.MakeIntReply(timestamp(expireTime))
 }
 
 // 查询一个键的 剩余生存时间（秒）
@@ -255,23 +479,40 @@ func execTTL(db *DB, args [][]byte) redis.Reply {
 		return protocol.MakeIntReply(-2)
 	}
 
-	raw, exists := db.ttlMap.Get(key)
+	ttl, exists := db.TTL(key)
 	if !exists {
 		return protocol.MakeIntReply(-1)
 
 	}
-	expireTime, _ := raw.(time.Time)
-	ttl := expireTime.Sub(time.Now()).Seconds()
-	return protocol.MakeIntReply(int64(math.Round(ttl)))
+	return protocol.MakeIntReply(int64(math.Round(ttl.Seconds())))
 }
 
-// 删除键
+// 查询一个键的 剩余生存时间（毫秒）
+func execPTTL(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return protocol.MakeIntReply(-2)
+	}
+
+	ttl, exists := db.TTL(key)
+	if !exists {
+		return protocol.MakeIntReply(-1)
+	}
+	return protocol.MakeIntReply(ttl.Milliseconds())
+}
+
+// 去掉键的过期时间
 func execPersist(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	_, exists := db.GetEntity(key)
 	if !exists {
 		return protocol.MakeIntReply(0)
 	}
+	// 没有过期时间时不修改
+	if _, volatile := db.ExpireAt(key); !volatile {
+		return protocol.MakeIntReply(0)
+	}
 
 	db.Persist(key)
 	db.addAof(utils.ToCmdLine3("persist", args...))
@@ -285,17 +526,28 @@ func undoExpire(db *DB, args [][]byte) []CmdLine {
 }
 
 // 返回所有键
+// KEYS pattern [TYPE type]，TYPE 是本项目的扩展，与 SCAN 的 TYPE 选项相同
 func execKeys(db *DB, args [][]byte) redis.Reply {
 	pattern, err := wildcard.CompilePattern(string(args[0]))
 	if err != nil {
 		return protocol.MakeErrReply("ERR pattern is not a valid glob-style pattern")
 	}
+	var keyType string
+	if len(args) > 1 {
+		if len(args) != 3 || strings.ToLower(string(args[1])) != "type" {
+			return &protocol.SyntaxErrReply{}
+		}
+		keyType = strings.ToLower(string(args[2]))
+	}
 	result := make([][]byte, 0)
 	// 这里的foreach是加锁的了，所以这就是为什么不推荐使用的原因了
 	db.data.ForEach(func(key string, value interface{}) bool {
 		if !pattern.IsMatch(key) {
 			return true
 		}
+		if keyType != "" && entityType(value.(*database.DataEntity)) != keyType {
+			return true
+		}
 		if !db.IsExpired(key) {
 			result = append(result, []byte(key))
 		}
@@ -311,11 +563,15 @@ func execScan(db *DB, args [][]byte) redis.Reply {
 	var pattern string = "*"
 	var scanType string = ""
 	if len(args) > 1 {
-		for i := 0; i < len(args); i++ {
+		// args[0] 是游标，选项都带一个参数
+		for i := 1; i < len(args); i++ {
 			arg := strings.ToLower(string(args[i]))
+			if i+1 >= len(args) {
+				return &protocol.SyntaxErrReply{}
+			}
 			if arg == "count" {
 				count0, err := strconv.Atoi(string(args[i+1]))
-				if err != nil {
+				if err != nil || count0 < 1 {
 					return &protocol.SyntaxErrReply{}
 				}
 				count = count0
@@ -331,25 +587,25 @@ func execScan(db *DB, args [][]byte) redis.Reply {
 			}
 		}
 	}
-	cursor, err := strconv.Atoi(string(args[0]))
+	// 与 redis 相同游标是无符号 64 位整数，超出分片数的游标直接结束遍历
+	cursor64, err := strconv.ParseUint(string(args[0]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR invalid cursor")
 	}
+	cursor := int(min(cursor64, math.MaxInt32))
+	// TYPE 在遍历分片时按值过滤，过滤掉的 key 不占用 COUNT，不会因为类型不符返回很短甚至空的一页
+	var filter dict.Consumer
+	if len(scanType) != 0 {
+		filter = func(key string, value interface{}) bool {
+			return entityType(value.(*database.DataEntity)) == scanType
+		}
+	}
 	// 针对那一部分的分片上锁
-	keysReply, nextCursor := db.data.DictScan(cursor, count, pattern)
+	keysReply, nextCursor := db.data.DictScanFilter(cursor, count, pattern, filter)
 	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+		return protocol.MakeErrReply("ERR invalid cursor")
 	}
 
-	if len(scanType) != 0 {
-		for i := 0; i < len(keysReply); {
-			if getType(db, string(keysReply[i])) != scanType {
-				keysReply = append(keysReply[:i], keysReply[i+1:]...)
-			} else {
-				i++
-			}
-		}
-	}
 	result := make([]redis.Reply, 2)
 	result[0] = protocol.MakeBulkReply([]byte(strconv.FormatInt(int64(nextCursor), 10)))
 	result[1] = protocol.MakeMultiBulkReply(keysReply)
@@ -360,25 +616,35 @@ func execScan(db *DB, args [][]byte) redis.Reply {
 func init() {
 	registerCommand("Del", execDel, writeAllKeys, undoDel, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, -1, 1)
-	registerCommand("Expire", execExpire, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("Expire", execExpire, writeFirstKey, undoExpire, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("ExpireAt", execExpireAt, writeFirstKey, undoExpire, 3, flagWrite).
+	registerCommand("PExpire", execPExpire, writeFirstKey, undoExpire, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("ExpireTime", execExpireTime, readFirstKey, nil, 2, flagReadOnly).
+	registerCommand("ExpireAt", execExpireAt, writeFirstKey, undoExpire, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("PExpireAt", execPExpireAt, writeFirstKey, undoExpire, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("ExpireTime", execExpireTime, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("PExpireTime", execPExpireTime, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("TTL", execTTL, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("PTTL", execPTTL, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom, redisFlagFast}, 1, 1, 1)
 	registerCommand("Persist", execPersist, writeFirstKey, undoExpire, 2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("Exists", execExists, readAllKeys, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("Type", execType, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("Rename", execRename, prepareRename, undoRename, 3, flagReadOnly).
+	registerCommand("Rename", execRename, prepareRename, undoRename, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
-	registerCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3, flagReadOnly).
+	registerCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("Keys", execKeys, noPrepare, nil, 2, flagReadOnly).
+	registerCommand("Copy", execCopy, prepareCopy, undoCopy, -3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite}, 1, 2, 1)
+	registerCommand("Keys", execKeys, noPrepare, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
 	registerCommand("Scan", execScan, noPrepare, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
@@ -0,0 +1,396 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestCopy(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "str", "hello")
+	execTestCmd(db, "RPUSH", "list", "a", "b", "c")
+	execTestCmd(db, "HSET", "hash", "f", "v")
+	execTestCmd(db, "SADD", "set", "a", "b")
+	execTestCmd(db, "ZADD", "zset", "1", "a", "2", "b")
+
+	assertReply(t, execTestCmd(db, "COPY", "str", "str2"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "APPEND", "str2", " world"), ":11\r\n")
+	assertReply(t, execTestCmd(db, "GET", "str"), "$5\r\nhello\r\n")
+
+	assertReply(t, execTestCmd(db, "COPY", "list", "list2"), ":1\r\n")
+	execTestCmd(db, "LPOP", "list2")
+	assertReply(t, execTestCmd(db, "LRANGE", "list", "0", "-1"), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+
+	assertReply(t, execTestCmd(db, "COPY", "hash", "hash2"), ":1\r\n")
+	execTestCmd(db, "HSET", "hash2", "f", "v2")
+	assertReply(t, execTestCmd(db, "HGET", "hash", "f"), "$1\r\nv\r\n")
+
+	assertReply(t, execTestCmd(db, "COPY", "set", "set2"), ":1\r\n")
+	execTestCmd(db, "SREM", "set2", "a")
+	assertReply(t, execTestCmd(db, "SCARD", "set"), ":2\r\n")
+
+	assertReply(t, execTestCmd(db, "COPY", "zset", "zset2"), ":1\r\n")
+	execTestCmd(db, "ZADD", "zset2", "10", "a")
+	assertReply(t, execTestCmd(db, "ZSCORE", "zset", "a"), "$1\r\n1\r\n")
+
+	// dest exists
+	assertReply(t, execTestCmd(db, "COPY", "str", "list"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "COPY", "str", "list", "REPLACE"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "GET", "list"), "$5\r\nhello\r\n")
+
+	assertReply(t, execTestCmd(db, "COPY", "missing", "x"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "COPY", "str", "str"), "-ERR source and destination objects are the same\r\n")
+	assertReply(t, execTestCmd(db, "COPY", "str", "x", "DB", "0"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "COPY", "str", "y", "DB", "1"), "-ERR COPY to another db is not supported\r\n")
+
+	// ttl follows source
+	execTestCmd(db, "EXPIRE", "str", "100")
+	execTestCmd(db, "COPY", "str", "ttl", "REPLACE")
+	assertReply(t, execTestCmd(db, "TTL", "ttl"), ":100\r\n")
+}
+
+func TestCopyToDB(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	exec := func(args ...string) redis.Reply {
+		return server.Exec(conn, utils.ToCmdLine(args...))
+	}
+	exec("RPUSH", "list", "a", "b")
+	exec("SET", "str", "v")
+	exec("EXPIRE", "str", "100")
+	assertReply(t, exec("COPY", "list", "list", "DB", "1"), ":1\r\n")
+	assertReply(t, exec("COPY", "str", "str", "DB", "1"), ":1\r\n")
+	assertReply(t, exec("COPY", "str", "list", "DB", "1"), ":0\r\n")
+	assertReply(t, exec("COPY", "missing", "x", "DB", "1"), ":0\r\n")
+	assertReply(t, exec("COPY", "str", "x", "DB", "16"), "-ERR invalid DB index\r\n")
+	// 深拷贝，修改副本不影响源 key
+	exec("SELECT", "1")
+	exec("RPUSH", "list", "c")
+	assertReply(t, exec("TTL", "str"), ":100\r\n")
+	assertReply(t, exec("COPY", "str", "list", "DB", "0", "REPLACE"), ":1\r\n")
+	exec("SELECT", "0")
+	assertReply(t, exec("GET", "list"), "$1\r\nv\r\n")
+	server.Close()
+
+	// aof 在源 db 中重放，结果与内存一致
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, exec("GET", "list"), "$1\r\nv\r\n")
+	exec("SELECT", "1")
+	assertReply(t, exec("LRANGE", "list", "0", "-1"), bulks("a", "b", "c"))
+	assertReply(t, exec("GET", "str"), "$1\r\nv\r\n")
+}
+
+// 两个 DB 中的同名 key 各自有过期任务，复制到另一个 DB 不会覆盖源 DB 的任务
+func TestCopyToDBExpire(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	exec := func(args ...string) redis.Reply {
+		return server.Exec(conn, utils.ToCmdLine(args...))
+	}
+	exec("SET", "k", "v", "PX", "100")
+	assertReply(t, exec("COPY", "k", "k", "DB", "1"), ":1\r\n")
+	// 不通过 GetEntity 读取，检查两个 DB 中的 key 都是被定时任务主动删除的
+	deadline := time.Now().Add(time.Second)
+	for _, dbIndex := range []int{0, 1} {
+		for {
+			if _, ok := server.mustSelectDB(dbIndex).data.Get("k"); !ok {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("k in db %d is not removed by the expire job", dbIndex)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+// 每个实例有自己的过期任务调度器，另一个实例覆盖同名 key 或者关闭都不影响这个实例的过期
+func TestInstanceExpires(t *testing.T) {
+	a := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 1})
+	defer a.Close()
+	b := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 1})
+	conn := connection.NewFakeConn()
+	a.Exec(conn, utils.ToCmdLine("SET", "k", "v", "PX", "100"))
+	b.Exec(conn, utils.ToCmdLine("SET", "k", "v", "PX", "100"))
+	b.Exec(conn, utils.ToCmdLine("SET", "k", "v"))
+	b.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := a.mustSelectDB(0).data.Get("k"); !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("k is not removed by the expire job")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := b.mustSelectDB(0).data.Get("k"); !ok {
+		t.Error("k without ttl in the other instance is removed")
+	}
+}
+
+// TestConcurrentLazyExpire 只读命令只持有读锁，并发读到同一个过期 key 时不能在读锁下删除它
+func TestConcurrentLazyExpire(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("DEBUG", "SET-ACTIVE-EXPIRE", "0"))
+	const n = 2000
+	for i := 0; i < n; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "k"+strconv.Itoa(i), "v", "PX", "30"))
+	}
+	time.Sleep(50 * time.Millisecond)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			for i := 0; i < n; i++ {
+				reply := server.Exec(c, utils.ToCmdLine("GET", "k"+strconv.Itoa(i)))
+				if string(reply.ToBytes()) != "$-1\r\n" {
+					t.Errorf("expected nil for expired key, got %q", reply.ToBytes())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// 释放读锁之后已经删除
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DBSIZE")), ":0\r\n")
+}
+
+func TestGetSetUndo(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "k", "old")
+	execTestCmd(db, "EXPIRE", "k", "100")
+	for _, cmdLine := range []CmdLine{utils.ToCmdLine("GETSET", "k", "new"), utils.ToCmdLine("GETDEL", "k")} {
+		undo := db.GetUndoLogs(cmdLine)
+		db.Exec(nil, cmdLine)
+		for _, line := range undo {
+			db.Exec(nil, line)
+		}
+		assertReply(t, execTestCmd(db, "GET", "k"), "$3\r\nold\r\n")
+		assertReply(t, execTestCmd(db, "TTL", "k"), ":100\r\n")
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	const size = 100000
+	db := makeTestDB()
+	members := make([]string, 0, size*2)
+	for i := 0; i < size; i++ {
+		members = append(members, strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	db.Exec(nil, utils.ToCmdLine2("RPUSH", append([]string{"list"}, members...)...))
+	db.Exec(nil, utils.ToCmdLine2("HMSET", append([]string{"hash"}, members...)...))
+	db.Exec(nil, utils.ToCmdLine2("SADD", append([]string{"set"}, members...)...))
+	db.Exec(nil, utils.ToCmdLine2("ZADD", append([]string{"zset"}, members...)...))
+	for _, key := range []string{"list", "hash", "set", "zset"} {
+		b.Run(key, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				execTestCmd(db, "COPY", key, key+"2", "REPLACE")
+			}
+		})
+	}
+}
+
+func TestPExpirePrecision(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "k", "v")
+	start := time.Now()
+	assertReply(t, execTestCmd(db, "PEXPIRE", "k", "50"), ":1\r\n")
+	pttl := execTestCmd(db, "PTTL", "k").(*protocol.IntReply).Code
+	if pttl <= 40 || pttl > 50 {
+		t.Errorf("unexpected pttl %d", pttl)
+	}
+	// 不通过 GetEntity 读取，检查 key 是被定时任务主动删除的
+	for {
+		if _, ok := db.data.Get("k"); !ok {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("key is not removed by the expire job")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > 80*time.Millisecond {
+		t.Errorf("key expired after %v, expected about 50ms", elapsed)
+	}
+	assertReply(t, execTestCmd(db, "PTTL", "k"), ":-2\r\n")
+
+	// TTL 按毫秒四舍五入到秒
+	execTestCmd(db, "SET", "k", "v")
+	execTestCmd(db, "PEXPIRE", "k", "1600")
+	assertReply(t, execTestCmd(db, "TTL", "k"), ":2\r\n")
+	execTestCmd(db, "PEXPIRE", "k", "1400")
+	assertReply(t, execTestCmd(db, "TTL", "k"), ":1\r\n")
+	execTestCmd(db, "PERSIST", "k")
+	assertReply(t, execTestCmd(db, "PTTL", "k"), ":-1\r\n")
+}
+
+func TestExpireOptions(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "k", "v")
+	// 没有过期时间的 key 看作永不过期
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "100", "XX"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "100", "GT"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "PERSIST", "k"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "100", "LT"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "200", "NX"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "200", "LT"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "200", "xx", "gt"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "k"), ":200\r\n")
+	assertReply(t, execTestCmd(db, "PEXPIRE", "k", "150000", "LT"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "k"), ":150\r\n")
+
+	at := time.Now().Add(time.Hour).UnixMilli()
+	assertReply(t, execTestCmd(db, "PEXPIREAT", "k", strconv.FormatInt(at, 10), "GT"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "PEXPIRETIME", "k"), ":"+strconv.FormatInt(at, 10)+"\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRETIME", "k"), ":"+strconv.FormatInt(at/1000, 10)+"\r\n")
+	assertReply(t, execTestCmd(db, "EXPIREAT", "k", strconv.FormatInt(at/1000-10, 10), "GT"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "PERSIST", "k"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "PEXPIRETIME", "k"), ":-1\r\n")
+	assertReply(t, execTestCmd(db, "PEXPIRETIME", "missing"), ":-2\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "missing", "100", "NX"), ":0\r\n")
+
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "100", "NX", "XX"),
+		"-ERR NX and XX, GT or LT options at the same time are not compatible\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "100", "GT", "LT"),
+		"-ERR GT and LT options at the same time are not compatible\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "100", "KEEPTTL"), "-ERR Unsupported option KEEPTTL\r\n")
+	assertReply(t, execTestCmd(db, "EXPIRE", "k", "9223372036854775807"), "-ERR invalid expire time in 'expire' command\r\n")
+	assertReply(t, execTestCmd(db, "PEXPIRE", "k", "9223372036854775807"), "-ERR invalid expire time in 'pexpire' command\r\n")
+}
+
+// 整体替换 key 的命令中过期时间跟随值转移，覆盖的 key 原来的过期时间被丢弃
+func TestKeyTTLFollowsValue(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "src", "v")
+	execTestCmd(db, "SET", "dest", "old", "EX", "100")
+	assertReply(t, execTestCmd(db, "RENAME", "src", "dest"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "dest"), ":-1\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "src"), ":0\r\n")
+
+	execTestCmd(db, "EXPIRE", "dest", "100")
+	assertReply(t, execTestCmd(db, "RENAME", "dest", "dest"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "GET", "dest"), "$1\r\nv\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "dest"), ":100\r\n")
+	assertReply(t, execTestCmd(db, "RENAMENX", "dest", "other"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "other"), ":100\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "dest"), ":-2\r\n")
+
+	execTestCmd(db, "SET", "plain", "v")
+	assertReply(t, execTestCmd(db, "COPY", "plain", "other", "REPLACE"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "other"), ":-1\r\n")
+
+	execTestCmd(db, "EXPIRE", "other", "100")
+	execTestCmd(db, "MSET", "other", "v2", "plain", "v2")
+	assertReply(t, execTestCmd(db, "TTL", "other"), ":-1\r\n")
+
+	execTestCmd(db, "EXPIRE", "other", "100")
+	entity, expireAt, ok := db.GetWithTTL("other")
+	if !ok || expireAt == nil {
+		t.Fatal("expected other with ttl")
+	}
+	db.SetWithTTL("moved", entity, expireAt)
+	if ttl, ok := db.TTL("moved"); !ok || ttl <= 99*time.Second {
+		t.Errorf("unexpected ttl %v", ttl)
+	}
+	db.SetWithTTL("moved", entity, nil)
+	if _, ok := db.ExpireAt("moved"); ok {
+		t.Error("expected ttl to be removed")
+	}
+}
+
+func TestObject(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "int", "12345")
+	execTestCmd(db, "SET", "short", "hello")
+	execTestCmd(db, "SET", "long", strings.Repeat("x", 45))
+	execTestCmd(db, "RPUSH", "list", "a")
+	execTestCmd(db, "HSET", "hash", "f", "v")
+	execTestCmd(db, "SADD", "set", "a")
+	execTestCmd(db, "SADD", "intset", "1", "2")
+	execTestCmd(db, "ZADD", "zset", "1", "a")
+	execTestCmd(db, "XADD", "stream", "1-0", "f", "v")
+	for key, encoding := range map[string]string{
+		"int": "int", "short": "embstr", "long": "raw", "list": "quicklist",
+		"hash": "listpack", "set": "hashtable", "intset": "intset", "zset": "listpack", "stream": "stream",
+	} {
+		reply := execTestCmd(db, "OBJECT", "ENCODING", key)
+		assertReply(t, reply, "$"+strconv.Itoa(len(encoding))+"\r\n"+encoding+"\r\n")
+	}
+	assertReply(t, execTestCmd(db, "OBJECT", "ENCODING", "missing"), "$-1\r\n")
+
+	// 超过限制之后转换编码
+	execTestCmd(db, "HSET", "hash", "long", strings.Repeat("x", 65))
+	execTestCmd(db, "SADD", "intset", "a")
+	execTestCmd(db, "ZADD", "zset", "2", strings.Repeat("x", 65))
+	assertReply(t, execTestCmd(db, "OBJECT", "ENCODING", "hash"), "$9\r\nhashtable\r\n")
+	assertReply(t, execTestCmd(db, "OBJECT", "ENCODING", "intset"), "$9\r\nhashtable\r\n")
+	assertReply(t, execTestCmd(db, "OBJECT", "ENCODING", "zset"), "$8\r\nskiplist\r\n")
+	assertReply(t, execTestCmd(db, "OBJECT", "REFCOUNT", "list"), ":1\r\n")
+
+	// 查看元数据不算访问
+	raw, _ := db.data.Get("short")
+	entity := raw.(*database.DataEntity)
+	atomic.StoreInt64(&entity.LRU, time.Now().Add(-90*time.Second).UnixMilli())
+	assertReply(t, execTestCmd(db, "OBJECT", "IDLETIME", "short"), ":90\r\n")
+	// 初始计数 5，空闲超过 1 分钟衰减 1
+	assertReply(t, execTestCmd(db, "OBJECT", "FREQ", "short"), ":4\r\n")
+	assertReply(t, execTestCmd(db, "OBJECT", "IDLETIME", "short"), ":90\r\n")
+	execTestCmd(db, "GET", "short")
+	assertReply(t, execTestCmd(db, "OBJECT", "IDLETIME", "short"), ":0\r\n")
+
+	assertReply(t, execTestCmd(db, "OBJECT", "FOO", "short"), "-ERR unknown subcommand 'FOO'. Try OBJECT HELP.\r\n")
+	assertReply(t, execTestCmd(db, "OBJECT", "ENCODING"), "-ERR wrong number of arguments for 'object|encoding' command\r\n")
+	if _, ok := execTestCmd(db, "OBJECT", "HELP").(*protocol.MultiRawReply); !ok {
+		t.Error("OBJECT HELP should return an array")
+	}
+}
+
+// 编码转换的阈值属于各自的实例，一个实例的配置不影响另一个实例
+func TestEncodingLimitsPerInstance(t *testing.T) {
+	small := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir: t.TempDir(), Databases: 1,
+		HashMaxListpackEntries: 1, SetMaxIntsetEntries: 1, ZsetMaxListpackEntries: 1,
+	})
+	defer small.Close()
+	normal := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 1})
+	defer normal.Close()
+	conn := connection.NewFakeConn()
+	for _, server := range []*Server{small, normal} {
+		server.Exec(conn, utils.ToCmdLine("HSET", "hash", "a", "1"))
+		server.Exec(conn, utils.ToCmdLine("HSET", "hash", "b", "2"))
+		server.Exec(conn, utils.ToCmdLine("SADD", "set", "1", "2"))
+		server.Exec(conn, utils.ToCmdLine("ZADD", "zset", "1", "a", "2", "b"))
+	}
+	for key, encoding := range map[string]string{"hash": "hashtable", "set": "hashtable", "zset": "skiplist"} {
+		assertReply(t, small.Exec(conn, utils.ToCmdLine("OBJECT", "ENCODING", key)), "$"+strconv.Itoa(len(encoding))+"\r\n"+encoding+"\r\n")
+	}
+	for key, encoding := range map[string]string{"hash": "listpack", "set": "intset", "zset": "listpack"} {
+		assertReply(t, normal.Exec(conn, utils.ToCmdLine("OBJECT", "ENCODING", key)), "$"+strconv.Itoa(len(encoding))+"\r\n"+encoding+"\r\n")
+	}
+}
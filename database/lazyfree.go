@@ -0,0 +1,207 @@
+package database
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/zhangming/go-redis/datastruct/dict"
+	"github.com/zhangming/go-redis/datastruct/list"
+	"github.com/zhangming/go-redis/datastruct/set"
+	"github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/datastruct/sparse"
+	"github.com/zhangming/go-redis/datastruct/stream"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 异步释放(lazyfree)
+// UNLINK 只在命令执行期间把 key 从 db 中摘掉，大对象交给后台 goroutine 释放。
+// go 的内存最终由 GC 回收，后台做的是遍历对象、统计释放的字节数这些 O(n) 的工作。
+
+// 元素数量不超过该值的对象直接在命令中释放，和 redis 的 LAZYFREE_THRESHOLD 一致
+const lazyfreeThreshold = 64
+
+const lazyfreeQueueSize = 1024
+
+type lazyFreer struct {
+	// mu 保护 jobs 的创建、发送和关闭，stop 之后不再向 jobs 发送
+	mu      sync.Mutex
+	jobs    chan interface{}
+	stopped bool
+	done    chan struct{}
+
+	pending      atomic.Int64 // 等待后台释放的对象数
+	freedObjects atomic.Int64 // 后台已经释放的对象数
+	freedBytes   atomic.Int64 // 后台已经释放的字节数(估算值)
+
+	// hook 测试用，每次后台释放一个对象后调用
+	hook func(data interface{})
+}
+
+// start 第一次有对象交给后台时启动 goroutine，调用方持有 mu
+func (l *lazyFreer) start() {
+	if l.jobs != nil {
+		return
+	}
+	l.jobs = make(chan interface{}, lazyfreeQueueSize)
+	l.done = make(chan struct{})
+	go l.run()
+}
+
+func (l *lazyFreer) run() {
+	defer close(l.done)
+	for data := range l.jobs {
+		l.freedBytes.Add(freeObject(data))
+		l.freedObjects.Add(1)
+		l.pending.Add(-1)
+		if hook := l.hook; hook != nil {
+			hook(data)
+		}
+	}
+}
+
+// stop 关闭队列，等待后台 goroutine 释放完队列中的对象后退出。之后交给 free 的对象同步释放
+func (l *lazyFreer) stop() {
+	l.mu.Lock()
+	if l.stopped {
+		l.mu.Unlock()
+		return
+	}
+	l.stopped = true
+	jobs, done := l.jobs, l.done
+	l.mu.Unlock()
+	if jobs == nil {
+		return
+	}
+	close(jobs)
+	<-done
+}
+
+// free 释放一个已经从 db 中摘除的对象，返回 true 表示交给了后台。l 为 nil 时直接释放
+func (l *lazyFreer) free(data interface{}) bool {
+	if l == nil || objectLen(data) <= lazyfreeThreshold {
+		return false
+	}
+	if l.enqueue(data) {
+		return true
+	}
+	// 队列已满或者已经 stop，退化为同步释放
+	freeObject(data)
+	return false
+}
+
+func (l *lazyFreer) enqueue(data interface{}) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.stopped {
+		return false
+	}
+	l.start()
+	l.pending.Add(1)
+	select {
+	case l.jobs <- data:
+		return true
+	default:
+		l.pending.Add(-1)
+		return false
+	}
+}
+
+// objectLen 返回集合类型的元素数量，字符串视为 1 个元素
+func objectLen(data interface{}) int {
+	switch val := data.(type) {
+	case list.List:
+		return val.Len()
+	case dict.Dict:
+		return val.Len()
+	case *set.Set:
+		return val.Len()
+	case *sortedset.SortedSet:
+		return int(val.Len())
+	case *stream.Stream:
+		return val.Len()
+	}
+	return 1
+}
+
+// freeObject 遍历对象估算占用的字节数，返回后对象不再被引用，由 GC 回收
+func freeObject(data interface{}) int64 {
+	return objectSize(data, 0)
+}
+
+// objectSize 估算对象占用的字节数，limit > 0 时集合类型只遍历前 limit 个元素，再按元素数放大
+func objectSize(data interface{}, limit int) int64 {
+	var size int64
+	counted := 0
+	next := func() bool {
+		counted++
+		return limit <= 0 || counted < limit
+	}
+	switch val := data.(type) {
+	case []byte:
+		return int64(len(val))
+	case *sparse.String:
+		return val.Allocated()
+	case list.List:
+		val.ForEach(func(i int, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			size += int64(len(bytes)) + 16
+			return next()
+		})
+	case dict.Dict:
+		val.ForEach(func(key string, v interface{}) bool {
+			bytes, _ := v.([]byte)
+			size += int64(len(key)+len(bytes)) + 32
+			return next()
+		})
+	case *set.Set:
+		val.ForEach(func(member string) bool {
+			size += int64(len(member)) + 16
+			return next()
+		})
+	case *sortedset.SortedSet:
+		if n := val.Len(); n > 0 {
+			val.ForEachByRank(0, n, false, func(element *sortedset.Element) bool {
+				size += int64(len(element.Member)) + 64
+				return next()
+			})
+		}
+	case *stream.Stream:
+		val.ForEach(func(entry *stream.Entry) bool {
+			for _, field := range entry.Fields {
+				size += int64(len(field)) + 24
+			}
+			size += 48
+			return next()
+		})
+	}
+	if n := objectLen(data); counted > 0 && counted < n {
+		size = size * int64(n) / int64(counted)
+	}
+	return size
+}
+
+// 删除 key，大对象在后台释放
+func execUnlink(db *DB, args [][]byte) redis.Reply {
+	deleted := 0
+	for _, arg := range args {
+		key := string(arg)
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			continue
+		}
+		db.Remove(key)
+		db.lazyfree.free(entity.Data)
+		deleted++
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("unlink", args...))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+func init() {
+	registerCommand("Unlink", execUnlink, writeAllKeys, undoDel, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, -1, 1)
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/datastruct/set"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestUnlinkFreesInBackground(t *testing.T) {
+	db := makeTestDB()
+	db.lazyfree = &lazyFreer{}
+	lazyfree := db.lazyfree
+	members := []string{"big"}
+	for i := 0; i < lazyfreeThreshold*4; i++ {
+		members = append(members, strconv.Itoa(i))
+	}
+	db.Exec(nil, utils.ToCmdLine2("SADD", members...))
+	members[0] = "blocker"
+	db.Exec(nil, utils.ToCmdLine2("SADD", members...))
+	execTestCmd(db, "SET", "small", "v")
+
+	// hook 在每个对象释放并计数之后调用，阻塞在 blocker 上让后台暂停，之后 UNLINK 的对象留在队列中
+	release := make(chan struct{})
+	freed := make(chan interface{}, 2)
+	lazyfree.hook = func(data interface{}) {
+		freed <- data
+		<-release
+	}
+	defer func() {
+		lazyfree.hook = nil
+	}()
+	pending := lazyfree.pending.Load()
+	objects := lazyfree.freedObjects.Load()
+
+	assertReply(t, execTestCmd(db, "UNLINK", "blocker"), ":1\r\n")
+	select {
+	case <-freed:
+	case <-time.After(time.Second):
+		t.Fatal("background free did not happen")
+	}
+
+	assertReply(t, execTestCmd(db, "UNLINK", "big", "small", "missing"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "big"), ":0\r\n")
+	if got := lazyfree.freedObjects.Load() - objects; got != 1 {
+		t.Fatalf("expected only the blocker freed, actually %d", got)
+	}
+	if got := lazyfree.pending.Load() - pending; got != 1 {
+		t.Fatalf("expected big pending in background, actually %d", got)
+	}
+
+	close(release)
+	select {
+	case <-freed:
+	case <-time.After(time.Second):
+		t.Fatal("background free did not happen")
+	}
+	if got := lazyfree.freedObjects.Load() - objects; got != 2 {
+		t.Errorf("expected 2 objects freed in background, actually %d", got)
+	}
+	if got := lazyfree.pending.Load(); got != pending {
+		t.Errorf("expected pending back to %d, actually %d", pending, got)
+	}
+	if lazyfree.freedBytes.Load() == 0 {
+		t.Error("expected freed bytes to be counted")
+	}
+
+	info := string(GenGodisInfoString("memory", nil))
+	if !strings.Contains(info, "lazyfree_pending_objects:") || !strings.Contains(info, "lazyfree_freed_bytes:") {
+		t.Errorf("unexpected memory info %q", info)
+	}
+}
+
+func TestLazyfreeStopsWithServer(t *testing.T) {
+	members := []string{"big"}
+	for i := 0; i < lazyfreeThreshold*4; i++ {
+		members = append(members, strconv.Itoa(i))
+	}
+	newInstance := func() {
+		server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+		conn := connection.NewFakeConn()
+		server.Exec(conn, utils.ToCmdLine2("SADD", members...))
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("UNLINK", "big")), ":1\r\n")
+		server.Close()
+		// stop 之后的对象同步释放，不会向已经关闭的队列发送
+		big := set.Make(members...)
+		if server.lazyfree.free(big) {
+			t.Error("expected objects freed synchronously after close")
+		}
+	}
+	// 第一个实例会启动进程内共用的后台 goroutine，之后再统计
+	newInstance()
+	before := runtime.NumGoroutine()
+	for i := 0; i < 20; i++ {
+		newInstance()
+	}
+	// 其他实例的后台 goroutine 可能还在退出
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("expected closed instances to stop their goroutines, %d before and %d after", before, after)
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
 	"strconv"
 	"strings"
@@ -117,18 +118,14 @@ func execLPop(db *DB, args [][]byte) redis.Reply {
 			val := list.Remove(0).([]byte)
 			vals[i] = val
 		}
-		if list.Len() == 0 {
-			db.Remove(key)
-		}
 		db.addAof(utils.ToCmdLine3("lpop", args...))
+		db.deleteIfEmpty(key, list)
 		return protocol.MakeMultiBulkReply(vals)
 	}
 
 	val, _ := list.Remove(0).([]byte)
-	if list.Len() == 0 {
-		db.Remove(key)
-	}
 	db.addAof(utils.ToCmdLine3("lpop", args...))
+	db.deleteIfEmpty(key, list)
 	return protocol.MakeBulkReply(val)
 }
 
@@ -270,6 +267,9 @@ func execLRange(db *DB, args [][]byte) redis.Reply {
 	}
 
 	// assert: start in [0, size - 1], stop in [start, size]
+	if errReply := db.checkReplySize(stop-start, "LRANGE with a smaller range"); errReply != nil {
+		return errReply
+	}
 	slice := list.Range(start, stop)
 	result := make([][]byte, len(slice))
 	for i, raw := range slice {
@@ -314,12 +314,10 @@ func execLRem(db *DB, args [][]byte) redis.Reply {
 		}, -count)
 	}
 
-	if list.Len() == 0 {
-		db.Remove(key)
-	}
 	if removed > 0 {
 		db.addAof(utils.ToCmdLine3("lrem", args...))
 	}
+	db.deleteIfEmpty(key, list)
 
 	return protocol.MakeIntReply(int64(removed))
 }
@@ -419,18 +417,14 @@ func execRPop(db *DB, args [][]byte) redis.Reply {
 			val := list.RemoveLast().([]byte)
 			vals[i] = val
 		}
-		if list.Len() == 0 {
-			db.Remove(key)
-		}
 		db.addAof(utils.ToCmdLine3("rpop", args...))
+		db.deleteIfEmpty(key, list)
 		return protocol.MakeMultiBulkReply(vals)
 	}
 
 	val, _ := list.RemoveLast().([]byte)
-	if list.Len() == 0 {
-		db.Remove(key)
-	}
 	db.addAof(utils.ToCmdLine3("rpop", args...))
+	db.deleteIfEmpty(key, list)
 	return protocol.MakeBulkReply(val)
 }
 
@@ -473,66 +467,9 @@ func undoRPop(db *DB, args [][]byte) []CmdLine {
 	}
 }
 
-func prepareRPopLPush(args [][]byte) ([]string, []string) {
-	return []string{
-		string(args[0]),
-		string(args[1]),
-	}, nil
-}
-
 // execRPopLPush pops last element of list-A then insert it to the head of list-B
 func execRPopLPush(db *DB, args [][]byte) redis.Reply {
-	sourceKey := string(args[0])
-	destKey := string(args[1])
-
-	// get source entity
-	sourceList, errReply := db.getAsList(sourceKey)
-	if errReply != nil {
-		return errReply
-	}
-	if sourceList == nil {
-		return &protocol.NullBulkReply{}
-	}
-
-	// get dest entity
-	destList, _, errReply := db.getOrInitList(destKey)
-	if errReply != nil {
-		return errReply
-	}
-
-	// pop and push
-	val, _ := sourceList.RemoveLast().([]byte)
-	destList.Insert(0, val)
-
-	if sourceList.Len() == 0 {
-		db.Remove(sourceKey)
-	}
-
-	db.addAof(utils.ToCmdLine3("rpoplpush", args...))
-	return protocol.MakeBulkReply(val)
-}
-
-func undoRPopLPush(db *DB, args [][]byte) []CmdLine {
-	sourceKey := string(args[0])
-	list, errReply := db.getAsList(sourceKey)
-	if errReply != nil {
-		return nil
-	}
-	if list == nil || list.Len() == 0 {
-		return nil
-	}
-	element, _ := list.Get(list.Len() - 1).([]byte)
-	return []CmdLine{
-		{
-			rPushCmd,
-			args[0],
-			element,
-		},
-		{
-			[]byte("LPOP"),
-			args[1],
-		},
-	}
+	return listMove(db, args[0], args[1], false, true)
 }
 
 // execRPush inserts element at last of list
@@ -635,6 +572,7 @@ func execLTrim(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.addAof(utils.ToCmdLine3("ltrim", args...))
+	db.deleteIfEmpty(key, list)
 
 	return protocol.MakeOkReply()
 }
@@ -683,6 +621,198 @@ func execLInsert(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
+// lposArgs LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len] 的选项
+type lposArgs struct {
+	rank     int
+	count    int
+	hasCount bool
+	maxLen   int
+}
+
+func parseLPosArgs(args [][]byte) (*lposArgs, protocol.ErrorReply) {
+	opts := &lposArgs{rank: 1}
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+		value, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if value == 0 {
+				return nil, protocol.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, " +
+					"2 from the second ... or use negative to start from the end of the list")
+			}
+			opts.rank = int(value)
+		case "COUNT":
+			if value < 0 {
+				return nil, protocol.MakeErrReply("ERR COUNT can't be negative")
+			}
+			opts.count = int(value)
+			opts.hasCount = true
+		case "MAXLEN":
+			if value < 0 {
+				return nil, protocol.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+			opts.maxLen = int(value)
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return opts, nil
+}
+
+// execLPos returns positions of element in list, RANK skips matches and negative RANK searches from the tail,
+// positions are always counted from the head
+func execLPos(db *DB, args [][]byte) redis.Reply {
+	opts, errReply := parseLPosArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+	list, errReply := db.getAsList(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	var positions []int
+	if list != nil {
+		positions = lpos(list, args[1], opts)
+	}
+	if opts.hasCount {
+		result := make([]redis.Reply, len(positions))
+		for i, pos := range positions {
+			result[i] = protocol.MakeIntReply(int64(pos))
+		}
+		return protocol.MakeMultiRawReply(result)
+	}
+	if len(positions) == 0 {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeIntReply(int64(positions[0]))
+}
+
+// lpos 最多比较 maxLen 个元素(0 表示不限)，没有 COUNT 时只找一个，COUNT 0 表示全部
+func lpos(list List.List, element []byte, opts *lposArgs) []int {
+	limit := opts.count
+	if !opts.hasCount {
+		limit = 1
+	}
+	size := list.Len()
+	scan := size
+	if opts.maxLen > 0 && opts.maxLen < size {
+		scan = opts.maxLen
+	}
+	var positions []int
+	skip := opts.rank - 1
+	match := func(i int, v interface{}) bool {
+		if !bytes.Equal(v.([]byte), element) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		positions = append(positions, i)
+		return limit == 0 || len(positions) < limit
+	}
+	if opts.rank > 0 {
+		list.ForEach(func(i int, v interface{}) bool {
+			return i < scan && match(i, v)
+		})
+		return positions
+	}
+	// 从尾部开始，只取出需要比较的部分
+	skip = -opts.rank - 1
+	values := list.Range(size-scan, size)
+	for i := len(values) - 1; i >= 0; i-- {
+		if !match(size-scan+i, values[i]) {
+			break
+		}
+	}
+	return positions
+}
+
+// parseLMPop 解析 LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func parseLMPop(args [][]byte) (keys []string, left bool, count int, errReply protocol.ErrorReply) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return nil, false, 0, protocol.MakeErrReply("ERR numkeys should be greater than 0")
+	} else if numKeys > len(args)-2 {
+		return nil, false, 0, protocol.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys = make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	left, ok := parseListSide(args[numKeys+1])
+	if !ok {
+		return nil, false, 0, protocol.MakeSyntaxErrReply()
+	}
+	count = 1
+	rest := args[numKeys+2:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(string(rest[0]), "count") {
+			return nil, false, 0, protocol.MakeSyntaxErrReply()
+		}
+		count, err = strconv.Atoi(string(rest[1]))
+		if err != nil || count <= 0 {
+			return nil, false, 0, protocol.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+	return keys, left, count, nil
+}
+
+func prepareLMPop(args [][]byte) ([]string, []string) {
+	keys, _, _, errReply := parseLMPop(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return keys, nil
+}
+
+func undoLMPop(db *DB, args [][]byte) []CmdLine {
+	keys, _ := prepareLMPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// execLMPop pops up to count elements from the first non-empty list, returns the key and elements
+func execLMPop(db *DB, args [][]byte) redis.Reply {
+	keys, left, count, errReply := parseLMPop(args)
+	if errReply != nil {
+		return errReply
+	}
+	for _, key := range keys {
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+		count = min(count, list.Len())
+		vals := make([][]byte, count)
+		cmd := "RPOP"
+		if left {
+			cmd = "LPOP"
+		}
+		for i := range vals {
+			if left {
+				vals[i], _ = list.Remove(0).([]byte)
+			} else {
+				vals[i], _ = list.RemoveLast().([]byte)
+			}
+		}
+		db.addAof(utils.ToCmdLine(cmd, key, strconv.Itoa(count)))
+		db.deleteIfEmpty(key, list)
+		return protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			protocol.MakeMultiBulkReply(vals),
+		})
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
 func init() {
 	registerCommand("LPush", execLPush, writeFirstKey, undoLPush, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
@@ -696,7 +826,7 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("RPop", execRPop, writeFirstKey, undoRPop, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
-	registerCommand("RPopLPush", execRPopLPush, prepareRPopLPush, undoRPopLPush, 3, flagWrite).
+	registerCommand("RPopLPush", execRPopLPush, prepareMove, undoMove, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("LRem", execLRem, writeFirstKey, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
@@ -712,4 +842,8 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
 	registerCommand("LInsert", execLInsert, writeFirstKey, rollbackFirstKey, 5, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("LPos", execLPos, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("LMPop", execLMPop, prepareLMPop, undoLMPop, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagMovableKeys}, 0, 0, 0)
 }
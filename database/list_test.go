@@ -0,0 +1,78 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/zhangming/go-redis/lib/utils"
+)
+
+func TestLPos(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "RPUSH", "l", "a", "b", "c", "1", "2", "3", "c", "c")
+	execTestCmd(db, "SET", "str", "v")
+
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "x"), "$-1\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "missing", "c"), "$-1\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "RANK", "2"), ":6\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "RANK", "-1"), ":7\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "COUNT", "2"), "*2\r\n:2\r\n:6\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "COUNT", "0"), "*3\r\n:2\r\n:6\r\n:7\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "RANK", "-1", "COUNT", "0"), "*3\r\n:7\r\n:6\r\n:2\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "RANK", "-2", "COUNT", "1"), "*1\r\n:6\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "x", "COUNT", "0"), "*0\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "missing", "c", "COUNT", "1"), "*0\r\n")
+	// MAXLEN 限制比较的元素个数，从尾部开始时也是一样
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "COUNT", "0", "MAXLEN", "3"), "*1\r\n:2\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "MAXLEN", "2"), "$-1\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "RANK", "-1", "COUNT", "0", "MAXLEN", "2"), "*2\r\n:7\r\n:6\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "a", "RANK", "-1", "MAXLEN", "4"), "$-1\r\n")
+
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "RANK", "0"),
+		"-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "COUNT", "-1"), "-ERR COUNT can't be negative\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "MAXLEN", "-1"), "-ERR MAXLEN can't be negative\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "COUNT"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "LIMIT", "1"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "l", "c", "RANK", "x"), "-ERR value is not an integer or out of range\r\n")
+	assertReply(t, execTestCmd(db, "LPOS", "str", "c"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestLMPop(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "RPUSH", "l2", "a", "b", "c")
+	execTestCmd(db, "RPUSH", "l3", "x")
+	execTestCmd(db, "SET", "str", "v")
+
+	assertReply(t, execTestCmd(db, "LMPOP", "2", "l1", "l2", "LEFT"), "*2\r\n$2\r\nl2\r\n*1\r\n$1\r\na\r\n")
+	assertReply(t, execTestCmd(db, "LMPOP", "2", "l1", "l2", "right", "COUNT", "5"), "*2\r\n$2\r\nl2\r\n*2\r\n$1\r\nc\r\n$1\r\nb\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "l2"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "LMPOP", "2", "l1", "l2", "LEFT"), "*-1\r\n")
+	assertReply(t, execTestCmd(db, "LMPOP", "2", "str", "l3", "LEFT"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	assertReply(t, execTestCmd(db, "LMPOP", "0", "l3", "LEFT"), "-ERR numkeys should be greater than 0\r\n")
+	assertReply(t, execTestCmd(db, "LMPOP", "2", "l3", "LEFT"), "-ERR Number of keys can't be greater than number of args\r\n")
+	assertReply(t, execTestCmd(db, "LMPOP", "1", "l3", "UP"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "LMPOP", "1", "l3", "LEFT", "COUNT", "0"), "-ERR count should be greater than 0\r\n")
+	assertReply(t, execTestCmd(db, "LMPOP", "1", "l3", "LEFT", "COUNT"), "-ERR syntax error\r\n")
+
+	// 回滚恢复所有可能被修改的 key
+	execTestCmd(db, "RPUSH", "l2", "a", "b", "c")
+	for _, cmdLine := range []CmdLine{
+		utils.ToCmdLine("LMPOP", "3", "l1", "l2", "l3", "RIGHT", "COUNT", "3"),
+		utils.ToCmdLine("RPOPLPUSH", "l2", "l3"),
+		utils.ToCmdLine("RPOPLPUSH", "l2", "l2"),
+		utils.ToCmdLine("LMOVE", "l3", "l1", "LEFT", "RIGHT"),
+	} {
+		undo := db.GetUndoLogs(cmdLine)
+		db.Exec(nil, cmdLine)
+		for _, line := range undo {
+			db.Exec(nil, line)
+		}
+		assertReply(t, execTestCmd(db, "LRANGE", "l2", "0", "-1"), "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n")
+		assertReply(t, execTestCmd(db, "LRANGE", "l3", "0", "-1"), "*1\r\n$1\r\nx\r\n")
+		assertReply(t, execTestCmd(db, "EXISTS", "l1"), ":0\r\n")
+	}
+}
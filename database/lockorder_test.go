@@ -0,0 +1,98 @@
+//go:build lockorder
+
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+// go test -tags lockorder ./database/ -run TestLockOrder
+// 并发执行多 key 命令、事务、发布订阅、阻塞命令、跨 DB 的 COPY、SWAPDB、过期和 aof 重写，这些路径上不能出现可能死锁的加锁顺序
+func TestLockOrderHotPaths(t *testing.T) {
+	lockorder.Reset()
+	var mu sync.Mutex
+	var violations []*lockorder.Violation
+	prev := lockorder.SetViolationHandler(func(v *lockorder.Violation) {
+		mu.Lock()
+		defer mu.Unlock()
+		violations = append(violations, v)
+	})
+	defer lockorder.SetViolationHandler(prev)
+
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "everysec",
+		Databases:      16,
+	})
+	defer server.Close()
+
+	subscriber := connection.NewFakeConn()
+	server.Exec(subscriber, utils.ToCmdLine("SUBSCRIBE", "ch0", "ch1", "ch2"))
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			conn := connection.NewFakeConn()
+			exec := func(args ...string) {
+				server.Exec(conn, utils.ToCmdLine(args...))
+			}
+			for i := 0; i < 50; i++ {
+				a := "k" + strconv.Itoa((w+i)%10)
+				b := "k" + strconv.Itoa((w*7+i)%10)
+				exec("MSET", a, "1", b, "2")
+				exec("MGET", b, a)
+				exec("RENAME", a, b)
+				exec("SET", a, "x", "PX", "1")
+				exec("RPUSH", "list"+strconv.Itoa(i%3), a)
+				exec("BLPOP", "list"+strconv.Itoa((i+1)%3), "0.001")
+				exec("SUNIONSTORE", "set", b, a)
+				exec("MULTI")
+				exec("INCR", "counter")
+				exec("LMOVE", "list0", "list1", "LEFT", "RIGHT")
+				exec("EXEC")
+				exec("PUBLISH", "ch"+strconv.Itoa(i%3), a)
+				exec("COPY", a, "copied", "DB", "1", "REPLACE")
+				exec("SELECT", "1")
+				exec("COPY", "copied", b, "DB", "0", "REPLACE")
+				exec("SELECT", "0")
+				if i%10 == 0 {
+					exec("SUBSCRIBE", "ch"+strconv.Itoa(w%3))
+					exec("UNSUBSCRIBE")
+					exec("KEYS", "*")
+					exec("SCAN", "0")
+				}
+			}
+		}(w)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conn := connection.NewFakeConn()
+		for i := 0; i < 3; i++ {
+			server.Exec(conn, utils.ToCmdLine("SWAPDB", "0", "1"))
+			server.Exec(conn, utils.ToCmdLine("REWRITEAOF"))
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	wg.Wait()
+	// 等待过期任务执行
+	time.Sleep(50 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, v := range violations {
+		t.Errorf("%v\n%s\nprevious:\n%s", v, v.Stack, v.Previous)
+	}
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/lib/metrics"
+)
+
+// 命令统计和 Prometheus 指标
+// 每个命令的调用次数和耗时直方图用于 INFO commandstats 和 /metrics，表在启动时按命令表创建，之后只读，
+// 不认识的命令不统计，避免客户端发送任意的命令名让指标无限增长。阻塞命令与慢日志相同只计调用次数，耗时主要是等待数据
+
+// aof 重写耗时的桶(秒)
+var aofRewriteBuckets = []float64{0.1, 0.5, 1, 5, 10, 30, 60, 300}
+
+type commandStats struct {
+	commands map[string]*metrics.DurationHistogram
+	// 阻塞命令的调用次数，不进入直方图
+	blockingCalls map[string]*atomic.Int64
+	aofRewrites   *metrics.DurationHistogram
+}
+
+func makeCommandStats() *commandStats {
+	stats := &commandStats{
+		commands:      make(map[string]*metrics.DurationHistogram),
+		blockingCalls: make(map[string]*atomic.Int64),
+		aofRewrites:   metrics.NewDurationHistogram(aofRewriteBuckets),
+	}
+	for _, table := range []map[string]*command{cmdTable, serverCmdTable} {
+		for name := range table {
+			if isBlockingCommand(name) {
+				stats.blockingCalls[name] = &atomic.Int64{}
+			} else {
+				stats.commands[name] = metrics.NewDurationHistogram(metrics.DefaultLatencyBuckets)
+			}
+		}
+	}
+	return stats
+}
+
+// recordCommandStats 记录一次命令的耗时
+func (server *Server) recordCommandStats(cmdLine [][]byte, start time.Time) {
+	if server.cmdStats == nil || len(cmdLine) == 0 {
+		return
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if histogram := server.cmdStats.commands[cmdName]; histogram != nil {
+		histogram.Observe(time.Since(start))
+	} else if counter := server.cmdStats.blockingCalls[cmdName]; counter != nil {
+		counter.Add(1)
+	}
+}
+
+func (server *Server) recordAofRewrite(start time.Time) {
+	if server.cmdStats != nil {
+		server.cmdStats.aofRewrites.Observe(time.Since(start))
+	}
+}
+
+// commandCalls 返回调用过的命令的调用次数和总耗时，按命令名排序
+func (stats *commandStats) commandCalls() ([]string, map[string]int64, map[string]time.Duration) {
+	calls := make(map[string]int64)
+	durations := make(map[string]time.Duration)
+	for name, histogram := range stats.commands {
+		if count := histogram.Count(); count > 0 {
+			calls[name] = count
+			durations[name] = histogram.Sum()
+		}
+	}
+	for name, counter := range stats.blockingCalls {
+		if count := counter.Load(); count > 0 {
+			calls[name] = count
+		}
+	}
+	names := make([]string, 0, len(calls))
+	for name := range calls {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, calls, durations
+}
+
+// commandStatsInfo INFO commandstats
+func (server *Server) commandStatsInfo() string {
+	var sb strings.Builder
+	sb.WriteString("# Commandstats\r\n")
+	if server.cmdStats == nil {
+		return sb.String()
+	}
+	names, calls, durations := server.cmdStats.commandCalls()
+	for _, name := range names {
+		usec := durations[name].Microseconds()
+		fmt.Fprintf(&sb, "cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n",
+			name, calls[name], usec, float64(usec)/float64(calls[name]))
+	}
+	return sb.String()
+}
+
+// CollectMetrics returns command latencies, clients, keyspace sizes and persistence metrics for /metrics
+func (server *Server) CollectMetrics() []metrics.Family {
+	var families []metrics.Family
+	if server.cmdStats != nil {
+		names, calls, _ := server.cmdStats.commandCalls()
+		callSamples := make([]metrics.Sample, 0, len(names))
+		var latencySamples []metrics.Sample
+		for _, name := range names {
+			label := metrics.Label{Name: "cmd", Value: name}
+			callSamples = append(callSamples, metrics.Sample{Labels: []metrics.Label{label}, Value: float64(calls[name])})
+			if histogram := server.cmdStats.commands[name]; histogram != nil {
+				latencySamples = append(latencySamples, histogram.Samples(label)...)
+			}
+		}
+		families = append(families,
+			metrics.Family{Name: "redis_commands_total", Help: "Number of calls of each command.",
+				Type: metrics.Counter, Samples: callSamples},
+			metrics.Family{Name: "redis_command_duration_seconds", Help: "Execution time of commands, blocking commands excluded.",
+				Type: metrics.Histogram, Samples: latencySamples},
+			metrics.Family{Name: "redis_aof_rewrite_duration_seconds", Help: "Duration of AOF rewrites.",
+				Type: metrics.Histogram, Samples: server.cmdStats.aofRewrites.Samples()})
+	}
+
+	connected, blocked := 0, 0
+	server.clients.Range(func(key, value any) bool {
+		connected++
+		return true
+	})
+	server.blockedConns.Range(func(key, value any) bool {
+		blocked++
+		return true
+	})
+	families = append(families,
+		gaugeFamily("redis_connected_clients", "Number of client connections.", float64(connected)),
+		gaugeFamily("redis_blocked_clients", "Number of clients waiting in blocking commands.", float64(blocked)))
+
+	var keySamples, expireSamples []metrics.Sample
+	for i := range server.dbSet {
+		db := server.mustSelectDB(i)
+		keys, expires := db.data.Len(), db.ttlMap.Len()
+		if keys == 0 {
+			continue
+		}
+		label := []metrics.Label{{Name: "db", Value: "db" + strconv.Itoa(i)}}
+		keySamples = append(keySamples, metrics.Sample{Labels: label, Value: float64(keys)})
+		expireSamples = append(expireSamples, metrics.Sample{Labels: label, Value: float64(expires)})
+	}
+	families = append(families,
+		metrics.Family{Name: "redis_db_keys", Help: "Number of keys in each database.", Type: metrics.Gauge, Samples: keySamples},
+		metrics.Family{Name: "redis_db_keys_expiring", Help: "Number of keys with an expiration in each database.",
+			Type: metrics.Gauge, Samples: expireSamples})
+
+	if server.persister != nil || server.partitions != nil {
+		var pending int64
+		if server.persister != nil {
+			pending = server.persister.PendingCommands()
+		} else {
+			pending = server.partitions.PendingCommands()
+		}
+		families = append(families,
+			gaugeFamily("redis_aof_buffer_commands", "Commands accepted by the AOF but not written to the file yet.", float64(pending)))
+	}
+	rewriting := 0.0
+	if server.aofRewriting.Load() > 0 {
+		rewriting = 1
+	}
+	families = append(families,
+		gaugeFamily("redis_aof_rewrite_in_progress", "Whether an AOF rewrite is running.", rewriting),
+		gaugeFamily("redis_rdb_changes_since_last_save", "Number of changes since the last RDB save.",
+			float64(server.rdb.dirty.Load())))
+
+	// INFO stats 中的累计值
+	snapshot := server.StatsSnapshot()
+	for _, name := range []string{"total_commands_processed", "total_net_input_bytes", "total_net_output_bytes",
+		"expired_keys", "evicted_keys", "keyspace_hits", "keyspace_misses"} {
+		families = append(families, metrics.Family{
+			Name:    "redis_" + strings.TrimPrefix(name, "total_") + "_total",
+			Help:    "INFO stats " + name + ".",
+			Type:    metrics.Counter,
+			Samples: []metrics.Sample{{Value: snapshot[name]}},
+		})
+	}
+	return families
+}
+
+func gaugeFamily(name, help string, value float64) metrics.Family {
+	return metrics.Family{Name: name, Help: help, Type: metrics.Gauge, Samples: []metrics.Sample{{Value: value}}}
+}
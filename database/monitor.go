@@ -0,0 +1,124 @@
+package database
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// MONITOR
+// 执行 MONITOR 的连接加入 monitors，之后服务器收到的每条命令都以状态回复的格式发给它:
+//
+//	+1339518083.107412 [0 127.0.0.1:60866] "set" "k" "v"
+//
+// 依次是 unix 时间(微秒)、连接选择的 db、连接的地址和命令参数。命令在执行之前发送，事务中的命令在排队时发送；
+// 未知命令、带有 admin 或 skip_monitor 标志的命令(AUTH/HELLO 的参数包含密码)不发送。
+// 写入失败的连接移出 monitors，连接断开时同样移出
+
+type monitorRegistry struct {
+	// redis.Connection -> struct{}
+	conns sync.Map
+	// 没有 monitor 时 Exec 不需要格式化命令
+	count atomic.Int64
+}
+
+func (m *monitorRegistry) add(c redis.Connection) {
+	if _, loaded := m.conns.LoadOrStore(c, struct{}{}); !loaded {
+		m.count.Add(1)
+	}
+}
+
+func (m *monitorRegistry) remove(c redis.Connection) {
+	if _, loaded := m.conns.LoadAndDelete(c); loaded {
+		m.count.Add(-1)
+	}
+}
+
+// execMonitor 先回复 OK 再加入 monitors，保证 OK 在第一条命令之前
+func (server *Server) execMonitor(c redis.Connection) redis.Reply {
+	if c == nil {
+		return protocol.MakeErrReply("ERR MONITOR requires a client connection")
+	}
+	if c.InMultiState() {
+		return rejectInMulti(c, "monitor")
+	}
+	if c.IsSlave() {
+		return protocol.MakeErrReply("ERR Replica can't be a monitor")
+	}
+	if _, err := c.Write(protocol.MakeOkReply().ToBytes()); err != nil {
+		return &protocol.NoReply{}
+	}
+	server.monitors.add(c)
+	return &protocol.NoReply{}
+}
+
+// feedMonitors 把 c 执行的命令发送给所有 monitor
+func (server *Server) feedMonitors(c redis.Connection, cmdLine [][]byte) {
+	if server.monitors.count.Load() == 0 || c == nil || len(cmdLine) == 0 {
+		return
+	}
+	cmd, ok := lookupCommand(strings.ToLower(string(cmdLine[0])))
+	if !ok || skipMonitor(cmd) {
+		return
+	}
+	line := formatMonitorLine(time.Now(), c.GetDBIndex(), c.RemoteAddr(), cmdLine)
+	server.monitors.conns.Range(func(key, _ any) bool {
+		monitor := key.(redis.Connection)
+		if _, err := monitor.Write(line); err != nil {
+			server.monitors.remove(monitor)
+		}
+		return true
+	})
+}
+
+func skipMonitor(cmd *command) bool {
+	if cmd.categories&aclAdmin != 0 {
+		return true
+	}
+	return cmd.extra != nil && slices.Contains(cmd.extra.signs, redisFlagSkipMonitor)
+}
+
+func formatMonitorLine(now time.Time, dbIndex int, addr string, cmdLine [][]byte) []byte {
+	micros := now.UnixMicro()
+	buf := fmt.Appendf(nil, "+%d.%06d [%d %s]", micros/1e6, micros%1e6, dbIndex, addr)
+	for _, arg := range cmdLine {
+		buf = append(buf, ' ')
+		buf = appendRepr(buf, arg)
+	}
+	return append(buf, protocol.CRLF...)
+}
+
+// appendRepr 与 redis 的 sdscatrepr 相同，用双引号包围参数并转义引号、反斜杠和不可打印的字符
+func appendRepr(buf []byte, arg []byte) []byte {
+	const hex = "0123456789abcdef"
+	buf = append(buf, '"')
+	for _, b := range arg {
+		switch b {
+		case '\\', '"':
+			buf = append(buf, '\\', b)
+		case '\n':
+			buf = append(buf, '\\', 'n')
+		case '\r':
+			buf = append(buf, '\\', 'r')
+		case '\t':
+			buf = append(buf, '\\', 't')
+		case '\a':
+			buf = append(buf, '\\', 'a')
+		case '\b':
+			buf = append(buf, '\\', 'b')
+		default:
+			if b >= 0x20 && b < 0x7f {
+				buf = append(buf, b)
+			} else {
+				buf = append(buf, '\\', 'x', hex[b>>4], hex[b&0xf])
+			}
+		}
+	}
+	return append(buf, '"')
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestMonitor(t *testing.T) {
+	server := NewStandaloneServer()
+	defer server.Close()
+	monitor := connection.NewFakeConn()
+	conn := connection.NewFakeConn()
+	server.Exec(monitor, utils.ToCmdLine("MONITOR"))
+	if got := string(monitor.Bytes()); got != "+OK\r\n" {
+		t.Fatalf("expected +OK, got %q", got)
+	}
+	monitor.Clean()
+
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "a \"b\"\n\x01"))
+	// 管理命令、AUTH 和未知命令不发送
+	server.Exec(conn, utils.ToCmdLine("CONFIG", "GET", "save"))
+	server.Exec(conn, utils.ToCmdLine("AUTH", "secret"))
+	server.Exec(conn, utils.ToCmdLine("NOSUCHCMD"))
+	server.Exec(conn, utils.ToCmdLine("GET", "k"))
+
+	lines := strings.Split(strings.TrimSuffix(string(monitor.Bytes()), "\r\n"), "\r\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 lines, got %q", lines)
+	}
+	pattern := regexp.MustCompile(`^\+(\d+)\.\d{6} \[(\d+) \] (.*)$`)
+	expected := []struct{ db, args string }{
+		{"0", `"SELECT" "1"`},
+		{"1", `"SET" "k" "a \"b\"\n\x01"`},
+		{"1", `"GET" "k"`},
+	}
+	for i, line := range lines {
+		m := pattern.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("malformed line %q", line)
+		}
+		if m[2] != expected[i].db || m[3] != expected[i].args {
+			t.Errorf("line %d: expected db %s args %s, got %q", i, expected[i].db, expected[i].args, line)
+		}
+		if sec, _ := strconv.ParseInt(m[1], 10, 64); time.Since(time.Unix(sec, 0)) > time.Minute {
+			t.Errorf("unexpected timestamp in %q", line)
+		}
+	}
+
+	// 断开之后不再发送
+	server.AfterClientClose(monitor)
+	monitor.Clean()
+	server.Exec(conn, utils.ToCmdLine("GET", "k"))
+	if got := monitor.Bytes(); len(got) != 0 {
+		t.Fatalf("closed monitor should not receive commands, got %q", got)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MULTI")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MONITOR")), "-ERR command 'monitor' cannot be used in MULTI\r\n")
+}
@@ -0,0 +1,364 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/pubhub"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// keyspace 通知
+// 与 redis 的 notify-keyspace-events 配置相同: K 表示发布到 __keyspace@<db>__:<key>，
+// E 表示发布到 __keyevent@<db>__:<event>，其余字符选择需要通知的事件类型
+
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x
+	notifyEvicted              // e
+	notifyStream               // t
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet |
+		notifyExpired | notifyEvicted | notifyStream // A
+)
+
+// parseNotifyFlags 解析 notify-keyspace-events，遇到未知字符时返回 false
+func parseNotifyFlags(s string) (int, bool) {
+	flags := 0
+	for _, c := range s {
+		switch c {
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 't':
+			flags |= notifyStream
+		case 'A':
+			flags |= notifyAll
+		default:
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// notifyKeyspaceEvent 发布事件，没有开启对应类型的通知时什么也不做
+func (server *Server) notifyKeyspaceEvent(dbIndex int, class int, event string, key string) {
+	flags := int(server.notifyFlags.Load())
+	if flags&class == 0 {
+		return
+	}
+	db := strconv.Itoa(dbIndex)
+	if flags&notifyKeyspace != 0 {
+		pubhub.Publish(server.hub, [][]byte{[]byte("__keyspace@" + db + "__:" + key), []byte(event)})
+	}
+	if flags&notifyKeyevent != 0 {
+		pubhub.Publish(server.hub, [][]byte{[]byte("__keyevent@" + db + "__:" + strings.ToLower(event)), []byte(key)})
+	}
+}
+
+// notify 在 db 所属的实例上发布事件，临时数据库没有 notifier。
+// 命令执行期间推迟到释放锁之后发布
+func (db *DB) notify(class int, event string, key string) {
+	if db.pendingCommit != nil {
+		db.pendingCommit.inner = append(db.pendingCommit.inner, keyEvent{class, event, key})
+		return
+	}
+	if db.notifier != nil {
+		db.notifier(db.getIndex(), class, event, key)
+	}
+}
+
+// deleteIfEmpty 集合中最后一个元素被删除后删除 key，避免留下空的 list/hash/set/zset
+// 所有删除集合元素的写命令在修改完成后调用
+func (db *DB) deleteIfEmpty(key string, collection interface{}) bool {
+	if objectLen(collection) > 0 {
+		return false
+	}
+	db.Remove(key)
+	db.notify(notifyGeneric, "del", key)
+	return true
+}
+
+/* ---- 写命令的通知 ---- */
+
+// keyEvent 是一条待发布的通知
+type keyEvent struct {
+	class int
+	event string
+	key   string
+}
+
+// commandEvent 描述写命令修改数据后发布的通知
+// events 在执行命令之前、持有 key 的锁时调用，可以根据当前的数据决定事件；
+// changed 根据回复判断命令是否真的修改了数据，为 nil 时只要不是错误回复就发布
+type commandEvent struct {
+	events  func(db *DB, args [][]byte) []keyEvent
+	changed func(args [][]byte, reply redis.Reply) bool
+}
+
+// pendingEvents 是执行命令前计算出的通知
+type pendingEvents struct {
+	args    [][]byte
+	events  []keyEvent
+	changed func(args [][]byte, reply redis.Reply) bool
+}
+
+// prepareEvents 在执行命令前计算通知，没有开启通知或者不是写命令时返回 nil
+func (db *DB) prepareEvents(cmdName string, args [][]byte) *pendingEvents {
+	if db.notifier == nil {
+		return nil
+	}
+	ce, ok := commandEvents[cmdName]
+	if !ok || len(args) == 0 {
+		return nil
+	}
+	return &pendingEvents{
+		args:    args,
+		events:  ce.events(db, args),
+		changed: ce.changed,
+	}
+}
+
+// changedEvents 命令修改了数据时返回需要发布的通知
+func (pending *pendingEvents) changedEvents(reply redis.Reply) []keyEvent {
+	if pending == nil || protocol.IsErrorReply(reply) {
+		return nil
+	}
+	if pending.changed != nil && !pending.changed(pending.args, reply) {
+		return nil
+	}
+	return pending.events
+}
+
+// replyChanged 回复为 nil、空数组或者不大于 0 的整数时表示没有修改
+func replyChanged(args [][]byte, reply redis.Reply) bool {
+	switch r := reply.(type) {
+	case *protocol.NullBulkReply, *protocol.NullMultiBulkReply, *protocol.EmptyMultiBulkReply:
+		return false
+	case *protocol.IntReply:
+		return r.Code > 0
+	}
+	return true
+}
+
+// onKey 在第一个 key 上发布 event
+func onKey(class int, event string) func(db *DB, args [][]byte) []keyEvent {
+	return func(db *DB, args [][]byte) []keyEvent {
+		return []keyEvent{{class, event, string(args[0])}}
+	}
+}
+
+// existingKeys 只在执行前存在的 key 上发布 event，用于 DEL 等多个 key 的命令
+func existingKeys(class int, event string) func(db *DB, args [][]byte) []keyEvent {
+	return func(db *DB, args [][]byte) []keyEvent {
+		var events []keyEvent
+		for _, arg := range args {
+			if _, ok := db.data.GetLocked(string(arg)); ok {
+				events = append(events, keyEvent{class, event, string(arg)})
+			}
+		}
+		return events
+	}
+}
+
+// moveEvents RPOPLPUSH/LMOVE 等命令在 source 上发布 pop，在 destination 上发布 push
+func moveEvents(db *DB, args [][]byte) []keyEvent {
+	fromLeft, toLeft := false, true
+	if len(args) >= 4 {
+		fromLeft, _ = parseListSide(args[2])
+		toLeft, _ = parseListSide(args[3])
+	}
+	pop, push := "rpop", "rpush"
+	if fromLeft {
+		pop = "lpop"
+	}
+	if toLeft {
+		push = "lpush"
+	}
+	return []keyEvent{{notifyList, pop, string(args[0])}, {notifyList, push, string(args[1])}}
+}
+
+// blockingPopEvents BLPOP/BRPOP/BZPOPMIN/BZPOPMAX 弹出第一个非空的 key，弹空时 key 已经被删除
+func blockingPopEvents(class int, event string) func(db *DB, args [][]byte) []keyEvent {
+	return func(db *DB, args [][]byte) []keyEvent {
+		for _, arg := range args[:len(args)-1] {
+			if _, ok := db.data.GetLocked(string(arg)); ok {
+				return []keyEvent{{class, event, string(arg)}}
+			}
+		}
+		return nil
+	}
+}
+
+func renameEvents(db *DB, args [][]byte) []keyEvent {
+	return []keyEvent{{notifyGeneric, "rename_from", string(args[0])}, {notifyGeneric, "rename_to", string(args[1])}}
+}
+
+// msetEvents MSET/MSETNX 在每个 key 上发布 set
+func msetEvents(db *DB, args [][]byte) []keyEvent {
+	var events []keyEvent
+	for i := 0; i+1 < len(args); i += 2 {
+		events = append(events, keyEvent{notifyString, "set", string(args[i])})
+	}
+	return events
+}
+
+// setExEvents SETEX/PSETEX 同时设置值和过期时间
+func setExEvents(db *DB, args [][]byte) []keyEvent {
+	key := string(args[0])
+	return []keyEvent{{notifyString, "set", key}, {notifyGeneric, "expire", key}}
+}
+
+// setEvents SET 带有 EX/PX 等选项时还会发布 expire
+func setEvents(db *DB, args [][]byte) []keyEvent {
+	key := string(args[0])
+	events := []keyEvent{{notifyString, "set", key}}
+	for _, arg := range args[2:] {
+		switch strings.ToLower(string(arg)) {
+		case "ex", "px", "exat", "pxat":
+			events = append(events, keyEvent{notifyGeneric, "expire", key})
+		}
+	}
+	return events
+}
+
+// setChanged SET 的 NX/XX 条件不满足时回复 nil，带 GET 时回复的是旧值，视为修改
+func setChanged(args [][]byte, reply redis.Reply) bool {
+	for _, arg := range args[2:] {
+		if strings.ToLower(string(arg)) == "get" {
+			return true
+		}
+	}
+	return replyChanged(args, reply)
+}
+
+// getExEvents GETEX 只有带过期选项时才修改 key
+func getExEvents(db *DB, args [][]byte) []keyEvent {
+	key := string(args[0])
+	for _, arg := range args[1:] {
+		switch strings.ToLower(string(arg)) {
+		case "ex", "px", "exat", "pxat":
+			return []keyEvent{{notifyGeneric, "expire", key}}
+		case "persist":
+			return []keyEvent{{notifyGeneric, "persist", key}}
+		}
+	}
+	return nil
+}
+
+// commandEvents 与 redis 各命令发布的事件名称相同
+var commandEvents = map[string]*commandEvent{
+	// generic
+	"del":       {existingKeys(notifyGeneric, "del"), replyChanged},
+	"unlink":    {existingKeys(notifyGeneric, "del"), replyChanged},
+	"expire":    {onKey(notifyGeneric, "expire"), replyChanged},
+	"pexpire":   {onKey(notifyGeneric, "expire"), replyChanged},
+	"expireat":  {onKey(notifyGeneric, "expire"), replyChanged},
+	"pexpireat": {onKey(notifyGeneric, "expire"), replyChanged},
+	"persist":   {onKey(notifyGeneric, "persist"), replyChanged},
+	"rename":    {renameEvents, nil},
+	"renamenx":  {renameEvents, replyChanged},
+	"copy": {func(db *DB, args [][]byte) []keyEvent {
+		return []keyEvent{{notifyGeneric, "copy_to", string(args[1])}}
+	}, replyChanged},
+	"restore": {onKey(notifyGeneric, "restore"), nil},
+
+	// string
+	"set":         {setEvents, setChanged},
+	"setnx":       {onKey(notifyString, "set"), replyChanged},
+	"setex":       {setExEvents, nil},
+	"psetex":      {setExEvents, nil},
+	"mset":        {msetEvents, nil},
+	"msetnx":      {msetEvents, replyChanged},
+	"getset":      {onKey(notifyString, "set"), nil},
+	"getdel":      {onKey(notifyGeneric, "del"), replyChanged},
+	"getex":       {getExEvents, replyChanged},
+	"incr":        {onKey(notifyString, "incrby"), nil},
+	"incrby":      {onKey(notifyString, "incrby"), nil},
+	"decr":        {onKey(notifyString, "incrby"), nil},
+	"decrby":      {onKey(notifyString, "incrby"), nil},
+	"incrbyfloat": {onKey(notifyString, "incrbyfloat"), nil},
+	"append":      {onKey(notifyString, "append"), nil},
+	"setrange":    {onKey(notifyString, "setrange"), nil},
+	"setbit":      {onKey(notifyString, "setbit"), nil},
+	"bitop": {func(db *DB, args [][]byte) []keyEvent {
+		return []keyEvent{{notifyString, "set", string(args[1])}}
+	}, replyChanged},
+
+	// list
+	"lpush":      {onKey(notifyList, "lpush"), nil},
+	"rpush":      {onKey(notifyList, "rpush"), nil},
+	"lpushx":     {onKey(notifyList, "lpush"), replyChanged},
+	"rpushx":     {onKey(notifyList, "rpush"), replyChanged},
+	"lpop":       {onKey(notifyList, "lpop"), replyChanged},
+	"rpop":       {onKey(notifyList, "rpop"), replyChanged},
+	"blpop":      {blockingPopEvents(notifyList, "lpop"), replyChanged},
+	"brpop":      {blockingPopEvents(notifyList, "rpop"), replyChanged},
+	"rpoplpush":  {moveEvents, replyChanged},
+	"brpoplpush": {moveEvents, replyChanged},
+	"lmove":      {moveEvents, replyChanged},
+	"blmove":     {moveEvents, replyChanged},
+	"lrem":       {onKey(notifyList, "lrem"), replyChanged},
+	"lset":       {onKey(notifyList, "lset"), nil},
+	"ltrim":      {onKey(notifyList, "ltrim"), nil},
+	"linsert":    {onKey(notifyList, "linsert"), replyChanged},
+
+	// hash
+	"hset":    {onKey(notifyHash, "hset"), nil},
+	"hmset":   {onKey(notifyHash, "hset"), nil},
+	"hsetnx":  {onKey(notifyHash, "hset"), replyChanged},
+	"hdel":    {onKey(notifyHash, "hdel"), replyChanged},
+	"hincrby": {onKey(notifyHash, "hincrby"), nil},
+
+	// set
+	"sadd":        {onKey(notifySet, "sadd"), replyChanged},
+	"srem":        {onKey(notifySet, "srem"), replyChanged},
+	"spop":        {onKey(notifySet, "spop"), replyChanged},
+	"sinterstore": {onKey(notifySet, "sinterstore"), replyChanged},
+	"sunionstore": {onKey(notifySet, "sunionstore"), replyChanged},
+	"sdiffstore":  {onKey(notifySet, "sdiffstore"), replyChanged},
+
+	// sorted set
+	"zadd":             {onKey(notifyZSet, "zadd"), nil},
+	"zincrby":          {onKey(notifyZSet, "zincr"), nil},
+	"zrem":             {onKey(notifyZSet, "zrem"), replyChanged},
+	"zpopmin":          {onKey(notifyZSet, "zpopmin"), replyChanged},
+	"zpopmax":          {onKey(notifyZSet, "zpopmax"), replyChanged},
+	"bzpopmin":         {blockingPopEvents(notifyZSet, "zpopmin"), replyChanged},
+	"bzpopmax":         {blockingPopEvents(notifyZSet, "zpopmax"), replyChanged},
+	"zremrangebyscore": {onKey(notifyZSet, "zremrangebyscore"), replyChanged},
+	"zremrangebyrank":  {onKey(notifyZSet, "zremrangebyrank"), replyChanged},
+	"zremrangebylex":   {onKey(notifyZSet, "zremrangebylex"), replyChanged},
+	"geoadd":           {onKey(notifyZSet, "zadd"), nil},
+	"geosearchstore":   {onKey(notifyZSet, "geosearchstore"), replyChanged},
+
+	// stream
+	"xadd":   {onKey(notifyStream, "xadd"), nil},
+	"xtrim":  {onKey(notifyStream, "xtrim"), replyChanged},
+	"xsetid": {onKey(notifyStream, "xsetid"), nil},
+}
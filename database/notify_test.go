@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestDeleteEmptyCollection(t *testing.T) {
+	db := makeTestDB()
+	tests := []struct {
+		setup  []string
+		remove []string
+	}{
+		{[]string{"RPUSH", "k", "a", "b"}, []string{"LPOP", "k", "2"}},
+		{[]string{"RPUSH", "k", "a"}, []string{"RPOP", "k"}},
+		{[]string{"RPUSH", "k", "a", "a"}, []string{"LREM", "k", "0", "a"}},
+		{[]string{"RPUSH", "k", "a", "b"}, []string{"LTRIM", "k", "5", "10"}},
+		{[]string{"RPUSH", "k", "a"}, []string{"RPOPLPUSH", "k", "other"}},
+		{[]string{"SADD", "k", "a", "b"}, []string{"SREM", "k", "a", "b"}},
+		{[]string{"SADD", "k", "a", "b"}, []string{"SPOP", "k", "5"}},
+		{[]string{"HSET", "k", "f", "v"}, []string{"HDEL", "k", "f"}},
+		{[]string{"ZADD", "k", "1", "a"}, []string{"ZREM", "k", "a"}},
+		{[]string{"ZADD", "k", "1", "a"}, []string{"ZPOPMIN", "k"}},
+		{[]string{"ZADD", "k", "1", "a"}, []string{"ZREMRANGEBYSCORE", "k", "0", "2"}},
+		{[]string{"ZADD", "k", "1", "a"}, []string{"ZREMRANGEBYRANK", "k", "0", "-1"}},
+		{[]string{"ZADD", "k", "0", "a"}, []string{"ZREMRANGEBYLEX", "k", "-", "+"}},
+	}
+	for _, tt := range tests {
+		execTestCmd(db, tt.setup...)
+		execTestCmd(db, tt.remove...)
+		assertReply(t, execTestCmd(db, "TYPE", "k"), "+none\r\n")
+		assertReply(t, execTestCmd(db, "EXISTS", "k"), ":0\r\n")
+		execTestCmd(db, "DEL", "other")
+	}
+
+	// ZPOPMIN 不会创建空的 zset
+	execTestCmd(db, "ZPOPMIN", "missing")
+	assertReply(t, execTestCmd(db, "EXISTS", "missing"), ":0\r\n")
+
+	// 结果为空的 SINTERSTORE 删除 dest
+	execTestCmd(db, "SET", "dest", "v")
+	execTestCmd(db, "SADD", "s1", "a")
+	execTestCmd(db, "SADD", "s2", "b")
+	assertReply(t, execTestCmd(db, "SINTERSTORE", "dest", "s1", "s2"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "dest"), ":0\r\n")
+}
+
+func TestEmptyCollectionNotification(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:            16,
+		NotifyKeyspaceEvents: "KEg",
+	})
+	conn := connection.NewFakeConn()
+	subscriber := connection.NewFakeConn()
+	server.Exec(subscriber, utils.ToCmdLine("SUBSCRIBE", "__keyevent@0__:del", "__keyspace@0__:s"))
+	subscriber.Clean()
+
+	server.Exec(conn, utils.ToCmdLine("SADD", "s", "a", "b"))
+	server.Exec(conn, utils.ToCmdLine("SREM", "s", "a"))
+	if actual := string(subscriber.Bytes()); actual != "" {
+		t.Fatalf("unexpected notification %q", actual)
+	}
+	server.Exec(conn, utils.ToCmdLine("SREM", "s", "b"))
+	expected := "*3\r\n$7\r\nmessage\r\n$16\r\n__keyspace@0__:s\r\n$3\r\ndel\r\n" +
+		"*3\r\n$7\r\nmessage\r\n$18\r\n__keyevent@0__:del\r\n$1\r\ns\r\n"
+	if actual := string(subscriber.Bytes()); actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+}
+
+func TestCommandNotification(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:            16,
+		NotifyKeyspaceEvents: "E$glsx",
+	})
+	defer server.Close()
+	var channels []string
+	for _, event := range []string{"set", "expire", "expired", "del", "sadd", "rename_from", "rename_to", "incrby", "lpush", "lpop"} {
+		channels = append(channels, "__keyevent@0__:"+event)
+	}
+	messages, err := server.Subscribe(context.Background(), channels...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := connection.NewFakeConn()
+	for _, cmd := range [][]string{
+		{"SET", "k", "v", "PX", "100"},
+		{"SADD", "s", "a"},
+		{"SADD", "s", "a"}, // 没有修改
+		{"SET", "k2", "v", "NX"},
+		{"SET", "k2", "v", "NX"}, // 没有修改
+		{"RENAME", "k2", "k3"},
+		{"DEL", "k3", "missing"},
+		{"MULTI"}, {"INCR", "c"}, {"LPUSH", "l", "a", "b"}, {"EXEC"},
+		// 回滚的事务不发布通知
+		{"MULTI"}, {"LPUSH", "l", "c"}, {"INCR", "l"}, {"EXEC"},
+		{"LPOP", "l"},
+		{"LPOP", "missing"},
+	} {
+		server.Exec(conn, utils.ToCmdLine(cmd...))
+	}
+	expected := [][2]string{
+		{"set", "k"}, {"expire", "k"},
+		{"sadd", "s"},
+		{"set", "k2"},
+		{"rename_from", "k2"}, {"rename_to", "k3"},
+		{"del", "k3"},
+		{"incrby", "c"}, {"lpush", "l"},
+		{"lpop", "l"},
+		{"expired", "k"},
+	}
+	for _, e := range expected {
+		select {
+		case msg := <-messages:
+			if msg.Channel != "__keyevent@0__:"+e[0] || string(msg.Payload) != e[1] {
+				t.Fatalf("expected %v, got %s %s", e, msg.Channel, msg.Payload)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("timeout waiting for %v", e)
+		}
+	}
+}
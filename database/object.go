@@ -0,0 +1,157 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/zhangming/go-redis/datastruct/dict"
+	"github.com/zhangming/go-redis/datastruct/list"
+	"github.com/zhangming/go-redis/datastruct/set"
+	"github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/datastruct/sparse"
+	"github.com/zhangming/go-redis/datastruct/stream"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// OBJECT ENCODING | REFCOUNT | IDLETIME | FREQ key
+// 访问时间和 LFU 计数器由 GetEntity 在每次访问时更新(见 eviction.go)，
+// 不论使用哪种淘汰策略都会记录，所以 IDLETIME 和 FREQ 总是可用。查看元数据本身不算一次访问
+
+var objectHelp = []string{
+	"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+	"ENCODING <key>",
+	"    Return the kind of internal representation used in order to store the value",
+	"    associated with a <key>.",
+	"FREQ <key>",
+	"    Return the access frequency index of the <key>. The returned integer is",
+	"    proportional to the logarithm of the recent access frequency of the key.",
+	"IDLETIME <key>",
+	"    Return the idle time of the <key>, that is the approximated number of",
+	"    seconds elapsed since the last access to the key.",
+	"REFCOUNT <key>",
+	"    Return the number of references of the value associated with the specified",
+	"    <key>.",
+	"HELP",
+	"    Print this help.",
+}
+
+// 与 redis 相同，不超过 44 字节的字符串是 embstr
+const embstrSizeLimit = 44
+
+// objectEncoding 返回与 redis 对应的编码名称
+func objectEncoding(entity *database.DataEntity) string {
+	switch data := entity.Data.(type) {
+	case []byte:
+		if len(data) <= 20 {
+			if _, err := strconv.ParseInt(string(data), 10, 64); err == nil {
+				return "int"
+			}
+		}
+		if len(data) <= embstrSizeLimit {
+			return "embstr"
+		}
+		return "raw"
+	case *sparse.String:
+		return "raw"
+	case *list.QuickList:
+		return "quicklist"
+	case *list.LinkedList:
+		return "linkedlist"
+	case *dict.ListPackDict:
+		if data.IsListPack() {
+			return "listpack"
+		}
+		return "hashtable"
+	case *set.Set:
+		if data.IsIntset() {
+			return "intset"
+		}
+		return "hashtable"
+	case dict.Dict:
+		return "hashtable"
+	case *sortedset.SortedSet:
+		if data.IsListPack() {
+			return "listpack"
+		}
+		return "skiplist"
+	case *stream.Stream:
+		return "stream"
+	}
+	return "unknown"
+}
+
+// makeHash 新建的对象使用所在实例配置的编码转换阈值，测试中没有配置的 DB 使用默认值
+func (db *DB) makeHash() *dict.ListPackDict {
+	if db.cfg == nil {
+		return dict.MakeListPack()
+	}
+	return dict.MakeListPackWithLimits(db.cfg.HashMaxListpackEntries, db.cfg.HashMaxListpackValue)
+}
+
+func (db *DB) makeSet() *set.Set {
+	if db.cfg == nil {
+		return set.Make()
+	}
+	return set.MakeWithMaxIntset(db.cfg.SetMaxIntsetEntries)
+}
+
+func (db *DB) makeSortedSet() *sortedset.SortedSet {
+	if db.cfg == nil {
+		return sortedset.Make()
+	}
+	return sortedset.MakeWithListPackLimits(db.cfg.ZsetMaxListpackEntries, db.cfg.ZsetMaxListpackValue)
+}
+
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		// OBJECT HELP
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+func execObject(db *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd == "help" && len(args) == 1 {
+		replies := make([]redis.Reply, 0, len(objectHelp))
+		for _, line := range objectHelp {
+			replies = append(replies, protocol.MakeStatusReply(line))
+		}
+		return protocol.MakeMultiRawReply(replies)
+	}
+	switch subCmd {
+	case "encoding", "refcount", "idletime", "freq":
+	default:
+		return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+	}
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("object|" + subCmd)
+	}
+	entity, ok := db.peekEntity(string(args[1]))
+	if !ok {
+		return protocol.MakeNullBulkReply()
+	}
+	now := time.Now()
+	lastAccess := atomic.LoadInt64(&entity.LRU)
+	switch subCmd {
+	case "encoding":
+		return protocol.MakeBulkReply([]byte(objectEncoding(entity)))
+	case "refcount":
+		// 值没有在 key 之间共享
+		return protocol.MakeIntReply(1)
+	case "idletime":
+		return protocol.MakeIntReply((now.UnixMilli() - lastAccess) / 1000)
+	default:
+		freq := lfuDecay(atomic.LoadUint32(&entity.Freq), lastAccess, now, db.lfuDecayTime())
+		return protocol.MakeIntReply(int64(freq))
+	}
+}
+
+func init() {
+	registerCommand("Object", execObject, prepareObject, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 2, 2, 1)
+}
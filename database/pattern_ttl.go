@@ -0,0 +1,193 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/lib/wildcard"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// EXPIREPATTERN pattern seconds [COUNT count] [RATE keys-per-second]
+// PERSISTPATTERN pattern [COUNT count] [RATE keys-per-second]
+// 批量设置或者去掉匹配 pattern 的 key 的过期时间，用于导入数据之后重新设置 ttl，代替客户端 KEYS + EXPIRE 的循环。
+// 和 SCAN 一样按分片分批遍历当前数据库，每批只锁这一批 key，RATE 限制每秒遍历的 key 数。
+// 命令在连接上同步执行，连接断开或者实例关闭时中止，回复已经修改的 key 数。
+// 每个 key 和 EXPIRE/PERSIST 一样写入 aof 并转发给从节点
+
+const patternTTLDefaultCount = 100
+
+type patternTTLArgs struct {
+	pattern string
+	persist bool
+	ttl     time.Duration
+	count   int
+	rate    int
+}
+
+func parsePatternTTLArgs(cmdName string, args [][]byte) (*patternTTLArgs, redis.Reply) {
+	opts := &patternTTLArgs{
+		persist: cmdName == "persistpattern",
+		count:   patternTTLDefaultCount,
+	}
+	if len(args) < 1 || (!opts.persist && len(args) < 2) {
+		return nil, protocol.MakeArgNumErrReply(cmdName)
+	}
+	opts.pattern = string(args[0])
+	if _, err := wildcard.CompilePattern(opts.pattern); err != nil {
+		return nil, protocol.MakeErrReply("ERR pattern is not a valid glob-style pattern")
+	}
+	args = args[1:]
+	if !opts.persist {
+		seconds, err := strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if seconds <= 0 || seconds > int64(time.Duration(1<<63-1)/time.Second) {
+			return nil, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		opts.ttl = time.Duration(seconds) * time.Second
+		args = args[1:]
+	}
+	for len(args) > 0 {
+		if len(args) < 2 {
+			return nil, protocol.MakeErrReply("ERR syntax error")
+		}
+		value, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToLower(string(args[0])) {
+		case "count":
+			if value < 1 {
+				return nil, protocol.MakeErrReply("ERR syntax error")
+			}
+			opts.count = value
+		case "rate":
+			if value < 0 {
+				return nil, protocol.MakeErrReply("ERR value is out of range, must be positive")
+			}
+			opts.rate = value
+		default:
+			return nil, protocol.MakeErrReply("ERR syntax error")
+		}
+		args = args[2:]
+	}
+	return opts, nil
+}
+
+// execPatternTTL 执行 EXPIREPATTERN/PERSISTPATTERN，与普通命令一样使用连接选择的 db，c 不能为 nil
+func (server *Server) execPatternTTL(c redis.Connection, cmdName string, args [][]byte) redis.Reply {
+	if c.InMultiState() {
+		return protocol.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI")
+	}
+	opts, errReply := parsePatternTTLArgs(cmdName, args)
+	if errReply != nil {
+		return errReply
+	}
+	db, selectErr := server.selectDB(c.GetDBIndex())
+	if selectErr != nil {
+		return selectErr
+	}
+	// 和阻塞命令一样登记在 blockedConns 中，连接断开时 CancelBlocking 关闭 cancel
+	cancel := make(chan struct{})
+	server.blockedConns.Store(c, cancel)
+	defer server.blockedConns.Delete(c)
+
+	start := time.Now()
+	changed, scanned := 0, 0
+	cursor := 0
+	for {
+		keys, next := db.data.DictScan(cursor, opts.count, opts.pattern)
+		changed += db.applyPatternTTL(keys, opts)
+		scanned += len(keys)
+		if next <= 0 {
+			break
+		}
+		cursor = next
+		var delay time.Duration
+		if opts.rate > 0 {
+			delay = time.Until(start.Add(time.Duration(scanned) * time.Second / time.Duration(opts.rate)))
+		}
+		if server.patternTTLAborted(cancel, delay) {
+			break
+		}
+	}
+	return protocol.MakeIntReply(int64(changed))
+}
+
+// patternTTLAborted 在两批之间等待 delay，连接断开或者实例关闭时返回 true
+func (server *Server) patternTTLAborted(cancel <-chan struct{}, delay time.Duration) bool {
+	// 开始关闭之后不再等待剩下的批次
+	if server.stopping.Load() {
+		return true
+	}
+	if delay <= 0 {
+		select {
+		case <-cancel:
+			return true
+		case <-server.shutdown:
+			return true
+		default:
+			return false
+		}
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-cancel:
+		return true
+	case <-server.shutdown:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// applyPatternTTL 修改一批 key 的过期时间，返回修改的 key 数
+func (db *DB) applyPatternTTL(rawKeys [][]byte, opts *patternTTLArgs) int {
+	if len(rawKeys) == 0 {
+		return 0
+	}
+	keys := make([]string, len(rawKeys))
+	for i, key := range rawKeys {
+		keys[i] = string(key)
+	}
+	view, log := db.beginCommit(keys)
+	changed := 0
+	db.execCommitted(keys, nil, log, func() redis.Reply {
+		changed = view.applyPatternTTLLocked(keys, opts, log)
+		db.commit(log)
+		return nil
+	})
+	return changed
+}
+
+func (db *DB) applyPatternTTLLocked(keys []string, opts *patternTTLArgs, log *commitLog) int {
+	changed := 0
+	for _, key := range keys {
+		// 遍历和加锁之间 key 可能已经被删除或者过期
+		if _, exists := db.GetEntity(key); !exists {
+			continue
+		}
+		if opts.persist {
+			if _, hasTTL := db.ExpireAt(key); !hasTTL {
+				continue
+			}
+			db.Persist(key)
+			db.addAof(utils.ToCmdLine("persist", key))
+			log.events = append(log.events, keyEvent{notifyGeneric, "persist", key})
+		} else {
+			expireAt := time.Now().Add(opts.ttl)
+			db.Expire(key, expireAt)
+			db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
+			log.events = append(log.events, keyEvent{notifyGeneric, "expire", key})
+		}
+		changed++
+	}
+	return changed
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestPatternTTL(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	for i := 0; i < 300; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "user:"+strconv.Itoa(i), "v"))
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "other", "v"))
+	server.Exec(conn, utils.ToCmdLine("SET", "tmp", "v", "EX", "100"))
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXPIREPATTERN", "user:*", "1000", "COUNT", "7")), ":300\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("TTL", "user:42")), ":1000\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("TTL", "other")), ":-1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXPIREPATTERN", "nothing*", "10")), ":0\r\n")
+
+	// 只计算原来有过期时间的 key
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("PERSISTPATTERN", "user:1*")), ":111\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("PERSISTPATTERN", "*")), ":190\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("TTL", "tmp")), ":-1\r\n")
+	server.Exec(conn, utils.ToCmdLine("EXPIREPATTERN", "user:2?", "500", "RATE", "1000"))
+	server.Close()
+
+	// 修改通过 aof 持久化
+	reloaded := NewStandaloneServerWithConfig(cfg)
+	defer reloaded.Close()
+	assertReply(t, reloaded.Exec(conn, utils.ToCmdLine("TTL", "user:42")), ":-1\r\n")
+	if ttl := reloaded.Exec(conn, utils.ToCmdLine("TTL", "user:25")).(*protocol.IntReply).Code; ttl < 490 || ttl > 500 {
+		t.Fatalf("expected ttl about 500, got %d", ttl)
+	}
+	assertReply(t, reloaded.Exec(conn, utils.ToCmdLine("TTL", "user:250")), ":-1\r\n")
+
+	for _, tt := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"EXPIREPATTERN", "a*"}, "-ERR wrong number of arguments for 'expirepattern' command\r\n"},
+		{[]string{"EXPIREPATTERN", "a*", "x"}, "-ERR value is not an integer or out of range\r\n"},
+		{[]string{"EXPIREPATTERN", "a*", "0"}, "-ERR invalid expire time in 'expirepattern' command\r\n"},
+		{[]string{"EXPIREPATTERN", "a*", "10", "COUNT"}, "-ERR syntax error\r\n"},
+		{[]string{"EXPIREPATTERN", "a*", "10", "COUNT", "0"}, "-ERR syntax error\r\n"},
+		{[]string{"PERSISTPATTERN", "a*", "RATE", "-1"}, "-ERR value is out of range, must be positive\r\n"},
+		{[]string{"PERSISTPATTERN", "a*", "LIMIT", "1"}, "-ERR syntax error\r\n"},
+		{[]string{"PERSISTPATTERN", "a[", "RATE", "1"}, "-ERR pattern is not a valid glob-style pattern\r\n"},
+	} {
+		assertReply(t, reloaded.Exec(conn, utils.ToCmdLine(tt.args...)), tt.expected)
+	}
+	reloaded.Exec(conn, utils.ToCmdLine("MULTI"))
+	assertReply(t, reloaded.Exec(conn, utils.ToCmdLine("PERSISTPATTERN", "*")),
+		"-ERR command 'persistpattern' cannot be used in MULTI\r\n")
+}
+
+func TestPatternTTLAbort(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	for i := 0; i < 1000; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "k"+strconv.Itoa(i), "v"))
+	}
+
+	// 每秒 10 个 key 需要 100 秒，断开连接后立即结束
+	job := connection.NewFakeConn()
+	reply := execAsync(server, job, "EXPIREPATTERN", "k*", "100", "COUNT", "1", "RATE", "10")
+	waitBlocked(t, server, 1)
+	start := time.Now()
+	server.AfterClientClose(job)
+	r := <-reply
+	if n := r.(*protocol.IntReply).Code; n <= 0 || n >= 1000 || time.Since(start) > time.Second {
+		t.Fatalf("expected abort after a few keys, got %d in %v", n, time.Since(start))
+	}
+
+	reply = execAsync(server, job, "PERSISTPATTERN", "k*", "COUNT", "1", "RATE", "10")
+	waitBlocked(t, server, 1)
+	server.Close()
+	if n := (<-reply).(*protocol.IntReply).Code; n >= 1000 {
+		t.Fatalf("expected abort on shutdown, got %d", n)
+	}
+}
@@ -1,26 +1,42 @@
 package database
 
 import (
+	"errors"
 	"os"
+	"sort"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/hdt3213/rdb/core"
 	rdb "github.com/hdt3213/rdb/parser"
 	"github.com/zhangming/go-redis/aof"
 	"github.com/zhangming/go-redis/config"
-	"github.com/zhangming/go-redis/datastruct/dict"
 	"github.com/zhangming/go-redis/datastruct/list"
-	"github.com/zhangming/go-redis/datastruct/set"
-	"github.com/zhangming/go-redis/datastruct/sortedset"
+	"github.com/zhangming/go-redis/datastruct/stream"
 	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
+	"github.com/zhangming/go-redis/lib/timewheel"
+	"github.com/zhangming/go-redis/pubhub"
+	"github.com/zhangming/go-redis/redis/protocol"
 )
 
 func MakeAuxiliaryServer() *Server {
-	mdb := &Server{}
-	mdb.dbSet = make([]*atomic.Value, config.Properties.Databases)
+	return makeAuxiliaryServer(config.Properties, nil)
+}
+
+// makeAuxiliaryServer 创建用于重放 aof 的临时实例，与所属实例使用相同的配置和过期任务调度器，
+// DEBUG RELOAD 把临时实例的数据库换入所属实例之后过期任务仍然在所属实例中。expires 为 nil 时使用进程共享的调度器
+func makeAuxiliaryServer(cfg *config.ServerProperties, expires *timewheel.Scheduler) *Server {
+	// 重放 aof 中的 PUBLISH ... RETAIN 需要 hub
+	mdb := &Server{cfg: cfg, hub: pubhub.MakeHub(), writeGateClass: lockorder.NewClass("server.writeGate"), expires: expires}
+	mdb.hub.SetRetainLimit(cfg.PubsubRetainMax)
+	mdb.tuning = cfg.Tuning()
+	mdb.dbSet = make([]*atomic.Value, cfg.Databases)
 	for i := range mdb.dbSet {
 		holder := &atomic.Value{}
-		holder.Store(makeBasicDB())
+		holder.Store(mdb.makeDB())
 		mdb.dbSet[i] = holder
 	}
 	return mdb
@@ -28,15 +44,94 @@ func MakeAuxiliaryServer() *Server {
 
 func (server *Server) bindPersister(persister *aof.Persister) {
 	server.persister = persister
-	// 持续写入命令
-	for _, db := range server.dbSet {
-		singleDB := db.Load().(*DB)
-		singleDB.addAof = func(line CmdLine) {
-			if config.Properties.AppendOnly { // config may be changed during runtime
-				server.persister.SaveCmdLine(singleDB.index, line)
+}
+
+// bindPartitions 分区模式下按命令第一个 key 所在的槽位写入对应分区，跨槽位的命令在执行之前已经被拒绝
+func (server *Server) bindPartitions(partitions *aof.PartitionedPersister) {
+	server.partitions = partitions
+}
+
+// cmdLineSlot 返回命令第一个 key 的槽位，没有 key 时返回 -1
+func cmdLineSlot(cmdLine CmdLine) int {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, cmdLine) {
+		return -1
+	}
+	write, read := cmd.prepare(cmdLine[1:])
+	if len(write) > 0 {
+		return int(getSlot(write[0]))
+	}
+	if len(read) > 0 {
+		return int(getSlot(read[0]))
+	}
+	return -1
+}
+
+// loadProgress 返回 aof 的加载进度，没有开启 aof 或者使用分区 aof 时返回 nil
+func (server *Server) loadProgress() *aof.LoadProgress {
+	if server.persister == nil {
+		return nil
+	}
+	return server.persister.Progress()
+}
+
+// checkLoading 加载 aof 期间只允许执行带有 loading 标志的命令，
+// aof-load-lazy 开启时，序号小于正在加载的数据库的只读命令也可以执行。
+// 重写后的 aof 按数据库序号依次写入，但重写之后追加的命令可能会再次修改已经加载完的数据库，
+// 所以这些读命令可能暂时读不到 aof 末尾的修改
+func (server *Server) checkLoading(c redis.Connection, cmdName string) redis.Reply {
+	progress := server.loadProgress()
+	if progress == nil || !progress.Loading() || progress.IsLoader(c) {
+		return nil
+	}
+	if cmd, ok := lookupCommand(cmdName); ok && cmd.extra != nil {
+		for _, sign := range cmd.extra.signs {
+			if sign == redisFlagLoading {
+				return nil
 			}
 		}
 	}
+	if server.cfg.AofLoadLazy && c != nil && !isWriteCommand(cmdName) && c.GetDBIndex() < progress.CurrentDB() {
+		return nil
+	}
+	return protocol.MakeErrReply("LOADING Redis is loading the dataset in memory")
+}
+
+// saveAof 记录写命令，DB.addAof 也通过它写入，不经过 DB 的命令(例如 FLUSHDB/FLUSHALL)直接调用
+func (server *Server) saveAof(dbIndex int, cmdLine CmdLine) {
+	server.rdb.dirty.Add(1)
+	if !server.cfg.AppendOnly { // config may be changed during runtime
+		return
+	}
+	if server.persister != nil {
+		server.persister.SaveCmdLine(dbIndex, cmdLine)
+	}
+	if server.partitions != nil {
+		server.partitions.SaveCmdLine(cmdLineSlot(cmdLine), dbIndex, cmdLine)
+	}
+}
+
+// rewriteAof 重写 aof，记录 INFO persistence 中的重写状态
+func (server *Server) rewriteAof() error {
+	server.aofRewriting.Add(1)
+	defer server.aofRewriting.Add(-1)
+	start := time.Now()
+	err := server.doRewriteAof()
+	server.aofRewriteFailed.Store(err != nil)
+	if err == nil {
+		server.recordAofRewrite(start)
+	}
+	return err
+}
+
+func (server *Server) doRewriteAof() error {
+	if server.partitions != nil {
+		return server.partitions.Rewrite()
+	}
+	if server.persister == nil {
+		return errors.New("ERR no AOF persistence")
+	}
+	return server.persister.Rewrite()
 }
 
 func NewPersister(db database.DBEngine, filename string, load bool, fsync string) (*aof.Persister, error) {
@@ -45,78 +140,150 @@ func NewPersister(db database.DBEngine, filename string, load bool, fsync string
 	})
 }
 
+// newPersister 创建绑定到当前实例配置的 persister
+func (server *Server) newPersister(filename string, load bool, fsync string) (*aof.Persister, error) {
+	return aof.NewPersisterWithConfig(server.cfg, server, filename, load, fsync, func() database.DBEngine {
+		return makeAuxiliaryServer(server.cfg, server.expires)
+	})
+}
+
 func (server *Server) AddAof(dbIndex int, cmdLine CmdLine) {
-	if server.persister != nil {
-		server.persister.SaveCmdLine(dbIndex, cmdLine)
-	}
+	server.saveAof(dbIndex, cmdLine)
 }
 
 func (server *Server) LoadRDB(dec *core.Decoder) error {
-	return dec.Parse(func(o rdb.RedisObject) bool {
-		db := server.mustSelectDB(o.GetDBIndex())
-		var entity *database.DataEntity
-		switch o.GetType() {
-		case rdb.StringType:
-			str := o.(*rdb.StringObject)
-			entity = &database.DataEntity{
-				Data: str.Value,
-			}
-		case rdb.ListType:
-			listObj := o.(*rdb.ListObject)
-			list := list.NewQuickList()
-			for _, v := range listObj.Values {
-				list.Add(v)
-			}
-			entity = &database.DataEntity{
-				Data: list,
-			}
-		case rdb.HashType:
-			hashObj := o.(*rdb.HashObject)
-			hash := dict.MakeSimple()
-			for k, v := range hashObj.Hash {
-				hash.Put(k, v)
-			}
-			entity = &database.DataEntity{
-				Data: hash,
-			}
-		case rdb.SetType:
-			setObj := o.(*rdb.SetObject)
-			set := set.Make()
-			for _, mem := range setObj.Members {
-				set.Add(string(mem))
-			}
-			entity = &database.DataEntity{
-				Data: set,
-			}
-		case rdb.ZSetType:
-			zsetObj := o.(*rdb.ZSetObject)
-			zSet := sortedset.Make()
-			for _, e := range zsetObj.Entries {
-				zSet.Add(e.Member, e.Score)
-			}
-			entity = &database.DataEntity{
-				Data: zSet,
-			}
+	// 需要读取辅助字段中的格式版本
+	var formatErr error
+	err := dec.WithSpecialOpCode().Parse(func(o rdb.RedisObject) bool {
+		if aux, ok := o.(*rdb.AuxObject); ok {
+			formatErr = aof.CheckAuxFormat(aux)
+			return formatErr == nil
 		}
+		db := server.mustSelectDB(o.GetDBIndex())
+		entity := db.entityFromRDB(o)
 		if entity != nil {
-			db.PutEntity(o.GetKey(), entity)
-			if o.GetExpiration() != nil {
-				db.Expire(o.GetKey(), *o.GetExpiration())
-			}
+			// 从节点加载主节点的快照时仍然在处理读命令，写入时需要持有 key 的锁
+			keys := []string{o.GetKey()}
+			db.RWLocks(keys, nil)
+			db.SetWithTTL(o.GetKey(), entity, o.GetExpiration())
+			db.RWUnLocks(keys, nil)
 			// add to aof
 			//将当前内存状态转换为命令序列
 			//key: "user:1"
 			// value: {name: "Alice", age: "25"}
 			// 转化成
 			// ["HSET", "user:1", "name", "Alice", "age", "25"]
-			db.addAof(aof.EntityToCmd(o.GetKey(), entity).Args)
+			for _, cmd := range aof.EntityToCmds(o.GetKey(), entity) {
+				db.addAof(cmd.Args)
+			}
 		}
 		return true
 	})
+	if formatErr != nil {
+		return formatErr
+	}
+	return err
+}
+
+// entityFromRDB 把 rdb 解析出的对象转换为 DataEntity，不认识的类型返回 nil
+func (db *DB) entityFromRDB(o rdb.RedisObject) *database.DataEntity {
+	var entity *database.DataEntity
+	switch o.GetType() {
+	case rdb.StringType:
+		str := o.(*rdb.StringObject)
+		entity = &database.DataEntity{
+			Data: str.Value,
+		}
+	case rdb.ListType:
+		listObj := o.(*rdb.ListObject)
+		list := list.NewQuickList()
+		for _, v := range listObj.Values {
+			list.Add(v)
+		}
+		entity = &database.DataEntity{
+			Data: list,
+		}
+	case rdb.HashType:
+		hashObj := o.(*rdb.HashObject)
+		hash := db.makeHash()
+		for k, v := range hashObj.Hash {
+			hash.Put(k, v)
+		}
+		entity = &database.DataEntity{
+			Data: hash,
+		}
+	case rdb.SetType:
+		setObj := o.(*rdb.SetObject)
+		set := db.makeSet()
+		for _, mem := range setObj.Members {
+			set.Add(string(mem))
+		}
+		entity = &database.DataEntity{
+			Data: set,
+		}
+	case rdb.ZSetType:
+		zsetObj := o.(*rdb.ZSetObject)
+		zSet := db.makeSortedSet()
+		for _, e := range zsetObj.Entries {
+			zSet.Add(e.Member, e.Score)
+		}
+		entity = &database.DataEntity{
+			Data: zSet,
+		}
+	case rdb.StreamType:
+		entity = &database.DataEntity{
+			Data: streamFromRDB(o.(*rdb.StreamObject)),
+		}
+	}
+	return entity
+}
+
+// streamFromRDB 转换 rdb 中的消息流，忽略消费组。
+// 解析后消息的字段保存在 map 中，字段名与所在节点相同的消息按节点的字段名恢复顺序，其余的按字段名排序
+func streamFromRDB(obj *rdb.StreamObject) *stream.Stream {
+	s := stream.New()
+	for _, node := range obj.Entries {
+		for _, msg := range node.Msgs {
+			if msg.Deleted {
+				continue
+			}
+			names := node.Fields
+			if !sameFieldNames(msg.Fields, names) {
+				names = make([]string, 0, len(msg.Fields))
+				for name := range msg.Fields {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+			}
+			fields := make([][]byte, 0, 2*len(names))
+			for _, name := range names {
+				fields = append(fields, []byte(name), []byte(msg.Fields[name]))
+			}
+			s.Add(stream.ID{Ms: msg.Id.Ms, Seq: msg.Id.Sequence}, fields)
+		}
+	}
+	if obj.LastId != nil {
+		if lastID := (stream.ID{Ms: obj.LastId.Ms, Seq: obj.LastId.Sequence}); s.LastID().Less(lastID) {
+			s.SetLastID(lastID)
+		}
+	}
+	return s
+}
+
+func sameFieldNames(fields map[string]string, names []string) bool {
+	if len(fields) != len(names) {
+		return false
+	}
+	for _, name := range names {
+		if _, ok := fields[name]; !ok {
+			return false
+		}
+	}
+	return true
 }
 
 func (server *Server) loadRdbFile() error {
-	rdbFile, err := os.Open(config.Properties.RDBFilename)
+	rdbFile, err := os.Open(server.cfg.RDBFilePath())
 	if err != nil {
 		return err
 
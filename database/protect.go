@@ -0,0 +1,85 @@
+package database
+
+import (
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 生产环境保护
+// protected-mode、deny-commands 和 flush-require-async 通常由 profile production 一起开启，也可以单独配置
+
+var protectedModeReply = protocol.MakeErrReply("DENIED Running in protected mode because protected mode is enabled and no password is set. " +
+	"In this mode connections are only accepted from the loopback interface. " +
+	"Set requirepass or disable protected-mode to accept connections from other hosts.")
+
+// parseDenyCommands 解析 deny-commands，未知的命令返回错误
+func parseDenyCommands(names []string) (map[string]struct{}, error) {
+	denied := make(map[string]struct{})
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := lookupCommand(name); !ok {
+			return nil, errors.New("unknown command in deny-commands: " + name)
+		}
+		denied[name] = struct{}{}
+	}
+	return denied, nil
+}
+
+// checkDenied 返回 nil 表示允许执行
+func (server *Server) checkDenied(cmdName string) redis.Reply {
+	if _, ok := server.denied[cmdName]; ok {
+		return protocol.MakeErrReply("ERR command '" + cmdName + "' is disabled by deny-commands")
+	}
+	return nil
+}
+
+// checkFlushArgs 检查 FLUSHALL/FLUSHDB 的 ASYNC|SYNC 参数。
+// 清空数据库只是替换为新的空数据库，原来的数据交给 GC 回收，所以两种方式的执行过程相同
+func (server *Server) checkFlushArgs(cmdName string, args [][]byte) redis.Reply {
+	if len(args) > 0 && strings.EqualFold(string(args[len(args)-1]), "cluster") {
+		return errClusterOptionReply
+	}
+	if len(args) > 1 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	async := false
+	if len(args) == 1 {
+		switch strings.ToLower(string(args[0])) {
+		case "async":
+			async = true
+		case "sync":
+		default:
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+	if server.cfg.FlushRequireAsync && !async {
+		return protocol.MakeErrReply("ERR " + strings.ToUpper(cmdName) + " without ASYNC is disabled by flush-require-async")
+	}
+	return nil
+}
+
+// AcceptConnection 开启 protected-mode 并且 default 用户不需要密码时拒绝来自其他主机的连接
+func (server *Server) AcceptConnection(addr net.Addr) redis.Reply {
+	if !server.cfg.ProtectedMode || addr == nil {
+		return nil
+	}
+	if user := server.lookupUser(defaultUser); user == nil || user.perm.Load().requiresAuth() {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		// unix socket、测试用的 pipe 等没有网络地址的连接
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && !ip.IsLoopback() {
+		return protectedModeReply
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestProductionProtection(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:                  t.TempDir(),
+		ProtectedMode:        true,
+		DenyCommands:         []string{"keys", "DEBUG"},
+		FlushRequireAsync:    true,
+		SlowlogLogSlowerThan: 100,
+		SlowlogMaxLen:        2,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(server.Exec(conn, utils.ToCmdLine(args...)).ToBytes())
+	}
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("KEYS", "*")), "-ERR command 'keys' is disabled by deny-commands\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("DEBUG", "SLEEP", "0")), "-ERR command 'debug' is disabled by deny-commands\r\n")
+	exec("SET", "k", "v")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHALL")), "-ERR FLUSHALL without ASYNC is disabled by flush-require-async\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHDB", "SYNC")), "-ERR FLUSHDB without ASYNC is disabled by flush-require-async\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHDB", "LAZY")), "-ERR syntax error\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k")), ":1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("FLUSHALL", "ASYNC")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k")), ":0\r\n")
+
+	for _, tt := range []struct {
+		addr     string
+		accepted bool
+	}{
+		{"127.0.0.1:50000", true},
+		{"[::1]:50000", true},
+		{"10.0.0.2:50000", false},
+	} {
+		addr, err := net.ResolveTCPAddr("tcp", tt.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if accepted := server.AcceptConnection(addr) == nil; accepted != tt.accepted {
+			t.Errorf("%s: expected accepted %v", tt.addr, tt.accepted)
+		}
+	}
+	// default 用户设置密码之后不再限制
+	exec("ACL", "SETUSER", "default", ">secret")
+	addr, _ := net.ResolveTCPAddr("tcp", "10.0.0.2:50000")
+	if server.AcceptConnection(addr) != nil {
+		t.Error("expected connection to be accepted with requirepass")
+	}
+
+	// 开始时间提前 1ms 模拟执行较慢的命令，最多保留 2 条
+	exec("SLOWLOG", "RESET")
+	slow := time.Now().Add(-time.Millisecond)
+	server.recordSlowlog(conn, utils.ToCmdLine("SET", "a", strings.Repeat("x", 200)), slow)
+	server.recordSlowlog(conn, utils.ToCmdLine("GET", "a"), slow)
+	server.recordSlowlog(conn, utils.ToCmdLine("GET", "b"), slow)
+	server.recordSlowlog(conn, utils.ToCmdLine("AUTH", "secret"), slow)
+	exec("CONFIG", "SET", "slowlog-log-slower-than", "10000")
+	server.recordSlowlog(conn, utils.ToCmdLine("GET", "c"), time.Now())
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SLOWLOG", "LEN")), ":2\r\n")
+	entries := exec("SLOWLOG", "GET", "1")
+	if !strings.Contains(entries, "$3\r\nGET\r\n$1\r\nb\r\n") || strings.Contains(entries, "$1\r\na\r\n") {
+		t.Errorf("unexpected slowlog entries %q", entries)
+	}
+	args := slowlogArgs(utils.ToCmdLine("SET", "a", strings.Repeat("x", 200)))
+	if string(args[2]) != strings.Repeat("x", 128)+"... (72 more bytes)" {
+		t.Errorf("unexpected truncated argument %q", args[2])
+	}
+	args = slowlogArgs(make([][]byte, 40))
+	if len(args) != 32 || string(args[31]) != "... (9 more arguments)" {
+		t.Errorf("unexpected truncated arguments %q", args[31])
+	}
+}
+
+func TestParseDenyCommands(t *testing.T) {
+	if _, err := parseDenyCommands([]string{"nosuchcommand"}); err == nil {
+		t.Error("expected error for unknown command")
+	}
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"strings"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/pubhub"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// PUBLISH 通常在 Server.exec 中直接执行，只有在事务中才会进入 cmdTable 排队，
+// EXEC 时由 ExecMulti 在事务成功后依次发布，被回滚的事务不会发出消息
+
+func execPublish(db *DB, args [][]byte) redis.Reply {
+	if db.publisher == nil {
+		// 临时数据库没有订阅者
+		return protocol.MakeIntReply(0)
+	}
+	return db.publisher(args)
+}
+
+// publish 发布消息，带 RETAIN 时写入 aof，重启之后保留消息仍然存在。
+// 保留消息与数据库无关，总是记录在 0 号数据库的上下文中
+func (server *Server) publish(args [][]byte) redis.Reply {
+	reply := pubhub.Publish(server.hub, args)
+	if pubhub.IsRetainPublish(args) && !protocol.IsErrorReply(reply) {
+		server.mustSelectDB(0).addAof(append(CmdLine{[]byte("PUBLISH")}, args...))
+	}
+	return reply
+}
+
+// ForEachRetained visits pub/sub retained messages, see aof.RetainedSource
+func (server *Server) ForEachRetained(cb func(channel string, message []byte) bool) {
+	server.hub.ForEachRetained(cb)
+}
+
+func isPublish(cmdLine CmdLine) bool {
+	return strings.EqualFold(string(cmdLine[0]), "publish")
+}
+
+func init() {
+	registerCommand("Publish", execPublish, noPrepare, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagPubSub, redisFlagLoading, redisFlagFast}, 0, 0, 0)
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestPublishInMulti(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	subscriber := connection.NewFakeConn()
+	server.Exec(subscriber, utils.ToCmdLine("SUBSCRIBE", "ch"))
+	subscriber.Clean()
+
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MULTI")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "k", "v")), "+QUEUED\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("PUBLISH", "ch", "hello")), "+QUEUED\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("PUBLISH", "other", "hello")), "+QUEUED\r\n")
+	if len(subscriber.Bytes()) != 0 {
+		t.Fatalf("message published before EXEC: %q", subscriber.Bytes())
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*3\r\n+OK\r\n:1\r\n:0\r\n")
+	expected := "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n"
+	if actual := string(subscriber.Bytes()); actual != expected {
+		t.Fatalf("expected %q, got %q", expected, actual)
+	}
+	subscriber.Clean()
+
+	// 回滚的事务不发布消息
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	server.Exec(conn, utils.ToCmdLine("PUBLISH", "ch", "lost"))
+	server.Exec(conn, utils.ToCmdLine("INCR", "k"))
+	server.Exec(conn, utils.ToCmdLine("EXEC"))
+	if len(subscriber.Bytes()) != 0 {
+		t.Fatalf("aborted transaction published %q", subscriber.Bytes())
+	}
+
+	// DISCARD 丢弃排队的 PUBLISH
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	server.Exec(conn, utils.ToCmdLine("PUBLISH", "ch", "lost"))
+	server.Exec(conn, utils.ToCmdLine("DISCARD"))
+	if len(subscriber.Bytes()) != 0 {
+		t.Fatalf("discarded transaction published %q", subscriber.Bytes())
+	}
+}
+
+func TestServerSubscribe(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:            16,
+		NotifyKeyspaceEvents: "Eg",
+	})
+	ch, err := server.Subscribe(context.Background(), "__keyevent@0__:del", "news")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SADD", "s", "a"))
+	server.Exec(conn, utils.ToCmdLine("SREM", "s", "a"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("PUBLISH", "news", "hello")), ":1\r\n")
+	if msg := <-ch; msg.Channel != "__keyevent@0__:del" || string(msg.Payload) != "s" {
+		t.Fatalf("unexpected message %v", msg)
+	}
+	if msg := <-ch; msg.Channel != "news" || string(msg.Payload) != "hello" {
+		t.Fatalf("unexpected message %v", msg)
+	}
+
+	// 关闭实例时关闭 channel
+	server.Close()
+	if _, ok := <-ch; ok {
+		t.Fatal("expected channel closed after shutdown")
+	}
+}
+
+func TestRetainPersistence(t *testing.T) {
+	for _, preamble := range []bool{false, true} {
+		cfg := &config.ServerProperties{
+			Dir:               t.TempDir(),
+			AppendOnly:        true,
+			AppendFilename:    "appendonly.aof",
+			AppendFsync:       "always",
+			Databases:         16,
+			AofUseRdbPreamble: preamble,
+		}
+		server := NewStandaloneServerWithConfig(cfg)
+		conn := connection.NewFakeConn()
+		server.Exec(conn, utils.ToCmdLine("SELECT", "3"))
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("PUBLISH", "sensor", "20", "RETAIN")), ":0\r\n")
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("PUBLISH", "gone", "x", "RETAIN")), ":0\r\n")
+		server.Exec(conn, utils.ToCmdLine("MULTI"))
+		server.Exec(conn, utils.ToCmdLine("PUBLISH", "gone", "", "RETAIN"))
+		server.Exec(conn, utils.ToCmdLine("EXEC"))
+		server.Exec(conn, utils.ToCmdLine("SET", "k", "v"))
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("REWRITEAOF")), "+OK\r\n")
+		// 重写之后的更新追加在 aof 尾部
+		server.Exec(conn, utils.ToCmdLine("PUBLISH", "lamp", "on", "RETAIN"))
+		server.Close()
+
+		loaded := NewStandaloneServerWithConfig(cfg)
+		subscriber := connection.NewFakeConn()
+		loaded.Exec(subscriber, utils.ToCmdLine("SUBSCRIBE", "sensor", "gone", "lamp"))
+		expected := "*3\r\n$9\r\nsubscribe\r\n$6\r\nsensor\r\n:1\r\n" +
+			"*3\r\n$7\r\nmessage\r\n$6\r\nsensor\r\n$2\r\n20\r\n" +
+			"*3\r\n$9\r\nsubscribe\r\n$4\r\ngone\r\n:2\r\n" +
+			"*3\r\n$9\r\nsubscribe\r\n$4\r\nlamp\r\n:3\r\n" +
+			"*3\r\n$7\r\nmessage\r\n$4\r\nlamp\r\n$2\r\non\r\n"
+		if actual := string(subscriber.Bytes()); actual != expected {
+			t.Errorf("preamble %v: expected %q, got %q", preamble, expected, actual)
+		}
+		conn = connection.NewFakeConn()
+		conn.SelectDB(3)
+		assertReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "k")), "$1\r\nv\r\n")
+		loaded.Close()
+	}
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func saveDeterministicRDB(t *testing.T, cmdLines [][]string) []byte {
+	cfg := &config.ServerProperties{
+		Dir:              t.TempDir(),
+		AppendOnly:       true,
+		AppendFilename:   "appendonly.aof",
+		AppendFsync:      "always",
+		RDBFilename:      "dump.rdb",
+		Databases:        16,
+		RdbDeterministic: true,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	for _, cmdLine := range cmdLines {
+		server.Exec(conn, utils.ToCmdLine(cmdLine...))
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "+OK\r\n")
+	data, err := os.ReadFile(cfg.RDBFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crc := crc64jones.New()
+	_, _ = crc.Write(data[:len(data)-8])
+	if !bytes.Equal(crc.Sum(nil), data[len(data)-8:]) {
+		t.Fatal("bad rdb checksum")
+	}
+
+	// 生成的 rdb 可以正常加载
+	loaded := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:         cfg.Dir,
+		RDBFilename: "dump.rdb",
+		Databases:   16,
+	})
+	defer loaded.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("HGET", "h", "f50")), "$3\r\nv50\r\n")
+	conn.SelectDB(3)
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("SCARD", "s")), ":3\r\n")
+	return data
+}
+
+func TestDeterministicRDB(t *testing.T) {
+	var forward, backward [][]string
+	for i := 0; i < 100; i++ {
+		forward = append(forward, []string{"HSET", "h", "f" + strconv.Itoa(i), "v" + strconv.Itoa(i)})
+		forward = append(forward, []string{"SET", "k" + strconv.Itoa(i), strconv.Itoa(i)})
+	}
+	for i := 99; i >= 0; i-- {
+		backward = append(backward, []string{"SET", "k" + strconv.Itoa(i), strconv.Itoa(i)})
+		backward = append(backward, []string{"HSET", "h", "f" + strconv.Itoa(i), "v" + strconv.Itoa(i)})
+	}
+	// db 3 中只有 hash 以外的类型，db 4 中只有 hash
+	tail := [][]string{
+		{"SELECT", "3"}, {"SADD", "s", "a", "b", "c"}, {"RPUSH", "l", "1", "2"}, {"ZADD", "z", "1", "m"},
+		{"SELECT", "4"}, {"HSET", "h2", "a", "1", "b", "2"},
+		{"SELECT", "5"}, {"HSET", "h3", "x", "1"}, {"SET", "s", "v"},
+	}
+	forward = append(forward, tail...)
+	backward = append(backward, tail...)
+
+	first := saveDeterministicRDB(t, forward)
+	second := saveDeterministicRDB(t, backward)
+	if !bytes.Equal(first, second) {
+		t.Fatal("rdb of identical datasets should be byte-identical")
+	}
+}
+
+func TestAutoSaveWithoutAof(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:       t.TempDir(),
+		Databases: 16,
+		Save:      "1 2",
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	if server.shouldAutoSave(time.Now().Add(time.Hour)) {
+		t.Fatal("one change should not trigger save 1 2")
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "2"))
+	deadline := time.Now().Add(5 * time.Second)
+	for !fileExists(cfg.RDBFilePath()) || server.rdb.saving.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("rdb is not saved automatically")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if dirty := server.rdb.dirty.Load(); dirty != 0 {
+		t.Errorf("expected no changes since last save, got %d", dirty)
+	}
+	// 关闭自动保存后 SAVE 仍然可以直接遍历数据库
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CONFIG", "SET", "save", "")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CONFIG", "SET", "save", "1")),
+		"-ERR Invalid argument '1' for CONFIG SET 'save'\r\n")
+	server.Exec(conn, utils.ToCmdLine("SET", "c", "3"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "+OK\r\n")
+	server.Close()
+
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MGET", "a", "b", "c")), "*3\r\n$1\r\n1\r\n$1\r\n2\r\n$1\r\n3\r\n")
+}
+
+func TestSaveWithPartitionedAof(t *testing.T) {
+	cfg := makePartitionTestConfig(t.TempDir())
+	cfg.RDBFilename = "dump.rdb"
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "b", "x", "y"))
+	// 直接遍历数据库，不需要重放分区 aof
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "+OK\r\n")
+	server.Close()
+
+	loaded := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:         cfg.Dir,
+		RDBFilename: "dump.rdb",
+		Databases:   16,
+	})
+	defer loaded.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+	conn.SelectDB(1)
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("LLEN", "b")), ":2\r\n")
+}
+
+func TestBGSaveDoesNotBlockWrites(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:         t.TempDir(),
+		RDBFilename: "dump.rdb",
+		Databases:   16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	// 保存遍历到 blocker 所在的分片时等待，其它分片上的写命令不受影响。
+	// 分片锁由另一个 goroutine 持有，执行命令的 goroutine 不持有任何锁
+	db := server.mustSelectDB(0)
+	locked, release := make(chan struct{}), make(chan struct{})
+	go func() {
+		db.RWLocks([]string{"blocker"}, nil)
+		close(locked)
+		<-release
+		db.RWUnLocks([]string{"blocker"}, nil)
+	}()
+	<-locked
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BGSAVE")), "+Background saving started\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BGSAVE")), "-ERR Background save already in progress\r\n")
+	done := make(chan struct{})
+	go func() {
+		server.Exec(conn, utils.ToCmdLine("SET", "b", "2"))
+		// 开始保存之后的 FLUSHALL 不影响正在保存的数据
+		server.Exec(conn, utils.ToCmdLine("FLUSHALL"))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes are blocked by BGSAVE")
+	}
+	if !server.rdb.saving.Load() {
+		t.Fatal("BGSAVE should wait for the locked shard")
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for server.rdb.saving.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("BGSAVE is not finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	server.Close()
+
+	loaded := NewStandaloneServerWithConfig(cfg)
+	defer loaded.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+}
+
+// rdb-deterministic 的 BGSAVE 保存开始时复制出的数据，之后的修改(包括原地修改)不影响这次保存
+func TestDeterministicBGSave(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:              t.TempDir(),
+		RDBFilename:      "dump.rdb",
+		Databases:        16,
+		RdbDeterministic: true,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "s", "hello"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "h", "f", "v"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "l", "a", "b"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("BGSAVE")), "+Background saving started\r\n")
+	server.Exec(conn, utils.ToCmdLine("SETRANGE", "s", "0", "J"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "h", "f", "new"))
+	server.Exec(conn, utils.ToCmdLine("RPUSH", "l", "c"))
+	server.Exec(conn, utils.ToCmdLine("SET", "added", "1"))
+	deadline := time.Now().Add(5 * time.Second)
+	for server.rdb.saving.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("BGSAVE is not finished")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 关闭时不能再保存一次，直接加载 BGSAVE 生成的文件
+	loaded := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: cfg.Dir, RDBFilename: "dump.rdb", Databases: 16})
+	defer loaded.Close()
+	server.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("GET", "s")), "$5\r\nhello\r\n")
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("HGET", "h", "f")), "$1\r\nv\r\n")
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("LLEN", "l")), ":2\r\n")
+	assertReply(t, loaded.Exec(conn, utils.ToCmdLine("EXISTS", "added")), ":0\r\n")
+}
@@ -0,0 +1,355 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 主节点一侧的全量同步
+// 从节点发送 PSYNC/SYNC 后，主节点先回复 +FULLRESYNC，再把 rdb 快照发给从节点，
+// 最后把快照之后写入 aof 的命令持续转发给从节点
+
+const eofMarkLen = 40
+
+// slaveFeed 接收 aof 的命令并转发给从节点
+// 在 rdb 快照传输完成之前，命令先缓存在 pending 中，避免和快照数据交错
+type slaveFeed struct {
+	mu      sync.Mutex
+	conn    redis.Connection
+	pending [][]byte
+	ready   bool
+	closed  bool
+}
+
+// Callback 由 persister 在写入 aof 之后调用
+func (feed *slaveFeed) Callback(cmdLines []CmdLine) {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	if feed.closed {
+		return
+	}
+	for _, cmdLine := range cmdLines {
+		data := protocol.MakeMultiBulkReply(cmdLine).ToBytes()
+		if !feed.ready {
+			feed.pending = append(feed.pending, data)
+			continue
+		}
+		if _, err := feed.conn.Write(data); err != nil {
+			slog.Warn("write to slave failed", "addr", feed.conn.RemoteAddr(), "error", err)
+			feed.closed = true
+			return
+		}
+	}
+}
+
+// flush 快照传输完成后发送缓存的命令，之后的命令直接转发
+func (feed *slaveFeed) flush() error {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	for _, data := range feed.pending {
+		if _, err := feed.conn.Write(data); err != nil {
+			feed.closed = true
+			return err
+		}
+	}
+	feed.pending = nil
+	feed.ready = true
+	return nil
+}
+
+func (feed *slaveFeed) close() {
+	feed.mu.Lock()
+	defer feed.mu.Unlock()
+	feed.closed = true
+	feed.pending = nil
+}
+
+// execPSync 处理从节点的 PSYNC/SYNC，目前只支持全量同步
+// 快照在处理这个连接命令的协程中发送，传输期间从节点发来的 REPLCONF ACK、PING 等命令留在连接中，
+// 快照和缓存的命令发送完之后才执行，它们的回复不会混入快照
+func (server *Server) execPSync(c redis.Connection, args [][]byte) redis.Reply {
+	if server.partitions != nil {
+		return protocol.MakeErrReply("ERR full sync is not supported with aof-slot-partitions")
+	}
+	if server.persister == nil {
+		return protocol.MakeErrReply("ERR full sync requires appendonly yes")
+	}
+	c.SetSlave()
+	header := "+FULLRESYNC " + server.cfg.RunID + " 0" + protocol.CRLF
+	if _, err := c.Write([]byte(header)); err != nil {
+		return &protocol.NoReply{}
+	}
+	server.fullSync(c, &slaveFeed{conn: c})
+	return &protocol.NoReply{}
+}
+
+// fullSync 发送 rdb 快照，完成后再发送快照期间缓存的命令
+func (server *Server) fullSync(c redis.Connection, feed *slaveFeed) {
+	var err error
+	if server.cfg.ReplDisklessSync {
+		err = server.disklessSync(c, feed)
+	} else {
+		err = server.sendRDBFile(c, feed)
+	}
+	if err != nil {
+		slog.Error("full sync failed", "addr", c.RemoteAddr(), "error", err)
+		server.removeSlave(c, feed)
+		_ = c.Close()
+		return
+	}
+	if err := feed.flush(); err != nil {
+		slog.Error("send backlog to slave failed", "addr", c.RemoteAddr(), "error", err)
+	}
+	slog.Info("full sync finished", "addr", c.RemoteAddr())
+}
+
+// sendRDBFile 先生成临时 rdb 文件，再以 $<len>\r\n<payload> 的格式发送
+func (server *Server) sendRDBFile(c redis.Connection, feed *slaveFeed) error {
+	rdbFile, err := os.CreateTemp(server.cfg.TmpDir(), "*.rdb")
+	if err != nil {
+		return err
+	}
+	rdbFilename := rdbFile.Name()
+	_ = rdbFile.Close()
+	defer func() {
+		_ = os.Remove(rdbFilename)
+	}()
+	err = server.persister.GenerateRDBForReplication(rdbFilename, feed, func() {
+		server.slaves.Store(c, feed)
+	})
+	if err != nil {
+		return err
+	}
+	rdbFile, err = os.Open(rdbFilename)
+	if err != nil {
+		return err
+	}
+	defer rdbFile.Close()
+	info, err := rdbFile.Stat()
+	if err != nil {
+		return err
+	}
+	header := "$" + strconv.FormatInt(info.Size(), 10) + protocol.CRLF
+	if _, err = c.Write([]byte(header)); err != nil {
+		return err
+	}
+	_, err = io.Copy(flushedWriter{c}, rdbFile)
+	return err
+}
+
+const rdbChunkSize = 64 << 10
+
+// flushedWriter 每次写入后等待发送完成，快照不会整个堆积在从节点的输出缓冲中
+type flushedWriter struct {
+	c redis.Connection
+}
+
+func (w flushedWriter) Write(b []byte) (int, error) {
+	n, err := w.c.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, w.c.Flush()
+}
+
+// disklessSlave 一个等待无盘快照的从节点，err 是它的同步结果
+type disklessSlave struct {
+	c    redis.Connection
+	feed *slaveFeed
+	err  error
+}
+
+// disklessBatch 在 repl-diskless-sync-delay 期间到达的从节点，等待结束后共用一个快照
+type disklessBatch struct {
+	slaves []*disklessSlave
+	// 快照发送完成后关闭
+	done chan struct{}
+}
+
+// disklessSync 无盘同步。repl-diskless-sync-delay 大于 0 时，第一个从节点开始等待，
+// 等待期间到达的从节点加入同一批，等待结束后由第一个从节点的协程把同一个快照同时发给这一批从节点
+func (server *Server) disklessSync(c redis.Connection, feed *slaveFeed) error {
+	slave := &disklessSlave{c: c, feed: feed}
+	delay := server.cfg.ReplDisklessSyncDelay
+	if delay <= 0 {
+		server.sendRDBDiskless([]*disklessSlave{slave})
+		return slave.err
+	}
+
+	server.disklessMu.Lock()
+	if batch := server.disklessBatch; batch != nil {
+		batch.slaves = append(batch.slaves, slave)
+		server.disklessMu.Unlock()
+		<-batch.done
+		return slave.err
+	}
+	batch := &disklessBatch{slaves: []*disklessSlave{slave}, done: make(chan struct{})}
+	server.disklessBatch = batch
+	server.disklessMu.Unlock()
+	defer close(batch.done)
+
+	timer := time.NewTimer(time.Duration(delay) * time.Second)
+	defer timer.Stop()
+	var stopped bool
+	select {
+	case <-timer.C:
+	case <-server.shutdown:
+		stopped = true
+	}
+	// 取出这一批之后到达的从节点开始新的一批
+	server.disklessMu.Lock()
+	server.disklessBatch = nil
+	server.disklessMu.Unlock()
+	if stopped {
+		for _, s := range batch.slaves {
+			s.err = errors.New("server is shutting down")
+		}
+		return slave.err
+	}
+	server.sendRDBDiskless(batch.slaves)
+	return slave.err
+}
+
+// sendRDBDiskless 无盘复制：不知道快照的长度，使用 $EOF:<mark>\r\n<payload><mark> 的格式
+// 编码器直接写入从节点的连接，结果记录在每个从节点的 err 中
+func (server *Server) sendRDBDiskless(slaves []*disklessSlave) {
+	mark := []byte(utils.RandHexString(eofMarkLen))
+	w := &slavesWriter{}
+	listeners := make([]aof.Listener, 0, len(slaves))
+	for _, slave := range slaves {
+		if _, slave.err = slave.c.Write([]byte("$EOF:" + string(mark) + protocol.CRLF)); slave.err != nil {
+			continue
+		}
+		w.slaves = append(w.slaves, slave)
+		listeners = append(listeners, slave.feed)
+	}
+	if len(w.slaves) == 0 {
+		return
+	}
+	// 编码器每次写入的数据很少，攒够一块再发送
+	buf := bufio.NewWriterSize(w, rdbChunkSize)
+	err := server.persister.WriteRDBForReplication(buf, listeners, func() {
+		for _, slave := range w.slaves {
+			server.slaves.Store(slave.c, slave.feed)
+		}
+	})
+	if err == nil {
+		err = buf.Flush()
+	}
+	for _, slave := range w.slaves {
+		if slave.err != nil {
+			continue
+		}
+		if err != nil {
+			slave.err = err
+			continue
+		}
+		_, slave.err = slave.c.Write(mark)
+	}
+}
+
+// slavesWriter 把快照同时写入一批从节点的连接，写入失败的从节点不再写入，全部失败时返回错误
+type slavesWriter struct {
+	slaves []*disklessSlave
+}
+
+func (w *slavesWriter) Write(b []byte) (int, error) {
+	alive := 0
+	for _, slave := range w.slaves {
+		if slave.err != nil {
+			continue
+		}
+		if _, slave.err = (flushedWriter{slave.c}).Write(b); slave.err == nil {
+			alive++
+		}
+	}
+	if alive == 0 {
+		return 0, w.slaves[0].err
+	}
+	return len(b), nil
+}
+
+func (server *Server) removeSlave(c redis.Connection, feed *slaveFeed) {
+	feed.close()
+	server.slaves.Delete(c)
+	if server.persister != nil {
+		server.persister.RemoveListener(feed)
+	}
+}
+
+// execReplConf 从节点在同步前后会发送 REPLCONF listening-port/ip-address/capa/ack，
+// 记录从节点公布的地址用于 INFO replication，其他选项直接确认
+func (server *Server) execReplConf(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args)%2 != 0 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	for i := 0; i < len(args); i += 2 {
+		switch strings.ToLower(string(args[i])) {
+		case "ack":
+			// 从节点上报复制偏移量，不需要回复
+			return &protocol.NoReply{}
+		case "listening-port":
+			if _, err := strconv.Atoi(string(args[i+1])); err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			server.slaveAddr(c).port = string(args[i+1])
+		case "ip-address":
+			server.slaveAddr(c).ip = string(args[i+1])
+		}
+	}
+	return protocol.MakeOkReply()
+}
+
+// slaveAnnounce 从节点通过 REPLCONF 公布的地址
+type slaveAnnounce struct {
+	ip   string
+	port string
+}
+
+func (server *Server) slaveAddr(c redis.Connection) *slaveAnnounce {
+	raw, _ := server.slaveAddrs.LoadOrStore(c, &slaveAnnounce{})
+	return raw.(*slaveAnnounce)
+}
+
+// slavesInfo 返回 INFO replication 中的 slaveN 行，没有公布端口的从节点不列出
+func (server *Server) slavesInfo() (int, string) {
+	count := 0
+	s := ""
+	server.slaves.Range(func(key, value any) bool {
+		c := key.(redis.Connection)
+		feed := value.(*slaveFeed)
+		count++
+		raw, ok := server.slaveAddrs.Load(c)
+		if !ok || raw.(*slaveAnnounce).port == "" {
+			return true
+		}
+		addr := raw.(*slaveAnnounce)
+		ip := addr.ip
+		if ip == "" {
+			ip, _, _ = net.SplitHostPort(c.RemoteAddr())
+		}
+		feed.mu.Lock()
+		state := "wait_bgsave"
+		if feed.ready {
+			state = "online"
+		}
+		feed.mu.Unlock()
+		s += "slave" + strconv.Itoa(count-1) + ":ip=" + ip + ",port=" + addr.port +
+			",state=" + state + ",offset=0,lag=0\r\n"
+		return true
+	})
+	return count, s
+}
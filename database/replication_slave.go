@@ -0,0 +1,428 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	rdb "github.com/hdt3213/rdb/parser"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/interfaces/redis/parser"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 从节点一侧的复制
+// SLAVEOF/REPLICAOF host port 之后在后台连接主节点并发送 PSYNC，加载主节点发来的 rdb 快照，
+// 然后持续执行主节点转发的命令。连接断开后每隔 replRetryInterval 重连，并重新全量同步。
+// 主节点也可以是真正的 redis，用于不停机迁移: 从节点每秒发送 REPLCONF ACK 避免被 redis 判定超时，
+// 并回应 REPLCONF GETACK；不支持的命令记录在 INFO replication 的 master_failed_commands 中，
+// 追上之后 REPLICAOF NO ONE 切换
+
+const (
+	replRetryInterval  = time.Second
+	defaultReplTimeout = 60 * time.Second
+	replAckInterval    = time.Second
+)
+
+var errReplStopped = errors.New("replication stopped")
+
+// replicationStatus 记录当前的主节点和同步状态
+type replicationStatus struct {
+	mu         sync.Mutex
+	masterHost string
+	masterPort int
+	// 关闭 stop 通知同步 goroutine 退出，每次修改主节点都会创建新的 stop
+	stop       chan struct{}
+	masterConn net.Conn
+	replID     string
+
+	linkUp       atomic.Bool
+	offset       atomic.Int64
+	lastInteract atomic.Int64 // unix 秒
+	// 主节点转发的命令中执行失败的数量，通常是本引擎不支持的命令
+	failedCmds atomic.Int64
+}
+
+// master 返回主节点地址，不是从节点时返回空字符串
+func (status *replicationStatus) master() string {
+	addr := status.masterAddr()
+	if addr == nil {
+		return ""
+	}
+	return net.JoinHostPort(addr[0], addr[1])
+}
+
+// setMaster 停止之前的同步并记录新的主节点，主节点没有变化时返回 false
+func (status *replicationStatus) setMaster(host string, port int) (chan struct{}, bool) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.masterHost == host && status.masterPort == port {
+		return nil, false
+	}
+	status.stopLocked()
+	status.masterHost = host
+	status.masterPort = port
+	status.stop = make(chan struct{})
+	return status.stop, true
+}
+
+// stopReplication 断开与主节点的连接，之后实例重新成为主节点，已有的数据保留
+func (status *replicationStatus) stopReplication() {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	status.stopLocked()
+	status.masterHost = ""
+	status.masterPort = 0
+}
+
+func (status *replicationStatus) stopLocked() {
+	if status.stop != nil {
+		close(status.stop)
+		status.stop = nil
+	}
+	if status.masterConn != nil {
+		_ = status.masterConn.Close()
+		status.masterConn = nil
+	}
+	status.replID = ""
+	status.linkUp.Store(false)
+}
+
+// setConn 记录与主节点的连接，使 stopReplication 可以关闭它打断阻塞的读取
+func (status *replicationStatus) setConn(stop chan struct{}, conn net.Conn) bool {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.stop != stop {
+		return false
+	}
+	status.masterConn = conn
+	return true
+}
+
+func (status *replicationStatus) setReplID(stop chan struct{}, replID string) {
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.stop == stop {
+		status.replID = replID
+	}
+}
+
+func stopped(stop chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// isReplica 从节点只执行主节点转发的写命令
+func (server *Server) isReplica() bool {
+	return server.repl.masterAddr() != nil
+}
+
+// execSlaveOf SLAVEOF/REPLICAOF host port | NO ONE
+func (server *Server) execSlaveOf(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("slaveof")
+	}
+	if c != nil && c.InMultiState() {
+		return protocol.MakeErrReply("ERR command 'slaveof' cannot be used in MULTI")
+	}
+	if server.repl == nil {
+		return protocol.MakeErrReply("ERR replication is not supported")
+	}
+	if strings.EqualFold(string(args[0]), "no") && strings.EqualFold(string(args[1]), "one") {
+		if master := server.repl.master(); master != "" {
+			server.repl.stopReplication()
+			slog.Info("replication stopped, now acting as master", "master", master)
+		}
+		return protocol.MakeOkReply()
+	}
+	if server.partitions != nil {
+		return protocol.MakeErrReply("ERR replication is not supported with aof-slot-partitions")
+	}
+	host := string(args[0])
+	port, err := strconv.Atoi(string(args[1]))
+	if err != nil || port <= 0 || port > 65535 {
+		return protocol.MakeErrReply("ERR Invalid master port")
+	}
+	stop, changed := server.repl.setMaster(host, port)
+	if !changed {
+		return protocol.MakeStatusReply("OK Already connected to specified master")
+	}
+	go server.replicationLoop(stop, net.JoinHostPort(host, strconv.Itoa(port)))
+	return protocol.MakeOkReply()
+}
+
+// replicationLoop 同步失败后重试，直到 stop 被关闭
+func (server *Server) replicationLoop(stop chan struct{}, addr string) {
+	for {
+		err := server.syncWithMaster(stop, addr)
+		if stopped(stop) {
+			return
+		}
+		slog.Warn("replication with master broken, retrying", "master", addr, "error", err)
+		select {
+		case <-stop:
+			return
+		case <-time.After(replRetryInterval):
+		}
+	}
+}
+
+func (server *Server) replTimeout() time.Duration {
+	if server.cfg.ReplTimeout > 0 {
+		return time.Duration(server.cfg.ReplTimeout) * time.Second
+	}
+	return defaultReplTimeout
+}
+
+// sendReplCmd 握手阶段发送一条命令并读取一行回复，错误回复视为失败
+func sendReplCmd(conn net.Conn, reader *bufio.Reader, args ...string) (string, error) {
+	if _, err := conn.Write(protocol.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()); err != nil {
+		return "", err
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, protocol.CRLF)
+	if strings.HasPrefix(line, "-") {
+		return "", errors.New("master replied " + args[0] + ": " + line[1:])
+	}
+	return line, nil
+}
+
+// syncWithMaster 完成一次握手和全量同步，然后持续执行主节点的命令，直到连接断开
+func (server *Server) syncWithMaster(stop chan struct{}, addr string) error {
+	timeout := server.replTimeout()
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return err
+	}
+	if !server.repl.setConn(stop, conn) {
+		_ = conn.Close()
+		return errReplStopped
+	}
+	defer server.repl.linkUp.Store(false)
+
+	// 握手和传输快照期间使用 repl-timeout，之后的命令流没有超时
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReader(conn)
+	if server.cfg.MasterAuth != "" {
+		auth := []string{"AUTH", server.cfg.MasterAuth}
+		if server.cfg.MasterUser != "" {
+			auth = []string{"AUTH", server.cfg.MasterUser, server.cfg.MasterAuth}
+		}
+		if _, err = sendReplCmd(conn, reader, auth...); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+	port := server.cfg.SlaveAnnouncePort
+	if port == 0 {
+		port = server.cfg.Port
+	}
+	if _, err = sendReplCmd(conn, reader, "REPLCONF", "listening-port", strconv.Itoa(port)); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	if ip := server.cfg.SlaveAnnounceIP; ip != "" {
+		if _, err = sendReplCmd(conn, reader, "REPLCONF", "ip-address", ip); err != nil {
+			_ = conn.Close()
+			return err
+		}
+	}
+	if _, err = sendReplCmd(conn, reader, "REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	// +FULLRESYNC 和随后的 rdb 由 parser 识别
+	psync := protocol.MakeMultiBulkReply(utils.ToCmdLine("PSYNC", "?", "-1")).ToBytes()
+	if _, err = conn.Write(psync); err != nil {
+		_ = conn.Close()
+		return err
+	}
+	ch := parser.ParseStream(reader)
+	defer func() {
+		_ = conn.Close()
+		// 让 parser 的 goroutine 读到错误后退出
+		for range ch {
+		}
+	}()
+
+	payload := <-ch
+	if payload == nil {
+		return errors.New("read FULLRESYNC failed")
+	} else if payload.Err != nil {
+		return payload.Err
+	}
+	status, ok := payload.Data.(*protocol.StatusReply)
+	if !ok || !strings.HasPrefix(status.Status, "FULLRESYNC") {
+		return errors.New("unexpected PSYNC reply: " + strings.TrimSpace(string(payload.Data.ToBytes())))
+	}
+	fields := strings.Fields(status.Status)
+	if len(fields) != 3 {
+		return errors.New("illegal FULLRESYNC reply: " + status.Status)
+	}
+	offset, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return errors.New("illegal FULLRESYNC reply: " + status.Status)
+	}
+
+	payload = <-ch
+	if payload == nil {
+		return errors.New("read rdb from master failed")
+	} else if payload.Err != nil {
+		return payload.Err
+	}
+	rdbData, ok := payload.Data.(*protocol.BulkReply)
+	if !ok {
+		return errors.New("unexpected rdb payload from master")
+	}
+	if stopped(stop) {
+		return errReplStopped
+	}
+	if err = server.loadMasterRDB(rdbData.Arg); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	server.repl.setReplID(stop, fields[1])
+	server.repl.offset.Store(offset)
+	server.repl.lastInteract.Store(time.Now().Unix())
+	server.repl.linkUp.Store(true)
+	slog.Info("full sync with master finished", "master", addr, "bytes", len(rdbData.Arg))
+
+	// 定时上报偏移量，GETACK 时立即上报，两者都会写连接
+	var writeMu sync.Mutex
+	sendAck := func() error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		ack := utils.ToCmdLine("REPLCONF", "ACK", strconv.FormatInt(server.repl.offset.Load(), 10))
+		_, err := conn.Write(protocol.MakeMultiBulkReply(ack).ToBytes())
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(replAckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if sendAck() != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	// 主节点转发的命令以 SELECT 开头，使用独立的连接记录当前数据库
+	masterConn := connection.NewFakeConn()
+	masterConn.SetMaster()
+	for payload := range ch {
+		if payload.Err != nil {
+			return payload.Err
+		}
+		cmdLine, ok := payload.Data.(*protocol.MultiBulkReply)
+		if !ok || len(cmdLine.Args) == 0 {
+			continue
+		}
+		if stopped(stop) {
+			return errReplStopped
+		}
+		server.repl.offset.Add(int64(len(cmdLine.ToBytes())))
+		server.repl.lastInteract.Store(time.Now().Unix())
+		if isGetAck(cmdLine.Args) {
+			// 偏移量包括 GETACK 本身
+			if err := sendAck(); err != nil {
+				return err
+			}
+			continue
+		}
+		reply := server.Exec(masterConn, cmdLine.Args)
+		if protocol.IsErrorReply(reply) {
+			server.repl.failedCmds.Add(1)
+			slog.Warn("execute command from master failed",
+				"cmd", string(cmdLine.Args[0]), "reply", strings.TrimSpace(string(reply.ToBytes())))
+		}
+	}
+	return errors.New("connection with master closed")
+}
+
+// isGetAck 主节点发送 REPLCONF GETACK * 要求从节点立即上报偏移量
+func isGetAck(args [][]byte) bool {
+	return len(args) >= 2 && strings.EqualFold(string(args[0]), "replconf") &&
+		strings.EqualFold(string(args[1]), "getack")
+}
+
+// loadMasterRDB 清空所有数据库后加载主节点的快照
+func (server *Server) loadMasterRDB(data []byte) error {
+	server.flushAll()
+	return server.LoadRDB(rdb.NewDecoder(bytes.NewReader(data)))
+}
+
+// startReplicaOf 按照 replicaof 配置在启动时开始复制
+func (server *Server) startReplicaOf(value string) {
+	args := strings.Fields(value)
+	if len(args) != 2 {
+		panic("invalid replicaof: " + value)
+	}
+	reply := server.execSlaveOf(nil, [][]byte{[]byte(args[0]), []byte(args[1])})
+	if protocol.IsErrorReply(reply) {
+		panic("invalid replicaof: " + value)
+	}
+}
+
+// replicationInfo INFO replication
+func (server *Server) replicationInfo() string {
+	s := "# Replication\r\n"
+	if master := server.repl.masterAddr(); master != nil {
+		linkStatus := "down"
+		if server.repl.linkUp.Load() {
+			linkStatus = "up"
+		}
+		lastIO := int64(-1)
+		if last := server.repl.lastInteract.Load(); last > 0 {
+			lastIO = time.Now().Unix() - last
+		}
+		s += "role:slave\r\n" +
+			"master_host:" + master[0] + "\r\n" +
+			"master_port:" + master[1] + "\r\n" +
+			"master_link_status:" + linkStatus + "\r\n" +
+			"master_last_io_seconds_ago:" + strconv.FormatInt(lastIO, 10) + "\r\n" +
+			"master_repl_offset:" + strconv.FormatInt(server.repl.offset.Load(), 10) + "\r\n" +
+			"master_failed_commands:" + strconv.FormatInt(server.repl.failedCmds.Load(), 10) + "\r\n"
+	} else {
+		s += "role:master\r\n"
+	}
+	slaves, lines := server.slavesInfo()
+	s += "connected_slaves:" + strconv.Itoa(slaves) + "\r\n" + lines
+	return s
+}
+
+// masterAddr 返回 {host, port}，不是从节点时返回 nil
+func (status *replicationStatus) masterAddr() []string {
+	if status == nil {
+		return nil
+	}
+	status.mu.Lock()
+	defer status.mu.Unlock()
+	if status.masterHost == "" {
+		return nil
+	}
+	return []string{status.masterHost, strconv.Itoa(status.masterPort)}
+}
@@ -0,0 +1,336 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hdt3213/rdb/core"
+	"github.com/hdt3213/rdb/encoder"
+	"github.com/hdt3213/rdb/model"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis/parser"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// serveForTest 在随机端口上用 server 处理请求，返回监听地址
+func serveForTest(t *testing.T, server *Server) (string, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			raw, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn := connection.NewConn(raw)
+				defer func() {
+					server.AfterClientClose(conn)
+					_ = raw.Close()
+				}()
+				for payload := range parser.ParseStream(raw) {
+					if payload.Err != nil {
+						return
+					}
+					cmdLine, ok := payload.Data.(*protocol.MultiBulkReply)
+					if !ok {
+						continue
+					}
+					reply := server.Exec(conn, cmdLine.Args)
+					if _, ok := reply.(*protocol.NoReply); ok {
+						continue
+					}
+					if _, err := conn.Write(reply.ToBytes()); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port
+}
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func replInfoField(server *Server, field string) string {
+	for _, line := range strings.Split(server.replicationInfo(), "\r\n") {
+		if strings.HasPrefix(line, field+":") {
+			return strings.TrimPrefix(line, field+":")
+		}
+	}
+	return ""
+}
+
+func testReplication(t *testing.T, diskless bool) {
+	master := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:              t.TempDir(),
+		AppendOnly:       true,
+		AppendFilename:   "appendonly.aof",
+		AppendFsync:      "always",
+		Databases:        16,
+		ReplDisklessSync: diskless,
+	})
+	defer master.Close()
+	masterConn := connection.NewFakeConn()
+	master.Exec(masterConn, utils.ToCmdLine("SET", "a", "1"))
+	master.Exec(masterConn, utils.ToCmdLine("SELECT", "2"))
+	master.Exec(masterConn, utils.ToCmdLine("RPUSH", "list", "x", "y"))
+	host, port := serveForTest(t, master)
+
+	slave := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer slave.Close()
+	conn := connection.NewFakeConn()
+	slave.Exec(conn, utils.ToCmdLine("SET", "stale", "1"))
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("SLAVEOF", host, port)), "+OK\r\n")
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("REPLICAOF", host, port)),
+		"+OK Already connected to specified master\r\n")
+	waitFor(t, "full sync did not finish", func() bool {
+		return replInfoField(slave, "master_link_status") == "up"
+	})
+	if v := replInfoField(master, "connected_slaves"); v != "1" {
+		t.Fatalf("expected 1 connected slave, got %q", v)
+	}
+	// 同步前的数据被快照覆盖
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("GET", "stale")), "$-1\r\n")
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("SET", "b", "1")),
+		"-READONLY You can't write against a read only replica.\r\n")
+
+	// 快照之后的命令继续转发，并且保持主节点的数据库上下文
+	master.Exec(masterConn, utils.ToCmdLine("RPUSH", "list", "z"))
+	master.Exec(masterConn, utils.ToCmdLine("SELECT", "0"))
+	master.Exec(masterConn, utils.ToCmdLine("DEL", "a"))
+	conn.SelectDB(2)
+	waitFor(t, "command stream was not applied", func() bool {
+		reply := slave.Exec(conn, utils.ToCmdLine("LLEN", "list"))
+		return string(reply.ToBytes()) == ":3\r\n"
+	})
+	conn.SelectDB(0)
+	waitFor(t, "DEL was not applied", func() bool {
+		reply := slave.Exec(conn, utils.ToCmdLine("EXISTS", "a"))
+		return string(reply.ToBytes()) == ":0\r\n"
+	})
+
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("SLAVEOF", "NO", "ONE")), "+OK\r\n")
+	if v := replInfoField(slave, "role"); v != "master" {
+		t.Fatalf("expected role master, got %q", v)
+	}
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("SET", "b", "1")), "+OK\r\n")
+}
+
+func TestReplication(t *testing.T) {
+	testReplication(t, false)
+}
+
+func TestReplicationDiskless(t *testing.T) {
+	testReplication(t, true)
+}
+
+// repl-diskless-sync-delay 期间到达的从节点共用一个快照。从 +FULLRESYNC 到快照结束之间不写入其他数据，
+// 同步期间从节点发送的命令在快照和缓存的命令之后才回复
+func TestDisklessSyncDelay(t *testing.T) {
+	master := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:                   t.TempDir(),
+		AppendOnly:            true,
+		AppendFilename:        "appendonly.aof",
+		Databases:             16,
+		ReplDisklessSync:      true,
+		ReplDisklessSyncDelay: 1,
+	})
+	defer master.Close()
+	master.Exec(connection.NewFakeConn(), utils.ToCmdLine("SET", "a", "1"))
+	host, port := serveForTest(t, master)
+	startSync := func() *bufio.Reader {
+		raw, err := net.Dial("tcp", net.JoinHostPort(host, port))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = raw.Close()
+		})
+		_ = raw.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, _ = raw.Write(protocol.MakeMultiBulkReply(utils.ToCmdLine("PSYNC", "?", "-1")).ToBytes())
+		_, _ = raw.Write(protocol.MakeMultiBulkReply(utils.ToCmdLine("PING")).ToBytes())
+		return bufio.NewReader(raw)
+	}
+	// readSync 读取 +FULLRESYNC 和快照，返回 EOF 标记和快照内容
+	readSync := func(reader *bufio.Reader) (string, []byte) {
+		line, err := reader.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "+FULLRESYNC ") {
+			t.Fatalf("unexpected reply %q, err %v", line, err)
+		}
+		line, err = reader.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "$EOF:") || len(line) != len("$EOF:\r\n")+eofMarkLen {
+			t.Fatalf("unexpected rdb header %q, err %v", line, err)
+		}
+		mark := []byte(line[len("$EOF:") : len(line)-2])
+		var payload []byte
+		for !bytes.HasSuffix(payload, mark) {
+			b, err := reader.ReadByte()
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload = append(payload, b)
+		}
+		return string(mark), payload[:len(payload)-len(mark)]
+	}
+
+	start := time.Now()
+	first := startSync()
+	time.Sleep(200 * time.Millisecond)
+	second := startSync()
+	firstMark, firstPayload := readSync(first)
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected rdb to be sent after the 1s delay, sent after %v", elapsed)
+	}
+	secondMark, secondPayload := readSync(second)
+	if firstMark != secondMark || !bytes.Equal(firstPayload, secondPayload) {
+		t.Error("expected slaves arriving during the delay to share one snapshot")
+	}
+
+	// 快照中只有 rdb 数据
+	var keys []string
+	err := core.NewDecoder(bytes.NewReader(firstPayload)).Parse(func(o model.RedisObject) bool {
+		keys = append(keys, o.GetKey())
+		return true
+	})
+	if err != nil || len(keys) != 1 || keys[0] != "a" {
+		t.Fatalf("unexpected snapshot keys %v, err %v", keys, err)
+	}
+	// 快照之后是缓存的命令，然后才是 PING 的回复
+	expected := string(protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", "0")).ToBytes()) + "+PONG\r\n"
+	for _, reader := range []*bufio.Reader{first, second} {
+		buf := make([]byte, len(expected))
+		if _, err := io.ReadFull(reader, buf); err != nil || string(buf) != expected {
+			t.Errorf("unexpected data after snapshot %q, err %v", buf, err)
+		}
+	}
+}
+
+func TestSlaveOfArgs(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SLAVEOF", "127.0.0.1", "abc")), "-ERR Invalid master port\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SLAVEOF", "NO", "ONE")), "+OK\r\n")
+	if v := replInfoField(server, "role"); v != "master" {
+		t.Fatalf("expected role master, got %q", v)
+	}
+}
+
+// TestReplicateFromRedis 模拟真正的 redis 主节点: 快照前发送换行保活，rdb 后没有 CRLF，
+// 命令流中带有 REPLCONF GETACK
+func TestReplicateFromRedis(t *testing.T) {
+	var rdbBuf bytes.Buffer
+	enc := encoder.NewEncoder(&rdbBuf)
+	_ = enc.WriteHeader()
+	_ = enc.WriteAux("redis-ver", "7.2.4")
+	_ = enc.WriteDBHeader(0, 1, 0)
+	_ = enc.WriteStringObject("a", []byte("1"))
+	_ = enc.WriteEnd()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	handshake := make(chan []string, 8)
+	acks := make(chan string, 8)
+	stream := [][]byte{
+		protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", "0")).ToBytes(),
+		protocol.MakeMultiBulkReply(utils.ToCmdLine("SET", "b", "2")).ToBytes(),
+		protocol.MakeMultiBulkReply(utils.ToCmdLine("FT.CREATE", "idx")).ToBytes(),
+		protocol.MakeMultiBulkReply(utils.ToCmdLine("REPLCONF", "GETACK", "*")).ToBytes(),
+	}
+	expectedOffset := 0
+	for _, cmd := range stream {
+		expectedOffset += len(cmd)
+	}
+	go func() {
+		raw, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer raw.Close()
+		reader := bufio.NewReader(raw)
+		for {
+			cmdLine, err := parser.ParseV2(reader)
+			if err != nil {
+				return
+			}
+			args := make([]string, len(cmdLine))
+			for i, arg := range cmdLine {
+				args[i] = string(arg)
+			}
+			switch strings.ToUpper(args[0]) {
+			case "PSYNC":
+				_, _ = raw.Write([]byte("+FULLRESYNC " + strings.Repeat("f", 40) + " 0\r\n\n\n"))
+				_, _ = raw.Write([]byte("$" + strconv.Itoa(rdbBuf.Len()) + "\r\n"))
+				_, _ = raw.Write(rdbBuf.Bytes())
+				for _, cmd := range stream {
+					_, _ = raw.Write(cmd)
+				}
+			case "REPLCONF":
+				if strings.EqualFold(args[1], "ACK") {
+					acks <- args[2]
+					continue
+				}
+				handshake <- args
+				_, _ = raw.Write([]byte("+OK\r\n"))
+			default:
+				handshake <- args
+				_, _ = raw.Write([]byte("+OK\r\n"))
+			}
+		}
+	}()
+
+	slave := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:  16,
+		MasterUser: "repl",
+		MasterAuth: "secret",
+	})
+	defer slave.Close()
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	conn := connection.NewFakeConn()
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("REPLICAOF", host, port)), "+OK\r\n")
+	if auth := <-handshake; strings.Join(auth, " ") != "AUTH repl secret" {
+		t.Fatalf("unexpected auth %q", auth)
+	}
+	// 定时发送的 ACK 可能先于 GETACK 的回应到达
+	timeout := time.After(5 * time.Second)
+	for acked := false; !acked; {
+		select {
+		case ack := <-acks:
+			acked = ack == strconv.Itoa(expectedOffset)
+		case <-timeout:
+			t.Fatalf("replica did not ack offset %d", expectedOffset)
+		}
+	}
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+	assertReply(t, slave.Exec(conn, utils.ToCmdLine("GET", "b")), "$1\r\n2\r\n")
+	if v := replInfoField(slave, "master_failed_commands"); v != "1" {
+		t.Fatalf("expected 1 failed command, got %q", v)
+	}
+}
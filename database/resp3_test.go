@@ -0,0 +1,99 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestRESP3Replies(t *testing.T) {
+	db := makeTestDB()
+	c := connection.NewFakeConn()
+	c.SetProtocol(protocol.RESP3)
+	exec := func(cmd ...string) string {
+		return string(db.Exec(c, utils.ToCmdLine(cmd...)).ToBytes())
+	}
+	check := func(actual, expected string) {
+		t.Helper()
+		if actual != expected {
+			t.Errorf("expected %q, actually %q", expected, actual)
+		}
+	}
+
+	exec("ZADD", "z", "1.5", "a")
+	check(exec("ZSCORE", "z", "a"), ",1.5\r\n")
+	check(exec("ZINCRBY", "z", "1", "a"), ",2.5\r\n")
+	check(exec("INCRBYFLOAT", "f", "0.5"), ",0.5\r\n")
+	check(exec("INCRBYFLOAT", "f", "1"), ",1.5\r\n")
+
+	exec("HSET", "h", "f", "v")
+	check(exec("HRANDFIELD", "h", "1", "WITHVALUES"), "%1\r\n$1\r\nf\r\n$1\r\nv\r\n")
+	check(exec("HRANDFIELD", "h", "-2", "WITHVALUES"), "*2\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n*2\r\n$1\r\nf\r\n$1\r\nv\r\n")
+
+	// inside MULTI the queued replies are typed as well
+	exec("MULTI")
+	exec("ZSCORE", "z", "a")
+	check(exec("EXEC"), "*1\r\n,2.5\r\n")
+
+	// RESP2 connections get the same values as bulk strings and flat arrays
+	assertReply(t, execTestCmd(db, "ZSCORE", "z", "a"), "$3\r\n2.5\r\n")
+	assertReply(t, execTestCmd(db, "INCRBYFLOAT", "f", "1"), "$3\r\n2.5\r\n")
+	assertReply(t, execTestCmd(db, "HRANDFIELD", "h", "-2", "WITHVALUES"), "*4\r\n$1\r\nf\r\n$1\r\nv\r\n$1\r\nf\r\n$1\r\nv\r\n")
+	c2 := connection.NewFakeConn()
+	assertReply(t, db.Exec(c2, utils.ToCmdLine("MULTI")), "+OK\r\n")
+	db.Exec(c2, utils.ToCmdLine("ZSCORE", "z", "a"))
+	assertReply(t, db.Exec(c2, utils.ToCmdLine("EXEC")), "*1\r\n$3\r\n2.5\r\n")
+}
+
+func TestHello(t *testing.T) {
+	cfg := &config.ServerProperties{Dir: t.TempDir(), RequirePass: "secret"}
+	server := NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	c := connection.NewFakeConn()
+	exec := func(cmd ...string) string {
+		return string(server.Exec(c, utils.ToCmdLine(cmd...)).ToBytes())
+	}
+
+	assertReply(t, server.Exec(c, utils.ToCmdLine("HELLO", "4")), "-NOPROTO unsupported protocol version\r\n")
+	assertReply(t, server.Exec(c, utils.ToCmdLine("HELLO", "3", "AUTH", "default", "wrong")),
+		"-WRONGPASS invalid username-password pair or user is disabled.\r\n")
+	assertReply(t, server.Exec(c, utils.ToCmdLine("HELLO", "3", "SETNAME")), "-ERR Syntax error in HELLO option 'SETNAME'\r\n")
+	if c.GetProtocol() != protocol.RESP2 {
+		t.Fatal("failed HELLO should not switch protocol")
+	}
+
+	reply := exec("HELLO", "3", "AUTH", "default", "secret", "SETNAME", "worker-1")
+	if !strings.HasPrefix(reply, "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n") || !strings.Contains(reply, "$5\r\nproto\r\n:3\r\n") {
+		t.Errorf("unexpected HELLO reply %q", reply)
+	}
+	if c.GetPassword() != "secret" || c.GetClientName() != "worker-1" {
+		t.Error("HELLO should set password and client name")
+	}
+	exec("SET", "k", "v")
+	exec("ZADD", "z", "1.5", "a")
+	assertReply(t, server.Exec(c, utils.ToCmdLine("ZSCORE", "z", "a")), ",1.5\r\n")
+
+	// RESP3 订阅者收到 push 帧
+	exec("SUBSCRIBE", "ch")
+	c.Clean()
+	server.Exec(connection.NewFakeConn(), utils.ToCmdLine("PUBLISH", "ch", "hi"))
+	if actual := string(c.Bytes()); actual != ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n" {
+		t.Errorf("unexpected push frame %q", actual)
+	}
+	exec("UNSUBSCRIBE")
+
+	reply = exec("HELLO", "2")
+	if !strings.HasPrefix(reply, "*14\r\n") || !strings.Contains(reply, "$5\r\nproto\r\n:2\r\n") {
+		t.Errorf("unexpected HELLO reply %q", reply)
+	}
+	assertReply(t, server.Exec(c, utils.ToCmdLine("ZSCORE", "z", "a")), "$3\r\n1.5\r\n")
+
+	assertReply(t, protocol.MakeBooleanReply(true), "#t\r\n")
+	assertReply(t, protocol.ToRESP2(protocol.MakeMultiRawReply([]redis.Reply{protocol.MakeBooleanReply(false)})), "*1\r\n:0\r\n")
+	assertReply(t, protocol.ToRESP2(protocol.MakePushReply([]redis.Reply{protocol.MakeDoubleReply(1)})), "*1\r\n$1\r\n1\r\n")
+}
@@ -27,6 +27,8 @@ type command struct {
 	arity    int           //参数个数要求：<br>正数表示固定参数个数
 	flags    int           //命令标志位，如只读、写操作等
 	extra    *commandExtra //扩展信息，用于集群或 Lua 脚本中提取 keys
+	// ACL 分类，由 flags 和 signs 推导
+	categories aclCategory
 }
 
 type commandExtra struct {
@@ -55,19 +57,31 @@ func registerCommand(name string, executor ExecFunc, prepare PreFunc, rollback U
 		undo:     rollback,
 		arity:    arity,
 		flags:    flags,
+		// 特殊命令同样需要分类
+		categories: flagCategories(name, flags),
 	}
 	cmdTable[name] = cmd
 	return cmd
 }
 
+// GetRelatedKeys 返回命令会写入和读取的 key，未知命令或者参数个数错误时返回 nil
+func GetRelatedKeys(cmdLine [][]byte) ([]string, []string) {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, cmdLine) {
+		return nil, nil
+	}
+	return cmd.prepare(cmdLine[1:])
+}
+
 // 特殊命令的注册
 func registerSpecialCommand(name string, arity int, flags int) *command {
 	name = strings.ToLower(name)
 	flags |= flagSpecial
 	cmd := &command{
-		name:  name,
-		arity: arity,
-		flags: flags,
+		name:       name,
+		arity:      arity,
+		flags:      flags,
+		categories: flagCategories(name, flags),
 	}
 	cmdTable[name] = cmd
 	return cmd
@@ -80,6 +94,158 @@ func (cmd *command) attachCommandExtra(signs []string, firstKey int, lastKey int
 		lastKey:  lastKey,
 		keyStep:  keyStep,
 	}
+	cmd.categories = signCategories(cmd.categories, signs)
+}
+
+// execCommand 实现 COMMAND, COMMAND COUNT, COMMAND INFO [name ...], COMMAND DOCS [name ...], COMMAND GETKEYS cmd [arg ...]
+func execCommand(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeMultiRawReply(allCommandDescs())
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "count":
+		if len(args) != 1 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'command|count' command")
+		}
+		return protocol.MakeIntReply(int64(len(cmdTable) + len(serverCmdTable)))
+	case "info":
+		// 不指定命令时返回所有命令
+		if len(args) == 1 {
+			return protocol.MakeMultiRawReply(allCommandDescs())
+		}
+		replies := make([]redis.Reply, len(args)-1)
+		for i, name := range args[1:] {
+			cmd, ok := lookupCommand(strings.ToLower(string(name)))
+			if !ok {
+				replies[i] = protocol.MakeNullBulkReply()
+				continue
+			}
+			replies[i] = cmd.toDescReply()
+		}
+		return protocol.MakeMultiRawReply(replies)
+	case "docs":
+		return execCommandDocs(args[1:])
+	case "getkeys":
+		return execCommandGetKeys(args[1:])
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try COMMAND HELP.")
+}
+
+func allCommandDescs() []redis.Reply {
+	replies := make([]redis.Reply, 0, len(cmdTable)+len(serverCmdTable))
+	for _, table := range []map[string]*command{cmdTable, serverCmdTable} {
+		for _, cmd := range table {
+			replies = append(replies, cmd.toDescReply())
+		}
+	}
+	return replies
+}
+
+// commandGroups COMMAND DOCS 中的 group，按数据类型的 ACL 分类推导
+var commandGroups = []struct {
+	category aclCategory
+	group    string
+}{
+	{aclString, "string"},
+	{aclBitmap, "bitmap"},
+	{aclHash, "hash"},
+	{aclList, "list"},
+	{aclSet, "set"},
+	{aclSortedSet, "sorted-set"},
+	{aclGeo, "geo"},
+	{aclStream, "stream"},
+	{aclPubSub, "pubsub"},
+	{aclTransaction, "transactions"},
+	{aclConnection, "connection"},
+	{aclKeyspace, "generic"},
+}
+
+func (cmd *command) group() string {
+	for _, g := range commandGroups {
+		if cmd.categories&g.category != 0 {
+			return g.group
+		}
+	}
+	return "server"
+}
+
+// execCommandDocs 没有命令的说明文档，只返回 group；不认识的命令不出现在结果中
+func execCommandDocs(names [][]byte) redis.Reply {
+	var cmds []*command
+	if len(names) == 0 {
+		for _, table := range []map[string]*command{cmdTable, serverCmdTable} {
+			for _, cmd := range table {
+				cmds = append(cmds, cmd)
+			}
+		}
+	}
+	for _, name := range names {
+		if cmd, ok := lookupCommand(strings.ToLower(string(name))); ok {
+			cmds = append(cmds, cmd)
+		}
+	}
+	replies := make([]redis.Reply, 0, 2*len(cmds))
+	for _, cmd := range cmds {
+		replies = append(replies,
+			protocol.MakeBulkReply([]byte(cmd.name)),
+			protocol.MakeMapReply([]redis.Reply{
+				protocol.MakeBulkReply([]byte("group")), protocol.MakeBulkReply([]byte(cmd.group())),
+			}))
+	}
+	return protocol.MakeMapReply(replies)
+}
+
+// execCommandGetKeys 优先使用 prepare 分析出的读写 key，按它们在参数中出现的顺序返回；
+// 没有 prepare 的命令按 firstKey/lastKey/keyStep 取 key
+func execCommandGetKeys(cmdLine [][]byte) redis.Reply {
+	if len(cmdLine) == 0 {
+		return protocol.MakeErrReply("ERR wrong number of arguments for 'command|getkeys' command")
+	}
+	cmd, ok := lookupCommand(strings.ToLower(string(cmdLine[0])))
+	if !ok {
+		return protocol.MakeErrReply("ERR Invalid command specified")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return protocol.MakeErrReply("ERR Invalid number of arguments specified for command")
+	}
+	keys := commandKeyArgs(cmd, cmdLine)
+	if len(keys) == 0 {
+		return protocol.MakeErrReply("ERR The command has no key arguments")
+	}
+	return protocol.MakeMultiBulkReply(keys)
+}
+
+// commandKeyArgs 返回命令参数中的 key，调用方已经检查过参数个数
+func commandKeyArgs(cmd *command, cmdLine [][]byte) [][]byte {
+	var keys [][]byte
+	if cmd.prepare != nil {
+		write, read := cmd.prepare(cmdLine[1:])
+		related := make(map[string]bool, len(write)+len(read))
+		for _, key := range append(write, read...) {
+			related[key] = true
+		}
+		for _, arg := range cmdLine[1:] {
+			if related[string(arg)] {
+				// 同一个 key 只返回一次
+				related[string(arg)] = false
+				keys = append(keys, arg)
+			}
+		}
+	} else if cmd.extra != nil && cmd.extra.firstKey > 0 {
+		last := cmd.extra.lastKey
+		if last < 0 {
+			last += len(cmdLine)
+		}
+		step := cmd.extra.keyStep
+		if step <= 0 {
+			step = 1
+		}
+		for i := cmd.extra.firstKey; i <= last && i < len(cmdLine); i += step {
+			keys = append(keys, cmdLine[i])
+		}
+	}
+	return keys
 }
 
 // 将一个命令（command 结构体）转换为 Redis 客户端可识别的响应格式（redis.Reply 类型），用于描述该命令的相关信息。
@@ -99,6 +265,19 @@ func (cmd *command) toDescReply() redis.Reply {
 			protocol.MakeIntReply(int64(cmd.extra.lastKey)),
 			protocol.MakeIntReply(int64(cmd.extra.keyStep)),
 		)
+	} else {
+		args = append(args,
+			protocol.MakeEmptyMultiBulkReply(),
+			protocol.MakeIntReply(0),
+			protocol.MakeIntReply(0),
+			protocol.MakeIntReply(0),
+		)
+	}
+	categories := cmd.categories.names()
+	names := make([][]byte, len(categories))
+	for i, v := range categories {
+		names[i] = []byte(v)
 	}
+	args = append(args, protocol.MakeMultiBulkReply(names))
 	return protocol.MakeMultiRawReply(args)
 }
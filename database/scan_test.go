@@ -0,0 +1,198 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// SCAN 的保证
+//   - 整个遍历期间一直存在的 key 至少返回一次
+//   - 游标回到 0 时遍历结束，并发写入和 FLUSHDB 不会让遍历无法结束
+//   - 过期的游标(例如 FLUSHDB 之前得到的)不会导致错误或者 panic，非法游标返回 ERR invalid cursor
+//   - 游标按反向二进制顺序遍历分片，分片数变化后继续使用原来的游标同样满足第一条
+
+// scanOnce 执行一次 SCAN，返回下一个游标和 key
+func scanOnce(t *testing.T, server *Server, conn redis.Connection, cursor string, args ...string) (string, []string) {
+	t.Helper()
+	reply := server.Exec(conn, utils.ToCmdLine(append([]string{"SCAN", cursor}, args...)...))
+	raw, ok := reply.(*protocol.MultiRawReply)
+	if !ok || len(raw.Replies) != 2 {
+		t.Fatalf("unexpected SCAN reply %q", reply.ToBytes())
+	}
+	next := string(raw.Replies[0].(*protocol.BulkReply).Arg)
+	var keys []string
+	for _, key := range raw.Replies[1].(*protocol.MultiBulkReply).Args {
+		keys = append(keys, string(key))
+	}
+	return next, keys
+}
+
+// scanAll 从游标 0 遍历到结束，每次遍历之间调用 between
+func scanAll(t *testing.T, server *Server, conn redis.Connection, between func(round int), args ...string) map[string]int {
+	t.Helper()
+	seen := make(map[string]int)
+	cursor := "0"
+	for round := 0; ; round++ {
+		next, keys := scanOnce(t, server, conn, cursor, args...)
+		for _, key := range keys {
+			seen[key]++
+		}
+		if next == "0" {
+			return seen
+		}
+		if round > 1_000_000 {
+			t.Fatal("scan did not terminate")
+		}
+		cursor = next
+		if between != nil {
+			between(round)
+		}
+	}
+}
+
+func TestScanWithConcurrentWrites(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	const stable = 2000
+	for i := 0; i < stable; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "stable:"+strconv.Itoa(i), "v"))
+	}
+
+	// 并发写入、删除和过期其它 key
+	var stop atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			c := connection.NewFakeConn()
+			for i := 0; !stop.Load(); i++ {
+				key := "churn:" + strconv.Itoa(w) + ":" + strconv.Itoa(i%500)
+				switch i % 4 {
+				case 0, 1:
+					server.Exec(c, utils.ToCmdLine("SET", key, "v"))
+				case 2:
+					server.Exec(c, utils.ToCmdLine("DEL", key))
+				case 3:
+					server.Exec(c, utils.ToCmdLine("RPUSH", key+":list", "a"))
+					server.Exec(c, utils.ToCmdLine("PEXPIRE", key+":list", "1"))
+				}
+			}
+		}(w)
+	}
+
+	for _, args := range [][]string{nil, {"COUNT", "7"}, {"MATCH", "stable:*", "COUNT", "100"}, {"TYPE", "string"}} {
+		seen := scanAll(t, server, conn, nil, args...)
+		for i := 0; i < stable; i++ {
+			if seen["stable:"+strconv.Itoa(i)] == 0 {
+				t.Fatalf("SCAN %v missed stable:%d", args, i)
+			}
+		}
+	}
+	stop.Store(true)
+	wg.Wait()
+}
+
+func TestScanAcrossFlushDB(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	for i := 0; i < 1000; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "old:"+strconv.Itoa(i), "v"))
+	}
+	other := connection.NewFakeConn()
+	// 遍历中途清空数据库并写入新的 key，遍历仍然以 0 结束，新 key 在 FLUSHDB 之后一直存在
+	seen := scanAll(t, server, conn, func(round int) {
+		if round == 3 {
+			assertReply(t, server.Exec(other, utils.ToCmdLine("FLUSHDB")), "+OK\r\n")
+			for i := 0; i < 1000; i++ {
+				server.Exec(other, utils.ToCmdLine("SET", "new:"+strconv.Itoa(i), "v"))
+			}
+		}
+	}, "COUNT", "20")
+	if len(seen) == 0 {
+		t.Fatal("scan returned nothing")
+	}
+
+	// FLUSHDB 之前的游标仍然可以继续使用
+	cursor, _ := scanOnce(t, server, conn, "0", "COUNT", "5")
+	server.Exec(other, utils.ToCmdLine("FLUSHDB"))
+	server.Exec(other, utils.ToCmdLine("SET", "after", "v"))
+	for cursor != "0" {
+		cursor, _ = scanOnce(t, server, conn, cursor, "COUNT", "5")
+	}
+	// 超出范围的游标只取低位，全 1 是遍历顺序中最后一个分片；非法游标返回错误
+	if next, _ := scanOnce(t, server, conn, "18446744073709551615"); next != "0" {
+		t.Errorf("expected end of scan for out of range cursor, got %s", next)
+	}
+	for _, cursor := range []string{"-1", "abc", "1.5"} {
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("SCAN", cursor)), "-ERR invalid cursor\r\n")
+	}
+	// 遍历空数据库
+	server.Exec(other, utils.ToCmdLine("FLUSHDB"))
+	if next, keys := scanOnce(t, server, conn, "0"); next != "0" || len(keys) != 0 {
+		t.Errorf("expected empty scan, got %s %v", next, keys)
+	}
+}
+
+func TestScanTypeFilter(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	for i := 0; i < 2000; i++ {
+		server.Exec(conn, utils.ToCmdLine("SET", "str:"+strconv.Itoa(i), "v"))
+	}
+	for i := 0; i < 30; i++ {
+		server.Exec(conn, utils.ToCmdLine("RPUSH", "list:"+strconv.Itoa(i), "a"))
+	}
+	// 类型不符的 key 不占用 COUNT，除了最后一页每页都有 key
+	total := 0
+	cursor := "0"
+	for {
+		next, keys := scanOnce(t, server, conn, cursor, "TYPE", "list", "COUNT", "5")
+		for _, key := range keys {
+			if key[:5] != "list:" {
+				t.Fatalf("unexpected key %s", key)
+			}
+		}
+		total += len(keys)
+		if next == "0" {
+			break
+		}
+		if len(keys) == 0 {
+			t.Fatalf("empty page at cursor %s", cursor)
+		}
+		cursor = next
+	}
+	if total != 30 {
+		t.Errorf("expected 30 lists, got %d", total)
+	}
+
+	reply := server.Exec(conn, utils.ToCmdLine("KEYS", "*", "TYPE", "list"))
+	if keys := reply.(*protocol.MultiBulkReply).Args; len(keys) != 30 {
+		t.Errorf("expected 30 lists from KEYS, got %d", len(keys))
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("KEYS", "str:1", "TYPE", "string")), "*1\r\n$5\r\nstr:1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("KEYS", "*", "TYPE")), "-ERR syntax error\r\n")
+}
+
+func TestRandomKeySkipsExpired(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("RANDOMKEY")), "$-1\r\n")
+	for i := 0; i < 50; i++ {
+		key := "volatile:" + strconv.Itoa(i)
+		server.Exec(conn, utils.ToCmdLine("SET", key, "v"))
+		server.Exec(conn, utils.ToCmdLine("PEXPIRE", key, "1"))
+	}
+	server.Exec(conn, utils.ToCmdLine("SET", "live", "v"))
+	time.Sleep(10 * time.Millisecond)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("RANDOMKEY")), "$4\r\nlive\r\n")
+}
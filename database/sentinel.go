@@ -0,0 +1,315 @@
+package database
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 最小化的 sentinel
+// 不做故障转移，只提供客户端库需要的 SENTINEL 查询命令，
+// 例如 get-master-addr-by-name/sentinels/replicas，让支持 sentinel 的客户端可以通过本项目发现主节点。
+// 主节点来自 sentinel-monitor 配置，后台每隔 sentinelProbeInterval 向主节点发送 INFO replication，
+// 记录主节点是否可达以及它的从节点
+
+const (
+	sentinelProbeInterval = time.Second
+	sentinelProbeTimeout  = time.Second
+	// 超过这个时间没有探测成功的主节点标记为 s_down
+	sentinelDownAfter = 5 * time.Second
+)
+
+// sentinelReplica 探测主节点时得到的从节点
+type sentinelReplica struct {
+	ip    string
+	port  string
+	state string
+}
+
+// sentinelMaster 一个被监控的主节点
+type sentinelMaster struct {
+	name   string
+	host   string
+	port   string
+	quorum int
+
+	mu       sync.Mutex
+	lastOK   time.Time
+	replicas []sentinelReplica
+}
+
+type sentinelState struct {
+	masters []*sentinelMaster // 按配置的顺序
+	peers   []string
+	auth    string
+	stop    chan struct{}
+	started time.Time
+}
+
+// newSentinelState 解析 sentinel-monitor 和 sentinel-peers
+func newSentinelState(monitors []string, peers []string, auth string) (*sentinelState, error) {
+	state := &sentinelState{
+		auth:    auth,
+		stop:    make(chan struct{}),
+		started: time.Now(),
+	}
+	for _, monitor := range monitors {
+		fields := strings.Fields(monitor)
+		if len(fields) != 4 {
+			return nil, errors.New("invalid sentinel-monitor: " + monitor)
+		}
+		port, err := strconv.Atoi(fields[2])
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, errors.New("invalid sentinel-monitor port: " + monitor)
+		}
+		quorum, err := strconv.Atoi(fields[3])
+		if err != nil || quorum <= 0 {
+			return nil, errors.New("invalid sentinel-monitor quorum: " + monitor)
+		}
+		if state.master(fields[0]) != nil {
+			return nil, errors.New("duplicated master name: " + fields[0])
+		}
+		state.masters = append(state.masters, &sentinelMaster{
+			name:   fields[0],
+			host:   fields[1],
+			port:   fields[2],
+			quorum: quorum,
+		})
+	}
+	for _, peer := range peers {
+		peer = strings.TrimSpace(peer)
+		if peer == "" {
+			continue
+		}
+		if _, _, err := net.SplitHostPort(peer); err != nil {
+			return nil, errors.New("invalid sentinel-peers: " + peer)
+		}
+		state.peers = append(state.peers, peer)
+	}
+	return state, nil
+}
+
+func (state *sentinelState) master(name string) *sentinelMaster {
+	for _, m := range state.masters {
+		if m.name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// run 定期探测所有主节点，直到 close
+func (state *sentinelState) run() {
+	ticker := time.NewTicker(sentinelProbeInterval)
+	defer ticker.Stop()
+	for {
+		for _, m := range state.masters {
+			state.probe(m)
+		}
+		select {
+		case <-state.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (state *sentinelState) close() {
+	close(state.stop)
+}
+
+// probe 向主节点发送 INFO replication，成功时更新从节点列表
+func (state *sentinelState) probe(m *sentinelMaster) {
+	info, err := fetchReplicationInfo(net.JoinHostPort(m.host, m.port), state.auth)
+	if err != nil {
+		return
+	}
+	var replicas []sentinelReplica
+	for _, line := range strings.Split(info, "\r\n") {
+		if !strings.HasPrefix(line, "slave") {
+			continue
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+		if _, err := strconv.Atoi(line[len("slave"):colon]); err != nil {
+			continue
+		}
+		replica := sentinelReplica{}
+		for _, kv := range strings.Split(line[colon+1:], ",") {
+			key, value, _ := strings.Cut(kv, "=")
+			switch key {
+			case "ip":
+				replica.ip = value
+			case "port":
+				replica.port = value
+			case "state":
+				replica.state = value
+			}
+		}
+		if replica.ip != "" && replica.port != "" {
+			replicas = append(replicas, replica)
+		}
+	}
+	m.mu.Lock()
+	m.lastOK = time.Now()
+	m.replicas = replicas
+	m.mu.Unlock()
+}
+
+// fetchReplicationInfo 返回 INFO replication 的内容
+func fetchReplicationInfo(addr string, auth string) (string, error) {
+	conn, err := net.DialTimeout("tcp", addr, sentinelProbeTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(sentinelProbeTimeout))
+	reader := bufio.NewReader(conn)
+	if auth != "" {
+		if _, err = sendReplCmd(conn, reader, "AUTH", auth); err != nil {
+			return "", err
+		}
+	}
+	line, err := sendReplCmd(conn, reader, "INFO", "replication")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(line, "$") {
+		return "", errors.New("unexpected INFO reply: " + line)
+	}
+	size, err := strconv.Atoi(line[1:])
+	if err != nil || size < 0 {
+		return "", errors.New("unexpected INFO reply: " + line)
+	}
+	body := make([]byte, size+2)
+	if _, err = io.ReadFull(reader, body); err != nil {
+		return "", err
+	}
+	return string(body[:size]), nil
+}
+
+// down 超过 sentinelDownAfter 没有探测成功
+func (state *sentinelState) down(m *sentinelMaster) bool {
+	m.mu.Lock()
+	last := m.lastOK
+	m.mu.Unlock()
+	if last.IsZero() {
+		last = state.started
+	}
+	return time.Since(last) > sentinelDownAfter
+}
+
+func (state *sentinelState) masterFields(m *sentinelMaster) [][]byte {
+	flags := "master"
+	if state.down(m) {
+		flags += ",s_down"
+	}
+	m.mu.Lock()
+	numSlaves := len(m.replicas)
+	m.mu.Unlock()
+	return toBulks(
+		"name", m.name,
+		"ip", m.host,
+		"port", m.port,
+		"flags", flags,
+		"num-slaves", strconv.Itoa(numSlaves),
+		"num-other-sentinels", strconv.Itoa(len(state.peers)),
+		"quorum", strconv.Itoa(m.quorum),
+		"down-after-milliseconds", strconv.FormatInt(sentinelDownAfter.Milliseconds(), 10),
+	)
+}
+
+func toBulks(values ...string) [][]byte {
+	result := make([][]byte, len(values))
+	for i, v := range values {
+		result[i] = []byte(v)
+	}
+	return result
+}
+
+// execSentinel SENTINEL subcommand [args]
+func (server *Server) execSentinel(args [][]byte) redis.Reply {
+	state := server.sentinel
+	if state == nil {
+		return protocol.MakeErrReply("ERR This instance is not running in sentinel mode")
+	}
+	sub := strings.ToLower(string(args[0]))
+	switch sub {
+	case "masters":
+		if len(args) != 1 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'sentinel|masters' command")
+		}
+		replies := make([]redis.Reply, 0, len(state.masters))
+		for _, m := range state.masters {
+			replies = append(replies, protocol.MakeMultiBulkReply(state.masterFields(m)))
+		}
+		return protocol.MakeMultiRawReply(replies)
+	case "myid":
+		if len(args) != 1 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'sentinel|myid' command")
+		}
+		return protocol.MakeBulkReply([]byte(server.cfg.RunID))
+	case "get-master-addr-by-name", "master", "sentinels", "replicas", "slaves":
+	default:
+		return protocol.MakeErrReply("ERR Unknown sentinel subcommand '" + string(args[0]) + "'")
+	}
+	if len(args) != 2 {
+		return protocol.MakeErrReply("ERR wrong number of arguments for 'sentinel|" + sub + "' command")
+	}
+	m := state.master(string(args[1]))
+	if m == nil {
+		if sub == "get-master-addr-by-name" {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeErrReply("ERR No such master with that name")
+	}
+	switch sub {
+	case "get-master-addr-by-name":
+		return protocol.MakeMultiBulkReply(toBulks(m.host, m.port))
+	case "master":
+		return protocol.MakeMultiBulkReply(state.masterFields(m))
+	case "sentinels":
+		replies := make([]redis.Reply, 0, len(state.peers))
+		for _, peer := range state.peers {
+			host, port, _ := net.SplitHostPort(peer)
+			replies = append(replies, protocol.MakeMultiBulkReply(toBulks(
+				"name", peer,
+				"ip", host,
+				"port", port,
+				"flags", "sentinel",
+			)))
+		}
+		return protocol.MakeMultiRawReply(replies)
+	}
+	// replicas/slaves
+	m.mu.Lock()
+	replicas := m.replicas
+	m.mu.Unlock()
+	replies := make([]redis.Reply, 0, len(replicas))
+	for _, replica := range replicas {
+		linkStatus := "err"
+		if replica.state == "online" {
+			linkStatus = "ok"
+		}
+		replies = append(replies, protocol.MakeMultiBulkReply(toBulks(
+			"name", net.JoinHostPort(replica.ip, replica.port),
+			"ip", replica.ip,
+			"port", replica.port,
+			"flags", "slave",
+			"master-link-status", linkStatus,
+			"master-host", m.host,
+			"master-port", m.port,
+		)))
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
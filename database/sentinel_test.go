@@ -0,0 +1,68 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestSentinel(t *testing.T) {
+	master := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		Databases:      16,
+	})
+	defer master.Close()
+	host, port := serveForTest(t, master)
+
+	slave := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:       16,
+		Port:            7001,
+		SlaveAnnounceIP: "10.0.0.2",
+		ReplicaOf:       host + " " + port,
+	})
+	defer slave.Close()
+
+	sentinel := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:       16,
+		Sentinel:        true,
+		SentinelMonitor: []string{"mymaster " + host + " " + port + " 2"},
+		SentinelPeers:   []string{"10.0.0.5:26379"},
+	})
+	defer sentinel.Close()
+	conn := connection.NewFakeConn()
+
+	assertReply(t, sentinel.Exec(conn, utils.ToCmdLine("SENTINEL", "get-master-addr-by-name", "mymaster")),
+		"*2\r\n$"+strconv.Itoa(len(host))+"\r\n"+host+"\r\n$"+strconv.Itoa(len(port))+"\r\n"+port+"\r\n")
+	assertReply(t, sentinel.Exec(conn, utils.ToCmdLine("SENTINEL", "get-master-addr-by-name", "other")), "$-1\r\n")
+	assertReply(t, sentinel.Exec(conn, utils.ToCmdLine("SENTINEL", "master", "other")),
+		"-ERR No such master with that name\r\n")
+	assertReply(t, sentinel.Exec(conn, utils.ToCmdLine("SENTINEL", "sentinels", "mymaster")),
+		"*1\r\n*8\r\n$4\r\nname\r\n$14\r\n10.0.0.5:26379\r\n$2\r\nip\r\n$8\r\n10.0.0.5\r\n"+
+			"$4\r\nport\r\n$5\r\n26379\r\n$5\r\nflags\r\n$8\r\nsentinel\r\n")
+
+	waitFor(t, "replica was not observed", func() bool {
+		reply := string(sentinel.Exec(conn, utils.ToCmdLine("SENTINEL", "replicas", "mymaster")).ToBytes())
+		return reply != "*0\r\n"
+	})
+	reply := string(sentinel.Exec(conn, utils.ToCmdLine("SENTINEL", "slaves", "mymaster")).ToBytes())
+	expected := "*1\r\n*14\r\n$4\r\nname\r\n$13\r\n10.0.0.2:7001\r\n$2\r\nip\r\n$8\r\n10.0.0.2\r\n$4\r\nport\r\n$4\r\n7001\r\n"
+	if !strings.HasPrefix(reply, expected) {
+		t.Fatalf("unexpected replicas reply %q", reply)
+	}
+
+	masters := string(sentinel.Exec(conn, utils.ToCmdLine("SENTINEL", "masters")).ToBytes())
+	if want := "$5\r\nflags\r\n$6\r\nmaster\r\n"; !strings.Contains(masters, want) {
+		t.Fatalf("expected master flags in %q", masters)
+	}
+
+	plain := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer plain.Close()
+	assertReply(t, plain.Exec(conn, utils.ToCmdLine("SENTINEL", "masters")),
+		"-ERR This instance is not running in sentinel mode\r\n")
+}
@@ -1,12 +1,14 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"os"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,6 +16,8 @@ import (
 	"github.com/zhangming/go-redis/config"
 	"github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
+	"github.com/zhangming/go-redis/lib/timewheel"
 	"github.com/zhangming/go-redis/lib/utils"
 	"github.com/zhangming/go-redis/pubhub"
 	"github.com/zhangming/go-redis/redis/protocol"
@@ -21,14 +25,90 @@ import (
 
 var godisVersion = "1.2.8" // do not modify
 
-
 type Server struct {
+	// 实例配置，默认为全局的 config.Properties
+	cfg *config.ServerProperties
+	// 启动时根据 GOMAXPROCS 和 maxclients 推导的参数
+	tuning config.Tuning
+	// 同时执行数据命令的名额，大小为 tuning.WorkerPoolSize
+	workers workerPool
+
 	dbSet []*atomic.Value // 数据库序号
 
 	// subscribe publish
 	hub *pubhub.Hub
 	// handle aof persistence
 	persister *aof.Persister
+	// 开启 aof-slot-partitions 时使用按槽位分区的 aof，此时 persister 为 nil
+	partitions *aof.PartitionedPersister
+	// 正在全量同步或已经完成同步的从节点 redis.Connection -> *slaveFeed
+	slaves sync.Map
+	// 从节点通过 REPLCONF 公布的地址 redis.Connection -> *slaveAnnounce
+	slaveAddrs sync.Map
+	// 正在等待 repl-diskless-sync-delay 的一批从节点，没有时为 nil
+	disklessBatch *disklessBatch
+	disklessMu    sync.Mutex
+	// 作为从节点时的主节点和同步状态
+	repl *replicationStatus
+	// sentinel 模式下监控的主节点，不是 sentinel 模式时为 nil
+	sentinel *sentinelState
+	// 槽位迁移状态，只在集群模式下生效
+	slots *slotTable
+	// 所有用户 name -> *aclUser，包括 default 用户，ACL SETUSER/DELUSER 修改时持有 usersMu
+	users   map[string]*aclUser
+	usersMu sync.RWMutex
+	// deny-commands 禁止执行的命令
+	denied  map[string]struct{}
+	slowlog *slowLog
+	// 过期任务调度器和 UNLINK 的后台释放，每个实例各自一份，同一进程中的实例互不影响。
+	// 用于重放 aof 的临时实例与所属实例共用调度器，lazyfree 为 nil
+	expires  *timewheel.Scheduler
+	lazyfree *lazyFreer
+	// slowlog-log-slower-than，可以用 CONFIG SET 修改
+	slowlogThreshold atomic.Int64
+	// notify-keyspace-events 解析后的通知类型，可以用 CONFIG SET 修改
+	notifyFlags atomic.Int64
+	// CONFIG SET 修改 cfg 时持有，CONFIG GET 读取 cfg 时同样持有
+	configMu sync.Mutex
+
+	// 关闭时先拿写锁拒绝新的写命令，并等待正在执行的写命令结束
+	writeGate sync.RWMutex
+	// writeGate 在所有 key 锁之前获取
+	writeGateClass *lockorder.Class
+	closing        bool
+	// 关闭时 close，结束所有阻塞命令
+	shutdown chan struct{}
+	// 正在 execOnce 中执行的命令数，关闭时等待它们结束
+	executing atomic.Int64
+	// 开始关闭之后拒绝新的命令，SHUTDOWN 保存失败时恢复
+	stopping atomic.Bool
+	// 保证只关闭一次，closed 之后 Close 直接返回
+	shutdownMu sync.Mutex
+	closed     bool
+	// SHUTDOWN 成功后 close，通知 tcp 服务器停止监听并退出
+	shutdownRequested chan struct{}
+	// 正在执行阻塞命令的连接 redis.Connection -> chan struct{}，连接断开时 close
+	blockedConns sync.Map
+
+	// INFO stats 的计数器和每秒速率
+	stats *serverStats
+	// 每个命令的调用次数和耗时，见 metrics.go
+	cmdStats *commandStats
+	// audit-log-file 配置的审计日志，没有配置时为 nil
+	audit *auditLog
+	// DEBUG SET-ACTIVE-EXPIRE 0 关闭主动过期
+	activeExpireOff atomic.Bool
+	// rdb 快照的修改次数、保存状态和自动保存规则
+	rdb rdbSaveState
+	// 正在执行的 aof 重写数和上次重写是否失败
+	aofRewriting     atomic.Int32
+	aofRewriteFailed atomic.Bool
+	// 热点 key 统计，nil 表示没有开启
+	hotkeys *hotKeyProfiler
+	// 已连接的客户端 id uint64 -> redis.Connection
+	clients sync.Map
+	// 执行了 MONITOR 的连接
+	monitors monitorRegistry
 
 	// 回调函数
 	insertCallback database.KeyEventCallback
@@ -42,57 +122,266 @@ func fileExists(filename string) bool {
 
 // AfterClientClose does some clean after client close connection
 func (server *Server) AfterClientClose(c redis.Connection) {
+	server.clients.Delete(c.GetID())
+	server.releaseUser(c)
 	pubhub.UnsubscribeAll(server.hub, c)
+	if raw, ok := server.slaves.Load(c); ok {
+		server.removeSlave(c, raw.(*slaveFeed))
+	}
+	server.slaveAddrs.Delete(c)
+	server.monitors.remove(c)
+	server.CancelBlocking(c)
 }
 
+// Close 与不带参数的 SHUTDOWN 相同，保存失败时不保存直接关闭，见 shutdownServer
 func (server *Server) Close() {
+	if err := server.shutdownServer(shutdownSaveDefault, 0); err != nil {
+		slog.Error("save before shutdown failed, shutting down without saving", "error", err)
+		_ = server.shutdownServer(shutdownNoSave, 0)
+	}
+}
+
+// closeResources 拒绝新的写命令，等待正在执行的写命令完成后再关闭持久化，保证已经回复的写命令都落盘
+func (server *Server) closeResources() {
+	if server.shutdown != nil {
+		// 阻塞命令不持有 writeGate，需要先结束它们
+		select {
+		case <-server.shutdown:
+		default:
+			close(server.shutdown)
+		}
+	}
+	server.writeGateClass.Acquire(0)
+	server.writeGate.Lock()
+	server.closing = true
+	server.writeGate.Unlock()
+	server.writeGateClass.Release(0)
+	if server.repl != nil {
+		server.repl.stopReplication()
+	}
+	if server.sentinel != nil {
+		server.sentinel.close()
+	}
 	if server.persister != nil {
 		server.persister.Close()
 	}
+	if server.partitions != nil {
+		server.partitions.Close()
+	}
+	if server.audit != nil {
+		server.audit.close()
+	}
+	if server.expires != nil {
+		server.expires.Stop()
+	}
+	if server.lazyfree != nil {
+		server.lazyfree.stop()
+	}
+}
+
+// isWriteCommand 判断命令是否会修改数据
+func isWriteCommand(cmdName string) bool {
+	if cmdName == "flushdb" || cmdName == "flushall" || cmdName == "swapdb" || cmdName == "exec" ||
+		cmdName == "expirepattern" || cmdName == "persistpattern" {
+		return true
+	}
+	cmd, ok := cmdTable[cmdName]
+	return ok && cmd.flags&flagReadOnly == 0
 }
 
 // 创捷sercer
 func NewStandaloneServer() *Server {
-	server := &Server{}
-	if config.Properties.Databases == 0 {
-		config.Properties.Databases = 16
+	return NewStandaloneServerWithConfig(config.Properties)
+}
+
+// NewStandaloneServerWithConfig 使用独立的配置创建实例，同一进程中可以运行多个互不影响的实例
+func NewStandaloneServerWithConfig(cfg *config.ServerProperties) *Server {
+	server := &Server{
+		cfg:      cfg,
+		hub:      pubhub.MakeHub(),
+		slots:    makeSlotTable(),
+		repl:     &replicationStatus{},
+		shutdown: make(chan struct{}),
+		stats:    &serverStats{},
+		cmdStats: makeCommandStats(),
+		slowlog:  makeSlowLog(cfg.SlowlogMaxLen),
+		hotkeys:  makeHotKeyProfiler(cfg),
+		expires:  timewheel.NewScheduler(),
+		lazyfree: &lazyFreer{},
+
+		writeGateClass:    lockorder.NewClass("server.writeGate"),
+		shutdownRequested: make(chan struct{}),
+	}
+	server.expires.Start()
+	server.tuning = cfg.Tuning()
+	server.workers = makeWorkerPool(server.tuning.WorkerPoolSize)
+	server.slowlogThreshold.Store(int64(cfg.SlowlogLogSlowerThan))
+	server.hub.SetRetainLimit(cfg.PubsubRetainMax)
+	go server.stats.run(server.shutdown)
+	if cfg.Databases == 0 {
+		cfg.Databases = 16
+	}
+	if cfg.RunID == "" {
+		// 全量同步时作为复制 id 发给从节点
+		cfg.RunID = utils.RandString(40)
 	}
-	server.dbSet = make([]*atomic.Value, config.Properties.Databases)
+	server.dbSet = make([]*atomic.Value, cfg.Databases)
 	// 创建临时文件，防止写入aof和rdb的时候，导致失败时毁坏源文件
-	err := os.MkdirAll(config.GetTmpDir(), os.ModePerm)
+	err := os.MkdirAll(cfg.TmpDir(), os.ModePerm)
 	if err != nil {
-		slog.Error("mkdir failed", "path", config.GetTmpDir(), "error", err)
+		slog.Error("mkdir failed", "path", cfg.TmpDir(), "error", err)
 	}
 	for i := range server.dbSet {
-		singleDB := makeBasicDB()
-		singleDB.index = i
+		singleDB := server.makeDB()
+		singleDB.setIndex(i)
+		singleDB.cfg = cfg
+		singleDB.notifier = server.notifyKeyspaceEvent
+		singleDB.blocking = makeBlockingKeys()
+		singleDB.stats = server.stats
+		singleDB.hotkeys = server.hotkeys
+		singleDB.activeExpireOff = &server.activeExpireOff
+		singleDB.lazyfree = server.lazyfree
+		singleDB.publisher = server.publish
+		singleDB.aofSaver = server.saveAof
 		holder := &atomic.Value{}
 		holder.Store(singleDB)
 		server.dbSet[i] = holder
 	}
+	if cfg.MaxMemoryPolicy != "" && !validPolicy(strings.ToLower(cfg.MaxMemoryPolicy)) {
+		panic("invalid maxmemory-policy: " + cfg.MaxMemoryPolicy)
+	}
+	if cfg.NotifyKeyspaceEvents != "" {
+		flags, ok := parseNotifyFlags(cfg.NotifyKeyspaceEvents)
+		if !ok {
+			panic("invalid notify-keyspace-events: " + cfg.NotifyKeyspaceEvents)
+		}
+		server.notifyFlags.Store(int64(flags))
+	}
+	defaultRules := "+@all"
+	if cfg.AclDefaultRules != "" {
+		defaultRules = cfg.AclDefaultRules
+	}
+	commands, err := parseAclRules(defaultRules)
+	if err != nil {
+		panic(err)
+	}
+	users, err := parseAclUsers(cfg.AclUsers, commands)
+	if err != nil {
+		panic(err)
+	}
+	users[defaultUser] = newDefaultUser(cfg.RequirePass, commands)
+	server.users = users
+	if len(cfg.DenyCommands) > 0 {
+		denied, err := parseDenyCommands(cfg.DenyCommands)
+		if err != nil {
+			panic(err)
+		}
+		server.denied = denied
+	}
 	validAof := false
-	if config.Properties.AppendOnly {
-		validAof = fileExists(config.Properties.AppendFilename)
-		aofHandler, err := NewPersister(server,
-			config.Properties.AppendFilename, true, config.Properties.AppendFsync)
+	if cfg.AppendOnly && len(cfg.AofSlotPartitions) > 0 {
+		ranges, err := aof.ParseSlotRanges(cfg.AofSlotPartitions)
+		if err != nil {
+			panic(err)
+		}
+		for _, r := range ranges {
+			validAof = validAof || fileExists(aof.PartitionFilename(cfg.AppendFilePath(), r))
+			if err := aof.CheckFileFormat(aof.PartitionFilename(cfg.AppendFilePath(), r)); err != nil {
+				panic(err)
+			}
+			if err := aof.PrepareLoad(aof.PartitionFilename(cfg.AppendFilePath(), r), cfg.AofLoadTruncatedAllowed()); err != nil {
+				panic(err)
+			}
+		}
+		partitions, err := aof.NewPartitionedPersister(cfg, server, ranges, true, func() database.DBEngine {
+			return makeAuxiliaryServer(cfg, server.expires)
+		})
+		if err != nil {
+			panic(err)
+		}
+		server.bindPartitions(partitions)
+	} else if cfg.AppendOnly && cfg.AppendDirname != "" {
+		files, err := aof.ManifestFiles(cfg.AppendDirPath(), cfg.AppendFilename)
+		if err != nil {
+			panic(err)
+		}
+		if len(files) == 0 {
+			// 还没有清单时单个 aof 文件会被移入目录作为 base
+			files = []string{cfg.AppendFilePath()}
+		}
+		for i, filename := range files {
+			validAof = validAof || fileExists(filename)
+			if err := aof.CheckFileFormat(filename); err != nil {
+				panic(err)
+			}
+			// 只有最后一个文件还在追加，之前的文件不完整说明数据损坏
+			if err := aof.PrepareLoad(filename, i == len(files)-1 && cfg.AofLoadTruncatedAllowed()); err != nil {
+				panic(err)
+			}
+		}
+		aofHandler, err := aof.NewMultiPartPersister(cfg, server, !cfg.AofLoadLazy, func() database.DBEngine {
+			return makeAuxiliaryServer(cfg, server.expires)
+		})
+		if err != nil {
+			panic(err)
+		}
+		server.bindPersister(aofHandler)
+		if cfg.AofLoadLazy {
+			aofHandler.LoadInBackground()
+		}
+	} else if cfg.AppendOnly {
+		validAof = fileExists(cfg.AppendFilePath())
+		// 更新的引擎写入的文件不能安全加载，直接拒绝启动
+		if err := aof.CheckFileFormat(cfg.AppendFilePath()); err != nil {
+			panic(err)
+		}
+		// 截断不完整的最后一条命令，或者按照 aof-load-truncated 拒绝启动
+		if err := aof.PrepareLoad(cfg.AppendFilePath(), cfg.AofLoadTruncatedAllowed()); err != nil {
+			panic(err)
+		}
+		aofHandler, err := server.newPersister(cfg.AppendFilePath(), !cfg.AofLoadLazy, cfg.AppendFsync)
 		if err != nil {
 			panic(err)
 		}
 		server.bindPersister(aofHandler)
+		if cfg.AofLoadLazy {
+			// 在后台加载，加载期间写命令返回 LOADING
+			aofHandler.LoadInBackground()
+		}
 	}
-	if config.Properties.RDBFilename != "" && !validAof {
+	// 没有 aof 时加载 rdb，没有配置 dbfilename 时是 SAVE 和自动保存写入的 dump.rdb
+	if !validAof && fileExists(cfg.RDBFilePath()) {
+		// 加载失败时会以空数据启动，之后的 SAVE 会覆盖更新版本的 rdb
+		if err := aof.CheckFileFormat(cfg.RDBFilePath()); err != nil {
+			panic(err)
+		}
 		// load rdb
 		err := server.loadRdbFile()
 		if err != nil {
-			slog.Error("err",err)
+			slog.Error("err", err)
+		}
+	}
+	// 在加载数据之后打开，加载的命令不进入审计日志
+	if server.audit, err = openAuditLog(cfg); err != nil {
+		panic(err)
+	}
+	server.initAutoSave()
+	if cfg.ReplicaOf != "" {
+		server.startReplicaOf(cfg.ReplicaOf)
+	}
+	if cfg.Sentinel {
+		server.sentinel, err = newSentinelState(cfg.SentinelMonitor, cfg.SentinelPeers, cfg.MasterAuth)
+		if err != nil {
+			panic(err)
 		}
+		go server.sentinel.run()
 	}
 
 	return server
 }
 
 func (server *Server) selectDB(dbIndex int) (*DB, *protocol.StandardErrReply) {
-	if dbIndex > len(server.dbSet) || dbIndex < 0 {
+	if dbIndex >= len(server.dbSet) || dbIndex < 0 {
 		return nil, protocol.MakeErrReply("ERR invalid DB index")
 	}
 	return server.dbSet[dbIndex].Load().(*DB), nil
@@ -106,18 +395,23 @@ func (server *Server) mustSelectDB(dbIndex int) *DB {
 	return db
 }
 
-func (server *Server) GetEntity(dbIndex int, key string) (*database.DataEntity, bool) {
-	return server.mustSelectDB(dbIndex).GetEntity(key)
+// GetEntity returns the entity of the key, it locks the key itself so the invoker must not hold the lock of the key.
+// 与只读命令相同持有读锁，已经过期的 key 在释放锁之后删除并发布通知
+func (server *Server) GetEntity(dbIndex int, key string) (entity *database.DataEntity, exists bool) {
+	server.mustSelectDB(dbIndex).execRead(nil, []string{key}, func(view *DB) {
+		entity, exists = view.GetEntity(key)
+	})
+	return entity, exists
 }
 func (server *Server) GetExpiration(dbIndex int, key string) *time.Time {
-	raw, ok := server.mustSelectDB(dbIndex).ttlMap.Get(key)
+	expireTime, ok := server.mustSelectDB(dbIndex).ExpireAt(key)
 	if !ok {
 		return nil
 	}
-	expireTime, _ := raw.(time.Time)
 	return &expireTime
 }
 
+// GetUndoLogs generates undo commands of the command line, invoker should provide locks like ExecWithLock
 func (server *Server) GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine {
 	return server.mustSelectDB(dbIndex).GetUndoLogs(cmdLine)
 }
@@ -146,19 +440,40 @@ func (server *Server) loadDB(dbIndex int, newDB *DB) redis.Reply {
 	if dbIndex < 0 || dbIndex >= len(server.dbSet) {
 		return protocol.MakeErrReply("ERR invalid DB index")
 	}
-	newDB.index = dbIndex
+	newDB.setIndex(dbIndex)
 	oldDB := server.mustSelectDB(dbIndex)
-	newDB.addAof = oldDB.addAof
+	newDB.aofSaver = oldDB.aofSaver
+	newDB.cfg = oldDB.cfg
+	newDB.notifier = oldDB.notifier
+	newDB.publisher = oldDB.publisher
+	newDB.blocking = oldDB.blocking
+	newDB.stats = oldDB.stats
+	newDB.hotkeys = oldDB.hotkeys
+	newDB.activeExpireOff = oldDB.activeExpireOff
+	newDB.lazyfree = oldDB.lazyfree
+	newDB.insertCallback = oldDB.insertCallback
+	newDB.deleteCallback = oldDB.deleteCallback
 	server.dbSet[dbIndex].Store(newDB)
 	return protocol.MakeOkReply()
 }
 
+// makeDB 创建使用启动时推导的分片数的空数据库，配置了 shard-max-load 时分片数随 key 数增长
+func (server *Server) makeDB() *DB {
+	db := makeBasicDBWithShards(server.tuning.ShardCount)
+	db.data.SetMaxLoad(server.cfg.ShardMaxLoad)
+	db.versionMap.SetMaxLoad(server.cfg.ShardMaxLoad)
+	if server.expires != nil {
+		db.expires = server.expires
+	}
+	return db
+}
+
 // 清空当前选中的数据库中所有的键值对
 func (server *Server) FlushDB(dbIndex int) redis.Reply {
 	if dbIndex < 0 || dbIndex >= len(server.dbSet) {
 		return protocol.MakeErrReply("ERR invalid DB index")
 	}
-	newDB := makeBasicDB()
+	newDB := server.makeDB()
 	server.loadDB(dbIndex, newDB)
 	return protocol.MakeOkReply()
 }
@@ -168,70 +483,45 @@ func (server *Server) flushAll() redis.Reply {
 	for i := range server.dbSet {
 		server.FlushDB(i)
 	}
-	if server.persister != nil {
-		server.persister.SaveCmdLine(0, utils.ToCmdLine("FlushAll"))
-	}
+	server.saveAof(0, utils.ToCmdLine("FlushAll"))
 	return &protocol.OkReply{}
 }
 
-// AOF 持久化机制通常是通过追加写入操作日志到文件中，而不是像 RDB 那样生成全量快照。
-// 所以只用写saveRDB 而不用写saveAOF
-
-// 这个函数并不是用于 AOF 文件本身，而是用于生成 RDB 快照文件，只是这个模块的实现方式是基于 AOF 的“Rewrite”机制和“RDB Preamble”技术。
+// SaveRDB 遍历所有数据库生成 rdb 快照，保存期间暂停写命令，不需要开启 aof
 func (server *Server) SaveRDB() redis.Reply {
-	if server.persister == nil {
-		return protocol.MakeErrReply("ERR no AOF persistence")
+	if !server.rdb.saving.CompareAndSwap(false, true) {
+		return protocol.MakeErrReply(errSaveInProgress.Error())
 	}
-	rdbName := config.Properties.RDBFilename
-	if rdbName == "" {
-		rdbName = "dump.rdb"
-	}
-	err := server.persister.GenerateRDB(rdbName)
-	if err != nil {
+	defer server.rdb.saving.Store(false)
+	if err := server.saveRDB(); err != nil {
 		return protocol.MakeErrReply(err.Error())
 	}
 	return protocol.MakeOkReply()
 }
 
-// 这里的saveRDB是异步写入
+// BGSaveRDB 在后台逐个分片保存，不暂停写命令(rdb-deterministic 时只在复制数据期间暂停)，见 bgSave
 func (server *Server) BGSaveRDB() redis.Reply {
-	if server.persister == nil {
-		return protocol.MakeErrReply("ERR no AOF persistence")
+	if err := server.bgSave(); err != nil {
+		return protocol.MakeErrReply(err.Error())
 	}
-	go func() {
-		defer func() {
-			if err := recover(); err != nil {
-				slog.Error("Err", err)
-			}
-		}()
-		rdbFilename := config.Properties.RDBFilename
-		if rdbFilename == "" {
-			rdbFilename = "dump.rdb"
-		}
-		err := server.persister.GenerateRDB(rdbFilename)
-		if err != nil {
-			slog.Error("err", err)
-		}
-	}()
 	return protocol.MakeStatusReply("Background saving started")
 }
 
 // BGRewriteAOF asynchronously rewrites Append-Only-File
 func BGRewriteAOF(db *Server, args [][]byte) redis.Reply {
-	go db.persister.Rewrite()
+	go db.rewriteAof()
 	return protocol.MakeStatusReply("Background append only file rewriting started")
 }
 
 // （用更少命令重建 AOF）
 func RewriteAOF(db *Server, args [][]byte) redis.Reply {
-	err := db.persister.Rewrite()
+	err := db.rewriteAof()
 	if err != nil {
 		return protocol.MakeErrReply(err.Error())
 	}
 	return protocol.MakeOkReply()
 }
 
-
 func (server *Server) SetKeyInsertedCallback(cb database.KeyEventCallback) {
 	server.insertCallback = cb
 	for i := range server.dbSet {
@@ -249,11 +539,29 @@ func (server *Server) SetKeyDeletedCallback(cb database.KeyEventCallback) {
 	}
 }
 
-
+// Subscribe 在进程内订阅频道，嵌入使用时直接接收发布的消息和 keyspace 通知。
+// 消费者跟不上时发布者最多等待 pubhub.SubscriptionTimeout，之后丢弃消息。
+// ctx 结束或者实例关闭时退订并关闭返回的 channel
+func (server *Server) Subscribe(ctx context.Context, channels ...string) (<-chan pubhub.Message, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	ch, err := server.hub.Subscribe(ctx, channels...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go func() {
+		defer cancel()
+		select {
+		case <-ctx.Done():
+		case <-server.shutdown:
+		}
+	}()
+	return ch, nil
+}
 
 // ExecMulti executes multi commands transaction Atomically and Isolated
 func (server *Server) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLines []CmdLine) redis.Reply {
-	selectedDB, errReply := server.selectDB(conn.GetDBIndex())
+	selectedDB, errReply := server.selectDB(execDBIndex(conn))
 	if errReply != nil {
 		return errReply
 	}
@@ -271,9 +579,7 @@ func (server *Server) ExecWithLock(conn redis.Connection, cmdLine [][]byte) redi
 
 // 在执行 FlushDB（清空当前数据库）操作时，同时记录该操作到持久化日志（AOF）中
 func (server *Server) execFlushDB(dbIndex int) redis.Reply {
-	if server.persister != nil {
-		server.persister.SaveCmdLine(dbIndex, utils.ToCmdLine("FlushDB"))
-	}
+	server.saveAof(dbIndex, utils.ToCmdLine("FlushDB"))
 	return server.FlushDB(dbIndex)
 }
 
@@ -299,15 +605,127 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 			result = &protocol.UnknownErrReply{}
 		}
 	}()
+	server.stats.incr(statsMetricCommand, 1)
+	if c != nil && len(cmdLine) > 0 {
+		c.SetLastCmd(strings.ToLower(string(cmdLine[0])))
+	}
+	release, errReply := server.acquireInflight(c)
+	if errReply != nil {
+		return errReply
+	}
+	if release != nil {
+		defer release()
+	}
+	server.feedMonitors(c, cmdLine)
+	dbIndex := 0
+	if c != nil {
+		dbIndex = execDBIndex(c)
+	}
+	start := time.Now()
+	result = server.exec(c, cmdLine)
+	server.recordSlowlog(c, cmdLine, start)
+	server.recordCommandStats(cmdLine, start)
+	server.recordAudit(c, dbIndex, cmdLine, result, start)
+	// 服务器层的命令同样可能返回 RESP3 类型
+	if c == nil || c.GetProtocol() < protocol.RESP3 {
+		result = protocol.ToRESP2(result)
+	}
+	return result
+}
+
+func (server *Server) exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if cmdName == "waitsync" {
+		return server.execWaitSync(c, cmdLine)
+	}
+	if needsSyncAck(c, cmdName) {
+		return server.execSyncAck(c, cmdLine)
+	}
+	return server.execUnacked(c, cmdLine)
+}
 
+// execUnacked 执行命令，不等待 aof 落盘
+func (server *Server) execUnacked(c redis.Connection, cmdLine [][]byte) redis.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	// 事务中和主节点转发来的阻塞命令只尝试一次
+	if isBlockingCommand(cmdName) && c != nil && !c.InMultiState() && !c.IsMaster() {
+		return server.execBlocking(c, cmdLine)
+	}
+	return server.execOnce(c, cmdLine)
+}
+
+// lockWriteGate 写命令共享 writeGate，exclusive 时独占，返回解锁函数
+func (server *Server) lockWriteGate(exclusive bool) func() {
+	server.writeGateClass.Acquire(0)
+	if exclusive {
+		server.writeGate.Lock()
+		return func() {
+			server.writeGate.Unlock()
+			server.writeGateClass.Release(0)
+		}
+	}
+	server.writeGate.RLock()
+	return func() {
+		server.writeGate.RUnlock()
+		server.writeGateClass.Release(0)
+	}
+}
+
+// execOnce 执行一条命令，阻塞命令没有数据时直接返回 nil
+func (server *Server) execOnce(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	// 先计数再检查，关闭时看到计数为 0 之后不会再有命令开始执行
+	server.executing.Add(1)
+	defer server.executing.Add(-1)
+	if server.stopping.Load() {
+		return protocol.MakeErrReply("ERR server is shutting down")
+	}
+	if isWriteCommand(cmdName) {
+		// SWAPDB 交换时不能有正在执行的写命令
+		defer server.lockWriteGate(cmdName == "swapdb")()
+		if server.closing {
+			return protocol.MakeErrReply("ERR server is shutting down")
+		}
+	}
+	if c != nil && !c.IsAuthenticated() {
+		// 登录之前只能执行 AUTH/HELLO
+		if cmdName != "auth" && cmdName != "hello" {
+			return noAuthReply
+		}
+	} else if cmdName != "auth" {
+		// auth 不受 ACL 限制
+		if errReply := server.checkPermission(c, cmdName, cmdLine); errReply != nil {
+			return errReply
+		}
+	}
+	if errReply := server.checkDenied(cmdName); errReply != nil {
+		return errReply
+	}
+	if errReply := server.checkLoading(c, cmdName); errReply != nil {
+		return errReply
+	}
+	if isWriteCommand(cmdName) && server.isReplica() && (c == nil || !c.IsMaster()) {
+		return protocol.MakeErrReply("READONLY You can't write against a read only replica.")
+	}
+	if isWriteCommand(cmdName) && (c == nil || !c.IsMaster()) {
+		if errReply := server.checkAofWrite(); errReply != nil {
+			return errReply
+		}
+	}
+	// 服务器命令在这里统一检查参数个数，其它命令在 execNormalCommand 中检查
+	if cmd, ok := serverCmdTable[cmdName]; ok && !validateArity(cmd.arity, cmdLine) {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
 	// ping
 	if cmdName == "ping" {
 		return Ping(c, cmdLine[1:])
 	}
 	// authenticate
 	if cmdName == "auth" {
-		return Auth(c, cmdLine[1:])
+		return Auth(server, c, cmdLine[1:])
+	}
+	if cmdName == "hello" {
+		return server.execHello(c, cmdLine[1:])
 	}
 
 	// info
@@ -317,6 +735,9 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 	if cmdName == "dbsize" {
 		return DbSize(c, server)
 	}
+	if cmdName == "command" {
+		return execCommand(cmdLine[1:])
+	}
 
 	// special commands which cannot execute within transaction
 	if cmdName == "subscribe" {
@@ -324,38 +745,86 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 			return protocol.MakeArgNumErrReply("subscribe")
 		}
 		return pubhub.Subscribe(server.hub, c, cmdLine[1:])
-	} else if cmdName == "publish" {
-		return pubhub.Publish(server.hub, cmdLine[1:])
+	} else if cmdName == "publish" && (c == nil || !c.InMultiState()) {
+		// 事务中的 PUBLISH 交给 DB 排队
+		return server.publish(cmdLine[1:])
 	} else if cmdName == "unsubscribe" {
 		return pubhub.UnSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "psubscribe" {
+		if len(cmdLine) < 2 {
+			return protocol.MakeArgNumErrReply("psubscribe")
+		}
+		return pubhub.PSubscribe(server.hub, c, cmdLine[1:])
+	} else if cmdName == "punsubscribe" {
+		return pubhub.PUnSubscribe(server.hub, c, cmdLine[1:])
 	} else if cmdName == "bgrewriteaof" {
-		if !config.Properties.AppendOnly {
-			return protocol.MakeErrReply("AppendOnly is false, you can't rewrite aof file")
+		if !server.cfg.AppendOnly {
+			return protocol.MakeErrReply("ERR AppendOnly is false, you can't rewrite aof file")
 		}
 		// aof.go imports router.go, router.go cannot import BGRewriteAOF from aof.go
 		return BGRewriteAOF(server, cmdLine[1:])
 	} else if cmdName == "rewriteaof" {
-		if !config.Properties.AppendOnly {
-			return protocol.MakeErrReply("AppendOnly is false, you can't rewrite aof file")
+		if !server.cfg.AppendOnly {
+			return protocol.MakeErrReply("ERR AppendOnly is false, you can't rewrite aof file")
 		}
 		return RewriteAOF(server, cmdLine[1:])
 	} else if cmdName == "flushall" {
+		if errReply := server.checkFlushArgs(cmdName, cmdLine[1:]); errReply != nil {
+			return errReply
+		}
 		return server.flushAll()
 	} else if cmdName == "flushdb" {
-		if !validateArity(1, cmdLine) {
-			return protocol.MakeArgNumErrReply(cmdName)
+		if errReply := server.checkFlushArgs(cmdName, cmdLine[1:]); errReply != nil {
+			return errReply
 		}
 		if c.InMultiState() {
-			return protocol.MakeErrReply("ERR command 'FlushDB' cannot be used in MULTI")
+			return rejectInMulti(c, "FlushDB")
 		}
 		return server.execFlushDB(c.GetDBIndex())
+	} else if cmdName == "swapdb" {
+		return server.execSwapDB(c, cmdLine[1:])
 	} else if cmdName == "save" {
 		return server.SaveRDB()
 	} else if cmdName == "bgsave" {
 		return server.BGSaveRDB()
+	} else if cmdName == "lastsave" {
+		return server.execLastSave()
+	} else if cmdName == "psync" || cmdName == "sync" {
+		if c.InMultiState() {
+			return rejectInMulti(c, cmdName)
+		}
+		return server.execPSync(c, cmdLine[1:])
+	} else if cmdName == "slaveof" || cmdName == "replicaof" {
+		return server.execSlaveOf(c, cmdLine[1:])
+	} else if cmdName == "sentinel" {
+		return server.execSentinel(cmdLine[1:])
+	} else if cmdName == "replconf" {
+		return server.execReplConf(c, cmdLine[1:])
+	} else if cmdName == "asking" {
+		return execAsking(c, cmdLine[1:])
+	} else if cmdName == "cluster" {
+		return server.execCluster(c, cmdLine[1:])
+	} else if cmdName == "debug" {
+		return server.execDebug(c, cmdLine[1:])
+	} else if cmdName == "config" {
+		return server.execConfig(cmdLine[1:])
+	} else if cmdName == "monitor" {
+		return server.execMonitor(c)
+	} else if cmdName == "shutdown" {
+		return server.execShutdown(c, cmdLine[1:])
+	} else if cmdName == "acl" {
+		return server.execAcl(c, cmdLine[1:])
+	} else if cmdName == "slowlog" {
+		return server.execSlowlog(cmdLine[1:])
+	} else if cmdName == "hotkeys" {
+		return server.execHotkeys(cmdLine[1:])
+	} else if cmdName == "client" {
+		return server.execClient(c, cmdLine[1:])
+	} else if cmdName == "expirepattern" || cmdName == "persistpattern" {
+		return server.execPatternTTL(c, cmdName, cmdLine[1:])
 	} else if cmdName == "select" {
 		if c != nil && c.InMultiState() {
-			return protocol.MakeErrReply("cannot select database within multi")
+			return rejectInMulti(c, "select")
 		}
 		if len(cmdLine) != 2 {
 			return protocol.MakeArgNumErrReply("select")
@@ -363,11 +832,30 @@ func (server *Server) Exec(c redis.Connection, cmdLine [][]byte) (result redis.R
 		return execSelect(c, server, cmdLine[1:])
 	}
 
+	// 配置了 peers 时由 cluster 包按一致性哈希路由，不使用槽位重定向。
+	// 分区 aof 按槽位记录命令，跨槽位的命令只会写入一个分区，同样由这里拒绝
+	if (server.cfg.ClusterEnable && len(server.cfg.Peers) == 0 || server.partitions != nil) && server.slots != nil {
+		if reply := server.redirect(c, cmdLine); reply != nil {
+			return reply
+		}
+	}
+
 	// normal commands
-	dbIndex := c.GetDBIndex()
+	dbIndex := execDBIndex(c)
 	selectedDB, errReply := server.selectDB(dbIndex)
 	if errReply != nil {
 		return errReply
 	}
+	server.workers.acquire()
+	defer server.workers.release()
+	if cmdName == "copy" && !c.InMultiState() {
+		// 复制到其它 db 需要同时持有两个 db 的锁
+		if reply := server.execCopyToDB(c, cmdLine); reply != nil {
+			return reply
+		}
+	}
+	if isWriteCommand(cmdName) {
+		return server.execFsyncAlways(c, selectedDB, cmdLine)
+	}
 	return selectedDB.Exec(c, cmdLine)
 }
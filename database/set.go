@@ -1,6 +1,8 @@
 package database
 
 import (
+	"math"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -30,7 +32,7 @@ func (db *DB) getOrInitSet(key string) (set *HashSet.Set, inited bool, errReply
 	}
 	inited = false
 	if set == nil {
-		set = HashSet.Make()
+		set = db.makeSet()
 		db.PutEntity(key, &database.DataEntity{
 			Data: set,
 		})
@@ -78,6 +80,24 @@ func execSIsMember(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeIntReply(0)
 }
 
+// execSMIsMember checks each member, returns an array of 0/1
+func execSMIsMember(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		if set != nil && set.Has(string(member)) {
+			result[i] = protocol.MakeIntReply(1)
+		} else {
+			result[i] = protocol.MakeIntReply(0)
+		}
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
 // execSRem removes a member from set
 func execSRem(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
@@ -94,12 +114,10 @@ func execSRem(db *DB, args [][]byte) redis.Reply {
 	for _, member := range members {
 		counter += set.Remove(string(member))
 	}
-	if set.Len() == 0 {
-		db.Remove(key)
-	}
 	if counter > 0 {
 		db.addAof(utils.ToCmdLine3("srem", args...))
 	}
+	db.deleteIfEmpty(key, set)
 	return protocol.MakeIntReply(int64(counter))
 }
 
@@ -138,7 +156,58 @@ func execSPop(db *DB, args [][]byte) redis.Reply {
 	}
 
 	if count > 0 {
-		db.addAof(utils.ToCmdLine3("spop", args...))
+		// 弹出的成员是随机的，aof 中记录实际删除的成员，保证重放结果一致
+		db.addAof(utils.ToCmdLine3("srem", append([][]byte{args[0]}, result...)...))
+	}
+	db.deleteIfEmpty(key, set)
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// execSRandMember returns random members without removing them,
+// positive count returns distinct members, negative count may return the same member multiple times
+func execSRandMember(db *DB, args [][]byte) redis.Reply {
+	if len(args) != 1 && len(args) != 2 {
+		return protocol.MakeArgNumErrReply("srandmember")
+	}
+	key := string(args[0])
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if set == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		members := set.RandomDistinctMembers(1)
+		return protocol.MakeBulkReply([]byte(members[0]))
+	}
+	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if set == nil || count64 == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	var members []string
+	if count64 > 0 {
+		count := count64
+		if count > int64(set.Len()) {
+			count = int64(set.Len())
+		}
+		members = set.RandomDistinctMembers(int(count))
+	} else {
+		// 可以重复，返回的数量总是 -count
+		if count64 < -math.MaxInt32 {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
+		if errReply := db.checkReplySize(int(-count64), "SSCAN"); errReply != nil {
+			return errReply
+		}
+		members = set.RandomMembers(int(-count64))
+	}
+	result := make([][]byte, len(members))
+	for i, member := range members {
+		result[i] = []byte(member)
 	}
 	return protocol.MakeMultiBulkReply(result)
 }
@@ -170,6 +239,9 @@ func execSMembers(db *DB, args [][]byte) redis.Reply {
 	if set == nil {
 		return &protocol.EmptyMultiBulkReply{}
 	}
+	if errReply := db.checkReplySize(set.Len(), "SSCAN"); errReply != nil {
+		return errReply
+	}
 
 	arr := make([][]byte, set.Len())
 	i := 0
@@ -210,6 +282,72 @@ func execSInter(db *DB, args [][]byte) redis.Reply {
 	return set2reply(result)
 }
 
+// parseSInterCard 解析 SINTERCARD numkeys key [key ...] [LIMIT limit]
+func parseSInterCard(args [][]byte) (keys []string, limit int, errReply redis.Reply) {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return nil, 0, protocol.MakeErrReply("ERR numkeys should be greater than 0")
+	} else if numKeys > len(args)-1 {
+		return nil, 0, protocol.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+	keys = make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	for i := numKeys + 1; i < len(args); i += 2 {
+		if !strings.EqualFold(string(args[i]), "limit") || i+1 >= len(args) {
+			return nil, 0, protocol.MakeSyntaxErrReply()
+		}
+		limit, err = strconv.Atoi(string(args[i+1]))
+		if err != nil || limit < 0 {
+			return nil, 0, protocol.MakeErrReply("ERR LIMIT can't be negative")
+		}
+	}
+	return keys, limit, nil
+}
+
+func prepareSInterCard(args [][]byte) ([]string, []string) {
+	keys, _, errReply := parseSInterCard(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, keys
+}
+
+// execSInterCard returns the size of intersection, stops counting at LIMIT if it's positive
+func execSInterCard(db *DB, args [][]byte) redis.Reply {
+	keys, limit, errReply := parseSInterCard(args)
+	if errReply != nil {
+		return errReply
+	}
+	sets := make([]*HashSet.Set, 0, len(keys))
+	for _, key := range keys {
+		set, errReply := db.getAsSet(key)
+		if errReply != nil {
+			return errReply
+		}
+		if set == nil {
+			return protocol.MakeIntReply(0)
+		}
+		sets = append(sets, set)
+	}
+	// 遍历最小的集合，其它集合只做查找
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Len() < sets[j].Len()
+	})
+	count := 0
+	sets[0].ForEach(func(member string) bool {
+		for _, other := range sets[1:] {
+			if !other.Has(member) {
+				return true
+			}
+		}
+		count++
+		return limit == 0 || count < limit
+	})
+	return protocol.MakeIntReply(int64(count))
+}
+
 // execSInterStore intersects multiple sets and store the result in a key
 func execSInterStore(db *DB, args [][]byte) redis.Reply {
 	dest := string(args[0])
@@ -220,18 +358,10 @@ func execSInterStore(db *DB, args [][]byte) redis.Reply {
 		if errReply != nil {
 			return errReply
 		}
-		if set.Len() == 0 {
-			return protocol.MakeIntReply(0)
-		}
 		sets = append(sets, set)
 	}
 	result := HashSet.Intersect(sets...)
-
-	db.PutEntity(dest, &database.DataEntity{
-		Data: result,
-	})
-	db.addAof(utils.ToCmdLine3("sinterstore", args...))
-	return protocol.MakeIntReply(int64(result.Len()))
+	return db.storeSetResult(dest, result, "sinterstore", args)
 }
 
 // execSUnion adds multiple sets
@@ -262,16 +392,7 @@ func execSUnionStore(db *DB, args [][]byte) redis.Reply {
 		sets = append(sets, set)
 	}
 	result := HashSet.Union(sets...)
-	db.Remove(dest) // clean ttl
-	if result.Len() == 0 {
-		return protocol.MakeIntReply(0)
-	}
-
-	db.PutEntity(dest, &database.DataEntity{
-		Data: result,
-	})
-	db.addAof(utils.ToCmdLine3("sunionstore", args...))
-	return protocol.MakeIntReply(int64(result.Len()))
+	return db.storeSetResult(dest, result, "sunionstore", args)
 }
 
 // execSDiff subtracts multiple sets
@@ -302,18 +423,25 @@ func execSDiffStore(db *DB, args [][]byte) redis.Reply {
 		sets = append(sets, set)
 	}
 	result := HashSet.Diff(sets...)
-	db.Remove(dest) // clean ttl
+	return db.storeSetResult(dest, result, "sdiffstore", args)
+}
+
+// storeSetResult 把 SINTERSTORE 等命令的结果写入 dest，结果为空时删除 dest
+func (db *DB) storeSetResult(dest string, result *HashSet.Set, cmdName string, args [][]byte) redis.Reply {
 	if result.Len() == 0 {
-		return protocol.MakeIntReply(0)
+		if db.Removes(dest) > 0 {
+			db.notify(notifyGeneric, "del", dest)
+		}
+	} else {
+		db.Remove(dest) // clean ttl
+		db.PutEntity(dest, &database.DataEntity{
+			Data: result,
+		})
 	}
-	db.PutEntity(dest, &database.DataEntity{
-		Data: result,
-	})
-	db.addAof(utils.ToCmdLine3("sdiffstore", args...))
+	db.addAof(utils.ToCmdLine3(cmdName, args...))
 	return protocol.MakeIntReply(int64(result.Len()))
 }
 
-
 func execSScan(db *DB, args [][]byte) redis.Reply {
 	var count int = 10
 	var pattern string = "*"
@@ -351,7 +479,7 @@ func execSScan(db *DB, args [][]byte) redis.Reply {
 
 	keysReply, nextCursor := set.SetScan(cursor, count, pattern)
 	if nextCursor < 0 {
-		return protocol.MakeErrReply("Invalid argument")
+		return protocol.MakeErrReply("ERR invalid cursor")
 	}
 
 	result := make([]redis.Reply, 2)
@@ -366,16 +494,22 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 	registerCommand("SIsMember", execSIsMember, readFirstKey, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("SMIsMember", execSMIsMember, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("SRem", execSRem, writeFirstKey, undoSetChange, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("SPop", execSPop, writeFirstKey, undoSetChange, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagRandom, redisFlagFast}, 1, 1, 1)
+	registerCommand("SRandMember", execSRandMember, readFirstKey, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
 	registerCommand("SCard", execSCard, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("SMembers", execSMembers, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, 1, 1)
 	registerCommand("SInter", execSInter, prepareSetCalculate, nil, -2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagSortForScript}, 1, -1, 1)
+	registerCommand("SInterCard", execSInterCard, prepareSInterCard, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
 	registerCommand("SInterStore", execSInterStore, prepareSetCalculateStore, rollbackFirstKey, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, -1, 1)
 	registerCommand("SUnion", execSUnion, prepareSetCalculate, nil, -2, flagReadOnly).
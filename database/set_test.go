@@ -0,0 +1,62 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestSetReadCommands(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SADD", "s1", "a", "b", "c", "d")
+	execTestCmd(db, "SADD", "s2", "b", "c", "d", "e")
+	execTestCmd(db, "SADD", "s3", "c", "d")
+	execTestCmd(db, "SET", "str", "v")
+
+	assertReply(t, execTestCmd(db, "SMISMEMBER", "s1", "a", "e", "b"), "*3\r\n:1\r\n:0\r\n:1\r\n")
+	assertReply(t, execTestCmd(db, "SMISMEMBER", "missing", "a"), "*1\r\n:0\r\n")
+	assertReply(t, execTestCmd(db, "SMISMEMBER", "str", "a"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	assertReply(t, execTestCmd(db, "SINTERCARD", "3", "s1", "s2", "s3"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "2", "s1", "s2"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "2", "s1", "s2", "LIMIT", "2"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "2", "s1", "s2", "limit", "0"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "2", "s1", "missing"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "0", "s1"), "-ERR numkeys should be greater than 0\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "3", "s1", "s2"),
+		"-ERR Number of keys can't be greater than number of args\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "1", "s1", "LIMIT", "-1"), "-ERR LIMIT can't be negative\r\n")
+	assertReply(t, execTestCmd(db, "SINTERCARD", "1", "s1", "COUNT", "1"), "-ERR syntax error\r\n")
+
+	assertReply(t, execTestCmd(db, "SRANDMEMBER", "missing"), "$-1\r\n")
+	assertReply(t, execTestCmd(db, "SRANDMEMBER", "missing", "-3"), "*0\r\n")
+	assertReply(t, execTestCmd(db, "SRANDMEMBER", "s3", "0"), "*0\r\n")
+	assertReply(t, execTestCmd(db, "SRANDMEMBER", "s3", "x"), "-ERR value is not an integer or out of range\r\n")
+	if reply, ok := execTestCmd(db, "SRANDMEMBER", "s3").(*protocol.BulkReply); !ok ||
+		(string(reply.Arg) != "c" && string(reply.Arg) != "d") {
+		t.Errorf("unexpected SRANDMEMBER reply %v", reply)
+	}
+
+	// 正数返回不重复的成员，最多返回整个集合
+	reply := execTestCmd(db, "SRANDMEMBER", "s1", "10").(*protocol.MultiBulkReply)
+	seen := make(map[string]bool)
+	for _, member := range reply.Args {
+		seen[string(member)] = true
+	}
+	if len(reply.Args) != 4 || len(seen) != 4 {
+		t.Errorf("expected 4 distinct members, got %q", reply.Args)
+	}
+	// 负数返回 -count 个成员，可以重复
+	reply = execTestCmd(db, "SRANDMEMBER", "s3", "-10").(*protocol.MultiBulkReply)
+	if len(reply.Args) != 10 {
+		t.Errorf("expected 10 members, got %d", len(reply.Args))
+	}
+	for _, member := range reply.Args {
+		if string(member) != "c" && string(member) != "d" {
+			t.Errorf("unexpected member %q", member)
+		}
+	}
+	// 只读命令不修改集合
+	assertReply(t, execTestCmd(db, "SCARD", "s1"), ":4\r\n")
+}
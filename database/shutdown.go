@@ -0,0 +1,133 @@
+package database
+
+import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 关闭流程
+// SHUTDOWN 命令和收到 SIGTERM 等信号时(handler 调用 Close)按以下顺序关闭:
+//  1. 拒绝新的命令，返回 ERR server is shutting down
+//  2. 等待正在执行的命令结束，最多等待 shutdown-timeout 秒，等待中的阻塞命令不计入
+//  3. SHUTDOWN SAVE 或者配置了 save 规则时生成 rdb 快照，NOSAVE 时不保存；有后台保存时先等待它结束
+//  4. 结束阻塞命令，停止复制，把 aof 缓冲中的命令全部写入并 fsync 后关闭文件
+//
+// SHUTDOWN 保存失败时恢复执行命令并返回错误，与 redis 相同不退出；成功时不回复，tcp 服务器停止监听并关闭所有连接
+
+const (
+	defaultShutdownTimeout = 10 * time.Second
+	shutdownPollInterval   = 10 * time.Millisecond
+)
+
+type shutdownSave int
+
+const (
+	// 配置了 save 规则时保存
+	shutdownSaveDefault shutdownSave = iota
+	shutdownNoSave
+	shutdownForceSave
+)
+
+func (server *Server) shutdownTimeout() time.Duration {
+	if server.cfg.ShutdownTimeout > 0 {
+		return time.Duration(server.cfg.ShutdownTimeout) * time.Second
+	}
+	return defaultShutdownTimeout
+}
+
+// ShutdownRequested is closed after SHUTDOWN succeeds, the tcp server stops listening and exits
+func (server *Server) ShutdownRequested() <-chan struct{} {
+	return server.shutdownRequested
+}
+
+// shutdownServer 按照上面的顺序关闭，self 是调用方自己占用的执行中的命令数
+func (server *Server) shutdownServer(save shutdownSave, self int64) error {
+	server.shutdownMu.Lock()
+	defer server.shutdownMu.Unlock()
+	if server.closed {
+		return nil
+	}
+	server.stopping.Store(true)
+	deadline := time.Now().Add(server.shutdownTimeout())
+	server.waitExecuting(self, deadline)
+	if server.shouldSaveOnShutdown(save) {
+		if err := server.saveOnShutdown(deadline); err != nil {
+			// 数据仍然在内存中，继续提供服务
+			server.stopping.Store(false)
+			return err
+		}
+	}
+	server.closed = true
+	server.closeResources()
+	if server.shutdownRequested != nil {
+		close(server.shutdownRequested)
+	}
+	return nil
+}
+
+// waitExecuting 等待其它执行中的命令结束，超时后不再等待
+func (server *Server) waitExecuting(self int64, deadline time.Time) {
+	for server.executing.Load() > self {
+		if time.Now().After(deadline) {
+			slog.Warn("shutdown timeout, commands still executing", "count", server.executing.Load()-self)
+			return
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+}
+
+func (server *Server) shouldSaveOnShutdown(save shutdownSave) bool {
+	switch save {
+	case shutdownNoSave:
+		return false
+	case shutdownForceSave:
+		return true
+	}
+	rules, _ := server.rdb.rules.Load().([]config.SaveRule)
+	return len(rules) > 0
+}
+
+// saveOnShutdown 等待正在进行的保存结束后再保存一次，之前的保存不包含最后的修改
+func (server *Server) saveOnShutdown(deadline time.Time) error {
+	for !server.rdb.saving.CompareAndSwap(false, true) {
+		if time.Now().After(deadline) {
+			return errSaveInProgress
+		}
+		time.Sleep(shutdownPollInterval)
+	}
+	defer server.rdb.saving.Store(false)
+	slog.Info("saving the final rdb snapshot before shutdown")
+	return server.saveRDB()
+}
+
+// execShutdown SHUTDOWN [NOSAVE|SAVE]
+func (server *Server) execShutdown(c redis.Connection, args [][]byte) redis.Reply {
+	if c != nil && c.InMultiState() {
+		return rejectInMulti(c, "shutdown")
+	}
+	save := shutdownSaveDefault
+	if len(args) > 1 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	if len(args) == 1 {
+		switch strings.ToLower(string(args[0])) {
+		case "nosave":
+			save = shutdownNoSave
+		case "save":
+			save = shutdownForceSave
+		default:
+			return protocol.MakeSyntaxErrReply()
+		}
+	}
+	if err := server.shutdownServer(save, 1); err != nil {
+		slog.Error("SHUTDOWN failed", "error", err)
+		return protocol.MakeErrReply("ERR Errors trying to SHUTDOWN. Check logs.")
+	}
+	slog.Info("server is now ready to exit")
+	return &protocol.NoReply{}
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestShutdown(t *testing.T) {
+	cfg := &config.ServerProperties{Dir: t.TempDir(), Databases: 16}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SHUTDOWN", "NOW")), "-ERR syntax error\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SHUTDOWN", "SAVE", "NOSAVE")), "-ERR syntax error\r\n")
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SHUTDOWN")), "-ERR command 'shutdown' cannot be used in MULTI\r\n")
+	server.Exec(conn, utils.ToCmdLine("DISCARD"))
+
+	// 没有 save 规则时 SHUTDOWN SAVE 同样保存，成功时不回复
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SHUTDOWN", "SAVE")), "")
+	select {
+	case <-server.ShutdownRequested():
+	default:
+		t.Fatal("shutdown should be requested")
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "-ERR server is shutting down\r\n")
+	// handler 之后调用 Close 不会重复关闭
+	server.Close()
+
+	server = NewStandaloneServerWithConfig(cfg)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "2"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SHUTDOWN", "NOSAVE")), "")
+	server = NewStandaloneServerWithConfig(cfg)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+	server.Close()
+}
+
+func TestShutdownSaveFailure(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:         t.TempDir(),
+		Databases:   16,
+		Save:        "3600 1",
+		RDBFilename: filepath.Join("missing", "dump.rdb"),
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	// 保存失败时不退出，继续执行命令
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SHUTDOWN")), "-ERR Errors trying to SHUTDOWN. Check logs.\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "a")), "$1\r\n1\r\n")
+	select {
+	case <-server.ShutdownRequested():
+		t.Fatal("shutdown should not be requested after failed save")
+	default:
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SHUTDOWN", "NOSAVE")), "")
+}
@@ -0,0 +1,276 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 在线迁移槽位时的状态机
+// MIGRATING: 槽位仍属于本节点，但部分 key 已经迁走，本地不存在的 key 返回 -ASK 让客户端去目标节点
+// IMPORTING: 槽位仍属于源节点，只有带 ASKING 标记的命令才能在本节点执行，否则返回 -MOVED
+// NODE:      迁移完成，槽位归属于指定节点
+
+const slotCount = 16384
+
+var crc16Table [256]uint16
+
+func init() {
+	// CRC16-CCITT (XMODEM)，与 redis cluster 保持一致
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// getSlot 计算 key 所属的槽位，支持 {hashtag}
+func getSlot(key string) uint32 {
+	if begin := strings.IndexByte(key, '{'); begin >= 0 {
+		if end := strings.IndexByte(key[begin+1:], '}'); end > 0 {
+			key = key[begin+1 : begin+1+end]
+		}
+	}
+	return uint32(crc16(key)) % slotCount
+}
+
+// slotTable 记录处于迁移状态或归属其他节点的槽位，未记录的槽位默认属于本节点
+type slotTable struct {
+	mu        sync.RWMutex
+	migrating map[uint32]string // slot -> 目标节点地址
+	importing map[uint32]string // slot -> 源节点地址
+	owners    map[uint32]string // slot -> 其他节点地址
+}
+
+func makeSlotTable() *slotTable {
+	return &slotTable{
+		migrating: make(map[uint32]string),
+		importing: make(map[uint32]string),
+		owners:    make(map[uint32]string),
+	}
+}
+
+func (t *slotTable) setMigrating(slot uint32, node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.importing, slot)
+	t.migrating[slot] = node
+}
+
+func (t *slotTable) setImporting(slot uint32, node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.migrating, slot)
+	t.importing[slot] = node
+}
+
+func (t *slotTable) setStable(slot uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+}
+
+// setNode 迁移结束，把槽位交给 node，self 为本节点地址
+func (t *slotTable) setNode(slot uint32, node string, self string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+	if node == self {
+		delete(t.owners, slot)
+		return
+	}
+	t.owners[slot] = node
+}
+
+// redirect 检查命令涉及的 key 是否需要重定向，返回 nil 表示可以在本节点执行
+func (server *Server) redirect(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, cmdLine) {
+		return nil
+	}
+	asking := c.IsAsking()
+	c.SetAsking(false)
+	write, read := cmd.prepare(cmdLine[1:])
+	keys := append(write, read...)
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := getSlot(keys[0])
+	for _, key := range keys[1:] {
+		if getSlot(key) != slot {
+			return protocol.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	t := server.slots
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if node, ok := t.owners[slot]; ok {
+		return makeMovedReply(slot, node)
+	}
+	if node, ok := t.importing[slot]; ok {
+		if asking {
+			return nil
+		}
+		return makeMovedReply(slot, node)
+	}
+	if node, ok := t.migrating[slot]; ok {
+		db, errReply := server.selectDB(c.GetDBIndex())
+		if errReply != nil {
+			return errReply
+		}
+		// 迁移和过期可能同时在删除 key，持有读锁检查，不在这里删除过期的 key
+		db.RWLocks(nil, keys)
+		defer db.RWUnLocks(nil, keys)
+		missing := 0
+		for _, key := range keys {
+			if !db.hasKey(key) {
+				missing++
+			}
+		}
+		if missing == len(keys) {
+			// key 已经迁走(或者是新 key)，让客户端去目标节点
+			return protocol.MakeErrReply("ASK " + strconv.Itoa(int(slot)) + " " + node)
+		}
+		if missing > 0 {
+			// 一部分 key 已经迁走，两个节点都不能执行，与 redis 相同让客户端稍后重试
+			return protocol.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+		}
+	}
+	return nil
+}
+
+func makeMovedReply(slot uint32, node string) redis.Reply {
+	return protocol.MakeErrReply("MOVED " + strconv.Itoa(int(slot)) + " " + node)
+}
+
+func execAsking(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 0 {
+		return protocol.MakeArgNumErrReply("asking")
+	}
+	c.SetAsking(true)
+	return protocol.MakeOkReply()
+}
+
+// execCluster 支持迁移需要的子命令：KEYSLOT, SETSLOT, COUNTKEYSINSLOT, GETKEYSINSLOT
+func (server *Server) execCluster(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "keyslot":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return protocol.MakeIntReply(int64(getSlot(string(args[1]))))
+	case "setslot":
+		return server.execSetSlot(args[1:])
+	case "countkeysinslot":
+		if len(args) != 2 {
+			return protocol.MakeArgNumErrReply("cluster|countkeysinslot")
+		}
+		slot, errReply := parseSlot(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		keys := server.keysInSlot(c.GetDBIndex(), slot, -1)
+		return protocol.MakeIntReply(int64(len(keys)))
+	case "getkeysinslot":
+		if len(args) != 3 {
+			return protocol.MakeArgNumErrReply("cluster|getkeysinslot")
+		}
+		slot, errReply := parseSlot(args[1])
+		if errReply != nil {
+			return errReply
+		}
+		count, err := strconv.Atoi(string(args[2]))
+		if err != nil || count < 0 {
+			return protocol.MakeErrReply("ERR Invalid number of keys")
+		}
+		keys := server.keysInSlot(c.GetDBIndex(), slot, count)
+		result := make([][]byte, len(keys))
+		for i, key := range keys {
+			result[i] = []byte(key)
+		}
+		return protocol.MakeMultiBulkReply(result)
+	}
+	return protocol.MakeErrReply("ERR Unknown subcommand or wrong number of arguments for '" + subCmd + "'")
+}
+
+// CLUSTER SETSLOT <slot> IMPORTING <node> | MIGRATING <node> | STABLE | NODE <node>
+func (server *Server) execSetSlot(args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply("cluster|setslot")
+	}
+	slot, errReply := parseSlot(args[0])
+	if errReply != nil {
+		return errReply
+	}
+	action := strings.ToLower(string(args[1]))
+	if action == "stable" {
+		if len(args) != 2 {
+			return protocol.MakeSyntaxErrReply()
+		}
+		server.slots.setStable(slot)
+		return protocol.MakeOkReply()
+	}
+	if len(args) != 3 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	node := string(args[2])
+	switch action {
+	case "importing":
+		server.slots.setImporting(slot, node)
+	case "migrating":
+		server.slots.setMigrating(slot, node)
+	case "node":
+		server.slots.setNode(slot, node, server.cfg.AnnounceAddress())
+	default:
+		return protocol.MakeErrReply("ERR Invalid CLUSTER SETSLOT action or number of arguments")
+	}
+	return protocol.MakeOkReply()
+}
+
+func parseSlot(arg []byte) (uint32, redis.Reply) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= slotCount {
+		return 0, protocol.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	return uint32(slot), nil
+}
+
+// keysInSlot 返回槽位内最多 count 个 key，count < 0 表示不限制
+func (server *Server) keysInSlot(dbIndex int, slot uint32, count int) []string {
+	keys := make([]string, 0)
+	if count == 0 {
+		return keys
+	}
+	db := server.mustSelectDB(dbIndex)
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if getSlot(key) == slot {
+			keys = append(keys, key)
+		}
+		return count < 0 || len(keys) < count
+	})
+	return keys
+}
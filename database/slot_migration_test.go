@@ -0,0 +1,63 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestSlotMigration(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Databases:     16,
+		ClusterEnable: true,
+		Bind:          "127.0.0.1",
+		Port:          6399,
+	})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(server.Exec(conn, utils.ToCmdLine(args...)).ToBytes())
+	}
+	slot := strconv.Itoa(int(getSlot("s")))
+	target := "127.0.0.1:7001"
+	ask := "-ASK " + slot + " " + target + "\r\n"
+	moved := "-MOVED " + slot + " " + target + "\r\n"
+
+	if reply := exec("CLUSTER", "KEYSLOT", "{s}a"); reply != ":"+slot+"\r\n" {
+		t.Fatalf("unexpected slot %q", reply)
+	}
+	if reply := exec("MSET", "a", "1", "b", "2"); reply != "-CROSSSLOT Keys in request don't hash to the same slot\r\n" {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	exec("SET", "{s}a", "1")
+
+	// MIGRATING: 本地存在的 key 照常执行，不存在的返回 ASK，部分存在时返回 TRYAGAIN
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "MIGRATING", target)), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}a")), "$1\r\n1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}b")), ask)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "{s}b", "2")), ask)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MGET", "{s}a", "{s}b")),
+		"-TRYAGAIN Multiple keys request during rehashing of slot\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "STABLE")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}b")), "$-1\r\n")
+
+	// IMPORTING: 只有 ASKING 之后的一条命令可以在本节点执行
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "IMPORTING", target)), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}a")), moved)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("ASKING")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}a")), "$1\r\n1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}a")), moved)
+
+	// NODE: 槽位交给其他节点之后返回 MOVED，交回本节点之后恢复
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "NODE", target)), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}a")), moved)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("ASKING")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}a")), moved)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "NODE", "127.0.0.1:6399")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "{s}a")), "$1\r\n1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "UNKNOWN", target)),
+		"-ERR Invalid CLUSTER SETSLOT action or number of arguments\r\n")
+}
@@ -0,0 +1,172 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 慢日志
+// 执行时间超过 slowlog-log-slower-than 微秒的命令记入慢日志，最多保留 slowlog-max-len 条，
+// 阻塞命令的耗时主要是等待数据，不记录。参数过多或者过长时与 redis 相同截断
+
+const (
+	defaultSlowlogMaxLen = 128
+	// 每条记录最多保存的参数个数和每个参数的字节数，与 redis 相同
+	slowlogMaxArgc   = 32
+	slowlogMaxArgLen = 128
+)
+
+type slowlogEntry struct {
+	id       int64
+	time     int64 // unix 秒
+	duration int64 // 微秒
+	args     [][]byte
+	addr     string
+}
+
+type slowLog struct {
+	mu      sync.Mutex
+	nextID  int64
+	maxLen  int
+	entries []*slowlogEntry // 按时间顺序，最新的在最后
+}
+
+func makeSlowLog(maxLen int) *slowLog {
+	if maxLen <= 0 {
+		maxLen = defaultSlowlogMaxLen
+	}
+	return &slowLog{maxLen: maxLen}
+}
+
+// setMaxLen 修改保留的条数，多出的旧记录立即删除
+func (sl *slowLog) setMaxLen(maxLen int) {
+	if maxLen <= 0 {
+		maxLen = defaultSlowlogMaxLen
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	sl.maxLen = maxLen
+	if len(sl.entries) > sl.maxLen {
+		sl.entries = sl.entries[len(sl.entries)-sl.maxLen:]
+	}
+}
+
+// slowlogArgs 复制并截断参数，连接可能复用参数的内存
+func slowlogArgs(cmdLine [][]byte) [][]byte {
+	argc := len(cmdLine)
+	if argc > slowlogMaxArgc {
+		argc = slowlogMaxArgc
+	}
+	args := make([][]byte, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowlogMaxArgc-1 && len(cmdLine) > slowlogMaxArgc {
+			args[i] = []byte("... (" + strconv.Itoa(len(cmdLine)-slowlogMaxArgc+1) + " more arguments)")
+			break
+		}
+		arg := cmdLine[i]
+		if len(arg) > slowlogMaxArgLen {
+			args[i] = []byte(string(arg[:slowlogMaxArgLen]) + "... (" + strconv.Itoa(len(arg)-slowlogMaxArgLen) + " more bytes)")
+			continue
+		}
+		args[i] = append([]byte(nil), arg...)
+	}
+	return args
+}
+
+func (sl *slowLog) add(c redis.Connection, cmdLine [][]byte, start time.Time, duration time.Duration) {
+	entry := &slowlogEntry{
+		time:     start.Unix(),
+		duration: duration.Microseconds(),
+		args:     slowlogArgs(cmdLine),
+	}
+	if c != nil {
+		entry.addr = c.Name()
+	}
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	entry.id = sl.nextID
+	sl.nextID++
+	sl.entries = append(sl.entries, entry)
+	if len(sl.entries) > sl.maxLen {
+		sl.entries = sl.entries[len(sl.entries)-sl.maxLen:]
+	}
+}
+
+// recordSlowlog 执行时间超过阈值时记入慢日志
+func (server *Server) recordSlowlog(c redis.Connection, cmdLine [][]byte, start time.Time) {
+	threshold := server.slowlogThreshold.Load()
+	if threshold <= 0 {
+		return
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	// AUTH、HELLO 和 ACL 的参数包含密码，阻塞命令的耗时主要是等待数据
+	if cmdName == "auth" || cmdName == "hello" || cmdName == "acl" || cmdName == "slowlog" || isBlockingCommand(cmdName) {
+		return
+	}
+	duration := time.Since(start)
+	if duration.Microseconds() < threshold {
+		return
+	}
+	server.slowlog.add(c, cmdLine, start, duration)
+}
+
+// execSlowlog SLOWLOG GET [count] | LEN | RESET
+func (server *Server) execSlowlog(args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("slowlog")
+	}
+	sl := server.slowlog
+	switch strings.ToLower(string(args[0])) {
+	case "get":
+		if len(args) > 2 {
+			return protocol.MakeArgNumErrReply("slowlog|get")
+		}
+		count := 10
+		if len(args) == 2 {
+			n, err := strconv.Atoi(string(args[1]))
+			if err != nil || n < -1 {
+				return protocol.MakeErrReply("ERR count should be greater than or equal to -1")
+			}
+			count = n
+		}
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		if count == -1 || count > len(sl.entries) {
+			count = len(sl.entries)
+		}
+		replies := make([]redis.Reply, 0, count)
+		for i := len(sl.entries) - 1; i >= len(sl.entries)-count; i-- {
+			entry := sl.entries[i]
+			replies = append(replies, protocol.MakeMultiRawReply([]redis.Reply{
+				protocol.MakeIntReply(entry.id),
+				protocol.MakeIntReply(entry.time),
+				protocol.MakeIntReply(entry.duration),
+				protocol.MakeMultiBulkReply(entry.args),
+				protocol.MakeBulkReply([]byte(entry.addr)),
+				protocol.MakeBulkReply([]byte{}),
+			}))
+		}
+		return protocol.MakeMultiRawReply(replies)
+	case "len":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("slowlog|len")
+		}
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		return protocol.MakeIntReply(int64(len(sl.entries)))
+	case "reset":
+		if len(args) != 1 {
+			return protocol.MakeArgNumErrReply("slowlog|reset")
+		}
+		sl.mu.Lock()
+		defer sl.mu.Unlock()
+		sl.entries = nil
+		return protocol.MakeOkReply()
+	}
+	return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try SLOWLOG HELP.")
+}
@@ -30,7 +30,7 @@ func (db *DB) getOrInitSortedSet(key string) (*SortedSet.SortedSet, bool, protoc
 		return nil, false, err
 	}
 	if sortedSet == nil {
-		sortedSet = SortedSet.Make()
+		sortedSet = db.makeSortedSet()
 		db.PutEntity(key, &database.DataEntity{
 			Data: sortedSet,
 		})
@@ -92,14 +92,32 @@ func execZScore(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if sortedSet == nil {
-		return protocol.MakeErrReply("key not exist")
+		return protocol.MakeNullBulkReply()
 	}
 	element, exists := sortedSet.Get(member)
 	if !exists {
-		return protocol.MakeErrReply("member not exist")
+		return protocol.MakeNullBulkReply()
 	}
-	value := strconv.FormatFloat(element.Score, 'f', -1, 64)
-	return protocol.MakeBulkReply([]byte(value))
+	return protocol.MakeDoubleReply(element.Score)
+}
+
+// execZMScore ZMSCORE key member [member ...] 返回每个成员的分数，不存在的成员返回 nil
+func execZMScore(db *DB, args [][]byte) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	result := make([]redis.Reply, len(args)-1)
+	for i, member := range args[1:] {
+		result[i] = protocol.MakeNullBulkReply()
+		if sortedSet == nil {
+			continue
+		}
+		if element, exists := sortedSet.Get(string(member)); exists {
+			result[i] = protocol.MakeDoubleReply(element.Score)
+		}
+	}
+	return protocol.MakeMultiRawReply(result)
 }
 
 // 按照升序返回
@@ -111,9 +129,12 @@ func execZRank(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if sortedSet == nil {
-		return protocol.MakeErrReply("key not exist")
+		return protocol.MakeNullBulkReply()
 	}
 	rank := sortedSet.GetRank(member, false)
+	if rank < 0 {
+		return protocol.MakeNullBulkReply()
+	}
 	return protocol.MakeIntReply(int64(rank))
 }
 
@@ -126,9 +147,12 @@ func execZRevRank(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if sortedSet == nil {
-		return protocol.MakeErrReply("key not exist")
+		return protocol.MakeNullBulkReply()
 	}
 	rank := sortedSet.GetRank(member, true)
+	if rank < 0 {
+		return protocol.MakeNullBulkReply()
+	}
 	return protocol.MakeIntReply(int64(rank))
 }
 
@@ -189,7 +213,7 @@ func range0(db *DB, key string, start int64, stop int64, withScores bool, desc b
 		for _, element := range slice {
 			result[i] = []byte(element.Member)
 			i++
-			scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
+			scoreStr := protocol.FormatDouble(element.Score)
 			result[i] = []byte(scoreStr)
 			i++
 		}
@@ -206,13 +230,13 @@ func range0(db *DB, key string, start int64, stop int64, withScores bool, desc b
 
 func execZRange(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	if len(args) != 3 || len(args) != 4 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for ZRANGE command")
+	if len(args) != 3 && len(args) != 4 {
+		return protocol.MakeArgNumErrReply("zrange")
 	}
 	withValues := false
 	if len(args) == 4 {
 		if strings.ToUpper(string(args[3])) != "WITHSCORES" {
-			return protocol.MakeErrReply("syntax error")
+			return protocol.MakeSyntaxErrReply()
 		}
 		withValues = true
 	}
@@ -229,13 +253,13 @@ func execZRange(db *DB, args [][]byte) redis.Reply {
 
 func execZRevRange(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	if len(args) != 3 || len(args) != 4 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for ZRANGE command")
+	if len(args) != 3 && len(args) != 4 {
+		return protocol.MakeArgNumErrReply("zrevrange")
 	}
 	withValues := false
 	if len(args) == 4 {
 		if strings.ToUpper(string(args[3])) != "WITHSCORES" {
-			return protocol.MakeErrReply("syntax error")
+			return protocol.MakeSyntaxErrReply()
 		}
 		withValues = true
 	}
@@ -267,7 +291,7 @@ func rangeByScore0(db *DB, key string, min SortedSet.Border, max SortedSet.Borde
 		for _, element := range slice {
 			result[i] = []byte(element.Member)
 			i++
-			scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
+			scoreStr := protocol.FormatDouble(element.Score)
 			result[i] = []byte(scoreStr)
 			i++
 		}
@@ -424,13 +448,14 @@ func execZRemRangeByScore(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if sortedSet == nil {
-		return &protocol.EmptyMultiBulkReply{}
+		return protocol.MakeIntReply(0)
 	}
 
 	removed := sortedSet.RemoveRange(min, max)
 	if removed > 0 {
 		db.addAof(utils.ToCmdLine3("zremrangebyscore", args...))
 	}
+	db.deleteIfEmpty(key, sortedSet)
 	return protocol.MakeIntReply(removed)
 }
 
@@ -481,6 +506,7 @@ func execZRemRangeByRank(db *DB, args [][]byte) redis.Reply {
 	if removed > 0 {
 		db.addAof(utils.ToCmdLine3("zremrangebyrank", args...))
 	}
+	db.deleteIfEmpty(key, sortedSet)
 	return protocol.MakeIntReply(removed)
 }
 
@@ -511,6 +537,7 @@ func execZRem(db *DB, args [][]byte) redis.Reply {
 	if deleted > 0 {
 		db.addAof(utils.ToCmdLine3("zrem", args...))
 	}
+	db.deleteIfEmpty(key, sortedSet)
 	return protocol.MakeIntReply(deleted)
 }
 
@@ -524,32 +551,132 @@ func undoZRem(db *DB, args [][]byte) []CmdLine {
 	return rollbackZSetFields(db, key, fields...)
 }
 
+// execZPopMin ZPOPMIN key [count]
 func execZPopMin(db *DB, args [][]byte) redis.Reply {
+	return execZPop(db, args, false)
+}
+
+// execZPopMax ZPOPMAX key [count]
+func execZPopMax(db *DB, args [][]byte) redis.Reply {
+	return execZPop(db, args, true)
+}
+
+// popSortedSet 弹出 count 个分数最小或最大的成员
+func popSortedSet(sortedSet *SortedSet.SortedSet, count int, desc bool) []*SortedSet.Element {
+	if desc {
+		return sortedSet.PopMax(count)
+	}
+	return sortedSet.PopMin(count)
+}
+
+func execZPop(db *DB, args [][]byte, desc bool) redis.Reply {
+	if len(args) > 2 {
+		return protocol.MakeSyntaxErrReply()
+	}
 	key := string(args[0])
 	count := 1
-	sortedSet, _, errReply := db.getOrInitSortedSet(key)
-	if errReply != nil {
-		return errReply
-	}
 	if len(args) > 1 {
 		var err error
 		count, err = strconv.Atoi(string(args[1]))
 		if err != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
+		if count < 0 {
+			return protocol.MakeErrReply("ERR value is out of range, must be positive")
+		}
+	}
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil || count == 0 {
+		return &protocol.EmptyMultiBulkReply{}
 	}
-	removed := sortedSet.PopMin(count)
+	removed := popSortedSet(sortedSet, count, desc)
 	if len(removed) > 0 {
-		db.addAof(utils.ToCmdLine3("zpopmin", args...))
+		cmdName := "zpopmin"
+		if desc {
+			cmdName = "zpopmax"
+		}
+		db.addAof(utils.ToCmdLine3(cmdName, args...))
 	}
+	db.deleteIfEmpty(key, sortedSet)
 	result := make([][]byte, 0, len(removed)*2)
 	for _, element := range removed {
-		scoreStr := strconv.FormatFloat(element.Score, 'f', -1, 64)
+		scoreStr := protocol.FormatDouble(element.Score)
 		result = append(result, []byte(element.Member), []byte(scoreStr))
 	}
 	return protocol.MakeMultiBulkReply(result)
 }
 
+// execZPeekMin ZPEEKMIN key 返回分数最小的成员和分数但不删除，key 不存在时返回空数组
+func execZPeekMin(db *DB, args [][]byte) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	member, score, ok := sortedSet.PeekMin()
+	if !ok {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	return protocol.MakeMultiBulkReply([][]byte{[]byte(member), []byte(protocol.FormatDouble(score))})
+}
+
+// execZRandMember ZRANDMEMBER key [count [WITHSCORES]]
+// count 为正数时返回不重复的成员，为负数时可以重复，返回的数量总是 -count
+func execZRandMember(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 3 {
+		return protocol.MakeSyntaxErrReply()
+	}
+	sortedSet, errReply := db.getAsSortedSet(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if len(args) == 1 {
+		if sortedSet == nil {
+			return protocol.MakeNullBulkReply()
+		}
+		return protocol.MakeBulkReply([]byte(sortedSet.RandomDistinctMembers(1)[0].Member))
+	}
+	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	withScores := false
+	if len(args) == 3 {
+		if !strings.EqualFold(string(args[2]), "WITHSCORES") {
+			return protocol.MakeSyntaxErrReply()
+		}
+		withScores = true
+	}
+	if sortedSet == nil || count64 == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	var elements []*SortedSet.Element
+	if count64 > 0 {
+		elements = sortedSet.RandomDistinctMembers(int(min(count64, sortedSet.Len())))
+	} else {
+		if count64 < -math.MaxInt32 {
+			return protocol.MakeErrReply("ERR value is out of range")
+		}
+		if errReply := db.checkReplySize(int(-count64), "ZSCAN"); errReply != nil {
+			return errReply
+		}
+		elements = sortedSet.RandomMembers(int(-count64))
+	}
+	result := make([][]byte, 0, len(elements)*2)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, []byte(protocol.FormatDouble(element.Score)))
+		}
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
 func execZInrc(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	rawDelta := string(args[1]) // 增量值
@@ -567,11 +694,11 @@ func execZInrc(db *DB, args [][]byte) redis.Reply {
 	if !exists {
 		return protocol.MakeErrReply("ERR field doesn't exist")
 	}
-	element.Score += delta
-	sortedSet.Add(field, element.Score)
-	bytes := []byte(strconv.FormatFloat(element.Score, 'f', -1, 64))
+	// 不能直接修改 element.Score，否则 Add 发现分数没变不会调整跳表
+	score := element.Score + delta
+	sortedSet.Add(field, score)
 	db.addAof(utils.ToCmdLine3("zincrby", args...))
-	return protocol.MakeBulkReply(bytes)
+	return protocol.MakeDoubleReply(score)
 }
 
 func undoZIncr(db *DB, args [][]byte) []CmdLine {
@@ -585,18 +712,20 @@ func execZLexCount(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	minEle := string(args[1])
 	maxEle := string(args[2])
-	sortedSet, _, err := db.getOrInitSortedSet(key)
+	min, err := SortedSet.ParseLexBorder(minEle)
 	if err != nil {
-		return err
-
+		return protocol.MakeErrReply(err.Error())
 	}
-	min, err2 := SortedSet.ParseLexBorder(minEle)
-	if err2 != nil {
-		return protocol.MakeErrReply(err2.Error())
+	max, err := SortedSet.ParseLexBorder(maxEle)
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
 	}
-	max, err3 := SortedSet.ParseLexBorder(maxEle)
-	if err3 != nil {
-		return protocol.MakeErrReply(err3.Error())
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeIntReply(0)
 	}
 	count := sortedSet.RangeCount(min, max)
 	return protocol.MakeIntReply(int64(count))
@@ -612,14 +741,6 @@ func execZRangeByLex(db *DB, args [][]byte) redis.Reply {
 	}
 
 	key := string(args[0])
-	sortedSet, errReply := db.getAsSortedSet(key)
-	if errReply != nil {
-		return errReply
-	}
-	if sortedSet == nil {
-		return protocol.MakeIntReply(0)
-	}
-
 	minEle, maxEle := string(args[1]), string(args[2])
 	min, err := SortedSet.ParseLexBorder(minEle)
 	if err != nil {
@@ -629,9 +750,16 @@ func execZRangeByLex(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return protocol.MakeErrReply(err.Error())
 	}
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
 
 	offset := int64(0)
-	limitCnt := int64(math.MaxInt64)
+	limitCnt := int64(-1)
 	if n > 3 {
 		var err error
 		offset, err = strconv.ParseInt(string(args[4]), 10, 64)
@@ -645,9 +773,7 @@ func execZRangeByLex(db *DB, args [][]byte) redis.Reply {
 		if err != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		if count >= 0 {
-			limitCnt = count
-		}
+		limitCnt = count
 	}
 
 	elements := sortedSet.Range(min, max, offset, limitCnt, false)
@@ -671,14 +797,6 @@ func execZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
 	}
 
 	key := string(args[0])
-	sortedSet, errReply := db.getAsSortedSet(key)
-	if errReply != nil {
-		return errReply
-	}
-	if sortedSet == nil {
-		return protocol.MakeIntReply(0)
-	}
-
 	minEle, maxEle := string(args[2]), string(args[1])
 	min, err := SortedSet.ParseLexBorder(minEle)
 	if err != nil {
@@ -688,9 +806,16 @@ func execZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return protocol.MakeErrReply(err.Error())
 	}
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
 
 	offset := int64(0)
-	limitCnt := int64(math.MaxInt64)
+	limitCnt := int64(-1)
 	if n > 3 {
 		var err error
 		offset, err = strconv.ParseInt(string(args[4]), 10, 64)
@@ -704,9 +829,7 @@ func execZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
 		if err != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		if count >= 0 {
-			limitCnt = count
-		}
+		limitCnt = count
 	}
 
 	elements := sortedSet.Range(min, max, offset, limitCnt, true)
@@ -770,14 +893,6 @@ func execZRemRangeByLex(db *DB, args [][]byte) redis.Reply {
 	}
 
 	key := string(args[0])
-	sortedSet, errReply := db.getAsSortedSet(key)
-	if errReply != nil {
-		return errReply
-	}
-	if sortedSet == nil {
-		return protocol.MakeIntReply(0)
-	}
-
 	minEle, maxEle := string(args[1]), string(args[2])
 	min, err := SortedSet.ParseLexBorder(minEle)
 	if err != nil {
@@ -787,9 +902,19 @@ func execZRemRangeByLex(db *DB, args [][]byte) redis.Reply {
 	if err != nil {
 		return protocol.MakeErrReply(err.Error())
 	}
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeIntReply(0)
+	}
 
 	count := sortedSet.RemoveRange(min, max)
-
+	if count > 0 {
+		db.addAof(utils.ToCmdLine3("zremrangebylex", args...))
+	}
+	db.deleteIfEmpty(key, sortedSet)
 	return protocol.MakeIntReply(count)
 }
 
@@ -798,6 +923,10 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZScore", execZScore, readFirstKey, nil, 3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("ZMScore", execZMScore, readFirstKey, nil, -3, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("ZRandMember", execZRandMember, readFirstKey, nil, -2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
 	registerCommand("ZIncrBy", execZInrc, writeFirstKey, undoZIncr, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZRank", execZRank, readFirstKey, nil, 3, flagReadOnly).
@@ -818,6 +947,10 @@ func init() {
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 	registerCommand("ZPopMin", execZPopMin, writeFirstKey, rollbackFirstKey, -2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("ZPopMax", execZPopMax, writeFirstKey, rollbackFirstKey, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
+	registerCommand("ZPeekMin", execZPeekMin, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZRem", execZRem, writeFirstKey, undoZRem, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, rollbackFirstKey, 4, flagWrite).
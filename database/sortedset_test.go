@@ -0,0 +1,173 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func bulks(values ...string) string {
+	return string(protocol.MakeMultiBulkReply(utils.ToCmdLine(values...)).ToBytes())
+}
+
+// 期望结果与 redis 7 的返回一致
+func TestZSetRangeBorders(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "ZADD", "z", "-inf", "ninf", "1", "a", "1.5", "b", "1.5", "c", "2", "d", "+inf", "pinf")
+	execTestCmd(db, "ZADD", "lex", "0", "a", "0", "aa", "0", "ab", "0", "b", "0", "c")
+	execTestCmd(db, "SET", "str", "x")
+	notFloat := "-ERR min or max is not a float\r\n"
+	notLex := "-ERR min or max not valid string range item\r\n"
+
+	tests := []struct {
+		cmd      []string
+		expected string
+	}{
+		{[]string{"ZRANGEBYSCORE", "z", "-inf", "+inf"}, bulks("ninf", "a", "b", "c", "d", "pinf")},
+		{[]string{"ZRANGEBYSCORE", "z", "(-inf", "(+inf"}, bulks("a", "b", "c", "d")},
+		{[]string{"ZRANGEBYSCORE", "z", "(1", "(2"}, bulks("b", "c")},
+		{[]string{"ZRANGEBYSCORE", "z", "(1.5", "2"}, bulks("d")},
+		{[]string{"ZRANGEBYSCORE", "z", "1.5", "(1.5"}, bulks()},
+		{[]string{"ZRANGEBYSCORE", "z", "2", "1"}, bulks()},
+		{[]string{"ZRANGEBYSCORE", "z", "(2", "+inf", "WITHSCORES"}, bulks("pinf", "inf")},
+		{[]string{"ZRANGEBYSCORE", "z", "-inf", "1", "WITHSCORES"}, bulks("ninf", "-inf", "a", "1")},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "2", "LIMIT", "1", "2"}, bulks("b", "c")},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "2", "LIMIT", "3", "-1"}, bulks("d")},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "2", "LIMIT", "4", "10"}, bulks()},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "2", "LIMIT", "-1", "10"}, bulks()},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "2", "LIMIT", "0", "0"}, bulks()},
+		{[]string{"ZRANGEBYSCORE", "z", "", "1"}, notFloat},
+		{[]string{"ZRANGEBYSCORE", "z", "(", "1"}, notFloat},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "nan"}, notFloat},
+		{[]string{"ZRANGEBYSCORE", "z", "1", "x"}, notFloat},
+		{[]string{"ZRANGEBYSCORE", "missing", "-inf", "+inf"}, bulks()},
+		{[]string{"ZRANGEBYSCORE", "str", "-inf", "+inf"}, "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		{[]string{"ZREVRANGEBYSCORE", "z", "+inf", "-inf"}, bulks("pinf", "d", "c", "b", "a", "ninf")},
+		{[]string{"ZREVRANGEBYSCORE", "z", "(2", "(1"}, bulks("c", "b")},
+		{[]string{"ZREVRANGEBYSCORE", "z", "2", "1", "LIMIT", "1", "2"}, bulks("c", "b")},
+		{[]string{"ZREVRANGEBYSCORE", "z", "1", "2"}, bulks()},
+
+		{[]string{"ZCOUNT", "z", "-inf", "+inf"}, ":6\r\n"},
+		{[]string{"ZCOUNT", "z", "(1", "2"}, ":3\r\n"},
+		{[]string{"ZCOUNT", "z", "(1.5", "(2"}, ":0\r\n"},
+		{[]string{"ZCOUNT", "z", "+inf", "+inf"}, ":1\r\n"},
+		{[]string{"ZCOUNT", "z", "1", "abc"}, notFloat},
+
+		{[]string{"ZRANGEBYLEX", "lex", "-", "+"}, bulks("a", "aa", "ab", "b", "c")},
+		{[]string{"ZRANGEBYLEX", "lex", "[aa", "+"}, bulks("aa", "ab", "b", "c")},
+		{[]string{"ZRANGEBYLEX", "lex", "(aa", "+"}, bulks("ab", "b", "c")},
+		{[]string{"ZRANGEBYLEX", "lex", "[aa", "(b"}, bulks("aa", "ab")},
+		{[]string{"ZRANGEBYLEX", "lex", "(aa", "[aa"}, bulks()},
+		{[]string{"ZRANGEBYLEX", "lex", "-", "[", "LIMIT", "0", "1"}, bulks()},
+		{[]string{"ZRANGEBYLEX", "lex", "[", "(b"}, bulks("a", "aa", "ab")},
+		{[]string{"ZRANGEBYLEX", "lex", "-", "+", "LIMIT", "1", "2"}, bulks("aa", "ab")},
+		{[]string{"ZRANGEBYLEX", "lex", "-", "+", "LIMIT", "3", "-1"}, bulks("b", "c")},
+		{[]string{"ZRANGEBYLEX", "lex", "+", "-"}, bulks()},
+		{[]string{"ZRANGEBYLEX", "lex", "-", "-"}, bulks()},
+		{[]string{"ZRANGEBYLEX", "lex", "", "+"}, notLex},
+		{[]string{"ZRANGEBYLEX", "lex", "a", "+"}, notLex},
+		{[]string{"ZRANGEBYLEX", "lex", "-", "+b"}, notLex},
+		{[]string{"ZRANGEBYLEX", "missing", "-", "+"}, bulks()},
+		{[]string{"ZRANGEBYLEX", "missing", "a", "+"}, notLex},
+
+		{[]string{"ZREVRANGEBYLEX", "lex", "+", "-"}, bulks("c", "b", "ab", "aa", "a")},
+		{[]string{"ZREVRANGEBYLEX", "lex", "(b", "[aa"}, bulks("ab", "aa")},
+		{[]string{"ZREVRANGEBYLEX", "lex", "+", "[b", "LIMIT", "1", "1"}, bulks("b")},
+		{[]string{"ZREVRANGEBYLEX", "missing", "+", "-"}, bulks()},
+
+		{[]string{"ZLEXCOUNT", "lex", "-", "+"}, ":5\r\n"},
+		{[]string{"ZLEXCOUNT", "lex", "[a", "(b"}, ":3\r\n"},
+		{[]string{"ZLEXCOUNT", "lex", "(c", "+"}, ":0\r\n"},
+		{[]string{"ZLEXCOUNT", "missing", "-", "+"}, ":0\r\n"},
+		{[]string{"ZLEXCOUNT", "lex", "[a", "b"}, notLex},
+		{[]string{"EXISTS", "missing"}, ":0\r\n"},
+	}
+	for _, tt := range tests {
+		actual := string(execTestCmd(db, tt.cmd...).ToBytes())
+		if actual != tt.expected {
+			t.Errorf("%v: expected %q, actually %q", tt.cmd, tt.expected, actual)
+		}
+	}
+}
+
+func TestZSetRemoveRangeBorders(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "ZADD", "z", "1", "a", "2", "b", "3", "c", "+inf", "d")
+	assertReply(t, execTestCmd(db, "ZREMRANGEBYSCORE", "z", "(1", "(+inf"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "ZRANGEBYSCORE", "z", "-inf", "+inf"), bulks("a", "d"))
+	assertReply(t, execTestCmd(db, "ZREMRANGEBYSCORE", "z", "(", "1"), "-ERR min or max is not a float\r\n")
+
+	execTestCmd(db, "ZADD", "lex", "0", "a", "0", "b", "0", "c")
+	assertReply(t, execTestCmd(db, "ZREMRANGEBYLEX", "lex", "(a", "+"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "ZREMRANGEBYLEX", "missing", "x", "+"), "-ERR min or max not valid string range item\r\n")
+	assertReply(t, execTestCmd(db, "ZREMRANGEBYLEX", "lex", "-", "+"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "lex"), ":0\r\n")
+}
+
+func TestZPeekMin(t *testing.T) {
+	db := makeTestDB()
+	assertReply(t, execTestCmd(db, "ZPEEKMIN", "z"), "*0\r\n")
+	execTestCmd(db, "ZADD", "z", "3", "c", "1.5", "a", "2", "b")
+	assertReply(t, execTestCmd(db, "ZPEEKMIN", "z"), bulks("a", "1.5"))
+	assertReply(t, execTestCmd(db, "ZCARD", "z"), ":3\r\n")
+	execTestCmd(db, "ZPOPMIN", "z")
+	assertReply(t, execTestCmd(db, "ZPEEKMIN", "z"), bulks("b", "2"))
+	execTestCmd(db, "SET", "str", "x")
+	assertReply(t, execTestCmd(db, "ZPEEKMIN", "str"), "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestZPop(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "ZADD", "z", "1", "a", "2", "b", "3", "c", "4", "d")
+	assertReply(t, execTestCmd(db, "ZPOPMAX", "z"), bulks("d", "4"))
+	assertReply(t, execTestCmd(db, "ZPOPMAX", "z", "2"), bulks("c", "3", "b", "2"))
+	assertReply(t, execTestCmd(db, "ZPOPMIN", "z", "0"), "*0\r\n")
+	assertReply(t, execTestCmd(db, "ZPOPMAX", "z", "-1"), "-ERR value is out of range, must be positive\r\n")
+	assertReply(t, execTestCmd(db, "ZPOPMAX", "z", "1", "2"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "ZPOPMAX", "z", "10"), bulks("a", "1"))
+	assertReply(t, execTestCmd(db, "EXISTS", "z"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "ZPOPMAX", "z"), "*0\r\n")
+}
+
+func TestZMScore(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "ZADD", "z", "1.5", "a", "2", "b")
+	assertReply(t, protocol.ToRESP2(execTestCmd(db, "ZMSCORE", "z", "a", "nomember", "b")),
+		"*3\r\n$3\r\n1.5\r\n$-1\r\n$1\r\n2\r\n")
+	assertReply(t, execTestCmd(db, "ZMSCORE", "nokey", "a", "b"), "*2\r\n$-1\r\n$-1\r\n")
+}
+
+func TestZRandMember(t *testing.T) {
+	db := makeTestDB()
+	assertReply(t, execTestCmd(db, "ZRANDMEMBER", "z"), "$-1\r\n")
+	assertReply(t, execTestCmd(db, "ZRANDMEMBER", "z", "2"), "*0\r\n")
+	execTestCmd(db, "ZADD", "z", "1", "a", "2", "b", "3", "c")
+	scores := map[string]string{"a": "1", "b": "2", "c": "3"}
+
+	reply, ok := execTestCmd(db, "ZRANDMEMBER", "z", "5", "WITHSCORES").(*protocol.MultiBulkReply)
+	if !ok || len(reply.Args) != 6 {
+		t.Fatalf("expected 3 members with scores, actually %v", reply)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < len(reply.Args); i += 2 {
+		member := string(reply.Args[i])
+		if seen[member] || scores[member] != string(reply.Args[i+1]) {
+			t.Errorf("unexpected member %s %s", member, reply.Args[i+1])
+		}
+		seen[member] = true
+	}
+	reply, ok = execTestCmd(db, "ZRANDMEMBER", "z", "-10").(*protocol.MultiBulkReply)
+	if !ok || len(reply.Args) != 10 {
+		t.Fatalf("expected 10 members, actually %v", reply)
+	}
+	for _, member := range reply.Args {
+		if _, ok := scores[string(member)]; !ok {
+			t.Errorf("unexpected member %s", member)
+		}
+	}
+	assertReply(t, execTestCmd(db, "ZRANDMEMBER", "z", "0"), "*0\r\n")
+	assertReply(t, execTestCmd(db, "ZRANDMEMBER", "z", "1", "SCORES"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "ZRANDMEMBER", "z", "x"), "-ERR value is not an integer or out of range\r\n")
+}
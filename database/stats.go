@@ -0,0 +1,163 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 与 redis 的 trackInstantaneousMetric 相同：后台每 100ms 采样一次各个计数器，
+// 计算两次采样之间每秒的增量并放入长度为 16 的环形数组，instantaneous_* 取环中所有样本的平均值
+
+const (
+	statsMetricSamples  = 16
+	statsSampleInterval = 100 * time.Millisecond
+)
+
+const (
+	statsMetricCommand = iota
+	statsMetricNetInput
+	statsMetricNetOutput
+	statsMetricExpired
+	statsMetricEvicted // 目前没有 maxmemory 淘汰，evicted_keys 始终为 0
+	statsMetricKeyspaceHits
+	statsMetricKeyspaceMisses
+	statsMetricCount
+)
+
+type instantMetric struct {
+	lastSampleTime  int64 // unix 毫秒
+	lastSampleCount int64
+	samples         [statsMetricSamples]float64
+	idx             int
+}
+
+// serverStats 统计实例的累计值和每秒速率，nil 表示不统计(例如加载时使用的临时实例)
+type serverStats struct {
+	counters [statsMetricCount]atomic.Int64
+
+	mu      sync.Mutex
+	metrics [statsMetricCount]instantMetric
+
+	// used_memory_peak，采样时更新
+	memoryPeak atomic.Uint64
+}
+
+func (s *serverStats) incr(metric int, n int64) {
+	if s == nil {
+		return
+	}
+	s.counters[metric].Add(n)
+}
+
+func (s *serverStats) total(metric int) int64 {
+	if s == nil {
+		return 0
+	}
+	return s.counters[metric].Load()
+}
+
+// track 记录一次采样，now 为 unix 毫秒
+func (s *serverStats) track(now int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.metrics {
+		m := &s.metrics[i]
+		current := s.counters[i].Load()
+		if m.lastSampleTime > 0 && now > m.lastSampleTime {
+			elapsed := now - m.lastSampleTime
+			m.samples[m.idx] = float64(current-m.lastSampleCount) * 1000 / float64(elapsed)
+			m.idx = (m.idx + 1) % statsMetricSamples
+		}
+		m.lastSampleTime = now
+		m.lastSampleCount = current
+	}
+}
+
+// instantaneous 返回最近 16 次采样的平均每秒增量
+func (s *serverStats) instantaneous(metric int) float64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sum := 0.0
+	for _, sample := range s.metrics[metric].samples {
+		sum += sample
+	}
+	return sum / statsMetricSamples
+}
+
+// run 在后台定时采样，直到 stop 关闭
+func (s *serverStats) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(statsSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.track(now.UnixMilli())
+			s.trackMemoryPeak()
+		}
+	}
+}
+
+// RecordNetInput 记录从客户端读取的字节数
+func (server *Server) RecordNetInput(n int) {
+	server.stats.incr(statsMetricNetInput, int64(n))
+}
+
+// RecordNetOutput 记录写给客户端的字节数
+func (server *Server) RecordNetOutput(n int) {
+	server.stats.incr(statsMetricNetOutput, int64(n))
+}
+
+// StatsSnapshot 返回 INFO stats 中的计数器和每秒速率，用于 metrics 接口
+func (server *Server) StatsSnapshot() map[string]float64 {
+	s := server.stats
+	return map[string]float64{
+		"total_commands_processed":           float64(s.total(statsMetricCommand)),
+		"instantaneous_ops_per_sec":          s.instantaneous(statsMetricCommand),
+		"total_net_input_bytes":              float64(s.total(statsMetricNetInput)),
+		"total_net_output_bytes":             float64(s.total(statsMetricNetOutput)),
+		"instantaneous_input_kbps":           s.instantaneous(statsMetricNetInput) / 1024,
+		"instantaneous_output_kbps":          s.instantaneous(statsMetricNetOutput) / 1024,
+		"expired_keys":                       float64(s.total(statsMetricExpired)),
+		"evicted_keys":                       float64(s.total(statsMetricEvicted)),
+		"instantaneous_expired_keys_per_sec": s.instantaneous(statsMetricExpired),
+		"instantaneous_evicted_keys_per_sec": s.instantaneous(statsMetricEvicted),
+		"keyspace_hits":                      float64(s.total(statsMetricKeyspaceHits)),
+		"keyspace_misses":                    float64(s.total(statsMetricKeyspaceMisses)),
+	}
+}
+
+func (server *Server) statsInfo() string {
+	s := server.stats
+	return fmt.Sprintf("# Stats\r\n"+
+		"total_commands_processed:%d\r\n"+
+		"instantaneous_ops_per_sec:%d\r\n"+
+		"total_net_input_bytes:%d\r\n"+
+		"total_net_output_bytes:%d\r\n"+
+		"instantaneous_input_kbps:%.2f\r\n"+
+		"instantaneous_output_kbps:%.2f\r\n"+
+		"expired_keys:%d\r\n"+
+		"evicted_keys:%d\r\n"+
+		"instantaneous_expired_keys_per_sec:%d\r\n"+
+		"instantaneous_evicted_keys_per_sec:%d\r\n"+
+		"keyspace_hits:%d\r\n"+
+		"keyspace_misses:%d\r\n",
+		s.total(statsMetricCommand),
+		int64(s.instantaneous(statsMetricCommand)),
+		s.total(statsMetricNetInput),
+		s.total(statsMetricNetOutput),
+		s.instantaneous(statsMetricNetInput)/1024,
+		s.instantaneous(statsMetricNetOutput)/1024,
+		s.total(statsMetricExpired),
+		s.total(statsMetricEvicted),
+		int64(s.instantaneous(statsMetricExpired)),
+		int64(s.instantaneous(statsMetricEvicted)),
+		s.total(statsMetricKeyspaceHits),
+		s.total(statsMetricKeyspaceMisses))
+}
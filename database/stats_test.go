@@ -0,0 +1,172 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/metrics"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestStatsSampler(t *testing.T) {
+	s := &serverStats{}
+	now := int64(1000)
+	s.track(now)
+	// 前 8 次采样每 100ms 执行 50 条命令(500/s)，之后空闲
+	for i := 0; i < 8; i++ {
+		s.incr(statsMetricCommand, 50)
+		s.incr(statsMetricNetInput, 1024)
+		now += 100
+		s.track(now)
+	}
+	if ops := s.instantaneous(statsMetricCommand); ops != 250 {
+		t.Fatalf("expected 250 ops/sec, got %v", ops)
+	}
+	if kbps := s.instantaneous(statsMetricNetInput) / 1024; kbps != 5 {
+		t.Fatalf("expected 5 kbps, got %v", kbps)
+	}
+	for i := 0; i < statsMetricSamples; i++ {
+		now += 100
+		s.track(now)
+	}
+	if ops := s.instantaneous(statsMetricCommand); ops != 0 || s.total(statsMetricCommand) != 400 {
+		t.Fatalf("old samples should be dropped, got %v ops/sec, total %d", ops, s.total(statsMetricCommand))
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v", "PX", "1"))
+	time.Sleep(5 * time.Millisecond)
+	server.Exec(conn, utils.ToCmdLine("GET", "k"))
+	server.RecordNetInput(10)
+	server.RecordNetOutput(20)
+
+	info := string(server.Exec(conn, utils.ToCmdLine("INFO", "stats")).ToBytes())
+	for _, field := range []string{
+		"total_commands_processed:3\r\n",
+		"total_net_input_bytes:10\r\n",
+		"total_net_output_bytes:20\r\n",
+		"expired_keys:1\r\n",
+		"evicted_keys:0\r\n",
+		"instantaneous_ops_per_sec:",
+		"instantaneous_input_kbps:",
+	} {
+		if !strings.Contains(info, field) {
+			t.Fatalf("INFO stats should contain %q, got %q", field, info)
+		}
+	}
+	if snapshot := server.StatsSnapshot(); snapshot["expired_keys"] != 1 {
+		t.Fatalf("unexpected snapshot %v", snapshot)
+	}
+}
+
+func TestInfoSections(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir()})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.AfterClientConnect(conn)
+	info := func(args ...string) string {
+		reply, ok := server.Exec(conn, utils.ToCmdLine(append([]string{"INFO"}, args...)...)).(*protocol.BulkReply)
+		if !ok {
+			t.Fatalf("INFO %v should return a bulk string", args)
+		}
+		return string(reply.Arg)
+	}
+	headers := func(s string) []string {
+		var result []string
+		for _, line := range strings.Split(s, "\r\n") {
+			if strings.HasPrefix(line, "# ") {
+				result = append(result, line[2:])
+			}
+		}
+		return result
+	}
+
+	defaults := headers(info())
+	if strings.Join(defaults, ",") != "Server,Clients,Memory,Persistence,Stats,Replication,CPU,Cluster,Keyspace" {
+		t.Errorf("unexpected default sections %v", defaults)
+	}
+	if got := headers(info("default")); strings.Join(got, ",") != strings.Join(defaults, ",") {
+		t.Errorf("INFO default: unexpected sections %v", got)
+	}
+	// all 还包括不在默认段中的 Commandstats
+	for _, alias := range []string{"all", "everything", "ALL"} {
+		if got := headers(info(alias)); strings.Join(got, ",") != "Server,Clients,Memory,Persistence,Stats,Replication,CPU,Commandstats,Cluster,Keyspace" {
+			t.Errorf("INFO %s: unexpected sections %v", alias, got)
+		}
+	}
+	// 多个段按固定顺序输出，重复的段只输出一次，段之间有空行
+	s := info("stats", "SERVER", "stats", "nosuchsection")
+	if got := headers(s); strings.Join(got, ",") != "Server,Stats" {
+		t.Errorf("unexpected sections %v", got)
+	}
+	if !strings.Contains(s, "\r\n\r\n# Stats\r\n") {
+		t.Errorf("sections should be separated by an empty line, got %q", s)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("INFO", "nosuchsection")), "$0\r\n\r\n")
+	if s := info("client"); !strings.HasPrefix(s, "# Clients\r\nconnected_clients:1\r\n") {
+		t.Errorf("unexpected clients section %q", s)
+	}
+
+	// 只读命令读取的 key 计入命中和未命中
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "v"))
+	server.Exec(conn, utils.ToCmdLine("SET", "t", "v", "EX", "100"))
+	server.Exec(conn, utils.ToCmdLine("MGET", "k", "t", "missing"))
+	if s := info("stats"); !strings.Contains(s, "keyspace_hits:2\r\nkeyspace_misses:1\r\n") {
+		t.Errorf("unexpected keyspace hits and misses %q", s)
+	}
+	s = info("keyspace")
+	if !strings.HasPrefix(s, "# Keyspace\r\ndb0:keys=2,expires=1,avg_ttl=") || strings.Contains(s, "db1:") {
+		t.Errorf("unexpected keyspace section %q", s)
+	}
+	if s := info("memory"); !strings.Contains(s, "used_memory_peak:") || strings.Contains(s, "used_memory_dataset:0\r\n") {
+		t.Errorf("unexpected memory section %q", s)
+	}
+	if s := info("persistence"); !strings.Contains(s, "aof_rewrite_in_progress:0\r\n") {
+		t.Errorf("unexpected persistence section %q", s)
+	}
+}
+
+func TestCommandStatsAndMetrics(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "b", "2", "EX", "100"))
+	server.Exec(conn, utils.ToCmdLine("GET", "a"))
+	// 不认识的命令不统计
+	server.Exec(conn, utils.ToCmdLine("NOSUCHCMD"))
+
+	info := string(server.Exec(conn, utils.ToCmdLine("INFO", "commandstats")).ToBytes())
+	if !strings.Contains(info, "cmdstat_set:calls=2,") || !strings.Contains(info, "cmdstat_get:calls=1,") {
+		t.Fatalf("unexpected commandstats %q", info)
+	}
+	if strings.Contains(info, "nosuchcmd") {
+		t.Fatalf("unknown command should not be counted: %q", info)
+	}
+
+	var sb strings.Builder
+	if err := metrics.WriteText(&sb, server.CollectMetrics()); err != nil {
+		t.Fatal(err)
+	}
+	text := sb.String()
+	for _, line := range []string{
+		`redis_commands_total{cmd="set"} 2`,
+		`redis_command_duration_seconds_count{cmd="get"} 1`,
+		`redis_db_keys{db="db0"} 2`,
+		`redis_db_keys_expiring{db="db0"} 1`,
+		`redis_connected_clients `,
+		`redis_aof_rewrite_in_progress 0`,
+	} {
+		if !strings.Contains(text, line) {
+			t.Errorf("missing %q in metrics:\n%s", line, text)
+		}
+	}
+}
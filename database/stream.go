@@ -0,0 +1,482 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/zhangming/go-redis/datastruct/stream"
+	"github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 消息流 XADD/XLEN/XRANGE/XREVRANGE/XREAD/XTRIM/XSETID
+// 近似裁剪(~)也按精确的数量裁剪，写入 aof 的 XADD/XTRIM 统一改写为 MAXLEN = 裁剪后的长度，重放结果与执行时一致
+
+const (
+	errInvalidStreamID  = "ERR Invalid stream ID specified as stream command argument"
+	errStreamIDTooSmall = "ERR The ID specified in XADD is equal or smaller than the target stream top item"
+)
+
+func (db *DB) getAsStream(key string) (*stream.Stream, protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+	s, ok := entity.Data.(*stream.Stream)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return s, nil
+}
+
+// streamTrim 裁剪参数 MAXLEN|MINID [=|~] threshold [LIMIT count]
+type streamTrim struct {
+	byMinID bool
+	maxLen  int
+	minID   stream.ID
+	limit   int
+}
+
+// parseStreamTrim 从 args[0] 开始解析裁剪参数，返回消耗的参数个数
+func parseStreamTrim(args [][]byte) (*streamTrim, int, protocol.ErrorReply) {
+	trim := &streamTrim{byMinID: strings.ToUpper(string(args[0])) == "MINID"}
+	i := 1
+	approx := false
+	if i < len(args) {
+		switch string(args[i]) {
+		case "~":
+			approx = true
+			i++
+		case "=":
+			i++
+		}
+	}
+	if i >= len(args) {
+		return nil, 0, protocol.MakeSyntaxErrReply()
+	}
+	if trim.byMinID {
+		id, _, ok := stream.ParseID(string(args[i]))
+		if !ok {
+			return nil, 0, protocol.MakeErrReply(errInvalidStreamID)
+		}
+		trim.minID = id
+	} else {
+		maxLen, err := strconv.Atoi(string(args[i]))
+		if err != nil {
+			return nil, 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return nil, 0, protocol.MakeErrReply("ERR The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = maxLen
+	}
+	i++
+	if i < len(args) && strings.ToUpper(string(args[i])) == "LIMIT" {
+		if !approx {
+			return nil, 0, protocol.MakeErrReply("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		if i+1 >= len(args) {
+			return nil, 0, protocol.MakeSyntaxErrReply()
+		}
+		limit, err := strconv.Atoi(string(args[i+1]))
+		if err != nil || limit < 0 {
+			return nil, 0, protocol.MakeErrReply("ERR The LIMIT argument must be >= 0.")
+		}
+		trim.limit = limit
+		i += 2
+	}
+	return trim, i, nil
+}
+
+func (trim *streamTrim) apply(s *stream.Stream) int {
+	if trim.byMinID {
+		return s.TrimMinID(trim.minID, trim.limit)
+	}
+	return s.TrimMaxLen(trim.maxLen, trim.limit)
+}
+
+// execXAdd XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...]
+func execXAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	noMkStream := false
+	var trim *streamTrim
+	i := 1
+options:
+	for i < len(args) {
+		switch strings.ToUpper(string(args[i])) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			i++
+		case "MAXLEN", "MINID":
+			var n int
+			var errReply protocol.ErrorReply
+			trim, n, errReply = parseStreamTrim(args[i:])
+			if errReply != nil {
+				return errReply
+			}
+			i += n
+		default:
+			break options
+		}
+	}
+	if i >= len(args) || len(args[i+1:]) == 0 || len(args[i+1:])%2 != 0 {
+		return protocol.MakeArgNumErrReply("xadd")
+	}
+	rawID := string(args[i])
+	fields := args[i+1:]
+
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil && noMkStream {
+		return protocol.MakeNullBulkReply()
+	}
+	target := s
+	if target == nil {
+		target = stream.New()
+	}
+	id, errReply := nextStreamID(target, rawID)
+	if errReply != nil {
+		return errReply
+	}
+	target.Add(id, fields)
+	if s == nil {
+		db.PutEntity(key, &database.DataEntity{Data: target})
+	}
+
+	cmdLine := utils.ToCmdLine("XADD", key)
+	if trim != nil && trim.apply(target) > 0 {
+		cmdLine = append(cmdLine, []byte("MAXLEN"), []byte("="), []byte(strconv.Itoa(target.Len())))
+	}
+	cmdLine = append(cmdLine, []byte(id.String()))
+	cmdLine = append(cmdLine, fields...)
+	db.addAof(cmdLine)
+	db.broadcastBlocked(key)
+	return protocol.MakeBulkReply([]byte(id.String()))
+}
+
+// nextStreamID 解析 XADD 的 ID 参数：* 自动生成，ms-* 自动生成序号，其余为显式的 ID
+func nextStreamID(s *stream.Stream, raw string) (stream.ID, protocol.ErrorReply) {
+	if raw == "*" {
+		id, ok := s.NextID(uint64(time.Now().UnixMilli()))
+		if !ok {
+			return id, protocol.MakeErrReply("ERR The stream has exhausted the last possible ID, unable to add more items")
+		}
+		return id, nil
+	}
+	if ms, found := strings.CutSuffix(raw, "-*"); found {
+		msID, _, ok := stream.ParseID(ms)
+		if !ok || strings.Contains(ms, "-") {
+			return stream.ID{}, protocol.MakeErrReply(errInvalidStreamID)
+		}
+		id, ok := s.NextSeq(msID.Ms)
+		if !ok {
+			return id, protocol.MakeErrReply(errStreamIDTooSmall)
+		}
+		return id, nil
+	}
+	id, _, ok := stream.ParseID(raw)
+	if !ok {
+		return id, protocol.MakeErrReply(errInvalidStreamID)
+	}
+	if id == stream.MinID {
+		return id, protocol.MakeErrReply("ERR The ID specified in XADD must be greater than 0-0")
+	}
+	if !s.LastID().Less(id) {
+		return id, protocol.MakeErrReply(errStreamIDTooSmall)
+	}
+	return id, nil
+}
+
+// execXLen XLEN key
+func execXLen(db *DB, args [][]byte) redis.Reply {
+	s, errReply := db.getAsStream(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(s.Len()))
+}
+
+// execXTrim XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count]
+func execXTrim(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	option := strings.ToUpper(string(args[1]))
+	if option != "MAXLEN" && option != "MINID" {
+		return protocol.MakeSyntaxErrReply()
+	}
+	trim, n, errReply := parseStreamTrim(args[1:])
+	if errReply != nil {
+		return errReply
+	}
+	if 1+n != len(args) {
+		return protocol.MakeSyntaxErrReply()
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeIntReply(0)
+	}
+	removed := trim.apply(s)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine("XTRIM", key, "MAXLEN", "=", strconv.Itoa(s.Len())))
+	}
+	return protocol.MakeIntReply(int64(removed))
+}
+
+// execXSetID XSETID key last-id，设置消息流的最大 ID，不能小于现有的最后一条消息
+func execXSetID(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	id, _, ok := stream.ParseID(string(args[1]))
+	if !ok {
+		return protocol.MakeErrReply(errInvalidStreamID)
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	if last := s.Last(); last != nil && id.Less(last.ID) {
+		return protocol.MakeErrReply("ERR The ID specified in XSETID is smaller than the target stream top item")
+	}
+	s.SetLastID(id)
+	db.addAof(utils.ToCmdLine3("xsetid", args...))
+	return protocol.MakeOkReply()
+}
+
+// parseRangeID 解析范围的边界：- 和 + 表示最小和最大，( 开头表示不包含边界，省略序号时起点取 0、终点取最大值
+func parseRangeID(raw string, isStart bool) (stream.ID, protocol.ErrorReply) {
+	switch raw {
+	case "-":
+		return stream.MinID, nil
+	case "+":
+		return stream.MaxID, nil
+	}
+	exclusive := strings.HasPrefix(raw, "(")
+	id, hasSeq, ok := stream.ParseID(strings.TrimPrefix(raw, "("))
+	if !ok {
+		return id, protocol.MakeErrReply(errInvalidStreamID)
+	}
+	if !hasSeq && !isStart {
+		id.Seq = stream.MaxID.Seq
+	}
+	if !exclusive {
+		return id, nil
+	}
+	if isStart {
+		if id, ok = id.Next(); !ok {
+			return id, protocol.MakeErrReply("ERR invalid start ID for the interval")
+		}
+	} else if id, ok = id.Prev(); !ok {
+		return id, protocol.MakeErrReply("ERR invalid end ID for the interval")
+	}
+	return id, nil
+}
+
+func streamEntriesReply(entries []*stream.Entry) redis.Reply {
+	replies := make([]redis.Reply, len(entries))
+	for i, entry := range entries {
+		replies[i] = protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte(entry.ID.String())),
+			protocol.MakeMultiBulkReply(entry.Fields),
+		})
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
+
+// streamRange XRANGE key start end [COUNT count] / XREVRANGE key end start [COUNT count]
+func streamRange(db *DB, args [][]byte, reverse bool) redis.Reply {
+	key := string(args[0])
+	rawStart, rawEnd := string(args[1]), string(args[2])
+	if reverse {
+		rawStart, rawEnd = rawEnd, rawStart
+	}
+	start, errReply := parseRangeID(rawStart, true)
+	if errReply != nil {
+		return errReply
+	}
+	end, errReply := parseRangeID(rawEnd, false)
+	if errReply != nil {
+		return errReply
+	}
+	count := 0
+	if len(args) > 3 {
+		if len(args) != 5 || strings.ToUpper(string(args[3])) != "COUNT" {
+			return protocol.MakeSyntaxErrReply()
+		}
+		n, err := strconv.Atoi(string(args[4]))
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if n <= 0 {
+			return protocol.MakeEmptyMultiBulkReply()
+		}
+		count = n
+	}
+	s, errReply := db.getAsStream(key)
+	if errReply != nil {
+		return errReply
+	}
+	if s == nil {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+	return streamEntriesReply(s.Range(start, end, count, reverse))
+}
+
+// execXRange XRANGE key start end [COUNT count]
+func execXRange(db *DB, args [][]byte) redis.Reply {
+	return streamRange(db, args, false)
+}
+
+// execXRevRange XREVRANGE key end start [COUNT count]
+func execXRevRange(db *DB, args [][]byte) redis.Reply {
+	return streamRange(db, args, true)
+}
+
+// xreadArgs XREAD [COUNT count] [BLOCK milliseconds] STREAMS key [key ...] id [id ...]
+type xreadArgs struct {
+	count    int
+	block    bool
+	timeout  time.Duration
+	keys     []string
+	ids      [][]byte
+	idOffset int // 第一个 id 在参数中的下标
+}
+
+func parseXRead(args [][]byte) (*xreadArgs, protocol.ErrorReply) {
+	result := &xreadArgs{}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "COUNT":
+			if i+1 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			count, err := strconv.Atoi(string(args[i+1]))
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			result.count = max(count, 0)
+			i++
+		case "BLOCK":
+			if i+1 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
+			}
+			ms, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || ms > int64(time.Duration(1<<63-1)/time.Millisecond) {
+				return nil, protocol.MakeErrReply("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return nil, protocol.MakeErrReply("ERR timeout is negative")
+			}
+			result.block = true
+			result.timeout = time.Duration(ms) * time.Millisecond
+			i++
+		case "STREAMS":
+			rest := args[i+1:]
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, protocol.MakeErrReply("ERR Unbalanced 'xread' list of streams: " +
+					"for each stream key an ID or '$' must be specified.")
+			}
+			half := len(rest) / 2
+			for _, key := range rest[:half] {
+				result.keys = append(result.keys, string(key))
+			}
+			result.ids = rest[half:]
+			result.idOffset = i + 1 + half
+			return result, nil
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
+		}
+	}
+	return nil, protocol.MakeSyntaxErrReply()
+}
+
+// execXRead 只尝试读取一次，BLOCK 的等待由 execBlocking 完成
+func execXRead(db *DB, args [][]byte) redis.Reply {
+	xread, errReply := parseXRead(args)
+	if errReply != nil {
+		return errReply
+	}
+	var result []redis.Reply
+	for i, key := range xread.keys {
+		s, errReply := db.getAsStream(key)
+		if errReply != nil {
+			return errReply
+		}
+		rawID := string(xread.ids[i])
+		if s == nil || rawID == "$" {
+			continue
+		}
+		after, _, ok := stream.ParseID(rawID)
+		if !ok {
+			return protocol.MakeErrReply(errInvalidStreamID)
+		}
+		entries := s.After(after, xread.count)
+		if len(entries) == 0 {
+			continue
+		}
+		result = append(result, protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			streamEntriesReply(entries),
+		}))
+	}
+	if len(result) == 0 {
+		return protocol.MakeNullMultiBulkReply()
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+func prepareXRead(args [][]byte) ([]string, []string) {
+	xread, errReply := parseXRead(args)
+	if errReply != nil {
+		return nil, nil
+	}
+	return nil, xread.keys
+}
+
+// resolveLastIDs 把 XREAD BLOCK 中的 $ 替换为开始等待时的最大 ID，等待期间写入的消息都会被读到
+func (db *DB) resolveLastIDs(cmdLine CmdLine, xread *xreadArgs) CmdLine {
+	resolved := make(CmdLine, len(cmdLine))
+	copy(resolved, cmdLine)
+	db.execRead(nil, xread.keys, func(view *DB) {
+		for i, key := range xread.keys {
+			if string(xread.ids[i]) != "$" {
+				continue
+			}
+			lastID := stream.MinID
+			if s, _ := view.getAsStream(key); s != nil {
+				lastID = s.LastID()
+			}
+			// cmdLine[0] 是命令名
+			resolved[1+xread.idOffset+i] = []byte(lastID.String())
+		}
+	})
+	return resolved
+}
+
+func init() {
+	registerCommand("XAdd", execXAdd, writeFirstKey, rollbackFirstKey, -5, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
+	registerCommand("XLen", execXLen, readFirstKey, nil, 2, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("XRange", execXRange, readFirstKey, nil, -4, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("XRevRange", execXRevRange, readFirstKey, nil, -4, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("XRead", execXRead, prepareXRead, nil, -4, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagMovableKeys}, 0, 0, 0)
+	registerCommand("XTrim", execXTrim, writeFirstKey, rollbackFirstKey, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite}, 1, 1, 1)
+	registerCommand("XSetID", execXSetID, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
+}
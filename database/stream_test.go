@@ -0,0 +1,140 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/hdt3213/rdb/crc64jones"
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestStream(t *testing.T) {
+	db := makeTestDB()
+	assertReply(t, execTestCmd(db, "XADD", "s", "1-1", "a", "1"), "$3\r\n1-1\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "s", "1-*", "b", "2"), "$3\r\n1-2\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "s", "3", "c", "3", "d", "4"), "$3\r\n3-0\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "s", "2-0", "x", "y"),
+		"-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "other", "0-0", "x", "y"),
+		"-ERR The ID specified in XADD must be greater than 0-0\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "s", "abc", "x", "y"),
+		"-ERR Invalid stream ID specified as stream command argument\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "s", "4-0", "x"), "-ERR wrong number of arguments for 'xadd' command\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "missing", "NOMKSTREAM", "*", "x", "y"), "$-1\r\n")
+	assertReply(t, execTestCmd(db, "TYPE", "s"), "+stream\r\n")
+	assertReply(t, execTestCmd(db, "XLEN", "s"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "XLEN", "missing"), ":0\r\n")
+
+	assertReply(t, execTestCmd(db, "XRANGE", "s", "-", "+", "COUNT", "2"),
+		"*2\r\n*2\r\n$3\r\n1-1\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	// 省略序号的终点包含这一毫秒内所有的消息
+	assertReply(t, execTestCmd(db, "XRANGE", "s", "(1-1", "1"),
+		"*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	assertReply(t, execTestCmd(db, "XREVRANGE", "s", "+", "-", "COUNT", "1"),
+		"*1\r\n*2\r\n$3\r\n3-0\r\n*4\r\n$1\r\nc\r\n$1\r\n3\r\n$1\r\nd\r\n$1\r\n4\r\n")
+	assertReply(t, execTestCmd(db, "XRANGE", "s", "2", "1"), "*0\r\n")
+	assertReply(t, execTestCmd(db, "XRANGE", "missing", "-", "+"), "*0\r\n")
+
+	assertReply(t, execTestCmd(db, "XREAD", "COUNT", "1", "STREAMS", "s", "missing", "1-1", "0"),
+		"*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-2\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	assertReply(t, execTestCmd(db, "XREAD", "STREAMS", "s", "$"), "*-1\r\n")
+	assertReply(t, execTestCmd(db, "XREAD", "STREAMS", "s", "a", "0"),
+		"-ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified.\r\n")
+
+	// 裁剪之后最大 ID 不变，新消息的 ID 仍然要比它大
+	assertReply(t, execTestCmd(db, "XADD", "s", "MAXLEN", "2", "4-0", "e", "5"), "$3\r\n4-0\r\n")
+	assertReply(t, execTestCmd(db, "XLEN", "s"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "XTRIM", "s", "MAXLEN", "0"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "s", "3-1", "x", "y"),
+		"-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+	assertReply(t, execTestCmd(db, "XTRIM", "s", "MAXLEN", "=", "0", "LIMIT", "1"),
+		"-ERR syntax error, LIMIT cannot be used without the special ~ option\r\n")
+	assertReply(t, execTestCmd(db, "XSETID", "s", "5-0"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "XADD", "s", "5-*", "x", "y"), "$3\r\n5-1\r\n")
+	assertReply(t, execTestCmd(db, "XSETID", "s", "4-5"),
+		"-ERR The ID specified in XSETID is smaller than the target stream top item\r\n")
+
+	execTestCmd(db, "SET", "str", "1")
+	assertReply(t, execTestCmd(db, "XADD", "str", "*", "x", "y"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestStreamBlockingRead(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("XADD", "s", "1-0", "a", "1"))
+
+	// 已有数据时不等待
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("XREAD", "BLOCK", "0", "STREAMS", "s", "0")),
+		"*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\na\r\n$1\r\n1\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("XREAD", "BLOCK", "50", "STREAMS", "s", "$")), "*-1\r\n")
+
+	// $ 取开始等待时的最大 ID，一条消息唤醒所有等待的连接
+	r1 := execAsync(server, connection.NewFakeConn(), "XREAD", "BLOCK", "0", "STREAMS", "s", "$")
+	waitBlocked(t, server, 1)
+	r2 := execAsync(server, connection.NewFakeConn(), "XREAD", "BLOCK", "0", "STREAMS", "other", "s", "0", "$")
+	waitBlocked(t, server, 2)
+	server.Exec(conn, utils.ToCmdLine("XADD", "s", "2-0", "b", "2"))
+	expected := "*1\r\n*2\r\n$1\r\ns\r\n*1\r\n*2\r\n$3\r\n2-0\r\n*2\r\n$1\r\nb\r\n$1\r\n2\r\n"
+	assertReply(t, <-r1, expected)
+	assertReply(t, <-r2, expected)
+
+	// 等待之前不存在的 key
+	r3 := execAsync(server, connection.NewFakeConn(), "XREAD", "BLOCK", "0", "STREAMS", "new", "$")
+	waitBlocked(t, server, 1)
+	server.Exec(conn, utils.ToCmdLine("XADD", "new", "1-0", "c", "3"))
+	assertReply(t, <-r3, "*1\r\n*2\r\n$3\r\nnew\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$1\r\nc\r\n$1\r\n3\r\n")
+}
+
+func TestStreamPersistence(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		RDBFilename:    "dump.rdb",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("XADD", "s", "1-0", "a", "1", "b", "2"))
+	server.Exec(conn, utils.ToCmdLine("XADD", "s", "1-1", "a", "3", "b", "4"))
+	server.Exec(conn, utils.ToCmdLine("XADD", "s", "2-0", "z", "5", "a", "6"))
+	server.Exec(conn, utils.ToCmdLine("XADD", "s", "MAXLEN", "~", "2", "3-0", "c", "7"))
+	server.Exec(conn, utils.ToCmdLine("XADD", "empty", "MAXLEN", "0", "7-0", "x", "y"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SAVE")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("REWRITEAOF")), "+OK\r\n")
+	server.Close()
+
+	data, err := os.ReadFile(cfg.RDBFilePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	crc := crc64jones.New()
+	_, _ = crc.Write(data[:len(data)-8])
+	if !bytes.Equal(crc.Sum(nil), data[len(data)-8:]) {
+		t.Fatal("bad rdb checksum")
+	}
+
+	fromAof := NewStandaloneServerWithConfig(cfg)
+	defer fromAof.Close()
+	fromRdb := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:         cfg.Dir,
+		RDBFilename: "dump.rdb",
+		Databases:   16,
+	})
+	defer fromRdb.Close()
+	for _, loaded := range []*Server{fromAof, fromRdb} {
+		conn := connection.NewFakeConn()
+		assertReply(t, loaded.Exec(conn, utils.ToCmdLine("XRANGE", "s", "-", "+")),
+			"*2\r\n*2\r\n$3\r\n2-0\r\n*4\r\n$1\r\nz\r\n$1\r\n5\r\n$1\r\na\r\n$1\r\n6\r\n"+
+				"*2\r\n$3\r\n3-0\r\n*2\r\n$1\r\nc\r\n$1\r\n7\r\n")
+		assertReply(t, loaded.Exec(conn, utils.ToCmdLine("XLEN", "empty")), ":0\r\n")
+		assertReply(t, loaded.Exec(conn, utils.ToCmdLine("XADD", "empty", "7-0", "x", "y")),
+			"-ERR The ID specified in XADD is equal or smaller than the target stream top item\r\n")
+	}
+}
@@ -1,31 +1,104 @@
 package database
 
 import (
-	"fmt"
-	"log/slog"
+	"math"
 	"math/bits"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/zhangming/go-redis/aof"
 	"github.com/zhangming/go-redis/datastruct/bitmap"
+	"github.com/zhangming/go-redis/datastruct/sparse"
 	"github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/interfaces/redis"
 	"github.com/zhangming/go-redis/lib/utils"
 	"github.com/zhangming/go-redis/redis/protocol"
 )
 
+// 稀疏字符串
+// SETRANGE/SETBIT 写入后长度超过 sparseStringThreshold 并且一半以上是补齐的 0 时，
+// 值转换为 *sparse.String，只为非零的块分配内存；超过一半的块已经分配后再转换回 []byte。
+// 只有 STRLEN/APPEND/SETRANGE/GETRANGE 和位操作直接读写稀疏字符串，其他命令通过 getAsString 得到完整的字节
+
+const (
+	sparseStringThreshold  = 1 << 20
+	defaultProtoMaxBulkLen = 512 << 20
+
+	errStringTooLong = "ERR string exceeds maximum allowed size (proto-max-bulk-len)"
+)
+
+// protoMaxBulkLen 字符串的最大长度
+func (db *DB) protoMaxBulkLen() int64 {
+	if db.cfg == nil || db.cfg.ProtoMaxBulkLen <= 0 {
+		return defaultProtoMaxBulkLen
+	}
+	return int64(db.cfg.ProtoMaxBulkLen)
+}
+
 func (db *DB) getAsString(key string) ([]byte, protocol.ErrorReply) {
+	value, err := db.getStringValue(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	if str, ok := value.(*sparse.String); ok {
+		return str.Bytes(), nil
+	}
+	return value.([]byte), nil
+}
+
+// getStringValue 返回 []byte 或者 *sparse.String，key 不存在时返回 nil
+func (db *DB) getStringValue(key string) (interface{}, protocol.ErrorReply) {
 	entity, ok := db.GetEntity(key)
 	if !ok {
 		return nil, nil
 	}
-	bytes, ok := entity.Data.([]byte)
-	if !ok {
-		return nil, &protocol.WrongTypeErrReply{}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return val, nil
+	case *sparse.String:
+		return val, nil
+	}
+	return nil, &protocol.WrongTypeErrReply{}
+}
+
+func stringLen(value interface{}) int64 {
+	switch val := value.(type) {
+	case []byte:
+		return int64(len(val))
+	case *sparse.String:
+		return val.Len()
+	}
+	return 0
+}
+
+// writableString 返回用于写入的值，写入后的长度为 newLen
+func writableString(value interface{}, newLen int64) interface{} {
+	switch val := value.(type) {
+	case *sparse.String:
+		return val
+	case []byte:
+		if newLen > sparseStringThreshold && newLen > 2*int64(len(val)) {
+			return sparse.FromBytes(val)
+		}
+		if utils.IsSharedInt(val) {
+			// 共享的小整数不能原地修改
+			return slices.Clone(val)
+		}
+		return val
+	}
+	if newLen > sparseStringThreshold {
+		return sparse.New()
 	}
-	return bytes, nil
+	return []byte(nil)
+}
+
+// compactString 稀疏字符串中大部分块都已经分配时转换为 []byte
+func compactString(str *sparse.String) interface{} {
+	if str.Allocated()*2 > str.Len() {
+		return str.Bytes()
+	}
+	return str
 }
 
 // execGet returns string value bound to the given key
@@ -47,153 +120,152 @@ func execGet(db *DB, args [][]byte) redis.Reply {
 const (
 	upsertPolicy = iota // default
 	insertPolicy        // set nx
-	updatePolicy        // set ex
+	updatePolicy        // set xx
 )
 
-const unlimitedTTL int64 = 0
+// setOptions SET 和 GETEX 的可选参数
+type setOptions struct {
+	policy   int
+	expireAt time.Time // 零值表示没有设置 EX/PX/EXAT/PXAT
+	keepTTL  bool
+	persist  bool // GETEX PERSIST
+	get      bool // SET GET
+}
 
-// execGetEX Get the value of key and optionally set its expiration
-func execGetEX(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-	bytes, err := db.getAsString(key)
-	ttl := unlimitedTTL
+// parseExpireArg 解析 EX/PX/EXAT/PXAT 的参数，返回过期时间点
+func parseExpireArg(opt string, raw []byte, cmdName string) (time.Time, redis.Reply) {
+	n, err := strconv.ParseInt(string(raw), 10, 64)
 	if err != nil {
-		return err
+		return time.Time{}, protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-	if bytes == nil {
-		return &protocol.NullBulkReply{}
+	invalid := protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	if n <= 0 {
+		return time.Time{}, invalid
 	}
+	switch opt {
+	case "EX":
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		return time.Now().Add(time.Duration(n) * time.Second), nil
+	case "PX":
+		return time.Now().Add(time.Duration(n) * time.Millisecond), nil
+	case "EXAT":
+		if n > math.MaxInt64/1000 {
+			return time.Time{}, invalid
+		}
+		return time.Unix(n, 0), nil
+	}
+	return time.UnixMilli(n), nil
+}
 
-	for i := 1; i < len(args); i++ {
+// parseSetOptions 解析 [NX|XX] [GET] [EX|PX|EXAT|PXAT|KEEPTTL]，
+// getex 为 true 时解析 GETEX 的 [EX|PX|EXAT|PXAT|PERSIST]
+func parseSetOptions(args [][]byte, getex bool) (*setOptions, redis.Reply) {
+	cmdName := "set"
+	if getex {
+		cmdName = "getex"
+	}
+	opts := &setOptions{policy: upsertPolicy}
+	hasTTL := false // 已经出现了 EX/PX/EXAT/PXAT/KEEPTTL/PERSIST 中的一个
+	for i := 0; i < len(args); i++ {
 		arg := strings.ToUpper(string(args[i]))
-		if arg == "EX" { // ttl in seconds
-			if ttl != unlimitedTTL {
-				// ttl has been set
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 >= len(args) {
-				return &protocol.SyntaxErrReply{}
+		switch {
+		case arg == "NX" && !getex:
+			if opts.policy == updatePolicy {
+				return nil, protocol.MakeSyntaxErrReply()
 			}
-			ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-			if err != nil {
-				return &protocol.SyntaxErrReply{}
+			opts.policy = insertPolicy
+		case arg == "XX" && !getex:
+			if opts.policy == insertPolicy {
+				return nil, protocol.MakeSyntaxErrReply()
 			}
-			if ttlArg <= 0 {
-				return protocol.MakeErrReply("ERR invalid expire time in getex")
+			opts.policy = updatePolicy
+		case arg == "GET" && !getex:
+			opts.get = true
+		case arg == "KEEPTTL" && !getex, arg == "PERSIST" && getex:
+			if hasTTL {
+				return nil, protocol.MakeSyntaxErrReply()
 			}
-			ttl = ttlArg * 1000
-			i++ // skip next arg
-		} else if arg == "PX" { // ttl in milliseconds
-			if ttl != unlimitedTTL {
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 >= len(args) {
-				return &protocol.SyntaxErrReply{}
-			}
-			ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-			if err != nil {
-				return &protocol.SyntaxErrReply{}
+			hasTTL = true
+			opts.keepTTL = !getex
+			opts.persist = getex
+		case arg == "EX", arg == "PX", arg == "EXAT", arg == "PXAT":
+			if hasTTL || i+1 >= len(args) {
+				return nil, protocol.MakeSyntaxErrReply()
 			}
-			if ttlArg <= 0 {
-				return protocol.MakeErrReply("ERR invalid expire time in getex")
+			hasTTL = true
+			expireAt, errReply := parseExpireArg(arg, args[i+1], cmdName)
+			if errReply != nil {
+				return nil, errReply
 			}
-			ttl = ttlArg
+			opts.expireAt = expireAt
 			i++ // skip next arg
-		} else if arg == "PERSIST" {
-			if ttl != unlimitedTTL { // PERSIST Cannot be used with EX | PX
-				return &protocol.SyntaxErrReply{}
-			}
-			if i+1 > len(args) {
-				return &protocol.SyntaxErrReply{}
-			}
-			db.Persist(key)
+		default:
+			return nil, protocol.MakeSyntaxErrReply()
 		}
 	}
+	return opts, nil
+}
 
-	if len(args) > 1 {
-		if ttl != unlimitedTTL { // EX | PX
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.Expire(key, expireTime)
-			db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
-		} else { // PERSIST
-			db.Persist(key) // override ttl
-			// we convert to persist command to write aof
-			db.addAof(utils.ToCmdLine3("persist", args[0]))
-		}
+// makeSetAofCmd aof 中的过期时间统一记录为绝对时间 PXAT，重放时不受重放时刻的影响
+func makeSetAofCmd(key []byte, value []byte, expireAt time.Time) CmdLine {
+	cmdLine := utils.ToCmdLine3("set", key, value)
+	if !expireAt.IsZero() {
+		cmdLine = append(cmdLine, []byte("PXAT"), []byte(strconv.FormatInt(expireAt.UnixMilli(), 10)))
+	}
+	return cmdLine
+}
+
+// execGetEX Get the value of key and optionally set its expiration
+func execGetEX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	opts, errReply := parseSetOptions(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+	bytes, err := db.getAsString(key)
+	if err != nil {
+		return err
+	}
+	if bytes == nil {
+		return &protocol.NullBulkReply{}
+	}
+
+	if !opts.expireAt.IsZero() {
+		db.Expire(key, opts.expireAt)
+		db.addAof(makeSetAofCmd(args[0], bytes, opts.expireAt))
+	} else if opts.persist {
+		db.Persist(key)
+		// we convert to persist command to write aof
+		db.addAof(utils.ToCmdLine3("persist", args[0]))
 	}
 	return protocol.MakeBulkReply(bytes)
 }
 
 // execSet sets string value and time to live to the given key
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT ms-timestamp|KEEPTTL]
 func execSet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
-	for i, arg := range args {
-		fmt.Printf("args[%d] = %s\n", i, string(arg))
+	opts, errReply := parseSetOptions(args[2:], false)
+	if errReply != nil {
+		return errReply
 	}
-	policy := upsertPolicy
-	ttl := unlimitedTTL
-
-	// parse options
-	if len(args) > 2 {
-		for i := 2; i < len(args); i++ {
-			arg := strings.ToUpper(string(args[i]))
-			if arg == "NX" { // insert
-				if policy == updatePolicy {
-					return &protocol.SyntaxErrReply{}
-				}
-				policy = insertPolicy
-			} else if arg == "XX" { // update policy
-				if policy == insertPolicy {
-					return &protocol.SyntaxErrReply{}
-				}
-				policy = updatePolicy
-			} else if arg == "EX" { // ttl in seconds
-				if ttl != unlimitedTTL {
-					// ttl has been set
-					return &protocol.SyntaxErrReply{}
-				}
-				if i+1 >= len(args) {
-					return &protocol.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				if ttlArg <= 0 {
-					return protocol.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg * 1000
-				i++ // skip next arg
-			} else if arg == "PX" { // ttl in milliseconds
-				if ttl != unlimitedTTL {
-					return &protocol.SyntaxErrReply{}
-				}
-				if i+1 >= len(args) {
-					return &protocol.SyntaxErrReply{}
-				}
-				ttlArg, err := strconv.ParseInt(string(args[i+1]), 10, 64)
-				if err != nil {
-					return &protocol.SyntaxErrReply{}
-				}
-				if ttlArg <= 0 {
-					return protocol.MakeErrReply("ERR invalid expire time in set")
-				}
-				ttl = ttlArg
-				i++ // skip next arg
-			} else {
-				return &protocol.SyntaxErrReply{}
-			}
+	var old []byte
+	if opts.get {
+		var err protocol.ErrorReply
+		old, err = db.getAsString(key)
+		if err != nil {
+			return err
 		}
 	}
 
 	entity := &database.DataEntity{
-		Data: value,
+		Data: utils.InternInt(value),
 	}
-
 	var result int
-	slog.Info("set command")
-	switch policy {
+	switch opts.policy {
 	case upsertPolicy:
 		db.PutEntity(key, entity)
 		result = 1
@@ -202,23 +274,24 @@ func execSet(db *DB, args [][]byte) redis.Reply {
 	case updatePolicy:
 		result = db.PutIfExists(key, entity)
 	}
-	slog.Info("命令result：", result)
 	if result > 0 {
-		if ttl != unlimitedTTL {
-			expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-			db.Expire(key, expireTime)
-			db.addAof(CmdLine{
-				[]byte("SET"),
-				args[0],
-				args[1],
-			})
-			db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
+		if !opts.expireAt.IsZero() {
+			db.Expire(key, opts.expireAt)
+			db.addAof(makeSetAofCmd(args[0], value, opts.expireAt))
+		} else if opts.keepTTL {
+			db.addAof(utils.ToCmdLine3("set", args[0], value, []byte("KEEPTTL")))
 		} else {
 			db.Persist(key) // override ttl
-			db.addAof(utils.ToCmdLine3("set", args...))
+			db.addAof(makeSetAofCmd(args[0], value, time.Time{}))
 		}
 	}
 
+	if opts.get {
+		if old == nil {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeBulkReply(old)
+	}
 	if result > 0 {
 		return &protocol.OkReply{}
 	}
@@ -230,63 +303,38 @@ func execSetNX(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	value := args[1]
 	entity := &database.DataEntity{
-		Data: value,
+		Data: utils.InternInt(value),
 	}
 	result := db.PutIfAbsent(key, entity)
-	db.addAof(utils.ToCmdLine3("setnx", args...))
+	if result > 0 {
+		db.addAof(utils.ToCmdLine3("setnx", args...))
+	}
 	return protocol.MakeIntReply(int64(result))
 }
 
-// execSetEX sets string and its ttl
-func execSetEX(db *DB, args [][]byte) redis.Reply {
+// setWithTTL SETEX/PSETEX
+func setWithTTL(db *DB, args [][]byte, unit string, cmdName string) redis.Reply {
 	key := string(args[0])
 	value := args[2]
-
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return &protocol.SyntaxErrReply{}
-	}
-	if ttlArg <= 0 {
-		return protocol.MakeErrReply("ERR invalid expire time in setex")
-	}
-	ttl := ttlArg * 1000
-
-	entity := &database.DataEntity{
-		Data: value,
+	expireAt, errReply := parseExpireArg(unit, args[1], cmdName)
+	if errReply != nil {
+		return errReply
 	}
-
-	db.PutEntity(key, entity)
-	expireTime := time.Now().Add(time.Duration(ttl) * time.Millisecond)
-	db.Expire(key, expireTime)
-	db.addAof(utils.ToCmdLine3("setex", args...))
-	db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
+	db.SetWithTTL(key, &database.DataEntity{
+		Data: utils.InternInt(value),
+	}, &expireAt)
+	db.addAof(makeSetAofCmd(args[0], value, expireAt))
 	return &protocol.OkReply{}
 }
 
+// execSetEX sets string and its ttl
+func execSetEX(db *DB, args [][]byte) redis.Reply {
+	return setWithTTL(db, args, "EX", "setex")
+}
+
 // execPSetEX set a key's time to live in  milliseconds
 func execPSetEX(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-	value := args[2]
-
-	ttlArg, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return &protocol.SyntaxErrReply{}
-	}
-	if ttlArg <= 0 {
-		return protocol.MakeErrReply("ERR invalid expire time in setex")
-	}
-
-	entity := &database.DataEntity{
-		Data: value,
-	}
-
-	db.PutEntity(key, entity)
-	expireTime := time.Now().Add(time.Duration(ttlArg) * time.Millisecond)
-	db.Expire(key, expireTime)
-	db.addAof(utils.ToCmdLine3("setex", args...))
-	db.addAof(aof.MakeExpireCmd(key, expireTime).Args)
-
-	return &protocol.OkReply{}
+	return setWithTTL(db, args, "PX", "psetex")
 }
 
 func prepareMSet(args [][]byte) ([]string, []string) {
@@ -306,7 +354,7 @@ func undoMSet(db *DB, args [][]byte) []CmdLine {
 // execMSet sets multi key-value in database
 func execMSet(db *DB, args [][]byte) redis.Reply {
 	if len(args)%2 != 0 {
-		return protocol.MakeSyntaxErrReply()
+		return protocol.MakeErrReply("ERR syntax error")
 	}
 
 	size := len(args) / 2
@@ -317,9 +365,10 @@ func execMSet(db *DB, args [][]byte) redis.Reply {
 		values[i] = args[2*i+1]
 	}
 
+	// 与 SET 相同，覆盖的 key 原来的过期时间被丢弃
 	for i, key := range keys {
 		value := values[i]
-		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.SetWithTTL(key, &database.DataEntity{Data: utils.InternInt(value)}, nil)
 	}
 	db.addAof(utils.ToCmdLine3("mset", args...))
 	return &protocol.OkReply{}
@@ -362,7 +411,7 @@ func execMGet(db *DB, args [][]byte) redis.Reply {
 func execMSetNX(db *DB, args [][]byte) redis.Reply {
 	// parse args
 	if len(args)%2 != 0 {
-		return protocol.MakeSyntaxErrReply()
+		return protocol.MakeErrReply("ERR syntax error")
 	}
 	size := len(args) / 2
 	values := make([][]byte, size)
@@ -379,9 +428,10 @@ func execMSetNX(db *DB, args [][]byte) redis.Reply {
 		}
 	}
 
+	// 与 SET 相同，覆盖的 key 原来的过期时间被丢弃
 	for i, key := range keys {
 		value := values[i]
-		db.PutEntity(key, &database.DataEntity{Data: value})
+		db.SetWithTTL(key, &database.DataEntity{Data: utils.InternInt(value)}, nil)
 	}
 	db.addAof(utils.ToCmdLine3("msetnx", args...))
 	return protocol.MakeIntReply(1)
@@ -397,8 +447,7 @@ func execGetSet(db *DB, args [][]byte) redis.Reply {
 		return err
 	}
 
-	db.PutEntity(key, &database.DataEntity{Data: value})
-	db.Persist(key) // override ttl
+	db.SetWithTTL(key, &database.DataEntity{Data: utils.InternInt(value)}, nil) // override ttl
 	db.addAof(utils.ToCmdLine3("set", args...))
 	if old == nil {
 		return new(protocol.NullBulkReply)
@@ -424,62 +473,43 @@ func execGetDel(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeBulkReply(old)
 }
 
-// execIncr increments the integer value of a key by one
-func execIncr(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-
-	bytes, err := db.getAsString(key)
-	if err != nil {
-		return err
+// incrBy 把 key 的整数值加上 delta，key 不存在时视为 0，结果溢出 int64 时返回错误
+func incrBy(db *DB, key string, delta int64, aofCmd CmdLine) redis.Reply {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
 	}
+	var val int64
 	if bytes != nil {
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
+		var err error
+		val, err = strconv.ParseInt(string(bytes), 10, 64)
 		if err != nil {
 			return protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
-		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val+1, 10)),
-		})
-		db.addAof(utils.ToCmdLine3("incr", args...))
-		return protocol.MakeIntReply(val + 1)
 	}
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return protocol.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
 	db.PutEntity(key, &database.DataEntity{
-		Data: []byte("1"),
+		Data: utils.FormatInt(val),
 	})
-	db.addAof(utils.ToCmdLine3("incr", args...))
-	return protocol.MakeIntReply(1)
+	db.addAof(aofCmd)
+	return protocol.MakeIntReply(val)
+}
+
+// execIncr increments the integer value of a key by one
+func execIncr(db *DB, args [][]byte) redis.Reply {
+	return incrBy(db, string(args[0]), 1, utils.ToCmdLine3("incr", args...))
 }
 
 // execIncrBy increments the integer value of a key by given value
 func execIncrBy(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-	rawDelta := string(args[1])
-	delta, err := strconv.ParseInt(rawDelta, 10, 64)
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-
-	bytes, errReply := db.getAsString(key)
-	if errReply != nil {
-		return errReply
-	}
-	if bytes != nil {
-		// existed value
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val+delta, 10)),
-		})
-		db.addAof(utils.ToCmdLine3("incrby", args...))
-		return protocol.MakeIntReply(val + delta)
-	}
-	db.PutEntity(key, &database.DataEntity{
-		Data: args[1],
-	})
-	db.addAof(utils.ToCmdLine3("incrby", args...))
-	return protocol.MakeIntReply(delta)
+	return incrBy(db, string(args[0]), delta, utils.ToCmdLine3("incrby", args...))
 }
 
 // execIncrByFloat increments the float value of a key by given value
@@ -487,7 +517,7 @@ func execIncrByFloat(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	rawDelta := string(args[1])
 	delta, err := strconv.ParseFloat(rawDelta, 64)
-	if err != nil {
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
 		return protocol.MakeErrReply("ERR value is not a valid float")
 	}
 
@@ -495,110 +525,76 @@ func execIncrByFloat(db *DB, args [][]byte) redis.Reply {
 	if errReply != nil {
 		return errReply
 	}
+	var val float64
 	if bytes != nil {
-		val, err := strconv.ParseFloat(string(bytes), 64)
-		if err != nil {
+		val, err = strconv.ParseFloat(string(bytes), 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
 			return protocol.MakeErrReply("ERR value is not a valid float")
 		}
-		resultBytes := []byte(strconv.FormatFloat(val+delta, 'f', -1, 64))
-		db.PutEntity(key, &database.DataEntity{
-			Data: resultBytes,
-		})
-		db.addAof(utils.ToCmdLine3("incrbyfloat", args...))
-		return protocol.MakeBulkReply(resultBytes)
+	}
+	result := val + delta
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return protocol.MakeErrReply("ERR increment would produce NaN or Infinity")
 	}
 	db.PutEntity(key, &database.DataEntity{
-		Data: args[1],
+		Data: []byte(strconv.FormatFloat(result, 'f', -1, 64)),
 	})
 	db.addAof(utils.ToCmdLine3("incrbyfloat", args...))
-	return protocol.MakeBulkReply(args[1])
+	return protocol.MakeDoubleReply(result)
 }
 
 // execDecr decrements the integer value of a key by one
 func execDecr(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-
-	bytes, errReply := db.getAsString(key)
-	if errReply != nil {
-		return errReply
-	}
-	if bytes != nil {
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val-1, 10)),
-		})
-		db.addAof(utils.ToCmdLine3("decr", args...))
-		return protocol.MakeIntReply(val - 1)
-	}
-	entity := &database.DataEntity{
-		Data: []byte("-1"),
-	}
-	db.PutEntity(key, entity)
-	db.addAof(utils.ToCmdLine3("decr", args...))
-	return protocol.MakeIntReply(-1)
+	return incrBy(db, string(args[0]), -1, utils.ToCmdLine3("decr", args...))
 }
 
 // execDecrBy decrements the integer value of a key by onedecrement
 func execDecrBy(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-	rawDelta := string(args[1])
-	delta, err := strconv.ParseInt(rawDelta, 10, 64)
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
 	if err != nil {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
-
-	bytes, errReply := db.getAsString(key)
-	if errReply != nil {
-		return errReply
-	}
-	if bytes != nil {
-		val, err := strconv.ParseInt(string(bytes), 10, 64)
-		if err != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		db.PutEntity(key, &database.DataEntity{
-			Data: []byte(strconv.FormatInt(val-delta, 10)),
-		})
-		db.addAof(utils.ToCmdLine3("decrby", args...))
-		return protocol.MakeIntReply(val - delta)
+	if delta == math.MinInt64 {
+		return protocol.MakeErrReply("ERR decrement would overflow")
 	}
-	valueStr := strconv.FormatInt(-delta, 10)
-	db.PutEntity(key, &database.DataEntity{
-		Data: []byte(valueStr),
-	})
-	db.addAof(utils.ToCmdLine3("decrby", args...))
-	return protocol.MakeIntReply(-delta)
+	return incrBy(db, string(args[0]), -delta, utils.ToCmdLine3("decrby", args...))
 }
 
 // execStrLen returns len of string value bound to the given key
 func execStrLen(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	bytes, err := db.getAsString(key)
+	value, err := db.getStringValue(key)
 	if err != nil {
 		return err
 	}
-	if bytes == nil {
-		return protocol.MakeIntReply(0)
-	}
-	return protocol.MakeIntReply(int64(len(bytes)))
+	return protocol.MakeIntReply(stringLen(value))
 }
 
 // execAppend sets string value to the given key
 func execAppend(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
-	bytes, err := db.getAsString(key)
+	value, err := db.getStringValue(key)
 	if err != nil {
 		return err
 	}
-	bytes = append(bytes, args[1]...)
-	db.PutEntity(key, &database.DataEntity{
-		Data: bytes,
-	})
+	newLen := stringLen(value) + int64(len(args[1]))
+	if newLen > db.protoMaxBulkLen() {
+		return protocol.MakeErrReply(errStringTooLong)
+	}
+	if str, ok := value.(*sparse.String); ok {
+		str.WriteAt(str.Len(), args[1])
+		db.PutEntity(key, &database.DataEntity{
+			Data: compactString(str),
+		})
+	} else {
+		bytes, _ := value.([]byte)
+		bytes = append(bytes, args[1]...)
+		db.PutEntity(key, &database.DataEntity{
+			Data: bytes,
+		})
+	}
 	db.addAof(utils.ToCmdLine3("append", args...))
-	return protocol.MakeIntReply(int64(len(bytes)))
+	return protocol.MakeIntReply(newLen)
 }
 
 // execSetRange overwrites part of the string stored at key, starting at the specified offset.
@@ -607,35 +603,59 @@ func execSetRange(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	offset, errNative := strconv.ParseInt(string(args[1]), 10, 64)
 	if errNative != nil {
-		return protocol.MakeErrReply(errNative.Error())
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return protocol.MakeErrReply("ERR offset is out of range")
 	}
 	value := args[2]
-	bytes, err := db.getAsString(key)
+	current, err := db.getStringValue(key)
 	if err != nil {
 		return err
 	}
-	bytesLen := int64(len(bytes))
-	if bytesLen < offset {
-		diff := offset - bytesLen
-		diffArray := make([]byte, diff)
-		bytes = append(bytes, diffArray...)
-		bytesLen = int64(len(bytes))
-	}
-	for i := 0; i < len(value); i++ {
-		idx := offset + int64(i)
-		if idx >= bytesLen {
-			bytes = append(bytes, value[i])
-		} else {
-			bytes[idx] = value[i]
+	// 写入空字符串不修改数据，也不会创建 key 或者补齐 0
+	if len(value) == 0 {
+		return protocol.MakeIntReply(stringLen(current))
+	}
+	// offset 加上 value 的长度可能溢出，比较前先从上限中减去
+	if offset > db.protoMaxBulkLen()-int64(len(value)) {
+		return protocol.MakeErrReply(errStringTooLong)
+	}
+	newLen := max(stringLen(current), offset+int64(len(value)))
+	var result interface{}
+	switch str := writableString(current, newLen).(type) {
+	case *sparse.String:
+		str.WriteAt(offset, value)
+		str.Grow(newLen)
+		result = compactString(str)
+	case []byte:
+		bytes := str
+		bytesLen := int64(len(bytes))
+		if bytesLen < offset {
+			diff := offset - bytesLen
+			diffArray := make([]byte, diff)
+			bytes = append(bytes, diffArray...)
+			bytesLen = int64(len(bytes))
 		}
+		for i := 0; i < len(value); i++ {
+			idx := offset + int64(i)
+			if idx >= bytesLen {
+				bytes = append(bytes, value[i])
+			} else {
+				bytes[idx] = value[i]
+			}
+		}
+		result = bytes
 	}
 	db.PutEntity(key, &database.DataEntity{
-		Data: bytes,
+		Data: result,
 	})
 	db.addAof(utils.ToCmdLine3("setRange", args...))
-	return protocol.MakeIntReply(int64(len(bytes)))
+	return protocol.MakeIntReply(newLen)
 }
 
+// execGetRange returns the substring between start and end (both inclusive),
+// negative offsets count from the end and out of range offsets are clamped to the string
 func execGetRange(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	startIdx, err2 := strconv.ParseInt(string(args[1]), 10, 64)
@@ -647,25 +667,43 @@ func execGetRange(db *DB, args [][]byte) redis.Reply {
 		return protocol.MakeErrReply("ERR value is not an integer or out of range")
 	}
 
-	bs, err := db.getAsString(key)
+	value, err := db.getStringValue(key)
 	if err != nil {
 		return err
 	}
-	if bs == nil {
-		return protocol.MakeNullBulkReply()
+	beg, end, ok := stringRange(startIdx, endIdx, stringLen(value))
+	if !ok {
+		return protocol.MakeBulkReply([]byte{})
+	}
+	if str, ok := value.(*sparse.String); ok {
+		return protocol.MakeBulkReply(str.ReadAt(beg, end))
+	}
+	return protocol.MakeBulkReply(value.([]byte)[beg:end])
+}
+
+// stringRange 把 GETRANGE 的 [start, end] 转换为 [beg, end)，与 redis 相同地截断到字符串的范围内，
+// 范围为空时返回 false
+func stringRange(start, end, size int64) (int64, int64, bool) {
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, false
+	}
+	if start < 0 {
+		start = max(size+start, 0)
 	}
-	bytesLen := int64(len(bs))
-	beg, end := utils.ConvertRange(startIdx, endIdx, bytesLen)
-	if beg < 0 {
-		return protocol.MakeNullBulkReply()
+	if end < 0 {
+		end = max(size+end, 0)
 	}
-	return protocol.MakeBulkReply(bs[beg:end])
+	end = min(end, size-1)
+	if size == 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end + 1, true
 }
 
 func execSetBit(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
+	if err != nil || offset < 0 || offset >= db.protoMaxBulkLen()*8 {
 		return protocol.MakeErrReply("ERR bit offset is not an integer or out of range")
 	}
 	valStr := string(args[2])
@@ -677,14 +715,24 @@ func execSetBit(db *DB, args [][]byte) redis.Reply {
 	} else {
 		return protocol.MakeErrReply("ERR bit is not an integer or out of range")
 	}
-	bs, errReply := db.getAsString(key)
+	current, errReply := db.getStringValue(key)
 	if errReply != nil {
 		return errReply
 	}
-	bm := bitmap.FromBytes(bs)
-	former := bm.GetBit(offset)
-	bm.SetBit(offset, v)
-	db.PutEntity(key, &database.DataEntity{Data: bm.ToBytes()})
+	var former byte
+	var result interface{}
+	switch str := writableString(current, max(stringLen(current), offset/8+1)).(type) {
+	case *sparse.String:
+		former = str.GetBit(offset)
+		str.SetBit(offset, v)
+		result = compactString(str)
+	case []byte:
+		bm := bitmap.FromBytes(str)
+		former = bm.GetBit(offset)
+		bm.SetBit(offset, v)
+		result = bm.ToBytes()
+	}
+	db.PutEntity(key, &database.DataEntity{Data: result})
 	db.addAof(utils.ToCmdLine3("setBit", args...))
 	return protocol.MakeIntReply(int64(former))
 }
@@ -692,156 +740,295 @@ func execSetBit(db *DB, args [][]byte) redis.Reply {
 func execGetBit(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
+	if err != nil || offset < 0 {
 		return protocol.MakeErrReply("ERR bit offset is not an integer or out of range")
 	}
-	bs, errReply := db.getAsString(key)
+	value, errReply := db.getStringValue(key)
 	if errReply != nil {
 		return errReply
 	}
-	if bs == nil {
-		return protocol.MakeIntReply(0)
+	switch str := value.(type) {
+	case *sparse.String:
+		return protocol.MakeIntReply(int64(str.GetBit(offset)))
+	case []byte:
+		bm := bitmap.FromBytes(str)
+		return protocol.MakeIntReply(int64(bm.GetBit(offset)))
 	}
-	bm := bitmap.FromBytes(bs)
-	return protocol.MakeIntReply(int64(bm.GetBit(offset)))
+	return protocol.MakeIntReply(0)
 }
 
-func execBitCount(db *DB, args [][]byte) redis.Reply {
-	key := string(args[0])
-	bs, err := db.getAsString(key)
-	if err != nil {
-		return err
-	}
-	if bs == nil {
-		return protocol.MakeIntReply(0)
-	}
+// parseBitRange 按照 redis 的规则解析 BITCOUNT/BITPOS 的 start end [BYTE|BIT]，size 是字符串的字节数。
+// 负数从末尾开始计算，超出范围的下标截断到字符串内，返回按位计算的区间 [beg, end)，区间为空时 beg >= end
+func parseBitRange(args [][]byte, size int64, endGiven bool) (beg int64, end int64, errReply redis.Reply) {
 	byteMode := true
-	if len(args) > 3 {
-		mode := strings.ToLower(string(args[3]))
-		if mode == "bit" {
+	if len(args) > 2 {
+		switch strings.ToLower(string(args[2])) {
+		case "byte":
+		case "bit":
 			byteMode = false
-		} else if mode == "byte" {
-			byteMode = true
-		} else {
-			return protocol.MakeErrReply("ERR syntax error")
+		default:
+			return 0, 0, protocol.MakeErrReply("ERR syntax error")
 		}
 	}
-	var size int64
-	bm := bitmap.FromBytes(bs)
-	if byteMode {
-		size = int64(len(*bm))
-	} else {
-		size = int64(bm.BitSize())
+	total := size
+	if !byteMode {
+		total = size * 8
 	}
-	var beg, end int
-	if len(args) > 1 {
-		var err2 error
-		var startIdx, endIdx int64
-		startIdx, err2 = strconv.ParseInt(string(args[1]), 10, 64)
-		if err2 != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		endIdx, err2 = strconv.ParseInt(string(args[2]), 10, 64)
-		if err2 != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
-		}
-		beg, end = utils.ConvertRange(startIdx, endIdx, size)
-		if beg < 0 {
-			return protocol.MakeIntReply(0)
+	start, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return 0, 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop := total - 1
+	if endGiven {
+		stop, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return 0, 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
 		}
 	}
-	var count int64
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if stop < 0 {
+		stop = max(total+stop, 0)
+	}
+	stop = min(stop, total-1)
+	if start > stop {
+		return 0, 0, nil
+	}
 	if byteMode {
-		bm.ForEachByte(beg, end, func(offset int64, val byte) bool {
+		return start * 8, (stop + 1) * 8, nil
+	}
+	return start, stop + 1, nil
+}
+
+// countBits 统计 [beg, end) 位中 1 的个数
+func countBits(value interface{}, beg int64, end int64) int64 {
+	if str, ok := value.(*sparse.String); ok {
+		return str.CountBits(beg, end)
+	}
+	var count int64
+	bm := bitmap.FromBytes(value.([]byte))
+	if beg%8 == 0 && end%8 == 0 {
+		bm.ForEachByte(int(beg/8), int(end/8), func(offset int64, val byte) bool {
 			count += int64(bits.OnesCount8(val))
 			return true
 		})
-	} else {
-		bm.ForEachBit(int64(beg), int64(end), func(offset int64, val byte) bool {
-			if val > 0 {
-				count++
-			}
-			return true
-		})
+		return count
 	}
-	return protocol.MakeIntReply(count)
+	bm.ForEachBit(beg, end, func(offset int64, val byte) bool {
+		count += int64(val)
+		return true
+	})
+	return count
 }
 
-func execBitPos(db *DB, args [][]byte) redis.Reply {
+func execBitCount(db *DB, args [][]byte) redis.Reply {
+	if len(args) == 2 || len(args) > 4 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
 	key := string(args[0])
-	bs, err := db.getAsString(key)
+	value, err := db.getStringValue(key)
 	if err != nil {
 		return err
 	}
-	if bs == nil {
-		return protocol.MakeIntReply(-1)
+	if value == nil {
+		return protocol.MakeIntReply(0)
+	}
+	size := stringLen(value)
+	beg, end := int64(0), size*8
+	if len(args) > 1 {
+		var errReply redis.Reply
+		beg, end, errReply = parseBitRange(args[1:], size, true)
+		if errReply != nil {
+			return errReply
+		}
+		if beg >= end {
+			return protocol.MakeIntReply(0)
+		}
 	}
-	valStr := string(args[1])
+	return protocol.MakeIntReply(countBits(value, beg, end))
+}
+
+// bitPos 返回 [beg, end) 位中第一个等于 v 的位置，没有时返回 -1
+func bitPos(value interface{}, v byte, beg int64, end int64) int64 {
+	if str, ok := value.(*sparse.String); ok {
+		return str.BitPos(v, beg, end)
+	}
+	var offset = int64(-1)
+	bm := bitmap.FromBytes(value.([]byte))
+	bm.ForEachBit(beg, end, func(o int64, val byte) bool {
+		if val == v {
+			offset = o
+			return false
+		}
+		return true
+	})
+	return offset
+}
+
+func execBitPos(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 5 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	key := string(args[0])
 	var v byte
-	if valStr == "1" {
+	switch string(args[1]) {
+	case "1":
 		v = 1
-	} else if valStr == "0" {
+	case "0":
 		v = 0
-	} else {
-		return protocol.MakeErrReply("ERR bit is not an integer or out of range")
+	default:
+		return protocol.MakeErrReply("ERR The bit argument must be 1 or 0.")
 	}
-	byteMode := true
-	if len(args) > 4 {
-		mode := strings.ToLower(string(args[4]))
-		if mode == "bit" {
-			byteMode = false
-		} else if mode == "byte" {
-			byteMode = true
-		} else {
-			return protocol.MakeErrReply("ERR syntax error")
-		}
+	value, err := db.getStringValue(key)
+	if err != nil {
+		return err
 	}
-	var size int64
-	bm := bitmap.FromBytes(bs)
-	if byteMode {
-		size = int64(len(*bm))
-	} else {
-		size = int64(bm.BitSize())
+	if value == nil {
+		// 不存在的 key 视为空字符串，右侧补齐的都是 0
+		if v == 1 {
+			return protocol.MakeIntReply(-1)
+		}
+		return protocol.MakeIntReply(0)
 	}
-	var beg, end int
+	size := stringLen(value)
+	endGiven := len(args) > 3
+	beg, end := int64(0), size*8
 	if len(args) > 2 {
-		var err2 error
-		var startIdx, endIdx int64
-		startIdx, err2 = strconv.ParseInt(string(args[2]), 10, 64)
-		if err2 != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		var errReply redis.Reply
+		beg, end, errReply = parseBitRange(args[2:], size, endGiven)
+		if errReply != nil {
+			return errReply
 		}
-		endIdx, err2 = strconv.ParseInt(string(args[3]), 10, 64)
-		if err2 != nil {
-			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		if beg >= end {
+			return protocol.MakeIntReply(-1)
 		}
-		beg, end = utils.ConvertRange(startIdx, endIdx, size)
-		if beg < 0 {
-			return protocol.MakeIntReply(0)
+	}
+	pos := bitPos(value, v, beg, end)
+	if pos < 0 && v == 0 && !endGiven {
+		// 没有指定 end 时查找 0 视为字符串右侧补齐了 0
+		return protocol.MakeIntReply(size * 8)
+	}
+	return protocol.MakeIntReply(pos)
+}
+
+// prepareBitOp BITOP operation destkey key [key ...]
+func prepareBitOp(args [][]byte) ([]string, []string) {
+	readKeys := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		readKeys = append(readKeys, string(arg))
+	}
+	return []string{string(args[1])}, readKeys
+}
+
+func undoBitOp(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// execBitOp 对多个字符串按位运算，结果保存到 destkey，返回结果的长度。结果为空字符串时删除 destkey
+func execBitOp(db *DB, args [][]byte) redis.Reply {
+	op := strings.ToLower(string(args[0]))
+	if op != "and" && op != "or" && op != "xor" && op != "not" {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	if op == "not" && len(args) != 3 {
+		return protocol.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+	}
+	dest := string(args[1])
+	values := make([]interface{}, 0, len(args)-2)
+	var size int64
+	for _, arg := range args[2:] {
+		value, errReply := db.getStringValue(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if value == nil {
+			value = []byte{}
 		}
+		values = append(values, value)
+		size = max(size, stringLen(value))
 	}
-	if byteMode {
-		beg *= 8
-		end *= 8
+	if size == 0 {
+		if db.Removes(dest) > 0 {
+			db.addAof(utils.ToCmdLine3("bitop", args...))
+		}
+		return protocol.MakeIntReply(0)
 	}
-	var offset = int64(-1)
-	bm.ForEachBit(int64(beg), int64(end), func(o int64, val byte) bool {
-		if val == v {
-			offset = o
-			return false
+
+	var result interface{}
+	if size > sparseStringThreshold && op != "not" {
+		// 较大的结果按块计算，全零的块不分配内存
+		srcs := make([]*sparse.String, len(values))
+		for i, value := range values {
+			if str, ok := value.(*sparse.String); ok {
+				srcs[i] = str
+			} else {
+				srcs[i] = sparse.FromBytes(value.([]byte))
+			}
 		}
-		return true
-	})
-	return protocol.MakeIntReply(offset)
+		result = compactString(sparse.BitOp(op, srcs))
+	} else {
+		res := make([]byte, size)
+		for i, value := range values {
+			var src []byte
+			if str, ok := value.(*sparse.String); ok {
+				src = str.Bytes()
+			} else {
+				src = value.([]byte)
+			}
+			switch {
+			case op == "not":
+				for j := range res {
+					res[j] = ^src[j]
+				}
+			case i == 0:
+				copy(res, src)
+			case op == "and":
+				for j := range res {
+					if j < len(src) {
+						res[j] &= src[j]
+					} else {
+						res[j] = 0
+					}
+				}
+			case op == "or":
+				for j, v := range src {
+					res[j] |= v
+				}
+			case op == "xor":
+				for j, v := range src {
+					res[j] ^= v
+				}
+			}
+		}
+		result = res
+	}
+	db.SetWithTTL(dest, &database.DataEntity{Data: result}, nil)
+	db.addAof(utils.ToCmdLine3("bitop", args...))
+	return protocol.MakeIntReply(size)
 }
 
+// randomKeyAttempts 抽到已经过期的 key 时重新抽取的次数，
+// 与 redis 相同，几乎所有 key 都已经过期时可能返回 nil
+const randomKeyAttempts = 100
+
 // GetRandomKey Randomly return (do not delete) a key from the godis
 func getRandomKey(db *DB, args [][]byte) redis.Reply {
-	k := db.data.RandomKeys(1)
-	if len(k) == 0 {
-		return &protocol.NullBulkReply{}
+	for i := 0; i < randomKeyAttempts; i++ {
+		k := db.data.RandomKeys(1)
+		if len(k) == 0 {
+			return &protocol.NullBulkReply{}
+		}
+		// RANDOMKEY 不持有 key 的锁，检查过期时单独加读锁，已经过期的 key 在命令结束后由 expireReads 删除
+		keys := k[:1]
+		db.RWLocks(nil, keys)
+		expired := db.IsExpired(keys[0])
+		db.RWUnLocks(nil, keys)
+		if !expired {
+			return protocol.MakeBulkReply([]byte(keys[0]))
+		}
 	}
-	return protocol.MakeBulkReply([]byte(k[0]))
+	return &protocol.NullBulkReply{}
 }
 
 func init() {
@@ -856,13 +1043,13 @@ func init() {
 	registerCommand("MSet", execMSet, prepareMSet, undoMSet, -3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, -1, 2)
 	registerCommand("MGet", execMGet, prepareMGet, nil, -2, flagReadOnly).
-		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, -1, 1)
 	registerCommand("MSetNX", execMSetNX, prepareMSet, undoMSet, -3, flagWrite).
-		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, -1, 2)
 	registerCommand("Get", execGet, readFirstKey, nil, 2, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
-	registerCommand("GetEX", execGetEX, writeFirstKey, rollbackFirstKey, -2, flagReadOnly).
-		attachCommandExtra([]string{redisFlagReadonly, redisFlagFast}, 1, 1, 1)
+	registerCommand("GetEX", execGetEX, writeFirstKey, rollbackFirstKey, -2, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagFast}, 1, 1, 1)
 	registerCommand("GetSet", execGetSet, writeFirstKey, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("GetDel", execGetDel, writeFirstKey, rollbackFirstKey, 2, flagWrite).
@@ -871,7 +1058,8 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM, redisFlagFast}, 1, 1, 1)
 	registerCommand("IncrBy", execIncrBy, writeFirstKey, rollbackFirstKey, 3, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
-	registerCommand("IncrByFloat", execIncrByFloat, writeFirstKey, rollbackFirstKey, 3, flagWrite).attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
+	registerCommand("IncrByFloat", execIncrByFloat, writeFirstKey, rollbackFirstKey, 3, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("Decr", execDecr, writeFirstKey, rollbackFirstKey, 2, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("DecrBy", execDecrBy, writeFirstKey, rollbackFirstKey, 3, flagWrite).
@@ -884,6 +1072,9 @@ func init() {
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("GetRange", execGetRange, readFirstKey, nil, 4, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	// SUBSTR 是 GETRANGE 的旧名字
+	registerCommand("SubStr", execGetRange, readFirstKey, nil, 4, flagReadOnly).
+		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 	registerCommand("SetBit", execSetBit, writeFirstKey, rollbackFirstKey, 4, flagWrite).
 		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 1, 1, 1)
 	registerCommand("GetBit", execGetBit, readFirstKey, nil, 3, flagReadOnly).
@@ -892,6 +1083,8 @@ func init() {
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
 	registerCommand("BitPos", execBitPos, readFirstKey, nil, -3, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly}, 1, 1, 1)
+	registerCommand("BitOp", execBitOp, prepareBitOp, undoBitOp, -4, flagWrite).
+		attachCommandExtra([]string{redisFlagWrite, redisFlagDenyOOM}, 2, -1, 1)
 	registerCommand("Randomkey", getRandomKey, readAllKeys, nil, 1, flagReadOnly).
 		attachCommandExtra([]string{redisFlagReadonly, redisFlagRandom}, 1, 1, 1)
 }
@@ -0,0 +1,345 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/datastruct/sparse"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestSparseString(t *testing.T) {
+	db := makeTestDB()
+	offset := int64(100 << 23) // 第 100MB 字节
+	assertReply(t, execTestCmd(db, "SETBIT", "bm", strconv.FormatInt(offset, 10), "1"), ":0\r\n")
+	entity, _ := db.GetEntity("bm")
+	str, ok := entity.Data.(*sparse.String)
+	if !ok {
+		t.Fatalf("expected sparse string, got %T", entity.Data)
+	}
+	if str.Allocated() > sparse.ChunkSize {
+		t.Fatalf("allocated %d bytes", str.Allocated())
+	}
+	assertReply(t, execTestCmd(db, "GETBIT", "bm", strconv.FormatInt(offset, 10)), ":1\r\n")
+	assertReply(t, execTestCmd(db, "STRLEN", "bm"), ":"+strconv.FormatInt(offset/8+1, 10)+"\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "bm"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "bm", "1"), ":"+strconv.FormatInt(offset, 10)+"\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "bm", "-1", "-1"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "TYPE", "bm"), "+string\r\n")
+
+	assertReply(t, execTestCmd(db, "SETRANGE", "str", "10000000", "hello"), ":10000005\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "str", "9999999", "-1"), "$6\r\n\x00hello\r\n")
+	assertReply(t, execTestCmd(db, "APPEND", "str", "!"), ":10000006\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "str", "-6", "-1"), "$6\r\nhello!\r\n")
+	// 小的字符串不使用稀疏表示
+	execTestCmd(db, "SETRANGE", "small", "100", "x")
+	entity, _ = db.GetEntity("small")
+	if _, ok := entity.Data.([]byte); !ok {
+		t.Fatalf("expected []byte, got %T", entity.Data)
+	}
+
+	// 写满后转换回 []byte
+	execTestCmd(db, "SETRANGE", "dense", "2000000", "x")
+	execTestCmd(db, "SETRANGE", "dense", "0", string(make([]byte, 1500000)))
+	execTestCmd(db, "SETRANGE", "dense", "0", string(make([]byte, 1)))
+	if _, ok := getEntityData(db, "dense").(*sparse.String); !ok {
+		t.Fatal("zero writes should not allocate chunks")
+	}
+	ones := make([]byte, 1500000)
+	for i := range ones {
+		ones[i] = 1
+	}
+	execTestCmd(db, "SETRANGE", "dense", "0", string(ones))
+	if _, ok := getEntityData(db, "dense").([]byte); !ok {
+		t.Fatalf("expected []byte, got %T", getEntityData(db, "dense"))
+	}
+}
+
+// 期望结果与 redis 的文档和实际返回一致
+func TestBitmap(t *testing.T) {
+	db := makeTestDB()
+	assertReply(t, execTestCmd(db, "SETBIT", "bm", "7", "1"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "SETBIT", "bm", "0", "1"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "GET", "bm"), "$1\r\n\x81\r\n")
+	assertReply(t, execTestCmd(db, "GETBIT", "bm", "7"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "GETBIT", "bm", "100"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "SETBIT", "bm", "7", "0"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "SETBIT", "bm", "7", "2"), "-ERR bit is not an integer or out of range\r\n")
+
+	execTestCmd(db, "SET", "s", "foobar")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s"), ":26\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s", "1", "1"), ":6\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s", "1", "1", "BYTE"), ":6\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s", "5", "30", "BIT"), ":17\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s", "-100", "-1"), ":26\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s", "3", "1"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s", "0"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "s", "0", "1", "WORD"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "missing"), ":0\r\n")
+
+	execTestCmd(db, "SET", "p", "\xff\xf0\x00")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "0"), ":12\r\n")
+	execTestCmd(db, "SET", "p", "\x00\xff\xf0")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "1", "0"), ":8\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "1", "2"), ":16\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "1", "2", "-1", "BYTE"), ":16\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "1", "7", "15", "BIT"), ":8\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "1", "7", "-3", "BIT"), ":8\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "1", "2", "1"), ":-1\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "p", "2"), "-ERR The bit argument must be 1 or 0.\r\n")
+	// 没有指定 end 时查找 0 视为右侧补齐了 0
+	execTestCmd(db, "SET", "ones", "\xff\xff\xff")
+	assertReply(t, execTestCmd(db, "BITPOS", "ones", "0"), ":24\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "ones", "0", "1"), ":24\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "ones", "0", "0", "-1"), ":-1\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "missing", "0"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "missing", "1"), ":-1\r\n")
+
+	execTestCmd(db, "SET", "key1", "foobar")
+	execTestCmd(db, "SET", "key2", "abcdef")
+	assertReply(t, execTestCmd(db, "BITOP", "AND", "dest", "key1", "key2"), ":6\r\n")
+	assertReply(t, execTestCmd(db, "GET", "dest"), "$6\r\n`bc`ab\r\n")
+	assertReply(t, execTestCmd(db, "BITOP", "OR", "dest", "key1", "key2"), ":6\r\n")
+	assertReply(t, execTestCmd(db, "GET", "dest"), "$6\r\ngoofev\r\n")
+	assertReply(t, execTestCmd(db, "BITOP", "XOR", "dest", "key1", "key2", "key1"), ":6\r\n")
+	assertReply(t, execTestCmd(db, "GET", "dest"), "$6\r\nabcdef\r\n")
+	// 较短的字符串用 0 补齐
+	execTestCmd(db, "SET", "short", "\xff")
+	assertReply(t, execTestCmd(db, "BITOP", "AND", "dest", "ones", "short"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "GET", "dest"), "$3\r\n\xff\x00\x00\r\n")
+	assertReply(t, execTestCmd(db, "BITOP", "NOT", "dest", "p"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "GET", "dest"), "$3\r\n\xff\x00\x0f\r\n")
+	assertReply(t, execTestCmd(db, "BITOP", "NOT", "dest", "p", "s"),
+		"-ERR BITOP NOT must be called with a single source key.\r\n")
+	assertReply(t, execTestCmd(db, "BITOP", "NAND", "dest", "p"), "-ERR syntax error\r\n")
+	execTestCmd(db, "LPUSH", "list", "a")
+	assertReply(t, execTestCmd(db, "BITOP", "OR", "dest", "p", "list"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+	// 结果为空字符串时删除 destkey
+	execTestCmd(db, "EXPIRE", "dest", "100")
+	assertReply(t, execTestCmd(db, "BITOP", "OR", "dest", "missing"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "dest"), ":0\r\n")
+	execTestCmd(db, "SET", "dest", "x", "EX", "100")
+	execTestCmd(db, "BITOP", "OR", "dest", "key1")
+	assertReply(t, execTestCmd(db, "TTL", "dest"), ":-1\r\n")
+
+	// 稀疏字符串按块计算
+	offset := int64(100 << 23)
+	execTestCmd(db, "SETBIT", "big1", strconv.FormatInt(offset, 10), "1")
+	execTestCmd(db, "SETBIT", "big1", "0", "1")
+	execTestCmd(db, "SETBIT", "big2", strconv.FormatInt(offset+1, 10), "1")
+	execTestCmd(db, "SETBIT", "big2", "0", "1")
+	size := strconv.FormatInt(offset/8+1, 10)
+	assertReply(t, execTestCmd(db, "BITOP", "OR", "bigdest", "big1", "big2", "s"), ":"+size+"\r\n")
+	if str, ok := getEntityData(db, "bigdest").(*sparse.String); !ok || str.Allocated() > 2*sparse.ChunkSize {
+		t.Fatalf("expected sparse result, got %T", getEntityData(db, "bigdest"))
+	}
+	assertReply(t, execTestCmd(db, "BITCOUNT", "bigdest"), ":29\r\n")
+	assertReply(t, execTestCmd(db, "BITPOS", "bigdest", "1", "6"), ":"+strconv.FormatInt(offset, 10)+"\r\n")
+	assertReply(t, execTestCmd(db, "BITOP", "AND", "bigdest", "big1", "big2"), ":"+size+"\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "bigdest"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "GETBIT", "bigdest", "0"), ":1\r\n")
+	assertReply(t, execTestCmd(db, "BITOP", "XOR", "bigdest", "big1", "big2"), ":"+size+"\r\n")
+	assertReply(t, execTestCmd(db, "BITCOUNT", "bigdest"), ":2\r\n")
+}
+
+func getEntityData(db *DB, key string) interface{} {
+	entity, _ := db.GetEntity(key)
+	return entity.Data
+}
+
+func TestProtoMaxBulkLen(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16, ProtoMaxBulkLen: 1024})
+	conn := connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SETRANGE", "k", "1020", "hello")),
+		"-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SETRANGE", "k", "9223372036854775807", "x")),
+		"-ERR string exceeds maximum allowed size (proto-max-bulk-len)\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SETRANGE", "k", "1019", "hello")), ":1024\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SETBIT", "k", "8192", "1")),
+		"-ERR bit offset is not an integer or out of range\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SETBIT", "k", "8191", "1")), ":1\r\n")
+}
+
+func TestGetRangeSetRange(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "s", "This is a string")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "0", "3"), "$4\r\nThis\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "-3", "-1"), "$3\r\ning\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "0", "-1"), "$16\r\nThis is a string\r\n")
+	// 超出范围的偏移量截断到字符串内
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "-100", "3"), "$4\r\nThis\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "10", "100"), "$6\r\nstring\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "100", "200"), "$0\r\n\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "5", "3"), "$0\r\n\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "-1", "-5"), "$0\r\n\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "missing", "0", "-1"), "$0\r\n\r\n")
+	assertReply(t, execTestCmd(db, "GETRANGE", "s", "a", "1"), "-ERR value is not an integer or out of range\r\n")
+
+	assertReply(t, execTestCmd(db, "SETRANGE", "s", "10", "STRING"), ":16\r\n")
+	assertReply(t, execTestCmd(db, "GET", "s"), "$16\r\nThis is a STRING\r\n")
+	assertReply(t, execTestCmd(db, "SETRANGE", "pad", "3", "ab"), ":5\r\n")
+	assertReply(t, execTestCmd(db, "GET", "pad"), "$5\r\n\x00\x00\x00ab\r\n")
+	// 空字符串不修改数据
+	assertReply(t, execTestCmd(db, "SETRANGE", "missing", "100", ""), ":0\r\n")
+	assertReply(t, execTestCmd(db, "EXISTS", "missing"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "SETRANGE", "pad", "100", ""), ":5\r\n")
+	assertReply(t, execTestCmd(db, "SETRANGE", "pad", "-1", "x"), "-ERR offset is out of range\r\n")
+	assertReply(t, execTestCmd(db, "SETRANGE", "pad", "x", "x"), "-ERR value is not an integer or out of range\r\n")
+	execTestCmd(db, "RPUSH", "list", "a")
+	assertReply(t, execTestCmd(db, "SETRANGE", "list", "0", "x"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+}
+
+func TestIncrDecr(t *testing.T) {
+	db := makeTestDB()
+	assertReply(t, execTestCmd(db, "INCRBY", "n", "+5"), ":5\r\n")
+	assertReply(t, execTestCmd(db, "GET", "n"), "$1\r\n5\r\n")
+	assertReply(t, execTestCmd(db, "DECRBY", "n", "7"), ":-2\r\n")
+	assertReply(t, execTestCmd(db, "INCR", "n"), ":-1\r\n")
+	assertReply(t, execTestCmd(db, "DECR", "n"), ":-2\r\n")
+
+	execTestCmd(db, "SET", "max", "9223372036854775807")
+	assertReply(t, execTestCmd(db, "INCR", "max"), "-ERR increment or decrement would overflow\r\n")
+	assertReply(t, execTestCmd(db, "GET", "max"), "$19\r\n9223372036854775807\r\n")
+	execTestCmd(db, "SET", "min", "-9223372036854775808")
+	assertReply(t, execTestCmd(db, "DECRBY", "min", "1"), "-ERR increment or decrement would overflow\r\n")
+	assertReply(t, execTestCmd(db, "DECRBY", "n", "-9223372036854775808"), "-ERR decrement would overflow\r\n")
+	execTestCmd(db, "SET", "str", "abc")
+	assertReply(t, execTestCmd(db, "INCR", "str"), "-ERR value is not an integer or out of range\r\n")
+
+	assertReply(t, execTestCmd(db, "INCRBYFLOAT", "f", "inf"), "-ERR value is not a valid float\r\n")
+	execTestCmd(db, "SET", "f", "1.7976931348623157e308")
+	assertReply(t, execTestCmd(db, "INCRBYFLOAT", "f", "1.7976931348623157e308"),
+		"-ERR increment would produce NaN or Infinity\r\n")
+
+	assertReply(t, execTestCmd(db, "SUBSTR", "str", "1", "-1"), "$2\r\nbc\r\n")
+}
+
+func TestSharedIntegers(t *testing.T) {
+	db := makeTestDB()
+	execTestCmd(db, "SET", "a", "42")
+	execTestCmd(db, "SET", "b", "41")
+	execTestCmd(db, "INCR", "b")
+	a, b := getEntityData(db, "a").([]byte), getEntityData(db, "b").([]byte)
+	if &a[0] != &b[0] {
+		t.Error("expected small integers to share the same value")
+	}
+	// 原地修改之前复制，不影响其他 key
+	assertReply(t, execTestCmd(db, "SETRANGE", "a", "1", "3"), ":2\r\n")
+	assertReply(t, execTestCmd(db, "SETBIT", "b", "0", "1"), ":0\r\n")
+	assertReply(t, execTestCmd(db, "APPEND", "b", "x"), ":3\r\n")
+	assertReply(t, execTestCmd(db, "GET", "a"), "$2\r\n43\r\n")
+	assertReply(t, execTestCmd(db, "GET", "b"), "$3\r\n\xb42x\r\n")
+	execTestCmd(db, "SET", "c", "42")
+	assertReply(t, execTestCmd(db, "GET", "c"), "$2\r\n42\r\n")
+	// 非规范形式不共享
+	execTestCmd(db, "SET", "d", "042")
+	assertReply(t, execTestCmd(db, "GET", "d"), "$3\r\n042\r\n")
+}
+
+func BenchmarkIncr(b *testing.B) {
+	db := makeTestDB()
+	cmdLine := utils.ToCmdLine("INCR", "n")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if i%utils.SharedIntegers == 0 {
+			execTestCmd(db, "SET", "n", "0")
+		}
+		db.Exec(nil, cmdLine)
+	}
+}
+
+func TestStringRollback(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("MSET", "a", "1", "b", "x"))
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	server.Exec(conn, utils.ToCmdLine("INCRBY", "a", "10"))
+	server.Exec(conn, utils.ToCmdLine("APPEND", "b", "y"))
+	server.Exec(conn, utils.ToCmdLine("SETRANGE", "c", "2", "z"))
+	server.Exec(conn, utils.ToCmdLine("INCR", "b"))
+	// INCR b 失败，之前的命令全部回滚
+	server.Exec(conn, utils.ToCmdLine("EXEC"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MGET", "a", "b", "c")), "*3\r\n$1\r\n1\r\n$1\r\nx\r\n$-1\r\n")
+}
+
+// assertTTL 允许 1 秒的误差
+func assertTTL(t *testing.T, reply redis.Reply, expected int64) {
+	t.Helper()
+	intReply, ok := reply.(*protocol.IntReply)
+	if !ok || intReply.Code > expected || intReply.Code < expected-1 {
+		t.Errorf("expected ttl %d, actually %q", expected, reply.ToBytes())
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	db := makeTestDB()
+	assertReply(t, execTestCmd(db, "SET", "k", "v", "NX", "XX"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "SET", "k", "v", "EX", "10", "PX", "100"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "SET", "k", "v", "EX", "0"), "-ERR invalid expire time in 'set' command\r\n")
+	assertReply(t, execTestCmd(db, "SET", "k", "v", "EX", "abc"), "-ERR value is not an integer or out of range\r\n")
+
+	assertReply(t, execTestCmd(db, "SET", "k", "v", "GET"), "$-1\r\n")
+	assertReply(t, execTestCmd(db, "SET", "k", "v2", "GET", "EX", "100"), "$1\r\nv\r\n")
+	assertTTL(t, execTestCmd(db, "TTL", "k"), 100)
+	assertReply(t, execTestCmd(db, "SET", "k", "v3", "KEEPTTL"), "+OK\r\n")
+	assertTTL(t, execTestCmd(db, "TTL", "k"), 100)
+	assertReply(t, execTestCmd(db, "SET", "k", "v4"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "k"), ":-1\r\n")
+	assertReply(t, execTestCmd(db, "SET", "k", "v5", "NX", "GET"), "$2\r\nv4\r\n")
+	assertReply(t, execTestCmd(db, "GET", "k"), "$2\r\nv4\r\n")
+
+	at := strconv.FormatInt(time.Now().Add(time.Hour).Unix(), 10)
+	assertReply(t, execTestCmd(db, "SET", "k", "v", "EXAT", at), "+OK\r\n")
+	assertTTL(t, execTestCmd(db, "TTL", "k"), 3600)
+	assertReply(t, execTestCmd(db, "SET", "k", "v", "PXAT", "1"), "+OK\r\n")
+	assertReply(t, execTestCmd(db, "GET", "k"), "$-1\r\n")
+
+	execTestCmd(db, "RPUSH", "l", "a")
+	assertReply(t, execTestCmd(db, "SET", "l", "v", "GET"),
+		"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	execTestCmd(db, "SET", "g", "v")
+	assertReply(t, execTestCmd(db, "GETEX", "g", "PX", "100000"), "$1\r\nv\r\n")
+	assertTTL(t, execTestCmd(db, "TTL", "g"), 100)
+	assertReply(t, execTestCmd(db, "GETEX", "g", "PERSIST", "EX", "1"), "-ERR syntax error\r\n")
+	assertReply(t, execTestCmd(db, "GETEX", "g", "PERSIST"), "$1\r\nv\r\n")
+	assertReply(t, execTestCmd(db, "TTL", "g"), ":-1\r\n")
+	assertReply(t, execTestCmd(db, "GETEX", "g", "KEEPTTL"), "-ERR syntax error\r\n")
+
+	assertReply(t, execTestCmd(db, "PSETEX", "p", "0", "v"), "-ERR invalid expire time in 'psetex' command\r\n")
+	assertReply(t, execTestCmd(db, "PSETEX", "p", "100000", "v"), "+OK\r\n")
+	assertTTL(t, execTestCmd(db, "TTL", "p"), 100)
+}
+
+func TestSetTTLInAof(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "a", "1", "EX", "100"))
+	server.Exec(conn, utils.ToCmdLine("PSETEX", "b", "100000", "1"))
+	server.Exec(conn, utils.ToCmdLine("SETEX", "c", "100", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "c", "2", "KEEPTTL"))
+	server.Exec(conn, utils.ToCmdLine("SET", "d", "1"))
+	server.Exec(conn, utils.ToCmdLine("GETEX", "d", "EX", "100"))
+	server.Close()
+
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assertTTL(t, server.Exec(conn, utils.ToCmdLine("TTL", key)), 100)
+	}
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "c")), "$1\r\n2\r\n")
+}
@@ -0,0 +1,74 @@
+package database
+
+import (
+	"strconv"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// SWAPDB index1 index2
+// 交换 dbSet 中两个序号保存的 DB，之后选择了这两个序号的连接直接看到交换后的数据。
+// 执行期间独占 writeGate，没有正在执行的写命令；读命令可能还在读交换之前取到的 DB，相当于在交换之前执行。
+// 读命令不持有 writeGate，所以交换只原子地修改两个 DB 的序号，不修改 DB 的其他字段。
+// 序号相关的状态随序号保留: aof 按写入时 DB 的序号记录，阻塞的连接被唤醒后到交换后的 DB 上重新等待。
+// WATCH 记录的版本号属于序号，交换之后两个 DB 中所有 key 的版本号都超过交换前两边的版本号，
+// 监视这些 key 的事务会被放弃，所以 SWAPDB 的耗时与两个 DB 的 key 数成正比
+
+// execSwapDB 调用方独占 writeGate
+func (server *Server) execSwapDB(c redis.Connection, args [][]byte) redis.Reply {
+	if server.cfg.ClusterEnable {
+		return protocol.MakeErrReply("ERR SWAPDB is not allowed in cluster mode")
+	}
+	if c != nil && c.InMultiState() {
+		return rejectInMulti(c, "SwapDB")
+	}
+	first, err := strconv.Atoi(string(args[0]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid first DB index")
+	}
+	second, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid second DB index")
+	}
+	if first < 0 || first >= len(server.dbSet) || second < 0 || second >= len(server.dbSet) {
+		return protocol.MakeErrReply("ERR DB index is out of range")
+	}
+	if first == second {
+		return protocol.MakeOkReply()
+	}
+	server.swapDB(first, second)
+	// 重放和从节点同样交换，aof 中之后的命令按交换后的序号执行
+	server.saveAof(0, utils.ToCmdLine("SwapDB", strconv.Itoa(first), strconv.Itoa(second)))
+	return protocol.MakeOkReply()
+}
+
+func (server *Server) swapDB(first, second int) {
+	a, b := server.mustSelectDB(first), server.mustSelectDB(second)
+	invalidateSwappedVersions(a, b)
+	a.setIndex(second)
+	b.setIndex(first)
+	server.dbSet[first].Store(b)
+	server.dbSet[second].Store(a)
+	a.blocking.wakeAll()
+	b.blocking.wakeAll()
+}
+
+// invalidateSwappedVersions 两个 DB 中出现过的 key 的版本号都设为交换前两边的较大值加 1
+func invalidateSwappedVersions(a, b *DB) {
+	keys := make(map[string]struct{})
+	collect := func(key string, _ interface{}) bool {
+		keys[key] = struct{}{}
+		return true
+	}
+	for _, db := range []*DB{a, b} {
+		db.versionMap.ForEach(collect)
+		db.data.ForEach(collect)
+	}
+	for key := range keys {
+		version := max(a.GetVersion(key), b.GetVersion(key)) + 1
+		a.versionMap.Put(key, version)
+		b.versionMap.Put(key, version)
+	}
+}
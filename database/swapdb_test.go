@@ -0,0 +1,166 @@
+package database
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+func TestSwapDB(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	exec := func(c *connection.FakeConn, args ...string) string {
+		return string(server.Exec(c, utils.ToCmdLine(args...)).ToBytes())
+	}
+	exec(conn, "SET", "k", "zero")
+	exec(conn, "SELECT", "1")
+	exec(conn, "SET", "k", "one")
+	exec(conn, "SET", "only1", "x")
+
+	// 选择了 db 0 的连接直接看到交换后的数据
+	other := connection.NewFakeConn()
+	assertReply(t, server.Exec(other, utils.ToCmdLine("SWAPDB", "0", "1")), "+OK\r\n")
+	assertReply(t, server.Exec(other, utils.ToCmdLine("GET", "k")), "$3\r\none\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$4\r\nzero\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "only1")), ":0\r\n")
+	// 交换之后的写入记录在新的序号下
+	exec(conn, "SET", "after", "1")
+	exec(other, "SET", "after", "0")
+
+	// WATCH 的 key 在交换后失效
+	exec(other, "WATCH", "k")
+	exec(conn, "SWAPDB", "0", "1")
+	exec(other, "MULTI")
+	exec(other, "SET", "k", "watched")
+	assertReply(t, server.Exec(other, utils.ToCmdLine("EXEC")), "*0\r\n")
+	exec(conn, "SWAPDB", "0", "1")
+
+	// 阻塞的连接到交换后的 DB 上等待
+	blocked := connection.NewFakeConn()
+	result := execAsync(server, blocked, "BLPOP", "queue", "0")
+	waitBlocked(t, server, 1)
+	exec(conn, "RPUSH", "queue", "in1")
+	exec(conn, "SWAPDB", "0", "1")
+	assertReply(t, <-result, "*2\r\n$5\r\nqueue\r\n$3\r\nin1\r\n")
+	result = execAsync(server, blocked, "BLPOP", "queue2", "0")
+	waitBlocked(t, server, 1)
+	exec(conn, "SWAPDB", "0", "1")
+	exec(conn, "SELECT", "0")
+	exec(conn, "RPUSH", "queue2", "in0")
+	assertReply(t, <-result, "*2\r\n$6\r\nqueue2\r\n$3\r\nin0\r\n")
+
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SWAPDB", "0", "16")), "-ERR DB index is out of range\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SWAPDB", "a", "1")), "-ERR invalid first DB index\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SWAPDB", "0", "0")), "+OK\r\n")
+	exec(conn, "MULTI")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SWAPDB", "0", "1")), "-ERR command 'SwapDB' cannot be used in MULTI\r\n")
+	exec(conn, "DISCARD")
+
+	// 重放 aof 得到相同的数据
+	snapshot := func() []string {
+		var result []string
+		for _, db := range []string{"0", "1"} {
+			exec(conn, "SELECT", db)
+			for _, key := range []string{"k", "only1", "after"} {
+				result = append(result, exec(conn, "GET", key))
+			}
+		}
+		return result
+	}
+	expected := snapshot()
+	server.Close()
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	conn = connection.NewFakeConn()
+	actual := snapshot()
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("after replay %d: expected %q, got %q", i, expected[i], actual[i])
+		}
+	}
+}
+
+func TestSwapDBRewriteAof(t *testing.T) {
+	cfg := &config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    "always",
+		Databases:      16,
+	}
+	server := NewStandaloneServerWithConfig(cfg)
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "zero"))
+	server.Exec(conn, utils.ToCmdLine("SWAPDB", "0", "1"))
+	// 重写时在临时数据库中重放 SWAPDB
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("REWRITEAOF")), "+OK\r\n")
+	server.Close()
+	server = NewStandaloneServerWithConfig(cfg)
+	defer server.Close()
+	conn = connection.NewFakeConn()
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k")), ":0\r\n")
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$4\r\nzero\r\n")
+}
+
+// 读命令不持有 writeGate，与 SWAPDB 并发执行，用 -race 检查交换时没有修改读命令访问的字段
+func TestSwapDBConcurrentReaders(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		Databases:      16,
+	})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "zero", "EX", "100"))
+	server.Exec(conn, utils.ToCmdLine("SELECT", "1"))
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "one", "EX", "100"))
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make(chan string, 4)
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(dbIndex int) {
+			defer wg.Done()
+			reader := connection.NewFakeConn()
+			reader.SelectDB(dbIndex)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				reply := string(server.Exec(reader, utils.ToCmdLine("GET", "k")).ToBytes())
+				if reply != "$4\r\nzero\r\n" && reply != "$3\r\none\r\n" {
+					errs <- reply
+					return
+				}
+				server.Exec(reader, utils.ToCmdLine("TTL", "k"))
+			}
+		}(i % 2)
+	}
+	for i := 0; i < 200; i++ {
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("SWAPDB", "0", "1")), "+OK\r\n")
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+	for reply := range errs {
+		t.Errorf("unexpected GET reply %q during SWAPDB", reply)
+	}
+	// 交换了偶数次，写入仍然记录在原来的序号下
+	server.Exec(conn, utils.ToCmdLine("SET", "after", "1"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$3\r\none\r\n")
+}
@@ -22,53 +22,94 @@ func Ping(c redis.Connection, args [][]byte) redis.Reply {
 	}
 }
 
-func Info(db *Server, args [][]byte) redis.Reply {
+// infoSections 与 redis 的 INFO 段相同，按输出顺序排列。
+// 没有实现的段输出为空，不带参数或者 default 时输出 defaultSection 为 true 的段，
+// all 输出除 modules 以外的所有段，everything 输出所有段
+var infoSections = []struct {
+	name           string
+	defaultSection bool
+}{
+	{"server", true},
+	{"clients", true},
+	{"memory", true},
+	{"persistence", true},
+	{"stats", true},
+	{"replication", true},
+	{"cpu", true},
+	{"commandstats", false},
+	{"errorstats", true},
+	{"latencystats", false},
+	{"cluster", true},
+	{"keyspace", true},
+	{"modules", false},
+}
+
+// selectInfoSections 解析 INFO 的参数，不认识的段忽略
+func selectInfoSections(args [][]byte) map[string]bool {
+	selected := make(map[string]bool)
 	if len(args) == 0 {
-		infoCommandList := [...]string{"server", "client", "cluster", "keyspace"}
-		var allSection []byte
-		for _, s := range infoCommandList {
-			allSection = append(allSection, GenGodisInfoString(s, db)...)
-		}
-		return protocol.MakeBulkReply(allSection)
-	} else if len(args) == 1 {
-		section := strings.ToLower(string(args[0]))
-		switch section {
-		case "server":
-			reply := GenGodisInfoString("server", db)
-			return protocol.MakeBulkReply(reply)
+		args = [][]byte{[]byte("default")}
+	}
+	for _, arg := range args {
+		name := strings.ToLower(string(arg))
+		switch name {
+		case "all", "everything", "default":
+			for _, section := range infoSections {
+				if name == "everything" || (name == "all" && section.name != "modules") || section.defaultSection {
+					selected[section.name] = true
+				}
+			}
 		case "client":
-			return protocol.MakeBulkReply(GenGodisInfoString("client", db))
-		case "cluster":
-			return protocol.MakeBulkReply(GenGodisInfoString("cluster", db))
-		case "keyspace":
-			return protocol.MakeBulkReply(GenGodisInfoString("keyspace", db))
+			// 兼容旧版本的段名
+			selected["clients"] = true
 		default:
-			return protocol.MakeErrReply("Invalid section for 'info' command")
+			selected[name] = true
+		}
+	}
+	return selected
+}
+
+// Info INFO [section [section ...]]，段之间用空行分隔，没有匹配的段时返回空字符串
+func Info(db *Server, args [][]byte) redis.Reply {
+	selected := selectInfoSections(args)
+	info := make([]byte, 0)
+	for _, section := range infoSections {
+		if !selected[section.name] {
+			continue
+		}
+		s := GenGodisInfoString(section.name, db)
+		if len(s) == 0 {
+			continue
+		}
+		if len(info) > 0 {
+			info = append(info, "\r\n"...)
 		}
+		info = append(info, s...)
 	}
-	return protocol.MakeArgNumErrReply("info")
+	return protocol.MakeBulkReply(info)
 }
 
-func Auth(c redis.Connection, args [][]byte) redis.Reply {
+// Auth AUTH [username] password
+func Auth(db *Server, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 2 {
+		if errReply := db.authenticate(c, string(args[0]), string(args[1])); errReply != nil {
+			return errReply
+		}
+		return protocol.MakeOkReply()
+	}
 	if len(args) != 1 {
 		return protocol.MakeErrReply("ERR wrong number of arguments for 'auth' command")
 	}
-	if config.Properties.RequirePass == "" {
-		return protocol.MakeErrReply("ERR Client sent AUTH, but no password is set")
+	// 只有密码时以 default 用户登录
+	if user := db.lookupUser(defaultUser); user != nil && user.perm.Load().nopass {
+		return protocol.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. " +
+			"Are you sure your configuration is correct?")
 	}
-	password := string(args[0])
-	c.SetPassword(password)
-	if config.Properties.RequirePass != password {
-        return protocol.MakeErrReply("ERR invalid password")
+	if errReply := db.authenticate(c, defaultUser, string(args[0])); errReply != nil {
+		return errReply
 	}
 	return protocol.MakeOkReply()
 }
-func isAuthenticated(c redis.Connection) bool {
-	if config.Properties.RequirePass == "" {
-		return true
-	}
-	return c.GetPassword() == config.Properties.RequirePass
-}
 func DbSize(c redis.Connection, db *Server) redis.Reply {
 	keys, _ := db.GetDBSize(c.GetDBIndex())
 	return protocol.MakeIntReply(int64(keys))
@@ -89,35 +130,125 @@ func GenGodisInfoString(section string, db *Server) []byte {
 			"tcp_port:%d\r\n"+
 			"uptime_in_seconds:%d\r\n"+
 			"uptime_in_days:%d\r\n"+
-			"config_file:%s\r\n",
+			"config_file:%s\r\n"+
+			"gomaxprocs:%d\r\n"+
+			"num_cpu:%d\r\n"+
+			"shard_count:%d\r\n"+
+			"worker_pool_size:%d\r\n"+
+			"read_buffer_size:%d\r\n",
 			godisVersion,
-			getGodisRunningMode(),
+			getGodisRunningMode(db.cfg),
 			runtime.GOOS, runtime.GOARCH,
 			32<<(^uint(0)>>63),
 			//TODO,
 			runtime.Version(),
 			os.Getpid(),
-			config.Properties.RunID,
-			config.Properties.Port,
+			db.cfg.RunID,
+			db.cfg.Port,
 			startUpTimeFromNow,
 			startUpTimeFromNow/time.Duration(3600*24),
-			config.GetConfigFilePath())
+			config.GetConfigFilePath(),
+			db.tuning.GOMAXPROCS,
+			db.tuning.NumCPU,
+			db.tuning.ShardCount,
+			db.tuning.WorkerPoolSize,
+			db.tuning.ReadBufferSize)
 		return []byte(s)
-	case "client":
-		s := fmt.Sprintf("# Clients\r\n" +
-			"connected_clients:%d\r\n",
-		//"client_recent_max_input_buffer:%d\r\n"+
-		//"client_recent_max_output_buffer:%d\r\n"+
-		//"blocked_clients:%d\n",
-		)
+	case "clients", "client":
+		connected, blocked := 0, 0
+		db.clients.Range(func(key, value any) bool {
+			connected++
+			return true
+		})
+		db.blockedConns.Range(func(key, value any) bool {
+			blocked++
+			return true
+		})
+		s := fmt.Sprintf("# Clients\r\n"+
+			"connected_clients:%d\r\n"+
+			"blocked_clients:%d\r\n",
+			connected, blocked)
 		return []byte(s)
-
+	case "memory":
+		return []byte(db.memoryInfo())
+	case "persistence":
+		aofEnabled := 0
+		if db.cfg.AppendOnly {
+			aofEnabled = 1
+		}
+		bgsaveInProgress := 0
+		if db.rdb.saving.Load() {
+			bgsaveInProgress = 1
+		}
+		bgsaveStatus := "ok"
+		if db.rdb.lastFailed.Load() {
+			bgsaveStatus = "err"
+		}
+		aofRewriting := 0
+		if db.aofRewriting.Load() > 0 {
+			aofRewriting = 1
+		}
+		aofRewriteStatus := "ok"
+		if db.aofRewriteFailed.Load() {
+			aofRewriteStatus = "err"
+		}
+		s := fmt.Sprintf("# Persistence\r\n"+
+			"rdb_changes_since_last_save:%d\r\n"+
+			"rdb_bgsave_in_progress:%d\r\n"+
+			"rdb_last_save_time:%d\r\n"+
+			"rdb_last_bgsave_status:%s\r\n"+
+			"aof_enabled:%d\r\n"+
+			"aof_rewrite_in_progress:%d\r\n"+
+			"aof_last_bgrewrite_status:%s\r\n",
+			db.rdb.dirty.Load(), bgsaveInProgress, db.rdb.lastSave.Load(), bgsaveStatus, aofEnabled,
+			aofRewriting, aofRewriteStatus)
+		// 加载结束后 loading_* 保留最后一次加载的统计
+		if progress := db.loadProgress(); progress != nil {
+			loading := 0
+			if progress.Loading() {
+				loading = 1
+			}
+			s += fmt.Sprintf("loading:%d\r\n"+
+				"loading_start_time:%d\r\n"+
+				"loading_total_bytes:%d\r\n"+
+				"loading_loaded_bytes:%d\r\n"+
+				"loading_loaded_perc:%.2f\r\n"+
+				"loading_loaded_commands:%d\r\n"+
+				"loading_eta_seconds:%d\r\n",
+				loading,
+				progress.StartTime(),
+				progress.TotalBytes(),
+				progress.LoadedBytes(),
+				progress.Percent(),
+				progress.Commands(),
+				progress.ETA())
+		} else {
+			s += "loading:0\r\n"
+		}
+		return []byte(s)
+	case "stats":
+		return []byte(db.statsInfo())
+	case "replication":
+		return []byte(db.replicationInfo())
+	case "cpu":
+		return []byte(db.cpuInfo())
+	case "cluster":
+		clusterEnabled := 0
+		if db.cfg.ClusterEnable {
+			clusterEnabled = 1
+		}
+		return []byte(fmt.Sprintf("# Cluster\r\n"+
+			"cluster_enabled:%d\r\n", clusterEnabled))
+	case "commandstats":
+		return []byte(db.commandStatsInfo())
+	case "keyspace":
+		return []byte(db.keyspaceInfo())
 	}
 	return []byte("")
 }
 
-func getGodisRunningMode() string {
-	if config.Properties.ClusterEnable {
+func getGodisRunningMode(cfg *config.ServerProperties) string {
+	if cfg.ClusterEnable {
 		return config.ClusterMode
 	} else {
 		return config.StandaloneMode
@@ -10,6 +10,9 @@ import (
 
 // Watch 命令用于监视一个(或多个) key ，如果在事务执行之前这个(或这些) key 被其他命令所改动，那么事务将被放弃
 func Watch(db *DB, conn redis.Connection, args [][]byte) redis.Reply {
+	if conn.InMultiState() {
+		return protocol.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+	}
 	watching := conn.GetWatching()
 	for _, arg := range args {
 		key := string(arg)
@@ -18,6 +21,24 @@ func Watch(db *DB, conn redis.Connection, args [][]byte) redis.Reply {
 	return protocol.MakeOkReply()
 }
 
+// UnWatch 取消监视所有的 key，EXEC 和 DISCARD 之后同样会取消
+func UnWatch(conn redis.Connection) redis.Reply {
+	clear(conn.GetWatching())
+	return protocol.MakeOkReply()
+}
+
+// execUnWatchQueued 事务中的 UNWATCH 排队执行，EXEC 时监视已经检查完毕，直接返回 OK
+func execUnWatchQueued(db *DB, args [][]byte) redis.Reply {
+	return protocol.MakeOkReply()
+}
+
+// rejectInMulti 不能在事务中排队的命令，与排队时的其它错误一样记录下来，EXEC 时放弃整个事务
+func rejectInMulti(conn redis.Connection, cmdName string) redis.Reply {
+	err := protocol.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI")
+	conn.AddTxError(err)
+	return err
+}
+
 // 判断是否需要给上锁呢
 func isWatchingChanged(db *DB, watching map[string]uint32) bool {
 	// 实现 Watch 命令的核心是发现 key 是否被改动，我们使用简单可靠的版本号方案：为每个 key 存储一个版本号，版本号变化说明 key 被修改了
@@ -41,6 +62,14 @@ func StartMulti(conn redis.Connection) redis.Reply {
 	return protocol.MakeOkReply()
 }
 
+// execDBIndex 事务中排队的命令和 EXEC 使用 MULTI 时绑定的数据库，不受之后连接状态变化的影响
+func execDBIndex(conn redis.Connection) int {
+	if conn.InMultiState() {
+		return conn.GetMultiDBIndex()
+	}
+	return conn.GetDBIndex()
+}
+
 // 结束事务
 func DiscardMulti(conn redis.Connection) redis.Reply {
 	if !conn.InMultiState() {
@@ -96,8 +125,14 @@ func (db *DB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLi
 		watchingKeys = append(watchingKeys, key)
 	}
 	readKeys = append(readKeys, watchingKeys...)
-	db.RWLocks(writeKeys, readKeys)
-	defer db.RWUnLocks(writeKeys, readKeys)
+	view, log := db.beginCommit(writeKeys)
+	return db.execCommitted(writeKeys, readKeys, log, func() redis.Reply {
+		return view.execMultiLocked(log, watching, cmdLines)
+	})
+}
+
+// execMultiLocked 持有事务所有 key 的锁执行排队的命令，db 是 beginCommit 返回的 DB
+func (db *DB) execMultiLocked(log *commitLog, watching map[string]uint32, cmdLines []CmdLine) redis.Reply {
 	slog.Info("即将进入isWatchingChanged")
 	if isWatchingChanged(db, watching) { // watching keys changed, abort
 		slog.Info("watching keys 为空")
@@ -107,22 +142,44 @@ func (db *DB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLi
 	results := make([]redis.Reply, 0, len(cmdLines))
 	aborted := false
 	undoCmdLines := make([][]CmdLine, 0, len(cmdLines))
-	for _, cmdLine := range cmdLines {
+	// 事务成功后再执行的 PUBLISH 在 cmdLines 中的下标
+	var publishes []int
+	// 只读命令的 key 可能只持有读锁，不能在执行中删除过期的 key
+	reader := *db
+	reader.readExpired = &log.readExpired
+	for i, cmdLine := range cmdLines {
+		if isPublish(cmdLine) {
+			publishes = append(publishes, i)
+			results = append(results, nil)
+			undoCmdLines = append(undoCmdLines, nil)
+			continue
+		}
 		undoCmdLines = append(undoCmdLines, db.GetUndoLogs(cmdLine))
-		result := db.execWithLock(cmdLine)
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		events := db.prepareEvents(cmdName, cmdLine[1:])
+		var result redis.Reply
+		if isWriteCommand(cmdName) {
+			result = db.execWithLock(cmdLine)
+		} else {
+			result = reader.execWithLock(cmdLine)
+		}
 		if protocol.IsErrorReply(result) {
 			aborted = true
 			// 没必要回滚失败的操作了
 			undoCmdLines = undoCmdLines[:len(undoCmdLines)-1]
 			break
 		}
-
+		// 事务成功后再发布 keyspace 通知
+		log.addEvents(events, result)
 		results = append(results, result)
 	}
 	if !aborted {
 		// 成功
 		slog.Info("事务成功")
-		db.addVersion(writeKeys...)
+		log.db.commit(log)
+		for _, i := range publishes {
+			results[i] = db.execWithLock(cmdLines[i])
+		}
 		return protocol.MakeMultiRawReply(results)
 	}
 	// 不成功的处理
@@ -136,6 +193,8 @@ func (db *DB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLi
 			db.execWithLock(cmdLine)
 		}
 	}
+	log.abort()
+	log.db.commit(log)
 	return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
 }
 
@@ -148,9 +207,7 @@ func EnqueueCmd(conn redis.Connection, cmdLine [][]byte) redis.Reply {
 		return err
 	}
 	if cmd.prepare == nil {
-		err := protocol.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI")
-		conn.AddTxError(err)
-		return err
+		return rejectInMulti(conn, cmdName)
 	}
 	if !validateArity(cmd.arity, cmdLine) {
 		err := protocol.MakeArgNumErrReply(cmdName)
@@ -160,3 +217,8 @@ func EnqueueCmd(conn redis.Connection, cmdLine [][]byte) redis.Reply {
 	conn.EnqueueCmd(cmdLine)
 	return protocol.MakeQueuedReply()
 }
+
+func init() {
+	registerCommand("UnWatch", execUnWatchQueued, noPrepare, nil, 1, flagReadOnly).
+		attachCommandExtra([]string{redisFlagNoScript, redisFlagLoading, redisFlagStale, redisFlagFast}, 0, 0, 0)
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestMultiBindsDB(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	exec := func(args ...string) string {
+		return string(server.Exec(conn, utils.ToCmdLine(args...)).ToBytes())
+	}
+
+	// MULTI 之后连接切换了数据库，事务仍然在 MULTI 时的数据库执行
+	exec("SELECT", "1")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("MULTI")), "+OK\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SET", "k", "1")), "+QUEUED\r\n")
+	conn.SelectDB(3)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("INCR", "k")), "+QUEUED\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*2\r\n+OK\r\n:2\r\n")
+	for _, db := range []string{"0", "2", "3"} {
+		exec("SELECT", db)
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k")), ":0\r\n")
+	}
+	exec("SELECT", "1")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$1\r\n2\r\n")
+
+	// 下一个事务绑定新选择的数据库
+	exec("SELECT", "2")
+	exec("MULTI")
+	exec("SET", "k", "db2")
+	conn.SelectDB(0)
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*1\r\n+OK\r\n")
+	exec("SELECT", "2")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$3\r\ndb2\r\n")
+
+	// DISCARD 之后不再使用绑定的数据库
+	exec("MULTI")
+	exec("DISCARD")
+	exec("SELECT", "0")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k")), ":0\r\n")
+}
+
+func TestMultiWatchState(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	other := connection.NewFakeConn()
+	exec := func(c *connection.FakeConn, args ...string) string {
+		return string(server.Exec(c, utils.ToCmdLine(args...)).ToBytes())
+	}
+
+	// 事务中的 SELECT 被拒绝，EXEC 放弃整个事务，下一个事务不受影响
+	exec(conn, "MULTI")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("SELECT", "1")), "-ERR command 'select' cannot be used in MULTI\r\n")
+	exec(conn, "SET", "k", "1")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "-EXECABORT Transaction discarded because of previous errors.\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXISTS", "k")), ":0\r\n")
+	exec(conn, "MULTI")
+	exec(conn, "SET", "k", "1")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*1\r\n+OK\r\n")
+
+	// UNWATCH 之后 key 的修改不影响事务
+	exec(conn, "WATCH", "k")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("UNWATCH")), "+OK\r\n")
+	exec(other, "SET", "k", "2")
+	exec(conn, "MULTI")
+	exec(conn, "INCR", "k")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*1\r\n:3\r\n")
+
+	// EXEC 和 DISCARD 之后不再监视原来的 key
+	for _, end := range []string{"EXEC", "DISCARD"} {
+		exec(conn, "WATCH", "k")
+		exec(conn, "MULTI")
+		exec(conn, end)
+		exec(other, "SET", "k", "10")
+		exec(conn, "MULTI")
+		exec(conn, "INCR", "k")
+		assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*1\r\n:11\r\n")
+	}
+
+	// 事务中不能 WATCH，UNWATCH 排队执行
+	exec(conn, "WATCH", "k")
+	exec(conn, "MULTI")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("WATCH", "other")), "-ERR WATCH inside MULTI is not allowed\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("UNWATCH")), "+QUEUED\r\n")
+	exec(conn, "INCR", "k")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*2\r\n+OK\r\n:12\r\n")
+	// 事务中排队的 UNWATCH 不影响 EXEC 对 MULTI 之前 WATCH 的检查
+	exec(conn, "WATCH", "k")
+	exec(conn, "MULTI")
+	exec(conn, "UNWATCH")
+	exec(other, "SET", "k", "0")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")), "*0\r\n")
+}
+
+// 回滚命令使用值的拷贝，事务中之后原地修改字符串的命令不影响回滚
+func TestRollbackUsesClone(t *testing.T) {
+	server := NewStandaloneServerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16})
+	defer server.Close()
+	conn := connection.NewFakeConn()
+	server.Exec(conn, utils.ToCmdLine("SET", "k", "hello"))
+	server.Exec(conn, utils.ToCmdLine("HSET", "h", "f", "v"))
+	payload := server.Exec(conn, utils.ToCmdLine("DUMP", "h")).(*protocol.BulkReply).Arg
+
+	server.Exec(conn, utils.ToCmdLine("MULTI"))
+	server.Exec(conn, utils.ToCmdLine("SETRANGE", "k", "0", "J"))
+	server.Exec(conn, utils.ToCmdLine("SETRANGE", "k", "1", "E"))
+	server.Exec(conn, utils.ToCmdLine3("RESTORE", []byte("k"), []byte("0"), payload, []byte("REPLACE")))
+	server.Exec(conn, utils.ToCmdLine("INCR", "k"))
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("EXEC")),
+		"-EXECABORT Transaction discarded because of previous errors.\r\n")
+	assertReply(t, server.Exec(conn, utils.ToCmdLine("GET", "k")), "$5\r\nhello\r\n")
+}
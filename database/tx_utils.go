@@ -4,6 +4,7 @@ import (
 	"strconv"
 
 	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/lib/utils"
 )
 
@@ -59,11 +60,13 @@ func rollbackGivenKeys(db *DB, keys ...string) []CmdLine {
 				utils.ToCmdLine("DEL", key),
 			)
 		} else {
-			undoCmdLines = append(undoCmdLines,
-				utils.ToCmdLine("DEL", key), // clean existed first
-				aof.EntityToCmd(key, entity).Args,
-				toTTLCmd(db, key).Args,
-			)
+			undoCmdLines = append(undoCmdLines, utils.ToCmdLine("DEL", key)) // clean existed first
+			// 事务中之后的命令(SETRANGE/SETBIT 等)可能原地修改这个值，回滚命令使用它的拷贝
+			entity = &database.DataEntity{Data: cloneData(entity.Data)}
+			for _, cmd := range aof.EntityToCmds(key, entity) {
+				undoCmdLines = append(undoCmdLines, cmd.Args)
+			}
+			undoCmdLines = append(undoCmdLines, toTTLCmd(db, key).Args)
 		}
 	}
 	return undoCmdLines
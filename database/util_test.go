@@ -0,0 +1,28 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+)
+
+func makeTestDB() *DB {
+	return makeBasicDB()
+}
+
+// execTestCmd runs a command line like "HGET key field" against db
+func execTestCmd(db *DB, cmd ...string) redis.Reply {
+	return db.Exec(nil, utils.ToCmdLine(cmd...))
+}
+
+func assertReply(t *testing.T, actual redis.Reply, expected string) {
+	t.Helper()
+	if actual == nil {
+		t.Errorf("expected %q, actually nil", expected)
+		return
+	}
+	if string(actual.ToBytes()) != expected {
+		t.Errorf("expected %q, actually %q", expected, actual.ToBytes())
+	}
+}
@@ -0,0 +1,27 @@
+package database
+
+// 工作池
+// 数据命令执行前占用一个名额，同时执行的数据命令不超过 worker-pool-size，其它连接排队等待，
+// 连接数远大于 CPU 数时避免大量 goroutine 同时争抢 key 的锁。
+// 服务器命令和阻塞命令等待数据的过程不占用名额，等待中的连接不会挡住唤醒它的写命令
+type workerPool chan struct{}
+
+// makeWorkerPool size 不大于 0 时不限制
+func makeWorkerPool(size int) workerPool {
+	if size <= 0 {
+		return nil
+	}
+	return make(workerPool, size)
+}
+
+func (pool workerPool) acquire() {
+	if pool != nil {
+		pool <- struct{}{}
+	}
+}
+
+func (pool workerPool) release() {
+	if pool != nil {
+		<-pool
+	}
+}
@@ -1,5 +1,7 @@
 package bitmap
 
+// 与 redis 相同，offset 0 是第一个字节的最高位
+
 type BitMap []byte
 
 func New() *BitMap {
@@ -39,7 +41,7 @@ func (b *BitMap) ToBytes() []byte {
 func (b *BitMap) SetBit(offset int64, val byte) {
 	byteIndex := offset / 8
 	bitOffset := offset % 8
-	mask := byte(1 << bitOffset)
+	mask := byte(0x80 >> bitOffset)
 	b.grow(offset + 1)
 	if val > 0 {
 		// set bit
@@ -56,7 +58,7 @@ func (b *BitMap) GetBit(offset int64) byte {
 	if byteIndex >= int64(len(*b)) {
 		return 0
 	}
-	return ((*b)[byteIndex] >> bitOffset) & 0x01
+	return ((*b)[byteIndex] >> (7 - bitOffset)) & 0x01
 }
 
 type Callback func(offset int64, val byte) bool
@@ -68,7 +70,7 @@ func (b *BitMap) ForEachBit(begin int64, end int64, cb Callback) {
 	for byteIndex < int64(len(*b)) {
 		b := (*b)[byteIndex]
 		for bitOffset < 8 {
-			bit := byte(b >> bitOffset & 0x01)
+			bit := byte(b >> (7 - bitOffset) & 0x01)
 			if !cb(offset, bit) {
 				return
 			}
@@ -3,26 +3,50 @@ package dict
 import (
 	"log/slog"
 	"math"
+	"math/bits"
 	"math/rand"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
 	"github.com/zhangming/go-redis/lib/wildcard"
 )
 
 type Shard struct {
 	m     map[string]interface{}
 	mutex sync.RWMutex
-}
-
+	// Resize 把这个分片的 key 搬到新的分片表之后指向新表，之后这个分片一直为空。
+	// 在持有分片写锁时设置，拿到分片锁的调用方发现它不为 nil 时需要到新表中重新定位
+	moved atomic.Pointer[[]*Shard]
+	// 加锁顺序: 高 32 位是分片表的代数(每次 Resize 加 1)，低 32 位是分片下标，
+	// 旧表的分片总是排在新表之前
+	sub uint64
+}
+
+// ConcurrentDict 按 key 的哈希值分片，每个分片一把读写锁。
+// Get/Put/Remove 等方法自己加分片锁，可以被多个 goroutine 同时调用；
+// 数据库执行命令前已经用 RWLocks 锁住了命令涉及的 key 所在的分片(读写锁不能重入)，
+// 之后用不加锁的 *Locked 版本访问这些 key。
+// 分片数在创建时确定(1 或者向上取 2 的幂，至少 16)，Clear 不改变分片表，只有 Resize 和自动扩容会替换分片表。
+// Resize 与 SimpleDict 的渐进式 rehash 类似，逐个分片搬迁，每次只锁住一个旧分片和它对应的新分片，
+// 搬迁期间 key 可能在旧表也可能在新表，访问时从当前的分片表出发沿着 Shard.moved 找到 key 所在的分片
 type ConcurrentDict struct {
-	table      []*Shard
-	count      int32
-	shardCount int
+	// 当前的分片表，Resize 搬迁完所有分片之后替换
+	table atomic.Pointer[[]*Shard]
+	count int32
+	// 每个字典的分片锁是一类，嵌套加锁时必须按分片下标递增
+	lockClass *lockorder.Class
+	// 平均每个分片的 key 数超过 maxLoad 时在后台把分片数翻倍，0 表示不自动扩容
+	maxLoad  atomic.Int32
+	resizing atomic.Bool
+	resizeMu sync.Mutex
 }
 
+// maxShards 是自动扩容的分片数上限
+const maxShards = 1 << 16
+
 const prime32 = uint32(16777619)
 
 // 哈希函数常用于哈希表中，将键转换为哈希值，以便快速查找。
@@ -35,8 +59,56 @@ func fnv32(key string) uint32 {
 	return hash
 }
 
+func (dict *ConcurrentDict) lockShard(s *Shard, write bool) {
+	dict.lockClass.Acquire(s.sub)
+	if write {
+		s.mutex.Lock()
+	} else {
+		s.mutex.RLock()
+	}
+}
+
+func (dict *ConcurrentDict) unlockShard(s *Shard, write bool) {
+	if write {
+		s.mutex.Unlock()
+	} else {
+		s.mutex.RUnlock()
+	}
+	dict.lockClass.Release(s.sub)
+}
+
+// lockKey 锁住 key 所在的分片并返回它，返回的分片没有被搬迁
+func (dict *ConcurrentDict) lockKey(key string, write bool) *Shard {
+	shards := dict.shards()
+	s := shards[spreadIn(shards, key)]
+	for {
+		dict.lockShard(s, write)
+		moved := s.moved.Load()
+		if moved == nil {
+			return s
+		}
+		// 等锁期间这个分片被搬迁了
+		dict.unlockShard(s, write)
+		s = (*moved)[spreadIn(*moved, key)]
+	}
+}
+
+// resolve 返回 key 所在的分片，不加锁。调用方持有这个分片的锁时它不会被搬迁，
+// 而当前的分片表不会比它新，所以一定能沿着 moved 找到它
+func (dict *ConcurrentDict) resolve(key string) *Shard {
+	shards := dict.shards()
+	s := shards[spreadIn(shards, key)]
+	for moved := s.moved.Load(); moved != nil; moved = s.moved.Load() {
+		s = (*moved)[spreadIn(*moved, key)]
+	}
+	return s
+}
+
 func (dict *ConcurrentDict) addCount() {
-	atomic.AddInt32(&dict.count, 1)
+	n := atomic.AddInt32(&dict.count, 1)
+	if maxLoad := dict.maxLoad.Load(); maxLoad > 0 {
+		dict.maybeGrow(int(n), int(maxLoad))
+	}
 }
 
 func (dict *ConcurrentDict) decreaseCount() {
@@ -60,57 +132,51 @@ func computeCapacity(param int) int {
 	return n + 1
 }
 
-// 创建具有给定分片数的并发字典
-func MakeConcurrent(shardCount int) *ConcurrentDict {
+// normalizeShardCount 1 个分片保持不变，其他值向上取 2 的幂，至少 16
+func normalizeShardCount(shardCount int) int {
 	if shardCount == 1 {
-		table := []*Shard{
-			{
-				m: make(map[string]interface{}),
-			},
-		}
-		return &ConcurrentDict{
-			count:      0,
-			table:      table,
-			shardCount: shardCount,
-		}
-	}
-	shardCount = computeCapacity(shardCount)
-	table := make([]*Shard, shardCount)
-	for i := 0; i < shardCount; i++ {
-		table[i] = &Shard{
-			m: make(map[string]interface{}),
-		}
+		return 1
 	}
-	d := &ConcurrentDict{
-		count:      0,
-		table:      table,
-		shardCount: shardCount,
+	return computeCapacity(shardCount)
+}
+
+func makeShards(shardCount int, gen uint32) []*Shard {
+	shards := make([]*Shard, shardCount)
+	for i := range shards {
+		shards[i] = &Shard{m: make(map[string]interface{}), sub: uint64(gen)<<32 | uint64(i)}
 	}
+	return shards
+}
+
+// 创建具有给定分片数的并发字典，分片数见 normalizeShardCount
+func MakeConcurrent(shardCount int) *ConcurrentDict {
+	d := &ConcurrentDict{lockClass: lockorder.NewClass("dict.shard")}
+	shards := makeShards(normalizeShardCount(shardCount), 0)
+	d.table.Store(&shards)
 	return d
 }
 
+// MakeNewConcurrentDict 与 MakeConcurrent 相同
 func MakeNewConcurrentDict(capacity int) *ConcurrentDict {
-	shardCount := computeCapacity(capacity)
-	table := make([]*Shard, shardCount)
-	for i := 0; i < shardCount; i++ {
-		table[i] = &Shard{m: make(map[string]interface{})}
-	}
+	return MakeConcurrent(capacity)
+}
 
-	d := &ConcurrentDict{table: table, count: 0}
-	return d
+// shards 返回当前的分片表，不持有分片锁时可能随时被替换
+func (dict *ConcurrentDict) shards() []*Shard {
+	return *dict.table.Load()
 }
 
-// 将哈希码均匀地映射到 ConcurrentDict 的各个分片上
-func (dict *ConcurrentDict) spread(key string) uint32 {
-	if dict == nil {
-		panic("dict is nil")
-	}
-	if len(dict.table) == 1 {
+// ShardCount returns the number of shards of the current shard table
+func (dict *ConcurrentDict) ShardCount() int {
+	return len(dict.shards())
+}
+
+// spreadIn 将 key 的哈希码均匀地映射到分片表的各个分片上
+func spreadIn(shards []*Shard, key string) uint32 {
+	if len(shards) == 1 {
 		return 0
 	}
-	hashCode := fnv32(key)
-	tableSize := uint32(len(dict.table))
-	return (tableSize - 1) & hashCode
+	return uint32(len(shards)-1) & hashKey(key)
 }
 
 func (dict *ConcurrentDict) Len() int {
@@ -122,19 +188,12 @@ func (dict *ConcurrentDict) Len() int {
 	return int(atomic.LoadInt32(&dict.count))
 }
 
-func (dict *ConcurrentDict) getShard(index uint32) *Shard {
-	if dict == nil {
-		panic("dict is nil")
-	}
-	return dict.table[index]
-}
-
-func (dict *ConcurrentDict) Remove(key string) (val interface{}, result int) {
+// RemoveLocked 与 Remove 相同但不加锁，调用方必须已经通过 RWLocks 持有 key 所在分片的写锁
+func (dict *ConcurrentDict) RemoveLocked(key string) (val interface{}, result int) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	index := dict.spread(key)
-	s := dict.getShard(index)
+	s := dict.resolve(key)
 	if val, ok := s.m[key]; ok {
 		delete(s.m, key)
 		dict.decreaseCount()
@@ -143,15 +202,14 @@ func (dict *ConcurrentDict) Remove(key string) (val interface{}, result int) {
 	return val, 0
 }
 
-func (dict *ConcurrentDict) RemoveWithLock(key string) (val interface{}, result int) {
+// Remove removes the key and return the number of deleted key-value, it locks the shard
+func (dict *ConcurrentDict) Remove(key string) (val interface{}, result int) {
 	if dict == nil {
 		slog.Error("dict is nil")
 		return nil, 0
 	}
-	index := dict.spread(key)
-	s := dict.getShard(index)
-	defer s.mutex.Unlock()
-	s.mutex.Lock()
+	s := dict.lockKey(key, true)
+	defer dict.unlockShard(s, true)
 	if val, ok := s.m[key]; ok {
 		delete(s.m, key)
 		dict.decreaseCount()
@@ -160,63 +218,108 @@ func (dict *ConcurrentDict) RemoveWithLock(key string) (val interface{}, result
 	return nil, 0
 }
 
-func (dict *ConcurrentDict) toLockIndices(keys []string, reverse bool) []uint32 {
-	indexMap := make(map[uint32]struct{})
-	for _, key := range keys {
-		index := dict.spread(key)
-		indexMap[index] = struct{}{}
+// lockShards 按 sub 递增的顺序锁住 shards，值表示是否加写锁
+func (dict *ConcurrentDict) lockShards(shards map[*Shard]bool) {
+	sorted := make([]*Shard, 0, len(shards))
+	for s := range shards {
+		sorted = append(sorted, s)
 	}
-	indices := make([]uint32, 0, len(indexMap))
-	for index := range indexMap {
-		indices = append(indices, index)
-	}
-	sort.Slice(indices, func(i, j int) bool {
-		if !reverse {
-			return indices[i] < indices[j]
-		}
-		return indices[i] > indices[j]
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].sub < sorted[j].sub
 	})
-	return indices
+	for _, s := range sorted {
+		dict.lockShard(s, shards[s])
+	}
 }
 
 // RWLocks locks write keys and read keys together. allow duplicate keys
 func (dict *ConcurrentDict) RWLocks(writeKeys []string, readKeys []string) {
 	keys := append(writeKeys, readKeys...)
-	indices := dict.toLockIndices(keys, false)
-	writeIndexSet := make(map[uint32]struct{})
-	for _, wKey := range writeKeys {
-		idx := dict.spread(wKey)
-		writeIndexSet[idx] = struct{}{}
-	}
-	for _, index := range indices {
-		_, w := writeIndexSet[index]
-		mu := &dict.table[index].mutex
-		if w {
-			mu.Lock()
-		} else {
-			mu.RLock()
+	table := dict.shards()
+	current := make([]*Shard, len(keys))
+	pending := make(map[*Shard]bool)
+	for i, key := range keys {
+		current[i] = table[spreadIn(table, key)]
+		pending[current[i]] = pending[current[i]] || i < len(writeKeys)
+	}
+	// 先锁当前表中的分片，其中已经搬迁的分片再去锁新表中的分片，新表的 sub 更大，仍然是递增的顺序
+	for len(pending) > 0 {
+		dict.lockShards(pending)
+		next := make(map[*Shard]bool)
+		for i, key := range keys {
+			if _, ok := pending[current[i]]; !ok {
+				continue
+			}
+			if moved := current[i].moved.Load(); moved != nil {
+				current[i] = (*moved)[spreadIn(*moved, key)]
+				next[current[i]] = next[current[i]] || i < len(writeKeys)
+			}
 		}
+		for s, w := range pending {
+			if s.moved.Load() != nil {
+				dict.unlockShard(s, w)
+			}
+		}
+		pending = next
 	}
 }
 
 // RWUnLocks unlocks write keys and read keys together. allow duplicate keys
 func (dict *ConcurrentDict) RWUnLocks(writeKeys []string, readKeys []string) {
 	keys := append(writeKeys, readKeys...)
-	indices := dict.toLockIndices(keys, true)
-	writeIndexSet := make(map[uint32]struct{})
-	for _, wKey := range writeKeys {
-		idx := dict.spread(wKey)
-		writeIndexSet[idx] = struct{}{}
-	}
-	for _, index := range indices {
-		_, w := writeIndexSet[index]
-		mu := &dict.table[index].mutex
-		if w {
-			mu.Unlock()
-		} else {
-			mu.RUnlock()
+	// 持有锁期间这些分片不会被搬迁，从当前分片表出发可以找到加锁时的分片
+	held := make(map[*Shard]bool)
+	for i, key := range keys {
+		s := dict.resolve(key)
+		held[s] = held[s] || i < len(writeKeys)
+	}
+	sorted := make([]*Shard, 0, len(held))
+	for s := range held {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].sub > sorted[j].sub
+	})
+	for _, s := range sorted {
+		dict.unlockShard(s, held[s])
+	}
+}
+
+// visitShard 在读锁下把 shards[index] 中的 map 交给 fn，分片已经搬迁时到新表中对应的分片继续，
+// 新表的分片可能还有来自其他旧分片的 key，fn 需要自己按照下标过滤。fn 返回 false 时停止并返回 false
+func visitShard(shards []*Shard, index uint32, fn func(m map[string]interface{}) bool) bool {
+	s := shards[index]
+	s.mutex.RLock()
+	moved := s.moved.Load()
+	if moved == nil {
+		defer s.mutex.RUnlock()
+		return fn(s.m)
+	}
+	s.mutex.RUnlock()
+	next := *moved
+	// 分片数都是 2 的幂，旧分片的 key 只会分到下标低位与它相同的新分片上
+	mask := uint32(min(len(shards), len(next)) - 1)
+	for j := index & mask; j < uint32(len(next)); j += mask + 1 {
+		if !visitShard(next, j, fn) {
+			return false
 		}
 	}
+	return true
+}
+
+// forEachIn 遍历 shards[index] 中的 key，包括已经搬迁到新表中的 key，每个 key 只遍历一次
+func forEachIn(shards []*Shard, index uint32, consumer Consumer) bool {
+	return visitShard(shards, index, func(m map[string]interface{}) bool {
+		for key, value := range m {
+			if spreadIn(shards, key) != index {
+				continue
+			}
+			if !consumer(key, value) {
+				return false
+			}
+		}
+		return true
+	})
 }
 
 func (dict *ConcurrentDict) ForEach(consumer Consumer) {
@@ -224,19 +327,13 @@ func (dict *ConcurrentDict) ForEach(consumer Consumer) {
 		panic("dict is nil")
 
 	}
-	for _, s := range dict.table {
-		s.mutex.RLock()
-		f := func() bool {
-			defer s.mutex.RUnlock()
-			for key, value := range s.m {
-				continues := consumer(key, value)
-				if !continues {
-					return false
-				}
-			}
-			return true
-		}
-		if !f() {
+	// 遍历时逐个分片加锁，检测时整体看作持有所有分片。
+	// 遍历期间发生 Resize 时，旧表中已经搬迁的分片到新表中继续，所以每个 key 只遍历一次
+	dict.lockClass.Acquire(lockorder.All)
+	defer dict.lockClass.Release(lockorder.All)
+	shards := dict.shards()
+	for i := range shards {
+		if !forEachIn(shards, uint32(i), consumer) {
 			break
 		}
 	}
@@ -267,6 +364,13 @@ func (shard *Shard) RandomKey() string {
 		panic("shard is nil")
 	}
 	shard.mutex.RLock()
+	moved := shard.moved.Load()
+	if moved != nil {
+		shard.mutex.RUnlock()
+		// 已经搬迁的分片为空，从新表中随机选一个分片
+		next := *moved
+		return next[rand.Intn(len(next))].RandomKey()
+	}
 	defer shard.mutex.RUnlock()
 
 	for key := range shard.m {
@@ -275,58 +379,75 @@ func (shard *Shard) RandomKey() string {
 	return ""
 }
 
+// Clear 逐个分片清空，分片表保持不变，所以正在进行的 SCAN 的游标仍然有效
 func (dict *ConcurrentDict) Clear() {
-	*dict = *MakeConcurrent(dict.shardCount)
+	if dict == nil {
+		panic("dict is nil")
+	}
+	// 清空期间不替换分片表
+	dict.resizeMu.Lock()
+	defer dict.resizeMu.Unlock()
+	dict.lockClass.Acquire(lockorder.All)
+	defer dict.lockClass.Release(lockorder.All)
+	for _, shard := range dict.shards() {
+		shard.mutex.Lock()
+		n := len(shard.m)
+		shard.m = make(map[string]interface{})
+		shard.mutex.Unlock()
+		atomic.AddInt32(&dict.count, -int32(n))
+	}
 }
 
 func (dict *ConcurrentDict) GetShard(index uint32) *Shard {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	return dict.table[index]
+	return dict.shards()[index]
 }
 
-func (dict *ConcurrentDict) GetWithLock(key string) (val interface{}, exists bool) {
-	// hashCode := fnv32(key)
-	index := dict.spread(key)
-	shard := dict.getShard(index)
-	shard.mutex.RLock()
-	defer shard.mutex.RUnlock()
-	val, exists = shard.m[key]
+// Get returns the binding value and whether the key is exist, it locks the shard for reading
+func (dict *ConcurrentDict) Get(key string) (val interface{}, exists bool) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	s := dict.lockKey(key, false)
+	defer dict.unlockShard(s, false)
+	val, exists = s.m[key]
 	return val, exists
 }
 
-func (dict *ConcurrentDict) PutWithLock(key string, val interface{}) int {
-	// hashCode := fnv32(key)
-	index := dict.spread(key)
-	shard := dict.getShard(index)
-	shard.mutex.Lock()
-	defer shard.mutex.Unlock()
+// Put puts key value into dict and returns the number of new inserted key-value, it locks the shard
+func (dict *ConcurrentDict) Put(key string, val interface{}) int {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	shard := dict.lockKey(key, true)
+	defer dict.unlockShard(shard, true)
 	if _, ok := shard.m[key]; ok {
 		shard.m[key] = val
 		return 0
-	} else {
-		shard.m[key] = val
-		dict.addCount()
-		return 1
 	}
+	shard.m[key] = val
+	dict.addCount()
+	return 1
 }
-func (dict *ConcurrentDict) Get(key string) (interface{}, bool) {
+
+// GetLocked 与 Get 相同但不加锁，调用方必须已经通过 RWLocks 持有 key 所在分片的锁
+func (dict *ConcurrentDict) GetLocked(key string) (interface{}, bool) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	index := dict.spread(key)
-	shard := dict.GetShard(index)
+	shard := dict.resolve(key)
 	val, exists := shard.m[key]
 	return val, exists
 }
 
-func (dict *ConcurrentDict) Put(key string, val interface{}) int {
+// PutLocked 与 Put 相同但不加锁，调用方必须已经通过 RWLocks 持有 key 所在分片的写锁
+func (dict *ConcurrentDict) PutLocked(key string, val interface{}) int {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	index := dict.spread(key)
-	shard := dict.GetShard(index)
+	shard := dict.resolve(key)
 	if _, ok := shard.m[key]; ok {
 		shard.m[key] = val
 		return 0
@@ -335,14 +456,14 @@ func (dict *ConcurrentDict) Put(key string, val interface{}) int {
 	shard.m[key] = val
 	return 1
 }
-func (dict *ConcurrentDict) PutIfExistsWithLock(key string, val interface{}) (result int) {
+
+// PutIfExists puts value if the key is existed and returns the number of inserted key-value, it locks the shard
+func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	index := dict.spread(key)
-	s := dict.getShard(index)
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s := dict.lockKey(key, true)
+	defer dict.unlockShard(s, true)
 
 	if _, ok := s.m[key]; ok {
 		s.m[key] = val
@@ -351,12 +472,12 @@ func (dict *ConcurrentDict) PutIfExistsWithLock(key string, val interface{}) (re
 	return 0
 }
 
-func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int) {
+// PutIfExistsLocked 与 PutIfExists 相同但不加锁，调用方必须已经持有 key 所在分片的写锁
+func (dict *ConcurrentDict) PutIfExistsLocked(key string, val interface{}) (result int) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	index := dict.spread(key)
-	s := dict.getShard(index)
+	s := dict.resolve(key)
 
 	if _, ok := s.m[key]; ok {
 		s.m[key] = val
@@ -365,14 +486,13 @@ func (dict *ConcurrentDict) PutIfExists(key string, val interface{}) (result int
 	return 0
 }
 
-func (dict *ConcurrentDict) PutIfAbsentWithLock(key string, val interface{}) (result int) {
+// PutIfAbsent puts value if the key is not exists and returns the number of updated key-value, it locks the shard
+func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	index := dict.spread(key)
-	s := dict.getShard(index)
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	s := dict.lockKey(key, true)
+	defer dict.unlockShard(s, true)
 
 	if _, ok := s.m[key]; ok {
 		return 0
@@ -382,12 +502,12 @@ func (dict *ConcurrentDict) PutIfAbsentWithLock(key string, val interface{}) (re
 	return 1
 }
 
-func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int) {
+// PutIfAbsentLocked 与 PutIfAbsent 相同但不加锁，调用方必须已经持有 key 所在分片的写锁
+func (dict *ConcurrentDict) PutIfAbsentLocked(key string, val interface{}) (result int) {
 	if dict == nil {
 		panic("dict is nil")
 	}
-	index := dict.spread(key)
-	s := dict.getShard(index)
+	s := dict.resolve(key)
 
 	if _, ok := s.m[key]; ok {
 		return 0
@@ -397,11 +517,26 @@ func (dict *ConcurrentDict) PutIfAbsent(key string, val interface{}) (result int
 	return 1
 }
 
+// DictScan 与 redis 相同使用反向二进制迭代(reverse binary iteration)的游标:
+// 游标的低位是分片下标，每次对下标的二进制逆序加 1，按高位优先的顺序遍历分片。
+// 分片表按 2 的幂次扩大或缩小时，一个分片的 key 只会分到下标低位相同的分片上，
+// 而这些分片在逆序中是连续的，所以整个遍历期间一直存在的 key 至少返回一次，可能重复返回。
+// 游标只取低位，来自分片数不同的字典的游标同样有效，负数游标返回 -1
 func (dict *ConcurrentDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	return dict.DictScanFilter(cursor, count, pattern, nil)
+}
+
+// DictScanFilter 与 DictScan 相同，但只返回 filter 返回 true 的 key，filter 为 nil 时不过滤。
+// filter 在持有分片读锁时调用，不能再访问这个字典。
+// count 按照匹配的 key 计算，过滤掉的 key 不占用 count，过滤条件少见时一页可能遍历较多的分片
+func (dict *ConcurrentDict) DictScanFilter(cursor int, count int, pattern string, filter Consumer) ([][]byte, int) {
 	size := dict.Len()
 	result := make([][]byte, 0)
+	if cursor < 0 {
+		return result, -1
+	}
 
-	if pattern == "*" && count >= size {
+	if cursor == 0 && pattern == "*" && filter == nil && count >= size {
 		return stringsToBytes(dict.Keys()), 0
 	}
 
@@ -410,28 +545,47 @@ func (dict *ConcurrentDict) DictScan(cursor int, count int, pattern string) ([][
 		return result, -1
 	}
 
-	shardCount := len(dict.table)
-	shardIndex := cursor
-
-	for shardIndex < shardCount {
-		shard := dict.table[shardIndex]
-		shard.mutex.RLock()
-		if len(result)+len(shard.m) > count && shardIndex > cursor {
-			shard.mutex.RUnlock()
-			return result, shardIndex
-		}
-
-		for key := range shard.m {
-			if pattern == "*" || matchKey.IsMatch(key) {
-				result = append(result, []byte(key))
+	// 游标按照开始时的分片表计算，遍历期间发生 Resize 时已经搬迁的分片到新表中继续
+	shards := dict.shards()
+	mask := uint32(len(shards) - 1)
+	v := uint32(cursor) & mask
+
+	dict.lockClass.Acquire(lockorder.All)
+	defer dict.lockClass.Release(lockorder.All)
+	// 一个分片的 key 要么全部返回要么全部留给下一页，所以先收集匹配的 key 再决定是否结束这一页
+	var matched [][]byte
+	for first := true; ; first = false {
+		matched = matched[:0]
+		forEachIn(shards, v, func(key string, val interface{}) bool {
+			if pattern != "*" && !matchKey.IsMatch(key) {
+				return true
+			}
+			if filter != nil && !filter(key, val) {
+				return true
 			}
+			matched = append(matched, []byte(key))
+			return true
+		})
+		if len(result)+len(matched) > count && !first {
+			return result, int(v)
+		}
+		result = append(result, matched...)
+		v = nextScanCursor(v, mask)
+		if v == 0 {
+			return result, 0
 		}
-		shard.mutex.RUnlock()
-		shardIndex++
 	}
+}
 
-	return result, 0
+// nextScanCursor 把 mask 以外的位置 1 之后对逆序的游标加 1，进位越过 mask 以外的位，
+// 再逆序回来就是下一个分片下标，回到 0 表示遍历结束
+func nextScanCursor(v, mask uint32) uint32 {
+	v |= ^mask
+	v = bits.Reverse32(v)
+	v++
+	return bits.Reverse32(v)
 }
+
 func stringsToBytes(strSlice []string) [][]byte {
 	byteSlice := make([][]byte, len(strSlice))
 	for i, str := range strSlice {
@@ -447,12 +601,11 @@ func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
 		return dict.Keys()
 	}
 
-	shardCount := len(dict.table)
+	shards := dict.shards()
 	result := make(map[string]struct{})
 	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for len(result) < limit {
-		shardIndex := uint32(nR.Intn(shardCount))
-		s := dict.getShard(shardIndex)
+		s := shards[nR.Intn(len(shards))]
 		if s == nil {
 			continue
 		}
@@ -478,12 +631,12 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 	if limit >= size {
 		return dict.Keys()
 	}
-	shardCount := len(dict.table)
+	shards := dict.shards()
 
 	result := make([]string, limit)
 	nR := rand.New(rand.NewSource(time.Now().UnixNano()))
 	for i := 0; i < limit; {
-		s := dict.getShard(uint32(nR.Intn(shardCount)))
+		s := shards[nR.Intn(len(shards))]
 		if s == nil {
 			continue
 		}
@@ -495,3 +648,99 @@ func (dict *ConcurrentDict) RandomKeys(limit int) []string {
 	}
 	return result
 }
+
+// Clone 逐个分片复制，值与原字典共享
+func (dict *ConcurrentDict) Clone() Dict {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	dict.resizeMu.Lock()
+	defer dict.resizeMu.Unlock()
+	shards := dict.shards()
+	// 与源字典一样每个分片有自己的 sub，多 key 加锁才有固定的顺序
+	table := makeShards(len(shards), uint32(shards[0].sub>>32))
+	var count int32
+	dict.lockClass.Acquire(lockorder.All)
+	defer dict.lockClass.Release(lockorder.All)
+	for i, s := range shards {
+		s.mutex.RLock()
+		m := make(map[string]interface{}, len(s.m))
+		for k, v := range s.m {
+			m[k] = v
+		}
+		s.mutex.RUnlock()
+		table[i].m = m
+		count += int32(len(m))
+	}
+	clone := &ConcurrentDict{
+		count:     count,
+		lockClass: lockorder.NewClass("dict.shard"),
+	}
+	clone.table.Store(&table)
+	clone.maxLoad.Store(dict.maxLoad.Load())
+	return clone
+}
+
+// SetMaxLoad enables online resizing: when the average number of keys per shard exceeds maxLoad,
+// the shard count is doubled in background (up to 65536 shards). maxLoad <= 0 disables it
+func (dict *ConcurrentDict) SetMaxLoad(maxLoad int) {
+	dict.maxLoad.Store(int32(max(maxLoad, 0)))
+}
+
+// maybeGrow 在后台扩容，调用方可能持有分片锁，不能在这里等待全部分片的锁
+func (dict *ConcurrentDict) maybeGrow(count int, maxLoad int) {
+	n := dict.ShardCount()
+	if n >= maxShards || count <= n*maxLoad || !dict.resizing.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer dict.resizing.Store(false)
+		// 扩容期间写入的 key 可能又超过了负载，一直翻倍到满足为止
+		for {
+			n := dict.ShardCount()
+			maxLoad := int(dict.maxLoad.Load())
+			if n >= maxShards || maxLoad <= 0 || dict.Len() <= n*maxLoad {
+				return
+			}
+			dict.Resize(n * 2)
+		}
+	}()
+}
+
+// Resize 把分片数调整为 shardCount(按 normalizeShardCount 取整)。
+// 与 SimpleDict 的渐进式 rehash 相同，逐个分片把 key 搬到新的分片表，每次只持有一个旧分片和它对应的新分片的写锁，
+// 搬迁完的旧分片清空并通过 moved 指向新表，其他分片的读写不受影响。全部搬迁完之后替换分片表。
+// 与 DictScan 相同分片数都是 2 的幂，替换前后的 SCAN 游标都有效。调用方不能持有这个字典的分片锁
+func (dict *ConcurrentDict) Resize(shardCount int) {
+	if dict == nil {
+		panic("dict is nil")
+	}
+	shardCount = normalizeShardCount(shardCount)
+	dict.resizeMu.Lock()
+	defer dict.resizeMu.Unlock()
+	old := dict.shards()
+	if len(old) == shardCount {
+		return
+	}
+	shards := makeShards(shardCount, uint32(old[0].sub>>32)+1)
+	mask := uint32(min(len(old), len(shards)) - 1)
+	for i, s := range old {
+		// 旧分片的 key 只会分到下标低位与它相同的新分片上，按 sub 递增加锁
+		dict.lockShard(s, true)
+		var targets []*Shard
+		for j := uint32(i) & mask; j < uint32(len(shards)); j += mask + 1 {
+			dict.lockShard(shards[j], true)
+			targets = append(targets, shards[j])
+		}
+		for k, v := range s.m {
+			shards[spreadIn(shards, k)].m[k] = v
+		}
+		s.m = make(map[string]interface{})
+		s.moved.Store(&shards)
+		for j := len(targets) - 1; j >= 0; j-- {
+			dict.unlockShard(targets[j], true)
+		}
+		dict.unlockShard(s, true)
+	}
+	dict.table.Store(&shards)
+}
@@ -0,0 +1,380 @@
+package dict
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// scanFrom 从 cursor 开始在 dict 上遍历 rounds 次(rounds < 0 表示直到结束)，返回下一个游标
+func scanFrom(dict *ConcurrentDict, cursor int, rounds int, seen map[string]bool) int {
+	for i := 0; rounds < 0 || i < rounds; i++ {
+		keys, next := dict.DictScan(cursor, 10, "*")
+		for _, key := range keys {
+			seen[string(key)] = true
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	return cursor
+}
+
+func TestDictScanAcrossResize(t *testing.T) {
+	const n = 2000
+	fill := func(shardCount int) *ConcurrentDict {
+		d := MakeConcurrent(shardCount)
+		for i := 0; i < n; i++ {
+			d.Put("k"+strconv.Itoa(i), i)
+		}
+		return d
+	}
+	// 遍历到一半时换成分片数不同的字典继续遍历，每个 key 都至少返回一次
+	for _, sizes := range [][2]int{{16, 64}, {64, 16}, {256, 16}, {16, 1024}} {
+		seen := make(map[string]bool)
+		cursor := scanFrom(fill(sizes[0]), 0, 5, seen)
+		if cursor == 0 {
+			t.Fatalf("%v: scan finished too early", sizes)
+		}
+		scanFrom(fill(sizes[1]), cursor, -1, seen)
+		for i := 0; i < n; i++ {
+			if !seen["k"+strconv.Itoa(i)] {
+				t.Fatalf("%v: missed k%d", sizes, i)
+			}
+		}
+	}
+}
+
+func TestDictScanAcrossClear(t *testing.T) {
+	d := MakeConcurrent(64)
+	for i := 0; i < 1000; i++ {
+		d.Put("old"+strconv.Itoa(i), i)
+	}
+	seen := make(map[string]bool)
+	cursor := scanFrom(d, 0, 5, seen)
+	d.Clear()
+	if d.Len() != 0 {
+		t.Fatalf("expected empty dict after Clear, got %d", d.Len())
+	}
+	for i := 0; i < 1000; i++ {
+		d.Put("new"+strconv.Itoa(i), i)
+	}
+	// 原来的游标仍然可以继续遍历到结束，之后的完整遍历返回所有的 key
+	if next := scanFrom(d, cursor, -1, seen); next != 0 {
+		t.Fatalf("expected scan to end, got cursor %d", next)
+	}
+	seen = make(map[string]bool)
+	scanFrom(d, 0, -1, seen)
+	if len(seen) != 1000 {
+		t.Fatalf("expected 1000 keys, got %d", len(seen))
+	}
+}
+
+// 不持有分片锁时 Get/Put/Remove 可以并发调用，用 -race 运行
+func TestConcurrentGetPut(t *testing.T) {
+	d := MakeConcurrent(16)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := "k" + strconv.Itoa(i%100)
+				switch (g + i) % 4 {
+				case 0:
+					d.Put(key, i)
+				case 1:
+					d.PutIfAbsent(key, i)
+				case 2:
+					d.Remove(key)
+				default:
+					d.Get(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	n := 0
+	d.ForEach(func(key string, val interface{}) bool {
+		n++
+		return true
+	})
+	if n != d.Len() {
+		t.Errorf("expected len %d, actually %d", n, d.Len())
+	}
+}
+
+func TestShardCountStable(t *testing.T) {
+	for _, c := range [][2]int{{1, 1}, {0, 16}, {10, 16}, {100, 128}, {1024, 1024}} {
+		d := MakeConcurrent(c[0])
+		for i := 0; i < 100; i++ {
+			d.Put("k"+strconv.Itoa(i), i)
+		}
+		d.Clear()
+		if d.ShardCount() != c[1] {
+			t.Errorf("MakeConcurrent(%d): expected %d shards after Clear, actually %d", c[0], c[1], d.ShardCount())
+		}
+		if d.Clone().(*ConcurrentDict).ShardCount() != c[1] {
+			t.Errorf("MakeConcurrent(%d): clone has a different shard count", c[0])
+		}
+	}
+}
+
+// TestCloneLockOrder 克隆的分片和源字典一样按下标排列加锁顺序，多 key 加锁不会死锁
+func TestCloneLockOrder(t *testing.T) {
+	d := MakeConcurrent(16)
+	for i := 0; i < 100; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	d.Resize(64)
+	clone := d.Clone().(*ConcurrentDict)
+	if clone.Len() != d.Len() {
+		t.Fatalf("expected %d keys in clone, actually %d", d.Len(), clone.Len())
+	}
+	src, shards := d.shards(), clone.shards()
+	for i, s := range shards {
+		if s.sub != src[i].sub {
+			t.Fatalf("shard %d: expected sub %x, actually %x", i, src[i].sub, s.sub)
+		}
+	}
+
+	keys := make([]string, 100)
+	for i := range keys {
+		keys[i] = "k" + strconv.Itoa(i)
+	}
+	reversed := make([]string, len(keys))
+	for i, key := range keys {
+		reversed[len(keys)-1-i] = key
+	}
+	var wg sync.WaitGroup
+	for _, order := range [][]string{keys, reversed} {
+		wg.Add(1)
+		go func(order []string) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				clone.RWLocks(order, nil)
+				clone.RWUnLocks(order, nil)
+			}
+		}(order)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("multi-key locks on the clone deadlocked")
+	}
+}
+
+func TestResize(t *testing.T) {
+	const n = 2000
+	d := MakeConcurrent(16)
+	for i := 0; i < n; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	// 扩容和缩容都不丢 key，遍历中途改变分片数时游标仍然有效
+	for _, size := range []int{256, 16, 1, 64} {
+		seen := make(map[string]bool)
+		cursor := scanFrom(d, 0, 3, seen)
+		d.Resize(size)
+		if d.ShardCount() != normalizeShardCount(size) || d.Len() != n {
+			t.Fatalf("Resize(%d): shards %d, len %d", size, d.ShardCount(), d.Len())
+		}
+		scanFrom(d, cursor, -1, seen)
+		for i := 0; i < n; i++ {
+			key := "k" + strconv.Itoa(i)
+			if !seen[key] {
+				t.Fatalf("Resize(%d): scan missed %s", size, key)
+			}
+			if val, ok := d.Get(key); !ok || val != i {
+				t.Fatalf("Resize(%d): expected %s=%d, actually %v", size, key, i, val)
+			}
+		}
+	}
+}
+
+// Resize 逐个分片搬迁，搬迁期间只锁住正在搬迁的分片，其他分片照常读写
+func TestResizeIncremental(t *testing.T) {
+	d := MakeConcurrent(16)
+	orig := d.shards()
+	var blocker string
+	var others []string
+	for i := 0; i < 1000; i++ {
+		key := "k" + strconv.Itoa(i)
+		d.Put(key, i)
+		if spreadIn(orig, key) != 8 {
+			others = append(others, key)
+		} else if blocker == "" {
+			blocker = key
+		}
+	}
+	// 锁住第 8 个分片，Resize 搬迁到这里时停住，前面的分片已经搬到新表，后面的还在旧表
+	d.RWLocks([]string{blocker}, nil)
+	done := make(chan struct{})
+	go func() {
+		d.Resize(256)
+		close(done)
+	}()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for _, key := range others {
+			if _, ok := d.Get(key); !ok {
+				t.Errorf("missed %s", key)
+			}
+			d.Put(key, key)
+		}
+		keys := []string{others[0], others[1], others[len(others)-1]}
+		d.RWLocks(keys[:1], keys[1:])
+		d.PutLocked(keys[0], "new")
+		d.RWUnLocks(keys[:1], keys[1:])
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resize blocked other shards")
+	}
+	if d.ShardCount() != 16 {
+		t.Fatal("resize finished while a shard is locked")
+	}
+	d.RWUnLocks([]string{blocker}, nil)
+	<-done
+	if d.ShardCount() != 256 || d.Len() != 1000 {
+		t.Fatalf("shards %d, len %d", d.ShardCount(), d.Len())
+	}
+	if val, _ := d.Get(others[0]); val != "new" {
+		t.Errorf("lost write during resize, %s=%v", others[0], val)
+	}
+	if val, _ := d.Get(others[1]); val != others[1] {
+		t.Errorf("lost write during resize, %s=%v", others[1], val)
+	}
+}
+
+// 遍历与 Resize 同时进行时，遍历开始前就存在的 key 由 ForEach 返回恰好一次，由 DictScan 至少返回一次
+func TestIterateDuringResize(t *testing.T) {
+	const n = 5000
+	d := MakeConcurrent(64)
+	for i := 0; i < n; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	for _, size := range []int{1024, 16, 256, 1, 64} {
+		done := make(chan struct{})
+		go func() {
+			d.Resize(size)
+			close(done)
+		}()
+		counts := make(map[string]int)
+		d.ForEach(func(key string, val interface{}) bool {
+			counts[key]++
+			runtime.Gosched()
+			return true
+		})
+		seen := make(map[string]bool)
+		scanFrom(d, 0, -1, seen)
+		<-done
+		for i := 0; i < n; i++ {
+			key := "k" + strconv.Itoa(i)
+			if counts[key] != 1 {
+				t.Fatalf("Resize(%d): ForEach returned %s %d times", size, key, counts[key])
+			}
+			if !seen[key] {
+				t.Fatalf("Resize(%d): scan missed %s", size, key)
+			}
+		}
+	}
+}
+
+// 自动扩容与并发读写、RWLocks 同时进行，用 -race 运行
+func TestAutoGrow(t *testing.T) {
+	d := MakeConcurrent(16)
+	d.SetMaxLoad(4)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := "k" + strconv.Itoa(g*1000+i)
+				if i%2 == 0 {
+					d.Put(key, i)
+					continue
+				}
+				keys := []string{key, "k" + strconv.Itoa(g*1000+i-1)}
+				d.RWLocks(keys[:1], keys[1:])
+				d.PutLocked(key, i)
+				if _, ok := d.GetLocked(keys[1]); !ok {
+					t.Errorf("missed %s", keys[1])
+				}
+				d.RWUnLocks(keys[:1], keys[1:])
+			}
+		}(g)
+	}
+	wg.Wait()
+	if d.Len() != 8000 {
+		t.Fatalf("expected len 8000, actually %d", d.Len())
+	}
+	// 等后台扩容结束
+	for d.resizing.Load() {
+		runtime.Gosched()
+	}
+	// 最后几次写入可能发生在后台扩容的最后一次检查之后
+	if d.ShardCount() < 8000/4/2 {
+		t.Errorf("expected at least %d shards, actually %d", 8000/4/2, d.ShardCount())
+	}
+	for i := 0; i < 8000; i++ {
+		if _, ok := d.Get("k" + strconv.Itoa(i)); !ok {
+			t.Fatalf("missed k%d", i)
+		}
+	}
+}
+
+func makeBenchDict() (*ConcurrentDict, []string) {
+	d := MakeConcurrent(1024)
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+		d.Put(keys[i], i)
+	}
+	return d, keys
+}
+
+// BenchmarkGet 多个 goroutine 并发读，Get 每次加分片读锁
+func BenchmarkGet(b *testing.B) {
+	d, keys := makeBenchDict()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			d.Get(keys[i%len(keys)])
+		}
+	})
+}
+
+// BenchmarkGetLocked 作为对照，只读时不加锁的 GetLocked
+func BenchmarkGetLocked(b *testing.B) {
+	d, keys := makeBenchDict()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			d.GetLocked(keys[i%len(keys)])
+		}
+	})
+}
+
+// BenchmarkGetWithWrites 并发读写，每 16 次操作有一次 Put
+func BenchmarkGetWithWrites(b *testing.B) {
+	d, keys := makeBenchDict()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			key := keys[i%len(keys)]
+			if i%16 == 0 {
+				d.Put(key, i)
+			} else {
+				d.Get(key)
+			}
+		}
+	})
+}
@@ -18,4 +18,5 @@ type Dict interface {
 	RandomDistinctKeys(limit int) []string
 	Clear()
 	DictScan(cursor int, count int, pattern string) ([][]byte, int)
+	Clone() Dict
 }
@@ -0,0 +1,31 @@
+package dict
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/zhangming/go-redis/lib/siphash"
+)
+
+// 分片的哈希函数
+// key 由客户端决定，fnv32 没有密钥，攻击者可以离线算出落在同一个分片的大量 key，
+// 让所有请求争用同一把分片锁(hash flooding)。默认使用以启动时随机生成的密钥为种子的 SipHash，
+// 不知道密钥就无法预测 key 落在哪个分片。
+// 哈希函数在编译时选择: 使用 -tags dictfnv 构建时换回 fnv32，分片分布在每次启动时都相同，便于调试和复现问题
+
+// sipKey 在进程启动时随机生成，同一进程中所有字典共用
+var sipKey0, sipKey1 = randomSipKey()
+
+func randomSipKey() (uint64, uint64) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		panic("cannot generate hash seed: " + err.Error())
+	}
+	return binary.LittleEndian.Uint64(buf[:8]), binary.LittleEndian.Uint64(buf[8:])
+}
+
+// sipHash32 把 64 位的 SipHash 折叠为 32 位
+func sipHash32(key string) uint32 {
+	h := siphash.Sum64(sipKey0, sipKey1, key)
+	return uint32(h) ^ uint32(h>>32)
+}
@@ -0,0 +1,8 @@
+//go:build !dictfnv
+
+package dict
+
+// hashKey 是分片使用的哈希函数
+func hashKey(key string) uint32 {
+	return sipHash32(key)
+}
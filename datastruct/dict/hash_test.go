@@ -0,0 +1,81 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+const benchShards = 16
+
+// collidingKeys 找出 fnv32 下全部落在 0 号分片的 key，模拟攻击者构造的请求
+func collidingKeys(n int) []string {
+	keys := make([]string, 0, n)
+	for i := 0; len(keys) < n; i++ {
+		key := "key:" + strconv.Itoa(i)
+		if fnv32(key)&(benchShards-1) == 0 {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// maxShardLoad 返回最大分片的 key 数与平均值之比，1 表示完全均匀
+func maxShardLoad(hash func(string) uint32, keys []string) float64 {
+	var counts [benchShards]int
+	for _, key := range keys {
+		counts[hash(key)&(benchShards-1)]++
+	}
+	maxCount := 0
+	for _, c := range counts {
+		maxCount = max(maxCount, c)
+	}
+	return float64(maxCount) / (float64(len(keys)) / benchShards)
+}
+
+func TestSipHashDistribution(t *testing.T) {
+	keys := collidingKeys(16000)
+	if load := maxShardLoad(fnv32, keys); load != benchShards {
+		t.Fatalf("expected all keys in one shard with fnv32, got load %.2f", load)
+	}
+	// 构造的 key 在 SipHash 下仍然均匀分布
+	if load := maxShardLoad(sipHash32, keys); load > 1.2 {
+		t.Errorf("siphash max shard load %.2f", load)
+	}
+	if sipHash32("a") != sipHash32("a") || sipHash32("a") == sipHash32("b") {
+		t.Error("unexpected siphash result")
+	}
+}
+
+// 比较两种哈希函数的吞吐量和分布:
+// go test -run ^$ -bench Hash ./datastruct/dict/
+func BenchmarkHash(b *testing.B) {
+	random := make([]string, 1024)
+	for i := range random {
+		random[i] = "user:session:" + strconv.Itoa(i*7919)
+	}
+	adversarial := collidingKeys(1024)
+	for _, h := range []struct {
+		name string
+		fn   func(string) uint32
+	}{
+		{"fnv32", fnv32},
+		{"siphash", sipHash32},
+	} {
+		for _, ks := range []struct {
+			name string
+			keys []string
+		}{
+			{"random", random},
+			{"adversarial", adversarial},
+		} {
+			b.Run(h.name+"/"+ks.name, func(b *testing.B) {
+				var sink uint32
+				for i := 0; i < b.N; i++ {
+					sink ^= h.fn(ks.keys[i&1023])
+				}
+				_ = sink
+				b.ReportMetric(maxShardLoad(h.fn, ks.keys), "max-shard-load")
+			})
+		}
+	}
+}
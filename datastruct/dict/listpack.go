@@ -0,0 +1,256 @@
+package dict
+
+import (
+	"math/rand"
+	"slices"
+
+	"github.com/zhangming/go-redis/lib/wildcard"
+)
+
+// listpack 编码
+// 与 redis 的 listpack 类似，小的哈希按插入顺序保存在一个切片中，查找时顺序比较，省去 map 的桶和每个 key 的哈希开销。
+// 字段数超过 hash-max-listpack-entries 或者字段名、值的长度超过 hash-max-listpack-value 时转换为 SimpleDict，之后不再转换回来。
+// 与 SimpleDict 相同不是并发安全的
+
+const (
+	defaultListPackEntries = 128
+	defaultListPackValue   = 64
+)
+
+type listPackEntry struct {
+	key string
+	val interface{}
+}
+
+// ListPackDict is a dict for small hashes, it keeps fields in a slice until they exceed the listpack limits
+type ListPackDict struct {
+	// 转换之后为 nil
+	entries []listPackEntry
+	m       *SimpleDict
+	// 转换的阈值，创建时确定，之后不随配置变化
+	maxEntries int
+	maxValue   int
+}
+
+// MakeListPack makes a new listpack encoded dict with the default limits 128 and 64
+func MakeListPack() *ListPackDict {
+	return MakeListPackWithLimits(0, 0)
+}
+
+// MakeListPackWithLimits makes a new listpack encoded dict which converts when it has more than entries fields
+// or a field name or value is longer than value, non-positive values use the defaults 128 and 64
+func MakeListPackWithLimits(entries int, value int) *ListPackDict {
+	if entries <= 0 {
+		entries = defaultListPackEntries
+	}
+	if value <= 0 {
+		value = defaultListPackValue
+	}
+	return &ListPackDict{maxEntries: entries, maxValue: value}
+}
+
+// IsListPack returns whether the dict is still listpack encoded
+func (dict *ListPackDict) IsListPack() bool {
+	return dict.m == nil
+}
+
+func (dict *ListPackDict) index(key string) int {
+	for i := range dict.entries {
+		if dict.entries[i].key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// fits 判断加入 key 和 val 之后是否仍然可以使用 listpack 编码，只有 []byte 的值计算长度
+func (dict *ListPackDict) fits(size int, key string, val interface{}) bool {
+	if size > dict.maxEntries || len(key) > dict.maxValue {
+		return false
+	}
+	bytes, _ := val.([]byte)
+	return len(bytes) <= dict.maxValue
+}
+
+func (dict *ListPackDict) convert() {
+	m := MakeSimple()
+	for _, entry := range dict.entries {
+		m.Put(entry.key, entry.val)
+	}
+	dict.m = m
+	dict.entries = nil
+}
+
+// Get returns the binding value and whether the key is exist
+func (dict *ListPackDict) Get(key string) (val interface{}, exists bool) {
+	if dict.m != nil {
+		return dict.m.Get(key)
+	}
+	if i := dict.index(key); i >= 0 {
+		return dict.entries[i].val, true
+	}
+	return nil, false
+}
+
+// Len returns the number of dict
+func (dict *ListPackDict) Len() int {
+	if dict.m != nil {
+		return dict.m.Len()
+	}
+	return len(dict.entries)
+}
+
+// put 写入 key，exists 表示 key 原来是否存在，update/insert 表示是否覆盖已有的值和是否新增
+func (dict *ListPackDict) put(key string, val interface{}, update bool, insert bool) (exists bool) {
+	i := dict.index(key)
+	exists = i >= 0
+	if (exists && !update) || (!exists && !insert) {
+		return exists
+	}
+	size := len(dict.entries)
+	if !exists {
+		size++
+	}
+	if !dict.fits(size, key, val) {
+		dict.convert()
+		dict.m.Put(key, val)
+		return exists
+	}
+	if exists {
+		dict.entries[i].val = val
+	} else {
+		dict.entries = append(dict.entries, listPackEntry{key: key, val: val})
+	}
+	return exists
+}
+
+// Put puts key value into dict and returns the number of new inserted key-value
+func (dict *ListPackDict) Put(key string, val interface{}) (result int) {
+	if dict.m != nil {
+		return dict.m.Put(key, val)
+	}
+	if dict.put(key, val, true, true) {
+		return 0
+	}
+	return 1
+}
+
+// PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
+func (dict *ListPackDict) PutIfAbsent(key string, val interface{}) (result int) {
+	if dict.m != nil {
+		return dict.m.PutIfAbsent(key, val)
+	}
+	if dict.put(key, val, false, true) {
+		return 0
+	}
+	return 1
+}
+
+// PutIfExists puts value if the key is existed and returns the number of inserted key-value
+func (dict *ListPackDict) PutIfExists(key string, val interface{}) (result int) {
+	if dict.m != nil {
+		return dict.m.PutIfExists(key, val)
+	}
+	if dict.put(key, val, true, false) {
+		return 1
+	}
+	return 0
+}
+
+// Remove removes the key and return the number of deleted key-value
+func (dict *ListPackDict) Remove(key string) (val interface{}, result int) {
+	if dict.m != nil {
+		return dict.m.Remove(key)
+	}
+	i := dict.index(key)
+	if i < 0 {
+		return nil, 0
+	}
+	val = dict.entries[i].val
+	// 保持其余字段的插入顺序
+	dict.entries = slices.Delete(dict.entries, i, i+1)
+	return val, 1
+}
+
+// Keys returns all keys in dict
+func (dict *ListPackDict) Keys() []string {
+	if dict.m != nil {
+		return dict.m.Keys()
+	}
+	result := make([]string, len(dict.entries))
+	for i, entry := range dict.entries {
+		result[i] = entry.key
+	}
+	return result
+}
+
+// ForEach traversal the dict, listpack encoded dicts are visited in insertion order
+func (dict *ListPackDict) ForEach(consumer Consumer) {
+	if dict.m != nil {
+		dict.m.ForEach(consumer)
+		return
+	}
+	for _, entry := range dict.entries {
+		if !consumer(entry.key, entry.val) {
+			break
+		}
+	}
+}
+
+// RandomKeys randomly returns keys of the given number, may contain duplicated key
+func (dict *ListPackDict) RandomKeys(limit int) []string {
+	if dict.m != nil {
+		return dict.m.RandomKeys(limit)
+	}
+	if len(dict.entries) == 0 {
+		return []string{}
+	}
+	result := make([]string, limit)
+	for i := range result {
+		result[i] = dict.entries[rand.Intn(len(dict.entries))].key
+	}
+	return result
+}
+
+// RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
+func (dict *ListPackDict) RandomDistinctKeys(limit int) []string {
+	if dict.m != nil {
+		return dict.m.RandomDistinctKeys(limit)
+	}
+	limit = min(limit, len(dict.entries))
+	result := make([]string, limit)
+	for i, j := range rand.Perm(len(dict.entries))[:limit] {
+		result[i] = dict.entries[j].key
+	}
+	return result
+}
+
+// Clear removes all keys in dict, the dict returns to listpack encoding and keeps its limits
+func (dict *ListPackDict) Clear() {
+	*dict = ListPackDict{maxEntries: dict.maxEntries, maxValue: dict.maxValue}
+}
+
+func (dict *ListPackDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
+	if dict.m != nil {
+		return dict.m.DictScan(cursor, count, pattern)
+	}
+	result := make([][]byte, 0)
+	matchKey, err := wildcard.CompilePattern(pattern)
+	if err != nil {
+		return result, -1
+	}
+	for _, entry := range dict.entries {
+		if pattern == "*" || matchKey.IsMatch(entry.key) {
+			result = append(result, []byte(entry.key), entry.val.([]byte))
+		}
+	}
+	return result, 0
+}
+
+// Clone returns a copy of the dict in the same encoding, values are shared with the original one
+func (dict *ListPackDict) Clone() Dict {
+	if dict.m != nil {
+		return &ListPackDict{m: dict.m.Clone().(*SimpleDict), maxEntries: dict.maxEntries, maxValue: dict.maxValue}
+	}
+	return &ListPackDict{entries: slices.Clone(dict.entries), maxEntries: dict.maxEntries, maxValue: dict.maxValue}
+}
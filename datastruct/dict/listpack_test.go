@@ -0,0 +1,61 @@
+package dict
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListPackDict(t *testing.T) {
+	d := MakeListPackWithLimits(4, 8)
+	for i := 3; i >= 0; i-- {
+		d.Put("f"+strconv.Itoa(i), []byte("v"))
+	}
+	if !d.IsListPack() || d.Len() != 4 {
+		t.Fatalf("expected listpack of 4 fields, actually listpack=%v len=%d", d.IsListPack(), d.Len())
+	}
+	// 保持插入顺序
+	if keys := d.Keys(); !slices.Equal(keys, []string{"f3", "f2", "f1", "f0"}) {
+		t.Errorf("unexpected keys %v", keys)
+	}
+	if d.PutIfAbsent("f1", []byte("x")) != 0 || d.PutIfExists("f9", []byte("x")) != 0 {
+		t.Error("unexpected put result")
+	}
+	if _, ok := d.Remove("f2"); ok != 1 {
+		t.Error("expected f2 removed")
+	}
+
+	c := d.Clone()
+	d.Put("f1", []byte(strings.Repeat("x", 9)))
+	if d.IsListPack() {
+		t.Fatal("expected hashtable encoding after long value")
+	}
+	if val, _ := d.Get("f1"); len(val.([]byte)) != 9 || d.Len() != 3 {
+		t.Errorf("unexpected value %q or len %d", val, d.Len())
+	}
+	if val, _ := c.Get("f1"); string(val.([]byte)) != "v" {
+		t.Error("clone should be independent of the original")
+	}
+
+	c.Put("f5", []byte("v"))
+	c.Put("f6", []byte("v"))
+	if c.(*ListPackDict).IsListPack() || c.Len() != 5 {
+		t.Error("expected hashtable encoding after exceeding entries")
+	}
+	d.Clear()
+	if !d.IsListPack() || d.Len() != 0 {
+		t.Error("expected empty listpack after clear")
+	}
+	// 清空后保留阈值，重新写入时仍为 listpack 编码
+	d.Put("f1", []byte("v"))
+	if !d.IsListPack() || d.Len() != 1 {
+		t.Errorf("expected listpack of 1 field after clear and put, actually listpack=%v len=%d", d.IsListPack(), d.Len())
+	}
+	for i := 0; i < 4; i++ {
+		d.Put("g"+strconv.Itoa(i), []byte("v"))
+	}
+	if d.IsListPack() {
+		t.Error("expected hashtable encoding after exceeding entries following clear")
+	}
+}
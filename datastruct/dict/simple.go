@@ -1,38 +1,163 @@
 package dict
 
-import "github.com/zhangming/go-redis/lib/wildcard"
+import (
+	"math/bits"
+	"math/rand"
+	"sync/atomic"
 
-// SimpleDict wraps a map, it is not thread safe
+	"github.com/zhangming/go-redis/lib/wildcard"
+)
+
+// SimpleDict 是与 redis dict.c 相同的两张表渐进式 rehash 的哈希表
+// 桶数是 2 的幂，每个桶是一个小切片。元素数达到桶数时开始扩容到两倍以上，不到桶数的 1/8 时缩容，
+// 扩缩容时新建 tables[1]，之后每次写入或删除顺带把 tables[0] 的一个桶搬到 tables[1]，搬完后交换，
+// 不会因为一次扩容在一条命令里复制整个大哈希，旧表也不会像 map 那样只增不减。
+// 与 redis 不同，读命令持有读锁时可能并发执行，所以读操作不搬迁，也不修改字典的任何字段(ForEach 的计数除外，它是原子的)。
+// 两张表都按哈希值的低位分桶，DictScan 使用反向二进制迭代的游标，扩缩容前后游标都有效，
+// 遍历期间一直存在的 key 至少返回一次
+
+const (
+	simpleInitSize = 4
+	// 每次搬迁最多跳过的空桶数，避免一次写入扫描大量空桶
+	simpleEmptyVisits = 10
+)
+
+type simpleEntry struct {
+	key string
+	val interface{}
+}
+
+// SimpleDict is a hash table with incremental rehashing, it is not thread safe
 type SimpleDict struct {
-	m map[string]interface{}
+	// tables[1] 只在 rehash 期间不为 nil
+	tables [2][][]simpleEntry
+	used   [2]int
+	// 下一个要搬迁的 tables[0] 的桶，不在 rehash 时为 -1
+	rehashIdx int
+	// 大于 0 时有进行中的 ForEach，暂停搬迁，并发读时会同时修改所以用原子操作
+	iterators int32
 }
 
-// MakeSimple makes a new map
+// MakeSimple makes a new dict
 func MakeSimple() *SimpleDict {
-	return &SimpleDict{
-		m: make(map[string]interface{}),
+	return &SimpleDict{rehashIdx: -1}
+}
+
+func (dict *SimpleDict) isRehashing() bool {
+	return dict.rehashIdx >= 0
+}
+
+// rehashStep 搬迁 tables[0] 中的一个非空桶
+func (dict *SimpleDict) rehashStep() {
+	if !dict.isRehashing() || atomic.LoadInt32(&dict.iterators) > 0 {
+		return
+	}
+	old := dict.tables[0]
+	for visits := 0; dict.rehashIdx < len(old) && len(old[dict.rehashIdx]) == 0; visits++ {
+		if visits == simpleEmptyVisits {
+			return
+		}
+		dict.rehashIdx++
+	}
+	if dict.rehashIdx < len(old) {
+		mask := uint32(len(dict.tables[1]) - 1)
+		for _, entry := range old[dict.rehashIdx] {
+			i := hashKey(entry.key) & mask
+			dict.tables[1][i] = append(dict.tables[1][i], entry)
+		}
+		dict.used[1] += len(old[dict.rehashIdx])
+		dict.used[0] -= len(old[dict.rehashIdx])
+		old[dict.rehashIdx] = nil
+		dict.rehashIdx++
+	}
+	if dict.rehashIdx >= len(old) {
+		dict.tables[0], dict.used[0] = dict.tables[1], dict.used[1]
+		dict.tables[1], dict.used[1] = nil, 0
+		dict.rehashIdx = -1
 	}
 }
 
+// resize 开始 rehash 到能容纳 size 个元素的表
+func (dict *SimpleDict) resize(size int) {
+	n := simpleInitSize
+	if size > n {
+		n = 1 << bits.Len(uint(size-1))
+	}
+	if n == len(dict.tables[0]) {
+		return
+	}
+	if dict.tables[0] == nil {
+		dict.tables[0] = make([][]simpleEntry, n)
+		return
+	}
+	dict.tables[1] = make([][]simpleEntry, n)
+	dict.rehashIdx = 0
+}
+
+// find 返回 key 所在的表、桶和在桶中的位置，不存在时 pos 为 -1
+func (dict *SimpleDict) find(key string) (table int, bucket uint32, pos int) {
+	if dict.Len() == 0 {
+		return 0, 0, -1
+	}
+	h := hashKey(key)
+	for table = 0; table < 2; table++ {
+		if dict.tables[table] == nil {
+			break
+		}
+		bucket = h & uint32(len(dict.tables[table])-1)
+		for pos = range dict.tables[table][bucket] {
+			if dict.tables[table][bucket][pos].key == key {
+				return table, bucket, pos
+			}
+		}
+	}
+	return 0, 0, -1
+}
+
 // Get returns the binding value and whether the key is exist
 func (dict *SimpleDict) Get(key string) (val interface{}, exists bool) {
-	val, ok := dict.m[key]
-	return val, ok
+	table, bucket, pos := dict.find(key)
+	if pos < 0 {
+		return nil, false
+	}
+	return dict.tables[table][bucket][pos].val, true
 }
 
 // Len returns the number of dict
 func (dict *SimpleDict) Len() int {
-	if dict.m == nil {
-		panic("m is nil")
+	return dict.used[0] + dict.used[1]
+}
+
+// put 写入 key，exists 表示 key 原来是否存在，update/insert 表示是否覆盖已有的值和是否新增
+func (dict *SimpleDict) put(key string, val interface{}, update bool, insert bool) (exists bool) {
+	dict.rehashStep()
+	table, bucket, pos := dict.find(key)
+	if pos >= 0 {
+		if update {
+			dict.tables[table][bucket][pos].val = val
+		}
+		return true
+	}
+	if !insert {
+		return false
+	}
+	if !dict.isRehashing() && dict.used[0] >= len(dict.tables[0]) {
+		dict.resize(2 * (dict.used[0] + 1))
 	}
-	return len(dict.m)
+	// rehash 期间新的 key 直接写入新表
+	table = 0
+	if dict.isRehashing() {
+		table = 1
+	}
+	bucket = hashKey(key) & uint32(len(dict.tables[table])-1)
+	dict.tables[table][bucket] = append(dict.tables[table][bucket], simpleEntry{key: key, val: val})
+	dict.used[table]++
+	return false
 }
 
 // Put puts key value into dict and returns the number of new inserted key-value
 func (dict *SimpleDict) Put(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
-	dict.m[key] = val
-	if existed {
+	if dict.put(key, val, true, true) {
 		return 0
 	}
 	return 1
@@ -40,19 +165,15 @@ func (dict *SimpleDict) Put(key string, val interface{}) (result int) {
 
 // PutIfAbsent puts value if the key is not exists and returns the number of updated key-value
 func (dict *SimpleDict) PutIfAbsent(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
-	if existed {
+	if dict.put(key, val, false, true) {
 		return 0
 	}
-	dict.m[key] = val
 	return 1
 }
 
 // PutIfExists puts value if the key is existed and returns the number of inserted key-value
 func (dict *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
-	_, existed := dict.m[key]
-	if existed {
-		dict.m[key] = val
+	if dict.put(key, val, true, false) {
 		return 1
 	}
 	return 0
@@ -60,84 +181,184 @@ func (dict *SimpleDict) PutIfExists(key string, val interface{}) (result int) {
 
 // Remove removes the key and return the number of deleted key-value
 func (dict *SimpleDict) Remove(key string) (val interface{}, result int) {
-	val, existed := dict.m[key]
-	delete(dict.m, key)
-	if existed {
-		return val, 1
+	dict.rehashStep()
+	table, bucket, pos := dict.find(key)
+	if pos < 0 {
+		return nil, 0
+	}
+	entries := dict.tables[table][bucket]
+	val = entries[pos].val
+	last := len(entries) - 1
+	entries[pos] = entries[last]
+	// 清掉末尾的引用，让删除的 key 和值可以被回收
+	entries[last] = simpleEntry{}
+	dict.tables[table][bucket] = entries[:last]
+	dict.used[table]--
+	if !dict.isRehashing() && len(dict.tables[0]) > simpleInitSize && dict.used[0]*8 < len(dict.tables[0]) {
+		dict.resize(dict.used[0])
 	}
-	return nil, 0
+	return val, 1
 }
 
 // Keys returns all keys in dict
 func (dict *SimpleDict) Keys() []string {
-	result := make([]string, len(dict.m))
-	i := 0
-	for k := range dict.m {
-		result[i] = k
-		i++
-	}
+	result := make([]string, 0, dict.Len())
+	dict.ForEach(func(key string, val interface{}) bool {
+		result = append(result, key)
+		return true
+	})
 	return result
 }
 
-// ForEach traversal the dict
+// ForEach traversal the dict, the consumer may modify the dict,
+// keys removed from the bucket being visited may still be returned
 func (dict *SimpleDict) ForEach(consumer Consumer) {
-	for k, v := range dict.m {
-		if !consumer(k, v) {
-			break
+	atomic.AddInt32(&dict.iterators, 1)
+	defer atomic.AddInt32(&dict.iterators, -1)
+	// 逐个桶复制出来再调用 consumer，consumer 修改当前桶不会打乱遍历
+	var buf []simpleEntry
+	for table := 0; table < 2; table++ {
+		for i := 0; i < len(dict.tables[table]); i++ {
+			buf = append(buf[:0], dict.tables[table][i]...)
+			for _, entry := range buf {
+				if !consumer(entry.key, entry.val) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// randomBucket 随机选一个非空的桶，字典为空时返回 nil
+func (dict *SimpleDict) randomBucket() []simpleEntry {
+	if dict.Len() == 0 {
+		return nil
+	}
+	for {
+		// rehash 期间 tables[0] 中 rehashIdx 之前的桶都已经搬空
+		table, i := 0, 0
+		if dict.isRehashing() {
+			n := len(dict.tables[0]) - dict.rehashIdx + len(dict.tables[1])
+			i = dict.rehashIdx + rand.Intn(n)
+			if i >= len(dict.tables[0]) {
+				table, i = 1, i-len(dict.tables[0])
+			}
+		} else {
+			i = rand.Intn(len(dict.tables[0]))
+		}
+		if bucket := dict.tables[table][i]; len(bucket) > 0 {
+			return bucket
 		}
 	}
 }
 
 // RandomKeys randomly returns keys of the given number, may contain duplicated key
 func (dict *SimpleDict) RandomKeys(limit int) []string {
+	if dict.Len() == 0 {
+		return []string{}
+	}
 	result := make([]string, limit)
-	for i := 0; i < limit; i++ {
-		for k := range dict.m {
-			result[i] = k
-			break
-		}
+	for i := range result {
+		bucket := dict.randomBucket()
+		result[i] = bucket[rand.Intn(len(bucket))].key
 	}
 	return result
 }
 
 // RandomDistinctKeys randomly returns keys of the given number, won't contain duplicated key
 func (dict *SimpleDict) RandomDistinctKeys(limit int) []string {
-	size := limit
-	if size > len(dict.m) {
-		size = len(dict.m)
-	}
-	result := make([]string, size)
-	i := 0
-	for k := range dict.m {
-		if i == size {
-			break
+	if limit >= dict.Len() {
+		return dict.Keys()
+	}
+	result := make([]string, 0, limit)
+	seen := make(map[string]struct{}, limit)
+	for len(result) < limit {
+		bucket := dict.randomBucket()
+		key := bucket[rand.Intn(len(bucket))].key
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			result = append(result, key)
 		}
-		result[i] = k
-		i++
 	}
 	return result
 }
 
 // Clear removes all keys in dict
 func (dict *SimpleDict) Clear() {
-	*dict = *MakeSimple()
+	// 可能在 ForEach 中调用，保留遍历计数
+	*dict = SimpleDict{rehashIdx: -1, iterators: atomic.LoadInt32(&dict.iterators)}
 }
 
+// DictScan 与 ConcurrentDict 相同使用反向二进制迭代的游标，游标是桶的下标。
+// rehash 期间先返回小表中游标对应的桶，再返回大表中由这个桶展开的所有桶，
+// 所以游标在 rehash 前后、扩容和缩容之后都有效。负数游标返回 -1
 func (dict *SimpleDict) DictScan(cursor int, count int, pattern string) ([][]byte, int) {
 	result := make([][]byte, 0)
+	if cursor < 0 {
+		return result, -1
+	}
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
 		return result, -1
 	}
-	for k := range dict.m {
-		if pattern == "*" || matchKey.IsMatch(k) {
-			raw, exists := dict.Get(k)
-			if !exists {
-				continue
+	if dict.Len() == 0 {
+		return result, 0
+	}
+	collect := func(bucket []simpleEntry) {
+		for _, entry := range bucket {
+			if pattern == "*" || matchKey.IsMatch(entry.key) {
+				result = append(result, []byte(entry.key), entry.val.([]byte))
+			}
+		}
+	}
+
+	small, large := dict.tables[0], dict.tables[1]
+	if len(large) > 0 && len(large) < len(small) {
+		small, large = large, small
+	}
+	m0 := uint32(len(small) - 1)
+	v := uint32(cursor)
+	// 每个游标的桶要么全部返回要么留给下一页，至少返回一个游标的桶
+	for first := true; ; first = false {
+		before := len(result)
+		collect(small[v&m0])
+		if large != nil {
+			m1 := uint32(len(large) - 1)
+			for w := v; ; {
+				collect(large[w&m1])
+				// 与外层游标相同，在大表中对 m0 以外的高位做反向二进制加 1。
+				// 缩容后游标可能带着大表的高位，反向顺序中排在它之前的桶已经返回过，之后的桶都在这里返回
+				w = nextScanCursor(w, m1)
+				if w&(m0^m1) == 0 {
+					break
+				}
+			}
+		}
+		if len(result)/2 > count && !first {
+			result = result[:before]
+			return result, int(v)
+		}
+		v = nextScanCursor(v, m0)
+		if v == 0 {
+			return result, 0
+		}
+	}
+}
+
+// Clone returns a copy of the dict, values are shared with the original one
+// so callers must replace values instead of modifying them in place
+func (dict *SimpleDict) Clone() Dict {
+	clone := &SimpleDict{used: dict.used, rehashIdx: dict.rehashIdx}
+	for table := range dict.tables {
+		if dict.tables[table] == nil {
+			continue
+		}
+		clone.tables[table] = make([][]simpleEntry, len(dict.tables[table]))
+		for i, bucket := range dict.tables[table] {
+			if len(bucket) > 0 {
+				clone.tables[table][i] = append([]simpleEntry(nil), bucket...)
 			}
-			result = append(result, []byte(k))
-			result = append(result, raw.([]byte))
 		}
 	}
-	return result, 0
+	return clone
 }
@@ -0,0 +1,158 @@
+package dict
+
+import (
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// 随机操作的结果与 map 一致
+func TestSimpleDict(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	d := MakeSimple()
+	m := make(map[string]interface{})
+	for i := 0; i < 100000; i++ {
+		key := "k" + strconv.Itoa(r.Intn(3000))
+		// 前半段以写入为主，后半段以删除为主，经过多次扩容和缩容
+		if r.Intn(100) < 70 == (i < 50000) {
+			_, exists := m[key]
+			if (d.Put(key, i) == 1) == exists {
+				t.Fatalf("put %s: unexpected result", key)
+			}
+			m[key] = i
+		} else {
+			_, exists := m[key]
+			if _, result := d.Remove(key); (result == 1) != exists {
+				t.Fatalf("remove %s: unexpected result", key)
+			}
+			delete(m, key)
+		}
+		if d.Len() != len(m) {
+			t.Fatalf("step %d: expected len %d, actually %d", i, len(m), d.Len())
+		}
+	}
+	for key, val := range m {
+		if v, ok := d.Get(key); !ok || v != val {
+			t.Fatalf("get %s: expected %v, actually %v", key, val, v)
+		}
+	}
+	if len(d.tables[0]) > 8*simpleInitSize && d.Len()*8 < len(d.tables[0]) {
+		t.Errorf("table of %d buckets not shrunk for %d keys", len(d.tables[0]), d.Len())
+	}
+}
+
+func TestSimpleDictForEachModify(t *testing.T) {
+	d := MakeSimple()
+	for i := 0; i < 1000; i++ {
+		d.Put("k"+strconv.Itoa(i), i)
+	}
+	// 遍历中删除 key 和写入新的 key，原有的每个 key 都返回一次
+	seen := make(map[string]int)
+	d.ForEach(func(key string, val interface{}) bool {
+		seen[key]++
+		d.Remove(key)
+		d.Put("new"+key, val)
+		return true
+	})
+	for i := 0; i < 1000; i++ {
+		if seen["k"+strconv.Itoa(i)] != 1 {
+			t.Fatalf("k%d returned %d times", i, seen["k"+strconv.Itoa(i)])
+		}
+	}
+	if d.Len() != 1000 {
+		t.Errorf("expected 1000 keys, actually %d", d.Len())
+	}
+}
+
+func TestSimpleDictScanAcrossRehash(t *testing.T) {
+	d := MakeSimple()
+	for i := 0; i < 1000; i++ {
+		d.Put("k"+strconv.Itoa(i), []byte("v"))
+	}
+	// 遍历期间写入使字典扩容，再删除新的 key 使字典缩容，一直存在的 key 都至少返回一次
+	seen := make(map[string]bool)
+	cursor, rounds := 0, 0
+	for {
+		keys, next := d.DictScan(cursor, 10, "*")
+		for i := 0; i < len(keys); i += 2 {
+			seen[string(keys[i])] = true
+		}
+		for i := 0; i < 100; i++ {
+			key := "tmp" + strconv.Itoa(rounds*100+i)
+			if rounds < 40 {
+				d.Put(key, []byte("v"))
+			} else {
+				d.Remove("tmp" + strconv.Itoa((rounds-40)*100+i))
+			}
+		}
+		rounds++
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !seen["k"+strconv.Itoa(i)] {
+			t.Fatalf("missed k%d", i)
+		}
+	}
+	if keys, next := d.DictScan(-1, 10, "*"); next != -1 || len(keys) != 0 {
+		t.Error("expected negative cursor rejected")
+	}
+}
+
+// 在 64 个桶的表上取得游标之后缩容到 8 个桶，游标带着大表的高位。
+// key 按哈希值放在低 3 位为 0、高位各不相同的桶中，不依赖哈希种子
+func TestSimpleDictScanAfterShrink(t *testing.T) {
+	const large, small = 64, 8
+	var anchors []string
+	buckets := make(map[string]uint32)
+	for h := uint32(1); h < large/small; h++ {
+		for i := 0; ; i++ {
+			key := "a" + strconv.Itoa(i)
+			if _, ok := buckets[key]; !ok && hashKey(key)&(large-1) == h*small {
+				anchors = append(anchors, key)
+				buckets[key] = h * small
+				break
+			}
+		}
+	}
+
+	d := MakeSimple()
+	for _, key := range anchors {
+		d.Put(key, []byte("v"))
+	}
+	fillers := 0
+	for len(d.tables[0]) != large || d.isRehashing() {
+		d.Put("f"+strconv.Itoa(fillers), []byte("v"))
+		fillers++
+	}
+	for i := 0; i < fillers; i++ {
+		d.Remove("f" + strconv.Itoa(i))
+	}
+	if !d.isRehashing() || len(d.tables[0]) != large || len(d.tables[1]) != small || d.used[1] != 0 {
+		t.Fatalf("expected shrinking from %d to %d buckets, actually %d and %d", large, small, len(d.tables[0]), len(d.tables[1]))
+	}
+
+	// 从大表的每个游标继续遍历，反向顺序中不在游标之前的桶里的 key 都要返回
+	for cursor := uint32(0); ; {
+		seen := make(map[string]bool)
+		for next := int(cursor); ; {
+			keys, n := d.DictScan(next, 1, "*")
+			for i := 0; i < len(keys); i += 2 {
+				seen[string(keys[i])] = true
+			}
+			if next = n; next == 0 {
+				break
+			}
+		}
+		for _, key := range anchors {
+			if bits.Reverse32(buckets[key]) >= bits.Reverse32(cursor) && !seen[key] {
+				t.Errorf("scan from cursor %d missed %s in bucket %d", cursor, key, buckets[key])
+			}
+		}
+		if cursor = nextScanCursor(cursor, large-1); cursor == 0 {
+			break
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package dict
+
+import (
+	"hash/maphash"
+	"sync"
+	"sync/atomic"
+)
+
+// ConcurrentMap 泛型版本的 ConcurrentDict，可以在服务端以外直接使用
+// 与 ConcurrentDict 一样按 key 的哈希值分片，每个分片一把读写锁，分片数是 2 的幂次。
+// ConcurrentDict 还提供不加锁的 *Locked 方法给已经按 key 加锁的数据库使用，
+// ConcurrentMap 的所有方法都自己加锁，可以被多个 goroutine 同时调用
+
+type mapShard[K comparable, V any] struct {
+	m     map[K]V
+	mutex sync.RWMutex
+}
+
+// ConcurrentMap is a sharded map safe for concurrent use
+type ConcurrentMap[K comparable, V any] struct {
+	table []*mapShard[K, V]
+	seed  maphash.Seed
+	count atomic.Int64
+}
+
+// MakeConcurrentMap creates a map with given number of shards, shardCount is rounded up to a power of 2 (at least 16)
+func MakeConcurrentMap[K comparable, V any](shardCount int) *ConcurrentMap[K, V] {
+	shardCount = computeCapacity(shardCount)
+	table := make([]*mapShard[K, V], shardCount)
+	for i := range table {
+		table[i] = &mapShard[K, V]{m: make(map[K]V)}
+	}
+	return &ConcurrentMap[K, V]{
+		table: table,
+		seed:  maphash.MakeSeed(),
+	}
+}
+
+func (m *ConcurrentMap[K, V]) shard(key K) *mapShard[K, V] {
+	hashCode := maphash.Comparable(m.seed, key)
+	return m.table[hashCode&uint64(len(m.table)-1)]
+}
+
+// Len returns number of keys
+func (m *ConcurrentMap[K, V]) Len() int {
+	return int(m.count.Load())
+}
+
+// Get returns value of key
+func (m *ConcurrentMap[K, V]) Get(key K) (val V, exists bool) {
+	s := m.shard(key)
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	val, exists = s.m[key]
+	return val, exists
+}
+
+// Put 写入 key，返回新增的 key 数量
+func (m *ConcurrentMap[K, V]) Put(key K, val V) int {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 0
+	}
+	s.m[key] = val
+	m.count.Add(1)
+	return 1
+}
+
+// PutIfAbsent 只在 key 不存在时写入，返回写入的数量
+func (m *ConcurrentMap[K, V]) PutIfAbsent(key K, val V) int {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.m[key]; ok {
+		return 0
+	}
+	s.m[key] = val
+	m.count.Add(1)
+	return 1
+}
+
+// PutIfExists 只在 key 存在时写入，返回写入的数量
+func (m *ConcurrentMap[K, V]) PutIfExists(key K, val V) int {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.m[key]; ok {
+		s.m[key] = val
+		return 1
+	}
+	return 0
+}
+
+// Remove 删除 key，返回被删除的值和删除的数量
+func (m *ConcurrentMap[K, V]) Remove(key K) (val V, result int) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	val, ok := s.m[key]
+	if !ok {
+		return val, 0
+	}
+	delete(s.m, key)
+	m.count.Add(-1)
+	return val, 1
+}
+
+// Update 在分片锁内读取并修改 key，fn 返回 keep 为 false 时删除 key。用于计数器等读改写操作
+func (m *ConcurrentMap[K, V]) Update(key K, fn func(old V, exists bool) (val V, keep bool)) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	old, exists := s.m[key]
+	val, keep := fn(old, exists)
+	switch {
+	case keep:
+		s.m[key] = val
+		if !exists {
+			m.count.Add(1)
+		}
+	case exists:
+		delete(s.m, key)
+		m.count.Add(-1)
+	}
+}
+
+// ForEach 逐个分片遍历，遍历一个分片时持有它的读锁，consumer 中不能修改这个 map。返回 false 时停止遍历
+func (m *ConcurrentMap[K, V]) ForEach(consumer func(key K, val V) bool) {
+	for _, s := range m.table {
+		s.mutex.RLock()
+		for key, val := range s.m {
+			if !consumer(key, val) {
+				s.mutex.RUnlock()
+				return
+			}
+		}
+		s.mutex.RUnlock()
+	}
+}
+
+// Keys returns all keys
+func (m *ConcurrentMap[K, V]) Keys() []K {
+	keys := make([]K, 0, m.Len())
+	m.ForEach(func(key K, val V) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+// Clear removes all keys
+func (m *ConcurrentMap[K, V]) Clear() {
+	for _, s := range m.table {
+		s.mutex.Lock()
+		m.count.Add(-int64(len(s.m)))
+		s.m = make(map[K]V)
+		s.mutex.Unlock()
+	}
+}
@@ -0,0 +1,111 @@
+package dict
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentMap(t *testing.T) {
+	m := MakeConcurrentMap[string, int](16)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				m.Put(strconv.Itoa(w*1000+i), i)
+				m.Update("counter", func(old int, exists bool) (int, bool) {
+					return old + 1, true
+				})
+			}
+		}(w)
+	}
+	wg.Wait()
+	if m.Len() != 8001 {
+		t.Fatalf("expected 8001 keys, got %d", m.Len())
+	}
+	if v, _ := m.Get("counter"); v != 8000 {
+		t.Fatalf("expected counter 8000, got %d", v)
+	}
+	if m.PutIfAbsent("1", 0) != 0 || m.PutIfExists("missing", 0) != 0 {
+		t.Fatal("conditional put should not write")
+	}
+	if _, n := m.Remove("1"); n != 1 || m.Len() != 8000 {
+		t.Fatal("remove failed")
+	}
+	m.Update("counter", func(old int, exists bool) (int, bool) {
+		return 0, false
+	})
+	if _, ok := m.Get("counter"); ok || len(m.Keys()) != 7999 {
+		t.Fatal("update should remove key")
+	}
+	m.Clear()
+	if m.Len() != 0 || len(m.Keys()) != 0 {
+		t.Fatal("clear failed")
+	}
+}
+
+// 与 sync.Map 对比，go test -bench ConcurrentMap -run ^$ ./datastruct/dict/
+const benchKeys = 1 << 16
+
+var benchKeyNames = func() []string {
+	keys := make([]string, benchKeys)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+	return keys
+}()
+
+type benchMap interface {
+	load(key string) (int, bool)
+	store(key string, val int)
+}
+
+type typedBench struct{ m *ConcurrentMap[string, int] }
+
+func (b typedBench) load(key string) (int, bool) { return b.m.Get(key) }
+func (b typedBench) store(key string, val int)   { b.m.Put(key, val) }
+
+type syncBench struct{ m *sync.Map }
+
+func (b syncBench) load(key string) (int, bool) {
+	v, ok := b.m.Load(key)
+	if !ok {
+		return 0, false
+	}
+	return v.(int), true
+}
+func (b syncBench) store(key string, val int) { b.m.Store(key, val) }
+
+// runBench 每个 goroutine 的操作中有 writePercent% 是写入
+func runBench(b *testing.B, m benchMap, writePercent int) {
+	for i, key := range benchKeyNames {
+		m.store(key, i)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := benchKeyNames[(i*7919)&(benchKeys-1)]
+			if i%100 < writePercent {
+				m.store(key, i)
+			} else {
+				m.load(key)
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkConcurrentMap(b *testing.B) {
+	for _, writePercent := range []int{0, 10, 50, 100} {
+		name := strconv.Itoa(writePercent) + "%write"
+		b.Run(name+"/ConcurrentMap", func(b *testing.B) {
+			runBench(b, typedBench{MakeConcurrentMap[string, int](256)}, writePercent)
+		})
+		b.Run(name+"/sync.Map", func(b *testing.B) {
+			runBench(b, syncBench{&sync.Map{}}, writePercent)
+		})
+	}
+}
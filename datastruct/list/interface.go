@@ -21,4 +21,5 @@ type List interface {
 	ForEach(consumer Consumer)
 	Contains(expected Expected) bool
 	Range(start int, stop int) []interface{}
+	Clone() List
 }
@@ -289,3 +289,15 @@ func Make(vals ...interface{}) *LinkedList {
 	}
 	return &list
 }
+
+// Clone returns a new list holding the same values
+func (list *LinkedList) Clone() List {
+	if list == nil {
+		panic("list is nil")
+	}
+	result := Make()
+	for n := list.first; n != nil; n = n.next {
+		result.Add(n.val)
+	}
+	return result
+}
@@ -388,3 +388,16 @@ func (ql *QuickList) Range(start int, stop int) []interface{} {
 	}
 	return slice
 }
+
+// Clone copies every page, values are shared with the original list
+func (ql *QuickList) Clone() List {
+	result := NewQuickList()
+	for e := ql.data.Front(); e != nil; e = e.Next() {
+		page := e.Value.([]interface{})
+		newPage := make([]interface{}, len(page), cap(page))
+		copy(newPage, page)
+		result.data.PushBack(newPage)
+	}
+	result.size = ql.size
+	return result
+}
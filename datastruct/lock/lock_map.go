@@ -3,10 +3,14 @@ package lock
 import (
 	"sort"
 	"sync"
+
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
 )
 
 type Locks struct {
 	table []*sync.RWMutex
+	// 同一个 Locks 中的锁属于同一类，嵌套加锁时必须按下标递增
+	class *lockorder.Class
 }
 
 const (
@@ -18,7 +22,7 @@ func Make(size int) *Locks {
 	for i := 0; i < size; i++ {
 		table[i] = &sync.RWMutex{}
 	}
-	return &Locks{table: table}
+	return &Locks{table: table, class: lockorder.NewClass("lock.Locks")}
 }
 
 func fnv32(key string) uint32 {
@@ -41,6 +45,7 @@ func (locks *Locks) spread(hashCode uint32) uint32 {
 func (locks *Locks) Lock(key string) {
 	index := locks.spread(fnv32(key))
 	mu := locks.table[index]
+	locks.class.Acquire(uint64(index))
 	mu.Lock()
 }
 
@@ -48,12 +53,14 @@ func (locks *Locks) UnLock(key string) {
 	index := locks.spread(fnv32(key))
 	mu := locks.table[index]
 	mu.Unlock()
+	locks.class.Release(uint64(index))
 }
 
 func (locks *Locks) Locks(keys ...string) {
 	indices := locks.toLockIndices(keys, false)
 	for _, index := range indices {
 		mu := locks.table[index]
+		locks.class.Acquire(uint64(index))
 		mu.Lock()
 	}
 }
@@ -62,6 +69,7 @@ func (locks *Locks) UnLocks(keys ...string) {
 	for _, index := range indices {
 		mu := locks.table[index]
 		mu.Unlock()
+		locks.class.Release(uint64(index))
 	}
 }
 
@@ -76,6 +84,9 @@ func (locks *Locks) toLockIndices(keys []string, reverse bool) []uint32 {
 		indexMap[index] = true
 	}
 	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
 	// 如果 reverse 为 false，则 indices 按升序排列。
 	// 如果 reverse 为 true，则 indices 按降序排列。
 	// reverse == false：通常用于需要按自然顺序访问锁的场景。
@@ -103,6 +114,7 @@ func (locks *Locks) RWLock(readKeys []string, writeKeys []string) {
 	for _, index := range indices {
 		_, w := writeIndexSet[index]
 		mu := locks.table[index]
+		locks.class.Acquire(uint64(index))
 		if w {
 			mu.Lock()
 		} else {
@@ -127,5 +139,6 @@ func (locks *Locks) RWUnLock(readKeys []string, writeKeys []string) {
 		} else {
 			mu.RUnlock()
 		}
+		locks.class.Release(uint64(index))
 	}
 }
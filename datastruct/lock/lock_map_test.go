@@ -0,0 +1,42 @@
+package lock
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestToLockIndices(t *testing.T) {
+	locks := Make(16)
+	keys := []string{"a", "b", "c", "a", "d", "e", "f"}
+	indices := locks.toLockIndices(keys, false)
+	seen := make(map[uint32]bool)
+	for _, key := range keys {
+		seen[locks.spread(fnv32(key))] = true
+	}
+	if len(indices) != len(seen) {
+		t.Fatalf("expected %d distinct indices, actually %v", len(seen), indices)
+	}
+	if !sort.SliceIsSorted(indices, func(i, j int) bool { return indices[i] < indices[j] }) {
+		t.Errorf("expected ascending indices, actually %v", indices)
+	}
+	reversed := locks.toLockIndices(keys, true)
+	if !sort.SliceIsSorted(reversed, func(i, j int) bool { return reversed[i] > reversed[j] }) {
+		t.Errorf("expected descending indices, actually %v", reversed)
+	}
+
+	// 同一组 key 加锁之后其他协程不能再拿到其中任何一个 key 的锁
+	locks.Locks(keys...)
+	for index := range seen {
+		if locks.table[index].TryRLock() {
+			t.Errorf("lock %d is not held after Locks", index)
+			locks.table[index].RUnlock()
+		}
+	}
+	locks.UnLocks(keys...)
+	for index := range seen {
+		if !locks.table[index].TryLock() {
+			t.Fatalf("lock %d is still held after UnLocks", index)
+		}
+		locks.table[index].Unlock()
+	}
+}
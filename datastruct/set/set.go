@@ -1,27 +1,102 @@
 package set
 
 import (
+	"math/rand"
+	"slices"
+	"strconv"
+
 	"github.com/zhangming/go-redis/datastruct/dict"
 	"github.com/zhangming/go-redis/lib/wildcard"
 )
 
+// intset 编码
+// 与 redis 相同，只包含整数的小集合用有序的 []int64 保存，比字典省去每个成员的字符串和哈希表开销。
+// 加入非整数成员或者成员数超过 set-max-intset-entries 时转换为字典，之后不再转换回来。
+// 只有十进制规范形式的整数(没有前导 0 和 + 号)按整数保存，保证取出的成员与加入的相同
+
+const defaultMaxIntsetEntries = 512
+
 type Set struct {
+	// intset 编码时为 nil
 	dict dict.Dict
+	ints []int64
+	// intset 转换的阈值，创建时确定，之后不随配置变化
+	maxIntset int
+}
+
+// parseIntsetMember 成员是规范形式的整数时返回它的值
+func parseIntsetMember(val string) (int64, bool) {
+	if len(val) == 0 || len(val) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != val {
+		return 0, false
+	}
+	return n, true
+}
+
+// convertToDict 把 intset 编码转换为字典
+func (set *Set) convertToDict() {
+	d := dict.MakeSimple()
+	for _, n := range set.ints {
+		d.Put(strconv.FormatInt(n, 10), nil)
+	}
+	set.dict = d
+	set.ints = nil
+}
+
+// IsIntset returns whether the set is intset encoded
+func (set *Set) IsIntset() bool {
+	return set.dict == nil
 }
 
 func (set *Set) Add(val string) int {
+	if set.dict == nil {
+		if n, ok := parseIntsetMember(val); ok {
+			i, found := slices.BinarySearch(set.ints, n)
+			if found {
+				return 0
+			}
+			if len(set.ints) < set.maxIntset {
+				set.ints = slices.Insert(set.ints, i, n)
+				return 1
+			}
+		}
+		set.convertToDict()
+	}
 	return set.dict.Put(val, nil)
 }
 
 func (set *Set) Remove(val string) int {
+	if set.dict == nil {
+		n, ok := parseIntsetMember(val)
+		if !ok {
+			return 0
+		}
+		i, found := slices.BinarySearch(set.ints, n)
+		if !found {
+			return 0
+		}
+		set.ints = slices.Delete(set.ints, i, i+1)
+		return 1
+	}
 	_, ret := set.dict.Remove(val)
 	return ret
 }
 
+// Make creates a set, it starts intset encoded and converts to dict when needed
 func Make(members ...string) *Set {
-	set := &Set{
-		dict: dict.MakeSimple(),
+	return MakeWithMaxIntset(0, members...)
+}
+
+// MakeWithMaxIntset creates a set which converts to dict when it has more than maxIntset members,
+// maxIntset <= 0 uses the default 512
+func MakeWithMaxIntset(maxIntset int, members ...string) *Set {
+	if maxIntset <= 0 {
+		maxIntset = defaultMaxIntsetEntries
 	}
+	set := &Set{maxIntset: maxIntset}
 	for _, member := range members {
 		set.Add(member)
 	}
@@ -43,6 +118,14 @@ func (set *Set) Has(key string) bool {
 	if key == "" {
 		return false
 	}
+	if set.dict == nil {
+		n, ok := parseIntsetMember(key)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(set.ints, n)
+		return found
+	}
 	_, exist := set.dict.Get(key)
 	return exist
 }
@@ -51,6 +134,9 @@ func (set *Set) Len() int {
 	if set == nil {
 		return 0
 	}
+	if set.dict == nil {
+		return len(set.ints)
+	}
 	return set.dict.Len()
 }
 
@@ -58,6 +144,13 @@ func (set *Set) ToSlice() []string {
 	if set == nil {
 		return nil
 	}
+	if set.dict == nil {
+		slice := make([]string, len(set.ints))
+		for i, n := range set.ints {
+			slice[i] = strconv.FormatInt(n, 10)
+		}
+		return slice
+	}
 	i := 0
 	slice := make([]string, set.Len())
 	set.dict.ForEach(func(key string, val interface{}) bool {
@@ -73,21 +166,41 @@ func (set *Set) ToSlice() []string {
 	return slice
 }
 
+// ForEach visits every member, intset encoded sets are visited in increasing order.
+// The set must not be modified during the traversal
 func (set *Set) ForEach(consumer func(member string) bool) {
 	if set == nil {
 		return
 	}
+	if set.dict == nil {
+		for _, n := range set.ints {
+			if !consumer(strconv.FormatInt(n, 10)) {
+				return
+			}
+		}
+		return
+	}
 	set.dict.ForEach(func(key string, val interface{}) bool {
 		return consumer(key)
 	})
 }
 
-// 交集
+// resultMaxIntset 返回第一个存在的集合的 intset 阈值，不存在的 key 对应 nil 集合
+func resultMaxIntset(sets []*Set) int {
+	for _, set := range sets {
+		if set != nil {
+			return set.maxIntset
+		}
+	}
+	return 0
+}
+
+// 交集，结果使用第一个集合的 intset 阈值
 func Intersect(sets ...*Set) *Set {
-	result := Make()
 	if len(sets) == 0 {
-		return result
+		return Make()
 	}
+	result := MakeWithMaxIntset(resultMaxIntset(sets))
 
 	countMap := make(map[string]int)
 	for _, set := range sets {
@@ -104,12 +217,12 @@ func Intersect(sets ...*Set) *Set {
 	return result
 }
 
-// 并集
+// 并集，结果使用第一个集合的 intset 阈值
 func Union(sets ...*Set) *Set {
-	result := Make()
 	if len(sets) == 0 {
-		return result
+		return Make()
 	}
+	result := MakeWithMaxIntset(resultMaxIntset(sets))
 	for _, set := range sets {
 		set.ForEach(func(member string) bool {
 			result.Add(member)
@@ -121,8 +234,8 @@ func Union(sets ...*Set) *Set {
 
 // 差集
 func Diff(sets ...*Set) *Set {
-	if len(sets) == 0 {
-		return Make()
+	if len(sets) == 0 || sets[0] == nil {
+		return MakeWithMaxIntset(resultMaxIntset(sets))
 	}
 	result := sets[0].ShallowCopy()
 	for i := 1; i < len(sets); i++ {
@@ -137,8 +250,19 @@ func Diff(sets ...*Set) *Set {
 	return result
 }
 
+// Clone 复制底层字典，比 ShallowCopy 少一次逐个插入
+func (set *Set) Clone() *Set {
+	if set.dict == nil {
+		return &Set{ints: slices.Clone(set.ints), maxIntset: set.maxIntset}
+	}
+	return &Set{
+		dict:      set.dict.Clone(),
+		maxIntset: set.maxIntset,
+	}
+}
+
 func (set *Set) ShallowCopy() *Set {
-	result := Make()
+	result := MakeWithMaxIntset(set.maxIntset)
 	set.ForEach(func(member string) bool {
 		result.Add(member)
 		return true
@@ -164,12 +288,30 @@ func (set *Set) SetScan(cursor int, count int, pattern string) ([][]byte, int) {
 }
 
 func (set *Set) RandomMembers(limit int) []string {
-	if set == nil || set.dict == nil {
+	if set == nil {
 		return nil
 	}
+	if set.dict == nil {
+		if len(set.ints) == 0 {
+			return []string{}
+		}
+		result := make([]string, limit)
+		for i := range result {
+			result[i] = strconv.FormatInt(set.ints[rand.Intn(len(set.ints))], 10)
+		}
+		return result
+	}
 	return set.dict.RandomKeys(limit)
 }
 
 func (set *Set) RandomDistinctMembers(limit int) []string {
+	if set.dict == nil {
+		limit = min(limit, len(set.ints))
+		result := make([]string, limit)
+		for i, j := range rand.Perm(len(set.ints))[:limit] {
+			result[i] = strconv.FormatInt(set.ints[j], 10)
+		}
+		return result
+	}
 	return set.dict.RandomDistinctKeys(limit)
 }
\ No newline at end of file
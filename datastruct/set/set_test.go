@@ -0,0 +1,56 @@
+package set
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestIntset(t *testing.T) {
+	s := Make("3", "-1", "2", "3")
+	if !s.IsIntset() || s.Len() != 3 {
+		t.Fatalf("expected intset of 3 members, actually intset=%v len=%d", s.IsIntset(), s.Len())
+	}
+	if got := s.ToSlice(); !slices.Equal(got, []string{"-1", "2", "3"}) {
+		t.Errorf("unexpected members %v", got)
+	}
+	// 非规范形式的整数不能按整数保存
+	if s.Has("+2") || s.Has("02") || s.Remove("03") != 0 {
+		t.Error("non canonical integer should not match")
+	}
+	s.Add("02")
+	if s.IsIntset() {
+		t.Fatal("expected dict encoding after adding non canonical integer")
+	}
+	if !s.Has("02") || !s.Has("2") || s.Len() != 4 {
+		t.Errorf("unexpected members %v", s.ToSlice())
+	}
+}
+
+func TestIntsetMaxEntries(t *testing.T) {
+	s := MakeWithMaxIntset(10)
+	for i := 0; i < 10; i++ {
+		s.Add(strconv.Itoa(i))
+	}
+	c := s.Clone()
+	s.Add("10")
+	if s.IsIntset() || s.Len() != 11 {
+		t.Fatalf("expected dict of 11 members, actually intset=%v len=%d", s.IsIntset(), s.Len())
+	}
+	if !c.IsIntset() || c.Len() != 10 || c.Has("10") {
+		t.Error("clone should be independent of the original")
+	}
+	if members := s.RandomDistinctMembers(20); len(members) != 11 {
+		t.Errorf("expected 11 distinct members, actually %d", len(members))
+	}
+}
+
+func TestMissingSets(t *testing.T) {
+	// 不存在的 key 对应 nil 集合
+	if Union(nil, nil).Len() != 0 || Diff(nil, Make("a")).Len() != 0 || Intersect(nil).Len() != 0 {
+		t.Error("expected empty result for missing sets")
+	}
+	if result := Diff(Make("a", "b"), nil); result.Len() != 2 {
+		t.Errorf("expected 2 members, actually %d", result.Len())
+	}
+}
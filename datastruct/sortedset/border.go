@@ -2,6 +2,7 @@ package sortedset
 
 import (
 	"errors"
+	"math"
 	"strconv"
 )
 
@@ -10,160 +11,192 @@ import (
  * can accept:
  *   int or float value, such as 2.718, 2, -2.718, -2 ...
  *   exclusive int or float value, such as (2.718, (2, (-2.718, (-2 ...
- *   infinity: +inf, -inf， inf(same as +inf)
+ *   infinity: +inf, -inf， inf(same as +inf), (+inf, (-inf
+ *
+ * 边界创建之后不可修改，预先创建的 ±inf 边界可以在多次调用之间共享
  */
 
 const (
-	scoreNegativeInf int8 = -1
-	scorePositiveInf int8 = 1
-	lexNegativeInf   int8 = '-'
-	lexPositiveInf   int8 = '+'
+	lexNegativeInf int8 = '-'
+	lexPositiveInf int8 = '+'
+)
+
+var (
+	errScoreBorder = errors.New("ERR min or max is not a float")
+	errLexBorder   = errors.New("ERR min or max not valid string range item")
 )
 
 type Border interface {
 	greater(element *Element) bool
 	less(element *Element) bool
-	getValue() interface{}
-	getExclude() bool
-	isIntersected(max Border) bool
+	// isEmptyRange 以当前边界为 min、参数为 max 时区间内不可能有元素
+	isEmptyRange(max Border) bool
 }
 
 // ScoreBorder represents range of a float value, including: <, <=, >, >=, +inf, -inf
 type ScoreBorder struct {
-	Inf     int8
-	Value   float64
-	Exclude bool
+	value   float64
+	exclude bool
+}
+
+// NewScoreBorder creates ScoreBorder, value 可以是 ±Inf 但不能是 NaN
+func NewScoreBorder(value float64, exclude bool) *ScoreBorder {
+	return &ScoreBorder{value: value, exclude: exclude}
+}
+
+// Value returns value of border
+func (border *ScoreBorder) Value() float64 {
+	return border.value
+}
+
+// Exclude returns whether the border is exclusive
+func (border *ScoreBorder) Exclude() bool {
+	return border.exclude
 }
 
 // if max.greater(score) then the score is within the upper border
 // do not use min.greater()
 func (border *ScoreBorder) greater(element *Element) bool {
-	value := element.Score
-	if border.Inf == scoreNegativeInf {
-		return false
-	} else if border.Inf == scorePositiveInf {
-		return true
+	if border.exclude {
+		return border.value > element.Score
 	}
-	if border.Exclude {
-		return border.Value > value
-	}
-	return border.Value >= value
+	return border.value >= element.Score
 }
 
 func (border *ScoreBorder) less(element *Element) bool {
-	value := element.Score
-	if border.Inf == scoreNegativeInf {
-		return true
-	} else if border.Inf == scorePositiveInf {
-		return false
+	if border.exclude {
+		return border.value < element.Score
 	}
-	if border.Exclude {
-		return border.Value < value
-	}
-	return border.Value <= value
-}
-
-func (border *ScoreBorder) getValue() interface{} {
-	return border.Value
+	return border.value <= element.Score
 }
 
-func (border *ScoreBorder) getExclude() bool {
-	return border.Exclude
+func (border *ScoreBorder) isEmptyRange(max Border) bool {
+	maxBorder := max.(*ScoreBorder)
+	return border.value > maxBorder.value ||
+		(border.value == maxBorder.value && (border.exclude || maxBorder.exclude))
 }
 
-var scorePositiveInfBorder = &ScoreBorder{
-	Inf: scorePositiveInf,
-}
+var scorePositiveInfBorder = NewScoreBorder(math.Inf(1), false)
 
-var scoreNegativeInfBorder = &ScoreBorder{
-	Inf: scoreNegativeInf,
-}
+var scoreNegativeInfBorder = NewScoreBorder(math.Inf(-1), false)
 
 // ParseScoreBorder creates ScoreBorder from redis arguments
 func ParseScoreBorder(s string) (Border, error) {
-	if s == "inf" || s == "+inf" {
-		return scorePositiveInfBorder, nil
+	exclude := false
+	if len(s) > 0 && s[0] == '(' {
+		exclude = true
+		s = s[1:]
 	}
-	if s == "-inf" {
-		return scoreNegativeInfBorder, nil
-	}
-	if s[0] == '(' {
-		value, err := strconv.ParseFloat(s[1:], 64)
-		if err != nil {
-			return nil, errors.New("ERR min or max is not a float")
-		}
-		return &ScoreBorder{
-			Inf:     0,
-			Value:   value,
-			Exclude: true,
-		}, nil
+	if s == "" {
+		return nil, errScoreBorder
 	}
 	value, err := strconv.ParseFloat(s, 64)
-	if err != nil {
-		return nil, errors.New("ERR min or max is not a float")
+	if errors.Is(err, strconv.ErrRange) {
+		// 与 strtod 相同，超出范围的值视为 ±inf 或 0
+		err = nil
 	}
-	return &ScoreBorder{
-		Inf:     0,
-		Value:   value,
-		Exclude: false,
-	}, nil
-}
-
-func (border *ScoreBorder) isIntersected(max Border) bool {
-	minValue := border.Value
-	maxValue := max.(*ScoreBorder).Value
-	return minValue > maxValue || (minValue == maxValue && (border.getExclude() || max.getExclude()))
+	if err != nil || math.IsNaN(value) {
+		return nil, errScoreBorder
+	}
+	if !exclude {
+		if math.IsInf(value, 1) {
+			return scorePositiveInfBorder, nil
+		}
+		if math.IsInf(value, -1) {
+			return scoreNegativeInfBorder, nil
+		}
+	}
+	return NewScoreBorder(value, exclude), nil
 }
 
 // LexBorder represents range of a string value, including: <, <=, >, >=, +, -
 type LexBorder struct {
-	Inf     int8
-	Value   string
-	Exclude bool
+	inf     int8
+	value   string
+	exclude bool
+}
+
+// NewLexBorder creates LexBorder of given member, 正负无穷使用 ParseLexBorder("+") 和 ParseLexBorder("-")
+func NewLexBorder(value string, exclude bool) *LexBorder {
+	return &LexBorder{value: value, exclude: exclude}
+}
+
+// Value returns member of border, 正负无穷时为空字符串
+func (border *LexBorder) Value() string {
+	return border.value
+}
+
+// Exclude returns whether the border is exclusive
+func (border *LexBorder) Exclude() bool {
+	return border.exclude
 }
 
 // if max.greater(lex) then the lex is within the upper border
 // do not use min.greater()
 func (border *LexBorder) greater(element *Element) bool {
 	value := element.Member
-	if border.Inf == lexNegativeInf {
+	if border.inf == lexNegativeInf {
 		return false
-	} else if border.Inf == lexPositiveInf {
+	} else if border.inf == lexPositiveInf {
 		return true
 	}
-	if border.Exclude {
-		return border.Value > value
+	if border.exclude {
+		return border.value > value
 	}
-	return border.Value >= value
+	return border.value >= value
 }
 
 func (border *LexBorder) less(element *Element) bool {
 	value := element.Member
-	if border.Inf == lexNegativeInf {
+	if border.inf == lexNegativeInf {
 		return true
-	} else if border.Inf == lexPositiveInf {
+	} else if border.inf == lexPositiveInf {
 		return false
 	}
-	if border.Exclude {
-		return border.Value < value
+	if border.exclude {
+		return border.value < value
 	}
-	return border.Value <= value
+	return border.value <= value
 }
 
-func (border *LexBorder) getValue() interface{} {
-	return border.Value
+// compare 比较两个边界，"-" 小于任何字符串，"+" 大于任何字符串
+func (border *LexBorder) compare(other *LexBorder) int {
+	rank := func(b *LexBorder) int {
+		switch b.inf {
+		case lexNegativeInf:
+			return -1
+		case lexPositiveInf:
+			return 1
+		}
+		return 0
+	}
+	if r1, r2 := rank(border), rank(other); r1 != 0 || r2 != 0 {
+		return r1 - r2
+	}
+	switch {
+	case border.value < other.value:
+		return -1
+	case border.value > other.value:
+		return 1
+	}
+	return 0
 }
 
-func (border *LexBorder) getExclude() bool {
-	return border.Exclude
+func (border *LexBorder) isEmptyRange(max Border) bool {
+	maxBorder := max.(*LexBorder)
+	cmp := border.compare(maxBorder)
+	return cmp > 0 || (cmp == 0 && (border.exclude || maxBorder.exclude))
 }
 
+// 与 redis 相同，+ 和 - 视为开区间，因此 "- -" 和 "+ +" 都是空区间
 var lexPositiveInfBorder = &LexBorder{
-	Inf: lexPositiveInf,
+	inf:     lexPositiveInf,
+	exclude: true,
 }
 
 var lexNegativeInfBorder = &LexBorder{
-	Inf: lexNegativeInf,
+	inf:     lexNegativeInf,
+	exclude: true,
 }
 
 // ParseLexBorder creates LexBorder from redis arguments
@@ -174,27 +207,14 @@ func ParseLexBorder(s string) (Border, error) {
 	if s == "-" {
 		return lexNegativeInfBorder, nil
 	}
-	if s[0] == '(' {
-		return &LexBorder{
-			Inf:     0,
-			Value:   s[1:],
-			Exclude: true,
-		}, nil
+	if len(s) == 0 {
+		return nil, errLexBorder
 	}
-
-	if s[0] == '[' {
-		return &LexBorder{
-			Inf:     0,
-			Value:   s[1:],
-			Exclude: false,
-		}, nil
+	switch s[0] {
+	case '(':
+		return NewLexBorder(s[1:], true), nil
+	case '[':
+		return NewLexBorder(s[1:], false), nil
 	}
-
-	return nil, errors.New("ERR min or max not valid string range item")
-}
-
-func (border *LexBorder) isIntersected(max Border) bool {
-	minValue := border.Value
-	maxValue := max.(*LexBorder).Value
-	return border.Inf == '+' || minValue > maxValue || (minValue == maxValue && (border.getExclude() || max.getExclude()))
+	return nil, errLexBorder
 }
@@ -0,0 +1,229 @@
+package sortedset
+
+import (
+	"math"
+	"slices"
+	"testing"
+)
+
+func members(elements []*Element) []string {
+	result := make([]string, len(elements))
+	for i, element := range elements {
+		result[i] = element.Member
+	}
+	return result
+}
+
+func TestParseBorder(t *testing.T) {
+	scoreTests := []struct {
+		arg     string
+		value   float64
+		exclude bool
+		err     bool
+	}{
+		{arg: "1.5", value: 1.5},
+		{arg: "(1.5", value: 1.5, exclude: true},
+		{arg: "-2", value: -2},
+		{arg: "inf", value: math.Inf(1)},
+		{arg: "+inf", value: math.Inf(1)},
+		{arg: "-inf", value: math.Inf(-1)},
+		{arg: "(+inf", value: math.Inf(1), exclude: true},
+		{arg: "(-inf", value: math.Inf(-1), exclude: true},
+		{arg: "1e400", value: math.Inf(1)},
+		{arg: "", err: true},
+		{arg: "(", err: true},
+		{arg: "((1", err: true},
+		{arg: "abc", err: true},
+		{arg: "1.5x", err: true},
+		{arg: "nan", err: true},
+		{arg: "(nan", err: true},
+	}
+	for _, tt := range scoreTests {
+		border, err := ParseScoreBorder(tt.arg)
+		if tt.err {
+			if err == nil || err.Error() != "ERR min or max is not a float" {
+				t.Errorf("score border %q: expected error, got %v", tt.arg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("score border %q: %v", tt.arg, err)
+			continue
+		}
+		b := border.(*ScoreBorder)
+		if b.Value() != tt.value || b.Exclude() != tt.exclude {
+			t.Errorf("score border %q: got %v %v", tt.arg, b.Value(), b.Exclude())
+		}
+	}
+
+	lexTests := []struct {
+		arg     string
+		value   string
+		exclude bool
+		err     bool
+	}{
+		{arg: "[a", value: "a"},
+		{arg: "(a", value: "a", exclude: true},
+		{arg: "[", value: ""},
+		{arg: "(", value: "", exclude: true},
+		{arg: "[+", value: "+"},
+		{arg: "", err: true},
+		{arg: "a", err: true},
+		{arg: "+a", err: true},
+		{arg: "-a", err: true},
+		{arg: "{a", err: true},
+	}
+	for _, tt := range lexTests {
+		border, err := ParseLexBorder(tt.arg)
+		if tt.err {
+			if err == nil || err.Error() != "ERR min or max not valid string range item" {
+				t.Errorf("lex border %q: expected error, got %v", tt.arg, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("lex border %q: %v", tt.arg, err)
+			continue
+		}
+		b := border.(*LexBorder)
+		if b.Value() != tt.value || b.Exclude() != tt.exclude {
+			t.Errorf("lex border %q: got %q %v", tt.arg, b.Value(), b.Exclude())
+		}
+	}
+}
+
+func TestScoreRange(t *testing.T) {
+	z := Make()
+	z.Add("ninf", math.Inf(-1))
+	z.Add("a", 1)
+	z.Add("b", 1.5)
+	z.Add("c", 1.5)
+	z.Add("d", 2)
+	z.Add("pinf", math.Inf(1))
+	all := []string{"ninf", "a", "b", "c", "d", "pinf"}
+
+	tests := []struct {
+		min, max string
+		expected []string
+	}{
+		{"-inf", "+inf", all},
+		{"-inf", "inf", all},
+		{"(-inf", "(+inf", []string{"a", "b", "c", "d"}},
+		{"1.5", "1.5", []string{"b", "c"}},
+		{"(1.5", "1.5", nil},
+		{"1.5", "(1.5", nil},
+		{"(1.5", "2", []string{"d"}},
+		{"(1", "(2", []string{"b", "c"}},
+		{"1", "(1.5", []string{"a"}},
+		{"2", "1", nil},
+		{"+inf", "+inf", []string{"pinf"}},
+		{"(+inf", "+inf", nil},
+		{"-inf", "-inf", []string{"ninf"}},
+		{"-inf", "(-inf", nil},
+		{"(2", "+inf", []string{"pinf"}},
+		{"3", "4", nil},
+		{"-5", "0", nil},
+		{"-inf", "1", []string{"ninf", "a"}},
+	}
+	for _, tt := range tests {
+		min, _ := ParseScoreBorder(tt.min)
+		max, _ := ParseScoreBorder(tt.max)
+		got := members(z.Range(min, max, 0, -1, false))
+		if !slices.Equal(got, tt.expected) {
+			t.Errorf("[%s, %s]: expected %v, got %v", tt.min, tt.max, tt.expected, got)
+		}
+		reversed := slices.Clone(tt.expected)
+		slices.Reverse(reversed)
+		got = members(z.Range(min, max, 0, -1, true))
+		if !slices.Equal(got, reversed) {
+			t.Errorf("[%s, %s] desc: expected %v, got %v", tt.min, tt.max, reversed, got)
+		}
+		if n := z.RangeCount(min, max); n != int64(len(tt.expected)) {
+			t.Errorf("[%s, %s]: expected count %d, got %d", tt.min, tt.max, len(tt.expected), n)
+		}
+	}
+
+	// offset 越过区间之后不能返回区间外的元素
+	min, _ := ParseScoreBorder("1")
+	max, _ := ParseScoreBorder("1.5")
+	if got := members(z.Range(min, max, 2, -1, false)); !slices.Equal(got, []string{"c"}) {
+		t.Errorf("offset 2: got %v", got)
+	}
+	if got := members(z.Range(min, max, 3, -1, false)); len(got) != 0 {
+		t.Errorf("offset 3: got %v", got)
+	}
+	if got := members(z.Range(min, max, 1, 1, true)); !slices.Equal(got, []string{"b"}) {
+		t.Errorf("desc offset 1 limit 1: got %v", got)
+	}
+	if got := z.Range(min, max, -1, -1, false); len(got) != 0 {
+		t.Errorf("negative offset: got %v", members(got))
+	}
+}
+
+func TestLexRange(t *testing.T) {
+	z := Make()
+	for _, member := range []string{"", "a", "aa", "ab", "b"} {
+		z.Add(member, 0)
+	}
+	all := []string{"", "a", "aa", "ab", "b"}
+
+	tests := []struct {
+		min, max string
+		expected []string
+	}{
+		{"-", "+", all},
+		{"[a", "+", []string{"a", "aa", "ab", "b"}},
+		{"(a", "+", []string{"aa", "ab", "b"}},
+		{"-", "[a", []string{"", "a"}},
+		{"-", "(a", []string{""}},
+		{"[aa", "(b", []string{"aa", "ab"}},
+		{"[aa", "[aa", []string{"aa"}},
+		{"(aa", "[aa", nil},
+		{"[aa", "(aa", nil},
+		{"[", "(a", []string{""}},
+		{"(", "+", []string{"a", "aa", "ab", "b"}},
+		{"-", "[", []string{""}},
+		{"-", "(", nil},
+		{"[b", "[a", nil},
+		{"[c", "+", nil},
+		{"-", "-", nil},
+		{"+", "+", nil},
+		{"+", "-", nil},
+		{"+", "[z", nil},
+		{"[a", "-", nil},
+	}
+	for _, tt := range tests {
+		min, _ := ParseLexBorder(tt.min)
+		max, _ := ParseLexBorder(tt.max)
+		got := members(z.Range(min, max, 0, -1, false))
+		if !slices.Equal(got, tt.expected) {
+			t.Errorf("[%s, %s]: expected %q, got %q", tt.min, tt.max, tt.expected, got)
+		}
+		reversed := slices.Clone(tt.expected)
+		slices.Reverse(reversed)
+		got = members(z.Range(min, max, 0, -1, true))
+		if !slices.Equal(got, reversed) {
+			t.Errorf("[%s, %s] desc: expected %q, got %q", tt.min, tt.max, reversed, got)
+		}
+		if n := z.RangeCount(min, max); n != int64(len(tt.expected)) {
+			t.Errorf("[%s, %s]: expected count %d, got %d", tt.min, tt.max, len(tt.expected), n)
+		}
+	}
+}
+
+func TestSharedBorder(t *testing.T) {
+	// 预先创建的边界在多次调用之间共享，使用之后不能被改变
+	z := Make()
+	z.Add("a", 1)
+	z.Add("b", 2)
+	first, _ := ParseScoreBorder("+inf")
+	z.PopMin(1)
+	second, _ := ParseScoreBorder("+inf")
+	if first != second || second.(*ScoreBorder).Value() != math.Inf(1) || second.(*ScoreBorder).Exclude() {
+		t.Fatal("shared border changed")
+	}
+	min, _ := ParseScoreBorder("-inf")
+	if got := members(z.Range(min, second, 0, -1, false)); !slices.Equal(got, []string{"b"}) {
+		t.Fatalf("unexpected range %v", got)
+	}
+}
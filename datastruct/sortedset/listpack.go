@@ -0,0 +1,102 @@
+package sortedset
+
+import (
+	"slices"
+	"sort"
+)
+
+// listpack 编码
+// 与 redis 相同，小的有序集合按 (score, member) 的顺序保存在一个切片中，不需要跳表节点和成员字典，
+// 按成员查找时顺序比较，按分数和排名查找时二分。成员数超过 zset-max-listpack-entries 或者成员长度超过
+// zset-max-listpack-value 时转换为跳表，之后不再转换回来。切片中的 Element 与跳表相同不会被原地修改
+
+const (
+	defaultListPackEntries = 128
+	defaultListPackValue   = 64
+)
+
+// elementLess 与跳表相同，先按分数再按成员排序
+func elementLess(a *Element, score float64, member string) bool {
+	return a.Score < score || (a.Score == score && a.Member < member)
+}
+
+// listIndex 返回成员在切片中的位置，不存在时返回 -1
+func (sortedSet *SortedSet) listIndex(member string) int {
+	for i, element := range sortedSet.list {
+		if element.Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// listInsert 按顺序插入一个新成员
+func (sortedSet *SortedSet) listInsert(member string, score float64) {
+	i := sort.Search(len(sortedSet.list), func(i int) bool {
+		return !elementLess(sortedSet.list[i], score, member)
+	})
+	sortedSet.list = slices.Insert(sortedSet.list, i, &Element{Member: member, Score: score})
+}
+
+// convertToSkiplist 把 listpack 编码转换为跳表
+func (sortedSet *SortedSet) convertToSkiplist() {
+	sortedSet.skiplist = makeSkiplist()
+	sortedSet.dict = make(map[string]*Element, len(sortedSet.list))
+	for _, element := range sortedSet.list {
+		sortedSet.skiplist.insert(element.Score, element.Member)
+		sortedSet.dict[element.Member] = element
+	}
+	sortedSet.list = nil
+}
+
+// listFirstInRange 返回第一个在区间内的位置，没有时返回 len(list)
+func (sortedSet *SortedSet) listFirstInRange(min Border, max Border) int {
+	i := sort.Search(len(sortedSet.list), func(i int) bool {
+		return min.less(sortedSet.list[i])
+	})
+	if i < len(sortedSet.list) && !max.greater(sortedSet.list[i]) {
+		return len(sortedSet.list)
+	}
+	return i
+}
+
+// listLastInRange 返回最后一个在区间内的位置，没有时返回 -1
+func (sortedSet *SortedSet) listLastInRange(min Border, max Border) int {
+	i := sort.Search(len(sortedSet.list), func(i int) bool {
+		return !max.greater(sortedSet.list[i])
+	}) - 1
+	if i >= 0 && !min.less(sortedSet.list[i]) {
+		return -1
+	}
+	return i
+}
+
+// listRemove 删除 [start, stop) 位置上的成员并返回它们
+func (sortedSet *SortedSet) listRemove(start int, stop int) []*Element {
+	removed := slices.Clone(sortedSet.list[start:stop])
+	sortedSet.list = slices.Delete(sortedSet.list, start, stop)
+	return removed
+}
+
+// listForEach 与 ForEach 相同，按分数区间遍历 listpack 编码的集合
+func (sortedSet *SortedSet) listForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	var i, step int
+	if desc {
+		i, step = sortedSet.listLastInRange(min, max), -1
+	} else {
+		i, step = sortedSet.listFirstInRange(min, max), 1
+	}
+	if offset > 0 {
+		i += int(offset) * step
+	}
+	for n := int64(0); (n < limit || limit < 0) && i >= 0 && i < len(sortedSet.list); n++ {
+		element := sortedSet.list[i]
+		if !min.less(element) || !max.greater(element) {
+			break
+		}
+		if !consumer(element) {
+			break
+		}
+		i += step
+	}
+}
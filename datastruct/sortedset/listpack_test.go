@@ -0,0 +1,101 @@
+package sortedset
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func elementsOf(z *SortedSet) []Element {
+	var result []Element
+	z.ForEachByRank(0, z.Len(), false, func(element *Element) bool {
+		result = append(result, *element)
+		return true
+	})
+	return result
+}
+
+// listpack 编码的集合与跳表的集合执行相同的操作，结果应该一致
+func TestListPackEquivalence(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	lp, sl := Make(), Make()
+	sl.convertToSkiplist()
+	for i := 0; i < 5000; i++ {
+		member := "m" + strconv.Itoa(r.Intn(60))
+		score := float64(r.Intn(20))
+		switch r.Intn(6) {
+		case 0, 1:
+			if lp.Add(member, score) != sl.Add(member, score) {
+				t.Fatalf("add %s %v: different result", member, score)
+			}
+		case 2:
+			if lp.Remove(member) != sl.Remove(member) {
+				t.Fatalf("remove %s: different result", member)
+			}
+		case 3:
+			min, max := NewScoreBorder(score, r.Intn(2) == 0), NewScoreBorder(score+float64(r.Intn(5)), r.Intn(2) == 0)
+			offset, limit, desc := int64(r.Intn(3)), int64(r.Intn(5)-1), r.Intn(2) == 0
+			if got, want := lp.Range(min, max, offset, limit, desc), sl.Range(min, max, offset, limit, desc); !reflect.DeepEqual(got, want) {
+				t.Fatalf("range [%v %v] offset %d limit %d desc %v: expected %v, actually %v", min, max, offset, limit, desc, want, got)
+			}
+			if lp.RangeCount(min, max) != sl.RangeCount(min, max) {
+				t.Fatalf("range count [%v %v]: different result", min, max)
+			}
+		case 4:
+			if r.Intn(10) == 0 {
+				min, max := NewScoreBorder(score, false), NewScoreBorder(score+2, true)
+				if lp.RemoveRange(min, max) != sl.RemoveRange(min, max) {
+					t.Fatalf("remove range [%v %v]: different result", min, max)
+				}
+			} else if r.Intn(10) == 0 {
+				start := int64(r.Intn(10))
+				if lp.RemoveByRank(start, start+2) != sl.RemoveByRank(start, start+2) {
+					t.Fatalf("remove by rank %d: different result", start)
+				}
+			}
+		case 5:
+			desc := r.Intn(2) == 0
+			if lp.GetRank(member, desc) != sl.GetRank(member, desc) {
+				t.Fatalf("rank of %s: different result", member)
+			}
+			if r.Intn(20) == 0 {
+				if got, want := lp.PopMax(2), sl.PopMax(2); !reflect.DeepEqual(got, want) {
+					t.Fatalf("pop max: expected %v, actually %v", want, got)
+				}
+			}
+		}
+		if got, want := elementsOf(lp), elementsOf(sl); !reflect.DeepEqual(got, want) {
+			t.Fatalf("step %d: expected %v, actually %v", i, want, got)
+		}
+	}
+	if !lp.IsListPack() {
+		t.Fatal("expected listpack encoding")
+	}
+}
+
+func TestListPackConvert(t *testing.T) {
+	z := MakeWithListPackLimits(4, 8)
+	for i := 0; i < 4; i++ {
+		z.Add("m"+strconv.Itoa(i), float64(-i))
+	}
+	if !z.IsListPack() {
+		t.Fatal("expected listpack encoding")
+	}
+	z.Add("m4", 10)
+	if z.IsListPack() {
+		t.Fatal("expected skiplist encoding after exceeding entries")
+	}
+	if member, score := z.GetByRank(0, false); member != "m3" || score != -3 {
+		t.Errorf("expected m3 -3, actually %s %v", member, score)
+	}
+	if z.Len() != 5 || z.GetRank("m4", false) != 4 {
+		t.Errorf("unexpected len %d or rank %d", z.Len(), z.GetRank("m4", false))
+	}
+
+	z = MakeWithListPackLimits(4, 8)
+	z.Add("a-long-member", 1)
+	if z.IsListPack() {
+		t.Fatal("expected skiplist encoding for long member")
+	}
+}
@@ -6,7 +6,8 @@ import (
 )
 
 const (
-	maxLevel = 16
+	// 每层以 1/2 的概率晋升，32 层足够支撑上亿元素
+	maxLevel = 32
 )
 
 // 对外的元素抽象
@@ -96,7 +97,7 @@ func (sl *skiplist) GetRank(score float64, member string) int64 {
 }
 
 func (skiplist *skiplist) hasInRange(min Border, max Border) bool {
-	if min.isIntersected(max) { //是有交集的，则返回false
+	if min.isEmptyRange(max) {
 		return false
 	}
 	// min > tail
@@ -112,6 +113,14 @@ func (skiplist *skiplist) hasInRange(min Border, max Border) bool {
 	return true
 }
 func (skiplist *skiplist) getFirstInRange(min Border, max Border) *Node {
+	if min == Border(scoreNegativeInfBorder) {
+		// 下界是 -inf 时第一个节点就是结果，不需要逐层查找
+		n := skiplist.header.level[0].forward
+		if n == nil || !max.greater(&n.Element) {
+			return nil
+		}
+		return n
+	}
 	if !skiplist.hasInRange(min, max) { // 检查范围内是否有元素
 		return nil
 	}
@@ -132,6 +141,13 @@ func (skiplist *skiplist) getFirstInRange(min Border, max Border) *Node {
 }
 
 func (skiplist *skiplist) getLastInRange(min Border, max Border) *Node {
+	if max == Border(scorePositiveInfBorder) {
+		n := skiplist.tail
+		if n == nil || !min.less(&n.Element) {
+			return nil
+		}
+		return n
+	}
 	if !skiplist.hasInRange(min, max) { // 检查范围内是否有元素
 		return nil
 	}
@@ -153,7 +169,7 @@ func (skiplist *skiplist) getLastInRange(min Border, max Border) *Node {
  * param update: backward node (of target)
  */
 func (skiplist *skiplist) removeNode(node *Node, update []*Node) {
-	for i := 0; i < len(update); i++ {
+	for i := int16(0); i < skiplist.level; i++ {
 		if update[i].level[i].forward == node {
 			update[i].level[i].span += node.level[i].span - 1
 			update[i].level[i].forward = node.level[i].forward
@@ -224,6 +240,15 @@ func (skiplist *skiplist) insert(score float64, member string) *Node {
 		}
 	}
 	level := randomLevel()
+	if level > skiplist.level {
+		// 新增的层从 header 开始，header 到末尾跨越整个跳表
+		for i := skiplist.level; i < level; i++ {
+			rank[i] = 0
+			update[i] = skiplist.header
+			update[i].level[i].span = skiplist.length
+		}
+		skiplist.level = level
+	}
 	node = makeNode(level, score, member)
 	for i := int16(0); i < level; i++ {
 		node.level[i].forward = update[i].level[i].forward
@@ -253,10 +278,14 @@ func (skiplist *skiplist) insert(score float64, member string) *Node {
 	return node
 }
 
+// randomLevel 返回 [1, maxLevel] 之间的层数，P(level > k) = 1/2^k
+// 随机数末尾连续 0 的个数服从参数为 1/2 的几何分布
 func randomLevel() int16 {
-	total := uint64(1)<<uint64(maxLevel) - 1
-	k := rand.Uint64() % total
-	return maxLevel - int16(bits.Len64(k+1)) + 1
+	level := int16(bits.TrailingZeros64(rand.Uint64())) + 1
+	if level > maxLevel {
+		return maxLevel
+	}
+	return level
 }
 
 func (skiplist *skiplist) getRank(member string, score float64) int64 {
@@ -343,3 +372,58 @@ func (skiplist *skiplist) RemoveRangeByRank(start int64, stop int64) (removed []
 	}
 	return removed
 }
+
+// clone 按原有的层高逐个复制节点，顺序追加，不需要重新查找插入位置
+func (skiplist *skiplist) clone() *skiplist {
+	result := makeSkiplist()
+	result.level = skiplist.level
+	result.length = skiplist.length
+	// tails[i] 为第 i 层当前最后一个节点，tailRanks[i] 为它的排名
+	tails := make([]*Node, maxLevel)
+	tailRanks := make([]int64, maxLevel)
+	for i := range tails {
+		tails[i] = result.header
+	}
+	var rank int64
+	var prev *Node
+	for n := skiplist.header.level[0].forward; n != nil; n = n.level[0].forward {
+		rank++
+		node := makeNode(int16(len(n.level)), n.Score, n.Member)
+		for i := range node.level {
+			tails[i].level[i].forward = node
+			tails[i].level[i].span = rank - tailRanks[i]
+			tails[i] = node
+			tailRanks[i] = rank
+		}
+		node.backward = prev
+		prev = node
+	}
+	for i := range tails {
+		tails[i].level[i].span = rank - tailRanks[i]
+	}
+	result.tail = prev
+	return result
+}
+
+// LevelStat 跳表中一层的统计信息
+type LevelStat struct {
+	Nodes   int64   // 该层的节点数
+	AvgSpan float64 // 该层相邻节点之间的平均跨度
+}
+
+// levelStats 统计每一层的节点数和平均跨度，只统计正在使用的层
+func (skiplist *skiplist) levelStats() []LevelStat {
+	stats := make([]LevelStat, skiplist.level)
+	for i := int16(0); i < skiplist.level; i++ {
+		var nodes, spans int64
+		for n := skiplist.header; n.level[i].forward != nil; n = n.level[i].forward {
+			nodes++
+			spans += n.level[i].span
+		}
+		stats[i].Nodes = nodes
+		if nodes > 0 {
+			stats[i].AvgSpan = float64(spans) / float64(nodes)
+		}
+	}
+	return stats
+}
@@ -1,28 +1,93 @@
 package sortedset
 
 import (
+	"math/rand"
+	"slices"
 	"strconv"
 
 	"github.com/zhangming/go-redis/lib/wildcard"
 )
 
 type SortedSet struct {
+	// listpack 编码时 skiplist 和 dict 为 nil，见 listpack.go
+	list     []*Element
 	skiplist *skiplist
 	dict     map[string]*Element
+	// listpack 转换的阈值，创建时确定，之后不随配置变化
+	maxEntries int
+	maxValue   int
 }
 
+// Make creates a sorted set, it starts listpack encoded and converts to skiplist when needed
 func Make() *SortedSet {
+	return MakeWithListPackLimits(0, 0)
+}
+
+// MakeWithListPackLimits creates a sorted set which converts to skiplist when it has more than entries members
+// or a member is longer than value, non-positive values use the defaults 128 and 64
+func MakeWithListPackLimits(entries int, value int) *SortedSet {
+	if entries <= 0 {
+		entries = defaultListPackEntries
+	}
+	if value <= 0 {
+		value = defaultListPackValue
+	}
+	return &SortedSet{maxEntries: entries, maxValue: value}
+}
+
+// IsListPack returns whether the sorted set is listpack encoded
+func (sortedSet *SortedSet) IsListPack() bool {
+	return sortedSet.skiplist == nil
+}
+
+// Clone 复制跳表和字典，Element 会被原地修改所以不能共享
+func (sortedSet *SortedSet) Clone() *SortedSet {
+	if sortedSet.skiplist == nil {
+		list := make([]*Element, len(sortedSet.list))
+		for i, element := range sortedSet.list {
+			list[i] = &Element{Member: element.Member, Score: element.Score}
+		}
+		return &SortedSet{list: list, maxEntries: sortedSet.maxEntries, maxValue: sortedSet.maxValue}
+	}
+	sl := sortedSet.skiplist.clone()
+	dict := make(map[string]*Element, len(sortedSet.dict))
+	for n := sl.header.level[0].forward; n != nil; n = n.level[0].forward {
+		dict[n.Member] = &Element{
+			Member: n.Member,
+			Score:  n.Score,
+		}
+	}
 	return &SortedSet{
-		dict:     make(map[string]*Element),
-		skiplist: makeSkiplist(),
+		dict:       dict,
+		skiplist:   sl,
+		maxEntries: sortedSet.maxEntries,
+		maxValue:   sortedSet.maxValue,
 	}
 }
 
+// LevelStats 返回跳表每一层的统计信息，用于诊断跳表是否退化
+// listpack 编码相当于只有一层
+func (sortedSet *SortedSet) LevelStats() []LevelStat {
+	if sortedSet.skiplist == nil {
+		return []LevelStat{{Nodes: int64(len(sortedSet.list)), AvgSpan: 1}}
+	}
+	return sortedSet.skiplist.levelStats()
+}
+
 func (sortedSet *SortedSet) Len() int64 {
+	if sortedSet.skiplist == nil {
+		return int64(len(sortedSet.list))
+	}
 	return int64(len(sortedSet.dict))
 }
 
 func (sortedSet *SortedSet) Get(key string) (*Element, bool) {
+	if sortedSet.skiplist == nil {
+		if i := sortedSet.listIndex(key); i >= 0 {
+			return sortedSet.list[i], true
+		}
+		return nil, false
+	}
 	val, ok := sortedSet.dict[key]
 	if !ok {
 		return nil, false
@@ -31,6 +96,14 @@ func (sortedSet *SortedSet) Get(key string) (*Element, bool) {
 }
 
 func (sortedSet *SortedSet) Remove(member string) bool {
+	if sortedSet.skiplist == nil {
+		i := sortedSet.listIndex(member)
+		if i < 0 {
+			return false
+		}
+		sortedSet.listRemove(i, i+1)
+		return true
+	}
 	val, ok := sortedSet.dict[member]
 	if ok {
 		sortedSet.skiplist.remove(val.Score, member)
@@ -41,6 +114,21 @@ func (sortedSet *SortedSet) Remove(member string) bool {
 }
 
 func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	if sortedSet.skiplist == nil {
+		i := sortedSet.listIndex(member)
+		if i >= 0 {
+			if sortedSet.list[i].Score != score {
+				sortedSet.listRemove(i, i+1)
+				sortedSet.listInsert(member, score)
+			}
+			return false
+		}
+		if len(sortedSet.list) < sortedSet.maxEntries && len(member) <= sortedSet.maxValue {
+			sortedSet.listInsert(member, score)
+			return true
+		}
+		sortedSet.convertToSkiplist()
+	}
 	element, ok := sortedSet.dict[member]
 	sortedSet.dict[member] = &Element{
 		Member: member,
@@ -60,6 +148,13 @@ func (sortedSet *SortedSet) Add(member string, score float64) bool {
 }
 
 func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
+	if sortedSet.skiplist == nil {
+		i := int64(sortedSet.listIndex(member))
+		if i >= 0 && desc {
+			i = int64(len(sortedSet.list)) - 1 - i
+		}
+		return i
+	}
 	v, ok := sortedSet.dict[member]
 	if !ok {
 		return -1
@@ -74,11 +169,21 @@ func (sortedSet *SortedSet) GetRank(member string, desc bool) (rank int64) {
 }
 
 func (sortedSet *SortedSet) GetByRank(rank int64, desc bool) (member string, score float64) {
-	if rank < 0 || rank >= sortedSet.skiplist.length {
+	if rank < 0 || rank >= sortedSet.Len() {
 		return "", 0
 	}
+	if sortedSet.skiplist == nil {
+		if desc {
+			rank = sortedSet.Len() - 1 - rank
+		}
+		element := sortedSet.list[rank]
+		return element.Member, element.Score
+	}
+	// rank 从 0 开始，跳表中的排名从 1 开始
 	if desc {
 		rank = sortedSet.skiplist.length - rank
+	} else {
+		rank++
 	}
 	element := sortedSet.skiplist.getByRank(rank)
 	return element.Member, element.Score
@@ -93,6 +198,18 @@ func (sortedSet *SortedSet) ForEachByRank(start int64, stop int64, desc bool, co
 	if stop < 0 || stop > size {
 		panic("stop out of range")
 	}
+	if sortedSet.skiplist == nil {
+		for i := start; i < stop; i++ {
+			j := i
+			if desc {
+				j = size - 1 - i
+			}
+			if !consumer(sortedSet.list[j]) {
+				break
+			}
+		}
+		return
+	}
 
 	// 寻找开始的节点
 	var node *Node
@@ -153,6 +270,10 @@ func (sortedSet *SortedSet) RangeCount(min Border, max Border) int64 {
 
 // 按分数区间遍历
 func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	if sortedSet.skiplist == nil {
+		sortedSet.listForEach(min, max, offset, limit, desc, consumer)
+		return
+	}
 	var node *Node
 	if desc {
 		node = sortedSet.skiplist.getLastInRange(min, max)
@@ -169,7 +290,14 @@ func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit
 		}
 		offset--
 	}
-	for i := 0; (i < int(limit) || limit < 0) && node != nil; i++ {
+	// limit 小于 0 表示不限制数量
+	for i := int64(0); (i < limit || limit < 0) && node != nil; i++ {
+		// 跳过 offset 个节点之后可能已经越过了边界
+		gtMin := min.less(&node.Element) // greater than min
+		ltMax := max.greater(&node.Element)
+		if !gtMin || !ltMax {
+			break // break through score border
+		}
 		if !consumer(&node.Element) {
 			break
 		}
@@ -178,19 +306,12 @@ func (sortedSet *SortedSet) ForEach(min Border, max Border, offset int64, limit
 		} else {
 			node = node.level[0].forward
 		}
-		if node == nil {
-			break
-		}
-		gtMin := min.less(&node.Element) // greater than min
-		ltMax := max.greater(&node.Element)
-		if !gtMin || !ltMax {
-			break // break through score border
-		}
 	}
 }
 
+// Range 返回区间内的元素，与 redis 的 LIMIT 相同，offset 小于 0 时返回空，limit 小于 0 时返回 offset 之后的所有元素
 func (sortedSet *SortedSet) Range(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
-	if limit < 0 || offset < 0 {
+	if offset < 0 {
 		return []*Element{}
 	}
 	slices := make([]*Element, 0)
@@ -202,6 +323,14 @@ func (sortedSet *SortedSet) Range(min Border, max Border, offset int64, limit in
 }
 
 func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
+	if sortedSet.skiplist == nil {
+		start := sortedSet.listFirstInRange(min, max)
+		stop := start
+		for stop < len(sortedSet.list) && max.greater(sortedSet.list[stop]) {
+			stop++
+		}
+		return int64(len(sortedSet.listRemove(start, stop)))
+	}
 	removed := sortedSet.skiplist.RemoveRange(min, max, 0)
 	for _, element := range removed {
 		delete(sortedSet.dict, element.Member)
@@ -210,6 +339,13 @@ func (sortedSet *SortedSet) RemoveRange(min Border, max Border) int64 {
 }
 
 func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
+	if sortedSet.skiplist == nil {
+		start, stop = max(start, 0), min(stop, sortedSet.Len())
+		if start >= stop {
+			return 0
+		}
+		return int64(len(sortedSet.listRemove(int(start), int(stop))))
+	}
 	removed := sortedSet.skiplist.RemoveRangeByRank(start+1, stop+1)
 	for _, element := range removed {
 		delete(sortedSet.dict, element.Member)
@@ -217,16 +353,51 @@ func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
 	return int64(len(removed))
 }
 
+// PeekMin returns the member with the lowest score without removing it,
+// 直接读取跳表的第一个节点，与集合大小无关并且不分配内存，供轮询任务队列和阻塞的 BZPOPMIN 唤醒使用
+func (sortedSet *SortedSet) PeekMin() (member string, score float64, ok bool) {
+	if sortedSet.skiplist == nil {
+		if len(sortedSet.list) == 0 {
+			return "", 0, false
+		}
+		return sortedSet.list[0].Member, sortedSet.list[0].Score, true
+	}
+	n := sortedSet.skiplist.header.level[0].forward
+	if n == nil {
+		return "", 0, false
+	}
+	return n.Member, n.Score, true
+}
+
+// PeekMax returns the member with the highest score without removing it
+func (sortedSet *SortedSet) PeekMax() (member string, score float64, ok bool) {
+	if sortedSet.skiplist == nil {
+		if len(sortedSet.list) == 0 {
+			return "", 0, false
+		}
+		last := sortedSet.list[len(sortedSet.list)-1]
+		return last.Member, last.Score, true
+	}
+	n := sortedSet.skiplist.tail
+	if n == nil {
+		return "", 0, false
+	}
+	return n.Member, n.Score, true
+}
+
 func (sortedSet *SortedSet) PopMin(count int) []*Element {
+	if sortedSet.skiplist == nil {
+		if len(sortedSet.list) == 0 {
+			return nil
+		}
+		return sortedSet.listRemove(0, min(count, len(sortedSet.list)))
+	}
 	// 获取最小元素
 	first := sortedSet.skiplist.getFirstInRange(scoreNegativeInfBorder, scorePositiveInfBorder)
 	if first == nil {
 		return nil
 	}
-	border := &ScoreBorder{
-		Value:   first.Score,
-		Exclude: false,
-	}
+	border := NewScoreBorder(first.Score, false)
 	// 移除
 	removed := sortedSet.skiplist.RemoveRange(border, scorePositiveInfBorder, count)
 	for _, element := range removed {
@@ -235,12 +406,101 @@ func (sortedSet *SortedSet) PopMin(count int) []*Element {
 	return removed
 }
 
+// PopMax removes and returns at most count members with the highest scores, in descending order
+func (sortedSet *SortedSet) PopMax(count int) []*Element {
+	if sortedSet.skiplist == nil {
+		n := len(sortedSet.list)
+		removed := sortedSet.listRemove(n-min(count, n), n)
+		slices.Reverse(removed)
+		return removed
+	}
+	removed := make([]*Element, 0, min(count, len(sortedSet.dict)))
+	for len(removed) < count {
+		n := sortedSet.skiplist.tail
+		if n == nil {
+			break
+		}
+		element := n.Element
+		removed = append(removed, &element)
+		sortedSet.Remove(element.Member)
+	}
+	return removed
+}
+
+// RandomMembers returns limit members chosen at random, a member may be returned more than once
+func (sortedSet *SortedSet) RandomMembers(limit int) []*Element {
+	size := sortedSet.Len()
+	if size == 0 || limit <= 0 {
+		return nil
+	}
+	result := make([]*Element, limit)
+	for i := range result {
+		// 跳表中的排名从 1 开始
+		element := sortedSet.elementByRank(rand.Int63n(size) + 1)
+		result[i] = &element
+	}
+	return result
+}
+
+// elementByRank 返回排名(从 1 开始)对应元素的副本
+func (sortedSet *SortedSet) elementByRank(rank int64) Element {
+	if sortedSet.skiplist == nil {
+		return *sortedSet.list[rank-1]
+	}
+	return sortedSet.skiplist.getByRank(rank).Element
+}
+
+// RandomDistinctMembers returns at most limit distinct members in random order
+func (sortedSet *SortedSet) RandomDistinctMembers(limit int) []*Element {
+	size := sortedSet.Len()
+	if limit <= 0 || size == 0 {
+		return nil
+	}
+	var ranks []int64
+	if int64(limit) >= size {
+		ranks = make([]int64, size)
+		for i := range ranks {
+			ranks[i] = int64(i) + 1
+		}
+	} else {
+		// Floyd 算法，只生成 limit 个随机数就能选出不重复的排名
+		chosen := make(map[int64]struct{}, limit)
+		ranks = make([]int64, 0, limit)
+		for j := size - int64(limit) + 1; j <= size; j++ {
+			rank := rand.Int63n(j) + 1
+			if _, ok := chosen[rank]; ok {
+				rank = j
+			}
+			chosen[rank] = struct{}{}
+			ranks = append(ranks, rank)
+		}
+	}
+	rand.Shuffle(len(ranks), func(i, j int) {
+		ranks[i], ranks[j] = ranks[j], ranks[i]
+	})
+	result := make([]*Element, len(ranks))
+	for i, rank := range ranks {
+		element := sortedSet.elementByRank(rank)
+		result[i] = &element
+	}
+	return result
+}
+
 func (sortedSet *SortedSet) ZSetScan(cursor int, count int, pattern string) ([][]byte, int) {
 	result := make([][]byte, 0)
 	matchKey, err := wildcard.CompilePattern(pattern)
 	if err != nil {
 		return result, -1
 	}
+	if sortedSet.skiplist == nil {
+		for _, elem := range sortedSet.list {
+			if pattern == "*" || matchKey.IsMatch(elem.Member) {
+				result = append(result, []byte(elem.Member))
+				result = append(result, []byte(strconv.FormatFloat(elem.Score, 'f', 10, 64)))
+			}
+		}
+		return result, 0
+	}
 	for k := range sortedSet.dict {
 		if pattern == "*" || matchKey.IsMatch(k) {
 			elem, exists := sortedSet.dict[k]
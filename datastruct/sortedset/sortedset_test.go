@@ -0,0 +1,221 @@
+package sortedset
+
+import (
+	"strconv"
+	"testing"
+)
+
+func makeTestSortedSet(n int) *SortedSet {
+	z := Make()
+	for i := 0; i < n; i++ {
+		z.Add("m"+strconv.Itoa(i), float64(i%97))
+	}
+	return z
+}
+
+func TestClone(t *testing.T) {
+	z := makeTestSortedSet(1000)
+	c := z.Clone()
+	if c.Len() != z.Len() {
+		t.Fatalf("expected len %d, actually %d", z.Len(), c.Len())
+	}
+	for rank := int64(0); rank < z.Len(); rank++ {
+		member, score := z.GetByRank(rank, false)
+		cMember, cScore := c.GetByRank(rank, false)
+		if member != cMember || score != cScore {
+			t.Fatalf("rank %d: expected %s %v, actually %s %v", rank, member, score, cMember, cScore)
+		}
+		if got := c.GetRank(member, true); got != z.GetRank(member, true) {
+			t.Fatalf("reverse rank of %s: expected %d, actually %d", member, z.GetRank(member, true), got)
+		}
+	}
+
+	// the clone must be independent of the original
+	c.Add("m0", 1000)
+	c.Remove("m1")
+	c.Add("new", -1)
+	if e, _ := z.Get("m0"); e.Score != 0 {
+		t.Errorf("original score changed to %v", e.Score)
+	}
+	if _, ok := z.Get("m1"); !ok {
+		t.Error("original lost m1")
+	}
+	if _, ok := z.Get("new"); ok {
+		t.Error("original got new member")
+	}
+	if member, _ := c.GetByRank(0, false); member != "new" {
+		t.Errorf("expected new at rank 0, actually %s", member)
+	}
+	if member, _ := c.GetByRank(0, true); member != "m0" {
+		t.Errorf("expected m0 at last rank, actually %s", member)
+	}
+}
+
+func BenchmarkClone(b *testing.B) {
+	z := makeTestSortedSet(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		z.Clone()
+	}
+}
+
+// BenchmarkCopyByAdd 逐个插入的复制方式，作为 Clone 的对比
+func BenchmarkCopyByAdd(b *testing.B) {
+	z := makeTestSortedSet(100000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c := Make()
+		z.ForEachByRank(0, z.Len(), false, func(element *Element) bool {
+			c.Add(element.Member, element.Score)
+			return true
+		})
+	}
+}
+
+func TestRandomLevel(t *testing.T) {
+	const n = 1 << 20
+	counts := make([]int, maxLevel+1)
+	for i := 0; i < n; i++ {
+		level := randomLevel()
+		if level < 1 || level > maxLevel {
+			t.Fatalf("level %d out of range", level)
+		}
+		counts[level]++
+	}
+	// P(level >= k) = 1/2^(k-1)
+	atLeast := n
+	for k := 1; k <= 8; k++ {
+		expected := n >> (k - 1)
+		if diff := atLeast - expected; diff > expected/20 || diff < -expected/20 {
+			t.Errorf("level >= %d: expected about %d, actually %d", k, expected, atLeast)
+		}
+		atLeast -= counts[k]
+	}
+}
+
+func TestLevelStats(t *testing.T) {
+	z := makeTestSortedSet(10000)
+	stats := z.LevelStats()
+	if stats[0].Nodes != 10000 || stats[0].AvgSpan != 1 {
+		t.Errorf("unexpected level 1 %+v", stats[0])
+	}
+	for i := 1; i < len(stats); i++ {
+		if stats[i].Nodes > stats[i-1].Nodes {
+			t.Errorf("level %d has more nodes than level %d", i+1, i)
+		}
+	}
+	// 删除全部元素后层数回到 1
+	for i := 0; i < 10000; i++ {
+		z.Remove("m" + strconv.Itoa(i))
+	}
+	if stats := z.LevelStats(); len(stats) != 1 || stats[0].Nodes != 0 {
+		t.Errorf("unexpected stats after remove all %+v", stats)
+	}
+}
+
+func TestPeek(t *testing.T) {
+	z := Make()
+	if _, _, ok := z.PeekMin(); ok {
+		t.Fatal("empty set should have no min")
+	}
+	if _, _, ok := z.PeekMax(); ok {
+		t.Fatal("empty set should have no max")
+	}
+	z.Add("b", 2)
+	z.Add("a", 1)
+	z.Add("c", 3)
+	if member, score, _ := z.PeekMin(); member != "a" || score != 1 {
+		t.Errorf("expected min a 1, actually %s %v", member, score)
+	}
+	if member, score, _ := z.PeekMax(); member != "c" || score != 3 {
+		t.Errorf("expected max c 3, actually %s %v", member, score)
+	}
+	z.PopMin(1)
+	if member, _, _ := z.PeekMin(); member != "b" {
+		t.Errorf("expected min b after pop, actually %s", member)
+	}
+	if z.Len() != 2 {
+		t.Errorf("peek should not remove members, len %d", z.Len())
+	}
+	if popped := z.PopMax(5); len(popped) != 2 || popped[0].Member != "c" || popped[1].Member != "b" {
+		t.Errorf("unexpected PopMax result %+v", popped)
+	}
+	if _, _, ok := z.PeekMax(); ok || z.Len() != 0 {
+		t.Errorf("PopMax should remove all members, len %d", z.Len())
+	}
+
+	// -inf/+inf 的快速路径与普通查找结果相同
+	z = makeTestSortedSet(1000)
+	for _, max := range []float64{-1, 0, 50, 96, 100} {
+		first := z.Range(scoreNegativeInfBorder, NewScoreBorder(max, false), 0, 1, false)
+		expected := z.Range(NewScoreBorder(-1e9, false), NewScoreBorder(max, false), 0, 1, false)
+		if len(first) != len(expected) || (len(first) > 0 && *first[0] != *expected[0]) {
+			t.Errorf("max %v: expected %v, actually %v", max, expected, first)
+		}
+		last := z.Range(NewScoreBorder(max, false), scorePositiveInfBorder, 0, 1, true)
+		expected = z.Range(NewScoreBorder(max, false), NewScoreBorder(1e9, false), 0, 1, true)
+		if len(last) != len(expected) || (len(last) > 0 && *last[0] != *expected[0]) {
+			t.Errorf("min %v: expected %v, actually %v", max, expected, last)
+		}
+	}
+}
+
+// BenchmarkPeekMin 耗时与集合大小无关并且没有内存分配
+func BenchmarkPeekMin(b *testing.B) {
+	for _, n := range []int{100, 10000, 1000000} {
+		z := makeTestSortedSet(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				z.PeekMin()
+			}
+		})
+	}
+}
+
+// BenchmarkRangeByScoreFirst ZRANGEBYSCORE key -inf +inf LIMIT 0 1
+func BenchmarkRangeByScoreFirst(b *testing.B) {
+	for _, n := range []int{100, 10000, 1000000} {
+		z := makeTestSortedSet(n)
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				z.ForEach(scoreNegativeInfBorder, scorePositiveInfBorder, 0, 1, false, func(element *Element) bool {
+					return true
+				})
+			}
+		})
+	}
+}
+
+func TestRandomMembers(t *testing.T) {
+	z := makeTestSortedSet(100)
+	for _, limit := range []int{1, 10, 99, 100, 200} {
+		members := z.RandomDistinctMembers(limit)
+		if len(members) != min(limit, 100) {
+			t.Errorf("limit %d: expected %d members, actually %d", limit, min(limit, 100), len(members))
+		}
+		seen := make(map[string]struct{})
+		for _, element := range members {
+			if _, ok := seen[element.Member]; ok {
+				t.Errorf("limit %d: duplicate member %s", limit, element.Member)
+			}
+			seen[element.Member] = struct{}{}
+			if stored, ok := z.Get(element.Member); !ok || stored.Score != element.Score {
+				t.Errorf("limit %d: unexpected element %+v", limit, element)
+			}
+		}
+	}
+	members := z.RandomMembers(500)
+	if len(members) != 500 {
+		t.Errorf("expected 500 members, actually %d", len(members))
+	}
+	for _, element := range members {
+		if stored, ok := z.Get(element.Member); !ok || stored.Score != element.Score {
+			t.Errorf("unexpected element %+v", element)
+		}
+	}
+	if Make().RandomMembers(3) != nil || Make().RandomDistinctMembers(3) != nil {
+		t.Error("empty set should return no members")
+	}
+}
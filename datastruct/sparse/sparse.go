@@ -0,0 +1,293 @@
+package sparse
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// 稀疏字符串
+// 按固定大小的块存储字符串，全零的块不分配内存。
+// 用于 SETBIT/SETRANGE 在很大的偏移量上写入少量数据的场景，例如以用户 id 作为偏移量的 bitmap
+
+// ChunkSize 每个块的字节数
+const ChunkSize = 4096
+
+// String is a byte string whose zero chunks are not allocated
+type String struct {
+	size   int64
+	chunks map[int64][]byte // 块序号 -> 块
+}
+
+// New creates an empty sparse string
+func New() *String {
+	return &String{
+		chunks: make(map[int64][]byte),
+	}
+}
+
+// FromBytes creates a sparse string with the same content as b, all-zero chunks are skipped
+func FromBytes(b []byte) *String {
+	s := New()
+	s.size = int64(len(b))
+	for beg := 0; beg < len(b); beg += ChunkSize {
+		end := beg + ChunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		if isZero(b[beg:end]) {
+			continue
+		}
+		chunk := make([]byte, ChunkSize)
+		copy(chunk, b[beg:end])
+		s.chunks[int64(beg/ChunkSize)] = chunk
+	}
+	return s
+}
+
+func isZero(b []byte) bool {
+	for _, v := range b {
+		if v != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Len returns length of string
+func (s *String) Len() int64 {
+	return s.size
+}
+
+// Allocated returns bytes actually allocated
+func (s *String) Allocated() int64 {
+	return int64(len(s.chunks)) * ChunkSize
+}
+
+// chunk 返回 offset 所在的块，create 为 false 且块不存在时返回 nil
+func (s *String) chunk(offset int64, create bool) []byte {
+	index := offset / ChunkSize
+	chunk, ok := s.chunks[index]
+	if !ok && create {
+		chunk = make([]byte, ChunkSize)
+		s.chunks[index] = chunk
+	}
+	return chunk
+}
+
+// WriteAt overwrites bytes start from offset, string is padded with zero if offset is beyond its length
+func (s *String) WriteAt(offset int64, value []byte) {
+	for len(value) > 0 {
+		chunkOffset := offset % ChunkSize
+		n := int64(len(value))
+		if n > ChunkSize-chunkOffset {
+			n = ChunkSize - chunkOffset
+		}
+		if chunk := s.chunk(offset, false); chunk != nil || !isZero(value[:n]) {
+			chunk = s.chunk(offset, true)
+			copy(chunk[chunkOffset:], value[:n])
+		}
+		offset += n
+		value = value[n:]
+	}
+	if offset > s.size {
+		s.size = offset
+	}
+}
+
+// Grow 把长度扩展到 size，新增的部分为 0
+func (s *String) Grow(size int64) {
+	if size > s.size {
+		s.size = size
+	}
+}
+
+// ReadAt returns bytes in [beg, end)
+func (s *String) ReadAt(beg int64, end int64) []byte {
+	if end > s.size {
+		end = s.size
+	}
+	if beg >= end {
+		return []byte{}
+	}
+	result := make([]byte, end-beg)
+	for offset := beg; offset < end; {
+		chunkOffset := offset % ChunkSize
+		n := ChunkSize - chunkOffset
+		if n > end-offset {
+			n = end - offset
+		}
+		if chunk := s.chunk(offset, false); chunk != nil {
+			copy(result[offset-beg:], chunk[chunkOffset:chunkOffset+n])
+		}
+		offset += n
+	}
+	return result
+}
+
+// Bytes returns the whole string
+func (s *String) Bytes() []byte {
+	return s.ReadAt(0, s.size)
+}
+
+// GetBit returns the bit at offset
+func (s *String) GetBit(offset int64) byte {
+	if offset/8 >= s.size {
+		return 0
+	}
+	chunk := s.chunk(offset/8, false)
+	if chunk == nil {
+		return 0
+	}
+	return chunk[offset/8%ChunkSize] >> (7 - offset%8) & 0x01
+}
+
+// SetBit sets the bit at offset, string grows if needed
+func (s *String) SetBit(offset int64, val byte) {
+	byteIndex := offset / 8
+	s.Grow(byteIndex + 1)
+	if val == 0 {
+		chunk := s.chunk(byteIndex, false)
+		if chunk != nil {
+			chunk[byteIndex%ChunkSize] &^= 0x80 >> (offset % 8)
+		}
+		return
+	}
+	chunk := s.chunk(byteIndex, true)
+	chunk[byteIndex%ChunkSize] |= 0x80 >> (offset % 8)
+}
+
+// sortedChunks 返回按序号排序的块序号
+func (s *String) sortedChunks() []int64 {
+	indexes := make([]int64, 0, len(s.chunks))
+	for index := range s.chunks {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool {
+		return indexes[i] < indexes[j]
+	})
+	return indexes
+}
+
+// CountBits returns number of 1 in bits [beg, end)
+func (s *String) CountBits(beg int64, end int64) int64 {
+	if end > s.size*8 {
+		end = s.size * 8
+	}
+	var count int64
+	for index, chunk := range s.chunks {
+		chunkBeg := index * ChunkSize * 8
+		chunkEnd := chunkBeg + ChunkSize*8
+		if chunkEnd <= beg || chunkBeg >= end {
+			continue
+		}
+		if chunkBeg >= beg && chunkEnd <= end {
+			for _, v := range chunk {
+				count += int64(bits.OnesCount8(v))
+			}
+			continue
+		}
+		for offset := max(beg, chunkBeg); offset < min(end, chunkEnd); offset++ {
+			count += int64(chunk[(offset-chunkBeg)/8] >> (7 - offset%8) & 0x01)
+		}
+	}
+	return count
+}
+
+// BitPos returns offset of the first bit equals val in bits [beg, end), -1 if not found
+func (s *String) BitPos(val byte, beg int64, end int64) int64 {
+	if end > s.size*8 {
+		end = s.size * 8
+	}
+	if val == 0 {
+		// 没有分配的块都是 0，最多检查 len(chunks)+1 个块就能找到
+		for offset := beg; offset < end; {
+			chunk := s.chunk(offset/8, false)
+			chunkEnd := (offset/8/ChunkSize + 1) * ChunkSize * 8
+			if chunk == nil {
+				return offset
+			}
+			for ; offset < min(end, chunkEnd); offset++ {
+				if chunk[offset/8%ChunkSize]>>(7-offset%8)&0x01 == 0 {
+					return offset
+				}
+			}
+		}
+		return -1
+	}
+	for _, index := range s.sortedChunks() {
+		chunkBeg := index * ChunkSize * 8
+		chunkEnd := chunkBeg + ChunkSize*8
+		if chunkEnd <= beg {
+			continue
+		}
+		if chunkBeg >= end {
+			break
+		}
+		chunk := s.chunks[index]
+		for offset := max(beg, chunkBeg); offset < min(end, chunkEnd); offset++ {
+			if chunk[(offset-chunkBeg)/8]>>(7-offset%8)&0x01 == 1 {
+				return offset
+			}
+		}
+	}
+	return -1
+}
+
+// BitOp 对 srcs 按字节做 and/or/xor，结果的长度与最长的 src 相同，较短的 src 视为用 0 补齐。
+// 没有分配的块都是 0，and 只需要计算所有 src 都已分配的块，or/xor 只需要计算任意一个 src 已分配的块
+func BitOp(op string, srcs []*String) *String {
+	result := New()
+	indexes := make(map[int64]struct{})
+	for i, src := range srcs {
+		result.Grow(src.size)
+		for index := range src.chunks {
+			if op != "and" || i == 0 {
+				indexes[index] = struct{}{}
+			}
+		}
+		if op == "and" && i > 0 {
+			for index := range indexes {
+				if _, ok := src.chunks[index]; !ok {
+					delete(indexes, index)
+				}
+			}
+		}
+	}
+	for index := range indexes {
+		chunk := make([]byte, ChunkSize)
+		for i, src := range srcs {
+			srcChunk := src.chunks[index]
+			if srcChunk == nil {
+				continue
+			}
+			for j, v := range srcChunk {
+				switch {
+				case i == 0:
+					chunk[j] = v
+				case op == "and":
+					chunk[j] &= v
+				case op == "or":
+					chunk[j] |= v
+				case op == "xor":
+					chunk[j] ^= v
+				}
+			}
+		}
+		if !isZero(chunk) {
+			result.chunks[index] = chunk
+		}
+	}
+	return result
+}
+
+// Clone returns a deep copy
+func (s *String) Clone() *String {
+	result := New()
+	result.size = s.size
+	for index, chunk := range s.chunks {
+		c := make([]byte, ChunkSize)
+		copy(c, chunk)
+		result.chunks[index] = c
+	}
+	return result
+}
@@ -0,0 +1,120 @@
+package sparse
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// 与直接操作 []byte 的结果比较
+func TestSparseString(t *testing.T) {
+	s := New()
+	var expected []byte
+	for i := 0; i < 200; i++ {
+		offset := rand.Int63n(ChunkSize * 20)
+		value := make([]byte, rand.Intn(ChunkSize*2))
+		rand.Read(value)
+		if i%3 == 0 {
+			// 全零的写入不分配块
+			value = make([]byte, len(value))
+		}
+		s.WriteAt(offset, value)
+		if end := offset + int64(len(value)); end > int64(len(expected)) {
+			expected = append(expected, make([]byte, end-int64(len(expected)))...)
+		}
+		copy(expected[offset:], value)
+	}
+	if !bytes.Equal(s.Bytes(), expected) {
+		t.Fatal("content mismatch")
+	}
+	if !bytes.Equal(FromBytes(expected).Bytes(), expected) {
+		t.Fatal("FromBytes mismatch")
+	}
+	if !bytes.Equal(s.ReadAt(100, 5000), expected[100:5000]) {
+		t.Fatal("ReadAt mismatch")
+	}
+	var ones int64
+	for _, b := range expected[10:9000] {
+		for i := 0; i < 8; i++ {
+			ones += int64(b >> i & 1)
+		}
+	}
+	if count := s.CountBits(80, 72000); count != ones {
+		t.Fatalf("expected %d bits, got %d", ones, count)
+	}
+}
+
+func TestSparseBits(t *testing.T) {
+	s := New()
+	offset := int64(100 << 23) // 100MB
+	s.SetBit(offset, 1)
+	if s.Len() != offset/8+1 {
+		t.Fatalf("unexpected len %d", s.Len())
+	}
+	if s.Allocated() != ChunkSize {
+		t.Fatalf("expected one chunk, got %d bytes", s.Allocated())
+	}
+	if s.GetBit(offset) != 1 || s.GetBit(offset-1) != 0 {
+		t.Fatal("wrong bit")
+	}
+	if pos := s.BitPos(1, 0, s.Len()*8); pos != offset {
+		t.Fatalf("expected first 1 at %d, got %d", offset, pos)
+	}
+	if pos := s.BitPos(0, 0, s.Len()*8); pos != 0 {
+		t.Fatalf("expected first 0 at 0, got %d", pos)
+	}
+	if pos := s.BitPos(1, offset+1, s.Len()*8); pos != -1 {
+		t.Fatalf("expected -1, got %d", pos)
+	}
+	if count := s.CountBits(0, s.Len()*8); count != 1 {
+		t.Fatalf("expected 1 bit, got %d", count)
+	}
+	s.SetBit(offset, 0)
+	if s.GetBit(offset) != 0 || s.CountBits(0, s.Len()*8) != 0 {
+		t.Fatal("bit is not cleared")
+	}
+}
+
+func TestBitOp(t *testing.T) {
+	var srcs []*String
+	var dense [][]byte
+	for i := 0; i < 3; i++ {
+		s := New()
+		for j := 0; j < 5; j++ {
+			value := make([]byte, 100)
+			rand.Read(value)
+			// 前两个块所有 src 都有数据，之后的块随机分布
+			s.WriteAt(int64(j%2)*ChunkSize+rand.Int63n(ChunkSize*int64(1+i*j)), value)
+		}
+		srcs = append(srcs, s)
+		dense = append(dense, s.Bytes())
+	}
+	size := 0
+	for _, b := range dense {
+		size = max(size, len(b))
+	}
+	for _, op := range []string{"and", "or", "xor"} {
+		expected := make([]byte, size)
+		for j := range expected {
+			for i, b := range dense {
+				var v byte
+				if j < len(b) {
+					v = b[j]
+				}
+				switch {
+				case i == 0:
+					expected[j] = v
+				case op == "and":
+					expected[j] &= v
+				case op == "or":
+					expected[j] |= v
+				default:
+					expected[j] ^= v
+				}
+			}
+		}
+		if result := BitOp(op, srcs); !bytes.Equal(result.Bytes(), expected) {
+			t.Fatalf("%s mismatch", op)
+		}
+	}
+}
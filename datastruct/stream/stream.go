@@ -0,0 +1,253 @@
+package stream
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Stream 只追加的消息流，消息按 ID 递增排列
+// 使用有序切片存储，追加和按 ID 二分查找都很快；裁剪只发生在头部，直接移动切片的起点
+
+// ID 消息 ID，由毫秒时间戳和同一毫秒内的序号组成
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+// MinID 和 MaxID 分别对应范围查询中的 - 和 +
+var (
+	MinID = ID{}
+	MaxID = ID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+// Less returns whether id is smaller than other
+func (id ID) Less(other ID) bool {
+	if id.Ms != other.Ms {
+		return id.Ms < other.Ms
+	}
+	return id.Seq < other.Seq
+}
+
+// Next 返回紧跟在 id 之后的 ID，id 已经是最大值时返回 false
+func (id ID) Next() (ID, bool) {
+	if id.Seq < math.MaxUint64 {
+		return ID{Ms: id.Ms, Seq: id.Seq + 1}, true
+	}
+	if id.Ms < math.MaxUint64 {
+		return ID{Ms: id.Ms + 1}, true
+	}
+	return id, false
+}
+
+// Prev 返回紧挨在 id 之前的 ID，id 已经是最小值时返回 false
+func (id ID) Prev() (ID, bool) {
+	if id.Seq > 0 {
+		return ID{Ms: id.Ms, Seq: id.Seq - 1}, true
+	}
+	if id.Ms > 0 {
+		return ID{Ms: id.Ms - 1, Seq: math.MaxUint64}, true
+	}
+	return id, false
+}
+
+// ParseID 解析 ms-seq 或者 ms 格式的 ID，省略序号时 hasSeq 为 false，由调用方决定序号
+func ParseID(s string) (id ID, hasSeq bool, ok bool) {
+	msPart, seqPart, hasSeq := strings.Cut(s, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return ID{}, false, false
+	}
+	id.Ms = ms
+	if !hasSeq {
+		return id, false, true
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return ID{}, false, false
+	}
+	id.Seq = seq
+	return id, true, true
+}
+
+// Entry 一条消息，Fields 中字段名和值交替排列，保留写入时的顺序
+type Entry struct {
+	ID     ID
+	Fields [][]byte
+}
+
+// Stream 消息流
+type Stream struct {
+	entries []*Entry
+	// 写入过的最大 ID，消息被裁剪后仍然保留，新消息的 ID 必须比它大
+	lastID ID
+}
+
+// New creates an empty stream
+func New() *Stream {
+	return &Stream{}
+}
+
+// Len returns number of entries
+func (s *Stream) Len() int {
+	return len(s.entries)
+}
+
+// LastID returns the largest id ever added
+func (s *Stream) LastID() ID {
+	return s.lastID
+}
+
+// SetLastID 设置最大 ID，用于 XSETID 和从持久化文件中恢复
+func (s *Stream) SetLastID(id ID) {
+	s.lastID = id
+}
+
+// Last returns the entry with largest id, nil if stream is empty
+func (s *Stream) Last() *Entry {
+	if len(s.entries) == 0 {
+		return nil
+	}
+	return s.entries[len(s.entries)-1]
+}
+
+// NextID 生成自动 ID：ms 大于上一个 ID 的时间戳时序号从 0 开始，否则沿用上一个时间戳并递增序号
+func (s *Stream) NextID(ms uint64) (ID, bool) {
+	if s.lastID.Ms < ms {
+		return ID{Ms: ms}, true
+	}
+	return s.lastID.Next()
+}
+
+// NextSeq 生成指定时间戳的 ID，ms 小于上一个 ID 的时间戳时返回 false
+func (s *Stream) NextSeq(ms uint64) (ID, bool) {
+	if ms > s.lastID.Ms {
+		return ID{Ms: ms}, true
+	}
+	if ms < s.lastID.Ms || s.lastID.Seq == math.MaxUint64 {
+		return ID{}, false
+	}
+	return ID{Ms: ms, Seq: s.lastID.Seq + 1}, true
+}
+
+// Add 追加一条消息，id 必须大于 LastID
+func (s *Stream) Add(id ID, fields [][]byte) bool {
+	if !s.lastID.Less(id) {
+		return false
+	}
+	s.entries = append(s.entries, &Entry{ID: id, Fields: fields})
+	s.lastID = id
+	return true
+}
+
+// search 返回第一个 ID 不小于 id 的消息的下标
+func (s *Stream) search(id ID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].ID.Less(id)
+	})
+}
+
+// Range 返回 ID 在 [start, end] 之间的消息，count 大于 0 时最多返回 count 条，reverse 为 true 时从 end 开始倒序返回
+func (s *Stream) Range(start, end ID, count int, reverse bool) []*Entry {
+	if end.Less(start) {
+		return nil
+	}
+	beg := s.search(start)
+	stop := len(s.entries)
+	if next, ok := end.Next(); ok {
+		stop = s.search(next)
+	}
+	if beg >= stop {
+		return nil
+	}
+	size := stop - beg
+	if count > 0 && count < size {
+		size = count
+	}
+	result := make([]*Entry, 0, size)
+	if reverse {
+		for i := stop - 1; i >= beg && len(result) < size; i-- {
+			result = append(result, s.entries[i])
+		}
+	} else {
+		for i := beg; i < stop && len(result) < size; i++ {
+			result = append(result, s.entries[i])
+		}
+	}
+	return result
+}
+
+// After 返回 ID 大于 id 的消息，用于 XREAD
+func (s *Stream) After(id ID, count int) []*Entry {
+	next, ok := id.Next()
+	if !ok {
+		return nil
+	}
+	return s.Range(next, MaxID, count, false)
+}
+
+// removeHead 删除最早的 n 条消息
+func (s *Stream) removeHead(n int) {
+	if n <= 0 {
+		return
+	}
+	remain := len(s.entries) - n
+	if remain < cap(s.entries)/4 {
+		// 剩余的消息较少时复制一份，释放底层数组的头部
+		entries := make([]*Entry, remain)
+		copy(entries, s.entries[n:])
+		s.entries = entries
+		return
+	}
+	for i := 0; i < n; i++ {
+		s.entries[i] = nil
+	}
+	s.entries = s.entries[n:]
+}
+
+// TrimMaxLen 删除最早的消息直到只剩 maxLen 条，limit 大于 0 时最多删除 limit 条，返回删除的数量
+func (s *Stream) TrimMaxLen(maxLen int, limit int) int {
+	n := len(s.entries) - maxLen
+	if n <= 0 {
+		return 0
+	}
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	s.removeHead(n)
+	return n
+}
+
+// TrimMinID 删除 ID 小于 minID 的消息，limit 大于 0 时最多删除 limit 条，返回删除的数量
+func (s *Stream) TrimMinID(minID ID, limit int) int {
+	n := s.search(minID)
+	if limit > 0 && n > limit {
+		n = limit
+	}
+	s.removeHead(n)
+	return n
+}
+
+// ForEach visits entries in id order
+func (s *Stream) ForEach(consumer func(entry *Entry) bool) {
+	for _, entry := range s.entries {
+		if !consumer(entry) {
+			break
+		}
+	}
+}
+
+// Clone 复制消息流，消息写入后不会被修改，所以共享消息本身
+func (s *Stream) Clone() *Stream {
+	entries := make([]*Entry, len(s.entries))
+	copy(entries, s.entries)
+	return &Stream{
+		entries: entries,
+		lastID:  s.lastID,
+	}
+}
@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"math"
+	"testing"
+)
+
+func ids(entries []*Entry) []ID {
+	result := make([]ID, len(entries))
+	for i, entry := range entries {
+		result[i] = entry.ID
+	}
+	return result
+}
+
+func equalIDs(a, b []ID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStream(t *testing.T) {
+	s := New()
+	for ms := uint64(1); ms <= 5; ms++ {
+		for seq := uint64(0); seq < 2; seq++ {
+			if !s.Add(ID{Ms: ms, Seq: seq}, [][]byte{[]byte("f"), []byte("v")}) {
+				t.Fatal("add failed")
+			}
+		}
+	}
+	if s.Add(ID{Ms: 5, Seq: 1}, nil) {
+		t.Fatal("id must be greater than last id")
+	}
+	got := ids(s.Range(ID{Ms: 2}, ID{Ms: 3, Seq: math.MaxUint64}, 0, false))
+	if !equalIDs(got, []ID{{2, 0}, {2, 1}, {3, 0}, {3, 1}}) {
+		t.Fatalf("unexpected range %v", got)
+	}
+	got = ids(s.Range(MinID, MaxID, 3, true))
+	if !equalIDs(got, []ID{{5, 1}, {5, 0}, {4, 1}}) {
+		t.Fatalf("unexpected reverse range %v", got)
+	}
+	got = ids(s.After(ID{Ms: 4, Seq: 1}, 0))
+	if !equalIDs(got, []ID{{5, 0}, {5, 1}}) {
+		t.Fatalf("unexpected entries after 4-1: %v", got)
+	}
+
+	if n := s.TrimMinID(ID{Ms: 3}, 0); n != 4 || s.Len() != 6 {
+		t.Fatalf("trim by min id removed %d, remain %d", n, s.Len())
+	}
+	if n := s.TrimMaxLen(1, 2); n != 2 || s.Len() != 4 {
+		t.Fatalf("trim with limit removed %d, remain %d", n, s.Len())
+	}
+	s.TrimMaxLen(0, 0)
+	if s.Len() != 0 || s.LastID() != (ID{Ms: 5, Seq: 1}) {
+		t.Fatal("last id should be kept after trim")
+	}
+
+	if id, _ := s.NextID(3); id != (ID{Ms: 5, Seq: 2}) {
+		t.Fatalf("clock went backwards, expected 5-2, got %s", id)
+	}
+	if id, _ := s.NextID(7); id != (ID{Ms: 7}) {
+		t.Fatalf("expected 7-0, got %s", id)
+	}
+	if _, ok := s.NextSeq(4); ok {
+		t.Fatal("ms smaller than last id should be rejected")
+	}
+	s.SetLastID(MaxID)
+	if _, ok := s.NextID(1); ok {
+		t.Fatal("id should be exhausted")
+	}
+}
@@ -0,0 +1,117 @@
+// Package topk 使用 space-saving 算法在固定的内存内统计出现次数最多的元素。
+// 最多跟踪 capacity 个元素，新元素替换计数最小的元素并继承它的计数，
+// 所以计数是上界，Error 为可能多算的次数；真实次数超过 总次数/capacity 的元素一定会被保留
+package topk
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Item is a tracked element, the true count is in [Count-Error, Count]
+type Item struct {
+	Key   string
+	Count int64
+	Error int64
+}
+
+type entry struct {
+	Item
+	index int // 在堆中的下标
+}
+
+// minHeap 按计数排序，堆顶是计数最小的元素
+type minHeap []*entry
+
+func (h minHeap) Len() int           { return len(h) }
+func (h minHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h minHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *minHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *minHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return e
+}
+
+// SpaceSaving counts the most frequent keys, it is not safe for concurrent use
+type SpaceSaving struct {
+	capacity int
+	items    map[string]*entry
+	heap     minHeap
+}
+
+// New creates SpaceSaving tracking at most capacity keys
+func New(capacity int) *SpaceSaving {
+	if capacity <= 0 {
+		capacity = 1
+	}
+	return &SpaceSaving{
+		capacity: capacity,
+		items:    make(map[string]*entry, capacity),
+		heap:     make(minHeap, 0, capacity),
+	}
+}
+
+// Add counts n occurrences of key
+func (s *SpaceSaving) Add(key string, n int64) {
+	if e, ok := s.items[key]; ok {
+		e.Count += n
+		heap.Fix(&s.heap, e.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		e := &entry{Item: Item{Key: key, Count: n}}
+		s.items[key] = e
+		heap.Push(&s.heap, e)
+		return
+	}
+	// 替换计数最小的元素，新元素可能在被淘汰前已经出现过 min 次
+	e := s.heap[0]
+	delete(s.items, e.Key)
+	e.Error = e.Count
+	e.Count += n
+	e.Key = key
+	s.items[key] = e
+	heap.Fix(&s.heap, 0)
+}
+
+// Min returns the count a key not tracked may have at most, 0 if there is still room
+func (s *SpaceSaving) Min() int64 {
+	if len(s.heap) < s.capacity {
+		return 0
+	}
+	return s.heap[0].Count
+}
+
+// Len returns the number of tracked keys
+func (s *SpaceSaving) Len() int {
+	return len(s.heap)
+}
+
+// Items returns tracked keys ordered by count descending
+func (s *SpaceSaving) Items() []Item {
+	items := make([]Item, len(s.heap))
+	for i, e := range s.heap {
+		items[i] = e.Item
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Count != items[j].Count {
+			return items[i].Count > items[j].Count
+		}
+		return items[i].Key < items[j].Key
+	})
+	return items
+}
@@ -0,0 +1,49 @@
+package topk
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := New(50)
+	exact := make(map[string]int64)
+	r := rand.New(rand.NewSource(1))
+	var total int64
+	for i := 0; i < 100000; i++ {
+		var key string
+		if i%4 == 0 {
+			// 三个热点 key 占 1/4 的访问
+			key = "hot" + strconv.Itoa(i%3)
+		} else {
+			key = "cold" + strconv.Itoa(r.Intn(10000))
+		}
+		s.Add(key, 1)
+		exact[key]++
+		total++
+	}
+	if s.Len() != 50 {
+		t.Fatalf("expected 50 tracked keys, got %d", s.Len())
+	}
+	items := s.Items()
+	for i := 0; i < 3; i++ {
+		if items[i].Key[:3] != "hot" {
+			t.Errorf("expected hot keys first, got %v", items[:3])
+		}
+	}
+	var sum int64
+	for _, item := range items {
+		sum += item.Count
+		// 计数是上界，误差不超过 total/capacity
+		if item.Count < exact[item.Key] || item.Count-item.Error > exact[item.Key] || item.Error > total/50 {
+			t.Errorf("bad estimate for %s: %+v, exact %d", item.Key, item, exact[item.Key])
+		}
+	}
+	if sum != total {
+		t.Errorf("counts should sum up to %d, got %d", total, sum)
+	}
+	if s.Min() > total/50 {
+		t.Errorf("min %d exceeds total/capacity", s.Min())
+	}
+}
@@ -1,10 +1,12 @@
 package database
 
 import (
+	"net"
 	"time"
 
 	"github.com/hdt3213/rdb/core"
 	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/metrics"
 )
 
 // CmdLine is alias for [][]byte, represents a command line
@@ -17,6 +19,58 @@ type DB interface {
 	Close()
 }
 
+// BlockingCanceler is implemented by engines supporting blocking commands such as BLPOP,
+// the handler calls it as soon as the client disconnects
+type BlockingCanceler interface {
+	CancelBlocking(c redis.Connection)
+}
+
+// StatsRecorder is implemented by engines reporting INFO stats,
+// the handler reports network traffic through it and the metrics endpoint reads StatsSnapshot
+type StatsRecorder interface {
+	RecordNetInput(n int)
+	RecordNetOutput(n int)
+	StatsSnapshot() map[string]float64
+}
+
+// ConnectionFilter is implemented by engines that may refuse new connections such as protected mode,
+// the handler sends the returned error reply and closes the connection
+type ConnectionFilter interface {
+	AcceptConnection(addr net.Addr) redis.Reply
+}
+
+// ClientTracker is implemented by engines listing connected clients such as CLIENT LIST and CLIENT KILL,
+// the handler registers every accepted connection and AfterClientClose removes it
+type ClientTracker interface {
+	AfterClientConnect(c redis.Connection)
+}
+
+// ShutdownNotifier is implemented by engines supporting the SHUTDOWN command,
+// the channel is closed once the engine has saved its data and the server should exit
+type ShutdownNotifier interface {
+	ShutdownRequested() <-chan struct{}
+}
+
+// HotKey is a frequently accessed key reported by HotKeyReporter,
+// Count is the estimated number of accesses in the window and may be over counted by at most Error
+type HotKey struct {
+	DB    int
+	Key   string
+	Count int64
+	Error int64
+}
+
+// HotKeyReporter is implemented by engines sampling key accesses,
+// the metrics endpoint reads the hottest keys through it
+type HotKeyReporter interface {
+	HotKeys(count int) []HotKey
+}
+
+// MetricsCollector is implemented by engines exporting Prometheus metrics on /metrics
+type MetricsCollector interface {
+	CollectMetrics() []metrics.Family
+}
+
 // KeyEventCallback will be called back on key event, such as key inserted or deleted
 // may be called concurrently
 type KeyEventCallback func(dbIndex int, key string, entity *DataEntity)
@@ -41,4 +95,7 @@ type DBEngine interface {
 // DataEntity stores data bound to a key, including a string, list, hash, set and so on
 type DataEntity struct {
 	Data interface{}
+	// 最近一次访问的时间(unix 毫秒)和 LFU 计数器，命令访问时用原子操作更新，用于淘汰策略
+	LRU  int64
+	Freq uint32
 }
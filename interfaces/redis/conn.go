@@ -1,22 +1,42 @@
 package redis
 
+import "time"
+
 // Connection represents a connection with redis client
 type Connection interface {
+	// Write buffers the data, it is sent in the background
 	Write([]byte) (int, error)
+	// Flush waits until buffered output is sent
+	Flush() error
+	// GetOutputBufferSize returns bytes written but not sent yet
+	GetOutputBufferSize() int
 	Close() error
 	RemoteAddr() string
 
 	SetPassword(string)
 	GetPassword() string
+	// user authenticated by AUTH or HELLO, empty means the default user
+	SetUser(string)
+	GetUser() string
+	// connections are authenticated unless marked otherwise on accept
+	SetAuthenticated(bool)
+	IsAuthenticated() bool
 
 	// client should keep its subscribing channels
 	Subscribe(channel string)
 	UnSubscribe(channel string)
+	// SubsCount returns the number of subscribed channels and patterns
 	SubsCount() int
 	GetChannels() []string
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	GetPatterns() []string
 
 	InMultiState() bool
+	// SetMultiState(true) binds the selected db to the transaction
 	SetMultiState(bool)
+	// GetMultiDBIndex returns the db bound at MULTI, queued commands execute against it
+	GetMultiDBIndex() int
 	GetQueuedCmdLine() [][][]byte
 	EnqueueCmd([][]byte)
 	ClearQueuedCmds()
@@ -33,5 +53,35 @@ type Connection interface {
 	SetMaster()
 	IsMaster() bool
 
+	// ASKING is valid for the next command only
+	SetAsking(bool)
+	IsAsking() bool
+
+	// CLIENT DURABILITY SYNC: replies of write commands wait until the aof is fsynced
+	SetSyncDurability(bool)
+	IsSyncDurability() bool
+
+	// RESP protocol version of the connection, 2 or 3
+	SetProtocol(int)
+	GetProtocol() int
+
+	// HELLO ... COMPRESS lz4: bulk strings not shorter than the threshold are compressed, 0 disables
+	SetReplyCompression(int)
+	GetReplyCompression() int
+
+	// client name set by HELLO SETNAME or CLIENT SETNAME, empty by default
+	SetClientName(string)
+	GetClientName() string
+
+	// GetID returns the unique id of connection, used by CLIENT ID and CLIENT KILL
+	GetID() uint64
+	GetCreateTime() time.Time
+	// SetLastCmd records the command being executed, CLIENT LIST shows it and the idle time
+	SetLastCmd(string)
+	GetLastCmd() (string, time.Time)
+	// Kill stops reading the connection, the handler closes it after the current reply
+	Kill()
+	IsKilled() bool
+
 	Name() string
 }
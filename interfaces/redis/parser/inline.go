@@ -0,0 +1,119 @@
+package parser
+
+// 内联命令，例如 telnet 中直接输入 SET "hello world" 'it\'s'
+// 与 redis 的 sdssplitargs 相同：参数之间用空白分隔，双引号中支持 \n \r \t \b \a \\ \" 和 \xHH 转义，
+// 单引号中只支持 \'，右引号后面必须是空白或者行尾
+
+var errUnbalancedQuotes = protocolError("unbalanced quotes in request")
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// splitArgs 把一行内联命令拆分成参数，引号不匹配时返回协议错误
+func splitArgs(line []byte) ([][]byte, error) {
+	var args [][]byte
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		arg, next, err := splitArg(line, i)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		i = next
+	}
+}
+
+// splitArg 从 line[i] 开始读取一个参数，返回参数和下一个参数的起始位置
+func splitArg(line []byte, i int) ([]byte, int, error) {
+	arg := make([]byte, 0)
+	inDouble, inSingle := false, false
+	for ; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case inDouble:
+			if c == '\\' && i+3 < len(line) && line[i+1] == 'x' {
+				hi, ok1 := hexValue(line[i+2])
+				lo, ok2 := hexValue(line[i+3])
+				if ok1 && ok2 {
+					arg = append(arg, hi<<4|lo)
+					i += 3
+					continue
+				}
+			}
+			if c == '\\' && i+1 < len(line) {
+				i++
+				switch line[i] {
+				case 'n':
+					arg = append(arg, '\n')
+				case 'r':
+					arg = append(arg, '\r')
+				case 't':
+					arg = append(arg, '\t')
+				case 'b':
+					arg = append(arg, '\b')
+				case 'a':
+					arg = append(arg, '\a')
+				default:
+					arg = append(arg, line[i])
+				}
+			} else if c == '"' {
+				if i+1 < len(line) && !isSpace(line[i+1]) {
+					return nil, 0, errUnbalancedQuotes
+				}
+				return arg, i + 1, nil
+			} else {
+				arg = append(arg, c)
+			}
+		case inSingle:
+			if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+				i++
+				arg = append(arg, '\'')
+			} else if c == '\'' {
+				if i+1 < len(line) && !isSpace(line[i+1]) {
+					return nil, 0, errUnbalancedQuotes
+				}
+				return arg, i + 1, nil
+			} else {
+				arg = append(arg, c)
+			}
+		default:
+			switch c {
+			case ' ', '\t', '\n', '\r':
+				return arg, i + 1, nil
+			case '"':
+				inDouble = true
+			case '\'':
+				inSingle = true
+			default:
+				arg = append(arg, c)
+			}
+		}
+	}
+	if inDouble || inSingle {
+		return nil, 0, errUnbalancedQuotes
+	}
+	return arg, i, nil
+}
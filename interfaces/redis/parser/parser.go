@@ -11,36 +11,107 @@ import (
 	"strings"
 
 	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/lz4"
 	"github.com/zhangming/go-redis/redis/protocol"
 )
 
+// 与 redis 相同的限制，超过限制的输入按协议错误处理，避免恶意的长度头分配大量内存
+const (
+	// maxLineLen 内联命令和 RESP 头部行的最大长度
+	maxLineLen = 64 * 1024
+	// maxArrayLen 数组的最大元素个数
+	maxArrayLen = 1024 * 1024
+	// maxBulkLen bulk string 的最大长度
+	maxBulkLen = 512 * 1024 * 1024
+	// maxDepth 嵌套数组的最大层数，命令只有一层，回复(例如 EXEC、SCAN)最多几层
+	maxDepth = 32
+	// preallocLimit 超过这个长度时按实际读到的数据增长，不按头部一次分配
+	preallocLimit = 64 * 1024
+)
+
 // Payload stores redis.Reply or error
 type Payload struct {
 	Data redis.Reply
 	Err  error
 }
 
-// ParseStream reads data from io.Reader and send payloads through channel
+// ProtocolError means the stream is malformed, parsing stops after it
+type ProtocolError struct {
+	Msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "Protocol error: " + e.Msg
+}
+
+func protocolError(msg string) error {
+	return &ProtocolError{Msg: msg}
+}
+
+// IsProtocolError tells whether err is reported for malformed input
+func IsProtocolError(err error) bool {
+	var protoErr *ProtocolError
+	return errors.As(err, &protoErr)
+}
+
+// ParseStream reads data from io.Reader and send payloads through channel.
+// The channel is closed after io error or protocol error
 func ParseStream(reader io.Reader) <-chan *Payload {
-	ch := make(chan *Payload)
-	go parse0(reader, ch)
+	return ParseStreamWithBufferSize(reader, 0)
+}
+
+// ParseStreamWithBufferSize is like ParseStream but reads through a buffer of size bytes,
+// size <= 0 means the default size of bufio
+func ParseStreamWithBufferSize(reader io.Reader, size int) <-chan *Payload {
+	return ParseStreamAhead(reader, size, 0)
+}
+
+// ParseStreamAhead is like ParseStreamWithBufferSize but decodes up to ahead payloads
+// before the caller receives them, so pipelined requests can be drained as a batch
+func ParseStreamAhead(reader io.Reader, size int, ahead int) <-chan *Payload {
+	ch := make(chan *Payload, ahead)
+	go parse0(reader, size, ch)
 	return ch
 }
 
+// ParseComplete parses the complete frames at the beginning of data, returns them and the number of bytes consumed,
+// an incomplete frame at the end is left for the next call. A protocol error is returned as the last payload
+func ParseComplete(data []byte) ([]*Payload, int) {
+	src := bytes.NewReader(data)
+	reader := bufio.NewReader(src)
+	var payloads []*Payload
+	consumed := 0
+	for consumed < len(data) {
+		reply, err := parseFrame(reader)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return append(payloads, &Payload{Err: err}), consumed
+		}
+		// bufio 预读的数据还没有解析
+		consumed = len(data) - src.Len() - reader.Buffered()
+		if reply != nil {
+			payloads = append(payloads, &Payload{Data: reply})
+		}
+	}
+	return payloads, consumed
+}
+
 // ParseBytes reads data from []byte and return all replies
 func ParseBytes(data []byte) ([]redis.Reply, error) {
 	ch := make(chan *Payload)
 	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
+	go parse0(reader, 0, ch)
 	var results []redis.Reply
 	for payload := range ch {
-		if payload == nil {
-			return nil, errors.New("no protocol")
-		}
 		if payload.Err != nil {
 			if payload.Err == io.EOF {
 				break
 			}
+			// 读完剩余的 payload，parse0 才能退出
+			for range ch {
+			}
 			return nil, payload.Err
 		}
 		results = append(results, payload.Data)
@@ -52,116 +123,299 @@ func ParseBytes(data []byte) ([]redis.Reply, error) {
 func ParseOne(data []byte) (redis.Reply, error) {
 	ch := make(chan *Payload, 1)
 	reader := bytes.NewReader(data)
-	go parse0(reader, ch)
-	payload := <-ch // parse0 will close the channel
-	if payload == nil {
-		return nil, errors.New("no protocol")
-	}
+	go parse0(reader, 0, ch)
+	payload := <-ch
+	go func() {
+		for range ch {
+		}
+	}()
 	return payload.Data, payload.Err
 }
 
-func parse0(rawReader io.Reader, ch chan<- *Payload) {
+func parse0(rawReader io.Reader, size int, ch chan<- *Payload) {
+	defer close(ch)
 	defer func() {
 		if err := recover(); err != nil {
-			slog.Error( string(debug.Stack()),err)
+			slog.Error("parser panic", "err", err, "stack", string(debug.Stack()))
+			ch <- &Payload{Err: protocolError("internal error")}
 		}
 	}()
-	reader := bufio.NewReader(rawReader)
+	var reader *bufio.Reader
+	if size > 0 {
+		reader = bufio.NewReaderSize(rawReader, size)
+	} else {
+		reader = bufio.NewReader(rawReader)
+	}
 	for {
-		line, err := reader.ReadBytes('\n')
+		reply, err := parseFrame(reader)
 		if err != nil {
 			ch <- &Payload{Err: err}
-			close(ch)
 			return
 		}
-		length := len(line)
-		if length <= 2 || line[length-2] != '\r' {
-			// there are some empty lines within replication traffic, ignore this error
-			//protocolError(ch, "empty line")
+		if reply == nil {
+			// 空行，复制连接上主节点会发送 "\n" 保持连接
 			continue
 		}
-		line = bytes.TrimSuffix(line, []byte{'\r', '\n'})
-		switch line[0] {
-		case '+':
-			content := string(line[1:])
-			ch <- &Payload{
-				Data: protocol.MakeStatusReply(content),
-			}
-			if strings.HasPrefix(content, "FULLRESYNC") {
-				err = parseRDBBulkString(reader, ch)
-				if err != nil {
-					ch <- &Payload{Err: err}
-					close(ch)
-					return
-				}
-			}
-		case '-':
-			ch <- &Payload{
-				Data: protocol.MakeErrReply(string(line[1:])),
-			}
-		case ':':
-			value, err := strconv.ParseInt(string(line[1:]), 10, 64)
-			if err != nil {
-				protocolError(ch, "illegal number "+string(line[1:]))
-				continue
-			}
-			ch <- &Payload{
-				Data: protocol.MakeIntReply(value),
-			}
-		case '$':
-			err = parseBulkString(line, reader, ch)
-			if err != nil {
+		ch <- &Payload{Data: reply}
+		if status, ok := reply.(*protocol.StatusReply); ok && strings.HasPrefix(status.Status, "FULLRESYNC") {
+			if err := parseRDBBulkString(reader, ch); err != nil {
 				ch <- &Payload{Err: err}
-				close(ch)
 				return
 			}
-		case '*':
-			err = parseArray(line, reader, ch)
-			if err != nil {
-				ch <- &Payload{Err: err}
-				close(ch)
-				return
-			}
-		default:
-			args := bytes.Split(line, []byte{' '})
-			ch <- &Payload{
-				Data: protocol.MakeMultiBulkReply(args),
+		}
+	}
+}
+
+// parseFrame 读取一个完整的帧。不以 RESP 类型开头的行是内联命令，空行返回 nil
+func parseFrame(reader *bufio.Reader) (redis.Reply, error) {
+	line, err := readRESPLine(reader)
+	if err != nil {
+		return nil, err
+	}
+	if isRESPType(line[0]) {
+		return parseValue(reader, line, 0)
+	}
+	if len(line) > maxLineLen {
+		return nil, protocolError("too big inline request")
+	}
+	args, err := splitArgs(bytes.TrimRight(line, "\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 {
+		return nil, nil
+	}
+	return protocol.MakeMultiBulkReply(args), nil
+}
+
+func isRESPType(b byte) bool {
+	switch b {
+	case '+', '-', ':', '$', '*', protocol.CompressedBulkPrefix:
+		return true
+	}
+	return false
+}
+
+// readRESPLine 读取以 '\n' 结尾的一行，包含结尾的换行符。
+// 超过 maxLineLen 时不再继续读取，数据在行中间结束时返回 io.ErrUnexpectedEOF
+func readRESPLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := reader.ReadSlice('\n')
+		line = append(line, chunk...)
+		if err == nil {
+			return line, nil
+		}
+		if err == io.EOF && len(line) > 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != bufio.ErrBufferFull {
+			return nil, err
+		}
+		if len(line) > maxLineLen {
+			if isRESPType(line[0]) {
+				return nil, protocolError("too big header line")
 			}
+			return nil, protocolError("too big inline request")
 		}
 	}
 }
 
-func parseBulkString(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
-	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || strLen < -1 {
-		protocolError(ch, "illegal bulk string header: "+string(header))
-		return nil
-	} else if strLen == -1 {
-		ch <- &Payload{
-			Data: protocol.MakeNullBulkReply(),
+// headerLine 去掉 RESP 头部行的类型和 CRLF
+func headerLine(line []byte) ([]byte, error) {
+	if len(line) > maxLineLen {
+		return nil, protocolError("too big header line")
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, protocolError("expected CRLF after '" + string(line[0]) + "' header")
+	}
+	return line[1 : len(line)-2], nil
+}
+
+// parseValue 解析以 line 开头的 RESP 值，数组会递归读取元素
+func parseValue(reader *bufio.Reader, line []byte, depth int) (redis.Reply, error) {
+	kind := line[0]
+	content, err := headerLine(line)
+	if err != nil {
+		return nil, err
+	}
+	switch kind {
+	case '+':
+		return protocol.MakeStatusReply(string(content)), nil
+	case '-':
+		return protocol.MakeErrReply(string(content)), nil
+	case ':':
+		value, err := strconv.ParseInt(string(content), 10, 64)
+		if err != nil {
+			return nil, protocolError("invalid integer " + strconv.Quote(string(content)))
+		}
+		return protocol.MakeIntReply(value), nil
+	case '$':
+		body, err := parseBulkBody(reader, content)
+		if err != nil {
+			return nil, err
+		}
+		if body == nil {
+			return protocol.MakeNullBulkReply(), nil
 		}
-		return nil
+		return protocol.MakeBulkReply(body), nil
+	case protocol.CompressedBulkPrefix:
+		body, err := parseCompressedBody(reader, content)
+		if err != nil {
+			return nil, err
+		}
+		return protocol.MakeBulkReply(body), nil
+	case '*':
+		return parseArray(reader, content, depth)
 	}
-	body := make([]byte, strLen+2)
-	_, err = io.ReadFull(reader, body)
+	return nil, protocolError("unexpected type '" + string(kind) + "'")
+}
+
+// parseBulkBody 读取 bulk string 的内容，长度为 -1 时返回 nil
+func parseBulkBody(reader *bufio.Reader, header []byte) ([]byte, error) {
+	strLen, err := strconv.ParseInt(string(header), 10, 64)
+	if err != nil || strLen < -1 || strLen > maxBulkLen {
+		return nil, protocolError("invalid bulk length")
+	}
+	if strLen == -1 {
+		return nil, nil
+	}
+	var body []byte
+	if strLen+2 <= preallocLimit {
+		body = make([]byte, strLen+2)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	} else {
+		// 长度头可能是伪造的，按实际收到的数据增长
+		var buf bytes.Buffer
+		if _, err := io.CopyN(&buf, reader, strLen+2); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		body = buf.Bytes()
+	}
+	if body[strLen] != '\r' || body[strLen+1] != '\n' {
+		return nil, protocolError("expected CRLF after bulk string")
+	}
+	return body[:strLen:strLen], nil
+}
+
+// parseCompressedBody 读取并解压 @<原始长度>:<压缩后长度> 的内容，见 protocol.CompressedReply
+func parseCompressedBody(reader *bufio.Reader, header []byte) ([]byte, error) {
+	rawHeader, compressedHeader, ok := bytes.Cut(header, []byte{':'})
+	if !ok {
+		return nil, protocolError("invalid compressed bulk header")
+	}
+	rawLen, err := strconv.ParseInt(string(rawHeader), 10, 64)
+	if err != nil || rawLen < 0 || rawLen > maxBulkLen {
+		return nil, protocolError("invalid bulk length")
+	}
+	compressed, err := parseBulkBody(reader, compressedHeader)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	ch <- &Payload{
-		Data: protocol.MakeBulkReply(body[:len(body)-2]),
+	if compressed == nil {
+		return nil, protocolError("invalid compressed bulk header")
 	}
-	return nil
+	body, err := lz4.Decompress(compressed, int(rawLen))
+	if err != nil {
+		return nil, protocolError("invalid compressed bulk string")
+	}
+	return body, nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// parseArray 元素全部是 bulk string 时返回 MultiBulkReply(命令总是这种形式)，否则返回 MultiRawReply
+func parseArray(reader *bufio.Reader, header []byte, depth int) (redis.Reply, error) {
+	n, err := strconv.ParseInt(string(header), 10, 64)
+	if err != nil || n < -1 || n > maxArrayLen {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if n == -1 {
+		return protocol.MakeNullMultiBulkReply(), nil
+	}
+	if n == 0 {
+		return protocol.MakeEmptyMultiBulkReply(), nil
+	}
+	if depth >= maxDepth {
+		return nil, protocolError("too deeply nested array")
+	}
+	capacity := n
+	if capacity > 1024 {
+		capacity = 1024
+	}
+	args := make([][]byte, 0, capacity)
+	var replies []redis.Reply // 出现非 bulk 元素后使用
+	for i := int64(0); i < n; i++ {
+		line, err := readRESPLine(reader)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if (line[0] == '$' || line[0] == protocol.CompressedBulkPrefix) && replies == nil {
+			content, err := headerLine(line)
+			if err != nil {
+				return nil, err
+			}
+			var body []byte
+			if line[0] == '$' {
+				body, err = parseBulkBody(reader, content)
+			} else {
+				body, err = parseCompressedBody(reader, content)
+			}
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, body) // nil 为回复中的 null 元素，例如 MGET 不存在的 key
+			continue
+		}
+		if !isRESPType(line[0]) {
+			return nil, protocolError("expected '$', got '" + string(line[0]) + "'")
+		}
+		if replies == nil {
+			replies = make([]redis.Reply, 0, capacity)
+			for _, arg := range args {
+				replies = append(replies, bulkOrNull(arg))
+			}
+		}
+		element, err := parseValue(reader, line, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		replies = append(replies, element)
+	}
+	if replies != nil {
+		return protocol.MakeMultiRawReply(replies), nil
+	}
+	return protocol.MakeMultiBulkReply(args), nil
+}
+
+func bulkOrNull(arg []byte) redis.Reply {
+	if arg == nil {
+		return protocol.MakeNullBulkReply()
+	}
+	return protocol.MakeBulkReply(arg)
 }
 
 // there is no CRLF between RDB and following AOF, therefore it needs to be treated differently
 func parseRDBBulkString(reader *bufio.Reader, ch chan<- *Payload) error {
-	header, err := reader.ReadBytes('\n')
-	if err != nil {
-		return errors.New("failed to read bytes")
+	var header []byte
+	// redis 在生成 rdb 期间每秒发送一个 "\n" 保持连接
+	for len(header) == 0 {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return errors.New("failed to read bytes")
+		}
+		header = bytes.TrimRight(line, "\r\n")
 	}
-	header = bytes.TrimSuffix(header, []byte{'\r', '\n'})
-	if len(header) == 0 {
-		return errors.New("empty header")
+	// 无盘复制: $EOF:<mark>\r\n<payload><mark>
+	if bytes.HasPrefix(header, []byte("$EOF:")) {
+		return parseRDBWithEOFMark(header[len("$EOF:"):], reader, ch)
 	}
 	strLen, err := strconv.ParseInt(string(header[1:]), 10, 64)
 	if err != nil || strLen <= 0 {
@@ -178,51 +432,21 @@ func parseRDBBulkString(reader *bufio.Reader, ch chan<- *Payload) error {
 	return nil
 }
 
-func parseArray(header []byte, reader *bufio.Reader, ch chan<- *Payload) error {
-	nStrs, err := strconv.ParseInt(string(header[1:]), 10, 64)
-	if err != nil || nStrs < 0 {
-		protocolError(ch, "illegal array header "+string(header[1:]))
-		return nil
-	} else if nStrs == 0 {
-		ch <- &Payload{
-			Data: protocol.MakeEmptyMultiBulkReply(),
-		}
-		return nil
+// parseRDBWithEOFMark 读取直到遇到 mark，mark 之前的内容为 rdb
+func parseRDBWithEOFMark(mark []byte, reader *bufio.Reader, ch chan<- *Payload) error {
+	if len(mark) == 0 {
+		return errors.New("empty eof mark")
 	}
-	lines := make([][]byte, 0, nStrs)
-	for i := int64(0); i < nStrs; i++ {
-		var line []byte
-		line, err = reader.ReadBytes('\n')
+	var body []byte
+	for !bytes.HasSuffix(body, mark) {
+		b, err := reader.ReadByte()
 		if err != nil {
 			return err
 		}
-		length := len(line)
-		if length < 4 || line[length-2] != '\r' || line[0] != '$' {
-			protocolError(ch, "illegal bulk string header "+string(line))
-			break
-		}
-		strLen, err := strconv.ParseInt(string(line[1:length-2]), 10, 64)
-		if err != nil || strLen < -1 {
-			protocolError(ch, "illegal bulk string length "+string(line))
-			break
-		} else if strLen == -1 {
-			lines = append(lines, []byte{})
-		} else {
-			body := make([]byte, strLen+2)
-			_, err := io.ReadFull(reader, body)
-			if err != nil {
-				return err
-			}
-			lines = append(lines, body[:len(body)-2])
-		}
+		body = append(body, b)
 	}
 	ch <- &Payload{
-		Data: protocol.MakeMultiBulkReply(lines),
+		Data: protocol.MakeBulkReply(body[:len(body)-len(mark)]),
 	}
 	return nil
 }
-
-func protocolError(ch chan<- *Payload, msg string) {
-	err := errors.New("protocol error: " + msg)
-	ch <- &Payload{Err: err}
-}
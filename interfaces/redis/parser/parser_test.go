@@ -0,0 +1,157 @@
+package parser
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func TestParseFrames(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n" +
+		"+OK\r\n-ERR bad\r\n:-12\r\n$-1\r\n*-1\r\n*0\r\n" +
+		"*2\r\n$1\r\na\r\n$-1\r\n" +
+		"*3\r\n:1\r\n*2\r\n$1\r\nx\r\n+y\r\n$1\r\nz\r\n" +
+		"\n\r\n" +
+		"set \"hello world\" 'it\\'s'  \"\\x41\\n\" ''\r\n" +
+		"ping\n"
+	expected := []string{
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n",
+		"+OK\r\n", "-ERR bad\r\n", ":-12\r\n", "$-1\r\n", "*-1\r\n", "*0\r\n",
+		"*2\r\n$1\r\na\r\n$-1\r\n",
+		"*3\r\n:1\r\n*2\r\n$1\r\nx\r\n+y\r\n$1\r\nz\r\n",
+		"*5\r\n$3\r\nset\r\n$11\r\nhello world\r\n$4\r\nit's\r\n$2\r\nA\n\r\n$0\r\n\r\n",
+		"*1\r\n$4\r\nping\r\n",
+	}
+	replies, err := ParseBytes([]byte(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != len(expected) {
+		t.Fatalf("expected %d replies, got %d", len(expected), len(replies))
+	}
+	for i, reply := range replies {
+		if actual := string(reply.ToBytes()); actual != expected[i] {
+			t.Errorf("reply %d: expected %q, got %q", i, expected[i], actual)
+		}
+	}
+	if _, ok := replies[0].(*protocol.MultiBulkReply); !ok {
+		t.Error("commands should be parsed as MultiBulkReply")
+	}
+}
+
+func TestParseComplete(t *testing.T) {
+	input := []byte("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$5\r\nvalue\r\nping\r\n*1\r\n$4\r\nPING\r\n")
+	// 数据分两次到达，第一次只解析完整的帧，剩余的数据与第二次的数据一起解析
+	for split := 0; split <= len(input); split++ {
+		payloads, consumed := ParseComplete(input[:split])
+		rest, restConsumed := ParseComplete(input[consumed:])
+		payloads = append(payloads, rest...)
+		if consumed+restConsumed != len(input) || len(payloads) != 3 {
+			t.Fatalf("split %d: consumed %d+%d, got %d payloads", split, consumed, restConsumed, len(payloads))
+		}
+		if actual := string(payloads[1].Data.ToBytes()); actual != "*1\r\n$4\r\nping\r\n" {
+			t.Errorf("split %d: unexpected inline command %q", split, actual)
+		}
+	}
+	payloads, _ := ParseComplete([]byte("*1\r\n$4\r\nPING\r\n*x\r\n*1\r\n$4\r\nPING\r\n"))
+	if len(payloads) != 2 || !IsProtocolError(payloads[1].Err) {
+		t.Errorf("expected parsing to stop at the protocol error, got %d payloads", len(payloads))
+	}
+}
+
+func TestParseProtocolErrors(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		msg   string
+	}{
+		{"*2\r\n$3\r\nGET\r\n:1\r\n", ""},
+		{"*x\r\n", "invalid multibulk length"},
+		{"*2000000\r\n", "invalid multibulk length"},
+		{"$-2\r\n", "invalid bulk length"},
+		{"$600000000\r\n", "invalid bulk length"},
+		{"$3\r\nabcd\r\n", "expected CRLF after bulk string"},
+		{"*1\r\nGET\r\n", "expected '$', got 'G'"},
+		{":abc\r\n", "invalid integer \"abc\""},
+		{"+OK\n", "expected CRLF after '+' header"},
+		{"set \"a\r\n", "unbalanced quotes in request"},
+		{"set \"a\"b\r\n", "unbalanced quotes in request"},
+		{"get 'a\r\n", "unbalanced quotes in request"},
+		{strings.Repeat("a", maxLineLen+10) + "\r\n", "too big inline request"},
+		{strings.Repeat("*1\r\n", maxDepth+1) + ":1\r\n", "too deeply nested array"},
+	} {
+		_, err := ParseBytes([]byte(tt.input))
+		if tt.msg == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error %v", tt.input, err)
+			}
+			continue
+		}
+		if !IsProtocolError(err) || err.Error() != "Protocol error: "+tt.msg {
+			t.Errorf("%q: expected protocol error %q, got %v", tt.input, tt.msg, err)
+		}
+	}
+
+	// 帧不完整时报告 ErrUnexpectedEOF，与连接正常关闭区分开
+	for _, input := range []string{"*2\r\n$3\r\nGET\r\n", "$5\r\nab", "+OK"} {
+		if _, err := ParseBytes([]byte(input)); err != io.ErrUnexpectedEOF {
+			t.Errorf("%q: expected unexpected EOF, got %v", input, err)
+		}
+	}
+
+	// 协议错误之后不再继续解析
+	ch := ParseStream(strings.NewReader("*1\r\n$x\r\n*1\r\n$4\r\nPING\r\n"))
+	var payloads []*Payload
+	for p := range ch {
+		payloads = append(payloads, p)
+	}
+	if len(payloads) != 1 || !IsProtocolError(payloads[0].Err) {
+		t.Errorf("expected a single protocol error, got %d payloads", len(payloads))
+	}
+}
+
+func encodeAll(replies []redis.Reply) []byte {
+	var buf bytes.Buffer
+	for _, reply := range replies {
+		buf.Write(reply.ToBytes())
+	}
+	return buf.Bytes()
+}
+
+// FuzzParse 任意输入都不能 panic 或者卡住；解析成功时重新编码后再解析得到相同的结果
+func FuzzParse(f *testing.F) {
+	for _, seed := range []string{
+		"*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n",
+		"*2\r\n:1\r\n*1\r\n+OK\r\n",
+		"$-1\r\n*-1\r\n*0\r\n-ERR x\r\n",
+		"set \"a b\" 'c\\'d' \"\\x00\"\r\n",
+		"ping\n\r\n",
+		"$3\r\nabcd\r\n",
+		"*1\r\n$2000000000\r\n",
+	} {
+		f.Add([]byte(seed))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		replies, err := ParseBytes(data)
+		if err != nil {
+			return
+		}
+		for _, reply := range replies {
+			if status, ok := reply.(*protocol.StatusReply); ok && strings.HasPrefix(status.Status, "FULLRESYNC") {
+				// 之后的 rdb 没有结尾的 CRLF，重新编码后格式不同
+				return
+			}
+		}
+		encoded := encodeAll(replies)
+		again, err := ParseBytes(encoded)
+		if err != nil {
+			t.Fatalf("failed to parse re-encoded %q: %v", encoded, err)
+		}
+		if !bytes.Equal(encodeAll(again), encoded) {
+			t.Fatalf("round trip mismatch: %q != %q", encodeAll(again), encoded)
+		}
+	})
+}
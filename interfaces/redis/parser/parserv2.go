@@ -4,7 +4,6 @@ import (
 	"errors"
 	"io"
 	"strconv"
-	"strings"
 )
 
 func ParseV2(r io.Reader) ([][]byte, error) {
@@ -21,12 +20,7 @@ func ParseV2(r io.Reader) ([][]byte, error) {
 			return nil, err
 		}
 		buf = append(buf, buf2...)
-		line := strings.Split(string(buf), " ")
-		result := make([][]byte, len(line))
-		for i, s := range line {
-			result[i] = []byte(s)
-		}
-		return result, nil
+		return splitArgs(buf)
 	}
 
 	// 读取参数数量
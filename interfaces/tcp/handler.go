@@ -8,4 +8,10 @@ import(
 type Handler interface {
     Handle(ctx context.Context, conn net.Conn)
     Close()error
+}
+
+// ShutdownNotifier is implemented by handlers whose engine may ask the server to exit, e.g. on SHUTDOWN,
+// the server stops accepting and closes the handler once the channel is closed
+type ShutdownNotifier interface {
+	ShutdownRequested() <-chan struct{}
 }
\ No newline at end of file
@@ -0,0 +1,89 @@
+package consistenthash
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 一致性哈希
+// 每个节点在环上放置 replicas 个虚拟节点，key 顺时针找到的第一个虚拟节点所属的节点负责这个 key。
+// 与 redis cluster 相同，key 中包含 {tag} 时只对 tag 计算哈希，使相关的 key 落在同一个节点
+
+// HashFunc defines function to generate hash code
+type HashFunc func(data []byte) uint32
+
+// Map stores nodes and you can pick node from Map
+type Map struct {
+	hashFunc HashFunc
+	replicas int
+	keys     []int // sorted
+	hashMap  map[int]string
+}
+
+// New creates a new Map
+func New(replicas int, fn HashFunc) *Map {
+	m := &Map{
+		replicas: replicas,
+		hashFunc: fn,
+		hashMap:  make(map[int]string),
+	}
+	if m.hashFunc == nil {
+		m.hashFunc = crc32.ChecksumIEEE
+	}
+	if m.replicas <= 0 {
+		m.replicas = 1
+	}
+	return m
+}
+
+// IsEmpty returns if there is no node in Map
+func (m *Map) IsEmpty() bool {
+	return len(m.keys) == 0
+}
+
+// AddNode add the given nodes into consistent hash circle
+func (m *Map) AddNode(nodes ...string) {
+	for _, node := range nodes {
+		if node == "" {
+			continue
+		}
+		for i := 0; i < m.replicas; i++ {
+			hash := int(m.hashFunc([]byte(strconv.Itoa(i) + node)))
+			m.keys = append(m.keys, hash)
+			m.hashMap[hash] = node
+		}
+	}
+	sort.Ints(m.keys)
+}
+
+// getPartitionKey 返回 {tag} 中的 tag，没有 tag 时返回 key 本身
+func getPartitionKey(key string) string {
+	beg := strings.Index(key, "{")
+	if beg == -1 {
+		return key
+	}
+	end := strings.Index(key[beg+1:], "}")
+	if end <= 0 {
+		return key
+	}
+	return key[beg+1 : beg+1+end]
+}
+
+// PickNode gets the closest node in the hash to the provided key
+func (m *Map) PickNode(key string) string {
+	if m.IsEmpty() {
+		return ""
+	}
+	hash := int(m.hashFunc([]byte(getPartitionKey(key))))
+	// binary search for appropriate replica
+	idx := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+	// means we have cycled back to the first replica
+	if idx == len(m.keys) {
+		idx = 0
+	}
+	return m.hashMap[m.keys[idx]]
+}
@@ -0,0 +1,44 @@
+package consistenthash
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	m := New(3, func(key []byte) uint32 {
+		ret, _ := strconv.Atoi(string(key))
+		return uint32(ret)
+	})
+	// 虚拟节点: 2, 12, 22, 4, 14, 24, 6, 16, 26
+	m.AddNode("6", "4", "2")
+	cases := map[string]string{
+		"2":  "2",
+		"11": "2",
+		"23": "4",
+		"27": "2",
+	}
+	for key, node := range cases {
+		if actual := m.PickNode(key); actual != node {
+			t.Errorf("key %s: expected %s, actual %s", key, node, actual)
+		}
+	}
+	// hash tag
+	if m.PickNode("a{23}") != "4" || m.PickNode("{11}b") != "2" {
+		t.Error("wrong node for hash tag")
+	}
+}
+
+func TestPartitionKey(t *testing.T) {
+	cases := map[string]string{
+		"abc":      "abc",
+		"{user}:1": "user",
+		"a{}b":     "a{}b",
+		"a{b":      "a{b",
+	}
+	for key, expected := range cases {
+		if actual := getPartitionKey(key); actual != expected {
+			t.Errorf("%s: expected %s, actual %s", key, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package geohash
+
+import "math"
+
+// 与 redis 相同的 52 位 geohash
+// 经度和纬度各用 26 位表示所在的格子，交错排列后经度在每一对位的高位，
+// 前缀相同的 geohash 在同一个格子中，所以一个格子对应有序集合中一段连续的分数区间。
+// 纬度范围与 web mercator 相同，超出 ±85.05112878 的位置不能编码
+
+const (
+	// Step 是每个维度的位数
+	Step = 26
+	// Bits 是 geohash 的总位数
+	Bits = Step * 2
+
+	LngMin = -180.0
+	LngMax = 180.0
+	LatMin = -85.05112878
+	LatMax = 85.05112878
+
+	// EarthRadius 是 redis 计算距离使用的地球半径，单位米
+	EarthRadius = 6372797.560856
+	// mercatorMax 是 web mercator 投影下赤道周长的一半
+	mercatorMax = 20037726.37
+)
+
+// Range 是有序集合中的分数区间 [Min, Max)
+type Range struct {
+	Min uint64
+	Max uint64
+}
+
+// Valid 检查经纬度能否编码
+func Valid(lng, lat float64) bool {
+	return lng >= LngMin && lng <= LngMax && lat >= LatMin && lat <= LatMax
+}
+
+// Encode 把经纬度编码为 52 位 geohash，调用前需要用 Valid 检查
+func Encode(lng, lat float64) uint64 {
+	lngIdx, latIdx := cellIndex(lng, lat, Step)
+	return interleave(latIdx, lngIdx)
+}
+
+// Decode 返回 geohash 所在格子的中心
+func Decode(hash uint64) (lng, lat float64) {
+	latIdx, lngIdx := deinterleave(hash)
+	minLng, maxLng, minLat, maxLat := cellBounds(lngIdx, latIdx, Step)
+	lng = math.Max(LngMin, math.Min(LngMax, (minLng+maxLng)/2))
+	lat = math.Max(LatMin, math.Min(LatMax, (minLat+maxLat)/2))
+	return lng, lat
+}
+
+// Distance 用 haversine 公式计算两点之间的距离，单位米
+func Distance(lng1, lat1, lng2, lat2 float64) float64 {
+	lat1r, lat2r := toRadians(lat1), toRadians(lat2)
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((toRadians(lng2) - toRadians(lng1)) / 2)
+	return 2 * EarthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// InBox 检查点是否在以 (lng, lat) 为中心、宽 width 高 height 米的矩形内，在矩形内时返回到中心的距离
+func InBox(lng, lat, width, height, pointLng, pointLat float64) (float64, bool) {
+	if EarthRadius*math.Abs(toRadians(pointLat)-toRadians(lat)) > height/2 {
+		return 0, false
+	}
+	if Distance(lng, pointLat, pointLng, pointLat) > width/2 {
+		return 0, false
+	}
+	return Distance(lng, lat, pointLng, pointLat), true
+}
+
+// Cover 返回覆盖以 (lng, lat) 为中心、东西方向 halfWidth 米、南北方向 halfHeight 米范围的 geohash 区间。
+// 选择格子边长不小于搜索范围的精度，中心所在的格子和周围 8 个格子一定包含整个范围，
+// 区间中的点还需要调用方按照实际的形状过滤
+func Cover(lng, lat, halfWidth, halfHeight float64) []Range {
+	step := estimateStep(math.Max(halfWidth, halfHeight), lat)
+	for step > 1 && !covers(lng, lat, halfWidth, halfHeight, step) {
+		step--
+	}
+	lngIdx, latIdx := cellIndex(lng, lat, step)
+	size := uint64(1) << step
+	shift := uint(Bits - 2*step)
+	ranges := make([]Range, 0, 9)
+	seen := make(map[uint64]struct{}, 9)
+	for _, dlat := range []int64{0, -1, 1} {
+		y := int64(latIdx) + dlat
+		if y < 0 || y >= int64(size) {
+			continue
+		}
+		for _, dlng := range []int64{0, -1, 1} {
+			// 经度在 ±180 处首尾相接
+			x := uint64((int64(lngIdx) + dlng + int64(size)) % int64(size))
+			hash := interleave(uint64(y), x)
+			if _, ok := seen[hash]; ok {
+				continue
+			}
+			seen[hash] = struct{}{}
+			ranges = append(ranges, Range{Min: hash << shift, Max: (hash + 1) << shift})
+		}
+	}
+	return ranges
+}
+
+// estimateStep 按照搜索半径估计格子的精度，靠近两极时格子在东西方向变窄，需要更大的格子
+func estimateStep(radius float64, lat float64) int {
+	if radius == 0 {
+		return Step
+	}
+	step := 1
+	for radius < mercatorMax {
+		radius *= 2
+		step++
+	}
+	step -= 2
+	if lat > 66 || lat < -66 {
+		step--
+		if lat > 80 || lat < -80 {
+			step--
+		}
+	}
+	if step < 1 {
+		step = 1
+	}
+	if step > Step {
+		step = Step
+	}
+	return step
+}
+
+// covers 检查中心格子和周围 8 个格子是否包含整个搜索范围，没有相邻格子的方向到达了纬度边界
+func covers(lng, lat, halfWidth, halfHeight float64, step int) bool {
+	lngIdx, latIdx := cellIndex(lng, lat, step)
+	minLng, maxLng, minLat, maxLat := cellBounds(lngIdx, latIdx, step)
+	cellWidth, cellHeight := maxLng-minLng, maxLat-minLat
+	if cellWidth*3 < LngMax-LngMin {
+		// 东西方向的距离在范围内离赤道最远的纬度上最短
+		edgeLat := math.Min(90, math.Abs(lat)+halfHeight/EarthRadius*180/math.Pi)
+		if Distance(lng, edgeLat, minLng-cellWidth, edgeLat) < halfWidth || Distance(lng, edgeLat, maxLng+cellWidth, edgeLat) < halfWidth {
+			return false
+		}
+	}
+	size := uint64(1) << step
+	if latIdx > 0 && Distance(lng, lat, lng, minLat-cellHeight) < halfHeight {
+		return false
+	}
+	if latIdx+1 < size && Distance(lng, lat, lng, maxLat+cellHeight) < halfHeight {
+		return false
+	}
+	return true
+}
+
+// cellIndex 返回经纬度在 step 位精度下所在格子的下标
+func cellIndex(lng, lat float64, step int) (lngIdx, latIdx uint64) {
+	size := float64(uint64(1) << step)
+	index := func(v, min, max float64) uint64 {
+		i := uint64((v - min) / (max - min) * size)
+		if i >= uint64(size) {
+			i = uint64(size) - 1 // 范围的上界落在最后一个格子中
+		}
+		return i
+	}
+	return index(lng, LngMin, LngMax), index(lat, LatMin, LatMax)
+}
+
+// cellBounds 返回格子的经纬度范围
+func cellBounds(lngIdx, latIdx uint64, step int) (minLng, maxLng, minLat, maxLat float64) {
+	size := float64(uint64(1) << step)
+	minLng = LngMin + float64(lngIdx)/size*(LngMax-LngMin)
+	maxLng = LngMin + float64(lngIdx+1)/size*(LngMax-LngMin)
+	minLat = LatMin + float64(latIdx)/size*(LatMax-LatMin)
+	maxLat = LatMin + float64(latIdx+1)/size*(LatMax-LatMin)
+	return
+}
+
+// interleave 把 x 的各位放在偶数位，y 的各位放在奇数位
+func interleave(x, y uint64) uint64 {
+	return spread(x) | spread(y)<<1
+}
+
+func deinterleave(hash uint64) (x, y uint64) {
+	return squash(hash), squash(hash >> 1)
+}
+
+// spread 在低 32 位的每一位之间插入一个 0
+func spread(v uint64) uint64 {
+	v &= 0xFFFFFFFF
+	v = (v | v<<16) & 0x0000FFFF0000FFFF
+	v = (v | v<<8) & 0x00FF00FF00FF00FF
+	v = (v | v<<4) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v<<2) & 0x3333333333333333
+	v = (v | v<<1) & 0x5555555555555555
+	return v
+}
+
+// squash 是 spread 的逆运算
+func squash(v uint64) uint64 {
+	v &= 0x5555555555555555
+	v = (v | v>>1) & 0x3333333333333333
+	v = (v | v>>2) & 0x0F0F0F0F0F0F0F0F
+	v = (v | v>>4) & 0x00FF00FF00FF00FF
+	v = (v | v>>8) & 0x0000FFFF0000FFFF
+	v = (v | v>>16) & 0x00000000FFFFFFFF
+	return v
+}
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
@@ -0,0 +1,67 @@
+package geohash
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	// redis 文档中的例子: GEOADD Sicily 13.361389 38.115556 Palermo 15.087269 37.502669 Catania
+	if hash := Encode(13.361389, 38.115556); hash != 3479099956230698 {
+		t.Fatalf("unexpected hash %d", hash)
+	}
+	if hash := Encode(15.087269, 37.502669); hash != 3479447370796909 {
+		t.Fatalf("unexpected hash %d", hash)
+	}
+	lng, lat := Decode(3479099956230698)
+	if math.Abs(lng-13.361389) > 1e-5 || math.Abs(lat-38.115556) > 1e-5 {
+		t.Fatalf("unexpected position %f,%f", lng, lat)
+	}
+	// redis 使用解码之后的位置计算距离
+	lng2, lat2 := Decode(3479447370796909)
+	if d := fmt.Sprintf("%.4f", Distance(lng, lat, lng2, lat2)); d != "166274.1516" {
+		t.Fatalf("unexpected distance %s", d)
+	}
+	// 边界上的位置也能编码和解码
+	for _, p := range [][2]float64{{LngMax, LatMax}, {LngMin, LatMin}} {
+		lng, lat := Decode(Encode(p[0], p[1]))
+		if math.Abs(lng-p[0]) > 1e-5 || math.Abs(lat-p[1]) > 1e-5 {
+			t.Fatalf("unexpected position %f,%f for %v", lng, lat, p)
+		}
+	}
+}
+
+func TestCover(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		lng := r.Float64()*360 - 180
+		lat := r.Float64()*170 - 85
+		radius := math.Pow(10, r.Float64()*7)
+		ranges := Cover(lng, lat, radius, radius)
+		// 范围内的点一定落在返回的区间中
+		for j := 0; j < 20; j++ {
+			pLng := lng + (r.Float64()*2-1)*radius/EarthRadius*180/math.Pi*3
+			pLat := lat + (r.Float64()*2-1)*radius/EarthRadius*180/math.Pi
+			if pLng > LngMax {
+				pLng -= 360
+			} else if pLng < LngMin {
+				pLng += 360
+			}
+			if !Valid(pLng, pLat) || Distance(lng, lat, pLng, pLat) > radius {
+				continue
+			}
+			hash := Encode(pLng, pLat)
+			found := false
+			for _, rg := range ranges {
+				if hash >= rg.Min && hash < rg.Max {
+					found = true
+				}
+			}
+			if !found {
+				t.Fatalf("point %f,%f within %f of %f,%f not covered", pLng, pLat, radius, lng, lat)
+			}
+		}
+	}
+}
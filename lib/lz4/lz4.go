@@ -0,0 +1,217 @@
+// Package lz4 实现 LZ4 的块格式(block format)。
+// 压缩后的数据不包含原始长度，解压时由调用方提供，与其他语言的 LZ4 块压缩库互通。
+// 压缩使用单个哈希表的贪心匹配，速度优先，压缩率与 LZ4 的默认级别接近。
+package lz4
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sync"
+)
+
+const (
+	minMatch = 4
+	// 最后一个匹配必须在距结尾 mfLimit 字节之前开始，最后 lastLiterals 字节总是字面量
+	mfLimit      = 12
+	lastLiterals = 5
+	maxOffset    = 65535
+	hashLog      = 14
+)
+
+// ErrCorrupt means the compressed data is malformed or does not match the expected size
+var ErrCorrupt = errors.New("lz4: corrupt input")
+
+func hash(seq uint32) uint32 {
+	return (seq * 2654435761) >> (32 - hashLog)
+}
+
+// hashTable 在多次压缩之间复用，不需要每次清零:
+// 一次压缩中位置 i 保存为 base+i+1，不大于 base 的是之前的压缩留下的，按空处理
+type hashTable struct {
+	entries [1 << hashLog]uint32
+	base    uint32
+}
+
+var tablePool = sync.Pool{
+	New: func() interface{} {
+		return new(hashTable)
+	},
+}
+
+// CompressBound returns the max size of compressed data of n bytes
+func CompressBound(n int) int {
+	return n + n/255 + 16
+}
+
+// Compress appends the compressed block of src to dst
+func Compress(dst, src []byte) []byte {
+	anchor := 0
+	if len(src) > mfLimit {
+		table := tablePool.Get().(*hashTable)
+		defer tablePool.Put(table)
+		if uint64(table.base)+uint64(len(src))+1 >= math.MaxUint32 {
+			clear(table.entries[:])
+			table.base = 0
+		}
+		base := table.base
+		table.base += uint32(len(src)) + 1
+		limit := len(src) - mfLimit
+		maxEnd := len(src) - lastLiterals
+		for i := 0; i < limit; {
+			seq := binary.LittleEndian.Uint32(src[i:])
+			h := hash(seq)
+			ref := -1
+			if v := table.entries[h]; v > base {
+				ref = int(v - base - 1)
+			}
+			table.entries[h] = base + uint32(i) + 1
+			if ref < 0 || i-ref > maxOffset || binary.LittleEndian.Uint32(src[ref:]) != seq {
+				i++
+				continue
+			}
+			// 向前扩展匹配，不越过上一个序列的结尾
+			for i > anchor && ref > 0 && src[i-1] == src[ref-1] {
+				i--
+				ref--
+			}
+			end := i + minMatch
+			for end < maxEnd && src[end] == src[ref+end-i] {
+				end++
+			}
+			dst = appendSequence(dst, src[anchor:i], i-ref, end-i)
+			i = end
+			anchor = end
+		}
+	}
+	// 最后一个序列只有字面量
+	lits := src[anchor:]
+	dst = append(dst, literalToken(len(lits)))
+	dst = appendLiteralLength(dst, len(lits))
+	return append(dst, lits...)
+}
+
+func literalToken(n int) byte {
+	if n >= 15 {
+		return 15 << 4
+	}
+	return byte(n) << 4
+}
+
+func appendLiteralLength(dst []byte, n int) []byte {
+	if n < 15 {
+		return dst
+	}
+	return appendLength(dst, n-15)
+}
+
+// appendLength 超过 token 中 4 位的部分，每个 255 表示还有后续字节
+func appendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+func appendSequence(dst []byte, lits []byte, offset, matchLen int) []byte {
+	ml := matchLen - minMatch
+	token := literalToken(len(lits))
+	if ml >= 15 {
+		token |= 15
+	} else {
+		token |= byte(ml)
+	}
+	dst = append(dst, token)
+	dst = appendLiteralLength(dst, len(lits))
+	dst = append(dst, lits...)
+	dst = append(dst, byte(offset), byte(offset>>8))
+	if ml >= 15 {
+		dst = appendLength(dst, ml-15)
+	}
+	return dst
+}
+
+// readLength 读取 token 之后的扩展长度
+func readLength(src []byte, i int) (int, int, error) {
+	n := 0
+	for {
+		if i >= len(src) {
+			return 0, 0, ErrCorrupt
+		}
+		b := src[i]
+		i++
+		n += int(b)
+		if b != 255 {
+			return n, i, nil
+		}
+	}
+}
+
+// Decompress decodes a compressed block whose original size is size
+func Decompress(src []byte, size int) ([]byte, error) {
+	// 每个输入字节最多表示 255 个输出字节，size 可能来自不可信的输入，先检查再分配
+	if size < 0 || size/255 > len(src) {
+		return nil, ErrCorrupt
+	}
+	dst := make([]byte, 0, size)
+	i := 0
+	for {
+		if i >= len(src) {
+			return nil, ErrCorrupt
+		}
+		token := src[i]
+		i++
+		litLen := int(token >> 4)
+		if litLen == 15 {
+			n, next, err := readLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			litLen += n
+			i = next
+		}
+		if litLen > len(src)-i || litLen > size-len(dst) {
+			return nil, ErrCorrupt
+		}
+		dst = append(dst, src[i:i+litLen]...)
+		i += litLen
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return nil, ErrCorrupt
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, ErrCorrupt
+		}
+		matchLen := int(token & 15)
+		if matchLen == 15 {
+			n, next, err := readLength(src, i)
+			if err != nil {
+				return nil, err
+			}
+			matchLen += n
+			i = next
+		}
+		matchLen += minMatch
+		if matchLen > size-len(dst) {
+			return nil, ErrCorrupt
+		}
+		start := len(dst) - offset
+		if offset >= matchLen {
+			dst = append(dst, dst[start:start+matchLen]...)
+			continue
+		}
+		// 匹配与正在写入的部分重叠，逐字节复制
+		for k := 0; k < matchLen; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+	if len(dst) != size {
+		return nil, ErrCorrupt
+	}
+	return dst, nil
+}
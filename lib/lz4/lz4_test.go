@@ -0,0 +1,76 @@
+package lz4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+)
+
+// 由 lz4 命令行工具压缩得到的块
+func TestDecompressReference(t *testing.T) {
+	block, _ := hex.DecodeString("9f676f2d7265646973200900071f211c0008051b000f2500ffff8be0656e64206f66206d657373616765")
+	expected := append(bytes.Repeat([]byte("go-redis go-redis go-redis go-redis! "), 20), "end of message"...)
+	actual, err := Decompress(block, len(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(actual, expected) {
+		t.Errorf("unexpected result %q", actual)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 100000)
+	r.Read(random)
+	var json []byte
+	for len(json) < 200000 {
+		json = append(json, `{"id":`...)
+		json = append(json, byte('0'+r.Intn(10)))
+		json = append(json, `,"name":"alice","tags":["a","b"]},`...)
+	}
+	inputs := [][]byte{
+		nil,
+		[]byte("a"),
+		[]byte("abcdefghijklm"),
+		bytes.Repeat([]byte("a"), 13),
+		bytes.Repeat([]byte("a"), 100000),
+		random,
+		json,
+		append(append([]byte{}, random[:70000]...), random[:70000]...), // 超过 64KB 的重复无法引用
+	}
+	for _, input := range inputs {
+		compressed := Compress(nil, input)
+		if len(compressed) > CompressBound(len(input)) {
+			t.Errorf("size %d: compressed size %d exceeds bound", len(input), len(compressed))
+		}
+		actual, err := Decompress(compressed, len(input))
+		if err != nil {
+			t.Fatalf("size %d: %v", len(input), err)
+		}
+		if !bytes.Equal(actual, input) {
+			t.Fatalf("size %d: round trip mismatch", len(input))
+		}
+	}
+	if compressed := Compress(nil, json); len(compressed) > len(json)/4 {
+		t.Errorf("poor compression ratio %d/%d", len(compressed), len(json))
+	}
+}
+
+func TestDecompressCorrupt(t *testing.T) {
+	input := bytes.Repeat([]byte("go-redis "), 100)
+	compressed := Compress(nil, input)
+	if _, err := Decompress(compressed, len(input)-1); err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt for wrong size, got %v", err)
+	}
+	for i := 1; i < len(compressed); i++ {
+		if _, err := Decompress(compressed[:i], len(input)); err != ErrCorrupt {
+			t.Errorf("expected ErrCorrupt for truncated input %d, got %v", i, err)
+		}
+	}
+	// offset 超出已经解压的数据
+	if _, err := Decompress([]byte{0x10, 'a', 0xff, 0x00}, 5); err != ErrCorrupt {
+		t.Errorf("expected ErrCorrupt for bad offset, got %v", err)
+	}
+}
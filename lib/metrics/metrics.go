@@ -0,0 +1,158 @@
+package metrics
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Prometheus 文本格式
+// 每个指标族先输出 # HELP 和 # TYPE，再逐行输出样本: name{label="value",...} value
+// 直方图的样本是 _bucket{le="..."}(累计计数)、_sum 和 _count，见 DurationHistogram.Samples
+
+// Types of metric families
+const (
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+)
+
+// Label is a name-value pair identifying a sample within a family
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is one line of a family, Suffix is appended to the family name such as _bucket of histograms
+type Sample struct {
+	Suffix string
+	Labels []Label
+	Value  float64
+}
+
+// Family is a group of samples sharing name, help and type
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// WriteText writes families in the Prometheus text exposition format, families without samples are skipped
+func WriteText(w io.Writer, families []Family) error {
+	bw := bufio.NewWriter(w)
+	for _, family := range families {
+		if len(family.Samples) == 0 {
+			continue
+		}
+		bw.WriteString("# HELP " + family.Name + " " + escapeHelp(family.Help) + "\n")
+		bw.WriteString("# TYPE " + family.Name + " " + family.Type + "\n")
+		for _, sample := range family.Samples {
+			bw.WriteString(family.Name + sample.Suffix)
+			if len(sample.Labels) > 0 {
+				bw.WriteByte('{')
+				for i, label := range sample.Labels {
+					if i > 0 {
+						bw.WriteByte(',')
+					}
+					bw.WriteString(label.Name + `="` + escapeLabel(label.Value) + `"`)
+				}
+				bw.WriteByte('}')
+			}
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(sample.Value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// DefaultLatencyBuckets are the upper bounds in seconds of command latency histograms, from 10us to 1s
+var DefaultLatencyBuckets = []float64{0.00001, 0.00005, 0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// DurationHistogram counts durations in buckets, safe for concurrent use
+type DurationHistogram struct {
+	// 桶的上界(秒)
+	bounds []float64
+	// 每个桶自己的计数，最后一个是 +Inf
+	counts []atomic.Int64
+	sum    atomic.Int64 // 纳秒
+}
+
+// NewDurationHistogram creates a histogram with the given upper bounds in seconds, in increasing order
+func NewDurationHistogram(bounds []float64) *DurationHistogram {
+	return &DurationHistogram{
+		bounds: bounds,
+		counts: make([]atomic.Int64, len(bounds)+1),
+	}
+}
+
+// Observe records one duration
+func (h *DurationHistogram) Observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(h.bounds, seconds)
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// Count returns the number of observations
+func (h *DurationHistogram) Count() int64 {
+	var count int64
+	for i := range h.counts {
+		count += h.counts[i].Load()
+	}
+	return count
+}
+
+// Sum returns the total of observed durations
+func (h *DurationHistogram) Sum() time.Duration {
+	return time.Duration(h.sum.Load())
+}
+
+// Samples returns the _bucket, _sum and _count samples of the histogram, each with labels plus le for buckets
+func (h *DurationHistogram) Samples(labels ...Label) []Sample {
+	samples := make([]Sample, 0, len(h.counts)+2)
+	var cumulative int64
+	for i := range h.counts {
+		cumulative += h.counts[i].Load()
+		le := "+Inf"
+		if i < len(h.bounds) {
+			le = formatValue(h.bounds[i])
+		}
+		bucketLabels := append(append(make([]Label, 0, len(labels)+1), labels...), Label{Name: "le", Value: le})
+		samples = append(samples, Sample{Suffix: "_bucket", Labels: bucketLabels, Value: float64(cumulative)})
+	}
+	samples = append(samples,
+		Sample{Suffix: "_sum", Labels: labels, Value: h.Sum().Seconds()},
+		Sample{Suffix: "_count", Labels: labels, Value: float64(cumulative)})
+	return samples
+}
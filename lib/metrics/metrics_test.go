@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	h := NewDurationHistogram([]float64{0.001, 0.01})
+	h.Observe(500 * time.Microsecond)
+	h.Observe(5 * time.Millisecond)
+	h.Observe(time.Second)
+	families := []Family{
+		{Name: "up", Help: "Server is up.", Type: Gauge, Samples: []Sample{{Value: 1}}},
+		{Name: "empty", Help: "Skipped.", Type: Counter},
+		{Name: "latency_seconds", Help: "Latency.", Type: Histogram, Samples: h.Samples(Label{Name: "cmd", Value: `a"b`})},
+	}
+	var sb strings.Builder
+	if err := WriteText(&sb, families); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP up Server is up.
+# TYPE up gauge
+up 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{cmd="a\"b",le="0.001"} 1
+latency_seconds_bucket{cmd="a\"b",le="0.01"} 2
+latency_seconds_bucket{cmd="a\"b",le="+Inf"} 3
+latency_seconds_sum{cmd="a\"b"} 1.0055
+latency_seconds_count{cmd="a\"b"} 3
+`
+	if sb.String() != expected {
+		t.Fatalf("unexpected output:\n%s", sb.String())
+	}
+}
@@ -0,0 +1,59 @@
+// Package siphash 实现 SipHash-2-4。
+// SipHash 是带密钥的哈希函数，不知道密钥时无法构造出哈希值相同的大量 key，
+// 用于防止攻击者选择 key 让哈希表退化(hash flooding)，redis 的字典也使用它。
+package siphash
+
+import "math/bits"
+
+// Sum64 返回以 (k0, k1) 为密钥的 data 的 SipHash-2-4 值
+func Sum64(k0, k1 uint64, data string) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+
+	n := len(data)
+	for len(data) >= 8 {
+		m := uint64(data[0]) | uint64(data[1])<<8 | uint64(data[2])<<16 | uint64(data[3])<<24 |
+			uint64(data[4])<<32 | uint64(data[5])<<40 | uint64(data[6])<<48 | uint64(data[7])<<56
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+		data = data[8:]
+	}
+
+	// 最后不足 8 字节的部分，最高字节为长度
+	last := uint64(n) << 56
+	for i := len(data) - 1; i >= 0; i-- {
+		last |= uint64(data[i]) << (8 * uint(i))
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+
+	v2 ^= 0xff
+	round()
+	round()
+	round()
+	round()
+	return v0 ^ v1 ^ v2 ^ v3
+}
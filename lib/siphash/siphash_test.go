@@ -0,0 +1,27 @@
+package siphash
+
+import "testing"
+
+// 参考实现中的测试向量: 密钥为 00 01 ... 0f，消息为 00 01 ... (n-1)
+func TestSum64(t *testing.T) {
+	k0 := uint64(0x0706050403020100)
+	k1 := uint64(0x0f0e0d0c0b0a0908)
+	msg := make([]byte, 16)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	for _, tt := range []struct {
+		n        int
+		expected uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{2, 0x0d6c8009d9a94f5a},
+		{3, 0x85676696d7fb7e2d},
+		{8, 0x93f5f5799a932462},
+	} {
+		if actual := Sum64(k0, k1, string(msg[:tt.n])); actual != tt.expected {
+			t.Errorf("length %d: expected %x, actually %x", tt.n, tt.expected, actual)
+		}
+	}
+}
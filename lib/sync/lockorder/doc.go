@@ -0,0 +1,11 @@
+// Package lockorder 在调试构建中检测可能导致死锁的加锁顺序。
+//
+// 使用 go build -tags lockorder / go test -tags lockorder 时，每次加锁前调用 Class.Acquire，
+// 解锁后调用 Class.Release，检测器记录每个协程持有的锁和不同类锁之间的获取顺序，
+// 一旦出现 A->B 和 B->A 这样构成环的顺序(即使这次并没有真的死锁)，或者同一类锁没有按 sub 递增获取，
+// 就打印日志并 panic；设置 GOREDIS_LOCKORDER=log 时只打印日志。
+// 默认构建中这些函数都是空实现，不会带来额外开销。
+package lockorder
+
+// All 表示逐个获取这一类的所有锁(例如遍历字典的全部分片)，检测时看作同时持有这一类的全部锁
+const All = ^uint64(0)
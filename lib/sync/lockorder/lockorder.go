@@ -0,0 +1,196 @@
+//go:build lockorder
+
+package lockorder
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Enabled 表示当前是否启用了锁顺序检测
+const Enabled = true
+
+// Class 是一类锁，同一个 Class 的多个实例(例如字典的各个分片)之间通过 sub 排序，nil 表示不检测
+type Class struct {
+	name string
+}
+
+// Violation 描述一次可能导致死锁的加锁顺序
+type Violation struct {
+	Msg string
+	// Stack 是本次加锁的调用栈
+	Stack string
+	// Previous 是与本次加锁冲突的那条顺序第一次出现时的调用栈
+	Previous string
+}
+
+func (v *Violation) Error() string {
+	return "lock order violation: " + v.Msg
+}
+
+type held struct {
+	class *Class
+	sub   uint64
+}
+
+var (
+	mu sync.Mutex
+	// edges[a][b] 表示观察到过持有 a 时获取 b，值为第一次观察到时的调用栈
+	edges   = make(map[*Class]map[*Class]string)
+	holding = make(map[uint64][]held)
+	handler = defaultHandler
+)
+
+// 设置环境变量 GOREDIS_LOCKORDER=log 时只打印日志，否则 panic
+func defaultHandler(v *Violation) {
+	slog.Error(v.Error(), "stack", v.Stack, "previous", v.Previous)
+	if os.Getenv("GOREDIS_LOCKORDER") != "log" {
+		panic(v)
+	}
+}
+
+// NewClass 创建一类锁
+func NewClass(name string) *Class {
+	return &Class{name: name}
+}
+
+func (c *Class) String() string {
+	return c.name
+}
+
+// SetViolationHandler 替换发现违规时的处理函数，返回原来的处理函数，用于测试
+func SetViolationHandler(fn func(v *Violation)) func(v *Violation) {
+	mu.Lock()
+	defer mu.Unlock()
+	prev := handler
+	handler = fn
+	return prev
+}
+
+// Reset 清空已经记录的加锁顺序
+func Reset() {
+	mu.Lock()
+	defer mu.Unlock()
+	edges = make(map[*Class]map[*Class]string)
+	holding = make(map[uint64][]held)
+}
+
+// Acquire 在获取锁之前调用。
+// 同一类锁必须按照 sub 严格递增的顺序获取；不同类的锁之间记录 持有->获取 的边，边构成环时报告违规
+func (c *Class) Acquire(sub uint64) {
+	if c == nil {
+		return
+	}
+	gid := goid()
+	mu.Lock()
+	stack := holding[gid]
+	var v *Violation
+	for _, h := range stack {
+		if h.class == c {
+			if sub <= h.sub || sub == All {
+				v = &Violation{
+					Msg: fmt.Sprintf("acquiring %s[%d] while holding %s[%d], same class must be acquired in ascending order",
+						c, sub, c, h.sub),
+				}
+				break
+			}
+			continue
+		}
+		if _, ok := edges[h.class][c]; ok {
+			continue
+		}
+		if path := findPath(c, h.class); path != nil {
+			names := make([]string, 0, len(path)+1)
+			for _, p := range path {
+				names = append(names, p.name)
+			}
+			names = append(names, c.name)
+			v = &Violation{
+				Msg: fmt.Sprintf("acquiring %s while holding %s, but observed order %s",
+					c, h.class, strings.Join(names, " -> ")),
+				Previous: edges[path[0]][path[1]],
+			}
+			break
+		}
+		if edges[h.class] == nil {
+			edges[h.class] = make(map[*Class]string)
+		}
+		edges[h.class][c] = string(debug.Stack())
+	}
+	fn := handler
+	if v == nil {
+		holding[gid] = append(stack, held{class: c, sub: sub})
+	}
+	mu.Unlock()
+	if v != nil {
+		v.Stack = string(debug.Stack())
+		fn(v)
+		// 处理函数没有 panic 时照常加锁
+		mu.Lock()
+		holding[gid] = append(holding[gid], held{class: c, sub: sub})
+		mu.Unlock()
+	}
+}
+
+// Release 在释放锁之后调用
+func (c *Class) Release(sub uint64) {
+	if c == nil {
+		return
+	}
+	gid := goid()
+	mu.Lock()
+	defer mu.Unlock()
+	stack := holding[gid]
+	for i := len(stack) - 1; i >= 0; i-- {
+		if stack[i].class == c && stack[i].sub == sub {
+			stack = append(stack[:i], stack[i+1:]...)
+			break
+		}
+	}
+	if len(stack) == 0 {
+		delete(holding, gid)
+	} else {
+		holding[gid] = stack
+	}
+}
+
+// findPath 返回从 from 到 to 的一条路径(包含两端)，不存在时返回 nil
+func findPath(from, to *Class) []*Class {
+	visited := make(map[*Class]bool)
+	var dfs func(node *Class) []*Class
+	dfs = func(node *Class) []*Class {
+		if node == to {
+			return []*Class{node}
+		}
+		visited[node] = true
+		for next := range edges[node] {
+			if visited[next] {
+				continue
+			}
+			if path := dfs(next); path != nil {
+				return append([]*Class{node}, path...)
+			}
+		}
+		return nil
+	}
+	return dfs(from)
+}
+
+// goid 从调用栈的第一行 "goroutine 123 [running]:" 中解析出协程 id
+func goid() uint64 {
+	var buf [64]byte
+	n := runtime.Stack(buf[:], false)
+	line := bytes.TrimPrefix(buf[:n], []byte("goroutine "))
+	if i := bytes.IndexByte(line, ' '); i > 0 {
+		line = line[:i]
+	}
+	id, _ := strconv.ParseUint(string(line), 10, 64)
+	return id
+}
@@ -0,0 +1,20 @@
+//go:build !lockorder
+
+package lockorder
+
+// Enabled 表示当前是否启用了锁顺序检测
+const Enabled = false
+
+// Class 是一类锁，未启用检测时不记录任何信息
+type Class struct{}
+
+// NewClass 创建一类锁
+func NewClass(name string) *Class {
+	return nil
+}
+
+// Acquire 在获取锁之前调用
+func (c *Class) Acquire(sub uint64) {}
+
+// Release 在释放锁之后调用
+func (c *Class) Release(sub uint64) {}
@@ -0,0 +1,158 @@
+//go:build lockorder
+
+package lockorder
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+// collect 替换处理函数，记录所有违规
+func collect(t *testing.T) *[]*Violation {
+	t.Helper()
+	Reset()
+	var mu sync.Mutex
+	violations := make([]*Violation, 0)
+	prev := SetViolationHandler(func(v *Violation) {
+		mu.Lock()
+		defer mu.Unlock()
+		violations = append(violations, v)
+	})
+	t.Cleanup(func() {
+		SetViolationHandler(prev)
+		Reset()
+	})
+	return &violations
+}
+
+func TestCycle(t *testing.T) {
+	violations := collect(t)
+	a := NewClass("a")
+	b := NewClass("b")
+	c := NewClass("c")
+
+	// a -> b -> c
+	a.Acquire(0)
+	b.Acquire(0)
+	c.Acquire(0)
+	c.Release(0)
+	b.Release(0)
+	a.Release(0)
+	if len(*violations) != 0 {
+		t.Fatalf("unexpected violations %v", *violations)
+	}
+
+	// 在另一个协程中 c -> a，即使没有真的死锁也要报告
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Acquire(0)
+		a.Acquire(0)
+		a.Release(0)
+		c.Release(0)
+	}()
+	<-done
+	if len(*violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(*violations))
+	}
+	if msg := (*violations)[0].Error(); !strings.Contains(msg, "a -> b -> c -> a") {
+		t.Fatalf("unexpected message %q", msg)
+	}
+	if (*violations)[0].Previous == "" {
+		t.Fatal("expected the stack of the conflicting order")
+	}
+
+	// 已经报告过的顺序被记录下来，之后不再重复检查
+	a.Acquire(0)
+	b.Acquire(0)
+	b.Release(0)
+	a.Release(0)
+	if len(*violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(*violations))
+	}
+}
+
+func TestSameClass(t *testing.T) {
+	violations := collect(t)
+	shards := NewClass("shards")
+	other := NewClass("other")
+
+	shards.Acquire(1)
+	shards.Acquire(3)
+	shards.Release(3)
+	shards.Release(1)
+	if len(*violations) != 0 {
+		t.Fatalf("unexpected violations %v", *violations)
+	}
+
+	shards.Acquire(3)
+	shards.Acquire(1)
+	shards.Release(1)
+	shards.Release(3)
+	if len(*violations) != 1 {
+		t.Fatalf("expected 1 violation, got %d", len(*violations))
+	}
+
+	// 遍历所有分片时不能再持有或者获取同一类的锁
+	shards.Acquire(All)
+	shards.Acquire(2)
+	shards.Release(2)
+	shards.Release(All)
+	shards.Acquire(2)
+	shards.Acquire(All)
+	shards.Release(All)
+	shards.Release(2)
+	if len(*violations) != 3 {
+		t.Fatalf("expected 3 violations, got %d", len(*violations))
+	}
+
+	// 释放之后不再持有，其他类的锁不受影响
+	other.Acquire(0)
+	shards.Acquire(0)
+	shards.Release(0)
+	other.Release(0)
+	if len(*violations) != 3 {
+		t.Fatalf("expected 3 violations, got %d", len(*violations))
+	}
+}
+
+func TestDefaultHandlerPanics(t *testing.T) {
+	Reset()
+	defer Reset()
+	a := NewClass("a")
+	b := NewClass("b")
+	a.Acquire(0)
+	b.Acquire(0)
+	b.Release(0)
+	a.Release(0)
+
+	b.Acquire(0)
+	func() {
+		defer func() {
+			if _, ok := recover().(*Violation); !ok {
+				t.Fatal("expected panic with *Violation")
+			}
+		}()
+		a.Acquire(0)
+	}()
+	b.Release(0)
+	// panic 时没有加锁，也不应该记录为持有
+	mu.Lock()
+	defer mu.Unlock()
+	if len(holding) != 0 {
+		t.Fatalf("unexpected held locks %v", holding)
+	}
+}
+
+func TestNilClass(t *testing.T) {
+	violations := collect(t)
+	var c *Class
+	c.Acquire(0)
+	c.Acquire(0)
+	c.Release(0)
+	c.Release(0)
+	if len(*violations) != 0 {
+		t.Fatalf("unexpected violations %v", *violations)
+	}
+}
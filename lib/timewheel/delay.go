@@ -2,7 +2,11 @@ package timewheel
 
 import "time"
 
-var tw = New(time.Second, 3600)
+// 延迟任务
+// 过期 key 的删除依赖这里的定时任务。原来的时间轮以秒为刻度，PEXPIRE 50 这样的毫秒级过期时间
+// 会被取整到下一个刻度，所以改为按到期时间排序的最小堆，到期时间精确到定时器本身的精度
+
+var tw = NewScheduler()
 
 func init() {
 	tw.Start()
@@ -10,15 +14,25 @@ func init() {
 
 // Delay executes job after waiting the given duration
 func Delay(duration time.Duration, key string, job func()) {
-	tw.AddJob(duration, key, job)
+	tw.AddJob(time.Now().Add(duration), key, job)
 }
 
-// At executes job at given time
+// At executes job at given time, job whose time has passed runs immediately
 func At(at time.Time, key string, job func()) {
-	tw.AddJob(at.Sub(time.Now()), key, job)
+	tw.AddJob(at, key, job)
 }
 
 // Cancel stops a pending job
 func Cancel(key string) {
 	tw.RemoveJob(key)
 }
+
+// Default returns the process wide scheduler used by Delay/At/Cancel
+func Default() *Scheduler {
+	return tw
+}
+
+// Pending tells whether a job with the given key is waiting to run
+func Pending(key string) bool {
+	return tw.HasJob(key)
+}
@@ -0,0 +1,166 @@
+package timewheel
+
+import (
+	"container/heap"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type task struct {
+	at    time.Time
+	key   string
+	job   func()
+	index int // 在堆中的下标
+}
+
+// taskHeap 按到期时间排序的最小堆
+type taskHeap []*task
+
+func (h taskHeap) Len() int           { return len(h) }
+func (h taskHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	t := x.(*task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return t
+}
+
+// Scheduler executes jobs at given time with millisecond precision
+type Scheduler struct {
+	mu    sync.Mutex
+	tasks taskHeap
+	// key -> task，key 为空的任务不能取消
+	timer map[string]*task
+	// 堆顶变化时唤醒后台协程重新设置定时器
+	wakeup   chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// NewScheduler creates a scheduler, call Start before adding jobs
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		timer:  make(map[string]*task),
+		wakeup: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+	}
+}
+
+// Start starts the scheduler
+func (s *Scheduler) Start() {
+	go s.run()
+}
+
+// Stop stops the scheduler, pending jobs are dropped. It is safe to call Stop more than once
+func (s *Scheduler) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+}
+
+// AddJob adds a job running at the given time, a pending job with the same key is replaced
+func (s *Scheduler) AddJob(at time.Time, key string, job func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if key != "" {
+		if old, ok := s.timer[key]; ok {
+			heap.Remove(&s.tasks, old.index)
+		}
+	}
+	t := &task{at: at, key: key, job: job}
+	heap.Push(&s.tasks, t)
+	if key != "" {
+		s.timer[key] = t
+	}
+	if t.index == 0 {
+		s.notify()
+	}
+}
+
+// RemoveJob removes job from pending queue
+// if job is done or not found, then nothing happened
+func (s *Scheduler) RemoveJob(key string) {
+	if key == "" {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.timer[key]; ok {
+		heap.Remove(&s.tasks, t.index)
+		delete(s.timer, key)
+	}
+}
+
+// HasJob tells whether a job with the given key is pending
+func (s *Scheduler) HasJob(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.timer[key]
+	return ok
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Scheduler) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		next, ok := s.runExpired()
+		var wait <-chan time.Time
+		if ok {
+			timer.Reset(time.Until(next))
+			wait = timer.C
+		}
+		select {
+		case <-wait:
+		case <-s.wakeup:
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// runExpired 执行所有已经到期的任务，返回下一个任务的到期时间
+func (s *Scheduler) runExpired() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for len(s.tasks) > 0 && !s.tasks[0].at.After(now) {
+		t := heap.Pop(&s.tasks).(*task)
+		if t.key != "" {
+			delete(s.timer, t.key)
+		}
+		go func(job func()) {
+			defer func() {
+				if err := recover(); err != nil {
+					slog.Error("timewheel job panic", "err", err)
+				}
+			}()
+			job()
+		}(t.job)
+	}
+	if len(s.tasks) == 0 {
+		return time.Time{}, false
+	}
+	return s.tasks[0].at, true
+}
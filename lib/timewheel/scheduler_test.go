@@ -0,0 +1,68 @@
+package timewheel
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// 允许的调度误差，CI 机器负载较高时定时器可能稍晚触发
+const tolerance = 25 * time.Millisecond
+
+func TestSchedulerPrecision(t *testing.T) {
+	s := NewScheduler()
+	s.Start()
+	defer s.Stop()
+
+	start := time.Now()
+	delays := []time.Duration{50 * time.Millisecond, 5 * time.Millisecond, 120 * time.Millisecond, 20 * time.Millisecond}
+	fired := make([]time.Duration, len(delays))
+	var wg sync.WaitGroup
+	for i, delay := range delays {
+		i := i
+		wg.Add(1)
+		s.AddJob(start.Add(delay), "", func() {
+			fired[i] = time.Since(start)
+			wg.Done()
+		})
+	}
+	wg.Wait()
+	for i, delay := range delays {
+		if fired[i] < delay || fired[i] > delay+tolerance {
+			t.Errorf("job scheduled after %v fired after %v", delay, fired[i])
+		}
+	}
+}
+
+func TestSchedulerReplaceAndCancel(t *testing.T) {
+	s := NewScheduler()
+	s.Start()
+	defer s.Stop()
+
+	ran := make(chan string, 4)
+	s.AddJob(time.Now().Add(10*time.Millisecond), "k", func() { ran <- "old" })
+	// 相同 key 的任务替换之前的任务
+	s.AddJob(time.Now().Add(20*time.Millisecond), "k", func() { ran <- "new" })
+	s.AddJob(time.Now().Add(10*time.Millisecond), "cancelled", func() { ran <- "cancelled" })
+	s.RemoveJob("cancelled")
+	if !s.HasJob("k") || s.HasJob("cancelled") {
+		t.Fatal("unexpected pending jobs")
+	}
+	// 到期时间已经过去的任务立即执行
+	s.AddJob(time.Now().Add(-time.Second), "past", func() { ran <- "past" })
+
+	if got := <-ran; got != "past" {
+		t.Errorf("expected past job first, got %s", got)
+	}
+	if got := <-ran; got != "new" {
+		t.Errorf("expected replaced job, got %s", got)
+	}
+	select {
+	case got := <-ran:
+		t.Errorf("unexpected job %s", got)
+	case <-time.After(30 * time.Millisecond):
+	}
+	if s.HasJob("k") {
+		t.Error("expected finished job to be removed")
+	}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import "strconv"
+
+// 共享的小整数
+// 与 redis 的 shared integers 相同，0 到 SharedIntegers-1 的十进制字符串启动时在同一块内存中生成一次，
+// SET/INCR 写入的值直接引用，不再每个 key 分配一份。共享切片的 len 等于 cap，append 总会复制，
+// 原地修改字符串的命令(SETRANGE/SETBIT)需要先用 IsSharedInt 判断并复制
+
+// SharedIntegers is the number of shared small integers, values in [0, SharedIntegers) are shared
+const SharedIntegers = 10000
+
+var sharedInts [SharedIntegers][]byte
+
+func init() {
+	buf := make([]byte, 0, SharedIntegers*4)
+	for i := range sharedInts {
+		beg := len(buf)
+		buf = strconv.AppendInt(buf, int64(i), 10)
+		sharedInts[i] = buf[beg:len(buf):len(buf)]
+	}
+}
+
+// FormatInt returns the decimal representation of n, small integers return the shared slice which must not be modified
+func FormatInt(n int64) []byte {
+	if n >= 0 && n < SharedIntegers {
+		return sharedInts[n]
+	}
+	return strconv.AppendInt(nil, n, 10)
+}
+
+// InternInt returns the shared slice if b is a small integer in canonical form, otherwise b itself
+func InternInt(b []byte) []byte {
+	if n, ok := parseSmallInt(b); ok {
+		return sharedInts[n]
+	}
+	return b
+}
+
+// IsSharedInt returns whether b is one of the shared small integers
+func IsSharedInt(b []byte) bool {
+	n, ok := parseSmallInt(b)
+	return ok && &b[0] == &sharedInts[n][0]
+}
+
+// parseSmallInt 只接受没有前导 0 和符号的 0 到 SharedIntegers-1
+func parseSmallInt(b []byte) (int, bool) {
+	if len(b) == 0 || len(b) > 4 || (b[0] == '0' && len(b) > 1) {
+		return 0, false
+	}
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
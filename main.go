@@ -1,14 +1,23 @@
 package main
 
 import (
+	"expvar"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"strings"
 
+	"github.com/zhangming/go-redis/aof"
+	"github.com/zhangming/go-redis/cluster"
 	"github.com/zhangming/go-redis/config"
+	idatabase "github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/lib/metrics"
 	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/server/epoll"
+	"github.com/zhangming/go-redis/redis/server/sidecar"
 	"github.com/zhangming/go-redis/redis/server/std"
 )
 
@@ -22,6 +31,9 @@ G     G O     O       R    R  E       D    D     I    S     S
  GGGGG   OOOOO        R     R EEEEEE  DDDDD    IIIII   SSSSS
 `
 
+// metrics 接口返回的热点 key 数量
+const hotkeysMetricCount = 20
+
 var defaultProperties = &config.ServerProperties{
 	Bind:           "0.0.0.0",
 	Port:           6399,
@@ -36,19 +48,106 @@ func fileExists(filename string) bool {
 	return err == nil && !info.IsDir()
 }
 
+func exitWithConfigError(err error) {
+	fmt.Fprintf(os.Stderr, "invalid configuration:\n%s\n", err)
+	os.Exit(1)
+}
+
+// checkPersistenceFormat 持久化文件由更新版本的引擎写入时拒绝启动
+func checkPersistenceFormat(cfg *config.ServerProperties) error {
+	var files []string
+	if cfg.AppendOnly && cfg.AppendFilename != "" {
+		files = append(files, cfg.AppendFilePath())
+	}
+	if cfg.AppendOnly && cfg.AppendDirname != "" {
+		parts, err := aof.ManifestFiles(cfg.AppendDirPath(), cfg.AppendFilename)
+		if err != nil {
+			return err
+		}
+		files = append(files, parts...)
+	}
+	if cfg.RDBFilename != "" {
+		files = append(files, cfg.RDBFilePath())
+	}
+	for _, filename := range files {
+		if err := aof.CheckFileFormat(filename); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runCheckAof 与 redis-check-aof 类似的子命令: go-redis check-aof [-fix] <file>
+// 报告 aof 中最后一条完整命令的位置，-fix 时截断之后的数据
+func runCheckAof(args []string) int {
+	flags := flag.NewFlagSet("check-aof", flag.ExitOnError)
+	fix := flags.Bool("fix", false, "truncate the file after the last complete command")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: go-redis check-aof [-fix] <file>")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	filename := flags.Arg(0)
+	result, err := aof.CheckAof(filename)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "check-aof:", err)
+		return 1
+	}
+	if result.Err == nil {
+		fmt.Printf("AOF %s is valid: %d commands, %d bytes\n", filename, result.Commands, result.Size)
+		return 0
+	}
+	fmt.Printf("AOF %s: %v\n", filename, result.Err)
+	fmt.Printf("%d complete commands in the first %d of %d bytes, %d bytes after them\n",
+		result.Commands, result.ValidSize, result.Size, result.Size-result.ValidSize)
+	if !*fix {
+		fmt.Println("run with -fix to truncate the file after the last complete command")
+		return 1
+	}
+	if _, err := aof.FixAof(filename); err != nil {
+		fmt.Fprintln(os.Stderr, "check-aof:", err)
+		return 1
+	}
+	fmt.Printf("truncated %s to %d bytes\n", filename, result.ValidSize)
+	return 0
+}
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check-aof" {
+		os.Exit(runCheckAof(os.Args[2:]))
+	}
 	print(banner)
 	slog.Info("starting redis server...")
 	configFilename := os.Getenv("CONFIG")
+	if configFilename == "" && fileExists("redis.conf") {
+		configFilename = "redis.conf"
+	}
 	if configFilename == "" {
-		if fileExists("redis.conf") {
-			config.SetupConfig("redis.conf")
-		} else {
-			config.Properties = defaultProperties
-		}
-	} else {
-		config.SetupConfig(configFilename)
+		config.Properties = defaultProperties
+	} else if err := config.SetupConfig(configFilename); err != nil {
+		exitWithConfigError(err)
+	}
+	// 在监听端口之前检查配置，一次报告所有问题
+	if err := config.Properties.Validate(); err != nil {
+		exitWithConfigError(err)
+	}
+	if err := checkPersistenceFormat(config.Properties); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
 	}
+	// 启动时推导的参数，同样在 INFO server 中返回
+	tuning := config.Properties.Tuning()
+	slog.Info("performance tuning",
+		"gomaxprocs", tuning.GOMAXPROCS,
+		"num_cpu", tuning.NumCPU,
+		"maxclients", config.Properties.MaxClients,
+		"shard_count", tuning.ShardCount,
+		"worker_pool_size", tuning.WorkerPoolSize,
+		"read_buffer_size", tuning.ReadBufferSize)
 	listenAddr := fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.Port)
 	go func() {
 		slog.Info("Starting pprof server on localhost:6060")
@@ -59,10 +158,51 @@ func main() {
 	}()
 	var err error
 	// 直接用stdserver启动
-	handler := std.MakeHandler()
-	err = std.Serve(listenAddr, handler)
+	var handler *std.Handler
+	if config.Properties.ClusterEnable && len(config.Properties.Peers) > 0 {
+		// 配置了 peers 时按照一致性哈希在节点之间转发命令
+		handler = std.MakeHandlerWithDB(cluster.MakeCluster())
+	} else {
+		handler = std.MakeHandler()
+	}
+	if recorder, ok := handler.DB().(idatabase.StatsRecorder); ok {
+		// 与 INFO stats 相同的统计信息，通过 pprof 服务的 /debug/vars 提供给监控面板
+		expvar.Publish("redis_stats", expvar.Func(func() any {
+			return recorder.StatsSnapshot()
+		}))
+	}
+	if reporter, ok := handler.DB().(idatabase.HotKeyReporter); ok && config.Properties.HotkeysSampleRatio > 0 {
+		// 开启 hotkeys-sample-ratio 后，窗口内访问最多的 key 同样通过 /debug/vars 提供
+		expvar.Publish("redis_hotkeys", expvar.Func(func() any {
+			return reporter.HotKeys(hotkeysMetricCount)
+		}))
+	}
+	if collector, ok := handler.DB().(idatabase.MetricsCollector); ok && config.Properties.MetricsEnable {
+		// Prometheus 从 pprof 服务的 /metrics 抓取
+		http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			if err := metrics.WriteText(w, collector.CollectMetrics()); err != nil {
+				slog.Warn("write metrics failed", "error", err)
+			}
+		})
+	}
+	if config.Properties.SidecarPort > 0 {
+		sidecarAddr := fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.SidecarPort)
+		// 与 RESP 端口使用同一个关闭信号，在关闭 db 之前停止
+		handler.Attach(func(closeChan <-chan struct{}) {
+			if err := sidecar.Serve(sidecarAddr, sidecar.MakeHandler(handler.DB()), closeChan); err != nil {
+				slog.Error("start sidecar failed: " + err.Error())
+			}
+		})
+	}
+	if strings.EqualFold(config.Properties.ServerMode, "epoll") {
+		err = epoll.Serve(listenAddr, handler)
+	} else {
+		err = std.Serve(listenAddr, handler)
+	}
 	if err != nil {
 		slog.Error("start server failed: %v", err)
+		os.Exit(1)
 	}
 
 }
@@ -1,19 +1,27 @@
 package pubhub
 
 import (
+	"sync"
+
 	"github.com/zhangming/go-redis/datastruct/dict"
 	"github.com/zhangming/go-redis/datastruct/lock"
 )
 
-
 type Hub struct {
-    subs dict.Dict 
+	subs       dict.Dict
 	subsLocker *lock.Locks
+	// 模式 -> *patternSubscribers
+	patterns   map[string]*patternSubscribers
+	patternsMu sync.RWMutex
+	// PUBLISH ... RETAIN 保存的每个频道最后一条消息
+	retained *retainStore
 }
 
 func MakeHub() *Hub {
 	return &Hub{
 		subs:       dict.MakeConcurrent(4),
 		subsLocker: lock.Make(16),
+		patterns:   make(map[string]*patternSubscribers),
+		retained:   makeRetainStore(),
 	}
 }
@@ -0,0 +1,171 @@
+package pubhub
+
+import (
+	"github.com/zhangming/go-redis/datastruct/list"
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/lib/wildcard"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 模式订阅
+// PUBLISH 需要把频道和所有模式逐个匹配，所以模式订阅者保存在一个整体加读写锁的表中，
+// 发布时持有读锁遍历，订阅和退订时持有写锁
+
+var (
+	_psubscribe   = "psubscribe"
+	_punsubscribe = "punsubscribe"
+	pmessageBytes = []byte("pmessage")
+)
+
+var patternsLockClass = lockorder.NewClass("pubhub.patterns")
+
+// patternSubscribers 是订阅同一个模式的连接
+type patternSubscribers struct {
+	// 编译失败的模式(例如以 \ 结尾)为 nil，不匹配任何频道
+	matcher     *wildcard.Pattern
+	subscribers *list.LinkedList
+}
+
+func (hub *Hub) lockPatterns() {
+	patternsLockClass.Acquire(0)
+	hub.patternsMu.Lock()
+}
+
+func (hub *Hub) unlockPatterns() {
+	hub.patternsMu.Unlock()
+	patternsLockClass.Release(0)
+}
+
+func (hub *Hub) rLockPatterns() {
+	patternsLockClass.Acquire(0)
+	hub.patternsMu.RLock()
+}
+
+func (hub *Hub) rUnlockPatterns() {
+	hub.patternsMu.RUnlock()
+	patternsLockClass.Release(0)
+}
+
+// psubscribe0 返回是否是新的订阅
+func psubscribe0(hub *Hub, client redis.Connection, pattern string) bool {
+	client.PSubscribe(pattern)
+	ps, ok := hub.patterns[pattern]
+	if !ok {
+		matcher, _ := wildcard.CompilePattern(pattern)
+		ps = &patternSubscribers{matcher: matcher, subscribers: list.Make()}
+		hub.patterns[pattern] = ps
+	}
+	if ps.subscribers.Contains(func(a interface{}) bool { return a == client }) {
+		return false
+	}
+	ps.subscribers.Add(client)
+	return true
+}
+
+// deliverRetainedPattern 把匹配模式的频道的保留消息发给新的模式订阅者。
+// 调用者持有模式写锁，并发的 PUBLISH 在 publishPatterns 中等待，不会先于保留消息到达
+func deliverRetainedPattern(hub *Hub, c redis.Connection, pattern string) {
+	matcher := hub.patterns[pattern].matcher
+	if matcher == nil {
+		return
+	}
+	hub.ForEachRetained(func(channel string, message []byte) bool {
+		if matcher.IsMatch(channel) {
+			_, _ = c.Write(makeBulksFrame(c, pmessageBytes, []byte(pattern), []byte(channel), message))
+		}
+		return true
+	})
+}
+
+func punsubscribe0(hub *Hub, client redis.Connection, pattern string) {
+	client.PUnSubscribe(pattern)
+	ps, ok := hub.patterns[pattern]
+	if !ok {
+		return
+	}
+	ps.subscribers.RemoveAllByVal(func(a interface{}) bool {
+		return utils.Equals(a, client)
+	})
+	if ps.subscribers.Len() == 0 {
+		delete(hub.patterns, pattern)
+	}
+}
+
+// PSubscribe 订阅匹配模式的所有频道
+func PSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	hub.lockPatterns()
+	defer hub.unlockPatterns()
+
+	for _, arg := range args {
+		pattern := string(arg)
+		subscribed := psubscribe0(hub, c, pattern)
+		_, _ = c.Write(makeMsg(c, _psubscribe, pattern, int64(c.SubsCount())))
+		if subscribed {
+			deliverRetainedPattern(hub, c, pattern)
+		}
+	}
+	return &protocol.NoReply{}
+}
+
+// PUnSubscribe 退订模式，没有参数时退订所有模式
+func PUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var patterns []string
+	if len(args) == 0 {
+		patterns = c.GetPatterns()
+	} else {
+		patterns = make([]string, len(args))
+		for i, b := range args {
+			patterns[i] = string(b)
+		}
+	}
+	if len(patterns) == 0 {
+		_, _ = c.Write(makeUnsubscribeNothing(c, _punsubscribe, int64(c.SubsCount())))
+		return &protocol.NoReply{}
+	}
+
+	hub.lockPatterns()
+	defer hub.unlockPatterns()
+
+	for _, pattern := range patterns {
+		punsubscribe0(hub, c, pattern)
+		_, _ = c.Write(makeMsg(c, _punsubscribe, pattern, int64(c.SubsCount())))
+	}
+	return &protocol.NoReply{}
+}
+
+// publishPatterns 把消息发给匹配频道的模式订阅者，返回接收者数量。
+// 同一个连接订阅了多个匹配的模式时每个模式各收到一条，与 redis 相同
+func publishPatterns(hub *Hub, channel string, message []byte) int64 {
+	hub.rLockPatterns()
+	defer hub.rUnlockPatterns()
+
+	var count int64
+	for pattern, ps := range hub.patterns {
+		if ps.matcher == nil || !ps.matcher.IsMatch(channel) {
+			continue
+		}
+		ps.subscribers.ForEach(func(i int, c interface{}) bool {
+			if client, ok := c.(redis.Connection); ok {
+				_, _ = client.Write(makeBulksFrame(client, pmessageBytes, []byte(pattern), []byte(channel), message))
+			}
+			return true
+		})
+		count += int64(ps.subscribers.Len())
+	}
+	return count
+}
+
+func unsubscribeAllPatterns(hub *Hub, c redis.Connection) {
+	patterns := c.GetPatterns()
+	if len(patterns) == 0 {
+		return
+	}
+	hub.lockPatterns()
+	defer hub.unlockPatterns()
+
+	for _, pattern := range patterns {
+		punsubscribe0(hub, c, pattern)
+	}
+}
@@ -1,9 +1,6 @@
 package pubhub
 
 import (
-
-	"strconv"
-
 	"github.com/zhangming/go-redis/datastruct/list"
 	"github.com/zhangming/go-redis/interfaces/redis"
 	"github.com/zhangming/go-redis/lib/utils"
@@ -11,22 +8,55 @@ import (
 )
 
 var (
-	_subscribe         = "subscribe"
-	_unsubscribe       = "unsubscribe"
-	messageBytes       = []byte("message")
-	unSubscribeNothing = []byte("*3\r\n$11\r\nunsubscribe\r\n$-1\n:0\r\n")
+	_subscribe   = "subscribe"
+	_unsubscribe = "unsubscribe"
+	messageBytes = []byte("message")
 )
 
-func makeMsg(t string, channel string, code int64) []byte {
-	return []byte("*3\r\n$" + strconv.FormatInt(int64(len(t)), 10) + protocol.CRLF + t + protocol.CRLF +
-		"$" + strconv.FormatInt(int64(len(channel)), 10) + protocol.CRLF + channel + protocol.CRLF +
-		":" + strconv.FormatInt(code, 10) + protocol.CRLF)
+// makeFrame 构造发给订阅者的帧，RESP3 连接使用 push 类型，RESP2 连接使用数组
+func makeFrame(c redis.Connection, replies []redis.Reply) []byte {
+	if c.GetProtocol() >= protocol.RESP3 {
+		return protocol.MakePushReply(replies).ToBytes()
+	}
+	return protocol.MakeMultiRawReply(replies).ToBytes()
+}
+
+// makeMsg 构造 subscribe/unsubscribe 的回复帧: [类型, 频道, 当前订阅数]
+func makeMsg(c redis.Connection, t string, channel string, code int64) []byte {
+	return makeFrame(c, []redis.Reply{
+		protocol.MakeBulkReply([]byte(t)),
+		protocol.MakeBulkReply([]byte(channel)),
+		protocol.MakeIntReply(code),
+	})
+}
+
+// makeUnsubscribeNothing 没有可以退订的频道或模式时 UNSUBSCRIBE/PUNSUBSCRIBE 的回复，频道为 null bulk
+func makeUnsubscribeNothing(c redis.Connection, t string, code int64) []byte {
+	return makeFrame(c, []redis.Reply{
+		protocol.MakeBulkReply([]byte(t)),
+		protocol.MakeNullBulkReply(),
+		protocol.MakeIntReply(code),
+	})
+}
+
+// makeBulksFrame 构造 message/pmessage 帧
+func makeBulksFrame(c redis.Connection, args ...[]byte) []byte {
+	replies := make([]redis.Reply, len(args))
+	for i, arg := range args {
+		replies[i] = protocol.MakeBulkReply(arg)
+	}
+	return makeFrame(c, replies)
 }
 
-// 发布订阅信息给客户端
+// 发布订阅信息给客户端，返回频道订阅者和模式订阅者的总数。
+// 带 RETAIN 时同时保存为频道的保留消息
 func Publish(hub *Hub, args [][]byte) redis.Reply {
-	if len(args) != 2 {
-		return protocol.MakeErrReply("publish")
+	if len(args) != 2 && len(args) != 3 {
+		return protocol.MakeArgNumErrReply("publish")
+	}
+	retain := IsRetainPublish(args)
+	if len(args) == 3 && !retain {
+		return protocol.MakeSyntaxErrReply()
 	}
 	//发布消息的目标，也是订阅者监听的对象
 	channel := string(args[0])
@@ -35,21 +65,36 @@ func Publish(hub *Hub, args [][]byte) redis.Reply {
 	hub.subsLocker.Lock(channel)
 	defer hub.subsLocker.UnLock(channel)
 
+	if retain {
+		// 先保存再发布: 频道订阅持有同一个频道锁，不会错过也不会重复收到；
+		// 并发的模式订阅者可能先收到保留消息再收到这次发布，但不会错过
+		hub.retained.retain(channel, message)
+	}
+	count := publishChannel(hub, channel, message)
+	count += publishPatterns(hub, channel, message)
+	return protocol.MakeIntReply(count)
+}
+
+// publishChannel 把消息发给频道的订阅者，返回接收者数量
+func publishChannel(hub *Hub, channel string, message []byte) int64 {
 	raw, ok := hub.subs.Get(channel)
 	if !ok {
-		return protocol.MakeIntReply(0)
+		return 0
 	}
 	subscribers, _ := raw.(*list.LinkedList)
 	subscribers.ForEach(func(i int, c interface{}) bool {
-		client, _ := c.(redis.Connection)
-		replyArgs := make([][]byte, 3)
-		replyArgs[0] = messageBytes
-		replyArgs[1] = []byte(channel)
-		replyArgs[2] = message
-		_, _ = client.Write(protocol.MakeMultiBulkReply(replyArgs).ToBytes())
+		switch client := c.(type) {
+		case redis.Connection:
+			_, _ = client.Write(makeBulksFrame(client, messageBytes, []byte(channel), message))
+		case *subscription:
+			// 参数的内存可能被连接复用，复制一份交给订阅者
+			payload := make([]byte, len(message))
+			copy(payload, message)
+			client.deliver(Message{Channel: channel, Payload: payload})
+		}
 		return true
 	})
-	return protocol.MakeIntReply(int64(subscribers.Len()))
+	return int64(subscribers.Len())
 }
 
 // 这里的topic是指代主题
@@ -81,9 +126,15 @@ func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
 	hub.subsLocker.Locks(topics...)
 	defer hub.subsLocker.UnLocks(topics...)
 
+	// 重复订阅同一个频道也要回复，订阅数不变
 	for _, topic := range topics {
-		if subscribe0(c, topic, hub) {
-			_, _ = c.Write(makeMsg(_subscribe, topic, int64(c.SubsCount())))
+		subscribed := subscribe0(c, topic, hub)
+		_, _ = c.Write(makeMsg(c, _subscribe, topic, int64(c.SubsCount())))
+		if !subscribed {
+			continue
+		}
+		if message, ok := hub.retained.get(topic); ok {
+			_, _ = c.Write(makeBulksFrame(c, messageBytes, []byte(topic), message))
 		}
 	}
 	return &protocol.NoReply{}
@@ -120,14 +171,17 @@ func UnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
 	}
 
 	if len(topics) == 0 {
-		_, _ = c.Write(unSubscribeNothing)
+		_, _ = c.Write(makeUnsubscribeNothing(c, _unsubscribe, int64(c.SubsCount())))
 		return &protocol.NoReply{}
 	}
 
+	hub.subsLocker.Locks(topics...)
+	defer hub.subsLocker.UnLocks(topics...)
+
+	// 即使没有订阅过该频道也要回复，订阅数为剩余的订阅数
 	for _, topic := range topics {
-		if unSubScribe0(c, topic, hub) {
-			_, _ = c.Write(makeMsg(_unsubscribe, topic, int64(c.SubsCount())))
-		}
+		unSubScribe0(c, topic, hub)
+		_, _ = c.Write(makeMsg(c, _unsubscribe, topic, int64(c.SubsCount())))
 	}
 	return &protocol.NoReply{}
 }
@@ -145,5 +199,5 @@ func UnsubscribeAll(hub *Hub, c redis.Connection) {
 	for _, channel := range channels {
 		unSubScribe0(c, channel, hub)
 	}
-
+	unsubscribeAllPatterns(hub, c)
 }
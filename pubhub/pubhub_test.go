@@ -0,0 +1,265 @@
+package pubhub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func assertBytes(t *testing.T, c *connection.FakeConn, expected string) {
+	t.Helper()
+	actual := string(c.Bytes())
+	if actual != expected {
+		t.Errorf("expected %q, actually %q", expected, actual)
+	}
+	c.Clean()
+}
+
+func TestSubscribe(t *testing.T) {
+	hub := MakeHub()
+	c := connection.NewFakeConn()
+	reply := Subscribe(hub, c, utils.ToCmdLine("a", "b"))
+	if _, ok := reply.(*protocol.NoReply); !ok {
+		t.Errorf("expected NoReply, actually %q", reply.ToBytes())
+	}
+	assertBytes(t, c, "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n"+
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n")
+
+	// subscribe again replies with unchanged count
+	Subscribe(hub, c, utils.ToCmdLine("a"))
+	assertBytes(t, c, "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:2\r\n")
+}
+
+func TestPublish(t *testing.T) {
+	hub := MakeHub()
+	c1 := connection.NewFakeConn()
+	c2 := connection.NewFakeConn()
+	Subscribe(hub, c1, utils.ToCmdLine("ch"))
+	Subscribe(hub, c2, utils.ToCmdLine("ch"))
+	c1.Clean()
+	c2.Clean()
+
+	reply := Publish(hub, utils.ToCmdLine("ch", "hello"))
+	if string(reply.ToBytes()) != ":2\r\n" {
+		t.Errorf("expected :2, actually %q", reply.ToBytes())
+	}
+	msg := "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$5\r\nhello\r\n"
+	assertBytes(t, c1, msg)
+	assertBytes(t, c2, msg)
+
+	reply = Publish(hub, utils.ToCmdLine("nobody", "hello"))
+	if string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected :0, actually %q", reply.ToBytes())
+	}
+	reply = Publish(hub, utils.ToCmdLine("ch"))
+	if string(reply.ToBytes()) != "-ERR wrong number of arguments for 'publish' command\r\n" {
+		t.Errorf("expected arg num error, actually %q", reply.ToBytes())
+	}
+}
+
+func TestUnSubscribe(t *testing.T) {
+	hub := MakeHub()
+	c := connection.NewFakeConn()
+	Subscribe(hub, c, utils.ToCmdLine("a", "b", "c"))
+	c.Clean()
+
+	UnSubscribe(hub, c, utils.ToCmdLine("a"))
+	assertBytes(t, c, "*3\r\n$11\r\nunsubscribe\r\n$1\r\na\r\n:2\r\n")
+
+	// channel never subscribed still gets a frame with remaining count
+	UnSubscribe(hub, c, utils.ToCmdLine("x"))
+	assertBytes(t, c, "*3\r\n$11\r\nunsubscribe\r\n$1\r\nx\r\n:2\r\n")
+
+	// no args unsubscribes all remaining channels
+	UnSubscribe(hub, c, nil)
+	out := string(c.Bytes())
+	c.Clean()
+	frameB := "*3\r\n$11\r\nunsubscribe\r\n$1\r\nb\r\n"
+	frameC := "*3\r\n$11\r\nunsubscribe\r\n$1\r\nc\r\n"
+	if out != frameB+":1\r\n"+frameC+":0\r\n" && out != frameC+":1\r\n"+frameB+":0\r\n" {
+		t.Errorf("unexpected unsubscribe all reply %q", out)
+	}
+
+	UnSubscribe(hub, c, nil)
+	assertBytes(t, c, "*3\r\n$11\r\nunsubscribe\r\n$-1\r\n:0\r\n")
+
+	reply := Publish(hub, utils.ToCmdLine("b", "hello"))
+	if string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected :0, actually %q", reply.ToBytes())
+	}
+}
+
+func TestUnsubscribeAll(t *testing.T) {
+	hub := MakeHub()
+	c := connection.NewFakeConn()
+	Subscribe(hub, c, utils.ToCmdLine("a", "b"))
+	UnsubscribeAll(hub, c)
+	if c.SubsCount() != 0 {
+		t.Errorf("expected 0 subscriptions, actually %d", c.SubsCount())
+	}
+	reply := Publish(hub, utils.ToCmdLine("a", "hello"))
+	if string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected :0, actually %q", reply.ToBytes())
+	}
+}
+
+func TestHubSubscribe(t *testing.T) {
+	hub := MakeHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err := hub.Subscribe(ctx, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := connection.NewFakeConn()
+	Subscribe(hub, c, utils.ToCmdLine("a"))
+
+	// 连接和进程内订阅者都计入接收者数量
+	if reply := Publish(hub, utils.ToCmdLine("a", "hello")); string(reply.ToBytes()) != ":2\r\n" {
+		t.Errorf("expected :2, actually %q", reply.ToBytes())
+	}
+	Publish(hub, utils.ToCmdLine("b", "world"))
+	for _, expected := range []Message{{"a", []byte("hello")}, {"b", []byte("world")}} {
+		msg := <-ch
+		if msg.Channel != expected.Channel || string(msg.Payload) != string(expected.Payload) {
+			t.Errorf("expected %v, actually %v", expected, msg)
+		}
+	}
+
+	// 缓存写满后发布者最多等待 SubscriptionTimeout，之后丢弃消息
+	for i := 0; i < SubscriptionBuffer; i++ {
+		Publish(hub, utils.ToCmdLine("b", "x"))
+	}
+	start := time.Now()
+	Publish(hub, utils.ToCmdLine("b", "dropped"))
+	if elapsed := time.Since(start); elapsed < SubscriptionTimeout {
+		t.Errorf("expected publish to wait for the consumer, returned after %v", elapsed)
+	}
+	for i := 0; i < SubscriptionBuffer; i++ {
+		if msg := <-ch; string(msg.Payload) != "x" {
+			t.Fatalf("expected x, actually %q", msg.Payload)
+		}
+	}
+
+	// 等待中的发布者在取消订阅后立即返回
+	for i := 0; i < SubscriptionBuffer; i++ {
+		Publish(hub, utils.ToCmdLine("b", "x"))
+	}
+	published := make(chan struct{})
+	go func() {
+		Publish(hub, utils.ToCmdLine("b", "blocked"))
+		close(published)
+	}()
+	time.Sleep(SubscriptionTimeout / 4)
+	cancel()
+	select {
+	case <-published:
+	case <-time.After(SubscriptionTimeout / 2):
+		t.Fatal("expected publish to return after unsubscribing")
+	}
+	for range ch {
+	}
+	if reply := Publish(hub, utils.ToCmdLine("b", "x")); string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected :0, actually %q", reply.ToBytes())
+	}
+
+	if _, err := hub.Subscribe(ctx, "a"); err == nil {
+		t.Error("expected error for cancelled context")
+	}
+	if _, err := hub.Subscribe(context.Background()); err == nil {
+		t.Error("expected error without channels")
+	}
+}
+
+func TestPSubscribe(t *testing.T) {
+	hub := MakeHub()
+	c := connection.NewFakeConn()
+	Subscribe(hub, c, utils.ToCmdLine("news.tech"))
+	c.Clean()
+	PSubscribe(hub, c, utils.ToCmdLine("news.*", "h?llo"))
+	// 订阅数包括频道和模式
+	assertBytes(t, c, "*3\r\n$10\r\npsubscribe\r\n$6\r\nnews.*\r\n:2\r\n"+
+		"*3\r\n$10\r\npsubscribe\r\n$5\r\nh?llo\r\n:3\r\n")
+
+	other := connection.NewFakeConn()
+	PSubscribe(hub, other, utils.ToCmdLine("news.[st]*"))
+	other.Clean()
+
+	// 频道订阅和每个匹配的模式各收到一条
+	if reply := Publish(hub, utils.ToCmdLine("news.tech", "go")); string(reply.ToBytes()) != ":3\r\n" {
+		t.Errorf("expected :3, actually %q", reply.ToBytes())
+	}
+	assertBytes(t, c, "*3\r\n$7\r\nmessage\r\n$9\r\nnews.tech\r\n$2\r\ngo\r\n"+
+		"*4\r\n$8\r\npmessage\r\n$6\r\nnews.*\r\n$9\r\nnews.tech\r\n$2\r\ngo\r\n")
+	assertBytes(t, other, "*4\r\n$8\r\npmessage\r\n$10\r\nnews.[st]*\r\n$9\r\nnews.tech\r\n$2\r\ngo\r\n")
+	if reply := Publish(hub, utils.ToCmdLine("hallo", "x")); string(reply.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected :1, actually %q", reply.ToBytes())
+	}
+	c.Clean()
+
+	PUnSubscribe(hub, c, utils.ToCmdLine("news.*"))
+	assertBytes(t, c, "*3\r\n$12\r\npunsubscribe\r\n$6\r\nnews.*\r\n:2\r\n")
+	if reply := Publish(hub, utils.ToCmdLine("news.sport", "x")); string(reply.ToBytes()) != ":1\r\n" {
+		t.Errorf("expected :1, actually %q", reply.ToBytes())
+	}
+	PUnSubscribe(hub, c, nil)
+	assertBytes(t, c, "*3\r\n$12\r\npunsubscribe\r\n$5\r\nh?llo\r\n:1\r\n")
+	// 剩余的订阅数包括频道
+	PUnSubscribe(hub, c, nil)
+	assertBytes(t, c, "*3\r\n$12\r\npunsubscribe\r\n$-1\r\n:1\r\n")
+
+	UnsubscribeAll(hub, other)
+	if reply := Publish(hub, utils.ToCmdLine("news.sport", "x")); string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected :0, actually %q", reply.ToBytes())
+	}
+}
+
+func TestRetain(t *testing.T) {
+	hub := MakeHub()
+	hub.SetRetainLimit(2)
+	reply := Publish(hub, utils.ToCmdLine("a", "1", "RETAIN"))
+	if string(reply.ToBytes()) != ":0\r\n" {
+		t.Errorf("expected :0, actually %q", reply.ToBytes())
+	}
+	reply = Publish(hub, utils.ToCmdLine("a", "1", "KEEP"))
+	if string(reply.ToBytes()) != "-ERR syntax error\r\n" {
+		t.Errorf("expected syntax error, actually %q", reply.ToBytes())
+	}
+
+	// 新订阅者订阅后立即收到保留消息，重复订阅不会再收到
+	c := connection.NewFakeConn()
+	Subscribe(hub, c, utils.ToCmdLine("a", "b"))
+	assertBytes(t, c, "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:1\r\n"+
+		"*3\r\n$7\r\nmessage\r\n$1\r\na\r\n$1\r\n1\r\n"+
+		"*3\r\n$9\r\nsubscribe\r\n$1\r\nb\r\n:2\r\n")
+	Subscribe(hub, c, utils.ToCmdLine("a"))
+	assertBytes(t, c, "*3\r\n$9\r\nsubscribe\r\n$1\r\na\r\n:2\r\n")
+
+	// 只保留最后一条，普通发布不影响保留消息
+	Publish(hub, utils.ToCmdLine("a", "2", "retain"))
+	Publish(hub, utils.ToCmdLine("a", "3"))
+	c.Clean()
+	p := connection.NewFakeConn()
+	PSubscribe(hub, p, utils.ToCmdLine("a*"))
+	assertBytes(t, p, "*3\r\n$10\r\npsubscribe\r\n$2\r\na*\r\n:1\r\n"+
+		"*4\r\n$8\r\npmessage\r\n$2\r\na*\r\n$1\r\na\r\n$1\r\n2\r\n")
+
+	// 超过上限时淘汰最久没有更新的频道，空消息删除保留消息
+	Publish(hub, utils.ToCmdLine("b", "x", "RETAIN"))
+	Publish(hub, utils.ToCmdLine("c", "y", "RETAIN"))
+	var channels []string
+	hub.ForEachRetained(func(channel string, message []byte) bool {
+		channels = append(channels, channel+"="+string(message))
+		return true
+	})
+	if len(channels) != 2 || channels[0] != "b=x" || channels[1] != "c=y" {
+		t.Errorf("unexpected retained messages %v", channels)
+	}
+	Publish(hub, utils.ToCmdLine("b", "", "RETAIN"))
+	if hub.RetainedCount() != 1 {
+		t.Errorf("expected 1 retained message, actually %d", hub.RetainedCount())
+	}
+}
@@ -0,0 +1,119 @@
+package pubhub
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// 保留消息
+// PUBLISH channel message RETAIN 在发布的同时保存频道的最后一条消息，之后订阅该频道(或匹配的模式)的客户端
+// 订阅后立即收到这条消息，与 MQTT 的 retain 相同。消息为空串时删除保留消息。
+// 保留的频道数有上限，超过时淘汰最久没有更新的频道
+
+// DefaultRetainLimit is the max number of retained channels if not configured
+const DefaultRetainLimit = 1024
+
+var retainFlag = "retain"
+
+type retainedMsg struct {
+	channel string
+	payload []byte
+}
+
+// retainStore 按更新顺序保存保留消息，链表头部是最久没有更新的频道
+type retainStore struct {
+	mu       sync.Mutex
+	limit    int
+	order    *list.List
+	channels map[string]*list.Element
+}
+
+func makeRetainStore() *retainStore {
+	return &retainStore{
+		limit:    DefaultRetainLimit,
+		order:    list.New(),
+		channels: make(map[string]*list.Element),
+	}
+}
+
+// IsRetainPublish tells whether PUBLISH arguments (without command name) carry the RETAIN flag
+func IsRetainPublish(args [][]byte) bool {
+	return len(args) == 3 && strings.EqualFold(string(args[2]), retainFlag)
+}
+
+// SetRetainLimit sets the max number of retained channels, limit <= 0 means DefaultRetainLimit
+func (hub *Hub) SetRetainLimit(limit int) {
+	if limit <= 0 {
+		limit = DefaultRetainLimit
+	}
+	store := hub.retained
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.limit = limit
+	store.evict()
+}
+
+func (store *retainStore) evict() {
+	for store.order.Len() > store.limit {
+		front := store.order.Front()
+		store.order.Remove(front)
+		delete(store.channels, front.Value.(*retainedMsg).channel)
+	}
+}
+
+// retain 保存频道的最后一条消息，message 为空时删除
+func (store *retainStore) retain(channel string, message []byte) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	if elem, ok := store.channels[channel]; ok {
+		store.order.Remove(elem)
+		delete(store.channels, channel)
+	}
+	if len(message) == 0 {
+		return
+	}
+	// 参数的内存可能被连接复用
+	payload := make([]byte, len(message))
+	copy(payload, message)
+	store.channels[channel] = store.order.PushBack(&retainedMsg{channel: channel, payload: payload})
+	store.evict()
+}
+
+func (store *retainStore) get(channel string) ([]byte, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	elem, ok := store.channels[channel]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*retainedMsg).payload, true
+}
+
+// snapshot 按更新顺序返回所有保留消息，遍历时不持有锁
+func (store *retainStore) snapshot() []*retainedMsg {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	msgs := make([]*retainedMsg, 0, store.order.Len())
+	for elem := store.order.Front(); elem != nil; elem = elem.Next() {
+		msgs = append(msgs, elem.Value.(*retainedMsg))
+	}
+	return msgs
+}
+
+// RetainedCount returns the number of channels with a retained message
+func (hub *Hub) RetainedCount() int {
+	hub.retained.mu.Lock()
+	defer hub.retained.mu.Unlock()
+	return hub.retained.order.Len()
+}
+
+// ForEachRetained visits retained messages from the least recently updated one,
+// used by aof rewrite to keep the retained messages and their eviction order
+func (hub *Hub) ForEachRetained(cb func(channel string, message []byte) bool) {
+	for _, msg := range hub.retained.snapshot() {
+		if !cb(msg.channel, msg.payload) {
+			return
+		}
+	}
+}
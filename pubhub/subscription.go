@@ -0,0 +1,110 @@
+package pubhub
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zhangming/go-redis/datastruct/list"
+)
+
+// 进程内订阅
+// 嵌入使用时 Go 程序不需要构造 RESP 连接，直接通过 channel 接收发布的消息和 keyspace 通知。
+// 订阅者和客户端连接保存在同一个订阅者列表中，PUBLISH 回复的接收者数量也包括它们
+
+// SubscriptionBuffer 是每个订阅缓存的消息数
+const SubscriptionBuffer = 128
+
+// SubscriptionTimeout 是缓存已满时发布者等待消费者的最长时间，超时后丢弃这条消息
+const SubscriptionTimeout = 100 * time.Millisecond
+
+// Message 是进程内订阅者收到的消息
+type Message struct {
+	Channel string
+	Payload []byte
+}
+
+// subscription 是进程内的订阅者
+type subscription struct {
+	ch   chan Message
+	done <-chan struct{}
+}
+
+// deliver 投递消息，缓存已满时发布者最多等待 SubscriptionTimeout，
+// 消费者仍然没有取走消息就丢弃
+func (s *subscription) deliver(msg Message) {
+	select {
+	case s.ch <- msg:
+		return
+	default:
+	}
+	timer := time.NewTimer(SubscriptionTimeout)
+	defer timer.Stop()
+	select {
+	case s.ch <- msg:
+	case <-s.done:
+	case <-timer.C:
+	}
+}
+
+// Subscribe 订阅频道，返回接收消息的 channel。
+// 发布者包括持有 key 锁的写命令(例如 MULTI 中的 PUBLISH)，消费者跟不上时 PUBLISH 最多等待 SubscriptionTimeout，
+// 之后丢弃这条消息，一个较慢的消费者不会无限期地阻塞写命令。
+// 消费者应该尽快取走消息，不要在接收消息的协程中同步调用会等待新消息的操作。
+// ctx 结束后退订并关闭 channel
+func (hub *Hub) Subscribe(ctx context.Context, channels ...string) (<-chan Message, error) {
+	if len(channels) == 0 {
+		return nil, errors.New("no channel to subscribe")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	sub := &subscription{
+		ch:   make(chan Message, SubscriptionBuffer),
+		done: ctx.Done(),
+	}
+	hub.subsLocker.Locks(channels...)
+	for _, channel := range channels {
+		var subscribers *list.LinkedList
+		if raw, ok := hub.subs.Get(channel); ok {
+			subscribers = raw.(*list.LinkedList)
+		} else {
+			subscribers = list.Make()
+			hub.subs.Put(channel, subscribers)
+		}
+		if !subscribers.Contains(func(a interface{}) bool { return a == sub }) {
+			subscribers.Add(sub)
+		}
+		if retained, ok := hub.retained.get(channel); ok {
+			payload := make([]byte, len(retained))
+			copy(payload, retained)
+			// 消费者还没有拿到 channel，缓存已满时丢弃多出的保留消息而不是阻塞
+			select {
+			case sub.ch <- Message{Channel: channel, Payload: payload}:
+			default:
+			}
+		}
+	}
+	hub.subsLocker.UnLocks(channels...)
+
+	go func() {
+		<-ctx.Done()
+		// 阻塞在 deliver 中的发布者已经因为 done 返回，可以拿到频道锁
+		hub.subsLocker.Locks(channels...)
+		for _, channel := range channels {
+			raw, ok := hub.subs.Get(channel)
+			if !ok {
+				continue
+			}
+			subscribers := raw.(*list.LinkedList)
+			subscribers.RemoveAllByVal(func(a interface{}) bool { return a == sub })
+			if subscribers.Len() == 0 {
+				hub.subs.Remove(channel)
+			}
+		}
+		hub.subsLocker.UnLocks(channels...)
+		// 退订之后不会再有发布者写入
+		close(sub.ch)
+	}()
+	return sub.ch, nil
+}
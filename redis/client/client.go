@@ -4,6 +4,7 @@ import (
 	"errors"
 	"log/slog"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -11,36 +12,79 @@ import (
 
 	"github.com/zhangming/go-redis/interfaces/redis"
 	"github.com/zhangming/go-redis/interfaces/redis/parser"
-	"github.com/zhangming/go-redis/lib/sync/wait"
 	"github.com/zhangming/go-redis/redis/protocol"
 )
 
 // 这种在服务端未响应时客户端继续向服务端发送请求的模式称为 Pipeline
-// Client is a pipeline mode redis client
+// 请求按发送顺序进入 waiting 队列，服务端按顺序回复，收到的回复总是属于队首的请求。
+// 连接断开时队列中的请求全部失败，然后重新连接并恢复 AUTH 和 SELECT 的状态
+
+// Client is a pipeline mode redis client, it is safe for concurrent use
 type Client struct {
-	conn        net.Conn
+	addr string
+	opts Options
+
 	pendingReqs chan *request // wait to send
-	waitingReqs chan *request // waiting response
-	ticker      *time.Ticker  // 蹇冭烦
-	addr        string
+	done        chan struct{} // closed by Close
+	ticker      *time.Ticker  // 心跳
+	status      atomic.Int32
+
+	// mu 保护连接和等待回复的队列，写入连接和入队在同一把锁内，保证队列顺序与发送顺序相同
+	mu      sync.Mutex
+	conn    net.Conn
+	replies <-chan *parser.Payload // conn 上的解析结果，Start 之前由 MakeClient 保存
+	waiting []*request
+	// 通过 SELECT 选择的数据库，重连后恢复
+	db int
+}
 
-	status  int32
-	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
+// Options configures a Client, zero value uses defaults
+type Options struct {
+	// Password is sent by AUTH after connecting and reconnecting
+	Password string
+	// DB is selected after connecting
+	DB int
+	// DialTimeout defaults to 3 seconds
+	DialTimeout time.Duration
+	// Timeout is the max time waiting for a reply, defaults to 3 seconds
+	Timeout time.Duration
+	// Compress asks the server to send large bulk strings LZ4-compressed by HELLO 2 COMPRESS lz4,
+	// replies are decompressed transparently. Servers without the extension reject the handshake
+	Compress bool
 }
 
 // request is a message sends to redis server
 type request struct {
-	id        uint64
-	args      [][]byte
-	reply     redis.Reply
-	heartbeat bool
-	waiting   *wait.Wait
-	err       error
+	args  [][]byte
+	reply redis.Reply
+	err   error
+	done  chan struct{}
+	once  sync.Once
+}
+
+func makeRequest(args [][]byte) *request {
+	return &request{
+		args: args,
+		done: make(chan struct{}),
+	}
+}
+
+// finish 只有第一次调用生效，关闭客户端和连接断开可能同时结束同一个请求
+func (req *request) finish(reply redis.Reply, err error) {
+	req.once.Do(func() {
+		req.reply = reply
+		req.err = err
+		close(req.done)
+	})
 }
 
 const (
 	chanSize = 256
 	maxWait  = 3 * time.Second
+	// 断线后重连的次数和间隔，全部失败后客户端关闭
+	maxReconnect      = 3
+	reconnectInterval = time.Second
+	heartbeatInterval = 10 * time.Second
 )
 
 const (
@@ -49,19 +93,38 @@ const (
 	closed
 )
 
+var (
+	errClosed       = errors.New("client closed")
+	errDisconnected = errors.New("connection closed")
+)
+
 // MakeClient creates a new client
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
-	if err != nil {
-		return nil, err
+	return MakeClientWithOptions(addr, Options{})
+}
+
+// MakeClientWithOptions creates a new client, call Start before sending requests
+func MakeClientWithOptions(addr string, opts Options) (*Client, error) {
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = maxWait
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = maxWait
 	}
-	return &Client{
+	client := &Client{
 		addr:        addr,
-		conn:        conn,
+		opts:        opts,
 		pendingReqs: make(chan *request, chanSize),
-		waitingReqs: make(chan *request, chanSize),
-		working:     &sync.WaitGroup{},
-	}, nil
+		done:        make(chan struct{}),
+		db:          opts.DB,
+	}
+	conn, replies, err := client.dial()
+	if err != nil {
+		return nil, err
+	}
+	client.conn = conn
+	client.replies = replies
+	return client, nil
 }
 
 func (client *Client) RemoteAddress() string {
@@ -70,148 +133,263 @@ func (client *Client) RemoteAddress() string {
 
 // Start starts asynchronous goroutines
 func (client *Client) Start() {
-	client.ticker = time.NewTicker(10 * time.Second)
+	client.ticker = time.NewTicker(heartbeatInterval)
+	client.status.Store(running)
 	go client.handleWrite()
-	go client.handleRead()
+	go client.handleRead(client.conn, client.replies)
 	go client.heartBeat()
-	atomic.StoreInt32(&client.status, running)
 }
 
+// Closed returns true after client is closed, including giving up reconnecting
+func (client *Client) Closed() bool {
+	return client.status.Load() == closed
+}
+
+// Close closes the connection, requests waiting for reply fail immediately
 func (client *Client) Close() {
-	atomic.StoreInt32(&client.status, closed)
-	client.ticker.Stop()
-	// stop new request
-	close(client.pendingReqs)
+	if client.status.Swap(closed) == closed {
+		return
+	}
+	close(client.done)
+	if client.ticker != nil {
+		client.ticker.Stop()
+	}
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if client.conn != nil {
+		_ = client.conn.Close()
+	}
+	client.failWaiting(errClosed)
+}
 
-	// wait stop process
-	client.working.Wait()
+// dial 建立连接并恢复 AUTH、SELECT 和回复压缩的状态，返回连接和连接上的解析结果
+func (client *Client) dial() (net.Conn, <-chan *parser.Payload, error) {
+	conn, err := net.DialTimeout("tcp", client.addr, client.opts.DialTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	replies := parser.ParseStream(conn)
+	var handshake [][][]byte
+	if client.opts.Password != "" {
+		handshake = append(handshake, [][]byte{[]byte("AUTH"), []byte(client.opts.Password)})
+	}
+	if client.db != 0 {
+		handshake = append(handshake, [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(client.db))})
+	}
+	if client.opts.Compress {
+		// 解析器认识压缩的 bulk string，收到后直接解压
+		handshake = append(handshake, [][]byte{[]byte("HELLO"), []byte("2"), []byte("COMPRESS"), []byte("lz4")})
+	}
+	for _, cmdLine := range handshake {
+		_ = conn.SetDeadline(time.Now().Add(client.opts.Timeout))
+		err = client.handshake(conn, replies, cmdLine)
+		if err != nil {
+			_ = conn.Close()
+			// 等待解析协程退出
+			go func() {
+				for range replies {
+				}
+			}()
+			return nil, nil, err
+		}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return conn, replies, nil
+}
 
-	// clean
-	_ = client.conn.Close()
-	close(client.waitingReqs)
+func (client *Client) handshake(conn net.Conn, replies <-chan *parser.Payload, cmdLine [][]byte) error {
+	if _, err := conn.Write(protocol.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		return err
+	}
+	payload, ok := <-replies
+	if !ok {
+		return errDisconnected
+	}
+	if payload.Err != nil {
+		return payload.Err
+	}
+	if errReply, ok := payload.Data.(protocol.ErrorReply); ok {
+		return errors.New(strings.ToLower(string(cmdLine[0])) + " failed: " + errReply.Error())
+	}
+	return nil
 }
 
-func (client *Client) handleRead() {
-	ch := parser.ParseStream(client.conn)
-	for payload := range ch {
+func (client *Client) handleRead(conn net.Conn, replies <-chan *parser.Payload) {
+	for payload := range replies {
 		if payload.Err != nil {
-			status := atomic.LoadInt32(&client.status)
-			if status == closed {
-				return
-			}
-			client.reconnect()
-			return
+			break
 		}
 		client.finishRequest(payload.Data)
 	}
+	// 连接断开或者出现协议错误，之后的回复无法与请求对应
+	for range replies {
+	}
+	if client.Closed() {
+		return
+	}
+	if !client.reconnect(conn) {
+		slog.Warn("give up reconnecting to " + client.addr)
+		client.Close()
+	}
 }
 
 func (client *Client) handleWrite() {
-	for req := range client.pendingReqs {
-		client.doRequest(req)
+	for {
+		select {
+		case req := <-client.pendingReqs:
+			client.doRequest(req)
+		case <-client.done:
+			// 关闭后还在队列中的请求直接失败
+			for {
+				select {
+				case req := <-client.pendingReqs:
+					req.finish(nil, errClosed)
+				default:
+					return
+				}
+			}
+		}
 	}
 }
 
 func (client *Client) heartBeat() {
-	for range client.ticker.C {
-		client.doHeartbeat()
+	for {
+		select {
+		case <-client.ticker.C:
+			client.Send([][]byte{[]byte("PING")})
+		case <-client.done:
+			return
+		}
 	}
 }
 
+// finishRequest 回复属于等待队列的队首请求
 func (client *Client) finishRequest(reply redis.Reply) {
-	request := <-client.waitingReqs
-	if request == nil {
+	client.mu.Lock()
+	if len(client.waiting) == 0 {
+		client.mu.Unlock()
 		return
 	}
-	request.reply = reply
-	if request.waiting != nil {
-		request.waiting.Done()
+	req := client.waiting[0]
+	client.waiting[0] = nil
+	client.waiting = client.waiting[1:]
+	if isSelect(req.args) && protocol.IsOKReply(reply) {
+		client.db, _ = strconv.Atoi(string(req.args[1]))
 	}
+	client.mu.Unlock()
+	req.finish(reply, nil)
+}
+
+func isSelect(args [][]byte) bool {
+	return len(args) == 2 && strings.EqualFold(string(args[0]), "select")
 }
 
 func (client *Client) doRequest(req *request) {
-	if req == nil || len(req.args) == 0 {
+	if len(req.args) == 0 {
+		req.finish(nil, errors.New("empty command"))
 		return
 	}
-	re := protocol.MakeMultiBulkReply(req.args)
-	bytes := re.ToBytes()
-	var err error
-	for i := 0; i < 3; i++ { // only retry, waiting for handleRead
-		_, err = client.conn.Write(bytes)
-		if err == nil ||
-			(!strings.Contains(err.Error(), "timeout") && // only retry timeout
-				!strings.Contains(err.Error(), "deadline exceeded")) {
-			break
-		}
+	data := protocol.MakeMultiBulkReply(req.args).ToBytes()
+	client.mu.Lock()
+	if client.conn == nil {
+		client.mu.Unlock()
+		req.finish(nil, errDisconnected)
+		return
 	}
+	_ = client.conn.SetWriteDeadline(time.Now().Add(client.opts.Timeout))
+	_, err := client.conn.Write(data)
 	if err == nil {
-		client.waitingReqs <- req
-	} else {
-		req.err = err
-		req.waiting.Done()
-	}
-}
-func (client *Client) doHeartbeat() {
-	request := &request{
-		args:      [][]byte{[]byte("PING")},
-		heartbeat: true,
-		waiting:   &wait.Wait{},
-	}
-	client.working.Add(1)
-	request.waiting.Add(1)
-	defer client.working.Done()
-	client.pendingReqs <- request
-	request.waiting.WaitWithTimeout(maxWait)
-}
-func (client *Client) reconnect() {
-	slog.Info("reconnecting to redis server")
-	_ = client.conn.Close()
-	var conn net.Conn
-	var err error
-	for i := 0; i < 3; i++ {
-		conn, err = net.Dial("tcp", client.addr)
-		if err != nil {
-			time.Sleep(time.Second)
-			continue
-		} else {
-			break
-		}
+		client.waiting = append(client.waiting, req)
 	}
-	if conn == nil { // reach max retry, abort
-		client.Close()
-		return
+	client.mu.Unlock()
+	if err != nil {
+		// 读协程会发现连接断开并重连
+		req.finish(nil, err)
 	}
-	client.conn = conn
-	close(client.waitingReqs)
-	for req := range client.waitingReqs {
-		req.err = errors.New("connection closed")
-		req.waiting.Done()
+}
+
+// failWaiting 连接断开时等待回复的请求全部失败，调用方持有 mu
+func (client *Client) failWaiting(err error) {
+	for _, req := range client.waiting {
+		req.finish(nil, err)
 	}
-	client.waitingReqs = make(chan *request, chanSize)
-	// restart handle read
-	go client.handleRead()
+	client.waiting = nil
 }
 
-func (client *Client) Send(args [][]byte) redis.Reply {
-	if atomic.LoadInt32(&client.status) != running {
-		return protocol.MakeErrReply("client closed")
+// reconnect 替换断开的连接 old，返回 false 表示放弃重连
+func (client *Client) reconnect(old net.Conn) bool {
+	slog.Info("reconnecting to redis server " + client.addr)
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	_ = old.Close()
+	client.conn = nil
+	client.failWaiting(errDisconnected)
+	for i := 0; i < maxReconnect; i++ {
+		if client.Closed() {
+			return true
+		}
+		conn, replies, err := client.dial()
+		if err == nil {
+			client.conn = conn
+			go client.handleRead(conn, replies)
+			return true
+		}
+		slog.Warn("reconnect failed", "addr", client.addr, "err", err)
+		time.Sleep(reconnectInterval)
 	}
+	return false
+}
 
-	req := &request{
-		args:      args,
-		heartbeat: false,
-		waiting:   &wait.Wait{},
+// DB returns the database selected by the last successful SELECT, it is restored after reconnecting
+func (client *Client) DB() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.db
+}
+
+// Send sends a command and waits for its reply
+func (client *Client) Send(args [][]byte) redis.Reply {
+	return client.Pipeline([][][]byte{args})[0]
+}
+
+// Pipeline sends all commands without waiting for replies in between,
+// then returns their replies in order
+func (client *Client) Pipeline(cmdLines [][][]byte) []redis.Reply {
+	result := make([]redis.Reply, len(cmdLines))
+	if client.status.Load() != running {
+		for i := range result {
+			result[i] = protocol.MakeErrReply("client closed")
+		}
+		return result
 	}
-	req.waiting.Add(1)
-	client.working.Add(1)
-	defer client.working.Done()
-	client.pendingReqs <- req
-	timeout := req.waiting.WaitWithTimeout(maxWait)
-	if timeout {
-		return protocol.MakeErrReply("server time out")
+	reqs := make([]*request, len(cmdLines))
+	for i, args := range cmdLines {
+		reqs[i] = makeRequest(args)
+		select {
+		case client.pendingReqs <- reqs[i]:
+		case <-client.done:
+			reqs[i].finish(nil, errClosed)
+		}
 	}
-	if req.err != nil {
-		return protocol.MakeErrReply("request failed " + req.err.Error())
+	timer := time.NewTimer(client.opts.Timeout)
+	defer timer.Stop()
+	for i, req := range reqs {
+		select {
+		case <-req.done:
+		case <-timer.C:
+			for ; i < len(reqs); i++ {
+				result[i] = protocol.MakeErrReply("server time out")
+			}
+			return result
+		case <-client.done:
+			// 请求可能在关闭之前已经完成
+			req.finish(nil, errClosed)
+		}
+		if req.err != nil {
+			result[i] = protocol.MakeErrReply("request failed " + req.err.Error())
+		} else {
+			result[i] = req.reply
+		}
 	}
-	return req.reply
+	return result
 }
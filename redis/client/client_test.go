@@ -0,0 +1,183 @@
+package client
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+	"github.com/zhangming/go-redis/redis/server/std"
+	"github.com/zhangming/go-redis/tcp"
+)
+
+func startServer(t *testing.T, cfg *config.ServerProperties) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Dir = t.TempDir()
+	handler := std.MakeHandlerWithConfig(cfg)
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tcp.ListenAndServe(listener, handler, closeChan)
+	}()
+	t.Cleanup(func() {
+		close(closeChan)
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	addr := startServer(t, &config.ServerProperties{RequirePass: "secret"})
+	if _, err := MakeClientWithOptions(addr, Options{Password: "wrong"}); err == nil {
+		t.Fatal("expected handshake to fail with wrong password")
+	}
+	c, err := MakeClientWithOptions(addr, Options{Password: "secret", DB: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Close()
+
+	// 流水线中的回复按顺序对应请求
+	cmdLines := make([][][]byte, 100)
+	for i := range cmdLines {
+		cmdLines[i] = utils.ToCmdLine("INCR", "counter")
+	}
+	for i, reply := range c.Pipeline(cmdLines) {
+		if intReply, ok := reply.(*protocol.IntReply); !ok || intReply.Code != int64(i+1) {
+			t.Fatalf("reply %d: unexpected %q", i, reply.ToBytes())
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := "k" + strconv.Itoa(i)
+			c.Send(utils.ToCmdLine("SET", key, strconv.Itoa(i)))
+			if reply := c.Send(utils.ToCmdLine("GET", key)); string(reply.ToBytes()) != string(protocol.MakeBulkReply([]byte(strconv.Itoa(i))).ToBytes()) {
+				t.Errorf("GET %s: unexpected %q", key, reply.ToBytes())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 断线后重连，恢复 AUTH 和 SELECT
+	c.Send(utils.ToCmdLine("SELECT", "3"))
+	c.Send(utils.ToCmdLine("SET", "db3", "yes"))
+	c.mu.Lock()
+	_ = c.conn.Close()
+	c.mu.Unlock()
+	var reply []byte
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		reply = c.Send(utils.ToCmdLine("GET", "db3")).ToBytes()
+		if string(reply) == "$3\r\nyes\r\n" {
+			break
+		}
+	}
+	if string(reply) != "$3\r\nyes\r\n" {
+		t.Fatalf("expected to reconnect to db 3, got %q", reply)
+	}
+	if c.Closed() {
+		t.Fatal("client should stay open after reconnecting")
+	}
+
+	c.Close()
+	if reply := c.Send(utils.ToCmdLine("PING")); !protocol.IsErrorReply(reply) {
+		t.Errorf("expected error after close, got %q", reply.ToBytes())
+	}
+}
+
+func TestPool(t *testing.T) {
+	addr := startServer(t, &config.ServerProperties{})
+	pool := NewPool(addr, PoolOptions{MaxActive: 2, WaitTimeout: 50 * time.Millisecond})
+	defer pool.Close()
+
+	c1, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2, err := pool.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pool.Get(); err != ErrPoolExhausted {
+		t.Fatalf("expected pool exhausted, got %v", err)
+	}
+	pool.Put(c1)
+	c3, err := pool.Get()
+	if err != nil || c3 != c1 {
+		t.Fatalf("expected idle client to be reused, got %v", err)
+	}
+	// 关闭的连接不会放回池中
+	c2.Close()
+	pool.Put(c2)
+	pool.Put(c3)
+
+	replies := pool.Pipeline([][][]byte{utils.ToCmdLine("SET", "a", "1"), utils.ToCmdLine("GET", "a")})
+	if !protocol.IsOKReply(replies[0]) || string(replies[1].ToBytes()) != "$1\r\n1\r\n" {
+		t.Fatalf("unexpected replies %q %q", replies[0].ToBytes(), replies[1].ToBytes())
+	}
+	if len(pool.idle) != 1 {
+		t.Errorf("expected 1 idle client, got %d", len(pool.idle))
+	}
+	pool.Close()
+	if reply := pool.Send(utils.ToCmdLine("PING")); string(reply.ToBytes()) != "-ERR pool closed\r\n" {
+		t.Errorf("unexpected reply after close %q", reply.ToBytes())
+	}
+}
+
+func TestClientCompress(t *testing.T) {
+	addr := startServer(t, &config.ServerProperties{ReplyCompressThreshold: 64})
+	c, err := MakeClientWithOptions(addr, Options{Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer c.Close()
+
+	var value []byte
+	for i := 0; len(value) < 4096; i++ {
+		value = append(value, `{"id":`+strconv.Itoa(i)+`,"name":"alice","tags":["a","b"]},`...)
+	}
+	c.Send(utils.ToCmdLine("SET", "json", string(value)))
+	c.Send(utils.ToCmdLine("SET", "short", "v"))
+	if reply := c.Send(utils.ToCmdLine("GET", "json")); string(reply.ToBytes()) != string(protocol.MakeBulkReply(value).ToBytes()) {
+		t.Fatalf("GET: unexpected %q", reply.ToBytes())
+	}
+	expected := protocol.MakeMultiBulkReply([][]byte{value, []byte("v"), nil, value}).ToBytes()
+	if reply := c.Send(utils.ToCmdLine("MGET", "json", "short", "nokey", "json")); string(reply.ToBytes()) != string(expected) {
+		t.Fatalf("MGET: unexpected %q", reply.ToBytes())
+	}
+
+	// 线路上是压缩后的数据
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write(protocol.MakeMultiBulkReply(utils.ToCmdLine("HELLO", "2", "COMPRESS", "lz4")).ToBytes())
+	_, _ = conn.Write(protocol.MakeMultiBulkReply(utils.ToCmdLine("GET", "json")).ToBytes())
+	_ = conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 0, 8192)
+	for !strings.Contains(string(buf), "@"+strconv.Itoa(len(value))+":") {
+		n, err := conn.Read(buf[len(buf):cap(buf)])
+		if err != nil {
+			t.Fatalf("expected compressed reply, got %q: %v", buf, err)
+		}
+		buf = buf[:len(buf)+n]
+	}
+	if !strings.Contains(string(buf), "compress\r\n$3\r\nlz4") {
+		t.Errorf("expected HELLO to confirm compression, got %q", buf)
+	}
+}
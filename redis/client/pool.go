@@ -0,0 +1,154 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 连接池
+// Client 本身可以并发使用，连接池用于需要独占连接状态的场景(MULTI、阻塞命令、SELECT 到不同的数据库)，
+// 以及用多个连接分摊单个连接的读写。用完的连接放回池中复用，已经关闭的连接直接丢弃
+
+// PoolOptions configures a Pool
+type PoolOptions struct {
+	Options
+	// MaxIdle is the max number of idle clients kept in pool, defaults to 8
+	MaxIdle int
+	// MaxActive is the max number of clients in use and idle, 0 means no limit
+	MaxActive int
+	// WaitTimeout is the max time Get waits for a free client when MaxActive is reached, defaults to Timeout
+	WaitTimeout time.Duration
+}
+
+const defaultMaxIdle = 8
+
+// ErrPoolClosed is returned by Get after the pool is closed
+var ErrPoolClosed = errors.New("pool closed")
+
+// ErrPoolExhausted is returned by Get when no client is available before WaitTimeout
+var ErrPoolExhausted = errors.New("connection pool exhausted")
+
+// Pool is a pool of clients connecting to the same server
+type Pool struct {
+	addr string
+	opts PoolOptions
+	// 令牌数量为 MaxActive，nil 表示不限制
+	slots chan struct{}
+
+	mu     sync.Mutex
+	idle   []*Client
+	closed bool
+}
+
+// NewPool creates a pool, clients are created on demand
+func NewPool(addr string, opts PoolOptions) *Pool {
+	if opts.MaxIdle <= 0 {
+		opts.MaxIdle = defaultMaxIdle
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = maxWait
+	}
+	if opts.WaitTimeout <= 0 {
+		opts.WaitTimeout = opts.Timeout
+	}
+	pool := &Pool{
+		addr: addr,
+		opts: opts,
+	}
+	if opts.MaxActive > 0 {
+		pool.slots = make(chan struct{}, opts.MaxActive)
+	}
+	return pool
+}
+
+// Get returns an idle client or creates a new one, the client must be returned by Put
+func (pool *Pool) Get() (*Client, error) {
+	if pool.slots != nil {
+		timer := time.NewTimer(pool.opts.WaitTimeout)
+		defer timer.Stop()
+		select {
+		case pool.slots <- struct{}{}:
+		case <-timer.C:
+			return nil, ErrPoolExhausted
+		}
+	}
+	pool.mu.Lock()
+	if pool.closed {
+		pool.mu.Unlock()
+		pool.release()
+		return nil, ErrPoolClosed
+	}
+	for len(pool.idle) > 0 {
+		c := pool.idle[len(pool.idle)-1]
+		pool.idle = pool.idle[:len(pool.idle)-1]
+		if !c.Closed() {
+			pool.mu.Unlock()
+			return c, nil
+		}
+	}
+	pool.mu.Unlock()
+	c, err := MakeClientWithOptions(pool.addr, pool.opts.Options)
+	if err != nil {
+		pool.release()
+		return nil, err
+	}
+	c.Start()
+	return c, nil
+}
+
+// Put returns a client got from Get, closed clients are dropped.
+// Connection state such as SELECT should be restored before returning
+func (pool *Pool) Put(c *Client) {
+	defer pool.release()
+	if c.Closed() {
+		return
+	}
+	pool.mu.Lock()
+	if pool.closed || len(pool.idle) >= pool.opts.MaxIdle {
+		pool.mu.Unlock()
+		c.Close()
+		return
+	}
+	pool.idle = append(pool.idle, c)
+	pool.mu.Unlock()
+}
+
+func (pool *Pool) release() {
+	if pool.slots != nil {
+		<-pool.slots
+	}
+}
+
+// Send executes a command on a pooled client
+func (pool *Pool) Send(args [][]byte) redis.Reply {
+	return pool.Pipeline([][][]byte{args})[0]
+}
+
+// Pipeline executes commands on the same pooled client without waiting for replies in between
+func (pool *Pool) Pipeline(cmdLines [][][]byte) []redis.Reply {
+	c, err := pool.Get()
+	if err != nil {
+		result := make([]redis.Reply, len(cmdLines))
+		for i := range result {
+			result[i] = protocol.MakeErrReply("ERR " + err.Error())
+		}
+		return result
+	}
+	defer pool.Put(c)
+	return c.Pipeline(cmdLines)
+}
+
+// Close closes idle clients, clients in use are closed when they are returned
+func (pool *Pool) Close() {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.closed = true
+	for _, c := range pool.idle {
+		c.Close()
+	}
+	pool.idle = nil
+}
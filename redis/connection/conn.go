@@ -4,9 +4,13 @@ import (
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/lib/sync/lockorder"
 	"github.com/zhangming/go-redis/lib/sync/wait"
+	"github.com/zhangming/go-redis/redis/protocol"
 )
 
 const (
@@ -16,6 +20,12 @@ const (
 	flagMaster
 	// flagMulti means this connection is within a transaction
 	flagMulti
+	// flagAsking means the next command may access a slot being imported
+	flagAsking
+	// flagSyncDurability means replies of write commands wait for aof fsync
+	flagSyncDurability
+	// flagNoAuth means this connection has to AUTH before running other commands
+	flagNoAuth
 )
 
 // Connection represents a connection with a redis-cli
@@ -31,6 +41,8 @@ type Connection struct {
 
 	// subscribing channels
 	subs map[string]bool
+	// subscribing patterns
+	psubs map[string]bool
 
 	// password may be changed by CONFIG command during runtime, so store the password
 	password string
@@ -42,6 +54,54 @@ type Connection struct {
 
 	// selected db
 	selectedDB int
+	// db selected when MULTI was called
+	multiDB int
+
+	// RESP protocol version, 0 means RESP2
+	protocol int
+	// HELLO ... COMPRESS lz4 协商的压缩阈值，不短于它的 bulk string 压缩后发送，0 表示不压缩
+	compressThreshold int
+
+	// client name set by HELLO SETNAME or CLIENT SETNAME, protected by mu
+	clientName string
+	// 登录的用户，空字符串表示 default 用户，protected by mu
+	user string
+
+	// 连接编号和建立时间，用于 CLIENT LIST
+	id         uint64
+	createTime time.Time
+	// 最近执行的命令和时间(unix 纳秒)，CLIENT LIST 在其它协程读取
+	lastCmd    atomic.Pointer[string]
+	lastActive atomic.Int64
+	// 被 CLIENT KILL 关闭
+	killed atomic.Bool
+
+	// 输出缓冲，见 output.go
+	outMu       sync.Mutex
+	outBuf      []byte
+	outSpare    []byte
+	outSending  int
+	outFlushing bool
+	// Cork 之后写入的数据等到 Uncork 时再发送
+	outCorked bool
+	// 发送协程退出时 close
+	outIdle      chan struct{}
+	outErr       error
+	outSoftSince time.Time
+	outLimits    *config.OutputBufferLimits
+	// SetSlave 之后使用从节点的输出缓冲限制，其它协程写入时读取
+	replica atomic.Bool
+	// 订阅的频道和模式数，其它协程写入时读取
+	subsCount atomic.Int32
+}
+
+// 连接编号从 1 开始递增，不会重复使用
+var nextConnID atomic.Uint64
+
+func (c *Connection) init() {
+	c.id = nextConnID.Add(1)
+	c.createTime = time.Now()
+	c.lastActive.Store(c.createTime.UnixNano())
 }
 
 var connPool = sync.Pool{
@@ -57,16 +117,29 @@ func (c *Connection) RemoteAddr() string {
 
 // Close disconnect with the client
 func (c *Connection) Close() error {
+	c.Uncork()
 	c.sendingData.WaitWithTimeout(10 * time.Second)
 	if c.conn != nil { // may be a fake conn for tests
 		_ = c.conn.Close()
 	}
 	c.subs = nil
+	c.psubs = nil
 	c.password = ""
 	c.queue = nil
 	c.watching = nil
 	c.txErrors = nil
 	c.selectedDB = 0
+	c.multiDB = 0
+	c.protocol = 0
+	c.compressThreshold = 0
+	c.clientName = ""
+	c.user = ""
+	c.lastCmd.Store(nil)
+	c.killed.Store(false)
+	c.flags = 0
+	c.replica.Store(false)
+	c.subsCount.Store(0)
+	c.resetOutput()
 	connPool.Put(c)
 	return nil
 }
@@ -76,26 +149,40 @@ func NewConn(conn net.Conn) *Connection {
 	c, ok := connPool.Get().(*Connection)
 	if !ok {
 		slog.Error("connection pool make wrong type")
-		return &Connection{
+		c = &Connection{
 			conn: conn,
 		}
+		c.init()
+		return c
 	}
 	c.conn = conn
+	c.init()
 	return c
 }
 
 
-// Write sends response to client over tcp connection
+// Write appends b to the output buffer, the data is sent by a background goroutine,
+// fails after a previous write failed or the client output buffer limit is reached
 func (c *Connection) Write(b []byte) (int, error) {
 	if len(b) == 0 {
 		return 0, nil
 	}
-	c.sendingData.Add(1)
-	defer func() {
-		c.sendingData.Done()
-	}()
-
-	return c.conn.Write(b)
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	if c.outErr != nil {
+		return 0, c.outErr
+	}
+	c.outBuf = append(c.outBuf, b...)
+	if c.outputLimitReached() {
+		slog.Warn("closing client that reached output buffer limit", "addr", c.Name(), "pending", len(c.outBuf)+c.outSending)
+		c.outErr = errOutputLimit
+		c.disconnectSlow()
+		return 0, errOutputLimit
+	}
+	if !c.outCorked {
+		c.startFlushLocked()
+	}
+	return len(b), nil
 }
 
 func (c *Connection) Name() string {
@@ -105,35 +192,51 @@ func (c *Connection) Name() string {
 	return ""
 }
 
+var connLockClass = lockorder.NewClass("connection")
+
+func (c *Connection) lock() {
+	connLockClass.Acquire(0)
+	c.mu.Lock()
+}
+
+func (c *Connection) unlock() {
+	c.mu.Unlock()
+	connLockClass.Release(0)
+}
+
 // Subscribe add current connection into subscribers of the given channel
 func (c *Connection) Subscribe(channel string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.lock()
+	defer c.unlock()
 
 	if c.subs == nil {
 		c.subs = make(map[string]bool)
 	}
 	c.subs[channel] = true
+	c.subsCount.Store(int32(len(c.subs) + len(c.psubs)))
 }
 
 // UnSubscribe removes current connection into subscribers of the given channel
 func (c *Connection) UnSubscribe(channel string) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.lock()
+	defer c.unlock()
 
 	if len(c.subs) == 0 {
 		return
 	}
 	delete(c.subs, channel)
+	c.subsCount.Store(int32(len(c.subs) + len(c.psubs)))
 }
 
-// SubsCount returns the number of subscribing channels
+// SubsCount returns the number of subscribing channels and patterns
 func (c *Connection) SubsCount() int {
-	return len(c.subs)
+	return int(c.subsCount.Load())
 }
 
 // GetChannels returns all subscribing channels
 func (c *Connection) GetChannels() []string {
+	c.lock()
+	defer c.unlock()
 	if c.subs == nil {
 		return make([]string, 0)
 	}
@@ -146,6 +249,38 @@ func (c *Connection) GetChannels() []string {
 	return channels
 }
 
+// PSubscribe add current connection into subscribers of the given pattern
+func (c *Connection) PSubscribe(pattern string) {
+	c.lock()
+	defer c.unlock()
+
+	if c.psubs == nil {
+		c.psubs = make(map[string]bool)
+	}
+	c.psubs[pattern] = true
+	c.subsCount.Store(int32(len(c.subs) + len(c.psubs)))
+}
+
+// PUnSubscribe removes current connection from subscribers of the given pattern
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.lock()
+	defer c.unlock()
+
+	delete(c.psubs, pattern)
+	c.subsCount.Store(int32(len(c.subs) + len(c.psubs)))
+}
+
+// GetPatterns returns all subscribing patterns
+func (c *Connection) GetPatterns() []string {
+	c.lock()
+	defer c.unlock()
+	patterns := make([]string, 0, len(c.psubs))
+	for pattern := range c.psubs {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
 // SetPassword stores password for authentication
 func (c *Connection) SetPassword(password string) {
 	c.password = password
@@ -156,6 +291,20 @@ func (c *Connection) GetPassword() string {
 	return c.password
 }
 
+// SetUser sets the user authenticated by AUTH or HELLO
+func (c *Connection) SetUser(user string) {
+	c.lock()
+	defer c.unlock()
+	c.user = user
+}
+
+// GetUser returns the authenticated user, empty means the default user
+func (c *Connection) GetUser() string {
+	c.lock()
+	defer c.unlock()
+	return c.user
+}
+
 // InMultiState tells is connection in an uncommitted transaction
 func (c *Connection) InMultiState() bool {
 	return c.flags&flagMulti > 0
@@ -166,12 +315,19 @@ func (c *Connection) SetMultiState(state bool) {
 	if !state { // reset data when cancel multi
 		c.watching = nil
 		c.queue = nil
+		c.txErrors = nil
 		c.flags &= ^flagMulti // clean multi flag
 		return
 	}
+	c.multiDB = c.selectedDB
 	c.flags |= flagMulti
 }
 
+// GetMultiDBIndex returns the db selected when MULTI was called
+func (c *Connection) GetMultiDBIndex() int {
+	return c.multiDB
+}
+
 // GetQueuedCmdLine returns queued commands of current transaction
 func (c *Connection) GetQueuedCmdLine() [][][]byte {
 	return c.queue
@@ -217,6 +373,7 @@ func (c *Connection) SelectDB(dbNum int) {
 
 func (c *Connection) SetSlave() {
 	c.flags |= flagSlave
+	c.replica.Store(true)
 }
 
 func (c *Connection) IsSlave() bool {
@@ -231,3 +388,122 @@ func (c *Connection) SetMaster() {
 func (c *Connection) IsMaster() bool {
 	return c.flags&flagMaster > 0
 }
+
+// SetAsking marks the next command as allowed to access an importing slot
+func (c *Connection) SetAsking(asking bool) {
+	if asking {
+		c.flags |= flagAsking
+		return
+	}
+	c.flags &= ^flagAsking
+}
+
+// IsAsking tells whether ASKING was sent before current command
+func (c *Connection) IsAsking() bool {
+	return c.flags&flagAsking > 0
+}
+
+// SetSyncDurability sets whether write commands are acknowledged only after aof fsync
+func (c *Connection) SetSyncDurability(sync bool) {
+	if sync {
+		c.flags |= flagSyncDurability
+		return
+	}
+	c.flags &= ^flagSyncDurability
+}
+
+// IsSyncDurability tells whether CLIENT DURABILITY SYNC is enabled
+func (c *Connection) IsSyncDurability() bool {
+	return c.flags&flagSyncDurability > 0
+}
+
+// SetAuthenticated marks whether the connection has logged in
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	if authenticated {
+		c.flags &= ^flagNoAuth
+		return
+	}
+	c.flags |= flagNoAuth
+}
+
+// IsAuthenticated tells whether the connection may run commands other than AUTH and HELLO
+func (c *Connection) IsAuthenticated() bool {
+	return c.flags&flagNoAuth == 0
+}
+
+// SetProtocol sets the RESP protocol version negotiated by HELLO
+func (c *Connection) SetProtocol(version int) {
+	c.protocol = version
+}
+
+// GetProtocol returns the RESP protocol version, RESP2 by default
+func (c *Connection) GetProtocol() int {
+	if c.protocol == 0 {
+		return protocol.RESP2
+	}
+	return c.protocol
+}
+
+// SetReplyCompression sets the min size of bulk strings compressed in replies, 0 disables compression
+func (c *Connection) SetReplyCompression(threshold int) {
+	c.compressThreshold = threshold
+}
+
+// GetReplyCompression returns the threshold negotiated by HELLO ... COMPRESS lz4, 0 means disabled
+func (c *Connection) GetReplyCompression() int {
+	return c.compressThreshold
+}
+
+// SetClientName sets the client name
+func (c *Connection) SetClientName(name string) {
+	c.lock()
+	defer c.unlock()
+	c.clientName = name
+}
+
+// GetClientName returns the client name, empty if not set
+func (c *Connection) GetClientName() string {
+	c.lock()
+	defer c.unlock()
+	return c.clientName
+}
+
+// GetID returns the unique id of connection
+func (c *Connection) GetID() uint64 {
+	return c.id
+}
+
+// GetCreateTime returns when the connection was accepted
+func (c *Connection) GetCreateTime() time.Time {
+	return c.createTime
+}
+
+// SetLastCmd records the command being executed and the time
+func (c *Connection) SetLastCmd(cmd string) {
+	c.lastCmd.Store(&cmd)
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// GetLastCmd returns the last executed command and when it was executed,
+// command is empty and time is the creation time if no command was executed
+func (c *Connection) GetLastCmd() (string, time.Time) {
+	var cmd string
+	if p := c.lastCmd.Load(); p != nil {
+		cmd = *p
+	}
+	return cmd, time.Unix(0, c.lastActive.Load())
+}
+
+// Kill 停止读取连接，处理协程读到错误后释放连接。
+// 只设置读超时而不直接关闭，正在执行的命令(例如 CLIENT KILL 自己)仍然可以写回复
+func (c *Connection) Kill() {
+	c.killed.Store(true)
+	if c.conn != nil {
+		_ = c.conn.SetReadDeadline(time.Now())
+	}
+}
+
+// IsKilled tells whether the connection was killed by CLIENT KILL
+func (c *Connection) IsKilled() bool {
+	return c.killed.Load()
+}
@@ -0,0 +1,64 @@
+package connection
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+)
+
+func TestOutputBuffer(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewConn(server)
+	for _, s := range []string{"+a\r\n", "+b\r\n", "+c\r\n"} {
+		if _, err := c.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 回复按写入的顺序到达
+	buf := make([]byte, 12)
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "+a\r\n+b\r\n+c\r\n" {
+		t.Errorf("unexpected output %q", buf)
+	}
+	if err := c.Flush(); err != nil || c.GetOutputBufferSize() != 0 {
+		t.Errorf("expected empty buffer after flush, got %d, %v", c.GetOutputBufferSize(), err)
+	}
+	_ = c.Close()
+}
+
+func TestOutputBufferLimit(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := NewConn(server)
+	c.SetOutputBufferLimits(&config.OutputBufferLimits{PubSub: config.OutputBufferLimit{Hard: 1024}})
+	c.Subscribe("news")
+	// 客户端不读取，发送协程阻塞在第一次写入
+	msg := bytes.Repeat([]byte("x"), 256)
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		_, err = c.Write(msg)
+	}
+	if err != errOutputLimit || !c.IsKilled() {
+		t.Fatalf("expected slow subscriber to be disconnected, got %v", err)
+	}
+	if _, err := c.Write(msg); err != errOutputLimit {
+		t.Errorf("expected later writes to fail, got %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		_ = c.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("blocked write was not interrupted")
+	}
+}
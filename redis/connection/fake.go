@@ -19,6 +19,7 @@ type FakeConn struct {
 
 func NewFakeConn() *FakeConn {
 	c := &FakeConn{}
+	c.init()
 	return c
 }
 
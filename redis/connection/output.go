@@ -0,0 +1,178 @@
+package connection
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+)
+
+// 输出缓冲
+// Write 只把数据追加到 outBuf，由一个发送协程写入连接；发送期间的写入累积起来在下一次一起发送，
+// 流水线中的多个回复合并成一次系统调用，缓冲清空后发送协程退出，下一次写入时再启动。
+// 发布订阅的消息、MONITOR 以及转发给从节点的命令由其它协程写入，客户端读得慢时也不会阻塞写入的协程，
+// 未发送的数据超过 client-output-buffer-limit 时断开连接
+
+var errOutputLimit = errors.New("client output buffer limit reached")
+
+// 发送之后保留的缓冲不超过该大小，避免一次很大的回复之后一直占用内存
+const maxSpareOutput = 64 << 10
+
+// 连接的类型，决定使用哪一个输出缓冲限制
+const (
+	outputClassNormal int32 = iota
+	outputClassReplica
+	outputClassPubSub
+)
+
+// SetOutputBufferLimits sets limits of pending output, nil means no limit
+func (c *Connection) SetOutputBufferLimits(limits *config.OutputBufferLimits) {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outLimits = limits
+}
+
+// GetOutputBufferSize returns bytes written but not sent yet
+func (c *Connection) GetOutputBufferSize() int {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	return len(c.outBuf) + c.outSending
+}
+
+// Cork holds written data in the buffer until Uncork, replies of pipelined commands are sent together
+func (c *Connection) Cork() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outCorked = true
+}
+
+// Uncork starts sending the data buffered since Cork
+func (c *Connection) Uncork() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outCorked = false
+	c.startFlushLocked()
+}
+
+// Flush sends buffered output, including the data held by Cork, and waits until it is sent,
+// returns the write error if any
+func (c *Connection) Flush() error {
+	c.outMu.Lock()
+	c.startFlushLocked()
+	if !c.outFlushing {
+		err := c.outErr
+		c.outMu.Unlock()
+		return err
+	}
+	idle := c.outIdle
+	c.outMu.Unlock()
+	<-idle
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	return c.outErr
+}
+
+func (c *Connection) outputClass() int32 {
+	// 其它协程写入时连接的处理协程可能在修改 flags，这里只读原子变量
+	if c.replica.Load() {
+		return outputClassReplica
+	}
+	if c.SubsCount() > 0 {
+		return outputClassPubSub
+	}
+	return outputClassNormal
+}
+
+// outputLimitReached 调用方持有 outMu
+func (c *Connection) outputLimitReached() bool {
+	if c.outLimits == nil {
+		return false
+	}
+	var limit config.OutputBufferLimit
+	switch c.outputClass() {
+	case outputClassReplica:
+		limit = c.outLimits.Replica
+	case outputClassPubSub:
+		limit = c.outLimits.PubSub
+	default:
+		limit = c.outLimits.Normal
+	}
+	pending := int64(len(c.outBuf) + c.outSending)
+	if limit.Hard > 0 && pending > limit.Hard {
+		return true
+	}
+	if limit.Soft == 0 || pending <= limit.Soft {
+		c.outSoftSince = time.Time{}
+		return false
+	}
+	if c.outSoftSince.IsZero() {
+		c.outSoftSince = time.Now()
+	}
+	return time.Since(c.outSoftSince) >= limit.SoftDuration
+}
+
+// disconnectSlow 停止读取并让阻塞的发送立即失败，处理协程随后关闭连接
+func (c *Connection) disconnectSlow() {
+	c.Kill()
+	if c.conn != nil {
+		_ = c.conn.SetWriteDeadline(time.Now())
+	}
+}
+
+// startFlushLocked 有数据并且没有发送协程时启动一个，调用方持有 outMu
+func (c *Connection) startFlushLocked() {
+	if c.outFlushing || len(c.outBuf) == 0 || c.outErr != nil {
+		return
+	}
+	c.outFlushing = true
+	c.outIdle = make(chan struct{})
+	c.sendingData.Add(1)
+	go c.flushOutput(c.outIdle)
+}
+
+// flushOutput 发送协程，每次取出 outBuf 中的全部数据写入连接，直到缓冲为空或者写入出错
+func (c *Connection) flushOutput(idle chan struct{}) {
+	defer c.sendingData.Done()
+	for {
+		c.outMu.Lock()
+		if len(c.outBuf) == 0 || c.outErr != nil {
+			c.outFlushing = false
+			c.outSoftSince = time.Time{}
+			c.outMu.Unlock()
+			close(idle)
+			return
+		}
+		data := c.outBuf
+		// 两个缓冲交替使用，发送中的数据不会被新的写入覆盖
+		c.outBuf, c.outSpare = c.outSpare[:0], nil
+		c.outSending = len(data)
+		c.outMu.Unlock()
+
+		_, err := c.conn.Write(data)
+
+		c.outMu.Lock()
+		c.outSending = 0
+		if cap(data) <= maxSpareOutput {
+			c.outSpare = data[:0]
+		}
+		if err != nil && c.outErr == nil {
+			c.outErr = err
+		}
+		c.outMu.Unlock()
+	}
+}
+
+// resetOutput 连接放回对象池之前清空输出缓冲的状态
+func (c *Connection) resetOutput() {
+	c.outMu.Lock()
+	defer c.outMu.Unlock()
+	c.outBuf = nil
+	c.outSpare = nil
+	c.outSending = 0
+	c.outFlushing = false
+	c.outCorked = false
+	c.outIdle = nil
+	c.outErr = nil
+	c.outSoftSince = time.Time{}
+	c.outLimits = nil
+}
@@ -0,0 +1,111 @@
+package protocol
+
+import (
+	"strconv"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/lz4"
+)
+
+// 回复压缩
+// 连接用 HELLO <protover> COMPRESS lz4 协商之后，不短于阈值的 bulk string 用 LZ4 块格式压缩，
+// 编码为 @<原始长度>:<压缩后长度>\r\n<压缩数据>\r\n，可以出现在 bulk string 可以出现的任何位置(包括数组元素)。
+// 压缩后没有变小的值仍然按普通 bulk string 发送，没有协商的连接不会收到这种类型
+
+// CompressedBulkPrefix is the type byte of compressed bulk strings
+const CompressedBulkPrefix = '@'
+
+// CompressedReply serializes Reply with bulk strings not shorter than Threshold compressed
+type CompressedReply struct {
+	Reply     redis.Reply
+	Threshold int
+}
+
+// MakeCompressedReply creates CompressedReply
+func MakeCompressedReply(reply redis.Reply, threshold int) *CompressedReply {
+	return &CompressedReply{
+		Reply:     reply,
+		Threshold: threshold,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *CompressedReply) ToBytes() []byte {
+	return r.AppendTo(nil)
+}
+
+// AppendTo appends the serialized reply to buf
+func (r *CompressedReply) AppendTo(buf []byte) []byte {
+	return appendCompressed(buf, r.Reply, r.Threshold)
+}
+
+// appendCompressed 与 AppendReply 相同，但是会压缩其中的 bulk string
+func appendCompressed(buf []byte, reply redis.Reply, threshold int) []byte {
+	switch r := reply.(type) {
+	case *BulkReply:
+		return appendCompressedBulk(buf, r.Arg, threshold)
+	case *MultiBulkReply:
+		buf = appendHeader(buf, '*', len(r.Args))
+		for _, arg := range r.Args {
+			buf = appendCompressedBulk(buf, arg, threshold)
+		}
+		return buf
+	case *MultiRawReply:
+		buf = appendHeader(buf, '*', len(r.Replies))
+		for _, reply := range r.Replies {
+			buf = appendCompressed(buf, reply, threshold)
+		}
+		return buf
+	case *MapReply:
+		buf = appendHeader(buf, '%', len(r.Args)/2)
+		for _, arg := range r.Args {
+			buf = appendCompressed(buf, arg, threshold)
+		}
+		return buf
+	case *PairsReply:
+		buf = appendHeader(buf, '*', len(r.Args)/2)
+		for i := 0; i+1 < len(r.Args); i += 2 {
+			buf = appendHeader(buf, '*', 2)
+			buf = appendCompressed(buf, r.Args[i], threshold)
+			buf = appendCompressed(buf, r.Args[i+1], threshold)
+		}
+		return buf
+	case *PushReply:
+		buf = appendHeader(buf, '>', len(r.Replies))
+		for _, reply := range r.Replies {
+			buf = appendCompressed(buf, reply, threshold)
+		}
+		return buf
+	}
+	return AppendReply(buf, reply)
+}
+
+// compressedHeaderMax 头部的最大长度: '@' + 两个 int64 + ':' + CRLF
+const compressedHeaderMax = 1 + 20 + 1 + 20 + 2
+
+func appendCompressedBulk(buf []byte, arg []byte, threshold int) []byte {
+	if arg == nil || len(arg) < threshold {
+		return appendBulk(buf, arg)
+	}
+	// 压缩之前不知道头部的长度，先预留最大长度，压缩之后把数据移到头部后面
+	start := len(buf)
+	for i := 0; i < compressedHeaderMax; i++ {
+		buf = append(buf, 0)
+	}
+	body := len(buf)
+	buf = lz4.Compress(buf, arg)
+	compressedLen := len(buf) - body
+	if compressedLen >= len(arg) {
+		return appendBulk(buf[:start], arg)
+	}
+	var header [compressedHeaderMax]byte
+	h := append(header[:0], CompressedBulkPrefix)
+	h = strconv.AppendInt(h, int64(len(arg)), 10)
+	h = append(h, ':')
+	h = strconv.AppendInt(h, int64(compressedLen), 10)
+	h = append(h, CRLF...)
+	n := copy(buf[start:], h)
+	copy(buf[start+n:], buf[body:])
+	buf = buf[:start+n+compressedLen]
+	return append(buf, CRLF...)
+}
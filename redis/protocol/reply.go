@@ -1,11 +1,11 @@
 package protocol
 
 import (
-	"bytes"
 	"errors"
 	"strconv"
 
 	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
 )
 
 var (
@@ -33,7 +33,54 @@ func (r *BulkReply) ToBytes() []byte {
 	if r.Arg == nil {
 		return nil
 	}
-	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
+	return r.AppendTo(make([]byte, 0, bulkLen(r.Arg)))
+}
+
+// AppendTo appends the serialized reply to buf
+func (r *BulkReply) AppendTo(buf []byte) []byte {
+	if r.Arg == nil {
+		return buf
+	}
+	return appendBulk(buf, r.Arg)
+}
+
+// bulkLen 计算 $len\r\narg\r\n 的长度
+func bulkLen(arg []byte) int {
+	if arg == nil {
+		return 5
+	}
+	return 1 + intLen(int64(len(arg))) + 2 + len(arg) + 2
+}
+
+func intLen(n int64) int {
+	l := 1
+	if n < 0 {
+		l++
+		n = -n
+	}
+	for n >= 10 {
+		n /= 10
+		l++
+	}
+	return l
+}
+
+func appendBulk(buf []byte, arg []byte) []byte {
+	if arg == nil {
+		return append(buf, nullBulkBytes...)
+	}
+	buf = append(buf, '$')
+	buf = strconv.AppendInt(buf, int64(len(arg)), 10)
+	buf = append(buf, CRLF...)
+	buf = append(buf, arg...)
+	return append(buf, CRLF...)
+}
+
+// appendHeader 写入数组、map 等类型的 <prefix><n>\r\n
+func appendHeader(buf []byte, prefix byte, n int) []byte {
+	buf = append(buf, prefix)
+	buf = strconv.AppendInt(buf, int64(n), 10)
+	return append(buf, CRLF...)
 }
 
 /* ---- Multi Bulk Reply ---- */
@@ -52,37 +99,21 @@ func MakeMultiBulkReply(args [][]byte) *MultiBulkReply {
 
 // ToBytes marshal redis.Reply
 func (r *MultiBulkReply) ToBytes() []byte {
-	var buf bytes.Buffer
-	//Calculate the length of buffer
-	argLen := len(r.Args)
-	bufLen := 1 + len(strconv.Itoa(argLen)) + 2
+	//Calculate the length of buffer, allocate memory only once
+	bufLen := 1 + intLen(int64(len(r.Args))) + 2
 	for _, arg := range r.Args {
-		if arg == nil {
-			bufLen += 3 + 2
-		} else {
-			bufLen += 1 + len(strconv.Itoa(len(arg))) + 2 + len(arg) + 2
-		}
-	}
-	//Allocate memory
-	buf.Grow(bufLen)
-	//Write string step by step,avoid concat strings
-	buf.WriteString("*")
-	buf.WriteString(strconv.Itoa(argLen))
-	buf.WriteString(CRLF)
+		bufLen += bulkLen(arg)
+	}
+	return r.AppendTo(make([]byte, 0, bufLen))
+}
+
+// AppendTo appends the serialized reply to buf
+func (r *MultiBulkReply) AppendTo(buf []byte) []byte {
+	buf = appendHeader(buf, '*', len(r.Args))
 	for _, arg := range r.Args {
-		if arg == nil {
-			buf.WriteString("$-1")
-			buf.WriteString(CRLF)
-		} else {
-			buf.WriteString("$")
-			buf.WriteString(strconv.Itoa(len(arg)))
-			buf.WriteString(CRLF)
-			//Write bytes,avoid slice of byte to string(slicebytetostring)
-			buf.Write(arg)
-			buf.WriteString(CRLF)
-		}
+		buf = appendBulk(buf, arg)
 	}
-	return buf.Bytes()
+	return buf
 }
 
 /* ---- Multi Raw Reply ---- */
@@ -101,13 +132,16 @@ func MakeMultiRawReply(replies []redis.Reply) *MultiRawReply {
 
 // ToBytes marshal redis.Reply
 func (r *MultiRawReply) ToBytes() []byte {
-	argLen := len(r.Replies)
-	var buf bytes.Buffer
-	buf.WriteString("*" + strconv.Itoa(argLen) + CRLF)
+	return r.AppendTo(nil)
+}
+
+// AppendTo appends the serialized reply to buf, nested replies are serialized in place
+func (r *MultiRawReply) AppendTo(buf []byte) []byte {
+	buf = appendHeader(buf, '*', len(r.Replies))
 	for _, arg := range r.Replies {
-		buf.Write(arg.ToBytes())
+		buf = AppendReply(buf, arg)
 	}
-	return buf.Bytes()
+	return buf
 }
 
 /* ---- Status Reply ---- */
@@ -129,6 +163,13 @@ func (r *StatusReply) ToBytes() []byte {
 	return []byte("+" + r.Status + CRLF)
 }
 
+// AppendTo appends the serialized reply to buf
+func (r *StatusReply) AppendTo(buf []byte) []byte {
+	buf = append(buf, '+')
+	buf = append(buf, r.Status...)
+	return append(buf, CRLF...)
+}
+
 // IsOKReply returns true if the given protocol is +OK
 func IsOKReply(reply redis.Reply) bool {
 	return string(reply.ToBytes()) == "+OK\r\n"
@@ -141,8 +182,25 @@ type IntReply struct {
 	Code int64
 }
 
-// MakeIntReply creates int protocol
+// 与 OkReply 相同，小整数的回复和编码结果预先生成，不能被修改
+var (
+	sharedIntReplies [utils.SharedIntegers]IntReply
+	sharedIntBytes   [utils.SharedIntegers][]byte
+)
+
+func init() {
+	for i := range sharedIntReplies {
+		sharedIntReplies[i].Code = int64(i)
+		b := []byte(":" + string(utils.FormatInt(int64(i))) + CRLF)
+		sharedIntBytes[i] = b[:len(b):len(b)]
+	}
+}
+
+// MakeIntReply creates int protocol, small integers share the same reply
 func MakeIntReply(code int64) *IntReply {
+	if code >= 0 && code < utils.SharedIntegers {
+		return &sharedIntReplies[code]
+	}
 	return &IntReply{
 		Code: code,
 	}
@@ -150,9 +208,22 @@ func MakeIntReply(code int64) *IntReply {
 
 // ToBytes marshal redis.Reply
 func (r *IntReply) ToBytes() []byte {
+	if r.Code >= 0 && r.Code < utils.SharedIntegers {
+		return sharedIntBytes[r.Code]
+	}
 	return []byte(":" + strconv.FormatInt(r.Code, 10) + CRLF)
 }
 
+// AppendTo appends the serialized reply to buf
+func (r *IntReply) AppendTo(buf []byte) []byte {
+	if r.Code >= 0 && r.Code < utils.SharedIntegers {
+		return append(buf, sharedIntBytes[r.Code]...)
+	}
+	buf = append(buf, ':')
+	buf = strconv.AppendInt(buf, r.Code, 10)
+	return append(buf, CRLF...)
+}
+
 /* ---- Error Reply ---- */
 
 // ErrorReply is an error and redis.Reply
@@ -194,6 +265,13 @@ func (r *StandardErrReply) ToBytes() []byte {
 	return []byte("-" + r.Status + CRLF)
 }
 
+// AppendTo appends the serialized reply to buf
+func (r *StandardErrReply) AppendTo(buf []byte) []byte {
+	buf = append(buf, '-')
+	buf = append(buf, r.Status...)
+	return append(buf, CRLF...)
+}
+
 func (r *StandardErrReply) Error() string {
 	return r.Status
 }
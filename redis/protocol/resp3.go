@@ -0,0 +1,228 @@
+package protocol
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+)
+
+// RESP3 新增的类型
+// 命令层直接构造这些类型，连接使用 RESP2 时通过 ToRESP2 转换成等价的 RESP2 回复
+
+const (
+	// RESP2 is the default protocol version of connections
+	RESP2 = 2
+	// RESP3 is negotiated by HELLO 3
+	RESP3 = 3
+)
+
+/* ---- Double Reply ---- */
+
+// DoubleReply stores a float64, RESP2 encodes it as bulk string
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply creates DoubleReply
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+// FormatDouble 与 redis 保持一致，无穷大写作 inf/-inf
+func FormatDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "inf"
+	case math.IsInf(value, -1):
+		return "-inf"
+	case math.IsNaN(value):
+		return "nan"
+	}
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
+
+// ToBytes marshal redis.Reply
+func (r *DoubleReply) ToBytes() []byte {
+	return []byte("," + FormatDouble(r.Value) + CRLF)
+}
+
+/* ---- Big Number Reply ---- */
+
+// BigNumberReply stores an integer out of the range of int64
+type BigNumberReply struct {
+	Value string
+}
+
+// MakeBigNumberReply creates BigNumberReply
+func MakeBigNumberReply(value string) *BigNumberReply {
+	return &BigNumberReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *BigNumberReply) ToBytes() []byte {
+	return []byte("(" + r.Value + CRLF)
+}
+
+/* ---- Map Reply ---- */
+
+// MapReply stores key value pairs, Args is key1, value1, key2, value2 ...
+// RESP2 encodes it as a flat array
+type MapReply struct {
+	Args []redis.Reply
+}
+
+// MakeMapReply creates MapReply
+func MakeMapReply(args []redis.Reply) *MapReply {
+	return &MapReply{
+		Args: args,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MapReply) ToBytes() []byte {
+	return r.AppendTo(nil)
+}
+
+// AppendTo appends the serialized reply to buf
+func (r *MapReply) AppendTo(buf []byte) []byte {
+	buf = appendHeader(buf, '%', len(r.Args)/2)
+	for _, arg := range r.Args {
+		buf = AppendReply(buf, arg)
+	}
+	return buf
+}
+
+/* ---- Pairs Reply ---- */
+
+// PairsReply stores key value pairs which may contain duplicated keys
+// RESP3 encodes it as an array of 2-element arrays, RESP2 encodes it as a flat array
+type PairsReply struct {
+	Args []redis.Reply
+}
+
+// MakePairsReply creates PairsReply
+func MakePairsReply(args []redis.Reply) *PairsReply {
+	return &PairsReply{
+		Args: args,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *PairsReply) ToBytes() []byte {
+	return r.AppendTo(nil)
+}
+
+// AppendTo appends the serialized reply to buf
+func (r *PairsReply) AppendTo(buf []byte) []byte {
+	buf = appendHeader(buf, '*', len(r.Args)/2)
+	for i := 0; i+1 < len(r.Args); i += 2 {
+		buf = appendHeader(buf, '*', 2)
+		buf = AppendReply(buf, r.Args[i])
+		buf = AppendReply(buf, r.Args[i+1])
+	}
+	return buf
+}
+
+/* ---- Boolean Reply ---- */
+
+// BooleanReply stores a boolean, RESP2 encodes it as integer 1 or 0
+type BooleanReply struct {
+	Value bool
+}
+
+// MakeBooleanReply creates BooleanReply
+func MakeBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return []byte("#t" + CRLF)
+	}
+	return []byte("#f" + CRLF)
+}
+
+/* ---- Push Reply ---- */
+
+// PushReply is an out-of-band message such as pub/sub messages, RESP2 encodes it as an array
+type PushReply struct {
+	Replies []redis.Reply
+}
+
+// MakePushReply creates PushReply
+func MakePushReply(replies []redis.Reply) *PushReply {
+	return &PushReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *PushReply) ToBytes() []byte {
+	return r.AppendTo(nil)
+}
+
+// AppendTo appends the serialized reply to buf
+func (r *PushReply) AppendTo(buf []byte) []byte {
+	buf = appendHeader(buf, '>', len(r.Replies))
+	for _, reply := range r.Replies {
+		buf = AppendReply(buf, reply)
+	}
+	return buf
+}
+
+// ToRESP2 把 RESP3 类型转换成 RESP2 中的等价回复，会递归处理 MultiRawReply
+func ToRESP2(reply redis.Reply) redis.Reply {
+	switch r := reply.(type) {
+	case *DoubleReply:
+		return MakeBulkReply([]byte(FormatDouble(r.Value)))
+	case *BigNumberReply:
+		return MakeBulkReply([]byte(r.Value))
+	case *MapReply:
+		return MakeMultiRawReply(toRESP2Replies(r.Args))
+	case *PairsReply:
+		return MakeMultiRawReply(toRESP2Replies(r.Args))
+	case *BooleanReply:
+		if r.Value {
+			return MakeIntReply(1)
+		}
+		return MakeIntReply(0)
+	case *PushReply:
+		return MakeMultiRawReply(toRESP2Replies(r.Replies))
+	case *MultiRawReply:
+		// 大多数数组里没有 RESP3 类型，直接返回原回复
+		if containsRESP3(r.Replies) {
+			return MakeMultiRawReply(toRESP2Replies(r.Replies))
+		}
+	}
+	return reply
+}
+
+func toRESP2Replies(replies []redis.Reply) []redis.Reply {
+	result := make([]redis.Reply, len(replies))
+	for i, reply := range replies {
+		result[i] = ToRESP2(reply)
+	}
+	return result
+}
+
+func containsRESP3(replies []redis.Reply) bool {
+	for _, reply := range replies {
+		switch r := reply.(type) {
+		case *DoubleReply, *BigNumberReply, *MapReply, *PairsReply, *BooleanReply, *PushReply:
+			return true
+		case *MultiRawReply:
+			if containsRESP3(r.Replies) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"io"
+	"sync"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+)
+
+// 回复直接序列化到复用的缓冲区再写入连接
+// 实现了 ReplyAppender 的回复(包括嵌套在数组中的)不再通过 ToBytes 分配中间切片，
+// 其它回复退回到 ToBytes
+
+// ReplyAppender is implemented by replies which can serialize into a caller provided buffer
+type ReplyAppender interface {
+	AppendTo(buf []byte) []byte
+}
+
+// AppendReply appends the serialized reply to buf
+func AppendReply(buf []byte, reply redis.Reply) []byte {
+	if appender, ok := reply.(ReplyAppender); ok {
+		return appender.AppendTo(buf)
+	}
+	return append(buf, reply.ToBytes()...)
+}
+
+const (
+	initialWriteBufSize = 512
+	// 超过这个大小的缓冲区用完之后直接丢弃，避免大回复长期占用内存
+	maxPooledWriteBufSize = 64 << 10
+)
+
+var writeBufPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, 0, initialWriteBufSize)
+		return &buf
+	},
+}
+
+// WriteReply serializes reply into a pooled buffer and writes it to w with a single Write call,
+// w must not retain the slice after Write returns
+func WriteReply(w io.Writer, reply redis.Reply) (int, error) {
+	bufPtr := writeBufPool.Get().(*[]byte)
+	buf := AppendReply((*bufPtr)[:0], reply)
+	var n int
+	var err error
+	if len(buf) > 0 {
+		n, err = w.Write(buf)
+	}
+	if cap(buf) <= maxPooledWriteBufSize {
+		*bufPtr = buf
+		writeBufPool.Put(bufPtr)
+	}
+	return n, err
+}
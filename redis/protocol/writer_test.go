@@ -0,0 +1,77 @@
+package protocol
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+)
+
+func TestAppendReply(t *testing.T) {
+	cases := []struct {
+		reply    redis.Reply
+		expected string
+	}{
+		{MakeBulkReply([]byte("value")), "$5\r\nvalue\r\n"},
+		{MakeBulkReply([]byte{}), "$0\r\n\r\n"},
+		{MakeNullBulkReply(), "$-1\r\n"},
+		{MakeMultiBulkReply([][]byte{[]byte("a"), nil, []byte("0123456789")}), "*3\r\n$1\r\na\r\n$-1\r\n$10\r\n0123456789\r\n"},
+		{MakeMultiBulkReply(nil), "*0\r\n"},
+		{MakeStatusReply("OK"), "+OK\r\n"},
+		{MakeIntReply(-12), ":-12\r\n"},
+		{MakeErrReply("ERR x"), "-ERR x\r\n"},
+		{MakeMultiRawReply([]redis.Reply{MakeIntReply(1), MakeMultiBulkReply([][]byte{[]byte("b")}), MakeOkReply()}),
+			"*3\r\n:1\r\n*1\r\n$1\r\nb\r\n+OK\r\n"},
+		{MakeMapReply([]redis.Reply{MakeBulkReply([]byte("k")), MakeDoubleReply(1.5)}), "%1\r\n$1\r\nk\r\n,1.5\r\n"},
+		{MakePairsReply([]redis.Reply{MakeBulkReply([]byte("k")), MakeIntReply(1)}), "*1\r\n*2\r\n$1\r\nk\r\n:1\r\n"},
+		{MakePushReply([]redis.Reply{MakeBulkReply([]byte("message")), MakeBooleanReply(true)}), ">2\r\n$7\r\nmessage\r\n#t\r\n"},
+	}
+	for _, c := range cases {
+		if actual := string(c.reply.ToBytes()); actual != c.expected {
+			t.Errorf("ToBytes: expected %q, got %q", c.expected, actual)
+		}
+		if actual := string(AppendReply([]byte("prefix"), c.reply)); actual != "prefix"+c.expected {
+			t.Errorf("AppendReply: expected %q, got %q", c.expected, actual)
+		}
+		var buf bytes.Buffer
+		n, err := WriteReply(&buf, c.reply)
+		if err != nil || n != len(c.expected) || buf.String() != c.expected {
+			t.Errorf("WriteReply: expected %q, got %q (%d, %v)", c.expected, buf.String(), n, err)
+		}
+	}
+}
+
+// 模拟 GET 为主的 pipeline: 每批 100 个 64 字节的 bulk 回复，以及一个 MGET
+func makeGetPipeline() []redis.Reply {
+	value := bytes.Repeat([]byte("x"), 64)
+	replies := make([]redis.Reply, 0, 101)
+	for i := 0; i < 100; i++ {
+		replies = append(replies, MakeBulkReply(value))
+	}
+	args := make([][]byte, 20)
+	for i := range args {
+		args[i] = value
+	}
+	return append(replies, MakeMultiBulkReply(args))
+}
+
+func BenchmarkGetPipelineToBytes(b *testing.B) {
+	replies := makeGetPipeline()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, reply := range replies {
+			_, _ = io.Discard.Write(reply.ToBytes())
+		}
+	}
+}
+
+func BenchmarkGetPipelineWriteReply(b *testing.B) {
+	replies := makeGetPipeline()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		for _, reply := range replies {
+			_, _ = WriteReply(io.Discard, reply)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package epoll
+
+import (
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis/parser"
+	"github.com/zhangming/go-redis/redis/connection"
+)
+
+const (
+	readBufferSize = 16 << 10
+	// 一批连续执行的请求数，与标准服务器相同
+	maxBatch = 128
+)
+
+// 读缓冲只在处理协程读取时使用，空闲连接不占用
+var readBuffers = sync.Pool{
+	New: func() any {
+		buf := make([]byte, readBufferSize)
+		return &buf
+	},
+}
+
+type conn struct {
+	loop   *eventLoop
+	fd     int
+	client *connection.Connection
+	// 还没有收到完整的帧的数据
+	in []byte
+	// 上次解析时 in 的长度，in 增长到两倍或者读完当前数据时再解析，大的 bulk string 分多次到达时不必每次从头解析
+	parsed int
+
+	mu sync.Mutex
+	// 有协程正在处理这个连接，busy 期间的事件记录在 pending 中由该协程处理
+	busy    bool
+	pending bool
+	closed  bool
+}
+
+// notify 事件循环收到连接的事件，没有处理协程时启动一个
+func (c *conn) notify(events uint32) {
+	c.mu.Lock()
+	if c.client == nil || c.closed {
+		c.mu.Unlock()
+		return
+	}
+	if c.busy {
+		c.pending = true
+		client := c.client
+		c.mu.Unlock()
+		if events&(syscall.EPOLLRDHUP|syscall.EPOLLHUP|syscall.EPOLLERR) != 0 {
+			// 对端关闭时结束正在等待的阻塞命令
+			c.loop.server.handler.CancelBlocking(client)
+		}
+		return
+	}
+	c.busy = true
+	c.mu.Unlock()
+	go c.serve()
+}
+
+func (c *conn) serve() {
+	for {
+		if c.readAndExec() {
+			c.close()
+			return
+		}
+		c.mu.Lock()
+		if !c.pending {
+			c.busy = false
+			c.mu.Unlock()
+			return
+		}
+		c.pending = false
+		c.mu.Unlock()
+	}
+}
+
+// readAndExec 读取当前可读的全部数据并执行其中完整的请求，返回 true 时关闭连接
+func (c *conn) readAndExec() bool {
+	bufp := readBuffers.Get().(*[]byte)
+	defer readBuffers.Put(bufp)
+	for {
+		n, err := syscall.Read(c.fd, *bufp)
+		if n > 0 {
+			c.loop.server.recordInput(n)
+			data := (*bufp)[:n]
+			if len(c.in) > 0 {
+				c.in = append(c.in, data...)
+				data = c.in
+				if len(data) < 2*c.parsed {
+					continue
+				}
+			}
+			if c.execComplete(data) {
+				return true
+			}
+			continue
+		}
+		switch err {
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN:
+			if len(c.in) > c.parsed && c.execComplete(c.in) {
+				return true
+			}
+			return c.client.IsKilled()
+		}
+		// 对端关闭(n == 0)或者读取出错，已经收到的完整请求仍然执行
+		if len(c.in) > c.parsed {
+			c.execComplete(c.in)
+		}
+		return true
+	}
+}
+
+// execComplete 执行 data 中完整的请求，不完整的帧保存到 in 中
+func (c *conn) execComplete(data []byte) bool {
+	payloads, consumed := parser.ParseComplete(data)
+	rest := data[consumed:]
+	switch {
+	case len(rest) == 0:
+		// 空闲连接不保留缓冲
+		c.in = nil
+	case len(c.in) > 0:
+		c.in = append(c.in[:0], rest...)
+	default:
+		// data 是共用的读缓冲
+		c.in = append([]byte(nil), rest...)
+	}
+	c.parsed = len(rest)
+	for i := 0; i < len(payloads); i += maxBatch {
+		if c.loop.server.handler.ExecBatch(c.client, payloads[i:min(i+maxBatch, len(payloads))]) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.loop.remove(c)
+	c.loop.server.handler.CloseClient(c.client)
+}
+
+// killNotifier CLIENT KILL 通过设置读超时让标准服务器的读取返回，
+// 事件循环中的连接不会阻塞在读取上，这里改为通知处理协程关闭连接
+type killNotifier struct {
+	net.Conn
+	c *conn
+}
+
+func (k *killNotifier) SetReadDeadline(t time.Time) error {
+	if !t.IsZero() && !t.After(time.Now()) {
+		k.c.notify(0)
+	}
+	return k.Conn.SetReadDeadline(t)
+}
@@ -0,0 +1,219 @@
+package epoll
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	idatabase "github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/redis/server/std"
+)
+
+const (
+	// 边沿触发，连接可读时只通知一次，处理协程读到 EAGAIN 为止
+	edgeTriggered = syscall.EPOLLET & 0xffffffff
+	connEvents    = syscall.EPOLLIN | syscall.EPOLLRDHUP | edgeTriggered
+	// 每次 epoll_wait 最多返回的事件数
+	maxEvents = 256
+	// 每个事件循环的 CPU 数，事件循环只分发事件，不需要很多
+	procsPerLoop = 4
+)
+
+// Server serves connections registered in epoll event loops, implements tcp.Handler
+type Server struct {
+	handler  *std.Handler
+	recorder idatabase.StatsRecorder
+	loops    []*eventLoop
+	next     atomic.Uint32
+}
+
+// MakeServer starts event loops for handler, the loops stop when the server is closed
+func MakeServer(handler *std.Handler) (*Server, error) {
+	server := &Server{handler: handler}
+	server.recorder, _ = handler.DB().(idatabase.StatsRecorder)
+	count := (runtime.GOMAXPROCS(0) + procsPerLoop - 1) / procsPerLoop
+	for i := 0; i < count; i++ {
+		loop, err := makeEventLoop(server)
+		if err != nil {
+			server.stopLoops()
+			return nil, err
+		}
+		server.loops = append(server.loops, loop)
+		go loop.run()
+	}
+	return server, nil
+}
+
+// Handle registers conn in an event loop and returns immediately
+func (s *Server) Handle(ctx context.Context, raw net.Conn) {
+	fd, err := socketFD(raw)
+	if err != nil {
+		// 不是 socket(例如测试中的 net.Pipe)时使用标准的处理方式
+		s.handler.Handle(ctx, raw)
+		return
+	}
+	c := &conn{fd: fd, loop: s.loops[s.next.Add(1)%uint32(len(s.loops))]}
+	client := s.handler.Accept(&killNotifier{Conn: raw, c: c})
+	if client == nil {
+		return
+	}
+	c.mu.Lock()
+	c.client = client
+	c.mu.Unlock()
+	if err := c.loop.add(c); err != nil {
+		slog.Warn("register connection in epoll failed", "addr", raw.RemoteAddr().String(), "error", err)
+		s.handler.CloseClient(client)
+		return
+	}
+	if client.IsKilled() {
+		// 注册之前被关闭
+		c.notify(0)
+	}
+}
+
+// Close kills all connections, they are closed by their serving goroutines, then stops the event loops
+func (s *Server) Close() error {
+	err := s.handler.Close()
+	s.stopLoops()
+	return err
+}
+
+// ShutdownRequested is closed after the db executes SHUTDOWN
+func (s *Server) ShutdownRequested() <-chan struct{} {
+	return s.handler.ShutdownRequested()
+}
+
+func (s *Server) stopLoops() {
+	for _, loop := range s.loops {
+		loop.stop()
+	}
+}
+
+func (s *Server) recordInput(n int) {
+	if s.recorder != nil {
+		s.recorder.RecordNetInput(n)
+	}
+}
+
+// socketFD 返回连接的文件描述符，连接由 Handler 关闭之前一直有效
+func socketFD(conn net.Conn) (int, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return -1, errNotSupported
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	if err := raw.Control(func(f uintptr) {
+		fd = int(f)
+	}); err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
+
+type eventLoop struct {
+	server *Server
+	epfd   int
+	// 写入 wakeW 让 epoll_wait 返回，用于停止事件循环
+	wakeR, wakeW int
+	stopped      atomic.Bool
+
+	mu    sync.Mutex
+	conns map[int]*conn
+}
+
+func makeEventLoop(server *Server) (*eventLoop, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	var pipe [2]int
+	if err := syscall.Pipe2(pipe[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		_ = syscall.Close(epfd)
+		return nil, err
+	}
+	loop := &eventLoop{
+		server: server,
+		epfd:   epfd,
+		wakeR:  pipe[0],
+		wakeW:  pipe[1],
+		conns:  make(map[int]*conn),
+	}
+	event := &syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(loop.wakeR)}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, loop.wakeR, event); err != nil {
+		loop.closeFDs()
+		return nil, err
+	}
+	return loop, nil
+}
+
+func (loop *eventLoop) add(c *conn) error {
+	loop.mu.Lock()
+	loop.conns[c.fd] = c
+	loop.mu.Unlock()
+	// 注册时已经可读的连接会立即返回事件
+	event := &syscall.EpollEvent{Events: connEvents, Fd: int32(c.fd)}
+	if err := syscall.EpollCtl(loop.epfd, syscall.EPOLL_CTL_ADD, c.fd, event); err != nil {
+		loop.remove(c)
+		return err
+	}
+	return nil
+}
+
+// remove 在关闭连接之前调用，之后同一个文件描述符可能分配给新的连接
+func (loop *eventLoop) remove(c *conn) {
+	if !loop.stopped.Load() {
+		_ = syscall.EpollCtl(loop.epfd, syscall.EPOLL_CTL_DEL, c.fd, nil)
+	}
+	loop.mu.Lock()
+	if loop.conns[c.fd] == c {
+		delete(loop.conns, c.fd)
+	}
+	loop.mu.Unlock()
+}
+
+func (loop *eventLoop) run() {
+	defer loop.closeFDs()
+	events := make([]syscall.EpollEvent, maxEvents)
+	for {
+		n, err := syscall.EpollWait(loop.epfd, events, -1)
+		if err != nil {
+			if err == syscall.EINTR {
+				continue
+			}
+			slog.Error("epoll wait failed", "error", err)
+			return
+		}
+		for _, event := range events[:n] {
+			fd := int(event.Fd)
+			if fd == loop.wakeR {
+				return
+			}
+			loop.mu.Lock()
+			c := loop.conns[fd]
+			loop.mu.Unlock()
+			if c != nil {
+				c.notify(event.Events)
+			}
+		}
+	}
+}
+
+func (loop *eventLoop) stop() {
+	if loop.stopped.CompareAndSwap(false, true) {
+		_, _ = syscall.Write(loop.wakeW, []byte{0})
+	}
+}
+
+func (loop *eventLoop) closeFDs() {
+	_ = syscall.Close(loop.wakeR)
+	_ = syscall.Close(loop.wakeW)
+	_ = syscall.Close(loop.epfd)
+}
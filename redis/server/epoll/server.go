@@ -0,0 +1,26 @@
+package epoll
+
+import (
+	"errors"
+
+	"github.com/zhangming/go-redis/redis/server/std"
+	"github.com/zhangming/go-redis/tcp"
+)
+
+// 事件循环服务器
+// 标准服务器为每个连接保留读取、解析和处理三个协程以及读缓冲，空闲连接也占用协程栈。
+// 这里用 epoll 监听所有连接，连接可读时才启动一个协程读取并执行已经到达的请求，读完当前数据后协程退出，
+// 空闲连接只保留连接对象。回复仍然写入连接的输出缓冲由发送协程写出，阻塞命令在执行请求的协程中等待，不影响事件循环
+
+var errNotSupported = errors.New("epoll server mode is only supported on linux")
+
+// Serve starts an event loop server on addr, requests are executed by handler
+func Serve(addr string, handler *std.Handler) error {
+	server, err := MakeServer(handler)
+	if err != nil {
+		return err
+	}
+	return tcp.ListenAndServeWithSignal(&tcp.Config{
+		Address: addr,
+	}, server)
+}
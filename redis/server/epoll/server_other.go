@@ -0,0 +1,24 @@
+//go:build !linux
+
+package epoll
+
+import (
+	"context"
+	"net"
+
+	"github.com/zhangming/go-redis/redis/server/std"
+)
+
+// Server is not available on this platform, see MakeServer
+type Server struct{}
+
+// MakeServer returns an error, epoll is only available on linux
+func MakeServer(handler *std.Handler) (*Server, error) {
+	return nil, errNotSupported
+}
+
+func (s *Server) Handle(ctx context.Context, conn net.Conn) {}
+
+func (s *Server) Close() error {
+	return nil
+}
@@ -0,0 +1,164 @@
+//go:build linux
+
+package epoll
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/redis/protocol"
+	"github.com/zhangming/go-redis/redis/server/std"
+	"github.com/zhangming/go-redis/tcp"
+)
+
+type testConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func startServer(t *testing.T) (string, chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := MakeServer(std.MakeHandlerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tcp.ListenAndServe(listener, server, closeChan)
+	}()
+	t.Cleanup(func() {
+		select {
+		case <-closeChan:
+		default:
+			close(closeChan)
+		}
+		<-done
+	})
+	return listener.Addr().String(), closeChan
+}
+
+func dial(t *testing.T, addr string) *testConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &testConn{Conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testConn) send(t *testing.T, args ...string) {
+	t.Helper()
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	if _, err := c.Write(protocol.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// read 读取一个回复，bulk string 只返回内容
+func (c *testConn) read(t *testing.T) string {
+	t.Helper()
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	switch line[0] {
+	case '$':
+		size, _ := strconv.Atoi(line[1:])
+		if size < 0 {
+			return "(nil)"
+		}
+		body := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, body); err != nil {
+			t.Fatal(err)
+		}
+		return string(body[:size])
+	case '*':
+		size, _ := strconv.Atoi(line[1:])
+		elems := make([]string, 0, size)
+		for i := 0; i < size; i++ {
+			elems = append(elems, c.read(t))
+		}
+		return strings.Join(elems, " ")
+	}
+	return line
+}
+
+func (c *testConn) do(t *testing.T, args ...string) string {
+	t.Helper()
+	c.send(t, args...)
+	return c.read(t)
+}
+
+func TestPipeline(t *testing.T) {
+	addr, _ := startServer(t)
+	c := dial(t, addr)
+	// 大的 value 分多次读到，期间不完整的帧保留到下一次解析
+	value := strings.Repeat("v", 200<<10)
+	var pipeline []byte
+	for i := 0; i < 300; i++ {
+		pipeline = append(pipeline, protocol.MakeMultiBulkReply([][]byte{[]byte("SET"), []byte("k" + strconv.Itoa(i)), []byte(value)}).ToBytes()...)
+	}
+	pipeline = append(pipeline, "GET k299\r\n"...)
+	go func() {
+		_, _ = c.Write(pipeline)
+	}()
+	for i := 0; i < 300; i++ {
+		if reply := c.read(t); reply != "+OK" {
+			t.Fatalf("reply %d: unexpected %q", i, reply)
+		}
+	}
+	if reply := c.read(t); reply != value {
+		t.Fatalf("unexpected value of %d bytes", len(reply))
+	}
+	if reply := c.do(t, "DBSIZE"); reply != ":300" {
+		t.Errorf("unexpected dbsize %q", reply)
+	}
+}
+
+func TestKillAndClose(t *testing.T) {
+	addr, closeChan := startServer(t)
+	idle := dial(t, addr)
+	id := strings.TrimPrefix(idle.do(t, "CLIENT", "ID"), ":")
+	other := dial(t, addr)
+	if reply := other.do(t, "CLIENT", "KILL", "ID", id); reply != ":1" {
+		t.Fatalf("unexpected kill reply %q", reply)
+	}
+	// 空闲的连接被关闭
+	if _, err := idle.reader.ReadByte(); err == nil {
+		t.Error("expected killed connection to be closed")
+	}
+
+	// 对端关闭之后阻塞命令不再等待，之后写入的元素留在列表中
+	blocked := dial(t, addr)
+	blocked.send(t, "BLPOP", "queue", "0")
+	time.Sleep(50 * time.Millisecond)
+	_ = blocked.Close()
+	time.Sleep(50 * time.Millisecond)
+	other.do(t, "RPUSH", "queue", "x")
+	if reply := other.do(t, "LLEN", "queue"); reply != ":1" {
+		t.Errorf("expected the element to stay in the list, got %q", reply)
+	}
+
+	close(closeChan)
+	if _, err := other.reader.ReadByte(); err == nil {
+		t.Error("expected connections to be closed on shutdown")
+	}
+}
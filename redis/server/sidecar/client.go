@@ -0,0 +1,150 @@
+package sidecar
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// Client is a sidecar client, it is safe for concurrent use but requests are serialized
+type Client struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+}
+
+// Dial connects to sidecar port
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		conn:   conn,
+		reader: bufio.NewReaderSize(conn, 64*1024),
+		writer: bufio.NewWriterSize(conn, 64*1024),
+	}, nil
+}
+
+// Close closes the connection
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends a command and returns the decoded reply
+// error replies are returned as err with type Error
+func (c *Client) Do(args ...[]byte) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := writeRequest(c.writer, args); err != nil {
+		return nil, err
+	}
+	if err := c.writer.Flush(); err != nil {
+		return nil, err
+	}
+	result, err := readReply(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := result.(Error); ok {
+		return nil, e
+	}
+	return result, nil
+}
+
+func unexpected(reply interface{}) error {
+	return fmt.Errorf("sidecar: unexpected reply %v", reply)
+}
+
+// Get returns value of key, nil if key not exists
+func (c *Client) Get(key string) ([]byte, error) {
+	reply, err := c.Do([]byte("GET"), []byte(key))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	val, ok := reply.([]byte)
+	if !ok {
+		return nil, unexpected(reply)
+	}
+	return val, nil
+}
+
+// Set sets value of key
+func (c *Client) Set(key string, value []byte) error {
+	_, err := c.Do([]byte("SET"), []byte(key), value)
+	return err
+}
+
+// HSet sets field of hash, returns true if field is new
+func (c *Client) HSet(key, field string, value []byte) (bool, error) {
+	reply, err := c.Do([]byte("HSET"), []byte(key), []byte(field), value)
+	if err != nil {
+		return false, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return false, unexpected(reply)
+	}
+	return n == 1, nil
+}
+
+// HGet returns value of field, nil if field not exists
+func (c *Client) HGet(key, field string) ([]byte, error) {
+	reply, err := c.Do([]byte("HGET"), []byte(key), []byte(field))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	val, ok := reply.([]byte)
+	if !ok {
+		return nil, unexpected(reply)
+	}
+	return val, nil
+}
+
+// HGetAll returns all fields and values of hash
+func (c *Client) HGetAll(key string) (map[string][]byte, error) {
+	reply, err := c.Do([]byte("HGETALL"), []byte(key))
+	if err != nil {
+		return nil, err
+	}
+	arr, ok := reply.([]interface{})
+	if !ok {
+		return nil, unexpected(reply)
+	}
+	result := make(map[string][]byte, len(arr)/2)
+	for i := 0; i+1 < len(arr); i += 2 {
+		field, _ := arr[i].([]byte)
+		val, _ := arr[i+1].([]byte)
+		result[string(field)] = val
+	}
+	return result, nil
+}
+
+// ZAdd adds member to sorted set, returns true if member is new
+func (c *Client) ZAdd(key string, score float64, member string) (bool, error) {
+	reply, err := c.Do([]byte("ZADD"), []byte(key), []byte(strconv.FormatFloat(score, 'f', -1, 64)), []byte(member))
+	if err != nil {
+		return false, err
+	}
+	n, ok := reply.(int64)
+	if !ok {
+		return false, unexpected(reply)
+	}
+	return n == 1, nil
+}
+
+// ZScore returns score of member, ok is false if member not exists
+func (c *Client) ZScore(key, member string) (score float64, ok bool, err error) {
+	reply, err := c.Do([]byte("ZSCORE"), []byte(key), []byte(member))
+	if err != nil || reply == nil {
+		return 0, false, err
+	}
+	score, ok = reply.(float64)
+	if !ok {
+		return 0, false, unexpected(reply)
+	}
+	return score, true, nil
+}
@@ -0,0 +1,281 @@
+package sidecar
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// 二进制协议，所有整数使用大端序
+// 请求: argc(u32) 然后是 argc 个参数，每个参数为 len(u32) + bytes
+// 回复: tag(1 byte) + 对应的内容
+//   tagNil    无内容
+//   tagStatus len(u32) + bytes
+//   tagError  len(u32) + bytes
+//   tagInt    int64
+//   tagDouble float64
+//   tagBulk   len(u32) + bytes
+//   tagArray  n(u32) + n 个回复
+// 与 RESP 相比不需要按行扫描和解析十进制长度，大 value 可以直接 io.ReadFull
+
+const (
+	tagNil byte = iota
+	tagStatus
+	tagError
+	tagInt
+	tagDouble
+	tagBulk
+	tagArray
+)
+
+const (
+	// 单个参数或单个回复的最大长度，防止错误的长度导致分配过大的内存
+	maxFrameLen = 512 << 20
+	// 请求参数个数和数组元素个数的上限，与 RESP 解析器的 maxArrayLen 相同
+	maxArrayLen = 1024 * 1024
+	// 按头部声明的长度预先分配的上限，更长的参数和数组随着数据到达扩容，
+	// 只发送头部的连接不能让服务端分配大块内存
+	preallocLen   = 64 << 10
+	preallocElems = 1024
+)
+
+var errFrameTooLarge = errors.New("sidecar: frame too large")
+
+// Status is a status reply such as OK
+type Status string
+
+// Error is an error reply returned by server
+type Error string
+
+func (e Error) Error() string {
+	return string(e)
+}
+
+func writeUint32(w *bufio.Writer, n uint32) error {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], n)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func readUint32(r *bufio.Reader) (uint32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(buf[:]), nil
+}
+
+func writeBytes(w *bufio.Writer, b []byte) error {
+	if err := writeUint32(w, uint32(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func readBytes(r *bufio.Reader) ([]byte, error) {
+	n, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFrameLen {
+		return nil, errFrameTooLarge
+	}
+	size := int(n)
+	b := make([]byte, 0, min(size, preallocLen))
+	for len(b) < size {
+		if len(b) == cap(b) {
+			b = slices.Grow(b, min(len(b), size-len(b)))
+		}
+		read, err := io.ReadFull(r, b[len(b):min(cap(b), size)])
+		b = b[:len(b)+read]
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// writeRequest 写入一条命令
+func writeRequest(w *bufio.Writer, args [][]byte) error {
+	if err := writeUint32(w, uint32(len(args))); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		if err := writeBytes(w, arg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRequest 读取一条命令
+func readRequest(r *bufio.Reader) ([][]byte, error) {
+	argc, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if argc == 0 || argc > maxArrayLen {
+		return nil, errors.New("sidecar: invalid argument count " + strconv.Itoa(int(argc)))
+	}
+	args := make([][]byte, 0, min(int(argc), preallocElems))
+	for i := uint32(0); i < argc; i++ {
+		arg, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// writeReply 把命令层的回复直接编码为二进制格式，不经过 RESP
+func writeReply(w *bufio.Writer, reply redis.Reply) error {
+	switch r := reply.(type) {
+	case *protocol.BulkReply:
+		if r.Arg == nil {
+			return w.WriteByte(tagNil)
+		}
+		return writeTagged(w, tagBulk, r.Arg)
+	case *protocol.NullBulkReply, *protocol.NoReply, nil:
+		return w.WriteByte(tagNil)
+	case *protocol.IntReply:
+		return writeUint64(w, tagInt, uint64(r.Code))
+	case *protocol.DoubleReply:
+		return writeUint64(w, tagDouble, math.Float64bits(r.Value))
+	case *protocol.StatusReply:
+		return writeTagged(w, tagStatus, []byte(r.Status))
+	case *protocol.OkReply:
+		return writeTagged(w, tagStatus, []byte("OK"))
+	case *protocol.PongReply:
+		return writeTagged(w, tagStatus, []byte("PONG"))
+	case *protocol.QueuedReply:
+		return writeTagged(w, tagStatus, []byte("QUEUED"))
+	case *protocol.EmptyMultiBulkReply:
+		return writeArrayHeader(w, 0)
+	case *protocol.MultiBulkReply:
+		if err := writeArrayHeader(w, len(r.Args)); err != nil {
+			return err
+		}
+		for _, arg := range r.Args {
+			var err error
+			if arg == nil {
+				err = w.WriteByte(tagNil)
+			} else {
+				err = writeTagged(w, tagBulk, arg)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case *protocol.MultiRawReply:
+		return writeReplies(w, r.Replies)
+	case *protocol.MapReply:
+		return writeReplies(w, r.Args)
+	case *protocol.PairsReply:
+		return writeReplies(w, r.Args)
+	case protocol.ErrorReply:
+		return writeTagged(w, tagError, []byte(r.Error()))
+	}
+	// 其他类型按照 RESP 的首字节区分
+	raw := reply.ToBytes()
+	if len(raw) >= 3 && (raw[0] == '+' || raw[0] == '-') {
+		tag := tagStatus
+		if raw[0] == '-' {
+			tag = tagError
+		}
+		return writeTagged(w, tag, raw[1:len(raw)-2])
+	}
+	return writeTagged(w, tagBulk, raw)
+}
+
+func writeTagged(w *bufio.Writer, tag byte, b []byte) error {
+	if err := w.WriteByte(tag); err != nil {
+		return err
+	}
+	return writeBytes(w, b)
+}
+
+func writeUint64(w *bufio.Writer, tag byte, n uint64) error {
+	var buf [9]byte
+	buf[0] = tag
+	binary.BigEndian.PutUint64(buf[1:], n)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func writeArrayHeader(w *bufio.Writer, n int) error {
+	if err := w.WriteByte(tagArray); err != nil {
+		return err
+	}
+	return writeUint32(w, uint32(n))
+}
+
+func writeReplies(w *bufio.Writer, replies []redis.Reply) error {
+	if err := writeArrayHeader(w, len(replies)); err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if err := writeReply(w, reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readReply 解码回复，返回值为 nil, Status, Error, int64, float64, []byte 或 []interface{}
+func readReply(r *bufio.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagStatus:
+		b, err := readBytes(r)
+		return Status(b), err
+	case tagError:
+		b, err := readBytes(r)
+		return Error(b), err
+	case tagInt, tagDouble:
+		var buf [8]byte
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+		n := binary.BigEndian.Uint64(buf[:])
+		if tag == tagInt {
+			return int64(n), nil
+		}
+		return math.Float64frombits(n), nil
+	case tagBulk:
+		return readBytes(r)
+	case tagArray:
+		n, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		if n > maxArrayLen {
+			return nil, errFrameTooLarge
+		}
+		arr := make([]interface{}, 0, min(int(n), preallocElems))
+		for i := uint32(0); i < n; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, item)
+		}
+		return arr, nil
+	}
+	return nil, errors.New("sidecar: unknown reply tag " + strconv.Itoa(int(tag)))
+}
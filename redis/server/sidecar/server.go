@@ -0,0 +1,125 @@
+package sidecar
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	idatabase "github.com/zhangming/go-redis/interfaces/database"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+	"github.com/zhangming/go-redis/tcp"
+)
+
+// sidecar 是给 go 服务使用的二进制协议端口
+// 命令仍然通过 db.Exec 执行，和 RESP 端口共享同一个数据库、锁以及 aof，
+// 只是省掉了 RESP 的文本解析和回复的序列化
+
+// 这些命令会直接向连接写入 RESP 数据或者把连接变成复制连接，sidecar 不支持
+var unsupportedCmds = map[string]struct{}{
+	"subscribe":    {},
+	"unsubscribe":  {},
+	"psubscribe":   {},
+	"punsubscribe": {},
+	"psync":        {},
+	"sync":         {},
+	"monitor":      {},
+}
+
+// Handler serves sidecar protocol, it shares db with the RESP handler
+type Handler struct {
+	activeConn sync.Map // *connection.Connection -> net.Conn
+	db         idatabase.DB
+	closing    atomic.Bool
+}
+
+// MakeHandler creates a sidecar handler on the given db
+func MakeHandler(db idatabase.DB) *Handler {
+	return &Handler{
+		db: db,
+	}
+}
+
+// Serve listens addr and blocks until closeChan is closed
+func Serve(addr string, handler *Handler, closeChan <-chan struct{}) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	slog.Info("sidecar bind: " + addr)
+	tcp.ListenAndServe(listener, handler, closeChan)
+	return nil
+}
+
+// Close closes connections of sidecar, db is owned by the RESP handler and is not closed here
+func (h *Handler) Close() error {
+	h.closing.Store(true)
+	// 只关闭底层连接，Handle 读取失败后由 closeClient 释放 Connection
+	// 阻塞命令不会读取连接，需要通知 db 结束，否则关闭 db 之前会一直等待
+	canceler, _ := h.db.(idatabase.BlockingCanceler)
+	h.activeConn.Range(func(key interface{}, val interface{}) bool {
+		_ = val.(net.Conn).Close()
+		if canceler != nil {
+			canceler.CancelBlocking(key.(*connection.Connection))
+		}
+		return true
+	})
+	return nil
+}
+
+// closeClient 先让 db 清理订阅等状态，Close 会清空连接的状态并放回对象池
+func (h *Handler) closeClient(client *connection.Connection) {
+	h.db.AfterClientClose(client)
+	_ = client.Close()
+	h.activeConn.Delete(client)
+}
+
+// Handle reads requests from conn and writes binary replies
+func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
+	if h.closing.Load() {
+		_ = conn.Close()
+		return
+	}
+	if filter, ok := h.db.(idatabase.ConnectionFilter); ok && filter.AcceptConnection(conn.RemoteAddr()) != nil {
+		_ = conn.Close()
+		return
+	}
+	client := connection.NewConn(conn)
+	// 直接拿到 RESP3 类型的回复，浮点数和 map 不需要先转成字符串
+	client.SetProtocol(protocol.RESP3)
+	h.activeConn.Store(client, conn)
+	if tracker, ok := h.db.(idatabase.ClientTracker); ok {
+		tracker.AfterClientConnect(client)
+	}
+	defer h.closeClient(client)
+
+	reader := bufio.NewReaderSize(conn, 64*1024)
+	writer := bufio.NewWriterSize(conn, 64*1024)
+	for {
+		args, err := readRequest(reader)
+		if err != nil {
+			if err != io.EOF && !errors.Is(err, net.ErrClosed) {
+				slog.Info("sidecar connection closed: " + err.Error())
+			}
+			return
+		}
+		if _, ok := unsupportedCmds[strings.ToLower(string(args[0]))]; ok {
+			err = writeReply(writer, protocol.MakeErrReply("ERR '"+string(args[0])+"' is not supported by sidecar"))
+		} else {
+			err = writeReply(writer, h.db.Exec(client, args))
+		}
+		// 管道中还有未处理的请求时先不刷新，减少系统调用
+		if err == nil && reader.Buffered() == 0 {
+			err = writer.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
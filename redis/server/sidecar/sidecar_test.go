@@ -0,0 +1,376 @@
+package sidecar
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/database"
+	"github.com/zhangming/go-redis/interfaces/redis/parser"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/connection"
+	"github.com/zhangming/go-redis/redis/protocol"
+	"github.com/zhangming/go-redis/redis/server/std"
+	"github.com/zhangming/go-redis/tcp"
+)
+
+func TestSidecar(t *testing.T) {
+	db := database.NewStandaloneServerWithConfig(&config.ServerProperties{Databases: 16})
+	defer db.Close()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tcp.ListenAndServe(listener, MakeHandler(db), closeChan)
+	}()
+	defer func() {
+		close(closeChan)
+		<-done
+	}()
+
+	client, err := Dial(listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	value := bytes.Repeat([]byte("v"), 100000)
+	if err := client.Set("str", value); err != nil {
+		t.Fatal(err)
+	}
+	got, err := client.Get("str")
+	if err != nil || !bytes.Equal(got, value) {
+		t.Fatalf("get: %v", err)
+	}
+	if got, err := client.Get("missing"); err != nil || got != nil {
+		t.Fatalf("expected nil, got %q %v", got, err)
+	}
+	// 与 RESP 端口共享同一个数据库
+	ret := db.Exec(connection.NewFakeConn(), utils.ToCmdLine("STRLEN", "str"))
+	if intReply, ok := ret.(*protocol.IntReply); !ok || intReply.Code != int64(len(value)) {
+		t.Fatalf("unexpected strlen %q", ret.ToBytes())
+	}
+
+	if isNew, err := client.HSet("hash", "f1", []byte("v1")); err != nil || !isNew {
+		t.Fatalf("hset: %v", err)
+	}
+	if val, err := client.HGet("hash", "f1"); err != nil || string(val) != "v1" {
+		t.Fatalf("hget: %q %v", val, err)
+	}
+	all, err := client.HGetAll("hash")
+	if err != nil || len(all) != 1 || string(all["f1"]) != "v1" {
+		t.Fatalf("hgetall: %v %v", all, err)
+	}
+
+	if _, err := client.ZAdd("zset", 1.5, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if score, ok, err := client.ZScore("zset", "a"); err != nil || !ok || score != 1.5 {
+		t.Fatalf("zscore: %v %v %v", score, ok, err)
+	}
+
+	if _, err := client.HGet("str", "f1"); err == nil {
+		t.Fatal("expected wrong type error")
+	} else if _, ok := err.(Error); !ok {
+		t.Fatalf("expected server error, got %v", err)
+	}
+	if _, err := client.Do([]byte("SUBSCRIBE"), []byte("ch")); err == nil {
+		t.Fatal("expected subscribe to be rejected")
+	}
+}
+
+// sidecar 与 RESP 端口共用关闭信号，关闭 db 之前结束阻塞中的命令并退出
+func TestSidecarAttach(t *testing.T) {
+	handler := std.MakeHandlerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16})
+	addr := freeAddr(t)
+	served := make(chan struct{})
+	handler.Attach(func(closeChan <-chan struct{}) {
+		defer close(served)
+		if err := Serve(addr, MakeHandler(handler.DB()), closeChan); err != nil {
+			t.Error(err)
+		}
+	})
+	var client *Client
+	var err error
+	for i := 0; i < 100; i++ {
+		if client, err = Dial(addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := client.Do([]byte("BLPOP"), []byte("list"), []byte("0"))
+		blocked <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		_ = handler.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler close blocked by sidecar")
+	}
+	select {
+	case <-served:
+	default:
+		t.Fatal("db closed before sidecar stopped")
+	}
+	if err := <-blocked; err == nil {
+		t.Error("expected blocked command to fail")
+	}
+}
+
+func freeAddr(t testing.TB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func TestCodec(t *testing.T) {
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	replies := []struct {
+		reply    interface{ ToBytes() []byte }
+		expected string
+	}{
+		{protocol.MakeOkReply(), "OK"},
+		{protocol.MakeIntReply(-3), "-3"},
+		{protocol.MakeDoubleReply(2.5), "2.5"},
+		{protocol.MakeNullBulkReply(), "<nil>"},
+		{protocol.MakeErrReply("ERR boom"), "ERR boom"},
+		{protocol.MakeMultiBulkReply([][]byte{[]byte("a"), nil}), "[[97] <nil>]"},
+	}
+	for _, r := range replies {
+		if err := writeReply(w, r.reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = w.Flush()
+	reader := bufio.NewReader(&buf)
+	for _, r := range replies {
+		got, err := readReply(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s := toString(got); s != r.expected {
+			t.Errorf("expected %s, got %s", r.expected, s)
+		}
+	}
+}
+
+// TestCodecLimits 头部声明的长度在数据到达之前不会被预先分配
+func TestCodecLimits(t *testing.T) {
+	header := func(n uint32) *bufio.Reader {
+		var buf [4]byte
+		binary.BigEndian.PutUint32(buf[:], n)
+		return bufio.NewReader(bytes.NewReader(buf[:]))
+	}
+	if _, err := readRequest(header(maxArrayLen + 1)); err == nil {
+		t.Error("expected error for too many arguments")
+	}
+	if _, err := readReply(bufio.NewReader(bytes.NewReader([]byte{tagArray, 0xff, 0xff, 0xff, 0xff}))); err == nil {
+		t.Error("expected error for too many elements")
+	}
+	allocs := func(read func()) uint64 {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		read()
+		runtime.ReadMemStats(&after)
+		return after.TotalAlloc - before.TotalAlloc
+	}
+	for name, read := range map[string]func(){
+		"argc": func() { _, _ = readRequest(header(maxArrayLen)) },
+		"bulk": func() { _, _ = readBytes(header(maxFrameLen)) },
+		"array": func() {
+			_, _ = readReply(bufio.NewReader(bytes.NewReader([]byte{tagArray, 0, 0x10, 0, 0})))
+		},
+	} {
+		if n := allocs(read); n > 1<<20 {
+			t.Errorf("%s: header alone allocated %d bytes", name, n)
+		}
+	}
+
+	// 超过预分配上限的参数随着数据到达扩容
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	large := bytes.Repeat([]byte("x"), 3*preallocLen+1)
+	_ = writeRequest(w, [][]byte{[]byte("SET"), []byte("k"), large})
+	_ = w.Flush()
+	args, err := readRequest(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args) != 3 || !bytes.Equal(args[2], large) {
+		t.Error("large argument is corrupted")
+	}
+}
+
+func toString(v interface{}) string {
+	switch val := v.(type) {
+	case Status:
+		return string(val)
+	case Error:
+		return string(val)
+	case int64:
+		return strconv.FormatInt(val, 10)
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case nil:
+		return "<nil>"
+	case []interface{}:
+		s := "["
+		for i, item := range val {
+			if i > 0 {
+				s += " "
+			}
+			if b, ok := item.([]byte); ok {
+				s += "[" + strconv.Itoa(int(b[0])) + "]"
+			} else {
+				s += toString(item)
+			}
+		}
+		return s + "]"
+	}
+	return "?"
+}
+
+var benchSizes = []int{100, 10000, 1000000}
+
+// BenchmarkRESPCodec 一次 SET + GET 在 RESP 下的编解码开销: 客户端编码请求、服务端解析、服务端编码回复、客户端解析
+func BenchmarkRESPCodec(b *testing.B) {
+	for _, size := range benchSizes {
+		value := bytes.Repeat([]byte("a"), size)
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				req := protocol.MakeMultiBulkReply(utils.ToCmdLine3("SET", []byte("key"), value)).ToBytes()
+				if _, err := parser.ParseOne(req); err != nil {
+					b.Fatal(err)
+				}
+				resp := protocol.MakeBulkReply(value).ToBytes()
+				if _, err := parser.ParseOne(resp); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkSidecarCodec 与 BenchmarkRESPCodec 相同的流程，使用 sidecar 的二进制协议
+func BenchmarkSidecarCodec(b *testing.B) {
+	for _, size := range benchSizes {
+		value := bytes.Repeat([]byte("a"), size)
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			var buf bytes.Buffer
+			w := bufio.NewWriter(&buf)
+			r := bufio.NewReader(&buf)
+			for i := 0; i < b.N; i++ {
+				buf.Reset()
+				_ = writeRequest(w, utils.ToCmdLine3("SET", []byte("key"), value))
+				_ = w.Flush()
+				if _, err := readRequest(r); err != nil {
+					b.Fatal(err)
+				}
+				_ = writeReply(w, protocol.MakeBulkReply(value))
+				_ = w.Flush()
+				if _, err := readReply(r); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+var benchCmds = [][]string{
+	{"SET", "key", "value"},
+	{"GET", "key"},
+	{"HSET", "hash", "field", "value"},
+	{"HGET", "hash", "field"},
+	{"ZADD", "zset", "1.5", "member"},
+	{"ZSCORE", "zset", "member"},
+}
+
+// BenchmarkHandler 经过 RESP 和 sidecar 的 handler 以及 tcp 连接执行单个命令的往返开销，
+// 与只比较编解码的 BenchmarkRESPCodec/BenchmarkSidecarCodec 相比还包括命令执行和网络读写
+func BenchmarkHandler(b *testing.B) {
+	handler := std.MakeHandlerWithConfig(&config.ServerProperties{Dir: b.TempDir(), Databases: 16})
+	respListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	sidecarListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	respDone := make(chan struct{})
+	go func() {
+		defer close(respDone)
+		tcp.ListenAndServe(respListener, handler, closeChan)
+	}()
+	handler.Attach(func(<-chan struct{}) {
+		tcp.ListenAndServe(sidecarListener, MakeHandler(handler.DB()), closeChan)
+	})
+	defer func() {
+		close(closeChan)
+		<-respDone
+	}()
+
+	respConn, err := net.Dial("tcp", respListener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer respConn.Close()
+	replies := parser.ParseStream(respConn)
+	client, err := Dial(sidecarListener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, cmd := range benchCmds {
+		args := utils.ToCmdLine(cmd...)
+		req := protocol.MakeMultiBulkReply(args).ToBytes()
+		b.Run("RESP/"+cmd[0], func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := respConn.Write(req); err != nil {
+					b.Fatal(err)
+				}
+				payload := <-replies
+				if payload.Err != nil {
+					b.Fatal(payload.Err)
+				}
+			}
+		})
+		b.Run("Sidecar/"+cmd[0], func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := client.Do(args...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,489 @@
+package std
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/config"
+	"github.com/zhangming/go-redis/redis/protocol"
+	"github.com/zhangming/go-redis/tcp"
+)
+
+// 命令一致性测试
+// 通过 COMMAND 遍历命令表，每个命令在 RESP2 和 RESP3 下分别用给定的合法参数、不存在的 key、
+// 多余的语法参数和参数个数错误的参数执行：
+//   - 不能 panic(服务端捕获 panic 后回复 -Err unknown)，执行后连接仍然可用
+//   - 给定的合法参数不能回复错误
+//   - 错误回复以大写的错误类型开头，例如 ERR、WRONGTYPE
+//   - 参数个数错误时回复 ERR wrong number of arguments
+//   - 两种协议下回复的类型一致，RESP3 的 map、set、double 等按 RESP2 的编码比较
+
+// conformanceArgs 常用命令的合法参数，可以有多组；没有列出的命令按 arity 生成参数
+var conformanceArgs = map[string][][]string{
+	"set":           {{"str", "v"}, {"str", "v", "NX"}, {"str", "v", "EX", "10"}},
+	"get":           {{"str"}, {"nokey"}},
+	"getex":         {{"str", "PX", "1000"}},
+	"getrange":      {{"str", "0", "2"}},
+	"setrange":      {{"str", "1", "x"}},
+	"incrbyfloat":   {{"num", "1.5"}},
+	"incr":          {{"num"}},
+	"mset":          {{"a", "1", "b", "2"}},
+	"msetnx":        {{"a", "1", "b", "2"}},
+	"mget":          {{"str", "nokey"}},
+	"setbit":        {{"bits", "7", "1"}},
+	"getbit":        {{"str", "1"}},
+	"bitcount":      {{"str"}, {"str", "0", "-1"}},
+	"bitpos":        {{"str", "1"}},
+	"bitop":         {{"AND", "dest", "str", "str"}},
+	"lpush":         {{"list", "x"}},
+	"rpush":         {{"list", "x"}},
+	"lrange":        {{"list", "0", "-1"}},
+	"lindex":        {{"list", "0"}},
+	"lset":          {{"list", "0", "z"}},
+	"linsert":       {{"list", "BEFORE", "b", "x"}},
+	"lrem":          {{"list", "0", "a"}},
+	"ltrim":         {{"list", "0", "1"}},
+	"lmove":         {{"list", "list2", "LEFT", "RIGHT"}},
+	"rpoplpush":     {{"list", "list2"}},
+	"lpos":          {{"list", "b"}, {"list", "b", "RANK", "-1", "COUNT", "0"}},
+	"lmpop":         {{"2", "nokey", "list", "LEFT", "COUNT", "2"}},
+	"blpop":         {{"list", "0.01"}, {"nokey", "0.01"}},
+	"brpop":         {{"list", "0.01"}, {"nokey", "0.01"}},
+	"blmove":        {{"list", "list2", "LEFT", "RIGHT", "0.01"}, {"nokey", "list2", "LEFT", "RIGHT", "0.01"}},
+	"brpoplpush":    {{"list", "list2", "0.01"}, {"nokey", "list2", "0.01"}},
+	"hset":          {{"hash", "f3", "3"}},
+	"hmset":         {{"hash", "f3", "3"}},
+	"hsetnx":        {{"hash", "f1", "3"}},
+	"hget":          {{"hash", "f1"}, {"hash", "nofield"}},
+	"hmget":         {{"hash", "f1", "nofield"}},
+	"hgetall":       {{"hash"}, {"nokey"}},
+	"hincrby":       {{"hash", "f1", "2"}},
+	"hrandfield":    {{"hash"}, {"hash", "2"}, {"hash", "2", "WITHVALUES"}},
+	"hscan":         {{"hash", "0"}},
+	"sadd":          {{"set", "d"}},
+	"sismember":     {{"set", "a"}},
+	"smembers":      {{"set"}},
+	"smismember":    {{"set", "a", "nomember"}},
+	"srandmember":   {{"set"}, {"set", "2"}, {"set", "-5"}, {"nokey"}},
+	"sintercard":    {{"2", "set", "set"}, {"1", "set", "LIMIT", "1"}},
+	"spop":          {{"set"}, {"set", "2"}},
+	"sinter":        {{"set", "set"}},
+	"sunion":        {{"set", "nokey"}},
+	"sdiff":         {{"set", "nokey"}},
+	"sinterstore":   {{"dest", "set", "set"}},
+	"sunionstore":   {{"dest", "set", "set"}},
+	"sdiffstore":    {{"dest", "set", "set"}},
+	"sscan":         {{"set", "0"}},
+	"zadd":          {{"zset", "3", "c"}},
+	"zscore":        {{"zset", "a"}, {"zset", "nomember"}},
+	"zmscore":       {{"zset", "a", "nomember"}, {"nokey", "a"}},
+	"zrandmember":   {{"zset"}, {"zset", "2"}, {"zset", "-5", "WITHSCORES"}, {"nokey"}},
+	"zincrby":       {{"zset", "1", "a"}},
+	"zrank":         {{"zset", "a"}},
+	"zrevrank":      {{"zset", "a"}},
+	"zcount":        {{"zset", "-inf", "+inf"}},
+	"zrange":        {{"zset", "0", "-1"}, {"zset", "0", "-1", "WITHSCORES"}},
+	"zrevrange":     {{"zset", "0", "-1", "WITHSCORES"}},
+	"zpopmin":       {{"zset"}, {"zset", "2"}},
+	"zpopmax":       {{"zset"}, {"zset", "2"}},
+	"bzpopmin":      {{"zset", "0.01"}, {"nokey", "0.01"}},
+	"bzpopmax":      {{"zset", "0.01"}, {"nokey", "0.01"}},
+	"zpeekmin":      {{"zset"}, {"nokey"}},
+	"zscan":         {{"zset", "0"}},
+	"object":        {{"ENCODING", "zset"}, {"IDLETIME", "nokey"}, {"HELP"}},
+	"xadd":          {{"stream", "*", "f", "v"}},
+	"xrange":        {{"stream", "-", "+"}},
+	"xrevrange":     {{"stream", "+", "-"}},
+	"xread":         {{"COUNT", "1", "STREAMS", "stream", "0"}, {"BLOCK", "10", "STREAMS", "nokey", "$"}},
+	"xtrim":         {{"stream", "MAXLEN", "10"}},
+	"xsetid":        {{"stream", "9-0"}},
+	"geoadd":        {{"geo", "13.361389", "38.115556", "palermo"}},
+	"geopos":        {{"geo", "palermo", "nomember"}},
+	"geodist":       {{"geo", "palermo", "catania", "km"}},
+	"geosearch":     {{"geo", "FROMMEMBER", "palermo", "BYRADIUS", "200", "km", "ASC", "WITHDIST"}},
+	"expire":        {{"str", "100"}, {"str", "100", "GT"}},
+	"pexpire":       {{"str", "100000"}},
+	"expireat":      {{"str", "4102444800"}},
+	"pexpireat":     {{"str", "4102444800000"}},
+	"rename":        {{"str", "str2"}},
+	"renamenx":      {{"str", "str2"}},
+	"copy":          {{"str", "str2"}},
+	"dump":          {{"str"}, {"nokey"}},
+	"keys":          {{"*"}, {"*", "TYPE", "list"}},
+	"scan":          {{"0"}, {"0", "MATCH", "s*", "COUNT", "100"}, {"0", "TYPE", "string"}},
+	"publish":       {{"channel", "msg"}},
+	"select":        {{"1"}},
+	"swapdb":        {{"2", "3"}, {"2", "2"}},
+	"client":        {{"id"}, {"getname"}, {"list"}},
+	"command":       {{}, {"count"}, {"info", "get"}, {"getkeys", "set", "k", "v"}},
+	"info":          {{}, {"server"}},
+	"slowlog":       {{"get"}, {"len"}},
+	"acl":           {{"whoami"}, {"users"}, {"list"}, {"getuser", "default"}, {"cat"}, {"cat", "string"}},
+	"config":        {{"get", "port"}, {"set", "slowlog-max-len", "128"}},
+	"debug":         {{"ttlmap", "0"}},
+	"expirepattern": {{"s*", "100"}},
+}
+
+// conformanceErrorArgs 一定回复错误的参数，只检查错误类型
+var conformanceErrorArgs = map[string][][]string{
+	"get":     {{"list"}},
+	"incr":    {{"str"}},
+	"lrange":  {{"str", "0", "-1"}},
+	"restore": {{"str", "0", "bad"}, {"str3", "0", "bad", "REPLACE"}},
+	"config":  {{"set", "port", "1"}},
+	"rename":  {{"nokey", "str2"}},
+	"zrange":  {{"zset", "0", "-1", "BADSYNTAX"}},
+	"expire":  {{"str", "100", "NX", "XX"}},
+	// 测试服务器没有开启 aof
+	"waitsync": {{"set", "str", "v"}},
+}
+
+// conformanceSkip 会改变连接或者实例状态、无法在一条请求一条回复的模式下比较的命令
+var conformanceSkip = map[string]string{
+	"hello":        "switches protocol, used to set up RESP3",
+	"subscribe":    "replies are pushed",
+	"psubscribe":   "replies are pushed",
+	"unsubscribe":  "replies are pushed",
+	"punsubscribe": "replies are pushed",
+	"psync":        "turns the connection into a replica stream",
+	"sync":         "turns the connection into a replica stream",
+	"slaveof":      "starts replication",
+	"replicaof":    "starts replication",
+	"multi":        "queues the following commands",
+	"monitor":      "replies are pushed",
+	"bgsave":       "runs in background",
+	"bgrewriteaof": "runs in background",
+	"shutdown":     "stops the server",
+}
+
+// conformanceFixture 每个命令执行前重新写入的数据，
+// 只清空 0 号数据库，FLUSHALL 需要重建所有数据库，执行几百次太慢
+var conformanceFixture = [][]string{
+	{"SELECT", "0"},
+	{"FLUSHDB"},
+	{"SET", "str", "hello"},
+	{"SET", "num", "10"},
+	{"RPUSH", "list", "a", "b", "c"},
+	{"HSET", "hash", "f1", "1", "f2", "2"},
+	{"SADD", "set", "a", "b", "c"},
+	{"ZADD", "zset", "1", "a", "2", "b"},
+	{"XADD", "stream", "1-0", "f", "v"},
+	{"GEOADD", "geo", "13.361389", "38.115556", "palermo", "15.087269", "37.502669", "catania"},
+}
+
+type conformanceConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialConformance(t *testing.T, addr string, resp int) *conformanceConn {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &conformanceConn{conn: conn, reader: bufio.NewReader(conn)}
+	if resp == protocol.RESP3 {
+		if reply := c.do(t, "HELLO", "3"); reply.typ != '%' {
+			t.Fatalf("HELLO 3 failed: %q", reply.raw)
+		}
+	}
+	return c
+}
+
+type rawReply struct {
+	typ byte
+	raw string
+	// 数组和 map 的元素，用于读取 COMMAND 的结果
+	elems []rawReply
+}
+
+// kind 把 RESP3 的类型归一到 RESP2 的编码，用于比较两种协议下的回复
+func (r rawReply) kind() string {
+	switch r.typ {
+	case '_':
+		return "null"
+	case '$', '*':
+		if strings.HasPrefix(r.raw, string(r.typ)+"-1\r\n") {
+			return "null"
+		}
+		if r.typ == '$' {
+			return "bulk"
+		}
+		return "array"
+	case '%', '~', '>':
+		return "array"
+	case ',', '(', '=':
+		return "bulk"
+	case '#', ':':
+		return "integer"
+	case '!', '-':
+		return "error"
+	case '+':
+		return "status"
+	}
+	return "unknown " + string(r.typ)
+}
+
+func (c *conformanceConn) do(t *testing.T, args ...string) rawReply {
+	t.Helper()
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	_ = c.conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.conn.Write(protocol.MakeMultiBulkReply(cmdLine).ToBytes()); err != nil {
+		t.Fatalf("%v: write failed: %v", args, err)
+	}
+	reply, err := readRawReply(c.reader)
+	if err != nil {
+		t.Fatalf("%v: read failed: %v", args, err)
+	}
+	return reply
+}
+
+func readRawReply(reader *bufio.Reader) (rawReply, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return rawReply{}, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return rawReply{}, errors.New("malformed line " + strconv.Quote(line))
+	}
+	reply := rawReply{typ: line[0], raw: line}
+	switch reply.typ {
+	case '+', '-', ':', ',', '#', '_', '(':
+		return reply, nil
+	case '$', '=', '!':
+		size, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil {
+			return rawReply{}, err
+		}
+		if size < 0 {
+			return reply, nil
+		}
+		body := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, body); err != nil {
+			return rawReply{}, err
+		}
+		reply.raw += string(body)
+		return reply, nil
+	case '*', '%', '~', '>':
+		size, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil {
+			return rawReply{}, err
+		}
+		if reply.typ == '%' {
+			size *= 2
+		}
+		for i := 0; i < size; i++ {
+			elem, err := readRawReply(reader)
+			if err != nil {
+				return rawReply{}, err
+			}
+			reply.raw += elem.raw
+			reply.elems = append(reply.elems, elem)
+		}
+		return reply, nil
+	}
+	return rawReply{}, errors.New("unknown reply type " + strconv.Quote(line))
+}
+
+// bulkString 返回 bulk string 的内容
+func (r rawReply) bulkString() string {
+	header := strings.IndexByte(r.raw, '\n')
+	return strings.TrimSuffix(r.raw[header+1:], "\r\n")
+}
+
+func startConformanceServer(t testing.TB) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := MakeHandlerWithConfig(&config.ServerProperties{Dir: t.TempDir(), Databases: 16})
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tcp.ListenAndServe(listener, handler, closeChan)
+	}()
+	t.Cleanup(func() {
+		close(closeChan)
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+// conformanceCommand 是 COMMAND 返回的命令信息
+type conformanceCommand struct {
+	arity int
+	// 第一个 key 参数的位置，从命令名之后的 1 开始，0 表示没有 key
+	firstKey int
+}
+
+// conformanceCommands 从 COMMAND 读取命令名、arity 和第一个 key 的位置
+func conformanceCommands(t *testing.T, c *conformanceConn) map[string]conformanceCommand {
+	reply := c.do(t, "COMMAND")
+	commands := make(map[string]conformanceCommand, len(reply.elems))
+	for _, desc := range reply.elems {
+		if len(desc.elems) < 4 {
+			t.Fatalf("malformed COMMAND entry %q", desc.raw)
+		}
+		arity, err := strconv.Atoi(strings.TrimSuffix(desc.elems[1].raw[1:], "\r\n"))
+		if err != nil {
+			t.Fatalf("malformed arity in %q", desc.raw)
+		}
+		firstKey, err := strconv.Atoi(strings.TrimSuffix(desc.elems[3].raw[1:], "\r\n"))
+		if err != nil {
+			t.Fatalf("malformed first key in %q", desc.raw)
+		}
+		commands[desc.elems[0].bulkString()] = conformanceCommand{arity: arity, firstKey: firstKey}
+	}
+	return commands
+}
+
+// generatedArgs 没有给定参数的命令按最少参数个数填充
+func generatedArgs(arity int) []string {
+	n := arity - 1
+	if arity < 0 {
+		n = -arity - 1
+	}
+	args := make([]string, n)
+	for i := range args {
+		if i == 0 {
+			args[i] = "str"
+		} else {
+			args[i] = strconv.Itoa(i)
+		}
+	}
+	return args
+}
+
+// wrongArityArgs 返回参数个数错误的参数，最少参数为 0 的变长命令返回 false
+func wrongArityArgs(arity int) ([]string, bool) {
+	if arity > 0 {
+		return generatedArgs(arity + 1), true
+	}
+	if arity < -1 {
+		return generatedArgs(arity + 1), true
+	}
+	return nil, false
+}
+
+// edgeArgs 从合法参数派生出可能回复错误的参数：第一个 key 换成不存在的 key，变长命令末尾追加无法识别的选项
+func edgeArgs(cmd conformanceCommand, args []string) [][]string {
+	var edges [][]string
+	if cmd.firstKey > 0 && cmd.firstKey <= len(args) && args[cmd.firstKey-1] != "nokey" {
+		missing := append([]string(nil), args...)
+		missing[cmd.firstKey-1] = "nokey"
+		edges = append(edges, missing)
+	}
+	if cmd.arity < 0 {
+		edges = append(edges, append(append([]string(nil), args...), "BADSYNTAX"))
+	}
+	return edges
+}
+
+func checkErrorClass(t *testing.T, cmdLine []string, reply rawReply) {
+	t.Helper()
+	if reply.typ != '-' {
+		return
+	}
+	msg := strings.TrimSuffix(reply.raw[1:], "\r\n")
+	if msg == "Err unknown" {
+		t.Errorf("%v: server panicked", cmdLine)
+		return
+	}
+	class, _, _ := strings.Cut(msg, " ")
+	if class == "" || strings.ToUpper(class) != class {
+		t.Errorf("%v: error should start with an upper case class, got %q", cmdLine, msg)
+	}
+}
+
+// conformanceResult 是一组参数的检查方式
+type conformanceResult int
+
+const (
+	// 只检查错误类型
+	mayFail conformanceResult = iota
+	// 不能回复错误
+	mustSucceed
+	// 必须回复错误
+	mustFail
+)
+
+// runConformance 在两种协议下分别写入 fixture 后执行命令，检查回复并比较两种协议下的回复类型
+func runConformance(t *testing.T, conns map[int]*conformanceConn, cmdLine []string, expect conformanceResult) {
+	t.Helper()
+	kinds := make(map[int]string)
+	for _, resp := range []int{protocol.RESP2, protocol.RESP3} {
+		c := conns[resp]
+		for _, fixture := range conformanceFixture {
+			c.do(t, fixture...)
+		}
+		reply := c.do(t, cmdLine...)
+		checkErrorClass(t, cmdLine, reply)
+		switch {
+		case expect == mustSucceed && reply.typ == '-':
+			t.Errorf("%v: expected success in RESP%d, got %q", cmdLine, resp, reply.raw)
+		case expect == mustFail && reply.typ != '-':
+			t.Errorf("%v: expected an error in RESP%d, got %q", cmdLine, resp, reply.raw)
+		}
+		kinds[resp] = reply.kind()
+		if pong := c.do(t, "PING"); pong.raw != "+PONG\r\n" {
+			t.Fatalf("%v: connection broken in RESP%d, PING got %q", cmdLine, resp, pong.raw)
+		}
+	}
+	if kinds[protocol.RESP2] != kinds[protocol.RESP3] {
+		t.Errorf("%v: reply type differs, RESP2 %s, RESP3 %s", cmdLine, kinds[protocol.RESP2], kinds[protocol.RESP3])
+	}
+}
+
+func TestCommandConformance(t *testing.T) {
+	addr := startConformanceServer(t)
+	conns := map[int]*conformanceConn{
+		protocol.RESP2: dialConformance(t, addr, protocol.RESP2),
+		protocol.RESP3: dialConformance(t, addr, protocol.RESP3),
+	}
+	commands := conformanceCommands(t, conns[protocol.RESP2])
+	if len(commands) == 0 {
+		t.Fatal("COMMAND returned no commands")
+	}
+	for name, cmd := range commands {
+		if _, ok := conformanceSkip[name]; ok {
+			continue
+		}
+		// 按 arity 生成的参数不一定合法，只检查错误类型
+		argSets, ok := conformanceArgs[name]
+		expect := mustSucceed
+		if !ok {
+			argSets = [][]string{generatedArgs(cmd.arity)}
+			expect = mayFail
+		}
+		for _, args := range argSets {
+			runConformance(t, conns, append([]string{name}, args...), expect)
+			for _, edge := range edgeArgs(cmd, args) {
+				runConformance(t, conns, append([]string{name}, edge...), mayFail)
+			}
+		}
+		for _, args := range conformanceErrorArgs[name] {
+			runConformance(t, conns, append([]string{name}, args...), mustFail)
+		}
+
+		if args, ok := wrongArityArgs(cmd.arity); ok {
+			cmdLine := append([]string{name}, args...)
+			for _, resp := range []int{protocol.RESP2, protocol.RESP3} {
+				reply := conns[resp].do(t, cmdLine...)
+				checkErrorClass(t, cmdLine, reply)
+				if reply.typ != '-' || !strings.Contains(reply.raw, "wrong number of arguments") {
+					t.Errorf("%v: expected wrong number of arguments in RESP%d, got %q", cmdLine, resp, reply.raw)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package std
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+// TestPipelineBlocking 流水线中阻塞命令之前的回复在阻塞期间就能收到
+func TestPipelineBlocking(t *testing.T) {
+	addr := startConformanceServer(t)
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var pipeline []byte
+	for _, cmdLine := range [][]string{{"SET", "a", "1"}, {"GET", "a"}, {"BLPOP", "nokey", "0"}} {
+		pipeline = append(pipeline, makeCmd(cmdLine...)...)
+	}
+	if _, err := conn.Write(pipeline); err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	for _, expected := range []string{"+OK\r\n", "$1\r\n1\r\n"} {
+		reply, err := readRawReply(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reply.raw != expected {
+			t.Errorf("expected %q, got %q", expected, reply.raw)
+		}
+	}
+
+	other := dialConformance(t, addr, protocol.RESP2)
+	defer other.conn.Close()
+	other.do(t, "RPUSH", "nokey", "x")
+	if reply, err := readRawReply(reader); err != nil || len(reply.elems) != 2 || reply.elems[1].bulkString() != "x" {
+		t.Errorf("unexpected BLPOP reply %q, %v", reply.raw, err)
+	}
+}
+
+// BenchmarkPipeline 与 redis-benchmark -t set -P 16 相同，每次发送 16 个 SET 再读取全部回复
+func BenchmarkPipeline(b *testing.B) {
+	for _, depth := range []int{1, 16} {
+		b.Run("P"+strconv.Itoa(depth), func(b *testing.B) {
+			addr := startConformanceServer(b)
+			conn, err := net.Dial("tcp", addr)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer conn.Close()
+			var pipeline []byte
+			for i := 0; i < depth; i++ {
+				pipeline = append(pipeline, makeCmd("SET", "key:"+strconv.Itoa(i), "value")...)
+			}
+			reader := bufio.NewReader(conn)
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if _, err := conn.Write(pipeline); err != nil {
+					b.Fatal(err)
+				}
+				for j := 0; j < depth; j++ {
+					if _, err := readRawReply(reader); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.N*depth)/time.Since(start).Seconds(), "ops/s")
+		})
+	}
+}
+
+func makeCmd(args ...string) []byte {
+	cmdLine := make([][]byte, len(args))
+	for i, arg := range args {
+		cmdLine[i] = []byte(arg)
+	}
+	return protocol.MakeMultiBulkReply(cmdLine).ToBytes()
+}
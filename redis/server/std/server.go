@@ -8,6 +8,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/zhangming/go-redis/config"
 	"github.com/zhangming/go-redis/database"
 	idatabase "github.com/zhangming/go-redis/interfaces/database"
 	"github.com/zhangming/go-redis/interfaces/redis/parser"
@@ -20,94 +21,296 @@ var (
 	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
 )
 
+// 流水线中一次最多连续执行的请求数，也是解析协程预先解码的请求数
+const maxPipelineBatch = 128
+
 type Handler struct {
 	activeConn sync.Map // *client -> placeholder
 	db         idatabase.DB
 	closing    bool
+	// Close 时关闭，和这个 db 一起服务的其他端口(sidecar)以它作为关闭信号
+	closeChan chan struct{}
+	closeOnce sync.Once
+	// Attach 启动的服务，关闭 db 之前等待它们退出
+	attached sync.WaitGroup
+	// 每个连接解析请求的缓冲区大小，见 config.Tuning
+	readBufferSize int
+	// 连接的输出缓冲限制，见 config.ParseOutputBufferLimits
+	outputLimits *config.OutputBufferLimits
 }
 
 func MakeHandler() *Handler {
 	db := database.NewStandaloneServer()
 	return &Handler{
-		db: db,
+		db:             db,
+		closeChan:      make(chan struct{}),
+		readBufferSize: config.Properties.Tuning().ReadBufferSize,
+		outputLimits:   outputBufferLimits(config.Properties),
+	}
+}
+
+// MakeHandlerWithConfig creates a handler whose server uses its own config instead of the global one
+func MakeHandlerWithConfig(cfg *config.ServerProperties) *Handler {
+	db := database.NewStandaloneServerWithConfig(cfg)
+	return &Handler{
+		db:             db,
+		closeChan:      make(chan struct{}),
+		readBufferSize: cfg.Tuning().ReadBufferSize,
+		outputLimits:   outputBufferLimits(cfg),
+	}
+}
+
+// MakeHandlerWithDB creates a handler serving the given db, e.g. a cluster node
+func MakeHandlerWithDB(db idatabase.DB) *Handler {
+	return &Handler{
+		db:             db,
+		closeChan:      make(chan struct{}),
+		readBufferSize: config.Properties.Tuning().ReadBufferSize,
+		outputLimits:   outputBufferLimits(config.Properties),
+	}
+}
+
+// outputBufferLimits 配置在启动时已经检查过，不合法时使用默认值
+func outputBufferLimits(cfg *config.ServerProperties) *config.OutputBufferLimits {
+	limits, err := config.ParseOutputBufferLimits(cfg.ClientOutputBufferLimit)
+	if err != nil {
+		slog.Warn("invalid client-output-buffer-limit, using defaults", "error", err)
+		limits, _ = config.ParseOutputBufferLimits("")
+	}
+	return &limits
+}
+
+// ShutdownRequested is closed after the db executes SHUTDOWN, nil if the db does not support it
+func (h *Handler) ShutdownRequested() <-chan struct{} {
+	if notifier, ok := h.db.(idatabase.ShutdownNotifier); ok {
+		return notifier.ShutdownRequested()
 	}
+	return nil
+}
+
+// DB returns the database served by handler
+func (h *Handler) DB() idatabase.DB {
+	return h.db
+}
+
+// Attach runs serve in background with the close channel of handler.
+// Close waits for serve to return before closing db, so servers sharing the db stop first
+func (h *Handler) Attach(serve func(closeChan <-chan struct{})) {
+	h.attached.Add(1)
+	go func() {
+		defer h.attached.Done()
+		serve(h.closeChan)
+	}()
 }
+
 func Serve(addr string, handler *Handler) error {
 	return tcp.ListenAndServeWithSignal(&tcp.Config{
 		Address: addr,
 	}, handler)
 }
 
-func (h *Handler) closeClient(client *connection.Connection) {
-	_ = client.Close()
+// CloseClient lets the db clean up subscriptions and other states of client, then closes it,
+// the connection is reset and put back into the pool
+func (h *Handler) CloseClient(client *connection.Connection) {
 	h.db.AfterClientClose(client)
+	_ = client.Close()
 	h.activeConn.Delete(client)
 }
 
 func (h *Handler) Close() error {
 	slog.Info("handler shutting down...")
 	h.closing = true
+	h.closeOnce.Do(func() {
+		close(h.closeChan)
+	})
+	// 只停止读取，由处理协程调用 CloseClient 释放连接，这里直接 Close 会把连接两次放回对象池
 	h.activeConn.Range(func(key interface{}, val interface{}) bool {
 		client := key.(*connection.Connection)
-		_ = client.Close()
+		client.Kill()
 		return true
 	})
+	// sidecar 等共享 db 的服务可能还在执行命令，退出之后才能关闭 db
+	h.attached.Wait()
 	h.db.Close()
 	return nil
 }
 
+// watchClose 转发解析结果。Handle 在阻塞命令中等待时不会读取 channel，
+// 所以在这里看到连接关闭就立即通知 db 结束这个连接的阻塞命令
+func (h *Handler) watchClose(client *connection.Connection, ch <-chan *parser.Payload, done <-chan struct{}) <-chan *parser.Payload {
+	canceler, ok := h.db.(idatabase.BlockingCanceler)
+	if !ok {
+		return ch
+	}
+	out := make(chan *parser.Payload, maxPipelineBatch)
+	go func() {
+		defer close(out)
+		for payload := range ch {
+			if payload.Err != nil && (isClosedErr(payload.Err) || client.IsKilled()) {
+				canceler.CancelBlocking(client)
+			}
+			select {
+			case out <- payload:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// statsConn 统计连接读写的字节数，包括发布订阅等不经过 Handle 写入的消息
+type statsConn struct {
+	net.Conn
+	recorder idatabase.StatsRecorder
+}
+
+func (c *statsConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.recorder.RecordNetInput(n)
+	}
+	return n, err
+}
+
+func (c *statsConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.recorder.RecordNetOutput(n)
+	}
+	return n, err
+}
+
+func isClosedErr(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF ||
+		strings.Contains(err.Error(), "use of closed network connection")
+}
+
 func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 	slog.Info("connection accepted: " + conn.RemoteAddr().String())
-	slog.Info("ctx 内容 " + conn.RemoteAddr().String())
+	client, conn := h.accept(conn)
+	if client == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	ch := h.watchClose(client, parser.ParseStreamAhead(conn, h.readBufferSize, maxPipelineBatch), done)
+	batch := make([]*parser.Payload, 0, maxPipelineBatch)
+	for payload := range ch {
+		batch = drainPipeline(ch, append(batch[:0], payload))
+		if h.ExecBatch(client, batch) {
+			h.CloseClient(client)
+			return
+		}
+	}
+}
+
+// Accept prepares conn for serving and returns its client, nil if the connection is refused.
+// Servers driving connections without Handle read requests themselves and pass them to ExecBatch
+func (h *Handler) Accept(conn net.Conn) *connection.Connection {
+	client, _ := h.accept(conn)
+	return client
+}
+
+// accept 同时返回读取请求使用的连接，开启统计时读取的字节数计入 INFO stats
+func (h *Handler) accept(conn net.Conn) (*connection.Connection, net.Conn) {
 	if h.closing {
 		// closing handler refuse new connection
 		_ = conn.Close()
-		return
+		return nil, nil
+	}
+	if filter, ok := h.db.(idatabase.ConnectionFilter); ok {
+		if reply := filter.AcceptConnection(conn.RemoteAddr()); reply != nil {
+			_, _ = conn.Write(reply.ToBytes())
+			_ = conn.Close()
+			return nil, nil
+		}
+	}
+	if recorder, ok := h.db.(idatabase.StatsRecorder); ok {
+		conn = &statsConn{Conn: conn, recorder: recorder}
 	}
-
 	client := connection.NewConn(conn)
+	client.SetOutputBufferLimits(h.outputLimits)
 	h.activeConn.Store(client, struct{}{})
-	slog.Info("clent 内容 " + client.RemoteAddr())
+	if tracker, ok := h.db.(idatabase.ClientTracker); ok {
+		tracker.AfterClientConnect(client)
+	}
+	return client, conn
+}
 
-	ch := parser.ParseStream(conn)
-	for payload := range ch {
-		if payload.Err != nil {
-			if payload.Err == io.EOF ||
-				payload.Err == io.ErrUnexpectedEOF ||
-				strings.Contains(payload.Err.Error(), "use of closed network connection") {
-				// connection closed
-				slog.Error("进入EOF处理了")
-				h.closeClient(client)
-				slog.Info("connection closed: " + client.RemoteAddr())
-				return
-			}
-			// protocol err
-			slog.Error("进入其他错误")
-			errReply := protocol.MakeErrReply(payload.Err.Error())
-			_, err := client.Write(errReply.ToBytes())
-			if err != nil {
-				h.closeClient(client)
-				slog.Info("connection closed: " + client.RemoteAddr())
-				return
-			}
-			continue
+// ExecBatch executes pipelined requests and sends their replies together,
+// returns true if the connection should be closed by CloseClient
+func (h *Handler) ExecBatch(client *connection.Connection, batch []*parser.Payload) bool {
+	// 一批命令的回复都写入输出缓冲之后一起发送
+	client.Cork()
+	defer client.Uncork()
+	for _, p := range batch {
+		// 被 CLIENT KILL 之后不再执行已经解析的请求
+		if client.IsKilled() || h.handlePayload(client, p) {
+			return true
 		}
-		if payload.Data == nil {
-			slog.Error("empty payload")
-			continue
+	}
+	return client.IsKilled()
+}
+
+// CancelBlocking ends the blocking command of client, called when the peer is gone while the command waits
+func (h *Handler) CancelBlocking(client *connection.Connection) {
+	if canceler, ok := h.db.(idatabase.BlockingCanceler); ok {
+		canceler.CancelBlocking(client)
+	}
+}
+
+// drainPipeline 取出解析协程已经解码好的请求，不等待新的数据
+func drainPipeline(ch <-chan *parser.Payload, batch []*parser.Payload) []*parser.Payload {
+	for len(batch) < maxPipelineBatch {
+		select {
+		case payload, ok := <-ch:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, payload)
+		default:
+			return batch
 		}
-		r, ok := payload.Data.(*protocol.MultiBulkReply)
-		if !ok {
-			slog.Error("require multi bulk protocol")
-			continue
+	}
+	return batch
+}
+
+// handlePayload 执行一个请求并写入回复，返回 true 时关闭连接
+func (h *Handler) handlePayload(client *connection.Connection, payload *parser.Payload) bool {
+	if payload.Err != nil {
+		if isClosedErr(payload.Err) || client.IsKilled() {
+			// connection closed
+			slog.Info("connection closed: " + client.RemoteAddr())
+			return true
 		}
-		slog.Info("命令内容 " + string(r.ToBytes()))
-		result := h.db.Exec(client, r.Args)
-		slog.Info("result: ", result)
-		if result != nil {
-			_, _ = client.Write(result.ToBytes())
-		} else {
-			_, _ = client.Write(unknownErrReplyBytes)
+		// 协议错误之后无法确定下一个帧的位置，与 redis 相同回复错误后关闭连接
+		slog.Warn("protocol error from "+client.RemoteAddr(), "err", payload.Err)
+		_, _ = client.Write(protocol.MakeErrReply("ERR " + payload.Err.Error()).ToBytes())
+		return true
+	}
+	var r *protocol.MultiBulkReply
+	switch data := payload.Data.(type) {
+	case *protocol.MultiBulkReply:
+		r = data
+	case *protocol.EmptyMultiBulkReply, *protocol.NullMultiBulkReply:
+		// redis 忽略 *0 和 *-1
+		return false
+	default:
+		_, _ = client.Write(protocol.MakeErrReply("ERR Protocol error: expected '*', got '" + string(data.ToBytes()[:1]) + "'").ToBytes())
+		return true
+	}
+	result := h.db.Exec(client, r.Args)
+	if result != nil {
+		// 在写入连接时压缩，db 层和集群转发看到的仍然是原始的回复
+		if threshold := client.GetReplyCompression(); threshold > 0 {
+			result = protocol.MakeCompressedReply(result, threshold)
 		}
+		// 直接序列化到复用的缓冲区，避免 ToBytes 为每个回复分配内存
+		_, _ = protocol.WriteReply(client, result)
+	} else {
+		_, _ = client.Write(unknownErrReplyBytes)
 	}
+	return false
 }
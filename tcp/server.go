@@ -29,10 +29,20 @@ func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	closeChan := make(chan struct{})
 	sigCh := make(chan os.Signal)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
+	// 执行 SHUTDOWN 之后与收到信号一样关闭
+	var shutdownCh <-chan struct{}
+	if notifier, ok := handler.(tcp.ShutdownNotifier); ok {
+		shutdownCh = notifier.ShutdownRequested()
+	}
 	go func() {
-		sig := <-sigCh
-		switch sig {
-		case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+		select {
+		case sig := <-sigCh:
+			switch sig {
+			case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+				closeChan <- struct{}{}
+			}
+		case <-shutdownCh:
+			slog.Info("shutdown requested")
 			closeChan <- struct{}{}
 		}
 	}()
@@ -51,7 +61,10 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 	// listen signal
 	errCh := make(chan error, 1)
 	defer close(errCh)
+	// handler 关闭时保存数据，返回之前需要等待它完成，否则进程可能在保存完成之前退出
+	handlerClosed := make(chan struct{})
 	go func() {
+		defer close(handlerClosed)
 		select {
 		case <-closeChan:
 			slog.Info("get exit signal")
@@ -81,9 +94,9 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 		}
 		// handle
 		// logger.Info("accept link")
-		ClientCounter++
+		clients := atomic.AddInt32(&ClientCounter, 1)
 		waitDone.Add(1)
-		slog.Info(fmt.Sprintf("accept link, current client num: %d", ClientCounter))
+		slog.Info(fmt.Sprintf("accept link, current client num: %d", clients))
 		go func() {
 			defer func() {
 				waitDone.Done()
@@ -99,4 +112,5 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 		}()
 	}
 	waitDone.Wait()
+	<-handlerClosed
 }
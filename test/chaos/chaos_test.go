@@ -0,0 +1,235 @@
+package chaos
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+func skipShort(t *testing.T) {
+	if testing.Short() {
+		t.Skip("chaos tests start server processes, skipped in short mode")
+	}
+}
+
+// acked 记录服务端确认过的写入
+type acked struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func (a *acked) add(key, value string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.values[key] = value
+}
+
+// writeUntil 用 workers 个连接并发写入，直到 stop 关闭或者连接断开，返回写入失败的次数
+func writeUntil(t *testing.T, addr string, prefix string, workers int, value func(i int) string,
+	result *acked, stop chan struct{}) int {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	failures := 0
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			conn, err := Dial(addr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := prefix + ":" + strconv.Itoa(w) + ":" + strconv.Itoa(i)
+				val := value(i)
+				reply, err := conn.Do("SET", key, val)
+				if err != nil {
+					return
+				}
+				if protocol.IsOKReply(reply) {
+					result.add(key, val)
+				} else {
+					mu.Lock()
+					failures++
+					mu.Unlock()
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	return failures
+}
+
+// assertAcked 检查所有确认过的写入都能读到
+func assertAcked(t *testing.T, addr string, result *acked) {
+	conn := MustDial(t, addr)
+	result.mu.Lock()
+	defer result.mu.Unlock()
+	if len(result.values) == 0 {
+		t.Fatal("no write was acknowledged")
+	}
+	for key, expected := range result.values {
+		reply, err := conn.Do("GET", key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bulk, ok := reply.(*protocol.BulkReply)
+		if !ok || string(bulk.Arg) != expected {
+			t.Fatalf("acknowledged write %s=%s lost, got %s", key, expected, strings.TrimSpace(string(reply.ToBytes())))
+		}
+	}
+}
+
+// TestKillDuringWrites 写入过程中 SIGKILL，重启后 appendfsync always 确认过的写入都在
+func TestKillDuringWrites(t *testing.T) {
+	skipShort(t)
+	node := NewNode(t, "master", t.TempDir(),
+		"appendonly yes", "appendfilename appendonly.aof", "appendfsync always")
+	result := &acked{values: make(map[string]string)}
+	for round := 0; round < 3; round++ {
+		node.Start()
+		stop := make(chan struct{})
+		go func() {
+			time.Sleep(300 * time.Millisecond)
+			node.Kill()
+			close(stop)
+		}()
+		writeUntil(t, node.Addr(), "round"+strconv.Itoa(round), 4, strconv.Itoa, result, stop)
+		<-stop
+		node.Start()
+		assertAcked(t, node.Addr(), result)
+		node.Kill()
+	}
+}
+
+// TestDiskFull 数据目录写满后写命令返回错误，确认过的写入在重启后仍然完整
+func TestDiskFull(t *testing.T) {
+	skipShort(t)
+	dir := QuotaDir(t, 128*1024)
+	node := NewNode(t, "master", dir,
+		"appendonly yes", "appendfilename appendonly.aof", "appendfsync always")
+	node.Start()
+	result := &acked{values: make(map[string]string)}
+	value := func(i int) string {
+		return strings.Repeat(strconv.Itoa(i%10), 1000)
+	}
+	conn := MustDial(t, node.Addr())
+	misconf := ""
+	for i := 0; i < 1000 && misconf == ""; i++ {
+		key := "key:" + strconv.Itoa(i)
+		reply, err := conn.Do("SET", key, value(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if protocol.IsOKReply(reply) {
+			result.add(key, value(i))
+		} else if protocol.IsErrorReply(reply) {
+			misconf = string(reply.ToBytes())
+		}
+	}
+	if !strings.HasPrefix(misconf, "-MISCONF") {
+		t.Fatalf("expected MISCONF after disk is full, got %q", misconf)
+	}
+	// 写满之后继续写入也不能被确认
+	stop := make(chan struct{})
+	time.AfterFunc(200*time.Millisecond, func() { close(stop) })
+	writeUntil(t, node.Addr(), "full", 2, value, result, stop)
+
+	node.Kill()
+	node.Start()
+	assertAcked(t, node.Addr(), result)
+}
+
+func replyString(t *testing.T, conn *Conn, args ...string) string {
+	reply, err := conn.Do(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(reply.ToBytes())
+}
+
+// dump 返回 prefix 开头的所有 key 和值
+func dump(t *testing.T, conn *Conn, prefix string) map[string]string {
+	reply, err := conn.Do("KEYS", prefix+"*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make(map[string]string)
+	keys, ok := reply.(*protocol.MultiRawReply)
+	if !ok {
+		t.Fatalf("unexpected KEYS reply %q", reply.ToBytes())
+	}
+	for _, key := range keys.Replies {
+		name := string(key.(*protocol.BulkReply).Arg)
+		var value redis.Reply
+		if value, err = conn.Do("GET", name); err != nil {
+			t.Fatal(err)
+		}
+		result[name] = string(value.ToBytes())
+	}
+	return result
+}
+
+func sameData(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if b[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// TestReplicaConvergesAfterPartition 主从之间网络分区期间主节点继续写入，恢复后从节点与主节点一致
+func TestReplicaConvergesAfterPartition(t *testing.T) {
+	skipShort(t)
+	master := NewNode(t, "master", t.TempDir(),
+		"appendonly yes", "appendfilename appendonly.aof", "appendfsync always")
+	master.Start()
+	proxy := NewProxy(t, master.Addr())
+	host, port := proxy.Host()
+	replica := NewNode(t, "replica", t.TempDir(), "replicaof "+host+" "+port)
+	replica.Start()
+
+	masterConn := MustDial(t, master.Addr())
+	for i := 0; i < 100; i++ {
+		replyString(t, masterConn, "SET", "key:"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	replicaConn := MustDial(t, replica.Addr())
+	WaitFor(t, 10*time.Second, "replica did not sync before partition", func() bool {
+		return sameData(dump(t, masterConn, "key:"), dump(t, replicaConn, "key:"))
+	})
+
+	proxy.Partition()
+	for i := 50; i < 150; i++ {
+		replyString(t, masterConn, "SET", "key:"+strconv.Itoa(i), "v"+strconv.Itoa(i))
+	}
+	for i := 0; i < 10; i++ {
+		replyString(t, masterConn, "DEL", "key:"+strconv.Itoa(i))
+	}
+	time.Sleep(200 * time.Millisecond)
+	if sameData(dump(t, masterConn, "key:"), dump(t, replicaConn, "key:")) {
+		t.Fatal("replica received writes during partition")
+	}
+	if got := replyString(t, replicaConn, "SET", "x", "1"); !strings.HasPrefix(got, "-READONLY") {
+		t.Fatalf("replica accepted write during partition: %q", got)
+	}
+
+	proxy.Heal()
+	WaitFor(t, 10*time.Second, "replica did not converge after partition healed", func() bool {
+		return sameData(dump(t, masterConn, "key:"), dump(t, replicaConn, "key:"))
+	})
+}
@@ -0,0 +1,414 @@
+// Package chaos 端到端的故障注入测试
+// 以独立进程启动 master/replica，注入 SIGKILL、磁盘写满、网络分区等故障，
+// 然后检查持久化和复制的不变量，例如 appendfsync always 时确认过的写入不会丢失
+package chaos
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/zhangming/go-redis/interfaces/redis"
+	"github.com/zhangming/go-redis/lib/utils"
+	"github.com/zhangming/go-redis/redis/protocol"
+)
+
+const (
+	startTimeout = 10 * time.Second
+	ioTimeout    = 3 * time.Second
+)
+
+var (
+	buildOnce sync.Once
+	binPath   string
+	buildErr  error
+)
+
+// serverBinary 编译一次服务端，所有测试共用
+func serverBinary(t testing.TB) string {
+	buildOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "go-redis-chaos")
+		if err != nil {
+			buildErr = err
+			return
+		}
+		binPath = filepath.Join(dir, "go-redis")
+		cmd := exec.Command("go", "build", "-o", binPath, "github.com/zhangming/go-redis")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			buildErr = errors.New("build server failed: " + err.Error() + "\n" + string(out))
+		}
+	})
+	if buildErr != nil {
+		t.Fatal(buildErr)
+	}
+	return binPath
+}
+
+// freePort 返回一个当前空闲的端口
+func freePort(t testing.TB) int {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// Node 一个服务端进程，Kill 之后可以用同样的配置和数据目录重新 Start
+type Node struct {
+	t    testing.TB
+	Name string
+	Dir  string
+	Port int
+	// 额外的配置，每项是一行 "key value"
+	Conf []string
+	// 配置文件和进程输出所在的目录
+	logDir string
+	cmd    *exec.Cmd
+	done   chan struct{}
+}
+
+// NewNode creates a node whose data is stored in dir, extra config lines are appended to the defaults
+func NewNode(t testing.TB, name string, dir string, conf ...string) *Node {
+	node := &Node{
+		t:      t,
+		Name:   name,
+		Dir:    dir,
+		Port:   freePort(t),
+		Conf:   conf,
+		logDir: t.TempDir(),
+	}
+	t.Cleanup(node.Kill)
+	return node
+}
+
+// Addr returns host:port of the node
+func (node *Node) Addr() string {
+	return "127.0.0.1:" + strconv.Itoa(node.Port)
+}
+
+func (node *Node) writeConfig() string {
+	lines := []string{
+		"bind 127.0.0.1",
+		"port " + strconv.Itoa(node.Port),
+		"dir " + node.Dir,
+		"databases 16",
+	}
+	lines = append(lines, node.Conf...)
+	path := filepath.Join(node.logDir, node.Name+".conf")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		node.t.Fatal(err)
+	}
+	return path
+}
+
+func (node *Node) logPath() string {
+	return filepath.Join(node.logDir, node.Name+".log")
+}
+
+// Start 启动进程并等待端口可以连接
+func (node *Node) Start() {
+	bin := serverBinary(node.t)
+	logFile, err := os.OpenFile(node.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		node.t.Fatal(err)
+	}
+	cmd := exec.Command(bin)
+	cmd.Dir = node.Dir
+	cmd.Env = append(os.Environ(), "CONFIG="+node.writeConfig())
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		node.t.Fatal(err)
+	}
+	node.cmd = cmd
+	node.done = make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		_ = logFile.Close()
+		close(node.done)
+	}()
+	deadline := time.Now().Add(startTimeout)
+	for {
+		conn, err := net.DialTimeout("tcp", node.Addr(), ioTimeout)
+		if err == nil {
+			_ = conn.Close()
+			return
+		}
+		select {
+		case <-node.done:
+			log, _ := os.ReadFile(node.logPath())
+			node.t.Fatalf("%s exited during start up:\n%s", node.Name, log)
+		default:
+		}
+		if time.Now().After(deadline) {
+			node.t.Fatalf("%s did not start listening", node.Name)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// Kill 发送 SIGKILL 并等待进程退出，模拟宕机
+func (node *Node) Kill() {
+	node.signal(syscall.SIGKILL)
+}
+
+// Stop 发送 SIGTERM 并等待进程正常退出
+func (node *Node) Stop() {
+	node.signal(syscall.SIGTERM)
+}
+
+func (node *Node) signal(sig syscall.Signal) {
+	if node.cmd == nil {
+		return
+	}
+	_ = node.cmd.Process.Signal(sig)
+	<-node.done
+	node.cmd = nil
+}
+
+// Conn 同步的 redis 连接，每次发送一条命令并等待回复
+type Conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Dial connects to addr
+func Dial(addr string) (*Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, ioTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// MustDial connects to node and closes the connection when test ends
+func MustDial(t testing.TB, addr string) *Conn {
+	conn, err := Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+// Close closes the connection
+func (c *Conn) Close() {
+	_ = c.conn.Close()
+}
+
+// Do 发送命令并读取一个回复，网络错误时返回 error，服务端的错误回复作为 *protocol.StandardErrReply 返回
+func (c *Conn) Do(args ...string) (redis.Reply, error) {
+	_ = c.conn.SetDeadline(time.Now().Add(ioTimeout))
+	if _, err := c.conn.Write(protocol.MakeMultiBulkReply(utils.ToCmdLine(args...)).ToBytes()); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *Conn) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+func (c *Conn) readReply() (redis.Reply, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return protocol.MakeStatusReply(line[1:]), nil
+	case '-':
+		return protocol.MakeErrReply(line[1:]), nil
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return protocol.MakeIntReply(n), nil
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 {
+			return protocol.MakeNullBulkReply(), nil
+		}
+		body := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, body); err != nil {
+			return nil, err
+		}
+		return protocol.MakeBulkReply(body[:size]), nil
+	case '*':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		replies := make([]redis.Reply, 0, max(size, 0))
+		for i := 0; i < size; i++ {
+			reply, err := c.readReply()
+			if err != nil {
+				return nil, err
+			}
+			replies = append(replies, reply)
+		}
+		return protocol.MakeMultiRawReply(replies), nil
+	}
+	return nil, errors.New("unexpected reply: " + line)
+}
+
+// Proxy 转发 TCP 连接，Partition 之后断开所有连接并拒绝新连接，模拟网络分区
+type Proxy struct {
+	target      string
+	listener    net.Listener
+	mu          sync.Mutex
+	partitioned bool
+	conns       map[net.Conn]struct{}
+}
+
+// NewProxy creates a proxy forwarding to target, it is closed when test ends
+func NewProxy(t testing.TB, target string) *Proxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := &Proxy{
+		target:   target,
+		listener: listener,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	t.Cleanup(proxy.Close)
+	go proxy.serve()
+	return proxy
+}
+
+// Addr returns host:port of the proxy
+func (proxy *Proxy) Addr() string {
+	return proxy.listener.Addr().String()
+}
+
+// Host returns host and port of the proxy separately
+func (proxy *Proxy) Host() (string, string) {
+	host, port, _ := net.SplitHostPort(proxy.Addr())
+	return host, port
+}
+
+func (proxy *Proxy) serve() {
+	for {
+		conn, err := proxy.listener.Accept()
+		if err != nil {
+			return
+		}
+		go proxy.forward(conn)
+	}
+}
+
+// track 记录连接，分区期间返回 false
+func (proxy *Proxy) track(conns ...net.Conn) bool {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	if proxy.partitioned {
+		return false
+	}
+	for _, conn := range conns {
+		proxy.conns[conn] = struct{}{}
+	}
+	return true
+}
+
+func (proxy *Proxy) forward(client net.Conn) {
+	if !proxy.track(client) {
+		_ = client.Close()
+		return
+	}
+	server, err := net.DialTimeout("tcp", proxy.target, ioTimeout)
+	if err != nil {
+		proxy.closeConns(client)
+		return
+	}
+	if !proxy.track(server) {
+		proxy.closeConns(client, server)
+		return
+	}
+	go func() {
+		_, _ = io.Copy(server, client)
+		proxy.closeConns(client, server)
+	}()
+	_, _ = io.Copy(client, server)
+	proxy.closeConns(client, server)
+}
+
+func (proxy *Proxy) closeConns(conns ...net.Conn) {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+		delete(proxy.conns, conn)
+	}
+}
+
+// Partition 断开现有连接，之后的连接建立后立即关闭
+func (proxy *Proxy) Partition() {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	proxy.partitioned = true
+	for conn := range proxy.conns {
+		_ = conn.Close()
+	}
+	proxy.conns = make(map[net.Conn]struct{})
+}
+
+// Heal 恢复转发
+func (proxy *Proxy) Heal() {
+	proxy.mu.Lock()
+	defer proxy.mu.Unlock()
+	proxy.partitioned = false
+}
+
+// Close stops the proxy and closes all connections
+func (proxy *Proxy) Close() {
+	_ = proxy.listener.Close()
+	proxy.Partition()
+}
+
+// QuotaDir 挂载一个容量为 size 字节的 tmpfs 作为数据目录，写满后 write 返回 ENOSPC。
+// 需要 root 权限，无法挂载时跳过测试
+func QuotaDir(t testing.TB, size int) string {
+	dir := t.TempDir()
+	cmd := exec.Command("mount", "-t", "tmpfs", "-o", "size="+strconv.Itoa(size), "tmpfs", dir)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Skip("cannot mount tmpfs for quota dir: " + strings.TrimSpace(string(out)))
+	}
+	t.Cleanup(func() {
+		_ = exec.Command("umount", "-l", dir).Run()
+	})
+	return dir
+}
+
+// WaitFor 每隔 50ms 检查一次 cond，超时后测试失败
+func WaitFor(t testing.TB, timeout time.Duration, msg string, cond func() bool) {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal(msg)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}